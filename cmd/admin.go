@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+)
+
+// AdminSocketFileName is the unix socket in the data directory that a running node listens on
+// for admin commands such as `backup`. Only local users with access to the data directory can
+// connect to it.
+const AdminSocketFileName = "admin.sock"
+
+// AdminRequestReadTimeout is how long a client has to send its request after connecting to the
+// admin socket.
+const AdminRequestReadTimeout = 10 * time.Second
+
+const (
+	AdminCommandBackup       = "backup"
+	AdminCommandListPeerBans = "list-peer-bans"
+	AdminCommandBanPeer      = "ban-peer"
+	AdminCommandUnbanPeer    = "unban-peer"
+	AdminCommandGetBandwidth = "get-bandwidth"
+
+	AdminCommandVerifyTxnInclusion = "verify-txn-inclusion"
+)
+
+type AdminRequest struct {
+	Command     string
+	ArchivePath string
+
+	// IP, BanDuration, and Reason are used by the peer ban commands. A zero BanDuration uses
+	// the node's --peer-ban-duration-minutes.
+	IP          string
+	BanDuration time.Duration
+	Reason      string
+
+	// TxnHash and BlockHash are the hex hashes used by verify-txn-inclusion.
+	TxnHash   string
+	BlockHash string
+}
+
+type AdminResponse struct {
+	Error     string
+	Manifest  *lib.NodeBackupManifest
+	Bans      []*lib.PeerBan
+	Bandwidth *lib.BandwidthReport
+	Inclusion *lib.TxnInclusion
+}
+
+func GetAdminSocketPath(dataDirectory string) string {
+	return filepath.Join(dataDirectory, AdminSocketFileName)
+}
+
+// startAdminServer starts listening on the admin socket. Each connection is handled in its own
+// goroutine, so that a long backup doesn't hold up the other commands.
+func (node *Node) startAdminServer() {
+	socketPath := GetAdminSocketPath(node.Config.DataDirectory)
+	// A node that wasn't shut down cleanly leaves the socket file behind.
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		glog.Errorf("Node.startAdminServer: Problem listening on admin socket (%v): %v", socketPath, err)
+		return
+	}
+	if err = os.Chmod(socketPath, 0600); err != nil {
+		glog.Errorf("Node.startAdminServer: Problem setting permissions on admin socket: %v", err)
+	}
+	node.adminListener = listener
+	glog.Infof("Node.startAdminServer: Listening for admin commands on (%v)", socketPath)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				// The listener was closed by Stop.
+				return
+			}
+			go node.handleAdminConn(conn)
+		}
+	}()
+}
+
+func (node *Node) stopAdminServer() {
+	if node.adminListener == nil {
+		return
+	}
+	node.adminListener.Close()
+	node.adminListener = nil
+}
+
+func (node *Node) handleAdminConn(conn net.Conn) {
+	defer conn.Close()
+
+	request := &AdminRequest{}
+	response := &AdminResponse{}
+	// Don't let an idle client hold on to the connection. Commands can take as long as they need,
+	// the client sets its own deadline for the response.
+	conn.SetReadDeadline(time.Now().Add(AdminRequestReadTimeout))
+	if err := json.NewDecoder(conn).Decode(request); err != nil {
+		response.Error = fmt.Sprintf("Problem decoding request: %v", err)
+	} else {
+		conn.SetReadDeadline(time.Time{})
+		switch request.Command {
+		case AdminCommandBackup:
+			if !atomic.CompareAndSwapInt32(&node.adminBackupRunning, 0, 1) {
+				response.Error = "A backup is already running"
+				break
+			}
+			glog.Infof("Node.handleAdminConn: Starting backup to (%v)", request.ArchivePath)
+			manifest, err := node.Server.BackupToArchive(request.ArchivePath)
+			atomic.StoreInt32(&node.adminBackupRunning, 0)
+			if err != nil {
+				glog.Errorf("Node.handleAdminConn: Backup failed: %v", err)
+				response.Error = err.Error()
+			}
+			response.Manifest = manifest
+		case AdminCommandListPeerBans, AdminCommandBanPeer, AdminCommandUnbanPeer:
+			if err := node.handlePeerBanRequest(request, response); err != nil {
+				response.Error = err.Error()
+			}
+		case AdminCommandGetBandwidth:
+			response.Bandwidth = node.Server.GetConnectionManager().GetBandwidthReport()
+		case AdminCommandVerifyTxnInclusion:
+			inclusion, err := node.handleVerifyTxnInclusionRequest(request)
+			if err != nil {
+				response.Error = err.Error()
+			}
+			response.Inclusion = inclusion
+		default:
+			response.Error = fmt.Sprintf("Unknown command (%v)", request.Command)
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		glog.Errorf("Node.handleAdminConn: Problem writing response: %v", err)
+	}
+}
+
+// handlePeerBanRequest adds or removes a ban if requested, and replies with the current list of bans.
+func (node *Node) handlePeerBanRequest(request *AdminRequest, response *AdminResponse) error {
+	cmgr := node.Server.GetConnectionManager()
+	if cmgr.BanManager == nil {
+		return fmt.Errorf("Peer bans are disabled")
+	}
+
+	if request.Command != AdminCommandListPeerBans {
+		ip := net.ParseIP(request.IP)
+		if ip == nil {
+			return fmt.Errorf("Invalid IP (%v)", request.IP)
+		}
+		if request.Command == AdminCommandBanPeer {
+			reason := request.Reason
+			if reason == "" {
+				reason = "Banned through the admin interface"
+			}
+			if _, err := cmgr.BanIP(ip, request.BanDuration, reason); err != nil {
+				return err
+			}
+		} else {
+			wasBanned, err := cmgr.BanManager.Unban(ip)
+			if err != nil {
+				return err
+			}
+			if !wasBanned {
+				return fmt.Errorf("IP (%v) is not banned", ip)
+			}
+		}
+	}
+	response.Bans = cmgr.BanManager.GetBans()
+	return nil
+}
+
+// SendAdminRequest sends a request to the node running in dataDirectory and waits for the
+// response.
+func SendAdminRequest(dataDirectory string, request *AdminRequest, timeout time.Duration) (*AdminResponse, error) {
+	conn, err := net.Dial("unix", GetAdminSocketPath(dataDirectory))
+	if err != nil {
+		return nil, fmt.Errorf("SendAdminRequest: Problem connecting to node, make sure it's running: %v", err)
+	}
+	defer conn.Close()
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	if err = json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("SendAdminRequest: Problem sending request: %v", err)
+	}
+	response := &AdminResponse{}
+	if err = json.NewDecoder(conn).Decode(response); err != nil {
+		return nil, fmt.Errorf("SendAdminRequest: Problem reading response: %v", err)
+	}
+	return response, nil
+}
+
+// handleVerifyTxnInclusionRequest checks that a transaction is in a block of the node's best header chain.
+func (node *Node) handleVerifyTxnInclusionRequest(request *AdminRequest) (*lib.TxnInclusion, error) {
+	txnHash, err := _decodeBlockHash(request.TxnHash)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid txn hash: %v", err)
+	}
+	blockHash, err := _decodeBlockHash(request.BlockHash)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid block hash: %v", err)
+	}
+	return node.Server.VerifyTxnInclusion(txnHash, blockHash)
+}
+
+func _decodeBlockHash(hashHex string) (*lib.BlockHash, error) {
+	hashBytes, err := hex.DecodeString(hashHex)
+	if err != nil {
+		return nil, err
+	}
+	if len(hashBytes) != lib.HashSizeBytes {
+		return nil, fmt.Errorf("Hash (%v) should be %v bytes", hashHex, lib.HashSizeBytes)
+	}
+	return lib.NewBlockHash(hashBytes), nil
+}
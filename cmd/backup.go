@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Write a consistent backup of a running node's databases",
+	Long: `Asks the node running in the data directory to write a backup of its chain, snapshot, txindex,
+and mempool databases into a single archive. The node briefly pauses block processing while it
+opens a consistent view of all of them, and keeps running while the archive is written. The
+archive contains a manifest with the tip hash and the snapshot status at the time of the backup.`,
+	Run: Backup,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore the node's databases from a backup archive",
+	Long: `Restores the databases in a backup archive written by the backup command into the data
+directory, and validates the restored databases against the archive's manifest. The node must not
+be running and the databases being restored must not exist yet.`,
+	Run: Restore,
+}
+
+func init() {
+	backupCmd.Flags().String("data-dir", "", "The location where all of the protocol-related data like blocks is stored.")
+	backupCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	backupCmd.Flags().Bool("regtest", false, "Use the regtest params.")
+	backupCmd.Flags().String("output", "", "The file to write the backup archive to. It must not exist yet.")
+	backupCmd.Flags().Duration("timeout", time.Hour, "How long to wait for the node to finish the backup.")
+	rootCmd.AddCommand(backupCmd)
+
+	restoreCmd.Flags().String("data-dir", "", "The location where all of the protocol-related data like blocks is stored.")
+	restoreCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	restoreCmd.Flags().Bool("regtest", false, "Use the regtest params.")
+	restoreCmd.Flags().String("mempool-dump-dir", "", "If set, the mempool in the backup is restored to this directory.")
+	restoreCmd.Flags().String("archive", "", "The backup archive to restore.")
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func _getBackupParamsAndDataDir(cmd *cobra.Command) (*lib.DeSoParams, string) {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	regtest, _ := cmd.Flags().GetBool("regtest")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+
+	params := LoadParams(testnet, regtest)
+	lib.GlobalDeSoParams = *params
+
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+	return params, filepath.Join(dataDir, lib.DBVersionString)
+}
+
+func Backup(cmd *cobra.Command, args []string) {
+	_, dataDir := _getBackupParamsAndDataDir(cmd)
+	output, _ := cmd.Flags().GetString("output")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+	if output == "" {
+		glog.Fatalf("Backup: Please provide the --output file")
+	}
+	// The node writes the archive, so it needs the full path.
+	archivePath, err := filepath.Abs(output)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	response, err := SendAdminRequest(dataDir, &AdminRequest{
+		Command:     AdminCommandBackup,
+		ArchivePath: archivePath,
+	}, timeout)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if response.Error != "" {
+		glog.Fatalf("Backup: Node failed to write backup: %v", response.Error)
+	}
+	manifest := response.Manifest
+	fmt.Printf("Wrote backup of tip (%v) at height (%v) to (%v)\n", manifest.TipHash, manifest.TipHeight,
+		archivePath)
+	for _, database := range manifest.Databases {
+		fmt.Printf("  %v: %v records, %v bytes\n", database.Name, database.NumRecords, database.SizeBytes)
+	}
+}
+
+func Restore(cmd *cobra.Command, args []string) {
+	params, dataDir := _getBackupParamsAndDataDir(cmd)
+	mempoolDumpDir, _ := cmd.Flags().GetString("mempool-dump-dir")
+	archivePath, _ := cmd.Flags().GetString("archive")
+	if archivePath == "" {
+		glog.Fatalf("Restore: Please provide the --archive to restore")
+	}
+
+	manifest, err := lib.RestoreFromArchive(archivePath, dataDir, mempoolDumpDir, params)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Printf("Restored backup of tip (%v) at height (%v) taken at %v to (%v)\n", manifest.TipHash,
+		manifest.TipHeight, manifest.CreatedAt, dataDir)
+}
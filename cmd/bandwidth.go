@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+var bandwidthCmd = &cobra.Command{
+	Use:   "bandwidth",
+	Short: "Show the traffic of a running node with its peers",
+	Long: `Shows the bytes a running node sent to and received from its peers by message type, for the
+node as a whole and for each connected peer, and how much of its --max-upload-bytes-per-day it used.`,
+	Run: ShowBandwidth,
+}
+
+func init() {
+	bandwidthCmd.Flags().String("data-dir", "", "The location where all of the protocol-related data like blocks is stored.")
+	bandwidthCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	bandwidthCmd.Flags().Bool("by-msg-type", false, "Break down the traffic of each peer by message type.")
+	rootCmd.AddCommand(bandwidthCmd)
+}
+
+func ShowBandwidth(cmd *cobra.Command, args []string) {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	byMsgType, _ := cmd.Flags().GetBool("by-msg-type")
+
+	params := &lib.DeSoMainnetParams
+	if testnet {
+		params = &lib.DeSoTestnetParams
+	}
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+
+	response, err := SendAdminRequest(filepath.Join(dataDir, lib.DBVersionString), &AdminRequest{
+		Command: AdminCommandGetBandwidth,
+	}, time.Minute)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if response.Error != "" {
+		glog.Fatalf("Node failed to %v: %v", AdminCommandGetBandwidth, response.Error)
+	}
+	report := response.Bandwidth
+
+	upload := report.Upload
+	if upload.MaxUploadBytesPerDay > 0 {
+		fmt.Printf("Sent %d of %d bytes in the cycle ending at %v", upload.BytesSentInCycle,
+			upload.MaxUploadBytesPerDay, upload.CycleEnd.Format(time.RFC3339))
+		if upload.DailyUploadCapReached {
+			fmt.Printf(", not serving historical data")
+		}
+		fmt.Println()
+	}
+	if upload.MaxUploadBytesPerSecond > 0 {
+		fmt.Printf("Serving historical data at up to %d bytes per second\n", upload.MaxUploadBytesPerSecond)
+	}
+
+	fmt.Printf("Total: %s\n", _formatTraffic(report.Total))
+	_printTrafficByMsgType(report.ByMsgType)
+	for _, peer := range report.Peers {
+		direction := "inbound"
+		if peer.IsOutbound {
+			direction = "outbound"
+		}
+		fmt.Printf("Peer %d %v (%s): %s\n", peer.PeerID, peer.Addr, direction, _formatTraffic(peer.Total))
+		if byMsgType {
+			_printTrafficByMsgType(peer.ByMsgType)
+		}
+	}
+}
+
+func _formatTraffic(traffic lib.MsgTypeTraffic) string {
+	return fmt.Sprintf("sent %d bytes in %d messages, received %d bytes in %d messages",
+		traffic.BytesSent, traffic.MessagesSent, traffic.BytesReceived, traffic.MessagesReceived)
+}
+
+func _printTrafficByMsgType(trafficByMsgType map[string]lib.MsgTypeTraffic) {
+	var msgTypes []string
+	for msgType := range trafficByMsgType {
+		msgTypes = append(msgTypes, msgType)
+	}
+	sort.Strings(msgTypes)
+	for _, msgType := range msgTypes {
+		fmt.Printf("  %v: %s\n", msgType, _formatTraffic(trafficByMsgType[msgType]))
+	}
+}
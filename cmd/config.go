@@ -33,6 +33,28 @@ type Config struct {
 	MaxInboundPeers   uint32
 	OneInboundPerIp   bool
 
+	// Peer bans
+	PeerBanThreshold       uint32
+	PeerBanDurationMinutes uint64
+
+	// Peer transport
+	EncryptPeerConnections bool
+	PinnedPeerKeys         []string
+	StateChecksumPeerKeys  []string
+
+	// Peer message limits
+	PeerMessageRateLimits []string
+
+	// Proxy and Tor
+	Proxy              string
+	TorOnionService    bool
+	TorControlAddr     string
+	TorControlPassword string
+
+	// Upload caps
+	MaxUploadBytesPerDay    uint64
+	MaxUploadBytesPerSecond uint64
+
 	// Snapshot
 	HyperSync                 bool
 	SyncType                  lib.NodeSyncType
@@ -40,6 +62,32 @@ type Config struct {
 	SnapshotBlockHeightPeriod uint64
 	DisableEncoderMigrations  bool
 
+	// DB schema migrations
+	DBMigrationBackupPath string
+
+	// State scrubber
+	StateScrubberIntervalMinutes     uint64
+	StateScrubberMaxEntriesPerSecond uint64
+
+	// Snapshot serving
+	DisableSnapshotServing               bool
+	SnapshotServingMaxConcurrentRequests uint64
+	SnapshotServingPeerBytesPerSecond    uint64
+	SnapshotServingGlobalBytesPerSecond  uint64
+
+	// DB maintenance
+	DBMaintenanceIntervalMinutes uint64
+	DBMaintenanceIdleSeconds     uint64
+	ValueLogGCDiscardRatio       float64
+	DBMaintenanceFlatten         bool
+
+	// Trusted checkpoint
+	TrustedCheckpointBlockHash        string
+	TrustedCheckpointHeight           uint32
+	TrustedCheckpointDifficultyTarget string
+	TrustedCheckpointTimestamp        uint64
+	TrustedCheckpointStateChecksum    string
+
 	// Mining
 	MinerPublicKeys  []string
 	NumMiningThreads uint64
@@ -100,6 +148,24 @@ func LoadConfig() *Config {
 	config.SnapshotBlockHeightPeriod = viper.GetUint64("snapshot-block-height-period")
 	config.DisableEncoderMigrations = viper.GetBool("disable-encoder-migrations")
 
+	// DB schema migrations
+	config.DBMigrationBackupPath = viper.GetString("db-migration-backup-path")
+	config.StateScrubberIntervalMinutes = viper.GetUint64("state-scrubber-interval-minutes")
+	config.StateScrubberMaxEntriesPerSecond = viper.GetUint64("state-scrubber-max-entries-per-second")
+	config.DisableSnapshotServing = viper.GetBool("disable-snapshot-serving")
+	config.SnapshotServingMaxConcurrentRequests = viper.GetUint64("snapshot-serving-max-concurrent-requests")
+	config.SnapshotServingPeerBytesPerSecond = viper.GetUint64("snapshot-serving-peer-bytes-per-second")
+	config.SnapshotServingGlobalBytesPerSecond = viper.GetUint64("snapshot-serving-global-bytes-per-second")
+	config.DBMaintenanceIntervalMinutes = viper.GetUint64("db-maintenance-interval-minutes")
+	config.DBMaintenanceIdleSeconds = viper.GetUint64("db-maintenance-idle-seconds")
+	config.ValueLogGCDiscardRatio = viper.GetFloat64("value-log-gc-discard-ratio")
+	config.DBMaintenanceFlatten = viper.GetBool("db-maintenance-flatten")
+	config.TrustedCheckpointBlockHash = viper.GetString("trusted-checkpoint-block-hash")
+	config.TrustedCheckpointHeight = viper.GetUint32("trusted-checkpoint-height")
+	config.TrustedCheckpointDifficultyTarget = viper.GetString("trusted-checkpoint-difficulty-target")
+	config.TrustedCheckpointTimestamp = viper.GetUint64("trusted-checkpoint-timestamp")
+	config.TrustedCheckpointStateChecksum = viper.GetString("trusted-checkpoint-state-checksum")
+
 	// Peers
 	config.ConnectIPs = viper.GetStringSlice("connect-ips")
 	config.AddIPs = viper.GetStringSlice("add-ips")
@@ -114,6 +180,19 @@ func LoadConfig() *Config {
 	config.IgnoreInboundInvs = viper.GetBool("ignore-inbound-invs")
 	config.MaxInboundPeers = viper.GetUint32("max-inbound-peers")
 	config.OneInboundPerIp = viper.GetBool("one-inbound-per-ip")
+	config.PeerBanThreshold = viper.GetUint32("peer-ban-threshold")
+	config.PeerBanDurationMinutes = viper.GetUint64("peer-ban-duration-minutes")
+	config.PinnedPeerKeys = viper.GetStringSlice("pinned-peer-keys")
+	config.StateChecksumPeerKeys = viper.GetStringSlice("state-checksum-peer-keys")
+	config.EncryptPeerConnections = viper.GetBool("encrypt-peer-connections") || len(config.PinnedPeerKeys) > 0 ||
+		len(config.StateChecksumPeerKeys) > 0
+	config.PeerMessageRateLimits = viper.GetStringSlice("peer-message-rate-limits")
+	config.Proxy = viper.GetString("proxy")
+	config.TorOnionService = viper.GetBool("tor-onion-service")
+	config.TorControlAddr = viper.GetString("tor-control-addr")
+	config.TorControlPassword = viper.GetString("tor-control-password")
+	config.MaxUploadBytesPerDay = viper.GetUint64("max-upload-bytes-per-day")
+	config.MaxUploadBytesPerSecond = viper.GetUint64("max-upload-bytes-per-second")
 
 	// Mining + Admin
 	config.MinerPublicKeys = viper.GetStringSlice("miner-public-keys")
@@ -166,6 +245,18 @@ func (config *Config) Print() {
 		glog.Infof("SnapshotBlockHeightPeriod: %v", config.SnapshotBlockHeightPeriod)
 	}
 
+	if config.StateScrubberIntervalMinutes > 0 {
+		glog.Infof("StateScrubberIntervalMinutes: %v", config.StateScrubberIntervalMinutes)
+	}
+
+	if config.DisableSnapshotServing {
+		glog.Infof("Snapshot serving: OFF")
+	}
+
+	if config.DBMaintenanceIntervalMinutes > 0 {
+		glog.Infof("DBMaintenanceIntervalMinutes: %v", config.DBMaintenanceIntervalMinutes)
+	}
+
 	if lib.IsNodeArchival(config.SyncType) {
 		glog.Infof("ArchivalMode: ON")
 	}
@@ -176,6 +267,12 @@ func (config *Config) Print() {
 		glog.Infof("MaxSyncBlockHeight: %v", config.MaxSyncBlockHeight)
 	}
 
+	if config.TrustedCheckpointBlockHash != "" {
+		glog.Infof("Trusted Checkpoint: height %v, hash %v, difficulty target %v, timestamp %v, state checksum %v",
+			config.TrustedCheckpointHeight, config.TrustedCheckpointBlockHash, config.TrustedCheckpointDifficultyTarget,
+			config.TrustedCheckpointTimestamp, config.TrustedCheckpointStateChecksum)
+	}
+
 	if len(config.ConnectIPs) > 0 {
 		glog.Infof("Connect IPs: %s", config.ConnectIPs)
 	}
@@ -201,6 +298,39 @@ func (config *Config) Print() {
 	}
 
 	glog.Infof("Max Inbound Peers: %d", config.MaxInboundPeers)
+
+	if config.PeerBanThreshold == 0 {
+		glog.Infof("Automatic peer bans: OFF")
+	}
+
+	if config.EncryptPeerConnections {
+		glog.Infof("Encrypting peer connections")
+	}
+
+	if len(config.PinnedPeerKeys) > 0 {
+		glog.Infof("Pinned peer keys: %s", config.PinnedPeerKeys)
+	}
+
+	if len(config.StateChecksumPeerKeys) > 0 {
+		glog.Infof("State checksum peer keys: %s", config.StateChecksumPeerKeys)
+	}
+
+	if len(config.PeerMessageRateLimits) > 0 {
+		glog.Infof("Peer message rate limits: %s", config.PeerMessageRateLimits)
+	}
+
+	if config.Proxy != "" {
+		glog.Infof("Proxy: %s", config.Proxy)
+	}
+
+	if config.TorOnionService {
+		glog.Infof("Publishing Tor onion service through control port %s", config.TorControlAddr)
+	}
+
+	if config.MaxUploadBytesPerDay > 0 || config.MaxUploadBytesPerSecond > 0 {
+		glog.Infof("Upload caps: %d bytes per day, %d bytes per second", config.MaxUploadBytesPerDay,
+			config.MaxUploadBytesPerSecond)
+	}
 	glog.Infof("Protocol listening on port %d", config.ProtocolPort)
 
 	if len(config.MinerPublicKeys) > 0 {
@@ -210,3 +340,22 @@ func (config *Config) Print() {
 	glog.Infof("Rate Limit Feerate: %d", config.RateLimitFeerate)
 	glog.Infof("Min Feerate: %d", config.MinFeerate)
 }
+
+// LoadParams returns a copy of the params for the chosen network, with regtest enabled if requested. The
+// subcommands use it so that enabling regtest doesn't modify the global lib params.
+func LoadParams(testnet bool, regtest bool) *lib.DeSoParams {
+	params := lib.DeSoMainnetParams
+	if testnet {
+		params = lib.DeSoTestnetParams
+	}
+	if regtest {
+		// EnableRegtest adds a param updater, so the copy needs its own map.
+		paramUpdaterPublicKeys := make(map[lib.PkMapKey]bool, len(params.ParamUpdaterPublicKeys)+1)
+		for pkMapKey, isParamUpdater := range params.ParamUpdaterPublicKeys {
+			paramUpdaterPublicKeys[pkMapKey] = isParamUpdater
+		}
+		params.ParamUpdaterPublicKeys = paramUpdaterPublicKeys
+		params.EnableRegtest()
+	}
+	return &params
+}
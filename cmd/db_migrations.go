@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+var dbMigrateCmd = &cobra.Command{
+	Use:   "db-migrate",
+	Short: "Run the pending db schema migrations",
+	Long: `Brings the node's database up to the latest schema version by running the pending schema
+migration steps in order. Steps that were interrupted are resumed where they left off. The node
+runs the same steps at startup, this command lets operators do it ahead of time. The node must
+not be running.`,
+	Run: DBMigrate,
+}
+
+func init() {
+	dbMigrateCmd.Flags().String("data-dir", "", "The location where all of the protocol-related data like blocks is stored.")
+	dbMigrateCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	dbMigrateCmd.Flags().Bool("regtest", false, "Use the regtest params.")
+	dbMigrateCmd.Flags().String("backup-path", "", "If set, a backup of the db is written to this file before "+
+		"the migrations run.")
+	dbMigrateCmd.Flags().Bool("dry-run", false, "Only list the pending migrations without running them.")
+	rootCmd.AddCommand(dbMigrateCmd)
+}
+
+func DBMigrate(cmd *cobra.Command, args []string) {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	regtest, _ := cmd.Flags().GetBool("regtest")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	backupPath, _ := cmd.Flags().GetString("backup-path")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	params := LoadParams(testnet, regtest)
+	lib.GlobalDeSoParams = *params
+
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+	dbDir := lib.GetBadgerDbPath(filepath.Join(dataDir, lib.DBVersionString))
+	opts := lib.PerformanceBadgerOptions(dbDir)
+	opts.ValueDir = dbDir
+	db, err := lib.OpenBadgerKVStore(opts)
+	if err != nil {
+		glog.Fatalf("DBMigrate: Problem opening db at (%v), make sure the node isn't running: %v", dbDir, err)
+	}
+	defer db.Close()
+
+	pending, version, err := lib.GetPendingDBSchemaMigrations(db, lib.DBSchemaMigrations)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Printf("The db is at schema version (%v), the latest version is (%v)\n", version.Version,
+		lib.GetLatestDBSchemaVersion(lib.DBSchemaMigrations))
+	if len(pending) == 0 {
+		fmt.Printf("There are no pending migrations\n")
+		return
+	}
+	for _, migration := range pending {
+		if migration.Version == version.InProgressVersion {
+			fmt.Printf("  Version (%v): %v (interrupted after %v entries)\n", migration.Version, migration.Name,
+				version.InProgressEntries)
+		} else {
+			fmt.Printf("  Version (%v): %v\n", migration.Version, migration.Name)
+		}
+	}
+	if dryRun {
+		return
+	}
+
+	if err = lib.RunDBSchemaMigrations(db, lib.DBSchemaMigrations, backupPath); err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Printf("The db is now at schema version (%v)\n", lib.GetLatestDBSchemaVersion(lib.DBSchemaMigrations))
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+var encoderMigrationsCmd = &cobra.Command{
+	Use:   "encoder-migrations",
+	Short: "Dry-run the outstanding encoder migrations",
+	Long: `Walks all state prefixes in the node's database and reports, for each outstanding encoder
+migration, how many entries will change their encoder version and how the state checksum will
+change once the migration goes live. The database is not modified. The node must not be running.`,
+	Run: EncoderMigrationsDryRun,
+}
+
+func init() {
+	encoderMigrationsCmd.Flags().String("data-dir", "", "The location where all of the protocol-related data like blocks is stored.")
+	encoderMigrationsCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	encoderMigrationsCmd.Flags().Bool("regtest", false, "Use the regtest params.")
+	encoderMigrationsCmd.Flags().Uint64("block-height", 0, "Compare the migrations against the state encoded at this "+
+		"block height. Defaults to the height of the best block in the database.")
+	rootCmd.AddCommand(encoderMigrationsCmd)
+}
+
+func EncoderMigrationsDryRun(cmd *cobra.Command, args []string) {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	regtest, _ := cmd.Flags().GetBool("regtest")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	blockHeight, _ := cmd.Flags().GetUint64("block-height")
+
+	params := LoadParams(testnet, regtest)
+	// Encoders rely on GlobalDeSoParams to determine their version.
+	lib.GlobalDeSoParams = *params
+
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+	dbDir := lib.GetBadgerDbPath(filepath.Join(dataDir, lib.DBVersionString))
+	opts := lib.PerformanceBadgerOptions(dbDir)
+	opts.ValueDir = dbDir
+	db, err := lib.OpenBadgerKVStore(opts)
+	if err != nil {
+		glog.Fatalf("EncoderMigrationsDryRun: Problem opening db at (%v), make sure the node isn't running: %v",
+			dbDir, err)
+	}
+	defer db.Close()
+
+	if blockHeight == 0 {
+		bestHash := lib.DbGetBestHash(db, nil, lib.ChainTypeDeSoBlock)
+		if bestHash == nil {
+			glog.Fatalf("EncoderMigrationsDryRun: Problem getting best block hash, is the db at (%v) empty?", dbDir)
+		}
+		bestBlock, err := lib.GetBlock(bestHash, db, nil)
+		if err != nil {
+			glog.Fatalf("EncoderMigrationsDryRun: Problem getting best block (%v): %v", bestHash, err)
+		}
+		blockHeight = bestBlock.Header.Height
+	}
+
+	fmt.Printf("Scanning the state at block height (%v)...\n", blockHeight)
+	results, entriesScanned, err := lib.EncoderMigrationDryRun(db, blockHeight, params)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if len(results) == 0 {
+		fmt.Printf("There are no outstanding encoder migrations above block height (%v)\n", blockHeight)
+		return
+	}
+
+	fmt.Printf("Scanned (%v) state entries\n", entriesScanned)
+	for _, result := range results {
+		fmt.Printf("\nMigration (%v) at block height (%v), version (%v):\n", result.Migration.Name,
+			result.Migration.Height, result.Migration.Version)
+		fmt.Printf("  Entries with a new version: %v\n", result.TotalVersionChanges)
+
+		var prefixes []byte
+		for prefix := range result.VersionChanges {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Slice(prefixes, func(ii, jj int) bool {
+			return prefixes[ii] < prefixes[jj]
+		})
+		for _, prefix := range prefixes {
+			fmt.Printf("    Prefix (%v): %v\n", prefix, result.VersionChanges[prefix])
+		}
+
+		fmt.Printf("  Entries with a new checksum encoding: %v\n", result.ChecksumChanges)
+		checksumDeltaBytes, err := result.ChecksumDelta.MarshalBinary()
+		if err != nil {
+			glog.Fatal(err)
+		}
+		fmt.Printf("  Checksum delta: %v\n", hex.EncodeToString(checksumDeltaBytes))
+	}
+}
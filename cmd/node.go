@@ -18,7 +18,6 @@ import (
 	"github.com/deso-protocol/core/lib"
 	"github.com/deso-protocol/core/migrate"
 	"github.com/deso-protocol/go-deadlock"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/go-pg/pg/v10"
 	"github.com/golang/glog"
 	migrations "github.com/robinjoseph08/go-pg-migrations/v3"
@@ -28,7 +27,7 @@ import (
 
 type Node struct {
 	Server   *lib.Server
-	ChainDB  *badger.DB
+	ChainDB  lib.KVStore
 	TXIndex  *lib.TXIndex
 	Params   *lib.DeSoParams
 	Config   *Config
@@ -46,6 +45,10 @@ type Node struct {
 	nodeMessageChan chan lib.NodeMessage
 	// stopWaitGroup allows us to wait for the node to fully close.
 	stopWaitGroup sync.WaitGroup
+	// adminListener accepts admin commands on the admin socket in the data directory.
+	adminListener net.Listener
+	// adminBackupRunning is set to 1 while an admin backup is being written.
+	adminBackupRunning int32
 }
 
 func NewNode(config *Config) *Node {
@@ -112,7 +115,13 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	}
 
 	// Setup listeners and peers
-	desoAddrMgr := addrmgr.New(node.Config.DataDirectory, net.LookupIP)
+	// If we connect to peers through a proxy, host names are resolved through it too so
+	// that we don't leak DNS requests for our seeds and peers outside of it.
+	lookupIP := net.LookupIP
+	if node.Config.Proxy != "" {
+		lookupIP = lib.NewProxyLookupIP(node.Config.Proxy, node.Params.DialTimeout)
+	}
+	desoAddrMgr := addrmgr.New(node.Config.DataDirectory, lookupIP)
 	desoAddrMgr.Start()
 
 	// This just gets localhost listening addresses on the protocol port.
@@ -125,17 +134,17 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	if len(node.Config.ConnectIPs) == 0 {
 		glog.Infof("Looking for AddIPs: %v", len(node.Config.AddIPs))
 		for _, host := range node.Config.AddIPs {
-			addIPsForHost(desoAddrMgr, host, node.Params)
+			addIPsForHost(desoAddrMgr, lookupIP, host, node.Params)
 		}
 
 		glog.Infof("Looking for DNSSeeds: %v", len(node.Params.DNSSeeds))
 		for _, host := range node.Params.DNSSeeds {
-			addIPsForHost(desoAddrMgr, host, node.Params)
+			addIPsForHost(desoAddrMgr, lookupIP, host, node.Params)
 		}
 
 		// This is where we connect to addresses from DNSSeeds.
 		if !node.Config.PrivateMode {
-			go addSeedAddrsFromPrefixes(desoAddrMgr, node.Params)
+			go addSeedAddrsFromPrefixes(desoAddrMgr, lookupIP, node.Params)
 		}
 	}
 
@@ -143,11 +152,16 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	dbDir := lib.GetBadgerDbPath(node.Config.DataDirectory)
 	opts := lib.PerformanceBadgerOptions(dbDir)
 	opts.ValueDir = dbDir
-	node.ChainDB, err = badger.Open(opts)
+	node.ChainDB, err = lib.OpenBadgerKVStore(opts)
 	if err != nil {
 		panic(err)
 	}
 
+	// Bring the db up to the latest schema version before anything else touches it.
+	if err = lib.RunDBSchemaMigrations(node.ChainDB, lib.DBSchemaMigrations, node.Config.DBMigrationBackupPath); err != nil {
+		glog.Fatalf("Problem running db schema migrations: %v", err)
+	}
+
 	// Setup snapshot logger
 	if node.Config.LogDBSummarySnapshots {
 		lib.StartDBSummarySnapshots(node.ChainDB)
@@ -156,6 +170,30 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	// Validate that we weren't passed incompatible Hypersync flags
 	lib.ValidateHyperSyncFlags(node.Config.HyperSync, node.Config.SyncType)
 
+	// A header-only node doesn't connect blocks, so it can't do anything that needs the state.
+	if node.Config.SyncType == lib.NodeSyncTypeHeaderOnly {
+		if len(node.Config.MinerPublicKeys) > 0 || node.Config.BlockProducerSeed != "" {
+			glog.Fatal("--sync-type=header-only can't be combined with --miner-public-keys or " +
+				"--block-producer-seed")
+		}
+		if node.Config.TXIndex || node.Config.PostgresURI != "" {
+			glog.Fatal("--sync-type=header-only can't be combined with --txindex or --postgres-uri")
+		}
+		if len(node.Config.TrustedBlockProducerPublicKeys) > 0 {
+			glog.Warning("--sync-type=header-only keeps no state, so it can't check whether any of the " +
+				"--trusted-block-producer-public-keys have been forbidden. Remove forbidden block producers " +
+				"from the flag yourself.")
+		}
+	}
+
+	// Parse the trusted checkpoint, if one was provided.
+	trustedCheckpoint, err := lib.NewTrustedCheckpoint(node.Config.TrustedCheckpointBlockHash,
+		node.Config.TrustedCheckpointHeight, node.Config.TrustedCheckpointDifficultyTarget,
+		node.Config.TrustedCheckpointTimestamp, node.Config.TrustedCheckpointStateChecksum)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
 	// Setup postgres using a remote URI. Postgres is not currently supported when we're in hypersync mode.
 	if node.Config.HyperSync && node.Config.PostgresURI != "" {
 		glog.Fatal("--postgres-uri is not supported when --hypersync=true. We're " +
@@ -186,6 +224,16 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	// Setup eventManager
 	eventManager := lib.NewEventManager()
 
+	// Our onion service, if we publish one, forwards to our protocol port. Without a proxy, every peer we
+	// dial sees our IP, which would give away who runs the onion service.
+	torOnionServicePort := uint16(0)
+	if node.Config.TorOnionService {
+		if node.Config.Proxy == "" {
+			glog.Fatal("--tor-onion-service requires --proxy, otherwise peers can link the onion address to our IP")
+		}
+		torOnionServicePort = node.Config.ProtocolPort
+	}
+
 	// Setup the server. ShouldRestart is used whenever we detect an issue and should restart the node after a recovery
 	// process, just in case. These issues usually arise when the node was shutdown unexpectedly mid-operation. The node
 	// performs regular health checks to detect whenever this occurs.
@@ -202,10 +250,23 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		node.Config.MinerPublicKeys,
 		node.Config.NumMiningThreads,
 		node.Config.OneInboundPerIp,
+		node.Config.PeerBanThreshold,
+		node.Config.PeerBanDurationMinutes,
+		node.Config.EncryptPeerConnections,
+		node.Config.PinnedPeerKeys,
+		node.Config.StateChecksumPeerKeys,
+		node.Config.PeerMessageRateLimits,
+		node.Config.Proxy,
+		torOnionServicePort,
+		node.Config.TorControlAddr,
+		node.Config.TorControlPassword,
+		node.Config.MaxUploadBytesPerDay,
+		node.Config.MaxUploadBytesPerSecond,
 		node.Config.HyperSync,
 		node.Config.SyncType,
 		node.Config.MaxSyncBlockHeight,
 		node.Config.DisableEncoderMigrations,
+		trustedCheckpoint,
 		node.Config.RateLimitFeerate,
 		node.Config.MinFeerate,
 		node.Config.StallTimeoutSeconds,
@@ -214,6 +275,16 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		node.Config.BlockCypherAPIKey,
 		true,
 		node.Config.SnapshotBlockHeightPeriod,
+		node.Config.StateScrubberIntervalMinutes,
+		node.Config.StateScrubberMaxEntriesPerSecond,
+		node.Config.DisableSnapshotServing,
+		node.Config.SnapshotServingMaxConcurrentRequests,
+		node.Config.SnapshotServingPeerBytesPerSecond,
+		node.Config.SnapshotServingGlobalBytesPerSecond,
+		node.Config.DBMaintenanceIntervalMinutes,
+		node.Config.DBMaintenanceIdleSeconds,
+		node.Config.ValueLogGCDiscardRatio,
+		node.Config.DBMaintenanceFlatten,
 		node.Config.DataDirectory,
 		node.Config.MempoolDumpDirectory,
 		node.Config.DisableNetworking,
@@ -246,12 +317,16 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 				glog.Fatal(err)
 			}
 			node.Server.TxIndex = node.TXIndex
+			if node.Server.DBMaintainer != nil {
+				node.Server.DBMaintainer.AddDatabase("txindex", node.TXIndex.TXIndexChain.DB())
+			}
 			if !shouldRestart {
 				node.TXIndex.Start()
 			}
 		}
 	}
 	node.IsRunning = true
+	node.startAdminServer()
 
 	if shouldRestart {
 		if node.nodeMessageChan != nil {
@@ -295,6 +370,8 @@ func (node *Node) Stop() {
 	glog.Infof(lib.CLog(lib.Yellow, "Node is shutting down. This might take a minute. Please don't "+
 		"close the node now or else you might corrupt the state."))
 
+	node.stopAdminServer()
+
 	// Server
 	glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping server..."))
 	node.Server.Stop()
@@ -330,7 +407,7 @@ func (node *Node) Stop() {
 }
 
 // Close a database and handle the stopWaitGroup accordingly. We close databases in a go routine to speed up the process.
-func (node *Node) closeDb(db *badger.DB, dbName string) {
+func (node *Node) closeDb(db lib.KVStore, dbName string) {
 	node.stopWaitGroup.Add(1)
 
 	glog.Infof("Node.closeDb: Preparing to close %v db", dbName)
@@ -472,8 +549,10 @@ func GetAddrsToListenOn(protocolPort uint16) ([]net.TCPAddr, []net.Listener) {
 	return listeningAddrs, listeners
 }
 
-func addIPsForHost(desoAddrMgr *addrmgr.AddrManager, host string, params *lib.DeSoParams) {
-	ipAddrs, err := net.LookupIP(host)
+func addIPsForHost(desoAddrMgr *addrmgr.AddrManager, lookupIP func(string) ([]net.IP, error),
+	host string, params *lib.DeSoParams) {
+
+	ipAddrs, err := lookupIP(host)
 	if err != nil {
 		glog.V(2).Infof("_addSeedAddrs: DNS discovery failed on seed host (continuing on): %s %v\n", host, err)
 		return
@@ -516,7 +595,9 @@ func addIPsForHost(desoAddrMgr *addrmgr.AddrManager, host string, params *lib.De
 // Must be run in a goroutine. This function continuously adds IPs from a DNS seed
 // prefix+suffix by iterating up through all of the possible numeric values, which are typically
 // [0, 10]
-func addSeedAddrsFromPrefixes(desoAddrMgr *addrmgr.AddrManager, params *lib.DeSoParams) {
+func addSeedAddrsFromPrefixes(desoAddrMgr *addrmgr.AddrManager, lookupIP func(string) ([]net.IP, error),
+	params *lib.DeSoParams) {
+
 	MaxIterations := 20
 
 	go func() {
@@ -527,7 +608,7 @@ func addSeedAddrsFromPrefixes(desoAddrMgr *addrmgr.AddrManager, params *lib.DeSo
 				go func(dnsGenerator []string) {
 					dnsString := fmt.Sprintf("%s%d%s", dnsGenerator[0], dnsNumber, dnsGenerator[1])
 					glog.V(2).Infof("_addSeedAddrsFromPrefixes: Querying DNS seed: %s", dnsString)
-					addIPsForHost(desoAddrMgr, dnsString, params)
+					addIPsForHost(desoAddrMgr, lookupIP, dnsString, params)
 					wg.Done()
 				}(dnsGeneratorOuter)
 			}
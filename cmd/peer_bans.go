@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+var peerBansCmd = &cobra.Command{
+	Use:   "peer-bans",
+	Short: "List, add, and remove the peer bans of a running node",
+	Long: `Manages the IPs a running node refuses to connect to. The node bans IPs automatically once
+their misbehavior score reaches --peer-ban-threshold, and bans can also be added and removed by hand.
+Bans are kept in the data directory and survive restarts.`,
+}
+
+var peerBansListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the banned IPs",
+	Run:   ListPeerBans,
+}
+
+var peerBansAddCmd = &cobra.Command{
+	Use:   "add [ip]",
+	Short: "Ban an IP and disconnect its peers",
+	Args:  cobra.ExactArgs(1),
+	Run:   AddPeerBan,
+}
+
+var peerBansRemoveCmd = &cobra.Command{
+	Use:   "remove [ip]",
+	Short: "Remove the ban of an IP",
+	Args:  cobra.ExactArgs(1),
+	Run:   RemovePeerBan,
+}
+
+func init() {
+	peerBansCmd.PersistentFlags().String("data-dir", "", "The location where all of the protocol-related data like blocks is stored.")
+	peerBansCmd.PersistentFlags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	peerBansAddCmd.Flags().Duration("duration", 0, "How long the IP stays banned. If unset, the node's "+
+		"--peer-ban-duration-minutes is used.")
+	peerBansAddCmd.Flags().String("reason", "", "Why the IP is banned, for the record.")
+	peerBansCmd.AddCommand(peerBansListCmd, peerBansAddCmd, peerBansRemoveCmd)
+	rootCmd.AddCommand(peerBansCmd)
+}
+
+func _sendPeerBanRequest(cmd *cobra.Command, request *AdminRequest) {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+
+	params := &lib.DeSoMainnetParams
+	if testnet {
+		params = &lib.DeSoTestnetParams
+	}
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+
+	response, err := SendAdminRequest(filepath.Join(dataDir, lib.DBVersionString), request, time.Minute)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if response.Error != "" {
+		glog.Fatalf("Node failed to %v: %v", request.Command, response.Error)
+	}
+	if len(response.Bans) == 0 {
+		fmt.Println("No banned IPs")
+		return
+	}
+	for _, ban := range response.Bans {
+		fmt.Printf("%v banned at %v until %v: %v\n", ban.IP, ban.BannedAt.Format(time.RFC3339),
+			ban.ExpiresAt.Format(time.RFC3339), ban.Reason)
+	}
+}
+
+func ListPeerBans(cmd *cobra.Command, args []string) {
+	_sendPeerBanRequest(cmd, &AdminRequest{
+		Command: AdminCommandListPeerBans,
+	})
+}
+
+func AddPeerBan(cmd *cobra.Command, args []string) {
+	duration, _ := cmd.Flags().GetDuration("duration")
+	reason, _ := cmd.Flags().GetString("reason")
+	_sendPeerBanRequest(cmd, &AdminRequest{
+		Command:     AdminCommandBanPeer,
+		IP:          args[0],
+		BanDuration: duration,
+		Reason:      reason,
+	})
+}
+
+func RemovePeerBan(cmd *cobra.Command, args []string) {
+	_sendPeerBanRequest(cmd, &AdminRequest{
+		Command: AdminCommandUnbanPeer,
+		IP:      args[0],
+	})
+}
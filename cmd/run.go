@@ -67,6 +67,33 @@ func SetupRunFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().Bool("archival-mode", true, "Download all historical blocks after finishing hypersync.")
 	// Disable encoder migrations
 	cmd.PersistentFlags().Bool("disable-encoder-migrations", false, "Disable badgerDB encoder migrations")
+	// DB schema migrations
+	cmd.PersistentFlags().String("db-migration-backup-path", "", "If set, a backup of the db is written to this "+
+		"file before pending db schema migrations are run at startup. Nothing is written if there's nothing to migrate.")
+	// State scrubber
+	cmd.PersistentFlags().Uint64("state-scrubber-interval-minutes", 0, "If set, the node will periodically "+
+		"re-compute the state checksum from the db in the background and compare it with the incremental checksum. "+
+		"Only works with --hypersync.")
+	cmd.PersistentFlags().Uint64("state-scrubber-max-entries-per-second", 5000,
+		"Max number of db records per second the state scrubber hashes, so that it doesn't hurt block processing.")
+	cmd.PersistentFlags().Bool("disable-snapshot-serving", false, "If set, a hypersync node will refuse to serve "+
+		"snapshot chunks to peers, but will otherwise relay blocks and transactions as usual.")
+	cmd.PersistentFlags().Uint64("snapshot-serving-max-concurrent-requests", 4, "Max number of snapshot chunk "+
+		"requests served at the same time. Peers wait in a queue for their turn. Zero means unlimited.")
+	cmd.PersistentFlags().Uint64("snapshot-serving-peer-bytes-per-second", 0, "Max bandwidth used to serve "+
+		"snapshot chunks to a single peer. Zero means unlimited.")
+	cmd.PersistentFlags().Uint64("snapshot-serving-global-bytes-per-second", 0, "Max bandwidth used to serve "+
+		"snapshot chunks to all peers combined. Zero means unlimited.")
+	// DB maintenance
+	cmd.PersistentFlags().Uint64("db-maintenance-interval-minutes", 360, "How often the node runs value log GC "+
+		"on its dbs and reports their disk usage. GC only runs once the node has been idle between blocks. "+
+		"Zero disables db maintenance.")
+	cmd.PersistentFlags().Uint64("db-maintenance-idle-seconds", 30, "How long the node has to go without "+
+		"connecting a block before db maintenance runs.")
+	cmd.PersistentFlags().Float64("value-log-gc-discard-ratio", 0.5, "The fraction of a value log file that "+
+		"has to be stale for value log GC to rewrite it.")
+	cmd.PersistentFlags().Bool("db-maintenance-flatten", false, "If set, db maintenance also compacts the "+
+		"LSM tree of every db before running value log GC.")
 	// Disable slow sync
 	cmd.PersistentFlags().String("sync-type", "any", `We have the following options for SyncType:
 		- any: Will sync with a node no matter what kind of syncing it supports.
@@ -75,7 +102,27 @@ func SetupRunFlags(cmd *cobra.Command) {
 		  still download historical blocks at the end. Can only be set if HyperSync
 		  is true.
 		- hypersync: Will sync by downloading historical state, and will NOT
-		  download historical blocks. Can only be set if HyperSync is true.`)
+		  download historical blocks. Can only be set if HyperSync is true.
+		- header-only: Will only sync and validate headers, and will keep no
+		  state. Txn proofs, or whole blocks, are fetched from peers on demand
+		  to check that a transaction is included in a block. Can only be set
+		  if HyperSync is false, and can't be combined with mining, block
+		  production, --txindex or --postgres-uri.`)
+	// Trusted checkpoint
+	cmd.PersistentFlags().String("trusted-checkpoint-block-hash", "",
+		"Hex hash of a trusted snapshot epoch block. When set, the node only downloads headers after this "+
+			"block and hypersyncs the state at its height, rather than downloading all headers from genesis. "+
+			"Requires --hypersync=true and --sync-type=hypersync.")
+	cmd.PersistentFlags().Uint32("trusted-checkpoint-height", 0,
+		"Height of the block passed in --trusted-checkpoint-block-hash.")
+	cmd.PersistentFlags().String("trusted-checkpoint-difficulty-target", "",
+		"Hex difficulty target of the block passed in --trusted-checkpoint-block-hash. Headers after the "+
+			"checkpoint are required to meet the difficulty computed from it.")
+	cmd.PersistentFlags().Uint64("trusted-checkpoint-timestamp", 0,
+		"Timestamp, in seconds, of the block passed in --trusted-checkpoint-block-hash.")
+	cmd.PersistentFlags().String("trusted-checkpoint-state-checksum", "",
+		"Hex state checksum at the block passed in --trusted-checkpoint-block-hash. HyperSync will fail "+
+			"unless the synced state matches this checksum.")
 
 	// Peers
 	cmd.PersistentFlags().StringSlice("connect-ips", []string{},
@@ -111,6 +158,44 @@ func SetupRunFlags(cmd *cobra.Command) {
 			"our connections and potentially make onerous requests as well. Useful to "+
 			"disable this flag when testing locally to allow multiple inbound connections "+
 			"from test servers")
+	cmd.PersistentFlags().Uint32("peer-ban-threshold", 100, "The misbehavior score at which a peer's IP is "+
+		"banned. Every offense, such as sending an invalid block or an unrequested snapshot chunk, adds to the "+
+		"score of the peer's IP. Zero disables automatic bans.")
+	cmd.PersistentFlags().Uint64("peer-ban-duration-minutes", 24*60, "How long a misbehaving peer's IP stays "+
+		"banned. Bans are kept in the data directory and survive restarts.")
+	cmd.PersistentFlags().Bool("encrypt-peer-connections", false, "When set, the node encrypts its "+
+		"connections with peers that also set it, using a Noise handshake with a static key kept in the "+
+		"data directory. The node's public key is logged on startup.")
+	cmd.PersistentFlags().StringSlice("pinned-peer-keys", []string{}, "A comma-separated list of "+
+		"<connect-ip>=<hex public key> entries. When connecting to one of these --connect-ips, the node "+
+		"requires an encrypted connection and drops the peer unless it proves it owns the pinned key. "+
+		"Setting this turns on --encrypt-peer-connections.")
+	cmd.PersistentFlags().StringSlice("state-checksum-peer-keys", []string{}, "A comma-separated list of "+
+		"hex transport public keys of the peers, such as the state-diff command, that can request state "+
+		"checksums from this node. A peer has to prove it owns one of these keys over an encrypted connection, "+
+		"and requests from any other peer are rejected. Setting this turns on --encrypt-peer-connections.")
+	cmd.PersistentFlags().StringSlice("peer-message-rate-limits", []string{}, "A comma-separated list of "+
+		"<MSG_TYPE>=<messages per second>:<burst> entries that override the default rate limits on the "+
+		"messages a single peer can send us, e.g. GET_ADDR=0.1:10. A rate of zero removes the limit for the "+
+		"message type. Messages over the limit are dropped and count as misbehavior.")
+	cmd.PersistentFlags().String("proxy", "", "The <host>:<port> of a SOCKS5 proxy, such as Tor, that "+
+		"all outbound peer connections go through. When set, the node can connect to onion addresses and "+
+		"doesn't advertise its own IP. Host names, such as DNS seeds, are resolved through the proxy with Tor's "+
+		"SOCKS5 RESOLVE extension instead of our local resolver.")
+	cmd.PersistentFlags().Bool("tor-onion-service", false, "When set, the node publishes a Tor onion "+
+		"service through the Tor control port that forwards to its protocol port, and advertises the onion "+
+		"address to the peers it reaches through the proxy or the onion service. Requires --proxy. The service "+
+		"key is kept in the data directory so the address survives restarts.")
+	cmd.PersistentFlags().String("tor-control-addr", "127.0.0.1:9051", "The <host>:<port> of the Tor "+
+		"control port used by --tor-onion-service.")
+	cmd.PersistentFlags().String("tor-control-password", "", "The password for the Tor control port. "+
+		"When empty, the node authenticates with Tor's cookie file.")
+	cmd.PersistentFlags().Uint64("max-upload-bytes-per-day", 0, "The number of bytes the node sends to "+
+		"peers in a 24 hour cycle before it stops serving historical blocks and snapshots. New blocks and "+
+		"transactions are still relayed. Zero means unlimited.")
+	cmd.PersistentFlags().Uint64("max-upload-bytes-per-second", 0, "The upload rate above which the node "+
+		"holds back historical blocks and snapshots until its upload rate drops. New blocks and transactions "+
+		"are still relayed. Zero means unlimited.")
 
 	// Listeners
 	cmd.PersistentFlags().Uint64("protocol-port", 0,
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+var stateDiffCmd = &cobra.Command{
+	Use:   "state-diff",
+	Short: "Compare the state of two nodes",
+	Long: `Connects to two running hypersync nodes and finds the state records that differ between them.
+Both nodes must be at the same snapshot epoch. Instead of downloading the whole state, the command
+compares checksums of key ranges and only narrows down on the ranges that differ. Nodes only answer
+peers that prove they own a key in their --state-checksum-peer-keys, so both nodes must list the
+public key of --transport-key-file.`,
+	Run: StateDiff,
+}
+
+func init() {
+	stateDiffCmd.Flags().String("node-a", "", "The address of the first node, e.g. 127.0.0.1:17000")
+	stateDiffCmd.Flags().String("node-b", "", "The address of the second node, e.g. 127.0.0.1:18000")
+	stateDiffCmd.Flags().String("prefixes", "", "A comma-separated list of state prefixes to compare, "+
+		"e.g. 5,23. All state prefixes are compared by default.")
+	stateDiffCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	stateDiffCmd.Flags().Bool("regtest", false, "Use the regtest params.")
+	stateDiffCmd.Flags().Uint64("timeout-seconds", 60, "How long to wait for a node to reply to a request.")
+	stateDiffCmd.Flags().String("transport-key-file", "", "The file holding the transport key the command "+
+		"proves it owns to the nodes. A new key is generated if the file doesn't exist. The public key is "+
+		"printed on startup and has to be in the --state-checksum-peer-keys of both nodes.")
+	rootCmd.AddCommand(stateDiffCmd)
+}
+
+func StateDiff(cmd *cobra.Command, args []string) {
+	nodeAAddr, _ := cmd.Flags().GetString("node-a")
+	nodeBAddr, _ := cmd.Flags().GetString("node-b")
+	prefixesStr, _ := cmd.Flags().GetString("prefixes")
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	regtest, _ := cmd.Flags().GetBool("regtest")
+	timeoutSeconds, _ := cmd.Flags().GetUint64("timeout-seconds")
+	transportKeyFile, _ := cmd.Flags().GetString("transport-key-file")
+
+	if nodeAAddr == "" || nodeBAddr == "" {
+		glog.Fatalf("StateDiff: Both --node-a and --node-b must be provided")
+	}
+	if transportKeyFile == "" {
+		glog.Fatalf("StateDiff: --transport-key-file must be provided")
+	}
+	transportKey, err := lib.LoadOrCreatePeerTransportKey(transportKeyFile)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	fmt.Printf("Connecting with transport public key %v\n", hex.EncodeToString(transportKey.PublicKey))
+
+	params := LoadParams(testnet, regtest)
+	// Encoders rely on GlobalDeSoParams to determine their version.
+	lib.GlobalDeSoParams = *params
+
+	prefixes := lib.StatePrefixes.StatePrefixesList
+	if prefixesStr != "" {
+		prefixes = nil
+		for _, prefixStr := range strings.Split(prefixesStr, ",") {
+			prefix, err := strconv.ParseUint(strings.TrimSpace(prefixStr), 10, 8)
+			if err != nil {
+				glog.Fatalf("StateDiff: Problem parsing prefix (%v): %v", prefixStr, err)
+			}
+			prefixes = append(prefixes, []byte{byte(prefix)})
+		}
+	}
+
+	timeout := time.Duration(timeoutSeconds) * time.Second
+	nodeA, err := lib.NewStateDiffPeer(nodeAAddr, params, transportKey, timeout)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer nodeA.Close()
+	nodeB, err := lib.NewStateDiffPeer(nodeBAddr, params, transportKey, timeout)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	defer nodeB.Close()
+
+	differ := lib.NewStateDiffer(nodeA, nodeB)
+	diffs, err := differ.DiffPrefixes(prefixes)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	fmt.Printf("Compared (%v) prefixes at snapshot height (%v) using (%v) requests\n", len(prefixes),
+		differ.SnapshotMetadata.SnapshotBlockHeight, differ.NumRequests)
+	if len(diffs) == 0 {
+		fmt.Printf("The state of both nodes is identical\n")
+		return
+	}
+	fmt.Printf("Found (%v) differing records:\n", len(diffs))
+	for _, diff := range diffs {
+		fmt.Printf("\nKey: %v\n", hex.EncodeToString(diff.Key))
+		fmt.Printf("  Node A: %v\n", formatStateDiffValue(diff.Key, diff.ValueA, diff.FoundA))
+		fmt.Printf("  Node B: %v\n", formatStateDiffValue(diff.Key, diff.ValueB, diff.FoundB))
+	}
+}
+
+// formatStateDiffValue decodes the value if its prefix corresponds to a DeSoEncoder, otherwise prints it in hex.
+func formatStateDiffValue(key []byte, value []byte, found bool) string {
+	if !found {
+		return "<missing>"
+	}
+	if isEncoder, encoder := lib.StateKeyToDeSoEncoder(key); isEncoder && encoder != nil {
+		if exists, err := lib.DecodeFromBytes(encoder, bytes.NewReader(value)); err == nil && exists {
+			return fmt.Sprintf("%+v", encoder)
+		}
+	}
+	return hex.EncodeToString(value)
+}
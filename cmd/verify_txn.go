@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+var verifyTxnCmd = &cobra.Command{
+	Use:   "verify-txn [txn hash] [block hash]",
+	Short: "Check that a transaction is included in a block of a running node's best header chain",
+	Long: `Asks a running node whether a transaction is included in a block of its best header chain, and
+how many confirmations it has. A node running with --sync-type=header-only fetches a proof of the
+transaction, or the whole block, from its peers and checks it against the header it validated.`,
+	Args: cobra.ExactArgs(2),
+	Run:  VerifyTxn,
+}
+
+func init() {
+	verifyTxnCmd.Flags().String("data-dir", "", "The location where all of the protocol-related data like blocks is stored.")
+	verifyTxnCmd.Flags().Bool("testnet", false, "Use the DeSo testnet. Mainnet is used by default")
+	rootCmd.AddCommand(verifyTxnCmd)
+}
+
+func VerifyTxn(cmd *cobra.Command, args []string) {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+
+	params := &lib.DeSoMainnetParams
+	if testnet {
+		params = &lib.DeSoTestnetParams
+	}
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+
+	// The node may have to ask several peers for the block.
+	timeout := time.Duration(lib.HeaderOnlyMaxBlockRequestPeers+1) * lib.HeaderOnlyBlockRequestTimeout
+	response, err := SendAdminRequest(filepath.Join(dataDir, lib.DBVersionString), &AdminRequest{
+		Command:   AdminCommandVerifyTxnInclusion,
+		TxnHash:   args[0],
+		BlockHash: args[1],
+	}, timeout)
+	if err != nil {
+		glog.Fatal(err)
+	}
+	if response.Error != "" {
+		glog.Fatalf("Node failed to %v: %v", AdminCommandVerifyTxnInclusion, response.Error)
+	}
+	inclusion := response.Inclusion
+	fmt.Printf("Txn %v is txn %d of block %v at height %d, with %d confirmations\n", inclusion.TxnHash,
+		inclusion.TxnIndex, inclusion.BlockHash, inclusion.BlockHeight, inclusion.NumConfirmations)
+	if inclusion.ForbiddenBlockProducerUnchecked {
+		fmt.Printf("Warning: The block is signed by a trusted block producer, but a header-only node can't " +
+			"check whether the block producer has been forbidden since\n")
+	}
+}
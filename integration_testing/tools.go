@@ -6,7 +6,6 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/deso-protocol/core/cmd"
 	"github.com/deso-protocol/core/lib"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
@@ -183,7 +182,7 @@ func compareNodesByTxIndex(t *testing.T, nodeA *cmd.Node, nodeB *cmd.Node, verbo
 
 // compareNodesByDB will look through all records in provided prefixList in nodeA and nodeB databases and will compare them.
 // The nodes pass this comparison iff they have identical states.
-func compareNodesByStateWithPrefixList(t *testing.T, dbA *badger.DB, dbB *badger.DB, prefixList [][]byte, verbose int) {
+func compareNodesByStateWithPrefixList(t *testing.T, dbA lib.KVStore, dbB lib.KVStore, prefixList [][]byte, verbose int) {
 	maxBytes := lib.SnapshotBatchSize
 	var brokenPrefixes [][]byte
 	var broken bool
@@ -325,8 +324,8 @@ func computeNodeStateChecksum(t *testing.T, node *cmd.Node, blockHeight uint64)
 	carrierChecksum := &lib.StateChecksum{}
 	carrierChecksum.Initialize(nil, nil)
 
-	err := node.Server.GetBlockchain().DB().View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := node.Server.GetBlockchain().DB().View(func(txn lib.KVTxn) error {
+		opts := lib.DefaultKVIteratorOptions
 		for _, prefix := range prefixes {
 			it := txn.NewIterator(opts)
 			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
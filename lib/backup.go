@@ -0,0 +1,592 @@
+package lib
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// NodeBackupVersion is the version of the backup archive format. It should be bumped
+// whenever the layout of the archive or of the record dumps changes.
+const NodeBackupVersion = 1
+
+const (
+	// NodeBackupManifestFileName is the name of the manifest entry, which is always the first
+	// entry in a backup archive.
+	NodeBackupManifestFileName = "manifest.json"
+
+	NodeBackupChainDB    = "chain"
+	NodeBackupSnapshotDB = "snapshot"
+	NodeBackupTxIndexDB  = "txindex"
+	NodeBackupMempoolDB  = "mempool"
+)
+
+// NodeBackupSnapshotStatus is the part of the SnapshotStatus that is recorded in a backup
+// manifest. A restored node has to come up with exactly this status.
+type NodeBackupSnapshotStatus struct {
+	MainDBSemaphore      uint64
+	AncestralDBSemaphore uint64
+	CurrentBlockHeight   uint64
+}
+
+// NodeBackupDatabase describes one of the record dumps in a backup archive.
+type NodeBackupDatabase struct {
+	Name       string
+	FileName   string
+	NumRecords uint64
+	SizeBytes  int64
+	// Sha256 is the hex-encoded sha256 of the record dump.
+	Sha256 string
+}
+
+// NodeBackupManifest describes a backup archive. All of the databases in the archive were
+// dumped from read transactions that were opened at the same time, while block processing
+// and snapshot flushes were paused, so together they form a consistent copy of the node.
+type NodeBackupManifest struct {
+	Version     uint64
+	CreatedAt   time.Time
+	NetworkType string
+
+	TipHash   string
+	TipHeight uint64
+
+	// SnapshotStatus is nil if the node wasn't running with hypersync.
+	SnapshotStatus *NodeBackupSnapshotStatus
+
+	Databases []*NodeBackupDatabase
+}
+
+func (manifest *NodeBackupManifest) GetDatabase(name string) *NodeBackupDatabase {
+	for _, database := range manifest.Databases {
+		if database.Name == name {
+			return database
+		}
+	}
+	return nil
+}
+
+// nodeBackupSource is a read transaction on one of the node's databases that is dumped
+// into the archive.
+type nodeBackupSource struct {
+	name  string
+	store KVStore
+	txn   KVTxn
+}
+
+// BackupToArchive writes a consistent backup of the node's databases to a new archive at
+// archivePath. It briefly pauses block processing, txindex updates, and snapshot flushes
+// while it opens a read transaction on every database, records the tip and the snapshot
+// status, and copies the mempool. The databases are then streamed into the archive from
+// those transactions with the node running normally.
+func (srv *Server) BackupToArchive(archivePath string) (*NodeBackupManifest, error) {
+	bc := srv.blockchain
+	if bc.postgres != nil {
+		return nil, fmt.Errorf("BackupToArchive: Backups aren't supported for nodes running on Postgres")
+	}
+	if _, err := os.Stat(archivePath); err == nil {
+		return nil, fmt.Errorf("BackupToArchive: File (%v) already exists", archivePath)
+	}
+
+	manifest := &NodeBackupManifest{
+		Version:     NodeBackupVersion,
+		CreatedAt:   time.Now().UTC(),
+		NetworkType: bc.params.NetworkType.String(),
+	}
+
+	sources, err := srv._openBackupSources(manifest)
+	// The transactions are discarded once everything has been dumped, or right away if we
+	// failed to open some of them.
+	defer func() {
+		for _, source := range sources {
+			source.txn.Discard()
+			if source.name == NodeBackupMempoolDB {
+				source.store.Close()
+			}
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	// Dump every database to a temporary file next to the archive. We need the size and the
+	// checksum of every dump for the manifest, which has to be the first entry in the archive.
+	var dumpPaths []string
+	defer func() {
+		for _, dumpPath := range dumpPaths {
+			os.Remove(dumpPath)
+		}
+	}()
+	for _, source := range sources {
+		startTime := time.Now()
+		dumpPath := fmt.Sprintf("%v.%v.tmp", archivePath, source.name)
+		dumpPaths = append(dumpPaths, dumpPath)
+		database, err := _dumpBackupSource(source, dumpPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "BackupToArchive: Problem dumping (%v) db", source.name)
+		}
+		manifest.Databases = append(manifest.Databases, database)
+		glog.V(1).Infof("BackupToArchive: Dumped %v records from (%v) db in %v", database.NumRecords,
+			source.name, time.Since(startTime))
+	}
+
+	if err = _writeBackupArchive(archivePath, manifest, dumpPaths); err != nil {
+		os.Remove(archivePath)
+		return nil, err
+	}
+	glog.Infof("BackupToArchive: Wrote backup of tip (%v) at height (%v) to (%v)", manifest.TipHash,
+		manifest.TipHeight, archivePath)
+	return manifest, nil
+}
+
+// _openBackupSources opens a read transaction on every database and fills in the tip and
+// the snapshot status of the manifest. Nothing can be written to the chain, snapshot, or
+// txindex dbs while this runs.
+func (srv *Server) _openBackupSources(manifest *NodeBackupManifest) (_sources []*nodeBackupSource, _err error) {
+	bc := srv.blockchain
+
+	// The txindex takes the ChainLock while it holds the TXIndexLock, so we lock in the
+	// same order.
+	if srv.TxIndex != nil {
+		srv.TxIndex.TXIndexLock.Lock()
+		defer srv.TxIndex.TXIndexLock.Unlock()
+	}
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	// Holding the ChainLock stops new blocks from being flushed. Blocks that were already
+	// flushed can still have pending ancestral record flushes, so wait for those to finish.
+	snap := bc.snapshot
+	if snap != nil {
+		snap.WaitForAllOperationsToFinish()
+	}
+
+	tip := bc.blockTip()
+	manifest.TipHash = tip.Hash.String()
+	manifest.TipHeight = uint64(tip.Height)
+
+	var sources []*nodeBackupSource
+	sources = append(sources, &nodeBackupSource{
+		name:  NodeBackupChainDB,
+		store: bc.db,
+		txn:   bc.db.NewTransaction(false),
+	})
+
+	if snap != nil {
+		snap.Status.MemoryLock.Lock()
+		if snap.Status.IsFlushingWithoutLock() {
+			snap.Status.MemoryLock.Unlock()
+			return sources, fmt.Errorf("_openBackupSources: Snapshot is still flushing, try again later")
+		}
+		manifest.SnapshotStatus = &NodeBackupSnapshotStatus{
+			MainDBSemaphore:      snap.Status.MainDBSemaphore,
+			AncestralDBSemaphore: snap.Status.AncestralDBSemaphore,
+			CurrentBlockHeight:   snap.Status.CurrentBlockHeight,
+		}
+		snap.SnapshotDbMutex.Lock()
+		sources = append(sources, &nodeBackupSource{
+			name:  NodeBackupSnapshotDB,
+			store: snap.SnapshotDb,
+			txn:   snap.SnapshotDb.NewTransaction(false),
+		})
+		snap.SnapshotDbMutex.Unlock()
+		snap.Status.MemoryLock.Unlock()
+	}
+
+	if srv.TxIndex != nil {
+		txIndexDb := srv.TxIndex.TXIndexChain.DB()
+		sources = append(sources, &nodeBackupSource{
+			name:  NodeBackupTxIndexDB,
+			store: txIndexDb,
+			txn:   txIndexDb.NewTransaction(false),
+		})
+	}
+
+	// The mempool doesn't have a db of its own while the node is running, so copy it into
+	// an in-memory store in the same format as the mempool dumps.
+	if srv.mempool != nil {
+		mempoolDb := NewMemoryKVStore()
+		allTxns := srv.mempool.GetReadOnlyUniversalTransactionList()
+		err := mempoolDb.Update(func(txn KVTxn) error {
+			return FlushMempoolToDbWithTxn(txn, nil, uint64(tip.Height+1), allTxns)
+		})
+		if err != nil {
+			mempoolDb.Close()
+			return sources, errors.Wrapf(err, "_openBackupSources: Problem copying mempool")
+		}
+		sources = append(sources, &nodeBackupSource{
+			name:  NodeBackupMempoolDB,
+			store: mempoolDb,
+			txn:   mempoolDb.NewTransaction(false),
+		})
+	}
+
+	return sources, nil
+}
+
+func _dumpBackupSource(source *nodeBackupSource, dumpPath string) (*NodeBackupDatabase, error) {
+	dumpFile, err := os.OpenFile(dumpPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer dumpFile.Close()
+
+	hasher := sha256.New()
+	writer := bufio.NewWriter(io.MultiWriter(dumpFile, hasher))
+	numRecords, err := WriteBackupRecords(writer, source.txn)
+	if err != nil {
+		return nil, err
+	}
+	if err = writer.Flush(); err != nil {
+		return nil, err
+	}
+	info, err := dumpFile.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &NodeBackupDatabase{
+		Name:       source.name,
+		FileName:   source.name + ".records",
+		NumRecords: numRecords,
+		SizeBytes:  info.Size(),
+		Sha256:     hex.EncodeToString(hasher.Sum(nil)),
+	}, nil
+}
+
+func _writeBackupArchive(archivePath string, manifest *NodeBackupManifest, dumpPaths []string) error {
+	archiveFile, err := os.OpenFile(archivePath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "_writeBackupArchive: Problem creating archive")
+	}
+	defer archiveFile.Close()
+
+	tarWriter := tar.NewWriter(archiveFile)
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "_writeBackupArchive: Problem encoding manifest")
+	}
+	err = tarWriter.WriteHeader(&tar.Header{
+		Name:    NodeBackupManifestFileName,
+		Mode:    0600,
+		Size:    int64(len(manifestBytes)),
+		ModTime: manifest.CreatedAt,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "_writeBackupArchive: Problem writing manifest header")
+	}
+	if _, err = tarWriter.Write(manifestBytes); err != nil {
+		return errors.Wrapf(err, "_writeBackupArchive: Problem writing manifest")
+	}
+
+	for ii, database := range manifest.Databases {
+		err = tarWriter.WriteHeader(&tar.Header{
+			Name:    database.FileName,
+			Mode:    0600,
+			Size:    database.SizeBytes,
+			ModTime: manifest.CreatedAt,
+		})
+		if err != nil {
+			return errors.Wrapf(err, "_writeBackupArchive: Problem writing header for (%v)", database.Name)
+		}
+		dumpFile, err := os.Open(dumpPaths[ii])
+		if err != nil {
+			return errors.Wrapf(err, "_writeBackupArchive: Problem opening dump for (%v)", database.Name)
+		}
+		_, err = io.Copy(tarWriter, dumpFile)
+		dumpFile.Close()
+		if err != nil {
+			return errors.Wrapf(err, "_writeBackupArchive: Problem copying dump for (%v)", database.Name)
+		}
+	}
+
+	if err = tarWriter.Close(); err != nil {
+		return errors.Wrapf(err, "_writeBackupArchive: Problem closing archive")
+	}
+	return archiveFile.Sync()
+}
+
+// WriteBackupRecords writes every record visible to txn to writer. Each record is written
+// as a uvarint key length, the key, a uvarint value length, and the value, in key order.
+func WriteBackupRecords(writer io.Writer, txn KVTxn) (_numRecords uint64, _err error) {
+	opts := DefaultKVIteratorOptions
+	opts.PrefetchValues = true
+	it := txn.NewIterator(opts)
+	defer it.Close()
+
+	numRecords := uint64(0)
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for it.Seek([]byte{}); it.Valid(); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return 0, errors.Wrapf(err, "WriteBackupRecords: Problem reading value")
+		}
+		for _, data := range [][]byte{key, value} {
+			nn := binary.PutUvarint(lenBuf, uint64(len(data)))
+			if _, err = writer.Write(lenBuf[:nn]); err != nil {
+				return 0, err
+			}
+			if _, err = writer.Write(data); err != nil {
+				return 0, err
+			}
+		}
+		numRecords++
+	}
+	return numRecords, nil
+}
+
+// ReadBackupRecords loads the records written by WriteBackupRecords into store.
+func ReadBackupRecords(reader io.Reader, store KVStore) (_numRecords uint64, _err error) {
+	bufReader := bufio.NewReader(reader)
+	readData := func() ([]byte, error) {
+		dataLen, err := binary.ReadUvarint(bufReader)
+		if err != nil {
+			return nil, err
+		}
+		if dataLen > uint64(MaxMessagePayload) {
+			return nil, fmt.Errorf("record of %v bytes is too large", dataLen)
+		}
+		data := make([]byte, dataLen)
+		if _, err = io.ReadFull(bufReader, data); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+
+	writeBatch := store.NewWriteBatch()
+	defer writeBatch.Cancel()
+	numRecords := uint64(0)
+	for {
+		key, err := readData()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, errors.Wrapf(err, "ReadBackupRecords: Problem reading key of record %v", numRecords)
+		}
+		value, err := readData()
+		if err != nil {
+			return 0, errors.Wrapf(err, "ReadBackupRecords: Problem reading value of record %v", numRecords)
+		}
+		if err = writeBatch.Set(key, value); err != nil {
+			return 0, errors.Wrapf(err, "ReadBackupRecords: Problem writing record %v", numRecords)
+		}
+		numRecords++
+	}
+	if err := writeBatch.Flush(); err != nil {
+		return 0, errors.Wrapf(err, "ReadBackupRecords: Problem flushing records")
+	}
+	return numRecords, nil
+}
+
+// NodeBackupDatabaseDirs returns the badger Dir and ValueDir that the node uses for the
+// database with the given backup name.
+func NodeBackupDatabaseDirs(name string, dataDir string, mempoolDumpDir string) (_dir string, _valueDir string, _err error) {
+	switch name {
+	case NodeBackupChainDB:
+		dbDir := GetBadgerDbPath(dataDir)
+		return dbDir, dbDir, nil
+	case NodeBackupSnapshotDB:
+		snapshotDir := filepath.Join(GetBadgerDbPath(dataDir), "snapshot")
+		return snapshotDir, GetBadgerDbPath(snapshotDir), nil
+	case NodeBackupTxIndexDB:
+		txIndexDir := filepath.Join(GetBadgerDbPath(dataDir), "txindex")
+		return txIndexDir, GetBadgerDbPath(txIndexDir), nil
+	case NodeBackupMempoolDB:
+		if mempoolDumpDir == "" {
+			return "", "", nil
+		}
+		latestDir := filepath.Join(mempoolDumpDir, "latest_mempool_dump")
+		return latestDir, latestDir, nil
+	}
+	return "", "", fmt.Errorf("NodeBackupDatabaseDirs: Unknown database (%v)", name)
+}
+
+// RestoreFromArchive restores the databases in a backup archive into dataDir. The node must
+// not be running, and the databases being restored must not exist yet. The mempool is only
+// restored if mempoolDumpDir is set. Once everything is loaded, the restored snapshot status
+// and best chain are validated against the manifest.
+func RestoreFromArchive(archivePath string, dataDir string, mempoolDumpDir string, params *DeSoParams) (
+	*NodeBackupManifest, error) {
+
+	archiveFile, err := os.Open(archivePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "RestoreFromArchive: Problem opening archive")
+	}
+	defer archiveFile.Close()
+	tarReader := tar.NewReader(archiveFile)
+
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, errors.Wrapf(err, "RestoreFromArchive: Problem reading archive")
+	}
+	if header.Name != NodeBackupManifestFileName {
+		return nil, fmt.Errorf("RestoreFromArchive: Expected (%v) as the first entry, got (%v)",
+			NodeBackupManifestFileName, header.Name)
+	}
+	manifest := &NodeBackupManifest{}
+	if err = json.NewDecoder(tarReader).Decode(manifest); err != nil {
+		return nil, errors.Wrapf(err, "RestoreFromArchive: Problem decoding manifest")
+	}
+	if manifest.Version != NodeBackupVersion {
+		return nil, fmt.Errorf("RestoreFromArchive: Unsupported backup version (%v), expected (%v)",
+			manifest.Version, NodeBackupVersion)
+	}
+	if manifest.NetworkType != params.NetworkType.String() {
+		return nil, fmt.Errorf("RestoreFromArchive: Backup is for network (%v), but restoring on (%v)",
+			manifest.NetworkType, params.NetworkType.String())
+	}
+
+	restored := make(map[string]bool)
+	for {
+		header, err = tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "RestoreFromArchive: Problem reading archive")
+		}
+		var database *NodeBackupDatabase
+		for _, db := range manifest.Databases {
+			if db.FileName == header.Name {
+				database = db
+			}
+		}
+		if database == nil {
+			return nil, fmt.Errorf("RestoreFromArchive: Entry (%v) isn't in the manifest", header.Name)
+		}
+		dir, valueDir, err := NodeBackupDatabaseDirs(database.Name, dataDir, mempoolDumpDir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "RestoreFromArchive: Problem finding db dirs")
+		}
+		if dir == "" {
+			glog.Infof("RestoreFromArchive: Skipping (%v) db", database.Name)
+			restored[database.Name] = true
+			continue
+		}
+		if err = _restoreBackupDatabase(tarReader, database, dir, valueDir); err != nil {
+			return nil, errors.Wrapf(err, "RestoreFromArchive: Problem restoring (%v) db", database.Name)
+		}
+		restored[database.Name] = true
+		glog.Infof("RestoreFromArchive: Restored %v records to (%v) db at (%v)", database.NumRecords,
+			database.Name, dir)
+	}
+	for _, database := range manifest.Databases {
+		if !restored[database.Name] {
+			return nil, fmt.Errorf("RestoreFromArchive: Archive is missing (%v) db", database.Name)
+		}
+	}
+
+	if err = ValidateRestoredBackup(manifest, dataDir); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func _restoreBackupDatabase(reader io.Reader, database *NodeBackupDatabase, dir string, valueDir string) error {
+	// The records are loaded with write batches, so the default options are enough and keep
+	// memory usage low. The node can open the db with its usual options afterwards.
+	opts := badger.DefaultOptions(dir)
+	opts.ValueDir = valueDir
+	store, err := OpenBadgerKVStore(opts)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	if !_dbIsEmpty(store) {
+		return fmt.Errorf("db at (%v) isn't empty, refusing to overwrite it", dir)
+	}
+
+	hasher := sha256.New()
+	numRecords, err := ReadBackupRecords(io.TeeReader(reader, hasher), store)
+	if err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(hasher.Sum(nil)); checksum != database.Sha256 {
+		return fmt.Errorf("checksum (%v) doesn't match the manifest (%v)", checksum, database.Sha256)
+	}
+	if numRecords != database.NumRecords {
+		return fmt.Errorf("restored %v records but the manifest has %v", numRecords, database.NumRecords)
+	}
+	return nil
+}
+
+// ValidateRestoredBackup checks that the databases restored into dataDir match the manifest:
+// the best chain has to point at the backed up tip, and the snapshot db has to hold exactly
+// the recorded SnapshotStatus.
+func ValidateRestoredBackup(manifest *NodeBackupManifest, dataDir string) error {
+	dir, valueDir, _ := NodeBackupDatabaseDirs(NodeBackupChainDB, dataDir, "")
+	if err := _validateRestoredDatabase(dir, valueDir, func(store KVStore) error {
+		bestHash := DbGetBestHash(store, nil, ChainTypeDeSoBlock)
+		if bestHash == nil || bestHash.String() != manifest.TipHash {
+			return fmt.Errorf("best hash (%v) doesn't match the manifest tip (%v)", bestHash, manifest.TipHash)
+		}
+		return nil
+	}); err != nil {
+		return errors.Wrapf(err, "ValidateRestoredBackup: Problem validating chain db")
+	}
+
+	if manifest.SnapshotStatus == nil {
+		return nil
+	}
+	if manifest.GetDatabase(NodeBackupSnapshotDB) == nil {
+		return fmt.Errorf("ValidateRestoredBackup: Manifest has a snapshot status but no snapshot db")
+	}
+	dir, valueDir, _ = NodeBackupDatabaseDirs(NodeBackupSnapshotDB, dataDir, "")
+	if err := _validateRestoredDatabase(dir, valueDir, func(store KVStore) error {
+		return store.View(func(txn KVTxn) error {
+			item, err := txn.Get(_prefixSnapshotStatus)
+			if err != nil {
+				return errors.Wrapf(err, "problem reading snapshot status")
+			}
+			statusBytes, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			status := &SnapshotStatus{}
+			if err = status.FromBytes(bytes.NewReader(statusBytes)); err != nil {
+				return err
+			}
+			if status.IsFlushingWithoutLock() {
+				return fmt.Errorf("snapshot status (%v, %v) was saved in the middle of a flush",
+					status.MainDBSemaphore, status.AncestralDBSemaphore)
+			}
+			if status.MainDBSemaphore != manifest.SnapshotStatus.MainDBSemaphore ||
+				status.AncestralDBSemaphore != manifest.SnapshotStatus.AncestralDBSemaphore ||
+				status.CurrentBlockHeight != manifest.SnapshotStatus.CurrentBlockHeight {
+				return fmt.Errorf("snapshot status (%v, %v, %v) doesn't match the manifest (%v, %v, %v)",
+					status.MainDBSemaphore, status.AncestralDBSemaphore, status.CurrentBlockHeight,
+					manifest.SnapshotStatus.MainDBSemaphore, manifest.SnapshotStatus.AncestralDBSemaphore,
+					manifest.SnapshotStatus.CurrentBlockHeight)
+			}
+			return nil
+		})
+	}); err != nil {
+		return errors.Wrapf(err, "ValidateRestoredBackup: Problem validating snapshot db")
+	}
+	return nil
+}
+
+func _validateRestoredDatabase(dir string, valueDir string, validate func(store KVStore) error) error {
+	opts := badger.DefaultOptions(dir)
+	opts.ValueDir = valueDir
+	store, err := OpenBadgerKVStore(opts)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+	return validate(store)
+}
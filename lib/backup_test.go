@@ -0,0 +1,128 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupArchiveRestore(t *testing.T) {
+	require := require.New(t)
+
+	tempDir, err := ioutil.TempDir("", "backup")
+	require.NoError(err)
+	defer os.RemoveAll(tempDir)
+
+	// Set up a chain db with a best hash and some records, and a snapshot db with a status.
+	tipHash := &BlockHash{1, 2, 3}
+	chainDb := NewMemoryKVStore()
+	require.NoError(PutBestHash(chainDb, nil, tipHash, ChainTypeDeSoBlock))
+	require.NoError(chainDb.Update(func(txn KVTxn) error {
+		for ii := 0; ii < 1000; ii++ {
+			if err := txn.Set([]byte(fmt.Sprintf("record-%04d", ii)), bytes.Repeat([]byte{byte(ii)}, ii)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	status := &SnapshotStatus{MainDBSemaphore: 4, AncestralDBSemaphore: 4, CurrentBlockHeight: 7}
+	snapshotDb := NewMemoryKVStore()
+	require.NoError(snapshotDb.Update(func(txn KVTxn) error {
+		return txn.Set(_prefixSnapshotStatus, status.ToBytes())
+	}))
+
+	writeArchive := func(archivePath string, manifest *NodeBackupManifest) {
+		sources := []*nodeBackupSource{
+			{name: NodeBackupChainDB, store: chainDb, txn: chainDb.NewTransaction(false)},
+			{name: NodeBackupSnapshotDB, store: snapshotDb, txn: snapshotDb.NewTransaction(false)},
+		}
+		var dumpPaths []string
+		for _, source := range sources {
+			dumpPath := fmt.Sprintf("%v.%v.tmp", archivePath, source.name)
+			database, err := _dumpBackupSource(source, dumpPath)
+			require.NoError(err)
+			source.txn.Discard()
+			manifest.Databases = append(manifest.Databases, database)
+			dumpPaths = append(dumpPaths, dumpPath)
+		}
+		require.NoError(_writeBackupArchive(archivePath, manifest, dumpPaths))
+	}
+	newManifest := func() *NodeBackupManifest {
+		return &NodeBackupManifest{
+			Version:     NodeBackupVersion,
+			NetworkType: DeSoTestnetParams.NetworkType.String(),
+			TipHash:     tipHash.String(),
+			SnapshotStatus: &NodeBackupSnapshotStatus{
+				MainDBSemaphore:      status.MainDBSemaphore,
+				AncestralDBSemaphore: status.AncestralDBSemaphore,
+				CurrentBlockHeight:   status.CurrentBlockHeight,
+			},
+		}
+	}
+
+	// A good archive restores every record and passes validation.
+	archivePath := filepath.Join(tempDir, "backup.tar")
+	writeArchive(archivePath, newManifest())
+	dataDir := filepath.Join(tempDir, "data")
+	manifest, err := RestoreFromArchive(archivePath, dataDir, "", &DeSoTestnetParams)
+	require.NoError(err)
+	require.Equal(uint64(1001), manifest.GetDatabase(NodeBackupChainDB).NumRecords)
+	{
+		dir, valueDir, err := NodeBackupDatabaseDirs(NodeBackupChainDB, dataDir, "")
+		require.NoError(err)
+		opts := badger.DefaultOptions(dir)
+		opts.ValueDir = valueDir
+		restoredDb, err := OpenBadgerKVStore(opts)
+		require.NoError(err)
+		var original, restored bytes.Buffer
+		require.NoError(chainDb.View(func(txn KVTxn) error {
+			_, err := WriteBackupRecords(&original, txn)
+			return err
+		}))
+		require.NoError(restoredDb.View(func(txn KVTxn) error {
+			_, err := WriteBackupRecords(&restored, txn)
+			return err
+		}))
+		require.Equal(original.Bytes(), restored.Bytes())
+		require.NoError(restoredDb.Close())
+	}
+
+	// Restoring on top of existing dbs isn't allowed.
+	_, err = RestoreFromArchive(archivePath, dataDir, "", &DeSoTestnetParams)
+	require.Error(err)
+
+	// Nor is restoring a backup from another network.
+	_, err = RestoreFromArchive(archivePath, filepath.Join(tempDir, "data-mainnet"), "", &DeSoMainnetParams)
+	require.Error(err)
+
+	// A manifest that doesn't match the snapshot status fails validation.
+	{
+		manifest := newManifest()
+		manifest.SnapshotStatus.CurrentBlockHeight++
+		archivePath := filepath.Join(tempDir, "backup-bad-status.tar")
+		writeArchive(archivePath, manifest)
+		_, err = RestoreFromArchive(archivePath, filepath.Join(tempDir, "data-bad-status"), "", &DeSoTestnetParams)
+		require.Error(err)
+		require.Contains(err.Error(), "snapshot status")
+	}
+
+	// A manifest with the wrong checksum is caught while restoring.
+	{
+		manifest := newManifest()
+		archivePath := filepath.Join(tempDir, "backup-bad-checksum.tar")
+		writeArchive(archivePath, manifest)
+		manifest.Databases[0].Sha256 = manifest.Databases[1].Sha256
+		require.NoError(os.Remove(archivePath))
+		require.NoError(_writeBackupArchive(archivePath, manifest, []string{
+			archivePath + ".chain.tmp", archivePath + ".snapshot.tmp"}))
+		_, err = RestoreFromArchive(archivePath, filepath.Join(tempDir, "data-bad-checksum"), "", &DeSoTestnetParams)
+		require.Error(err)
+		require.Contains(err.Error(), "checksum")
+	}
+}
@@ -0,0 +1,339 @@
+package lib
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// bandwidth.go counts the bytes we send to and receive from peers by message type, for each peer
+// and for the node as a whole, and enforces the upload caps set with --max-upload-bytes-per-day
+// and --max-upload-bytes-per-second.
+//
+// Every message we send counts towards the caps, but only serving historical data is held back
+// when they're reached: blocks deeper than UploadCapRecentBlocks below our tip and snapshot chunks.
+// New blocks and transactions are still relayed so that a capped node keeps taking part in the
+// network. This follows the -maxuploadtarget of Bitcoin Core, and is meant for nodes running on
+// metered or shared connections.
+
+const (
+	// UploadCapRecentBlocks is how deep below our tip a block can be and still be served to peers
+	// once an upload cap is reached. This is enough for peers that follow the tip to catch up on
+	// the blocks they missed after a short disconnect.
+	UploadCapRecentBlocks = 24
+
+	// UploadCapCycle is the period over which --max-upload-bytes-per-day is measured. A cycle
+	// starts when the node starts and the bytes sent are reset at the start of every cycle.
+	UploadCapCycle = 24 * time.Hour
+)
+
+// MessageSizeOnWire returns the number of bytes a message with a payload of payloadLen takes on
+// the wire, header included. It matches what WriteMessage writes.
+func MessageSizeOnWire(networkType NetworkType, msgType MsgType, payloadLen uint64) uint64 {
+	// The header is the network, the MsgType, an eight-byte checksum, and the payload length.
+	return uint64(len(UintToBuf(uint64(networkType)))+len(UintToBuf(uint64(msgType)))+8+
+		len(UintToBuf(payloadLen))) + payloadLen
+}
+
+// MsgTypeTraffic is the traffic of one message type.
+type MsgTypeTraffic struct {
+	MessagesSent     uint64
+	BytesSent        uint64
+	MessagesReceived uint64
+	BytesReceived    uint64
+}
+
+func (traffic *MsgTypeTraffic) add(other MsgTypeTraffic) {
+	traffic.MessagesSent += other.MessagesSent
+	traffic.BytesSent += other.BytesSent
+	traffic.MessagesReceived += other.MessagesReceived
+	traffic.BytesReceived += other.BytesReceived
+}
+
+// TrafficCounters counts messages and bytes by message type. The methods are safe to call on a nil
+// TrafficCounters, in which case nothing is counted.
+type TrafficCounters struct {
+	mtx       sync.Mutex
+	byMsgType map[MsgType]*MsgTypeTraffic
+}
+
+func NewTrafficCounters() *TrafficCounters {
+	return &TrafficCounters{
+		byMsgType: make(map[MsgType]*MsgTypeTraffic),
+	}
+}
+
+func (counters *TrafficCounters) _get(msgType MsgType) *MsgTypeTraffic {
+	traffic, exists := counters.byMsgType[msgType]
+	if !exists {
+		traffic = &MsgTypeTraffic{}
+		counters.byMsgType[msgType] = traffic
+	}
+	return traffic
+}
+
+// RecordSent counts a message we sent that took numBytes on the wire.
+func (counters *TrafficCounters) RecordSent(msgType MsgType, numBytes uint64) {
+	if counters == nil {
+		return
+	}
+	counters.mtx.Lock()
+	defer counters.mtx.Unlock()
+
+	traffic := counters._get(msgType)
+	traffic.MessagesSent++
+	traffic.BytesSent += numBytes
+}
+
+// RecordReceived counts a message we received that took numBytes on the wire.
+func (counters *TrafficCounters) RecordReceived(msgType MsgType, numBytes uint64) {
+	if counters == nil {
+		return
+	}
+	counters.mtx.Lock()
+	defer counters.mtx.Unlock()
+
+	traffic := counters._get(msgType)
+	traffic.MessagesReceived++
+	traffic.BytesReceived += numBytes
+}
+
+// Get returns a copy of the counters.
+func (counters *TrafficCounters) Get() map[MsgType]MsgTypeTraffic {
+	trafficByMsgType := make(map[MsgType]MsgTypeTraffic)
+	if counters == nil {
+		return trafficByMsgType
+	}
+	counters.mtx.Lock()
+	defer counters.mtx.Unlock()
+
+	for msgType, traffic := range counters.byMsgType {
+		trafficByMsgType[msgType] = *traffic
+	}
+	return trafficByMsgType
+}
+
+// Total returns the traffic of all message types combined.
+func (counters *TrafficCounters) Total() MsgTypeTraffic {
+	total := MsgTypeTraffic{}
+	for _, traffic := range counters.Get() {
+		total.add(traffic)
+	}
+	return total
+}
+
+// BandwidthManager holds the traffic counters of the node as a whole and enforces the upload caps.
+// A zero cap means unlimited.
+type BandwidthManager struct {
+	// Traffic counts the messages exchanged with all peers since the node started.
+	Traffic *TrafficCounters
+
+	maxUploadBytesPerDay    uint64
+	maxUploadBytesPerSecond uint64
+
+	mtx sync.Mutex
+	// cycleStart is when the current UploadCapCycle started and bytesSentInCycle is what we sent
+	// since then.
+	cycleStart       time.Time
+	bytesSentInCycle uint64
+	// uploadThrottle tracks when everything we sent would have been sent at the per-second cap.
+	// Messages that aren't held back by the caps go over it without waiting.
+	uploadThrottle *bandwidthThrottle
+	// unsentReservedBytes are the bytes WaitForHistoricalUpload reserved on uploadThrottle that
+	// haven't been sent yet. RecordSent uses them up before reserving more.
+	unsentReservedBytes uint64
+}
+
+func NewBandwidthManager(maxUploadBytesPerDay uint64, maxUploadBytesPerSecond uint64, now time.Time) *BandwidthManager {
+	return &BandwidthManager{
+		Traffic:                 NewTrafficCounters(),
+		maxUploadBytesPerDay:    maxUploadBytesPerDay,
+		maxUploadBytesPerSecond: maxUploadBytesPerSecond,
+		cycleStart:              now,
+		uploadThrottle:          &bandwidthThrottle{bytesPerSecond: maxUploadBytesPerSecond},
+	}
+}
+
+// _updateCycle starts a new cycle if the current one is over.
+func (bm *BandwidthManager) _updateCycle(now time.Time) {
+	if now.Sub(bm.cycleStart) < UploadCapCycle {
+		return
+	}
+	numCycles := now.Sub(bm.cycleStart) / UploadCapCycle
+	bm.cycleStart = bm.cycleStart.Add(numCycles * UploadCapCycle)
+	bm.bytesSentInCycle = 0
+}
+
+// HasUploadCaps returns true if any upload cap is set.
+func (bm *BandwidthManager) HasUploadCaps() bool {
+	return bm != nil && (bm.maxUploadBytesPerDay > 0 || bm.maxUploadBytesPerSecond > 0)
+}
+
+// RecordSent counts a message we sent to a peer towards the node's traffic and the upload caps.
+func (bm *BandwidthManager) RecordSent(msgType MsgType, numBytes uint64, now time.Time) {
+	if bm == nil {
+		return
+	}
+	bm.Traffic.RecordSent(msgType, numBytes)
+
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	bm._updateCycle(now)
+	bm.bytesSentInCycle += numBytes
+	reservedBytes := numBytes
+	if reservedBytes > bm.unsentReservedBytes {
+		reservedBytes = bm.unsentReservedBytes
+	}
+	bm.unsentReservedBytes -= reservedBytes
+	bm.uploadThrottle.reserve(numBytes-reservedBytes, now)
+}
+
+// RecordReceived counts a message we received from a peer towards the node's traffic.
+func (bm *BandwidthManager) RecordReceived(msgType MsgType, numBytes uint64) {
+	if bm == nil {
+		return
+	}
+	bm.Traffic.RecordReceived(msgType, numBytes)
+}
+
+// DailyUploadCapReached returns true if we sent as much as --max-upload-bytes-per-day allows in
+// the current cycle.
+func (bm *BandwidthManager) DailyUploadCapReached(now time.Time) bool {
+	if bm == nil || bm.maxUploadBytesPerDay == 0 {
+		return false
+	}
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	bm._updateCycle(now)
+	return bm.bytesSentInCycle >= bm.maxUploadBytesPerDay
+}
+
+// reserveHistoricalUpload reserves numBytes of historical data on the per-second cap if our upload
+// is under it. Otherwise it reserves nothing and returns how long we have to wait to get back under
+// it. Checking and reserving under the same lock makes sure that peers waiting at the same time
+// don't all send at once.
+func (bm *BandwidthManager) reserveHistoricalUpload(numBytes uint64, now time.Time) time.Duration {
+	if bm == nil || bm.maxUploadBytesPerSecond == 0 {
+		return 0
+	}
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	if bm.uploadThrottle.nextAvailable.After(now) {
+		return bm.uploadThrottle.nextAvailable.Sub(now)
+	}
+	bm.uploadThrottle.reserve(numBytes, now)
+	bm.unsentReservedBytes += numBytes
+	return 0
+}
+
+// WaitForHistoricalUpload blocks until sending numBytes of historical data to a peer fits in the
+// upload caps, and reserves them. It returns false without waiting if the daily cap is reached, in
+// which case the data shouldn't be served until the next cycle, and false if the quit channel was
+// closed in the meantime.
+func (bm *BandwidthManager) WaitForHistoricalUpload(numBytes uint64, quit chan interface{}) bool {
+	for {
+		now := time.Now()
+		if bm.DailyUploadCapReached(now) {
+			return false
+		}
+		delay := bm.reserveHistoricalUpload(numBytes, now)
+		if delay <= 0 {
+			return true
+		}
+		select {
+		case <-time.After(delay):
+		case <-quit:
+			return false
+		}
+	}
+}
+
+// UploadStats describes the upload caps and how close we are to them.
+type UploadStats struct {
+	MaxUploadBytesPerDay    uint64
+	MaxUploadBytesPerSecond uint64
+	CycleStart              time.Time
+	CycleEnd                time.Time
+	BytesSentInCycle        uint64
+	DailyUploadCapReached   bool
+}
+
+func (bm *BandwidthManager) GetUploadStats(now time.Time) UploadStats {
+	if bm == nil {
+		return UploadStats{}
+	}
+	dailyUploadCapReached := bm.DailyUploadCapReached(now)
+
+	bm.mtx.Lock()
+	defer bm.mtx.Unlock()
+
+	bm._updateCycle(now)
+	return UploadStats{
+		MaxUploadBytesPerDay:    bm.maxUploadBytesPerDay,
+		MaxUploadBytesPerSecond: bm.maxUploadBytesPerSecond,
+		CycleStart:              bm.cycleStart,
+		CycleEnd:                bm.cycleStart.Add(UploadCapCycle),
+		BytesSentInCycle:        bm.bytesSentInCycle,
+		DailyUploadCapReached:   dailyUploadCapReached,
+	}
+}
+
+// PeerBandwidth is the traffic of a connected peer, keyed by message type name.
+type PeerBandwidth struct {
+	PeerID     uint64
+	Addr       string
+	IsOutbound bool
+	Total      MsgTypeTraffic
+	ByMsgType  map[string]MsgTypeTraffic
+}
+
+// BandwidthReport is the traffic of the node and of each connected peer, as returned by the
+// admin interface.
+type BandwidthReport struct {
+	Upload    UploadStats
+	Total     MsgTypeTraffic
+	ByMsgType map[string]MsgTypeTraffic
+	Peers     []*PeerBandwidth
+}
+
+func _trafficByMsgTypeName(trafficByMsgType map[MsgType]MsgTypeTraffic) map[string]MsgTypeTraffic {
+	trafficByName := make(map[string]MsgTypeTraffic)
+	for msgType, traffic := range trafficByMsgType {
+		trafficByName[msgType.String()] = traffic
+	}
+	return trafficByName
+}
+
+// GetBandwidthReport returns the traffic of the node and of the connected peers, the peers
+// that used the most bandwidth first.
+func (cmgr *ConnectionManager) GetBandwidthReport() *BandwidthReport {
+	report := &BandwidthReport{
+		Upload:    cmgr.Bandwidth.GetUploadStats(time.Now()),
+		ByMsgType: make(map[string]MsgTypeTraffic),
+	}
+	if cmgr.Bandwidth != nil {
+		report.Total = cmgr.Bandwidth.Traffic.Total()
+		report.ByMsgType = _trafficByMsgTypeName(cmgr.Bandwidth.Traffic.Get())
+	}
+	for _, pp := range cmgr.GetAllPeers() {
+		report.Peers = append(report.Peers, &PeerBandwidth{
+			PeerID:     pp.ID,
+			Addr:       pp.Address(),
+			IsOutbound: pp.IsOutbound(),
+			Total:      pp.traffic.Total(),
+			ByMsgType:  _trafficByMsgTypeName(pp.traffic.Get()),
+		})
+	}
+	sort.Slice(report.Peers, func(ii, jj int) bool {
+		totalii := report.Peers[ii].Total.BytesSent + report.Peers[ii].Total.BytesReceived
+		totaljj := report.Peers[jj].Total.BytesSent + report.Peers[jj].Total.BytesReceived
+		if totalii != totaljj {
+			return totalii > totaljj
+		}
+		return report.Peers[ii].PeerID < report.Peers[jj].PeerID
+	})
+	return report
+}
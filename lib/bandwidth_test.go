@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMessageSizeOnWire(t *testing.T) {
+	require := require.New(t)
+
+	getBlocks := &MsgDeSoGetBlocks{}
+	for ii := 0; ii < MaxBlocksInFlight; ii++ {
+		getBlocks.HashList = append(getBlocks.HashList, &BlockHash{byte(ii)})
+	}
+	for _, msg := range []DeSoMessage{&MsgDeSoPing{Nonce: 1}, getBlocks} {
+		var buf bytes.Buffer
+		payload, err := WriteMessage(&buf, msg, NetworkType_MAINNET)
+		require.NoError(err)
+		require.Equal(uint64(buf.Len()), MessageSizeOnWire(NetworkType_MAINNET, msg.GetMsgType(), uint64(len(payload))))
+	}
+}
+
+func TestBandwidthManager(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	bm := NewBandwidthManager(1000, 100, now)
+	require.True(bm.HasUploadCaps())
+
+	// Traffic is counted by message type.
+	bm.RecordSent(MsgTypeBlock, 400, now)
+	bm.RecordSent(MsgTypeBlock, 100, now)
+	bm.RecordReceived(MsgTypeGetBlocks, 50)
+	require.Equal(map[MsgType]MsgTypeTraffic{
+		MsgTypeBlock:     {MessagesSent: 2, BytesSent: 500},
+		MsgTypeGetBlocks: {MessagesReceived: 1, BytesReceived: 50},
+	}, bm.Traffic.Get())
+	require.Equal(MsgTypeTraffic{MessagesSent: 2, BytesSent: 500, MessagesReceived: 1, BytesReceived: 50},
+		bm.Traffic.Total())
+
+	// Historical data waits until what we sent would have been sent at the per-second cap.
+	require.Equal(5*time.Second, bm.reserveHistoricalUpload(100, now))
+	require.Equal(time.Second, bm.reserveHistoricalUpload(100, now.Add(4*time.Second)))
+	require.Equal(time.Duration(0), bm.reserveHistoricalUpload(100, now.Add(10*time.Second)))
+
+	// The bytes are reserved right away, so other peers waiting at the same time have to wait for them
+	// to be sent. Sending them doesn't count them against the per-second cap a second time.
+	require.Equal(time.Second, bm.reserveHistoricalUpload(100, now.Add(10*time.Second)))
+	bm.RecordSent(MsgTypeBlock, 100, now.Add(10*time.Second))
+	require.Equal(time.Second, bm.reserveHistoricalUpload(100, now.Add(10*time.Second)))
+	require.Equal(time.Duration(0), bm.reserveHistoricalUpload(100, now.Add(11*time.Second)))
+	bm.RecordSent(MsgTypeBlock, 150, now.Add(11*time.Second))
+	require.Equal(1500*time.Millisecond, bm.reserveHistoricalUpload(100, now.Add(11*time.Second)))
+
+	// Past the daily cap we stop serving historical data until the next cycle, even though new
+	// blocks and transactions keep counting.
+	require.False(bm.DailyUploadCapReached(now))
+	bm.RecordSent(MsgTypeTransactionBundle, 250, now.Add(10*time.Second))
+	require.True(bm.DailyUploadCapReached(now.Add(10 * time.Second)))
+	require.False(bm.WaitForHistoricalUpload(100, make(chan interface{})))
+	stats := bm.GetUploadStats(now.Add(UploadCapCycle - time.Second))
+	require.True(stats.DailyUploadCapReached)
+	require.Equal(uint64(1000), stats.BytesSentInCycle)
+	require.Equal(now.Add(UploadCapCycle), stats.CycleEnd)
+	require.False(bm.DailyUploadCapReached(now.Add(UploadCapCycle)))
+	stats = bm.GetUploadStats(now.Add(2*UploadCapCycle + time.Second))
+	require.Equal(uint64(0), stats.BytesSentInCycle)
+	require.Equal(now.Add(2*UploadCapCycle), stats.CycleStart)
+
+	// Without caps only the traffic is counted.
+	bm = NewBandwidthManager(0, 0, now)
+	require.False(bm.HasUploadCaps())
+	bm.RecordSent(MsgTypeBlock, 1<<40, now)
+	require.False(bm.DailyUploadCapReached(now))
+	require.True(bm.WaitForHistoricalUpload(100, make(chan interface{})))
+
+	// A nil manager and nil counters don't count anything.
+	var noBandwidth *BandwidthManager
+	noBandwidth.RecordSent(MsgTypeBlock, 100, now)
+	require.False(noBandwidth.HasUploadCaps())
+	require.True(noBandwidth.WaitForHistoricalUpload(100, make(chan interface{})))
+	var noCounters *TrafficCounters
+	noCounters.RecordReceived(MsgTypeBlock, 100)
+	require.Empty(noCounters.Get())
+}
+
+func TestQueuedBlocksToSend(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	var blockHashes []*BlockHash
+	for ii := 0; ii < 2; ii++ {
+		blk, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+		blockHash, err := blk.Hash()
+		require.NoError(err)
+		blockHashes = append(blockHashes, blockHash)
+	}
+	pp := &Peer{Params: params, quit: make(chan interface{})}
+	bm := NewBandwidthManager(0, 1000000, time.Now())
+
+	// Queued blocks are sent in the background, in the order they were requested, and the sender
+	// stops once the queue is empty.
+	pp.queueBlocksToSend(chain, bm, blockHashes)
+	pp.queueBlocksToSend(chain, bm, blockHashes[:1])
+	require.Eventually(func() bool { return !pp.hasQueuedBlocks() }, time.Second, 10*time.Millisecond)
+	for _, expectedHash := range []*BlockHash{blockHashes[0], blockHashes[1], blockHashes[0]} {
+		msg := pp.MaybeDequeueDeSoMessage()
+		require.NotNil(msg)
+		require.False(msg.Inbound)
+		blockHash, err := msg.DeSoMessage.(*MsgDeSoBlock).Hash()
+		require.NoError(err)
+		require.Equal(expectedHash, blockHash)
+	}
+	require.Nil(pp.MaybeDequeueDeSoMessage())
+}
@@ -0,0 +1,302 @@
+package lib
+
+import (
+	"math"
+	"time"
+)
+
+// block_download_scheduler.go spreads the blocks we fetch during sync across all of our sync
+// peers rather than only the SyncPeer. Blocks are handed out in ranges of consecutive heights,
+// and a block that doesn't show up in time is handed to a different peer. Blocks can therefore
+// arrive out of order, so the ones that arrive before their parent are held until the parent
+// is connected.
+//
+// The scheduler should only be accessed from the Server's messageHandler thread.
+
+const (
+	// BlockDownloadRangeSize is the number of consecutive blocks we assign to a peer before
+	// moving on to the next peer.
+	BlockDownloadRangeSize = 16
+
+	// BlockDownloadWindow is how many blocks past our block tip we fetch. It bounds the number
+	// of blocks we hold on to while they wait for their parent.
+	BlockDownloadWindow = 1024
+
+	// BlockDownloadStallTimeout is how long we wait for a block from a peer before we ask
+	// another peer for it.
+	BlockDownloadStallTimeout = 30 * time.Second
+
+	// BlockDownloadTickInterval is how often the Server checks for stalled block requests.
+	BlockDownloadTickInterval = 10 * time.Second
+)
+
+// BlockDownloadRequest is a block we've asked a peer for but haven't received yet.
+type BlockDownloadRequest struct {
+	Peer          *Peer
+	Height        uint32
+	TimeRequested time.Time
+}
+
+// BlockDownloadAssignment is a list of blocks a peer should be asked for, in height order.
+type BlockDownloadAssignment struct {
+	Peer       *Peer
+	BlockNodes []*BlockNode
+}
+
+// BufferedBlock is a block that arrived before its parent was connected.
+type BufferedBlock struct {
+	Peer      *Peer
+	Block     *MsgDeSoBlock
+	BlockHash *BlockHash
+}
+
+type BlockDownloadScheduler struct {
+	// peers are the peers we download blocks from, in the order they connected.
+	peers []*Peer
+	// inFlight has every block we're waiting on, across all peers. The blocks requested from
+	// a single peer are also kept in that peer's requestedBlocks.
+	inFlight map[BlockHash]*BlockDownloadRequest
+	// stalledPeers maps a block to the id of the peer that failed to deliver it in time, so
+	// that the block is assigned to a different peer.
+	stalledPeers map[BlockHash]uint64
+	// bufferedBlocks maps the hash of a parent block to its child that arrived first.
+	bufferedBlocks map[BlockHash]*BufferedBlock
+
+	stallTimeout time.Duration
+}
+
+func NewBlockDownloadScheduler(stallTimeout time.Duration) *BlockDownloadScheduler {
+	return &BlockDownloadScheduler{
+		inFlight:       make(map[BlockHash]*BlockDownloadRequest),
+		stalledPeers:   make(map[BlockHash]uint64),
+		bufferedBlocks: make(map[BlockHash]*BufferedBlock),
+		stallTimeout:   stallTimeout,
+	}
+}
+
+// AddPeer adds a sync candidate to the peers we download blocks from.
+func (scheduler *BlockDownloadScheduler) AddPeer(pp *Peer) {
+	for _, existingPeer := range scheduler.peers {
+		if existingPeer.ID == pp.ID {
+			return
+		}
+	}
+	scheduler.peers = append(scheduler.peers, pp)
+}
+
+// RemovePeer drops a disconnected peer. The blocks we were waiting on from it are released so
+// that they're assigned to other peers.
+func (scheduler *BlockDownloadScheduler) RemovePeer(pp *Peer) {
+	for ii, existingPeer := range scheduler.peers {
+		if existingPeer.ID == pp.ID {
+			scheduler.peers = append(scheduler.peers[:ii], scheduler.peers[ii+1:]...)
+			break
+		}
+	}
+	for blockHash, request := range scheduler.inFlight {
+		if request.Peer.ID == pp.ID {
+			delete(scheduler.inFlight, blockHash)
+		}
+	}
+}
+
+// NumPeers returns the number of peers we download blocks from.
+func (scheduler *BlockDownloadScheduler) NumPeers() int {
+	return len(scheduler.peers)
+}
+
+// BlocksToIgnore returns the blocks that shouldn't be requested again, either because a peer
+// is already sending them or because we're holding them until their parent is connected.
+func (scheduler *BlockDownloadScheduler) BlocksToIgnore() map[BlockHash]bool {
+	blocksToIgnore := make(map[BlockHash]bool)
+	for blockHash := range scheduler.inFlight {
+		blocksToIgnore[blockHash] = true
+	}
+	for _, bufferedBlock := range scheduler.bufferedBlocks {
+		blocksToIgnore[*bufferedBlock.BlockHash] = true
+	}
+	return blocksToIgnore
+}
+
+// Capacity returns the number of blocks we can request across all peers, with firstPeer
+// included even if it isn't one of our sync peers.
+func (scheduler *BlockDownloadScheduler) Capacity(firstPeer *Peer) int {
+	capacity := 0
+	for _, pp := range scheduler._candidatePeers(firstPeer) {
+		capacity += scheduler._peerCapacity(pp)
+	}
+	return capacity
+}
+
+// AssignBlocks splits blockNodes, which should be in height order, into ranges and assigns them
+// to peers with room in their in-flight list, starting with firstPeer. firstPeerMaxHeight limits
+// the blocks assigned to firstPeer, where a negative value means no limit. Other peers are only
+// assigned blocks up to the height they had when they connected. Blocks that no peer can take
+// are left unassigned. The assigned blocks are marked as in flight.
+func (scheduler *BlockDownloadScheduler) AssignBlocks(blockNodes []*BlockNode, firstPeer *Peer,
+	firstPeerMaxHeight int, now time.Time) []*BlockDownloadAssignment {
+
+	peers := scheduler._candidatePeers(firstPeer)
+	if len(peers) == 0 {
+		return nil
+	}
+	assignments := make([]*BlockDownloadAssignment, len(peers))
+	capacities := make([]int, len(peers))
+	maxHeights := make([]uint32, len(peers))
+	for ii, pp := range peers {
+		assignments[ii] = &BlockDownloadAssignment{Peer: pp}
+		capacities[ii] = scheduler._peerCapacity(pp)
+		maxHeights[ii] = pp.StartingBlockHeight()
+		if firstPeer != nil && pp.ID == firstPeer.ID {
+			maxHeights[ii] = math.MaxUint32
+			if firstPeerMaxHeight >= 0 {
+				maxHeights[ii] = uint32(firstPeerMaxHeight)
+			}
+		}
+	}
+
+	currentPeer := 0
+	numInCurrentRange := 0
+	for _, blockNode := range blockNodes {
+		if _, exists := scheduler.inFlight[*blockNode.Hash]; exists {
+			continue
+		}
+
+		// Find the first peer, starting with the one filling the current range, that can take
+		// the block. A peer that stalled on the block only gets it back if it's our only peer.
+		stalledPeerID, hasStalled := scheduler.stalledPeers[*blockNode.Hash]
+		assignedPeer := -1
+		for offset := 0; offset < len(peers); offset++ {
+			ii := (currentPeer + offset) % len(peers)
+			if capacities[ii] == 0 || blockNode.Height > maxHeights[ii] {
+				continue
+			}
+			if hasStalled && peers[ii].ID == stalledPeerID && len(peers) > 1 {
+				continue
+			}
+			assignedPeer = ii
+			break
+		}
+		if assignedPeer < 0 {
+			continue
+		}
+		if assignedPeer != currentPeer {
+			currentPeer = assignedPeer
+			numInCurrentRange = 0
+		}
+
+		pp := peers[assignedPeer]
+		assignments[assignedPeer].BlockNodes = append(assignments[assignedPeer].BlockNodes, blockNode)
+		capacities[assignedPeer]--
+		pp.requestedBlocks[*blockNode.Hash] = true
+		scheduler.inFlight[*blockNode.Hash] = &BlockDownloadRequest{
+			Peer:          pp,
+			Height:        blockNode.Height,
+			TimeRequested: now,
+		}
+
+		// Move on to the next peer once the range is full.
+		numInCurrentRange++
+		if numInCurrentRange >= BlockDownloadRangeSize || capacities[assignedPeer] == 0 {
+			currentPeer = (currentPeer + 1) % len(peers)
+			numInCurrentRange = 0
+		}
+	}
+
+	var nonEmptyAssignments []*BlockDownloadAssignment
+	for _, assignment := range assignments {
+		if len(assignment.BlockNodes) > 0 {
+			nonEmptyAssignments = append(nonEmptyAssignments, assignment)
+		}
+	}
+	return nonEmptyAssignments
+}
+
+// BlockReceived marks a block pp sent us as no longer in flight. It returns the request for the
+// block if it was in flight from pp, or nil otherwise. A block that's in flight from a different
+// peer stays in flight, so that a peer can't cancel requests that weren't made to it.
+func (scheduler *BlockDownloadScheduler) BlockReceived(pp *Peer, blockHash *BlockHash) *BlockDownloadRequest {
+	request, exists := scheduler.inFlight[*blockHash]
+	if !exists || pp == nil || request.Peer.ID != pp.ID {
+		return nil
+	}
+	delete(scheduler.inFlight, *blockHash)
+	delete(scheduler.stalledPeers, *blockHash)
+	return request
+}
+
+// StalledOnPeer returns true if we asked pp for the block but handed it to another peer because pp
+// didn't deliver it in time.
+func (scheduler *BlockDownloadScheduler) StalledOnPeer(pp *Peer, blockHash *BlockHash) bool {
+	stalledPeerID, exists := scheduler.stalledPeers[*blockHash]
+	return exists && pp != nil && stalledPeerID == pp.ID
+}
+
+// ReleaseStalledBlocks releases the blocks we've waited on for longer than the stall timeout,
+// so that they're assigned to other peers, and returns how many were released. We only do this
+// when we have more than one peer, since the peer's own stall detection deals with a single
+// peer that stopped responding.
+func (scheduler *BlockDownloadScheduler) ReleaseStalledBlocks(now time.Time) int {
+	if len(scheduler.peers) <= 1 {
+		return 0
+	}
+	numReleased := 0
+	for blockHash, request := range scheduler.inFlight {
+		if now.Sub(request.TimeRequested) < scheduler.stallTimeout {
+			continue
+		}
+		delete(scheduler.inFlight, blockHash)
+		delete(request.Peer.requestedBlocks, blockHash)
+		scheduler.stalledPeers[blockHash] = request.Peer.ID
+		numReleased++
+	}
+	return numReleased
+}
+
+// BufferBlock holds a block that arrived before its parent was connected. Only blocks that were in
+// flight from pp should be buffered, since blocks are keyed by their parent.
+func (scheduler *BlockDownloadScheduler) BufferBlock(pp *Peer, blk *MsgDeSoBlock, blockHash *BlockHash) {
+	scheduler.bufferedBlocks[*blk.Header.PrevBlockHash] = &BufferedBlock{
+		Peer:      pp,
+		Block:     blk,
+		BlockHash: blockHash,
+	}
+}
+
+// PopBufferedChild returns and removes the buffered child of a block, or nil if there isn't one.
+func (scheduler *BlockDownloadScheduler) PopBufferedChild(parentHash *BlockHash) *BufferedBlock {
+	bufferedBlock, exists := scheduler.bufferedBlocks[*parentHash]
+	if !exists {
+		return nil
+	}
+	delete(scheduler.bufferedBlocks, *parentHash)
+	return bufferedBlock
+}
+
+// NumBufferedBlocks returns the number of blocks waiting on their parent.
+func (scheduler *BlockDownloadScheduler) NumBufferedBlocks() int {
+	return len(scheduler.bufferedBlocks)
+}
+
+// _candidatePeers returns firstPeer followed by the rest of our sync peers that are still connected.
+func (scheduler *BlockDownloadScheduler) _candidatePeers(firstPeer *Peer) []*Peer {
+	var peers []*Peer
+	if firstPeer != nil {
+		peers = append(peers, firstPeer)
+	}
+	for _, pp := range scheduler.peers {
+		if (firstPeer != nil && pp.ID == firstPeer.ID) || pp.disconnected != 0 {
+			continue
+		}
+		peers = append(peers, pp)
+	}
+	return peers
+}
+
+func (scheduler *BlockDownloadScheduler) _peerCapacity(pp *Peer) int {
+	capacity := MaxBlocksInFlight - len(pp.requestedBlocks)
+	if capacity < 0 {
+		return 0
+	}
+	return capacity
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func _newBlockDownloadTestPeer(id uint64, startingHeight uint32) *Peer {
+	return &Peer{
+		ID:              id,
+		startingHeight:  startingHeight,
+		requestedBlocks: make(map[BlockHash]bool),
+	}
+}
+
+func _newBlockDownloadTestNodes(numNodes int) []*BlockNode {
+	var blockNodes []*BlockNode
+	for ii := 1; ii <= numNodes; ii++ {
+		hash := &BlockHash{}
+		hash[0] = byte(ii)
+		hash[1] = byte(ii >> 8)
+		blockNodes = append(blockNodes, &BlockNode{
+			Hash:   hash,
+			Height: uint32(ii),
+		})
+	}
+	return blockNodes
+}
+
+func _blockDownloadAssignmentHeights(assignment *BlockDownloadAssignment) []uint32 {
+	var heights []uint32
+	for _, blockNode := range assignment.BlockNodes {
+		heights = append(heights, blockNode.Height)
+	}
+	return heights
+}
+
+func TestBlockDownloadSchedulerAssignment(t *testing.T) {
+	require := require.New(t)
+
+	scheduler := NewBlockDownloadScheduler(time.Minute)
+	syncPeer := _newBlockDownloadTestPeer(1, 10)
+	otherPeer := _newBlockDownloadTestPeer(2, 100)
+	behindPeer := _newBlockDownloadTestPeer(3, 0)
+	for _, pp := range []*Peer{syncPeer, otherPeer, behindPeer} {
+		scheduler.AddPeer(pp)
+	}
+	require.Equal(3*MaxBlocksInFlight, scheduler.Capacity(syncPeer))
+
+	// Ranges alternate between the peers that have the blocks. The sync peer isn't limited by its
+	// starting height when maxHeight is -1, and the peer that's behind gets nothing.
+	blockNodes := _newBlockDownloadTestNodes(2*BlockDownloadRangeSize + 8)
+	now := time.Now()
+	assignments := scheduler.AssignBlocks(blockNodes, syncPeer, -1, now)
+	require.Len(assignments, 2)
+	require.Equal(syncPeer, assignments[0].Peer)
+	require.Equal(otherPeer, assignments[1].Peer)
+	require.Len(assignments[0].BlockNodes, BlockDownloadRangeSize+8)
+	require.Len(assignments[1].BlockNodes, BlockDownloadRangeSize)
+	require.Equal(uint32(BlockDownloadRangeSize+1), assignments[1].BlockNodes[0].Height)
+	require.Equal(uint32(2*BlockDownloadRangeSize+1), assignments[0].BlockNodes[BlockDownloadRangeSize].Height)
+	require.Len(syncPeer.requestedBlocks, BlockDownloadRangeSize+8)
+	require.Len(otherPeer.requestedBlocks, BlockDownloadRangeSize)
+	require.Len(scheduler.BlocksToIgnore(), len(blockNodes))
+
+	// Blocks in flight aren't assigned twice.
+	require.Empty(scheduler.AssignBlocks(blockNodes, syncPeer, -1, now))
+
+	// A block is only received from the peer we asked for it.
+	require.Nil(scheduler.BlockReceived(otherPeer, blockNodes[0].Hash))
+	require.Len(scheduler.BlocksToIgnore(), len(blockNodes))
+
+	// Stalled blocks are released and go to a different peer.
+	require.NotNil(scheduler.BlockReceived(syncPeer, blockNodes[0].Hash))
+	delete(syncPeer.requestedBlocks, *blockNodes[0].Hash)
+	require.Equal(0, scheduler.ReleaseStalledBlocks(now.Add(30*time.Second)))
+	require.Equal(len(blockNodes)-1, scheduler.ReleaseStalledBlocks(now.Add(time.Minute)))
+	require.Empty(syncPeer.requestedBlocks)
+	require.Empty(otherPeer.requestedBlocks)
+	require.True(scheduler.StalledOnPeer(syncPeer, blockNodes[1].Hash))
+	require.False(scheduler.StalledOnPeer(otherPeer, blockNodes[1].Hash))
+	assignments = scheduler.AssignBlocks(blockNodes[1:], syncPeer, -1, now)
+	require.Len(assignments, 2)
+	for _, assignment := range assignments {
+		for _, height := range _blockDownloadAssignmentHeights(assignment) {
+			wasOnSyncPeer := height <= BlockDownloadRangeSize || height > 2*BlockDownloadRangeSize
+			require.Equal(wasOnSyncPeer, assignment.Peer == otherPeer)
+		}
+	}
+
+	// A peer that disconnects releases its blocks.
+	scheduler.RemovePeer(otherPeer)
+	require.Equal(2, scheduler.NumPeers())
+	require.Len(scheduler.BlocksToIgnore(), len(syncPeer.requestedBlocks))
+}
+
+func TestBlockDownloadSchedulerBufferedBlocks(t *testing.T) {
+	require := require.New(t)
+
+	scheduler := NewBlockDownloadScheduler(time.Minute)
+	pp := _newBlockDownloadTestPeer(1, 10)
+	blockNodes := _newBlockDownloadTestNodes(3)
+	scheduler.AssignBlocks(blockNodes, pp, -1, time.Now())
+
+	// The last block arrives first and waits on its parent.
+	childBlock := &MsgDeSoBlock{Header: &MsgDeSoHeader{PrevBlockHash: blockNodes[1].Hash}}
+	require.NotNil(scheduler.BlockReceived(pp, blockNodes[2].Hash))
+	scheduler.BufferBlock(pp, childBlock, blockNodes[2].Hash)
+	require.Equal(1, scheduler.NumBufferedBlocks())
+	require.True(scheduler.BlocksToIgnore()[*blockNodes[2].Hash])
+
+	require.Nil(scheduler.PopBufferedChild(blockNodes[0].Hash))
+	bufferedBlock := scheduler.PopBufferedChild(blockNodes[1].Hash)
+	require.NotNil(bufferedBlock)
+	require.Equal(childBlock, bufferedBlock.Block)
+	require.Equal(blockNodes[2].Hash, bufferedBlock.BlockHash)
+	require.Equal(0, scheduler.NumBufferedBlocks())
+}
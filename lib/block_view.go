@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/btcec"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
@@ -89,14 +88,24 @@ type UtxoView struct {
 	// DAO coin limit order entry mapping.
 	DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderEntry
 
+	// Entries of all the DBIndexedEntryTypes.
+	IndexedEntries map[IndexedEntryMapKey]*IndexedEntry
+
 	// The hash of the tip the view is currently referencing. Mainly used
 	// for error-checking when doing a bulk operation on the view.
 	TipHash *BlockHash
 
-	Handle   *badger.DB
+	Handle   KVStore
 	Postgres *Postgres
 	Params   *DeSoParams
 	Snapshot *Snapshot
+
+	// If the view was created with NewChildUtxoView, parent is the view it's layered on
+	// top of. The mappings of a child view only contain its own entries, and lookups of
+	// entries it doesn't have fall through to the parent before hitting the db.
+	parent *UtxoView
+	// The mappings that were already fully copied from the parent, see _pullParentMappings.
+	pulledParentMappings map[*utxoViewMapping]bool
 }
 
 // Assumes the db Handle is already set on the view, but otherwise the
@@ -105,13 +114,13 @@ func (bav *UtxoView) _ResetViewMappingsAfterFlush() {
 	// Utxo data
 	bav.UtxoKeyToUtxoEntry = make(map[UtxoKey]*UtxoEntry)
 	// TODO: Deprecate this value
-	bav.NumUtxoEntries = GetUtxoNumEntries(bav.Handle, bav.Snapshot)
+	bav.NumUtxoEntries = bav.GetDbAdapter().GetUtxoNumEntries()
 	bav.PublicKeyToDeSoBalanceNanos = make(map[PublicKey]uint64)
 
 	// BitcoinExchange data
-	bav.NanosPurchased = DbGetNanosPurchased(bav.Handle, bav.Snapshot)
-	bav.USDCentsPerBitcoin = DbGetUSDCentsPerBitcoinExchangeRate(bav.Handle, bav.Snapshot)
-	bav.GlobalParamsEntry = DbGetGlobalParamsEntry(bav.Handle, bav.Snapshot)
+	bav.NanosPurchased = bav.GetDbAdapter().GetNanosPurchased()
+	bav.USDCentsPerBitcoin = bav.GetDbAdapter().GetUSDCentsPerBitcoinExchangeRate()
+	bav.GlobalParamsEntry = bav.GetDbAdapter().GetGlobalParamsEntry()
 	bav.BitcoinBurnTxIDs = make(map[BlockHash]bool)
 
 	// Forbidden block signature pub key info.
@@ -159,6 +168,9 @@ func (bav *UtxoView) _ResetViewMappingsAfterFlush() {
 
 	// DAO Coin Limit Order Entries
 	bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry = make(map[DAOCoinLimitOrderMapKey]*DAOCoinLimitOrderEntry)
+
+	// Indexed entries
+	bav.IndexedEntries = make(map[IndexedEntryMapKey]*IndexedEntry)
 }
 
 func (bav *UtxoView) CopyUtxoView() (*UtxoView, error) {
@@ -353,11 +365,218 @@ func (bav *UtxoView) CopyUtxoView() (*UtxoView, error) {
 		newEntry := *entry
 		newView.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[entryKey] = &newEntry
 	}
+
+	// Copy the indexed entries. The entries themselves are never modified in place, so they can be shared.
+	newView.IndexedEntries = make(map[IndexedEntryMapKey]*IndexedEntry, len(bav.IndexedEntries))
+	for entryKey, entry := range bav.IndexedEntries {
+		newEntry := *entry
+		newView.IndexedEntries[entryKey] = &newEntry
+	}
 	return newView, nil
 }
 
+// NewChildUtxoView creates a view that's layered on top of bav. Unlike CopyUtxoView, it doesn't copy
+// any of the parent's mappings. The child only records its own changes, and when it looks up an entry
+// it doesn't have, it copies it from the closest parent that has it before falling back to the db. The
+// parents are never modified. This makes it cheap to try connecting a transaction on top of a view with
+// a lot of entries, e.g. the mempool's universal view: if the transaction fails, the child can simply be
+// dropped, and if it succeeds, it can be merged into the parent with CommitToParent.
+//
+// Note that the mappings of a child only contain the entries it has looked up, so they shouldn't be
+// read directly. The parent must not be modified while the child is in use, but multiple children can
+// read from the same parent concurrently.
+func (bav *UtxoView) NewChildUtxoView() *UtxoView {
+	childView := &UtxoView{
+		NumUtxoEntries:     bav.NumUtxoEntries,
+		NanosPurchased:     bav.NanosPurchased,
+		USDCentsPerBitcoin: bav.USDCentsPerBitcoin,
+		TipHash:            bav.TipHash,
+
+		Handle:   bav.Handle,
+		Postgres: bav.Postgres,
+		Params:   bav.Params,
+		Snapshot: bav.Snapshot,
+
+		parent:               bav,
+		pulledParentMappings: make(map[*utxoViewMapping]bool),
+	}
+	newGlobalParamsEntry := *bav.GlobalParamsEntry
+	childView.GlobalParamsEntry = &newGlobalParamsEntry
+
+	childValue := reflect.ValueOf(childView).Elem()
+	for _, fieldIndex := range _utxoViewMappingFieldIndices {
+		mapping := childValue.Field(fieldIndex)
+		mapping.Set(reflect.MakeMap(mapping.Type()))
+	}
+	return childView
+}
+
+// CommitToParent merges the changes recorded in a child view into its parent. The child shouldn't be
+// used after it's committed.
+func (bav *UtxoView) CommitToParent() error {
+	if bav.parent == nil {
+		return fmt.Errorf("CommitToParent: View doesn't have a parent")
+	}
+	parent := bav.parent
+
+	childValue := reflect.ValueOf(bav).Elem()
+	parentValue := reflect.ValueOf(parent).Elem()
+	for _, fieldIndex := range _utxoViewMappingFieldIndices {
+		parentMapping := parentValue.Field(fieldIndex)
+		iter := childValue.Field(fieldIndex).MapRange()
+		for iter.Next() {
+			parentMapping.SetMapIndex(iter.Key(), iter.Value())
+		}
+	}
+	parent.NumUtxoEntries = bav.NumUtxoEntries
+	parent.NanosPurchased = bav.NanosPurchased
+	parent.USDCentsPerBitcoin = bav.USDCentsPerBitcoin
+	parent.GlobalParamsEntry = bav.GlobalParamsEntry
+	parent.TipHash = bav.TipHash
+
+	bav.parent = nil
+	return nil
+}
+
+// _utxoViewMappingFieldIndices holds the indices of all the UtxoView fields that map keys to entries.
+// Child views handle all of them generically so that new mappings automatically work with layering.
+var _utxoViewMappingFieldIndices = func() []int {
+	var fieldIndices []int
+	viewType := reflect.TypeOf(UtxoView{})
+	for ii := 0; ii < viewType.NumField(); ii++ {
+		field := viewType.Field(ii)
+		// Unexported maps, such as pulledParentMappings, aren't entry mappings.
+		if field.Type.Kind() == reflect.Map && field.PkgPath == "" {
+			fieldIndices = append(fieldIndices, ii)
+		}
+	}
+	return fieldIndices
+}()
+
+// _copyParentEntry returns a copy of an entry held by a parent view, so that the child can modify it.
+// Entries that point to a slice get their own backing array, so that appending in the child can't
+// write into the parent's.
+func _copyParentEntry(entry reflect.Value) reflect.Value {
+	if entry.Kind() != reflect.Ptr || entry.IsNil() {
+		return entry
+	}
+	entryCopy := reflect.New(entry.Type().Elem())
+	if entry.Elem().Kind() == reflect.Slice && !entry.Elem().IsNil() {
+		sliceCopy := reflect.MakeSlice(entry.Elem().Type(), entry.Elem().Len(), entry.Elem().Len())
+		reflect.Copy(sliceCopy, entry.Elem())
+		entryCopy.Elem().Set(sliceCopy)
+	} else {
+		entryCopy.Elem().Set(entry.Elem())
+	}
+	return entryCopy
+}
+
+// utxoViewMapping is one of the UtxoView mappings that child views pull from their parents. get returns
+// the mapping of a view, so that the compiler catches a mapping that was renamed or misspelled.
+type utxoViewMapping struct {
+	get func(bav *UtxoView) interface{}
+}
+
+var (
+	mappingUtxoKeyToUtxoEntry                              = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.UtxoKeyToUtxoEntry }}
+	mappingPublicKeyToDeSoBalanceNanos                     = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.PublicKeyToDeSoBalanceNanos }}
+	mappingBitcoinBurnTxIDs                                = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.BitcoinBurnTxIDs }}
+	mappingForbiddenPubKeyToForbiddenPubKeyEntry           = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.ForbiddenPubKeyToForbiddenPubKeyEntry }}
+	mappingMessageKeyToMessageEntry                        = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.MessageKeyToMessageEntry }}
+	mappingMessagingGroupKeyToMessagingGroupEntry          = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.MessagingGroupKeyToMessagingGroupEntry }}
+	mappingMessageMap                                      = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.MessageMap }}
+	mappingFollowKeyToFollowEntry                          = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.FollowKeyToFollowEntry }}
+	mappingNFTKeyToNFTEntry                                = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.NFTKeyToNFTEntry }}
+	mappingNFTBidKeyToNFTBidEntry                          = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.NFTBidKeyToNFTBidEntry }}
+	mappingNFTKeyToAcceptedNFTBidHistory                   = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.NFTKeyToAcceptedNFTBidHistory }}
+	mappingDiamondKeyToDiamondEntry                        = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.DiamondKeyToDiamondEntry }}
+	mappingLikeKeyToLikeEntry                              = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.LikeKeyToLikeEntry }}
+	mappingRepostKeyToRepostEntry                          = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.RepostKeyToRepostEntry }}
+	mappingPostHashToPostEntry                             = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.PostHashToPostEntry }}
+	mappingPublicKeyToPKIDEntry                            = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.PublicKeyToPKIDEntry }}
+	mappingPKIDToPublicKey                                 = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.PKIDToPublicKey }}
+	mappingProfilePKIDToProfileEntry                       = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.ProfilePKIDToProfileEntry }}
+	mappingProfileUsernameToProfileEntry                   = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.ProfileUsernameToProfileEntry }}
+	mappingHODLerPKIDCreatorPKIDToBalanceEntry             = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.HODLerPKIDCreatorPKIDToBalanceEntry }}
+	mappingHODLerPKIDCreatorPKIDToDAOCoinBalanceEntry      = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry }}
+	mappingDerivedKeyToDerivedEntry                        = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.DerivedKeyToDerivedEntry }}
+	mappingDAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry }}
+	mappingIndexedEntries                                  = &utxoViewMapping{func(bav *UtxoView) interface{} { return bav.IndexedEntries }}
+)
+
+// _utxoViewMappings lists all of the mappings, which must cover every mapping field of the UtxoView.
+var _utxoViewMappings = []*utxoViewMapping{
+	mappingUtxoKeyToUtxoEntry,
+	mappingPublicKeyToDeSoBalanceNanos,
+	mappingBitcoinBurnTxIDs,
+	mappingForbiddenPubKeyToForbiddenPubKeyEntry,
+	mappingMessageKeyToMessageEntry,
+	mappingMessagingGroupKeyToMessagingGroupEntry,
+	mappingMessageMap,
+	mappingFollowKeyToFollowEntry,
+	mappingNFTKeyToNFTEntry,
+	mappingNFTBidKeyToNFTBidEntry,
+	mappingNFTKeyToAcceptedNFTBidHistory,
+	mappingDiamondKeyToDiamondEntry,
+	mappingLikeKeyToLikeEntry,
+	mappingRepostKeyToRepostEntry,
+	mappingPostHashToPostEntry,
+	mappingPublicKeyToPKIDEntry,
+	mappingPKIDToPublicKey,
+	mappingProfilePKIDToProfileEntry,
+	mappingProfileUsernameToProfileEntry,
+	mappingHODLerPKIDCreatorPKIDToBalanceEntry,
+	mappingHODLerPKIDCreatorPKIDToDAOCoinBalanceEntry,
+	mappingDerivedKeyToDerivedEntry,
+	mappingDAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry,
+	mappingIndexedEntries,
+}
+
+// _pullParentMapping looks up a key in one of the mappings of the parent views, closest parent first.
+// If an entry is found, a copy of it is set in the view's own mapping and returned. Getters call this
+// before falling back to the db, which makes reads fall through the layers.
+func (bav *UtxoView) _pullParentMapping(mapping *utxoViewMapping, key interface{}) (interface{}, bool) {
+	if bav.parent == nil || bav.pulledParentMappings[mapping] {
+		return nil, false
+	}
+	keyValue := reflect.ValueOf(key)
+	for parent := bav.parent; parent != nil; parent = parent.parent {
+		entry := reflect.ValueOf(mapping.get(parent)).MapIndex(keyValue)
+		if !entry.IsValid() {
+			continue
+		}
+		entryCopy := _copyParentEntry(entry)
+		reflect.ValueOf(mapping.get(bav)).SetMapIndex(keyValue, entryCopy)
+		return entryCopy.Interface(), true
+	}
+	return nil, false
+}
+
+// _pullParentMappings copies all of the parents' entries of a mapping into the view, without overwriting
+// the entries the view already has. Functions that iterate over a whole mapping call this first so that
+// they see the entries of all the layers. Each mapping is only pulled once.
+func (bav *UtxoView) _pullParentMappings(mapping *utxoViewMapping) {
+	if bav.parent == nil || bav.pulledParentMappings[mapping] {
+		return
+	}
+	viewMapping := reflect.ValueOf(mapping.get(bav))
+	for parent := bav.parent; parent != nil; parent = parent.parent {
+		iter := reflect.ValueOf(mapping.get(parent)).MapRange()
+		for iter.Next() {
+			if !viewMapping.MapIndex(iter.Key()).IsValid() {
+				viewMapping.SetMapIndex(iter.Key(), _copyParentEntry(iter.Value()))
+			}
+		}
+		// A parent that already pulled this mapping has all the entries of the layers above it.
+		if parent.pulledParentMappings[mapping] {
+			break
+		}
+	}
+	bav.pulledParentMappings[mapping] = true
+}
+
 func NewUtxoView(
-	_handle *badger.DB,
+	_handle KVStore,
 	_params *DeSoParams,
 	_postgres *Postgres,
 	_snapshot *Snapshot,
@@ -434,14 +653,15 @@ func (bav *UtxoView) GetUtxoEntryForUtxoKey(utxoKeyArg *UtxoKey) *UtxoEntry {
 	}
 
 	utxoEntry, ok := bav.UtxoKeyToUtxoEntry[*utxoKey]
+	if !ok {
+		if parentEntry, exists := bav._pullParentMapping(mappingUtxoKeyToUtxoEntry, *utxoKey); exists {
+			return parentEntry.(*UtxoEntry)
+		}
+	}
 	// If the utxo entry isn't in our in-memory data structure, fetch it from the
 	// db.
 	if !ok {
-		if bav.Postgres != nil {
-			utxoEntry = bav.Postgres.GetUtxoEntryForUtxoKey(utxoKey)
-		} else {
-			utxoEntry = DbGetUtxoEntryForUtxoKey(bav.Handle, bav.Snapshot, utxoKey)
-		}
+		utxoEntry = bav.GetDbAdapter().GetUtxoEntryForUtxoKey(utxoKey)
 		if utxoEntry == nil {
 			// This means the utxo is neither in our map nor in the db so
 			// it doesn't exist. Return nil to signal that in this case.
@@ -469,16 +689,14 @@ func (bav *UtxoView) GetDeSoBalanceNanosForPublicKey(publicKeyArg []byte) (uint6
 	if hasBalance {
 		return balanceNanos, nil
 	}
+	if parentBalance, exists := bav._pullParentMapping(mappingPublicKeyToDeSoBalanceNanos, *NewPublicKey(publicKey)); exists {
+		return parentBalance.(uint64), nil
+	}
 
 	// If the utxo entry isn't in our in-memory data structure, fetch it from the db.
-	if bav.Postgres != nil {
-		balanceNanos = bav.Postgres.GetBalance(NewPublicKey(publicKey))
-	} else {
-		var err error
-		balanceNanos, err = DbGetDeSoBalanceNanosForPublicKey(bav.Handle, bav.Snapshot, publicKey)
-		if err != nil {
-			return uint64(0), errors.Wrap(err, "GetDeSoBalanceNanosForPublicKey: ")
-		}
+	balanceNanos, err := bav.GetDbAdapter().GetDeSoBalanceNanosForPublicKey(publicKey)
+	if err != nil {
+		return uint64(0), errors.Wrap(err, "GetDeSoBalanceNanosForPublicKey: ")
 	}
 
 	// Add the balance to memory for future references.
@@ -1113,13 +1331,13 @@ func _isEntryImmatureBlockReward(utxoEntry *UtxoEntry, blockHeight uint32, param
 	return false
 }
 
-func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_derivedPkBytes []byte, _err error) {
-	// Compute a hash of the transaction.
-	txBytes, err := txn.ToBytes(true /*preSignature*/)
-	if err != nil {
-		return nil, errors.Wrapf(err, "_verifySignature: Problem serializing txn without signature: ")
-	}
-	txHash := Sha256DoubleHash(txBytes)
+// _verifySignature checks that the txn is signed by its owner or by an authorized derived key. The
+// txHash is the hash of the full txn and is used to skip the ECDSA check for signatures that are
+// already in the signature cache, see PreVerifyTxnSignatures.
+func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, txHash *BlockHash, blockHeight uint32) (
+	_derivedPkBytes []byte, _err error) {
+
+	var err error
 
 	// Look for the derived key in transaction ExtraData and validate it. For transactions
 	// signed using a derived key, the derived public key is passed to ExtraData.
@@ -1143,7 +1361,11 @@ func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_der
 	// If derived key is present in ExtraData, we check if transaction was signed by the derived key.
 	if derivedPk == nil {
 		// Verify that the transaction is signed by the specified key.
-		if txn.Signature.Verify(txHash[:], ownerPk) {
+		isValid, err := _verifyTxnSignatureWithCache(txn, txHash, ownerPkBytes, ownerPk)
+		if err != nil {
+			return nil, errors.Wrapf(err, "_verifySignature: Problem serializing txn without signature: ")
+		}
+		if isValid {
 			return nil, nil
 		}
 	} else {
@@ -1182,7 +1404,11 @@ func (bav *UtxoView) _verifySignature(txn *MsgDeSoTxn, blockHeight uint32) (_der
 		}
 
 		// All checks passed so we try to verify the signature.
-		if txn.Signature.Verify(txHash[:], derivedPk) {
+		isValid, err := _verifyTxnSignatureWithCache(txn, txHash, derivedPkBytes, derivedPk)
+		if err != nil {
+			return nil, errors.Wrapf(err, "_verifySignature: Problem serializing txn without signature: ")
+		}
+		if isValid {
 			return derivedPk.SerializeCompressed(), nil
 		}
 
@@ -1471,7 +1697,7 @@ func (bav *UtxoView) _connectBasicTransfer(
 				return 0, 0, nil, RuleErrorBlockRewardTxnNotAllowedToHaveSignature
 			}
 		} else {
-			derivedPkBytes, err := bav._verifySignature(txn, blockHeight)
+			derivedPkBytes, err := bav._verifySignature(txn, txHash, blockHeight)
 			if err != nil {
 				return 0, 0, nil, errors.Wrapf(err, "_connectBasicTransfer: Problem verifying txn signature: ")
 			}
@@ -2075,6 +2301,9 @@ func (bav *UtxoView) _connectUpdateGlobalParams(
 		// If there is already an entry on the view for this pub key, save it.
 		if val, ok := bav.ForbiddenPubKeyToForbiddenPubKeyEntry[MakePkMapKey(forbiddenPubKey)]; ok {
 			prevForbiddenPubKeyEntry = val
+		} else if parentVal, exists := bav._pullParentMapping(
+			mappingForbiddenPubKeyToForbiddenPubKeyEntry, MakePkMapKey(forbiddenPubKey)); exists {
+			prevForbiddenPubKeyEntry = parentVal.(*ForbiddenPubKeyEntry)
 		}
 
 		newForbiddenPubKeyEntry = &ForbiddenPubKeyEntry{
@@ -2383,6 +2612,14 @@ func (bav *UtxoView) ConnectBlock(
 	}
 
 	blockHeader := desoBlock.Header
+
+	// Verify all the signatures in the block up front on all cores. The results land in
+	// the signature cache so ConnectTransaction below only has to check derived key
+	// authorizations, which depend on the state and have to be done in order.
+	if verifySignatures {
+		PreVerifyTxnSignatures(desoBlock.Txns, txHashes)
+	}
+
 	// Loop through all the transactions and validate them using the view. Also
 	// keep track of the total fees throughout.
 	var totalFees uint64
@@ -2474,16 +2711,20 @@ func (bav *UtxoView) ConnectBlock(
 }
 
 // Preload tries to fetch all the relevant data needed to connect a block
-// in batches from Postgres. It marks many objects as "nil" in the respective
-// data structures and then fills in the objects it is able to retrieve from
-// the database. It's much faster to fetch data in bulk and cache "nil" values
-// then to query individual records when connecting every transaction. If something
-// is not preloaded the view falls back to individual queries.
+// in batches from Postgres. It's much faster to fetch data in bulk than to query
+// individual records when connecting every transaction. Balances that don't exist
+// are cached as empty entries, just like a badger lookup would return them. Other
+// records that don't exist aren't cached, and neither is anything that isn't
+// preloaded, so the view falls back to individual queries for them.
 func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error {
-	// We can only preload if we're using postgres
-	if bav.Postgres == nil {
+	// Child views don't preload because the entries they don't have come from their parent
+	// rather than the db.
+	if bav.parent != nil {
 		return nil
 	}
+	if bav.Postgres == nil {
+		return bav._preloadFromDb(desoBlock)
+	}
 
 	// One iteration for all the PKIDs
 	// NOTE: Work in progress. Testing with follows for now.
@@ -2516,9 +2757,6 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 
 			// Set pkid entries for all the public keys
 			bav._setPKIDMappings(pkidEntry)
-
-			// Set nil profile entries
-			bav.ProfilePKIDToProfileEntry[*pkidEntry.PKID] = nil
 		}
 
 		// Set real entries for all the profiles that actually exist
@@ -2556,10 +2794,6 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 				FollowedPKID: bav.GetPKIDForPublicKey(txnMeta.FollowedPublicKey).PKID.NewPKID(),
 			}
 			follows = append(follows, follow)
-
-			// We cache the follow as not present and then fill them in later
-			followerKey := MakeFollowKey(follow.FollowerPKID, follow.FollowedPKID)
-			bav.FollowKeyToFollowEntry[followerKey] = nil
 		} else if txn.TxnMeta.GetTxnType() == TxnTypeCreatorCoin {
 			txnMeta := txn.TxnMeta.(*CreatorCoinMetadataa)
 
@@ -2570,9 +2804,8 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 			}
 			balances = append(balances, balance)
 
-			// We cache the balances as not present and then fill them in later
-			balanceEntryKey := MakeBalanceEntryKey(balance.HolderPKID, balance.CreatorPKID)
-			bav.HODLerPKIDCreatorPKIDToBalanceEntry[balanceEntryKey] = nil
+			// We cache the balances as empty and then fill them in later
+			bav._setCreatorCoinBalanceEntryMappings(_newEmptyBalanceEntry(balance.HolderPKID, balance.CreatorPKID))
 
 			// Fetch the creator's balance entry if they're not buying their own coin
 			if !reflect.DeepEqual(txn.PublicKey, txnMeta.ProfilePublicKey) {
@@ -2582,9 +2815,8 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 				}
 				balances = append(balances, balance)
 
-				// We cache the balances as not present and then fill them in later
-				balanceEntryKey = MakeBalanceEntryKey(balance.HolderPKID, balance.CreatorPKID)
-				bav.HODLerPKIDCreatorPKIDToBalanceEntry[balanceEntryKey] = nil
+				// We cache the balances as empty and then fill them in later
+				bav._setCreatorCoinBalanceEntryMappings(_newEmptyBalanceEntry(balance.HolderPKID, balance.CreatorPKID))
 			}
 		} else if txn.TxnMeta.GetTxnType() == TxnTypeDAOCoin {
 			txnMeta := txn.TxnMeta.(*DAOCoinMetadata)
@@ -2596,9 +2828,8 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 			}
 			daoBalances = append(daoBalances, daoBalance)
 
-			// We cache the balances as not present and then fill them in later
-			balanceEntryKey := MakeBalanceEntryKey(daoBalance.HolderPKID, daoBalance.CreatorPKID)
-			bav.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry[balanceEntryKey] = nil
+			// We cache the balances as empty and then fill them in later
+			bav._setDAOCoinBalanceEntryMappings(_newEmptyBalanceEntry(daoBalance.HolderPKID, daoBalance.CreatorPKID))
 
 			// Fetch the creator's balance entry if they're not buying their own coin
 			if !reflect.DeepEqual(txn.PublicKey, txnMeta.ProfilePublicKey) {
@@ -2608,9 +2839,8 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 				}
 				daoBalances = append(daoBalances, daoBalance)
 
-				// We cache the balances as not present and then fill them in later
-				balanceEntryKey = MakeBalanceEntryKey(daoBalance.HolderPKID, daoBalance.CreatorPKID)
-				bav.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry[balanceEntryKey] = nil
+				// We cache the balances as empty and then fill them in later
+				bav._setDAOCoinBalanceEntryMappings(_newEmptyBalanceEntry(daoBalance.HolderPKID, daoBalance.CreatorPKID))
 			}
 		} else if txn.TxnMeta.GetTxnType() == TxnTypeLike {
 			txnMeta := txn.TxnMeta.(*LikeMetadata)
@@ -2620,17 +2850,10 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 			}
 			likes = append(likes, like)
 
-			// We cache the likes as not present and then fill them in later
-			likeKey := MakeLikeKey(like.LikerPublicKey, *like.LikedPostHash)
-			bav.LikeKeyToLikeEntry[likeKey] = nil
-
 			post := &PGPost{
 				PostHash: txnMeta.LikedPostHash.NewBlockHash(),
 			}
 			posts = append(posts, post)
-
-			// We cache the posts as not present and then fill them in later
-			bav.PostHashToPostEntry[*post.PostHash] = nil
 		} else if txn.TxnMeta.GetTxnType() == TxnTypeSubmitPost {
 			txnMeta := txn.TxnMeta.(*SubmitPostMetadata)
 
@@ -2645,9 +2868,6 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 				PostHash: postHash,
 			})
 
-			// We cache the posts as not present and then fill them in later
-			bav.PostHashToPostEntry[*postHash] = nil
-
 			// TODO: Preload parent, grandparent, and reposted posts
 		} else if txn.TxnMeta.GetTxnType() == TxnTypeUpdateProfile {
 			txnMeta := txn.TxnMeta.(*UpdateProfileMetadata)
@@ -2656,9 +2876,6 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 			}
 
 			lowercaseUsernames = append(lowercaseUsernames, strings.ToLower(string(txnMeta.NewUsername)))
-
-			// We cache the profiles as not present and then fill them in later
-			bav.ProfileUsernameToProfileEntry[MakeUsernameMapKey(txnMeta.NewUsername)] = nil
 		}
 	}
 
@@ -2707,7 +2924,7 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 	if len(posts) > 0 {
 		foundPosts := bav.Postgres.GetPosts(posts)
 		for _, post := range foundPosts {
-			bav.setPostMappings(post)
+			bav._setPostEntryMappings(post.NewPostEntry())
 		}
 	}
 
@@ -2734,13 +2951,7 @@ func (bav *UtxoView) Preload(desoBlock *MsgDeSoBlock, blockHeight uint64) error
 func (bav *UtxoView) GetUnspentUtxoEntrysForPublicKey(pkBytes []byte) ([]*UtxoEntry, error) {
 	// Fetch the relevant utxos for this public key from the db. We do this because
 	// the db could contain utxos that are not currently loaded into the view.
-	var utxoEntriesForPublicKey []*UtxoEntry
-	var err error
-	if bav.Postgres != nil {
-		utxoEntriesForPublicKey = bav.Postgres.GetUtxoEntriesForPublicKey(pkBytes)
-	} else {
-		utxoEntriesForPublicKey, err = DbGetUtxosForPubKey(pkBytes, bav.Handle, bav.Snapshot)
-	}
+	utxoEntriesForPublicKey, err := bav.GetDbAdapter().GetUtxoEntriesForPublicKey(pkBytes)
 	if err != nil {
 		return nil, errors.Wrapf(err, "UtxoView.GetUnspentUtxoEntrysForPublicKey: Problem fetching "+
 			"utxos for public key %s", PkToString(pkBytes, bav.Params))
@@ -2758,6 +2969,7 @@ func (bav *UtxoView) GetUnspentUtxoEntrysForPublicKey(pkBytes []byte) ([]*UtxoEn
 
 	// Now that all of the utxos for this key have been loaded, filter the
 	// ones for this public key and return them.
+	bav._pullParentMappings(mappingUtxoKeyToUtxoEntry)
 	utxoEntriesToReturn := []*UtxoEntry{}
 	for utxoKeyTmp, utxoEntry := range bav.UtxoKeyToUtxoEntry {
 		// Make a copy of the iterator since it might change from underneath us
@@ -2777,43 +2989,12 @@ func (bav *UtxoView) GetSpendableDeSoBalanceNanosForPublicKey(pkBytes []byte,
 	// In order to get the spendable balance, we need to account for any immature block rewards.
 	// We get these by starting at the chain tip and iterating backwards until we have collected
 	// all of the immature block rewards for this public key.
-	nextBlockHash := bav.TipHash
 	numImmatureBlocks := uint32(bav.Params.BlockRewardMaturity / bav.Params.TimeBetweenBlocks)
-	immatureBlockRewards := uint64(0)
-
-	if bav.Postgres != nil {
-		// Filter out immature block rewards in postgres. UtxoType needs to be set correctly when importing blocks
-		outputs := bav.Postgres.GetBlockRewardsForPublicKey(NewPublicKey(pkBytes), tipHeight-numImmatureBlocks, tipHeight)
-
-		for _, output := range outputs {
-			immatureBlockRewards += output.AmountNanos
-		}
-	} else {
-		for ii := uint64(1); ii < uint64(numImmatureBlocks); ii++ {
-			// Don't look up the genesis block since it isn't in the DB.
-			if GenesisBlockHashHex == nextBlockHash.String() {
-				break
-			}
-
-			blockNode := GetHeightHashToNodeInfo(bav.Handle, bav.Snapshot, tipHeight, nextBlockHash, false)
-			if blockNode == nil {
-				return uint64(0), fmt.Errorf(
-					"GetSpendableDeSoBalanceNanosForPublicKey: Problem getting block for blockhash %s",
-					nextBlockHash.String())
-			}
-			blockRewardForPK, err := DbGetBlockRewardForPublicKeyBlockHash(bav.Handle, bav.Snapshot, pkBytes, nextBlockHash)
-			if err != nil {
-				return uint64(0), errors.Wrapf(
-					err, "GetSpendableDeSoBalanceNanosForPublicKey: Problem getting block reward for "+
-						"public key %s blockhash %s", PkToString(pkBytes, bav.Params), nextBlockHash.String())
-			}
-			immatureBlockRewards += blockRewardForPK
-			if blockNode.Parent != nil {
-				nextBlockHash = blockNode.Parent.Hash
-			} else {
-				nextBlockHash = GenesisBlockHash
-			}
-		}
+	immatureBlockRewards, err := bav.GetDbAdapter().GetImmatureBlockRewardsForPublicKey(
+		pkBytes, bav.TipHash, tipHeight, numImmatureBlocks)
+	if err != nil {
+		return uint64(0), errors.Wrapf(err, "GetSpendableDeSoBalanceNanosForPublicKey: Problem getting "+
+			"immature block rewards for public key %s", PkToString(pkBytes, bav.Params))
 	}
 
 	balanceNanos, err := bav.GetDeSoBalanceNanosForPublicKey(pkBytes)
@@ -17,16 +17,15 @@ func (bav *UtxoView) _getBalanceEntryForHODLerPKIDAndCreatorPKID(
 	if mapValue, existsMapValue := bav.GetHODLerPKIDCreatorPKIDToBalanceEntryMap(isDAOCoin)[balanceEntryKey]; existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(
+		_getBalanceEntryMapping(isDAOCoin), balanceEntryKey); exists {
+		return parentEntry.(*BalanceEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
-	var balanceEntry *BalanceEntry
-	if bav.Postgres != nil {
-		balanceEntry = bav.GetBalanceEntry(hodlerPKID, creatorPKID, isDAOCoin)
-	} else {
-		balanceEntry = DBGetBalanceEntryForHODLerAndCreatorPKIDs(bav.Handle, bav.Snapshot, hodlerPKID, creatorPKID, isDAOCoin)
-	}
+	balanceEntry := bav.GetDbAdapter().GetBalanceEntryForHODLerAndCreatorPKIDs(hodlerPKID, creatorPKID, isDAOCoin)
 	if balanceEntry != nil {
 		bav._setBalanceEntryMappingsWithPKIDs(balanceEntry, hodlerPKID, creatorPKID, isDAOCoin)
 	}
@@ -91,15 +90,9 @@ func (bav *UtxoView) _deleteBalanceEntryMappings(
 
 func (bav *UtxoView) GetHoldings(pkid *PKID, fetchProfiles bool, isDAOCoin bool) (
 	[]*BalanceEntry, []*ProfileEntry, error) {
-	var entriesYouHold []*BalanceEntry
-	if bav.Postgres != nil {
-		entriesYouHold = bav.GetBalanceEntryHoldings(pkid, isDAOCoin)
-	} else {
-		holdings, err := DbGetBalanceEntriesYouHold(bav.Handle, bav.Snapshot, pkid, true, isDAOCoin)
-		if err != nil {
-			return nil, nil, err
-		}
-		entriesYouHold = holdings
+	entriesYouHold, err := bav.GetDbAdapter().GetBalanceEntriesYouHold(pkid, isDAOCoin)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	holdingsMap := make(map[PKID]*BalanceEntry)
@@ -107,6 +100,7 @@ func (bav *UtxoView) GetHoldings(pkid *PKID, fetchProfiles bool, isDAOCoin bool)
 		holdingsMap[*balanceEntry.CreatorPKID] = balanceEntry
 	}
 
+	bav._pullParentMappings(_getBalanceEntryMapping(isDAOCoin))
 	for _, balanceEntry := range bav.GetHODLerPKIDCreatorPKIDToBalanceEntryMap(isDAOCoin) {
 		if reflect.DeepEqual(balanceEntry.HODLerPKID, pkid) {
 			if _, ok := holdingsMap[*balanceEntry.CreatorPKID]; ok {
@@ -135,15 +129,9 @@ func (bav *UtxoView) GetHoldings(pkid *PKID, fetchProfiles bool, isDAOCoin bool)
 
 func (bav *UtxoView) GetHolders(pkid *PKID, fetchProfiles bool, isDAOCoin bool) (
 	[]*BalanceEntry, []*ProfileEntry, error) {
-	var holderEntries []*BalanceEntry
-	if bav.Postgres != nil {
-		holderEntries = bav.GetBalanceEntryHolders(pkid, isDAOCoin)
-	} else {
-		holders, err := DbGetBalanceEntriesHodlingYou(bav.Handle, bav.Snapshot, pkid, true, isDAOCoin)
-		if err != nil {
-			return nil, nil, err
-		}
-		holderEntries = holders
+	holderEntries, err := bav.GetDbAdapter().GetBalanceEntriesHodlingYou(pkid, isDAOCoin)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	holdersMap := make(map[PKID]*BalanceEntry)
@@ -151,6 +139,7 @@ func (bav *UtxoView) GetHolders(pkid *PKID, fetchProfiles bool, isDAOCoin bool)
 		holdersMap[*balanceEntry.HODLerPKID] = balanceEntry
 	}
 
+	bav._pullParentMappings(_getBalanceEntryMapping(isDAOCoin))
 	for _, balanceEntry := range bav.GetHODLerPKIDCreatorPKIDToBalanceEntryMap(isDAOCoin) {
 		if reflect.DeepEqual(balanceEntry.CreatorPKID, pkid) {
 			if _, ok := holdersMap[*balanceEntry.HODLerPKID]; ok {
@@ -185,6 +174,15 @@ func (bav *UtxoView) GetHODLerPKIDCreatorPKIDToBalanceEntryMap(isDAOCoin bool) m
 	}
 }
 
+// _getBalanceEntryMapping returns the mapping GetHODLerPKIDCreatorPKIDToBalanceEntryMap returns.
+func _getBalanceEntryMapping(isDAOCoin bool) *utxoViewMapping {
+	if isDAOCoin {
+		return mappingHODLerPKIDCreatorPKIDToDAOCoinBalanceEntry
+	} else {
+		return mappingHODLerPKIDCreatorPKIDToBalanceEntry
+	}
+}
+
 //
 // BalanceEntry Postgres
 //
@@ -195,12 +193,20 @@ func (bav *UtxoView) GetBalanceEntry(holderPkid *PKID, creatorPkid *PKID, isDAOC
 	}
 	var balanceEntry *BalanceEntry
 	if isDAOCoin {
-		balance := bav.Postgres.GetDAOCoinBalance(holderPkid, creatorPkid)
+		balance, err := bav.Postgres.GetDAOCoinBalance(holderPkid, creatorPkid)
+		if err != nil {
+			glog.Errorf("GetBalanceEntry: Problem getting balance from Postgres: %v", err)
+			return nil
+		}
 		if balance != nil {
 			balanceEntry = balance.NewBalanceEntry()
 		}
 	} else {
-		balance := bav.Postgres.GetCreatorCoinBalance(holderPkid, creatorPkid)
+		balance, err := bav.Postgres.GetCreatorCoinBalance(holderPkid, creatorPkid)
+		if err != nil {
+			glog.Errorf("GetBalanceEntry: Problem getting balance from Postgres: %v", err)
+			return nil
+		}
 		if balance != nil {
 			balanceEntry = balance.NewBalanceEntry()
 		}
@@ -214,12 +220,20 @@ func (bav *UtxoView) GetBalanceEntryHoldings(pkid *PKID, isDAOCoin bool) []*Bala
 	}
 	var balanceEntries []*BalanceEntry
 	if isDAOCoin {
-		balances := bav.Postgres.GetDAOCoinHoldings(pkid)
+		balances, err := bav.Postgres.GetDAOCoinHoldings(pkid)
+		if err != nil {
+			glog.Errorf("GetBalanceEntryHoldings: Problem getting balances from Postgres: %v", err)
+			return nil
+		}
 		for _, balance := range balances {
 			balanceEntries = append(balanceEntries, balance.NewBalanceEntry())
 		}
 	} else {
-		balances := bav.Postgres.GetCreatorCoinHoldings(pkid)
+		balances, err := bav.Postgres.GetCreatorCoinHoldings(pkid)
+		if err != nil {
+			glog.Errorf("GetBalanceEntryHoldings: Problem getting balances from Postgres: %v", err)
+			return nil
+		}
 		for _, balance := range balances {
 			balanceEntries = append(balanceEntries, balance.NewBalanceEntry())
 		}
@@ -233,12 +247,20 @@ func (bav *UtxoView) GetBalanceEntryHolders(pkid *PKID, isDAOCoin bool) []*Balan
 	}
 	var balanceEntries []*BalanceEntry
 	if isDAOCoin {
-		balances := bav.Postgres.GetDAOCoinHolders(pkid)
+		balances, err := bav.Postgres.GetDAOCoinHolders(pkid)
+		if err != nil {
+			glog.Errorf("GetBalanceEntryHolders: Problem getting balances from Postgres: %v", err)
+			return nil
+		}
 		for _, balance := range balances {
 			balanceEntries = append(balanceEntries, balance.NewBalanceEntry())
 		}
 	} else {
-		balances := bav.Postgres.GetCreatorCoinHolders(pkid)
+		balances, err := bav.Postgres.GetCreatorCoinHolders(pkid)
+		if err != nil {
+			glog.Errorf("GetBalanceEntryHolders: Problem getting balances from Postgres: %v", err)
+			return nil
+		}
 		for _, balance := range balances {
 			balanceEntries = append(balanceEntries, balance.NewBalanceEntry())
 		}
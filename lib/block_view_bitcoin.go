@@ -29,11 +29,14 @@ func (bav *UtxoView) _existsBitcoinTxIDMapping(bitcoinBurnTxID *BlockHash) bool
 	if existsMapValue {
 		return mapValue
 	}
+	if parentValue, exists := bav._pullParentMapping(mappingBitcoinBurnTxIDs, *bitcoinBurnTxID); exists {
+		return parentValue.(bool)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return true. If not, return
 	// false. Either way, save the value to the in-memory view mapping got later.
-	dbHasMapping := DbExistsBitcoinBurnTxID(bav.Handle, bav.Snapshot, bitcoinBurnTxID)
+	dbHasMapping := bav.GetDbAdapter().ExistsBitcoinBurnTxID(bitcoinBurnTxID)
 	bav.BitcoinBurnTxIDs[*bitcoinBurnTxID] = dbHasMapping
 	return dbHasMapping
 }
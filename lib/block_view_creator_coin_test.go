@@ -2,7 +2,6 @@ package lib
 
 import (
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -4011,7 +4010,7 @@ func TestCreatorCoinBigBigBuyBigSell(t *testing.T) {
 	}
 }
 
-func _creatorCoinTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+func _creatorCoinTxn(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64,
 	UpdaterPublicKeyBase58Check string,
 	UpdaterPrivateKeyBase58Check string,
@@ -4125,7 +4124,7 @@ func _creatorCoinTxnWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _doCreatorCoinTransferTxnWithDiamonds(t *testing.T, chain *Blockchain, db *badger.DB,
+func _doCreatorCoinTransferTxnWithDiamonds(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64,
 	SenderPublicKeyBase58Check string,
 	SenderPrivBase58Check string,
@@ -4188,7 +4187,7 @@ func _doCreatorCoinTransferTxnWithDiamonds(t *testing.T, chain *Blockchain, db *
 	return utxoOps, txn, blockHeight, nil
 }
 
-func _doCreatorCoinTransferTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+func _doCreatorCoinTransferTxn(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64,
 	UpdaterPublicKeyBase58Check string, UpdaterPrivateKeyBase58Check string,
 	// See CreatorCoinTransferMetadataa for an explanation of these fields.
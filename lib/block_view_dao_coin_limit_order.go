@@ -902,6 +902,8 @@ func (bav *UtxoView) GetNextLimitOrdersToFill(
 		return nil, err
 	}
 
+	bav._pullParentMappings(mappingDAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry)
+
 	// Update UTXO with relevant limit order entries from database.
 	for _, matchingOrder := range matchingOrders {
 		if _, exists := bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry[matchingOrder.ToMapKey()]; !exists {
@@ -1366,6 +1368,11 @@ func (bav *UtxoView) _getDAOCoinLimitOrderEntry(orderID *BlockHash) (*DAOCoinLim
 	if outputEntry != nil {
 		return outputEntry, nil
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingDAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry, mapKey); exists {
+		if outputEntry = parentEntry.(*DAOCoinLimitOrderEntry); outputEntry != nil {
+			return outputEntry, nil
+		}
+	}
 
 	// If not, next check if we have the order entry in the database.
 	return bav.GetDbAdapter().GetDAOCoinLimitOrder(orderID)
@@ -1383,6 +1390,7 @@ func (bav *UtxoView) _getAllDAOCoinLimitOrders() ([]*DAOCoinLimitOrderEntry, err
 		return nil, err
 	}
 
+	bav._pullParentMappings(mappingDAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry)
 	for _, orderEntry := range dbOrderEntries {
 		orderMapKey := orderEntry.ToMapKey()
 
@@ -1424,6 +1432,7 @@ func (bav *UtxoView) GetAllDAOCoinLimitOrdersForThisDAOCoinPair(
 		return nil, err
 	}
 
+	bav._pullParentMappings(mappingDAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry)
 	for _, orderEntry := range dbOrderEntries {
 		orderMapKey := orderEntry.ToMapKey()
 
@@ -1463,6 +1472,7 @@ func (bav *UtxoView) GetAllDAOCoinLimitOrdersForThisTransactor(transactorPKID *P
 		return nil, err
 	}
 
+	bav._pullParentMappings(mappingDAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry)
 	for _, orderEntry := range dbOrderEntries {
 		orderMapKey := orderEntry.ToMapKey()
 
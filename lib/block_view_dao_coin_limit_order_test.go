@@ -3,7 +3,6 @@ package lib
 import (
 	"bytes"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/require"
 	"math"
@@ -4096,7 +4095,7 @@ func _doDAOCoinLimitOrderTxnWithTestMeta(
 }
 
 // Error expected.
-func _doDAOCoinLimitOrderTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+func _doDAOCoinLimitOrderTxn(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64,
 	TransactorPublicKeyBase58Check string,
 	TransactorPrivateKeyBase58Check string,
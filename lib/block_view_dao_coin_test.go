@@ -2,7 +2,6 @@ package lib
 
 import (
 	"github.com/btcsuite/btcd/btcec"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/holiman/uint256"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -10,7 +9,7 @@ import (
 	"testing"
 )
 
-func _daoCoinTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+func _daoCoinTxn(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64,
 	TransactorPublicKeyBase58Check string,
 	TransactorPrivateKeyBase58Check string,
@@ -89,7 +88,7 @@ func _daoCoinTxnWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _daoCoinTransferTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+func _daoCoinTransferTxn(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64,
 	TransactorPublicKeyBase58Check string,
 	TransactorPrivateKeyBase58Check string,
@@ -3,13 +3,17 @@ package lib
 import (
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"reflect"
 )
 
 func (bav *UtxoView) FlushToDb(blockHeight uint64) error {
+	// A child view only holds its own changes, so it has to be committed into its parent instead.
+	if bav.parent != nil {
+		return fmt.Errorf("FlushToDb: Can't flush a child view, commit it into its parent instead")
+	}
+
 	// Make sure everything happens inside a single transaction.
 	var err error
 	if bav.Postgres != nil {
@@ -19,7 +23,7 @@ func (bav *UtxoView) FlushToDb(blockHeight uint64) error {
 		}
 	}
 
-	err = bav.Handle.Update(func(txn *badger.Txn) error {
+	err = bav.Handle.Update(func(txn KVTxn) error {
 		return bav.FlushToDbWithTxn(txn, blockHeight)
 	})
 	if err != nil {
@@ -38,7 +42,14 @@ func (bav *UtxoView) FlushToDb(blockHeight uint64) error {
 	return nil
 }
 
-func (bav *UtxoView) FlushToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) FlushToDbWithTxn(txn KVTxn, blockHeight uint64) error {
+	if bav.parent != nil {
+		return fmt.Errorf("FlushToDbWithTxn: Can't flush a child view, commit it into its parent instead")
+	}
+
+	// Any records preloaded for the block are about to be overwritten, so stop serving them.
+	bav._resetPreloadKVStore()
+
 	// We're about to flush records to the main DB, so we initiate the snapshot update.
 	// This function prepares the data structures in the snapshot.
 	if bav.Snapshot != nil {
@@ -117,11 +128,15 @@ func (bav *UtxoView) FlushToDbWithTxn(txn *badger.Txn, blockHeight uint64) error
 	if err := bav._flushMessagingGroupEntriesToDbWithTxn(txn, blockHeight); err != nil {
 		return err
 	}
+	// Indexed entries are only stored in BadgerDB, even in Postgres mode.
+	if err := bav._flushIndexedEntriesToDbWithTxn(txn, blockHeight); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (bav *UtxoView) _flushUtxosToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushUtxosToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	glog.V(2).Infof("_flushUtxosToDbWithTxn: flushing %d mappings", len(bav.UtxoKeyToUtxoEntry))
 
 	for utxoKeyIter, utxoEntry := range bav.UtxoKeyToUtxoEntry {
@@ -176,7 +191,7 @@ func (bav *UtxoView) _flushUtxosToDbWithTxn(txn *badger.Txn, blockHeight uint64)
 	return nil
 }
 
-func (bav *UtxoView) _flushDeSoBalancesToDbWithTxn(txn *badger.Txn) error {
+func (bav *UtxoView) _flushDeSoBalancesToDbWithTxn(txn KVTxn) error {
 	glog.V(2).Infof("_flushDeSoBalancesToDbWithTxn: flushing %d mappings",
 		len(bav.PublicKeyToDeSoBalanceNanos))
 
@@ -204,7 +219,7 @@ func (bav *UtxoView) _flushDeSoBalancesToDbWithTxn(txn *badger.Txn) error {
 	return nil
 }
 
-func (bav *UtxoView) _flushGlobalParamsEntryToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushGlobalParamsEntryToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	globalParamsEntry := bav.GlobalParamsEntry
 	if err := DbPutGlobalParamsEntryWithTxn(txn, bav.Snapshot, blockHeight, *globalParamsEntry); err != nil {
 		return errors.Wrapf(err, "_flushGlobalParamsEntryToDbWithTxn: Problem putting global params entry in DB")
@@ -212,7 +227,7 @@ func (bav *UtxoView) _flushGlobalParamsEntryToDbWithTxn(txn *badger.Txn, blockHe
 	return nil
 }
 
-func (bav *UtxoView) _flushForbiddenPubKeyEntriesToDbWithTxn(txn *badger.Txn) error {
+func (bav *UtxoView) _flushForbiddenPubKeyEntriesToDbWithTxn(txn KVTxn) error {
 
 	// Go through all the entries in the KeyTorepostEntry map.
 	for _, forbiddenPubKeyEntry := range bav.ForbiddenPubKeyToForbiddenPubKeyEntry {
@@ -244,7 +259,7 @@ func (bav *UtxoView) _flushForbiddenPubKeyEntriesToDbWithTxn(txn *badger.Txn) er
 	return nil
 }
 
-func (bav *UtxoView) _flushBitcoinExchangeDataWithTxn(txn *badger.Txn) error {
+func (bav *UtxoView) _flushBitcoinExchangeDataWithTxn(txn KVTxn) error {
 	// Iterate through our in-memory map. If anything has a value of false it means
 	// that particular mapping should be expunged from the db. If anything has a value
 	// of true it means that mapping should be added to the db.
@@ -283,7 +298,7 @@ func (bav *UtxoView) _flushBitcoinExchangeDataWithTxn(txn *badger.Txn) error {
 	return nil
 }
 
-func (bav *UtxoView) _flushMessageEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushMessageEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	// Go through all the entries in the MessageKeyToMessageEntry map.
 	for messageKeyIter, messageEntry := range bav.MessageKeyToMessageEntry {
 		// Make a copy of the iterator since we take references to it below.
@@ -316,7 +331,7 @@ func (bav *UtxoView) _flushMessageEntriesToDbWithTxn(txn *badger.Txn, blockHeigh
 	return nil
 }
 
-func (bav *UtxoView) _flushRepostEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushRepostEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 
 	// Go through all the entries in the repostKeyTorepostEntry map.
 	for repostKeyIter, repostEntry := range bav.RepostKeyToRepostEntry {
@@ -360,7 +375,7 @@ func (bav *UtxoView) _flushRepostEntriesToDbWithTxn(txn *badger.Txn, blockHeight
 	return nil
 }
 
-func (bav *UtxoView) _flushLikeEntriesToDbWithTxn(txn *badger.Txn) error {
+func (bav *UtxoView) _flushLikeEntriesToDbWithTxn(txn KVTxn) error {
 
 	// Go through all the entries in the LikeKeyToLikeEntry map.
 	for likeKeyIter, likeEntry := range bav.LikeKeyToLikeEntry {
@@ -407,7 +422,7 @@ func (bav *UtxoView) _flushLikeEntriesToDbWithTxn(txn *badger.Txn) error {
 	return nil
 }
 
-func (bav *UtxoView) _flushFollowEntriesToDbWithTxn(txn *badger.Txn) error {
+func (bav *UtxoView) _flushFollowEntriesToDbWithTxn(txn KVTxn) error {
 
 	// Go through all the entries in the FollowKeyToFollowEntry map.
 	for followKeyIter, followEntry := range bav.FollowKeyToFollowEntry {
@@ -454,7 +469,7 @@ func (bav *UtxoView) _flushFollowEntriesToDbWithTxn(txn *badger.Txn) error {
 	return nil
 }
 
-func (bav *UtxoView) _flushNFTEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushNFTEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 
 	// Go through and delete all the entries so they can be added back fresh.
 	for nftKeyIter, nftEntry := range bav.NFTKeyToNFTEntry {
@@ -498,7 +513,7 @@ func (bav *UtxoView) _flushNFTEntriesToDbWithTxn(txn *badger.Txn, blockHeight ui
 	return nil
 }
 
-func (bav *UtxoView) _flushAcceptedBidEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushAcceptedBidEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 
 	// Go through and delete all the entries so they can be added back fresh.
 	for nftKeyIter := range bav.NFTKeyToAcceptedNFTBidHistory {
@@ -539,7 +554,7 @@ func (bav *UtxoView) _flushAcceptedBidEntriesToDbWithTxn(txn *badger.Txn, blockH
 	return nil
 }
 
-func (bav *UtxoView) _flushNFTBidEntriesToDbWithTxn(txn *badger.Txn) error {
+func (bav *UtxoView) _flushNFTBidEntriesToDbWithTxn(txn KVTxn) error {
 
 	// Go through and delete all the entries so they can be added back fresh.
 	for nftBidKeyIter, nftBidEntry := range bav.NFTBidKeyToNFTBidEntry {
@@ -583,7 +598,7 @@ func (bav *UtxoView) _flushNFTBidEntriesToDbWithTxn(txn *badger.Txn) error {
 	return nil
 }
 
-func (bav *UtxoView) _flushDiamondEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushDiamondEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 
 	// Go through and delete all the entries so they can be added back fresh.
 	for diamondKeyIter, diamondEntry := range bav.DiamondKeyToDiamondEntry {
@@ -631,7 +646,7 @@ func (bav *UtxoView) _flushDiamondEntriesToDbWithTxn(txn *badger.Txn, blockHeigh
 	return nil
 }
 
-func (bav *UtxoView) _flushPostEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushPostEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	// TODO(DELETEME): Remove flush logging after debugging MarkBlockInvalid bug.
 	glog.V(2).Infof("_flushPostEntriesToDbWithTxn: flushing %d mappings", len(bav.PostHashToPostEntry))
 
@@ -685,7 +700,7 @@ func (bav *UtxoView) _flushPostEntriesToDbWithTxn(txn *badger.Txn, blockHeight u
 
 	return nil
 }
-func (bav *UtxoView) _flushPKIDEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushPKIDEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	for pubKeyIter, pkidEntry := range bav.PublicKeyToPKIDEntry {
 		pubKeyCopy := make([]byte, btcec.PubKeyBytesLenCompressed)
 		copy(pubKeyCopy, pubKeyIter[:])
@@ -742,7 +757,7 @@ func (bav *UtxoView) _flushPKIDEntriesToDbWithTxn(txn *badger.Txn, blockHeight u
 	return nil
 }
 
-func (bav *UtxoView) _flushProfileEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushProfileEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	glog.V(2).Infof("_flushProfilesToDbWithTxn: flushing %d mappings", len(bav.ProfilePKIDToProfileEntry))
 
 	// Go through all the entries in the ProfilePublicKeyToProfileEntry map.
@@ -803,7 +818,7 @@ func (bav *UtxoView) _flushProfileEntriesToDbWithTxn(txn *badger.Txn, blockHeigh
 // TODO: All of these functions should be renamed "CreatorCoinBalanceEntry" to
 // distinguish them from DAOCoinBalanceEntry, which is a different but similar index
 // that got introduced later.
-func (bav *UtxoView) _flushBalanceEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushBalanceEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	glog.V(2).Infof("_flushBalanceEntriesToDbWithTxn: flushing %d mappings", len(bav.HODLerPKIDCreatorPKIDToBalanceEntry))
 
 	// Go through all the entries in the HODLerPubKeyCreatorPubKeyToBalanceEntry map.
@@ -861,7 +876,7 @@ func (bav *UtxoView) _flushBalanceEntriesToDbWithTxn(txn *badger.Txn, blockHeigh
 }
 
 // TODO: This could theoretically be consolidated with the other BalanceEntry flusher.
-func (bav *UtxoView) _flushDAOCoinBalanceEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushDAOCoinBalanceEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	glog.V(2).Infof("_flushDAOCoinBalanceEntriesToDbWithTxn: flushing %d mappings", len(bav.HODLerPKIDCreatorPKIDToDAOCoinBalanceEntry))
 
 	// Go through all the entries in the HODLerPubKeyCreatorPubKeyToBalanceEntry map.
@@ -918,7 +933,7 @@ func (bav *UtxoView) _flushDAOCoinBalanceEntriesToDbWithTxn(txn *badger.Txn, blo
 	return nil
 }
 
-func (bav *UtxoView) _flushDerivedKeyEntryToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushDerivedKeyEntryToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	glog.V(2).Infof("_flushDerivedKeyEntryToDbWithTxn: flushing %d mappings", len(bav.DerivedKeyToDerivedEntry))
 
 	// Go through all entries in the DerivedKeyToDerivedEntry map and add them to the DB.
@@ -953,7 +968,7 @@ func (bav *UtxoView) _flushDerivedKeyEntryToDbWithTxn(txn *badger.Txn, blockHeig
 	return nil
 }
 
-func (bav *UtxoView) _flushMessagingGroupEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushMessagingGroupEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	glog.V(2).Infof("_flushMessagingGroupEntriesToDbWithTxn: flushing %d mappings", len(bav.MessagingGroupKeyToMessagingGroupEntry))
 	numDeleted := 0
 	numPut := 0
@@ -1020,7 +1035,7 @@ func (bav *UtxoView) _flushMessagingGroupEntriesToDbWithTxn(txn *badger.Txn, blo
 	return nil
 }
 
-func (bav *UtxoView) _flushDAOCoinLimitOrderEntriesToDbWithTxn(txn *badger.Txn, blockHeight uint64) error {
+func (bav *UtxoView) _flushDAOCoinLimitOrderEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
 	glog.V(1).Infof("_flushDAOCoinLimitOrderEntriesToDbWithTxn: flushing %d mappings", len(bav.DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry))
 
 	// Go through all the entries in the DAOCoinLimitOrderMapKeyToDAOCoinLimitOrderEntry map.
@@ -26,18 +26,14 @@ func (bav *UtxoView) _getFollowEntryForFollowKey(followKey *FollowKey) *FollowEn
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingFollowKeyToFollowEntry, *followKey); exists {
+		return parentEntry.(*FollowEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil. Either way, save the value to the in-memory view mapping got later.
-	followExists := false
-	if bav.Postgres != nil {
-		followExists = bav.Postgres.GetFollow(&followKey.FollowerPKID, &followKey.FollowedPKID) != nil
-	} else {
-		followExists = DbGetFollowerToFollowedMapping(bav.Handle, bav.Snapshot, &followKey.FollowerPKID, &followKey.FollowedPKID) != nil
-	}
-
-	if followExists {
+	if bav.GetDbAdapter().GetFollowExists(&followKey.FollowerPKID, &followKey.FollowedPKID) {
 		followEntry := FollowEntry{
 			FollowerPKID: &followKey.FollowerPKID,
 			FollowedPKID: &followKey.FollowedPKID,
@@ -71,6 +67,7 @@ func (bav *UtxoView) _followEntriesForPubKey(publicKey []byte, getEntriesFollowi
 	// our public key or that are deleted. Note that only considering mappings
 	// where our public key is part of the key should ensure there are no
 	// duplicates in the resulting list.
+	bav._pullParentMappings(mappingFollowKeyToFollowEntry)
 	followEntriesToReturn := []*FollowEntry{}
 	for viewFollowKey, viewFollowEntry := range bav.FollowKeyToFollowEntry {
 		if viewFollowEntry.isDeleted {
@@ -118,44 +115,31 @@ func (bav *UtxoView) GetFollowEntriesForPublicKey(publicKey []byte, getEntriesFo
 	}
 
 	// Start by fetching all the follows we have in the db.
-	if bav.Postgres != nil {
-		var follows []*PGFollow
-		if getEntriesFollowingPublicKey {
-			follows = bav.Postgres.GetFollowers(pkidForPublicKey.PKID)
-		} else {
-			follows = bav.Postgres.GetFollowing(pkidForPublicKey.PKID)
-		}
-
-		for _, follow := range follows {
-			bav._setFollowEntryMappings(follow.NewFollowEntry())
-		}
+	var dbPKIDs []*PKID
+	var err error
+	if getEntriesFollowingPublicKey {
+		dbPKIDs, err = bav.GetDbAdapter().GetFollowerPKIDs(pkidForPublicKey.PKID)
 	} else {
-		var dbPKIDs []*PKID
-		var err error
+		dbPKIDs, err = bav.GetDbAdapter().GetFollowedPKIDs(pkidForPublicKey.PKID)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetFollowsForUser: Problem fetching FollowEntrys from db: ")
+	}
+
+	// Iterate through the entries found in the db and force the view to load them.
+	// This fills in any gaps in the view so that, after this, the view should contain
+	// the union of what it had before plus what was in the db.
+	for _, dbPKID := range dbPKIDs {
+		var followKey FollowKey
 		if getEntriesFollowingPublicKey {
-			dbPKIDs, err = DbGetPKIDsFollowingYou(bav.Handle, pkidForPublicKey.PKID)
+			// publicKey is the followed public key
+			followKey = MakeFollowKey(dbPKID, pkidForPublicKey.PKID)
 		} else {
-			dbPKIDs, err = DbGetPKIDsYouFollow(bav.Handle, pkidForPublicKey.PKID)
-		}
-		if err != nil {
-			return nil, errors.Wrapf(err, "GetFollowsForUser: Problem fetching FollowEntrys from db: ")
+			// publicKey is the follower public key
+			followKey = MakeFollowKey(pkidForPublicKey.PKID, dbPKID)
 		}
 
-		// Iterate through the entries found in the db and force the view to load them.
-		// This fills in any gaps in the view so that, after this, the view should contain
-		// the union of what it had before plus what was in the db.
-		for _, dbPKID := range dbPKIDs {
-			var followKey FollowKey
-			if getEntriesFollowingPublicKey {
-				// publicKey is the followed public key
-				followKey = MakeFollowKey(dbPKID, pkidForPublicKey.PKID)
-			} else {
-				// publicKey is the follower public key
-				followKey = MakeFollowKey(pkidForPublicKey.PKID, dbPKID)
-			}
-
-			bav._getFollowEntryForFollowKey(&followKey)
-		}
+		bav._getFollowEntryForFollowKey(&followKey)
 	}
 
 	followEntriesToReturn := bav._followEntriesForPubKey(publicKey, getEntriesFollowingPublicKey)
@@ -2,13 +2,12 @@ package lib
 
 import (
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
 )
 
-func _doFollowTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+func _doFollowTxn(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, senderPkBase58Check string,
 	followedPkBase58Check string, senderPrivBase58Check string, isUnfollow bool) (
 	_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// IndexedEntryMapKey is the key of an entry of a DBIndexedEntryType in the UtxoView.
+type IndexedEntryMapKey struct {
+	EntryTypeName string
+	PrimaryKey    string
+}
+
+// IndexedEntry is an entry of a DBIndexedEntryType held by the UtxoView. The Entry is shared
+// between views, so it must never be modified in place. Set a modified copy with
+// SetIndexedEntry instead.
+type IndexedEntry struct {
+	EntryType *DBIndexedEntryType
+	Entry     DeSoEncoder
+
+	isDeleted bool
+}
+
+func _indexedEntryMapKey(entryType *DBIndexedEntryType, primaryKey []byte) IndexedEntryMapKey {
+	return IndexedEntryMapKey{
+		EntryTypeName: entryType.Name,
+		PrimaryKey:    string(primaryKey),
+	}
+}
+
+// GetIndexedEntry returns the entry with the given primary key, or nil if there's no such entry.
+func (bav *UtxoView) GetIndexedEntry(entryType *DBIndexedEntryType, primaryKey []byte) (DeSoEncoder, error) {
+	mapKey := _indexedEntryMapKey(entryType, primaryKey)
+	if indexedEntry, exists := bav.IndexedEntries[mapKey]; exists {
+		if indexedEntry.isDeleted {
+			return nil, nil
+		}
+		return indexedEntry.Entry, nil
+	}
+	if parentEntry, exists := bav._pullParentMapping(mappingIndexedEntries, mapKey); exists {
+		indexedEntry := parentEntry.(*IndexedEntry)
+		if indexedEntry.isDeleted {
+			return nil, nil
+		}
+		return indexedEntry.Entry, nil
+	}
+
+	entry, err := entryType.Get(bav.Handle, bav.Snapshot, primaryKey)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetIndexedEntry: Problem fetching (%v) entry", entryType.Name)
+	}
+	if entry != nil {
+		bav.IndexedEntries[mapKey] = &IndexedEntry{EntryType: entryType, Entry: entry}
+	}
+	return entry, nil
+}
+
+// SetIndexedEntry adds or replaces the entry with the same primary key. The entry's secondary index
+// keys may differ from the ones of the entry it replaces, the flush takes care of removing the old ones.
+func (bav *UtxoView) SetIndexedEntry(entryType *DBIndexedEntryType, entry DeSoEncoder) {
+	mapKey := _indexedEntryMapKey(entryType, entryType.PrimaryKey(entry))
+	bav.IndexedEntries[mapKey] = &IndexedEntry{EntryType: entryType, Entry: entry}
+}
+
+// DeleteIndexedEntry deletes the entry with the same primary key as the passed entry.
+func (bav *UtxoView) DeleteIndexedEntry(entryType *DBIndexedEntryType, entry DeSoEncoder) {
+	mapKey := _indexedEntryMapKey(entryType, entryType.PrimaryKey(entry))
+	bav.IndexedEntries[mapKey] = &IndexedEntry{EntryType: entryType, Entry: entry, isDeleted: true}
+}
+
+// GetIndexedEntries returns all the entries whose primary key starts with keyPrefix, taking into
+// account the changes in the view, in key order.
+func (bav *UtxoView) GetIndexedEntries(entryType *DBIndexedEntryType, keyPrefix []byte) ([]DeSoEncoder, error) {
+	dbEntries, err := entryType.Enumerate(bav.Handle, keyPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetIndexedEntries: Problem scanning (%v) entries", entryType.Name)
+	}
+	return bav._mergeIndexedEntries(entryType, nil, keyPrefix, dbEntries), nil
+}
+
+// GetIndexedEntriesByIndex returns all the entries whose key in the given secondary index starts with
+// keyPrefix, taking into account the changes in the view, in index key order.
+func (bav *UtxoView) GetIndexedEntriesByIndex(entryType *DBIndexedEntryType, indexName string,
+	keyPrefix []byte) ([]DeSoEncoder, error) {
+
+	index, err := entryType.GetSecondaryIndex(indexName)
+	if err != nil {
+		return nil, err
+	}
+	dbEntries, err := entryType.EnumerateByIndex(bav.Handle, bav.Snapshot, indexName, keyPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetIndexedEntriesByIndex: Problem scanning (%v) entries", entryType.Name)
+	}
+	return bav._mergeIndexedEntries(entryType, index, keyPrefix, dbEntries), nil
+}
+
+// _mergeIndexedEntries combines the entries found in the db with the entries in the view. The view's
+// version of an entry always wins, even if it no longer matches keyPrefix. If index is nil, the entries
+// are matched and sorted by primary key.
+func (bav *UtxoView) _mergeIndexedEntries(entryType *DBIndexedEntryType, index *DBSecondaryIndex,
+	keyPrefix []byte, dbEntries []DeSoEncoder) []DeSoEncoder {
+
+	getKey := entryType.PrimaryKey
+	if index != nil {
+		getKey = index.Key
+	}
+
+	bav._pullParentMappings(mappingIndexedEntries)
+	entriesByKey := make(map[string]DeSoEncoder)
+	for _, entry := range dbEntries {
+		mapKey := _indexedEntryMapKey(entryType, entryType.PrimaryKey(entry))
+		if _, exists := bav.IndexedEntries[mapKey]; exists {
+			continue
+		}
+		entriesByKey[string(getKey(entry))] = entry
+	}
+	for mapKey, indexedEntry := range bav.IndexedEntries {
+		if mapKey.EntryTypeName != entryType.Name || indexedEntry.isDeleted {
+			continue
+		}
+		key := getKey(indexedEntry.Entry)
+		if bytes.HasPrefix(key, keyPrefix) {
+			entriesByKey[string(key)] = indexedEntry.Entry
+		}
+	}
+
+	keys := make([]string, 0, len(entriesByKey))
+	for key := range entriesByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	entries := make([]DeSoEncoder, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, entriesByKey[key])
+	}
+	return entries
+}
+
+func (bav *UtxoView) _flushIndexedEntriesToDbWithTxn(txn KVTxn, blockHeight uint64) error {
+	glog.V(1).Infof("_flushIndexedEntriesToDbWithTxn: flushing %d mappings", len(bav.IndexedEntries))
+
+	// Delete the existing records of every entry in the view. We use the entry that's in the db
+	// rather than the one in the view, because the view's entry may have different index keys.
+	for mapKey, indexedEntry := range bav.IndexedEntries {
+		if mapKey.EntryTypeName != indexedEntry.EntryType.Name {
+			return fmt.Errorf("_flushIndexedEntriesToDbWithTxn: Entry of type (%v) is mapped under type (%v)",
+				indexedEntry.EntryType.Name, mapKey.EntryTypeName)
+		}
+		err := indexedEntry.EntryType.DeleteByPrimaryKeyWithTxn(txn, bav.Snapshot, []byte(mapKey.PrimaryKey))
+		if err != nil {
+			return errors.Wrapf(err, "_flushIndexedEntriesToDbWithTxn: Problem deleting mappings")
+		}
+	}
+
+	// Put the entries that aren't deleted back.
+	numDeleted := 0
+	numPut := 0
+	for _, indexedEntry := range bav.IndexedEntries {
+		if indexedEntry.isDeleted {
+			numDeleted++
+			continue
+		}
+		numPut++
+		if err := indexedEntry.EntryType.PutWithTxn(txn, bav.Snapshot, indexedEntry.Entry, blockHeight); err != nil {
+			return errors.Wrapf(err, "_flushIndexedEntriesToDbWithTxn: Problem putting mappings")
+		}
+	}
+
+	glog.V(1).Infof("_flushIndexedEntriesToDbWithTxn: deleted %d mappings, put %d mappings", numDeleted, numPut)
+	return nil
+}
@@ -2,7 +2,6 @@ package lib
 
 import (
 	"fmt"
-	"github.com/btcsuite/btcd/btcec"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 	"reflect"
@@ -14,19 +13,14 @@ func (bav *UtxoView) _getLikeEntryForLikeKey(likeKey *LikeKey) *LikeEntry {
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingLikeKeyToLikeEntry, *likeKey); exists {
+		return parentEntry.(*LikeEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil. Either way, save the value to the in-memory view mapping got later.
-	likeExists := false
-	if bav.Postgres != nil {
-		likeExists = nil != bav.Postgres.GetLike(likeKey.LikerPubKey[:], &likeKey.LikedPostHash)
-	} else {
-		likeExists = nil != DbGetLikerPubKeyToLikedPostHashMapping(bav.Handle, bav.Snapshot,
-			likeKey.LikerPubKey[:], likeKey.LikedPostHash)
-	}
-
-	if likeExists {
+	if bav.GetDbAdapter().GetLikeExists(likeKey.LikerPubKey[:], &likeKey.LikedPostHash) {
 		likeEntry := LikeEntry{
 			LikerPubKey:   likeKey.LikerPubKey[:],
 			LikedPostHash: &likeKey.LikedPostHash,
@@ -68,37 +62,23 @@ func (bav *UtxoView) GetLikedByReader(readerPK []byte, postHash *BlockHash) bool
 }
 
 func (bav *UtxoView) GetLikesForPostHash(postHash *BlockHash) (_likerPubKeys [][]byte, _err error) {
-	if bav.Postgres != nil {
-		likes := bav.Postgres.GetLikesForPost(postHash)
-		for _, like := range likes {
-			bav._setLikeEntryMappings(like.NewLikeEntry())
-		}
-	} else {
-		handle := bav.Handle
-		dbPrefix := append([]byte{}, Prefixes.PrefixLikedPostHashToLikerPubKey...)
-		dbPrefix = append(dbPrefix, postHash[:]...)
-		keysFound, _ := EnumerateKeysForPrefix(handle, dbPrefix)
-
-		// Iterate over all the db keys & values and load them into the view.
-		expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed
-		for _, key := range keysFound {
-			// Sanity check that this is a reasonable key.
-			if len(key) != expectedKeyLength {
-				return nil, fmt.Errorf("UtxoView.GetLikesForPostHash: Invalid key length found: %d", len(key))
-			}
-
-			likerPubKey := key[1+HashSizeBytes:]
-
-			likeKey := &LikeKey{
-				LikerPubKey:   MakePkMapKey(likerPubKey),
-				LikedPostHash: *postHash,
-			}
-
-			bav._getLikeEntryForLikeKey(likeKey)
+	dbLikerPubKeys, err := bav.GetDbAdapter().GetLikerPublicKeysForPostHash(postHash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "UtxoView.GetLikesForPostHash: ")
+	}
+
+	// Iterate over all the likes in the db and load them into the view.
+	for _, likerPubKey := range dbLikerPubKeys {
+		likeKey := &LikeKey{
+			LikerPubKey:   MakePkMapKey(likerPubKey),
+			LikedPostHash: *postHash,
 		}
+
+		bav._getLikeEntryForLikeKey(likeKey)
 	}
 
 	// Iterate over the view and create the final list to return.
+	bav._pullParentMappings(mappingLikeKeyToLikeEntry)
 	likerPubKeys := [][]byte{}
 	for _, likeEntry := range bav.LikeKeyToLikeEntry {
 		if !likeEntry.isDeleted && reflect.DeepEqual(likeEntry.LikedPostHash[:], postHash[:]) {
@@ -2,7 +2,6 @@ package lib
 
 import (
 	"bytes"
-	"encoding/gob"
 	"encoding/hex"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
@@ -12,24 +11,30 @@ import (
 	"reflect"
 )
 
-func (bav *UtxoView) _getMessageEntryForMessageKey(messageKey *MessageKey) *MessageEntry {
+func (bav *UtxoView) _getMessageEntryForMessageKey(messageKey *MessageKey) (*MessageEntry, error) {
 	// It is important to note that this function has to be called with a MessageKey
 	// that's set with *messaging keys* rather than user keys.
 
 	// If an entry exists in the in-memory map, return the value of that mapping.
 	mapValue, existsMapValue := bav.MessageKeyToMessageEntry[*messageKey]
 	if existsMapValue {
-		return mapValue
+		return mapValue, nil
+	}
+	if parentEntry, exists := bav._pullParentMapping(mappingMessageKeyToMessageEntry, *messageKey); exists {
+		return parentEntry.(*MessageEntry), nil
 	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil. Either way, save the value to the in-memory view mapping got later.
-	dbMessageEntry := DBGetMessageEntry(bav.Handle, bav.Snapshot, messageKey.PublicKey[:], messageKey.TstampNanos)
+	dbMessageEntry, err := bav.GetDbAdapter().GetMessageEntry(messageKey.PublicKey[:], messageKey.TstampNanos)
+	if err != nil {
+		return nil, err
+	}
 	if dbMessageEntry != nil {
 		bav._setMessageEntryMappings(dbMessageEntry)
 	}
-	return dbMessageEntry
+	return dbMessageEntry, nil
 }
 
 func (bav *UtxoView) _setMessageEntryMappings(messageEntry *MessageEntry) {
@@ -81,42 +86,19 @@ func (bav *UtxoView) GetMessagingGroupKeyToMessagingGroupEntryMapping(
 	if mapValue, exists := bav.MessagingGroupKeyToMessagingGroupEntry[*messagingGroupKey]; exists {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(
+		mappingMessagingGroupKeyToMessagingGroupEntry, *messagingGroupKey); exists {
+		return parentEntry.(*MessagingGroupEntry)
+	}
 
-	if bav.Postgres != nil {
-		var pgMessagingGroup PGMessagingGroup
-		err := bav.Postgres.db.Model(&pgMessagingGroup).Where("group_owner_public_key = ? and messaging_group_key_name = ?",
-			messagingGroupKey.OwnerPublicKey, messagingGroupKey.GroupKeyName).First()
-		if err != nil {
-			return nil
-		}
-
-		memberEntries := []*MessagingGroupMember{}
-		if err := gob.NewDecoder(
-			bytes.NewReader(pgMessagingGroup.MessagingGroupMembers)).Decode(&memberEntries); err != nil {
-			glog.Errorf("Error decoding MessagingGroupMembers from DB: %v", err)
-			return nil
-		}
-
-		messagingGroupEntry := &MessagingGroupEntry{
-			GroupOwnerPublicKey:   pgMessagingGroup.GroupOwnerPublicKey,
-			MessagingPublicKey:    pgMessagingGroup.MessagingPublicKey,
-			MessagingGroupKeyName: pgMessagingGroup.MessagingGroupKeyName,
-			MessagingGroupMembers: memberEntries,
-		}
+	// If we get here it means no value exists in our in-memory map. In this case,
+	// defer to the db. If a mapping exists in the db, return it. If not, return
+	// nil. Either way, save the value to the in-memory UtxoView mapping.
+	messagingGroupEntry := bav.GetDbAdapter().GetMessagingGroupEntry(messagingGroupKey)
+	if messagingGroupEntry != nil {
 		bav._setMessagingGroupKeyToMessagingGroupEntryMapping(&messagingGroupKey.OwnerPublicKey, messagingGroupEntry)
-		return messagingGroupEntry
-
-	} else {
-		// If we get here it means no value exists in our in-memory map. In this case,
-		// defer to the db. If a mapping exists in the db, return it. If not, return
-		// nil. Either way, save the value to the in-memory UtxoView mapping.
-		messagingGroupEntry := DBGetMessagingGroupEntry(bav.Handle, bav.Snapshot, messagingGroupKey)
-		if messagingGroupEntry != nil {
-			bav._setMessagingGroupKeyToMessagingGroupEntryMapping(&messagingGroupKey.OwnerPublicKey, messagingGroupEntry)
-		}
-		return messagingGroupEntry
-
 	}
+	return messagingGroupEntry
 }
 
 func (bav *UtxoView) _setMessagingGroupKeyToMessagingGroupEntryMapping(ownerPublicKey *PublicKey,
@@ -158,6 +140,9 @@ func (bav *UtxoView) getMessage(messageHash *BlockHash) *PGMessage {
 	if existsMapValue {
 		return mapValue
 	}
+	if parentMessage, exists := bav._pullParentMapping(mappingMessageMap, *messageHash); exists {
+		return parentMessage.(*PGMessage)
+	}
 
 	message := bav.Postgres.GetMessage(messageHash)
 	if message != nil {
@@ -185,6 +170,8 @@ func (bav *UtxoView) GetMessagingGroupEntriesForUser(ownerPublicKey []byte) (
 	// This is our helper map to keep track of all user messaging keys.
 	messagingKeysMap := make(map[MessagingGroupKey]*MessagingGroupEntry)
 
+	bav._pullParentMappings(mappingMessagingGroupKeyToMessagingGroupEntry)
+
 	// Start by fetching all the messaging keys that we have in the UtxoView.
 	for messagingKey, messagingKeyEntry := range bav.MessagingGroupKeyToMessagingGroupEntry {
 		// We don't check for deleted entries now, we will do that later once we add messaging keys
@@ -206,7 +193,7 @@ func (bav *UtxoView) GetMessagingGroupEntriesForUser(ownerPublicKey []byte) (
 	}
 
 	// We fetched all the entries from the UtxoView, so we move to the DB.
-	dbMessagingKeys, err := DBGetAllUserGroupEntries(bav.Handle, ownerPublicKey)
+	dbMessagingKeys, err := bav.GetDbAdapter().GetAllUserGroupEntries(ownerPublicKey)
 	if err != nil {
 		return nil, errors.Wrapf(err, "GetUserMessagingKeys: problem getting "+
 			"messaging keys from the DB")
@@ -233,14 +220,12 @@ func (bav *UtxoView) GetMessagingGroupEntriesForUser(ownerPublicKey []byte) (
 	return retMessagingKeyEntries, nil
 }
 
-// TODO: Update for Postgres
 func (bav *UtxoView) GetMessagesForUser(publicKey []byte) (
 	_messageEntries []*MessageEntry, _messagingKeyEntries []*MessagingGroupEntry, _err error) {
 
 	return bav.GetLimitedMessagesForUser(publicKey, math.MaxUint64)
 }
 
-// TODO: Update for Postgres
 func (bav *UtxoView) GetLimitedMessagesForUser(ownerPublicKey []byte, limit uint64) (
 	_messageEntries []*MessageEntry, _messagingGroupEntries []*MessagingGroupEntry, _err error) {
 
@@ -257,6 +242,8 @@ func (bav *UtxoView) GetLimitedMessagesForUser(ownerPublicKey []byte, limit uint
 	// We define an auxiliary map to keep track of messages in UtxoView and DB.
 	messagesMap := make(map[MessageKey]*MessageEntry)
 
+	bav._pullParentMappings(mappingMessageKeyToMessageEntry)
+
 	// First look for messages in the UtxoView. We don't skip deleted entries for now as we will do it later.
 	for messageKey, messageEntry := range bav.MessageKeyToMessageEntry {
 		for _, messagingKeyEntry := range messagingGroupEntries {
@@ -271,7 +258,7 @@ func (bav *UtxoView) GetLimitedMessagesForUser(ownerPublicKey []byte, limit uint
 	}
 
 	// We fetched all UtxoView entries, so now look for messages in the DB.
-	dbMessageEntries, err := DBGetLimitedMessageForMessagingKeys(bav.Handle, messagingGroupEntries, limit)
+	dbMessageEntries, err := bav.GetDbAdapter().GetLimitedMessageEntriesForMessagingKeys(messagingGroupEntries, limit)
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "GetMessagesForUser: Problem fetching MessageEntries from db: ")
 	}
@@ -518,14 +505,20 @@ func (bav *UtxoView) _connectPrivateMessage(
 		// private messages by the messaging public keys, rather than sender/owner main keys. This is
 		// particularly useful in group messages, and allows us to later fetch messages from DB more efficiently.
 		senderMessageKey := MakeMessageKey(messageEntry.SenderMessagingPublicKey[:], txMeta.TimestampNanos)
-		senderMessage := bav._getMessageEntryForMessageKey(&senderMessageKey)
+		senderMessage, err := bav._getMessageEntryForMessageKey(&senderMessageKey)
+		if err != nil {
+			return 0, 0, nil, errors.Wrapf(err, "_connectPrivateMessage: Problem fetching message entry")
+		}
 		if senderMessage != nil && !senderMessage.isDeleted {
 			return 0, 0, nil, errors.Wrapf(
 				RuleErrorPrivateMessageExistsWithSenderPublicKeyTstampTuple,
 				"_connectPrivateMessage: Message key: %v", &senderMessageKey)
 		}
 		recipientMessageKey := MakeMessageKey(messageEntry.RecipientMessagingPublicKey[:], txMeta.TimestampNanos)
-		recipientMessage := bav._getMessageEntryForMessageKey(&recipientMessageKey)
+		recipientMessage, err := bav._getMessageEntryForMessageKey(&recipientMessageKey)
+		if err != nil {
+			return 0, 0, nil, errors.Wrapf(err, "_connectPrivateMessage: Problem fetching message entry")
+		}
 		if recipientMessage != nil && !recipientMessage.isDeleted {
 			return 0, 0, nil, errors.Wrapf(
 				RuleErrorPrivateMessageExistsWithRecipientPublicKeyTstampTuple,
@@ -543,21 +536,26 @@ func (bav *UtxoView) _connectPrivateMessage(
 	// <OwnerPublicKey, TstampNanos> tuple. We also know that the sender and recipient
 	// have different public keys.
 
+	// Set the mappings in our in-memory map for the MessageEntry.
+	bav._setMessageEntryMappings(messageEntry)
+
+	// Postgres flushes messages by hash so they're tracked in a separate map.
 	if bav.Postgres != nil {
-		//TODO: Fix Postgres
 		message := &PGMessage{
-			MessageHash:        txn.Hash(),
-			SenderPublicKey:    txn.PublicKey,
-			RecipientPublicKey: txMeta.RecipientPublicKey,
-			EncryptedText:      txMeta.EncryptedText,
-			TimestampNanos:     txMeta.TimestampNanos,
-			ExtraData:          extraData,
+			MessageHash:                    txn.Hash(),
+			SenderPublicKey:                txn.PublicKey,
+			RecipientPublicKey:             txMeta.RecipientPublicKey,
+			EncryptedText:                  txMeta.EncryptedText,
+			TimestampNanos:                 txMeta.TimestampNanos,
+			ExtraData:                      extraData,
+			Version:                        version,
+			SenderMessagingPublicKey:       messageEntry.SenderMessagingPublicKey,
+			SenderMessagingGroupKeyName:    messageEntry.SenderMessagingGroupKeyName,
+			RecipientMessagingPublicKey:    messageEntry.RecipientMessagingPublicKey,
+			RecipientMessagingGroupKeyName: messageEntry.RecipientMessagingGroupKeyName,
 		}
 
 		bav.setMessageMappings(message)
-	} else {
-		// Set the mappings in our in-memory map for the MessageEntry.
-		bav._setMessageEntryMappings(messageEntry)
 	}
 
 	// Add an operation to the list at the end indicating we've added a message
@@ -569,7 +567,6 @@ func (bav *UtxoView) _connectPrivateMessage(
 	return totalInput, totalOutput, utxoOpsForTxn, nil
 }
 
-// TODO: Update for postgres
 func (bav *UtxoView) _disconnectPrivateMessage(
 	operationType OperationType, currentTxn *MsgDeSoTxn, txnHash *BlockHash,
 	utxoOpsForTxn []*UtxoOperation, blockHeight uint32) error {
@@ -631,7 +628,10 @@ func (bav *UtxoView) _disconnectPrivateMessage(
 	// entries, one for the sender and one for the recipient, but for now let's only validate
 	// the sender's entry.
 	senderMessageKey := MakeMessageKey(senderPkBytes, txMeta.TimestampNanos)
-	senderMessageEntry := bav._getMessageEntryForMessageKey(&senderMessageKey)
+	senderMessageEntry, err := bav._getMessageEntryForMessageKey(&senderMessageKey)
+	if err != nil {
+		return errors.Wrapf(err, "_disconnectPrivateMessage: Problem fetching sender message entry")
+	}
 	if senderMessageEntry == nil || senderMessageEntry.isDeleted {
 		return fmt.Errorf("_disconnectPrivateMessage: MessageEntry for "+
 			"SenderMessageKey %v was found to be nil or deleted: %v",
@@ -685,7 +685,10 @@ func (bav *UtxoView) _disconnectPrivateMessage(
 
 	// We passed all sanity checks so now fetch the recipient entry and make sure it wasn't deleted.
 	recipientMessageKey := MakeMessageKey(recipientPkBytes, txMeta.TimestampNanos)
-	recipientMessageEntry := bav._getMessageEntryForMessageKey(&senderMessageKey)
+	recipientMessageEntry, err := bav._getMessageEntryForMessageKey(&senderMessageKey)
+	if err != nil {
+		return errors.Wrapf(err, "_disconnectPrivateMessage: Problem fetching recipient message entry")
+	}
 	if recipientMessageEntry == nil || recipientMessageEntry.isDeleted {
 		return fmt.Errorf("_disconnectPrivateMessage: MessageEntry (%v) for "+
 			"RecipientMessageKey (%v) was found to be nil or deleted",
@@ -706,6 +709,9 @@ func (bav *UtxoView) _disconnectPrivateMessage(
 	// rolling back, use the entry to delete the mappings for this message.
 	// Both entries will be deleted at the same time.
 	bav._deleteMessageEntryMappings(senderMessageEntry)
+	if bav.Postgres != nil {
+		bav.deleteMessageMappings(&PGMessage{MessageHash: txnHash})
+	}
 
 	// Now revert the basic transfer with the remaining operations. Cut off
 	// the PrivateMessage operation at the end since we just reverted it.
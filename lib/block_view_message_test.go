@@ -5,7 +5,6 @@ import (
 	"encoding/hex"
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"reflect"
@@ -38,7 +37,7 @@ func TestBasePointSignature(t *testing.T) {
 }
 
 func _privateMessage(
-	t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams, feeRateNanosPerKB uint64,
+	t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams, feeRateNanosPerKB uint64,
 	senderPkBase58Check string, recipientPkBase58Check string, senderPrivBase58Check string,
 	unencryptedMessageText string, tstampNanos uint64) (
 	_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error,
@@ -49,7 +48,7 @@ func _privateMessage(
 	)
 }
 
-func _privateMessageWithExtraData(t *testing.T, chain *Blockchain, db *badger.DB,
+func _privateMessageWithExtraData(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, senderPkBase58Check string,
 	recipientPkBase58Check string,
 	senderPrivBase58Check string, unencryptedMessageText string, tstampNanos uint64, extraData map[string][]byte) (
@@ -620,13 +619,13 @@ func _generateMessagingKey(senderPub []byte, senderPriv []byte, keyName []byte)
 }
 
 // _messagingKey adds a messaging key entry to a new utxo and flushes to DB.
-func _messagingKey(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _messagingKey(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	senderPk []byte, signerPriv string, messagingPublicKey []byte, messagingKeyName []byte,
 	keySignature []byte, recipients []*MessagingGroupMember) ([]*UtxoOperation, *MsgDeSoTxn, error) {
 	return _messagingKeyWithExtraData(t, chain, db, params, senderPk, signerPriv, messagingPublicKey, messagingKeyName, keySignature, recipients, nil)
 }
 
-func _messagingKeyWithExtraData(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _messagingKeyWithExtraData(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	senderPk []byte, signerPriv string, messagingPublicKey []byte, messagingKeyName []byte,
 	keySignature []byte, recipients []*MessagingGroupMember, extraData map[string][]byte) (
 	[]*UtxoOperation, *MsgDeSoTxn, error) {
@@ -718,7 +717,7 @@ func _verifyAddedMessagingKeys(testMeta *TestMeta, publicKey []byte, expectedEnt
 	require := require.New(testMeta.t)
 	assert := assert.New(testMeta.t)
 
-	require.NoError(testMeta.chain.db.View(func(txn *badger.Txn) error {
+	require.NoError(testMeta.chain.db.View(func(txn KVTxn) error {
 		// Get the DB record.
 		entries, err := DBGetAllUserGroupEntiresWithTxn(txn, publicKey)
 		require.NoError(err)
@@ -1713,12 +1712,14 @@ func _verifyMessageParty(testMeta *TestMeta, expectedMessageEntries map[PublicKe
 	utxoView, err := NewUtxoView(testMeta.db, testMeta.params, nil, testMeta.chain.snapshot)
 	require.NoError(err)
 	messageKey := MakeMessageKey(expectedEntry.SenderMessagingPublicKey[:], expectedEntry.TstampNanos)
-	messageEntrySender := utxoView._getMessageEntryForMessageKey(&messageKey)
+	messageEntrySender, err := utxoView._getMessageEntryForMessageKey(&messageKey)
+	require.NoError(err)
 	if messageEntrySender == nil || messageEntrySender.isDeleted {
 		return false
 	}
 	messageKey = MakeMessageKey(expectedEntry.RecipientMessagingPublicKey[:], expectedEntry.TstampNanos)
-	messageEntryRecipient := utxoView._getMessageEntryForMessageKey(&messageKey)
+	messageEntryRecipient, err := utxoView._getMessageEntryForMessageKey(&messageKey)
+	require.NoError(err)
 	if messageEntryRecipient == nil || messageEntryRecipient.isDeleted {
 		return false
 	}
@@ -2372,7 +2373,7 @@ func TestGroupMessages(t *testing.T) {
 		verifyGangMessage := func(msg, pk, priv []byte) {
 			// Get all user messages from the DB.
 			var msgKeys []*MessagingGroupEntry
-			require.NoError(db.View(func(txn *badger.Txn) error {
+			require.NoError(db.View(func(txn KVTxn) error {
 				msgKeys, err = DBGetAllMessagingGroupEntriesForMemberWithTxn(txn, NewPublicKey(pk))
 				return err
 			}))
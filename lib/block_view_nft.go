@@ -41,20 +41,14 @@ func (bav *UtxoView) GetNFTEntryForNFTKey(nftKey *NFTKey) *NFTEntry {
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingNFTKeyToNFTEntry, *nftKey); exists {
+		return parentEntry.(*NFTEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
-	var nftEntry *NFTEntry
-	if bav.Postgres != nil {
-		nft := bav.Postgres.GetNFT(&nftKey.NFTPostHash, nftKey.SerialNumber)
-		if nft != nil {
-			nftEntry = nft.NewNFTEntry()
-		}
-	} else {
-		nftEntry = DBGetNFTEntryByPostHashSerialNumber(bav.Handle, bav.Snapshot,
-			&nftKey.NFTPostHash, nftKey.SerialNumber)
-	}
+	nftEntry := bav.GetDbAdapter().GetNFTEntry(&nftKey.NFTPostHash, nftKey.SerialNumber)
 
 	if nftEntry != nil {
 		bav._setNFTEntryMappings(nftEntry)
@@ -64,15 +58,9 @@ func (bav *UtxoView) GetNFTEntryForNFTKey(nftKey *NFTKey) *NFTEntry {
 
 func (bav *UtxoView) GetNFTEntriesForPostHash(nftPostHash *BlockHash) []*NFTEntry {
 	// Get all the entries in the DB.
-	var dbNFTEntries []*NFTEntry
-	if bav.Postgres != nil {
-		nfts := bav.Postgres.GetNFTsForPostHash(nftPostHash)
-		for _, nft := range nfts {
-			dbNFTEntries = append(dbNFTEntries, nft.NewNFTEntry())
-		}
-	} else {
-		dbNFTEntries = DBGetNFTEntriesForPostHash(bav.Handle, nftPostHash)
-	}
+	dbNFTEntries := bav.GetDbAdapter().GetNFTEntriesForPostHash(nftPostHash)
+
+	bav._pullParentMappings(mappingNFTKeyToNFTEntry)
 
 	// Make sure all of the DB entries are loaded in the view.
 	for _, dbNFTEntry := range dbNFTEntries {
@@ -95,15 +83,9 @@ func (bav *UtxoView) GetNFTEntriesForPostHash(nftPostHash *BlockHash) []*NFTEntr
 }
 
 func (bav *UtxoView) GetNFTEntriesForPKID(ownerPKID *PKID) []*NFTEntry {
-	var dbNFTEntries []*NFTEntry
-	if bav.Postgres != nil {
-		nfts := bav.Postgres.GetNFTsForPKID(ownerPKID)
-		for _, nft := range nfts {
-			dbNFTEntries = append(dbNFTEntries, nft.NewNFTEntry())
-		}
-	} else {
-		dbNFTEntries = DBGetNFTEntriesForPKID(bav.Handle, ownerPKID)
-	}
+	dbNFTEntries := bav.GetDbAdapter().GetNFTEntriesForPKID(ownerPKID)
+
+	bav._pullParentMappings(mappingNFTKeyToNFTEntry)
 
 	// Make sure all of the DB entries are loaded in the view.
 	for _, dbNFTEntry := range dbNFTEntries {
@@ -126,15 +108,9 @@ func (bav *UtxoView) GetNFTEntriesForPKID(ownerPKID *PKID) []*NFTEntry {
 }
 
 func (bav *UtxoView) GetNFTBidEntriesForPKID(bidderPKID *PKID) (_nftBidEntries []*NFTBidEntry) {
-	var dbNFTBidEntries []*NFTBidEntry
-	if bav.Postgres != nil {
-		bids := bav.Postgres.GetNFTBidsForPKID(bidderPKID)
-		for _, bid := range bids {
-			dbNFTBidEntries = append(dbNFTBidEntries, bid.NewNFTBidEntry())
-		}
-	} else {
-		dbNFTBidEntries = DBGetNFTBidEntriesForPKID(bav.Handle, bidderPKID)
-	}
+	dbNFTBidEntries := bav.GetDbAdapter().GetNFTBidEntriesForPKID(bidderPKID)
+
+	bav._pullParentMappings(mappingNFTBidKeyToNFTBidEntry)
 
 	// Make sure all of the DB entries are loaded in the view.
 	for _, dbNFTBidEntry := range dbNFTBidEntries {
@@ -177,6 +153,8 @@ func (bav *UtxoView) GetHighAndLowBidsForNFTCollection(
 		}
 	}
 
+	bav._pullParentMappings(mappingNFTBidKeyToNFTBidEntry)
+
 	// Then we loop over the view to for anything we missed.
 	for _, nftBidEntry := range bav.NFTBidKeyToNFTBidEntry {
 		if !nftBidEntry.isDeleted && reflect.DeepEqual(nftBidEntry.NFTPostHash, nftHash) {
@@ -195,6 +173,8 @@ func (bav *UtxoView) GetHighAndLowBidsForNFTCollection(
 
 // TODO: Postgres
 func (bav *UtxoView) GetHighAndLowBidsForNFTSerialNumber(nftHash *BlockHash, serialNumber uint64) (_highBid uint64, _lowBid uint64) {
+	bav._pullParentMappings(mappingNFTBidKeyToNFTBidEntry)
+
 	highBid := uint64(0)
 	lowBid := uint64(0)
 
@@ -247,18 +227,18 @@ func (bav *UtxoView) GetDBHighAndLowBidsForNFT(nftHash *BlockHash, serialNumber
 
 // This function gets the highest and lowest bids for a specific NFT that
 // have not been deleted in the view.
-// TODO: Postgres
 func (bav *UtxoView) GetDBHighAndLowBidEntriesForNFT(
 	nftHash *BlockHash, serialNumber uint64,
 ) (_highBidEntry *NFTBidEntry, _lowBidEntry *NFTBidEntry) {
 	numPerDBFetch := 5
 	var highestBidEntry *NFTBidEntry
 	var lowestBidEntry *NFTBidEntry
+	bav._pullParentMappings(mappingNFTBidKeyToNFTBidEntry)
 
 	// Loop until we find the highest bid in the database that hasn't been deleted in the view.
 	exitLoop := false
-	highBidEntries := DBGetNFTBidEntriesPaginated(
-		bav.Handle, nftHash, serialNumber, nil, numPerDBFetch, true)
+	highBidEntries := bav.GetDbAdapter().GetNFTBidEntriesPaginated(
+		nftHash, serialNumber, nil, numPerDBFetch, true)
 	for _, bidEntry := range highBidEntries {
 		bidEntryKey := MakeNFTBidKey(bidEntry.BidderPKID, bidEntry.NFTPostHash, bidEntry.SerialNumber)
 		if _, exists := bav.NFTBidKeyToNFTBidEntry[bidEntryKey]; !exists {
@@ -287,16 +267,16 @@ func (bav *UtxoView) GetDBHighAndLowBidEntriesForNFT(
 			break
 		} else {
 			nextStartEntry := highBidEntries[len(highBidEntries)-1]
-			highBidEntries = DBGetNFTBidEntriesPaginated(
-				bav.Handle, nftHash, serialNumber, nextStartEntry, numPerDBFetch, true,
+			highBidEntries = bav.GetDbAdapter().GetNFTBidEntriesPaginated(
+				nftHash, serialNumber, nextStartEntry, numPerDBFetch, true,
 			)
 		}
 	}
 
 	// Loop until we find the lowest bid in the database that hasn't been deleted in the view.
 	exitLoop = false
-	lowBidEntries := DBGetNFTBidEntriesPaginated(
-		bav.Handle, nftHash, serialNumber, nil, numPerDBFetch, false)
+	lowBidEntries := bav.GetDbAdapter().GetNFTBidEntriesPaginated(
+		nftHash, serialNumber, nil, numPerDBFetch, false)
 	for _, bidEntry := range lowBidEntries {
 		bidEntryKey := MakeNFTBidKey(bidEntry.BidderPKID, bidEntry.NFTPostHash, bidEntry.SerialNumber)
 		if _, exists := bav.NFTBidKeyToNFTBidEntry[bidEntryKey]; !exists {
@@ -325,8 +305,8 @@ func (bav *UtxoView) GetDBHighAndLowBidEntriesForNFT(
 			break
 		} else {
 			nextStartEntry := lowBidEntries[len(lowBidEntries)-1]
-			lowBidEntries = DBGetNFTBidEntriesPaginated(
-				bav.Handle, nftHash, serialNumber, nextStartEntry, numPerDBFetch, false,
+			lowBidEntries = bav.GetDbAdapter().GetNFTBidEntriesPaginated(
+				nftHash, serialNumber, nextStartEntry, numPerDBFetch, false,
 			)
 		}
 	}
@@ -351,12 +331,14 @@ func (bav *UtxoView) GetAcceptNFTBidHistoryForNFTKey(nftKey *NFTKey) *[]*NFTBidE
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntries, exists := bav._pullParentMapping(mappingNFTKeyToAcceptedNFTBidHistory, *nftKey); exists {
+		return parentEntries.(*[]*NFTBidEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
-	dbNFTBidEntries := DBGetAcceptedNFTBidEntriesByPostHashSerialNumber(
-		bav.Handle, bav.Snapshot, &nftKey.NFTPostHash, nftKey.SerialNumber)
+	dbNFTBidEntries := bav.GetDbAdapter().GetAcceptedNFTBidEntries(&nftKey.NFTPostHash, nftKey.SerialNumber)
 	if dbNFTBidEntries != nil {
 		bav._setAcceptNFTBidHistoryMappings(*nftKey, dbNFTBidEntries)
 		return dbNFTBidEntries
@@ -393,19 +375,14 @@ func (bav *UtxoView) GetNFTBidEntryForNFTBidKey(nftBidKey *NFTBidKey) *NFTBidEnt
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingNFTBidKeyToNFTBidEntry, *nftBidKey); exists {
+		return parentEntry.(*NFTBidEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
-	var dbNFTBidEntry *NFTBidEntry
-	if bav.Postgres != nil {
-		bidEntry := bav.Postgres.GetNFTBid(&nftBidKey.NFTPostHash, &nftBidKey.BidderPKID, nftBidKey.SerialNumber)
-		if bidEntry != nil {
-			dbNFTBidEntry = bidEntry.NewNFTBidEntry()
-		}
-	} else {
-		dbNFTBidEntry = DBGetNFTBidEntryForNFTBidKey(bav.Handle, bav.Snapshot, nftBidKey)
-	}
+	dbNFTBidEntry := bav.GetDbAdapter().GetNFTBidEntry(nftBidKey)
 
 	if dbNFTBidEntry != nil {
 		bav._setNFTBidEntryMappings(dbNFTBidEntry)
@@ -416,15 +393,9 @@ func (bav *UtxoView) GetNFTBidEntryForNFTBidKey(nftBidKey *NFTBidKey) *NFTBidEnt
 
 func (bav *UtxoView) GetAllNFTBidEntries(nftPostHash *BlockHash, serialNumber uint64) []*NFTBidEntry {
 	// Get all the entries in the DB.
-	var dbEntries []*NFTBidEntry
-	if bav.Postgres != nil {
-		bids := bav.Postgres.GetNFTBidsForSerial(nftPostHash, serialNumber)
-		for _, bid := range bids {
-			dbEntries = append(dbEntries, bid.NewNFTBidEntry())
-		}
-	} else {
-		dbEntries = DBGetNFTBidEntries(bav.Handle, nftPostHash, serialNumber)
-	}
+	dbEntries := bav.GetDbAdapter().GetNFTBidEntries(nftPostHash, serialNumber)
+
+	bav._pullParentMappings(mappingNFTBidKeyToNFTBidEntry)
 
 	// Make sure all of the DB entries are loaded in the view.
 	for _, dbEntry := range dbEntries {
@@ -1322,7 +1293,8 @@ func (bav *UtxoView) _helpConnectNFTSold(args HelpConnectNFTSoldStruct) (
 	prevAcceptedBidHistory := bav.GetAcceptNFTBidHistoryForNFTKey(&nftKey)
 	acceptedNFTBidEntry := nftBidEntry.Copy()
 	acceptedNFTBidEntry.AcceptedBlockHeight = &blockHeight
-	newAcceptedBidHistory := append(*prevAcceptedBidHistory, acceptedNFTBidEntry)
+	// The previous history may share its backing array with the history of a parent view, so we append to a copy.
+	newAcceptedBidHistory := append(append([]*NFTBidEntry{}, *prevAcceptedBidHistory...), acceptedNFTBidEntry)
 	bav._setAcceptNFTBidHistoryMappings(nftKey, &newAcceptedBidHistory)
 
 	// (2) Iterate over all the NFTBidEntries for this NFT and delete them.
@@ -1,7 +1,6 @@
 package lib
 
 import (
-	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"math"
@@ -9,7 +8,7 @@ import (
 	"testing"
 )
 
-func _createNFTWithAdditionalRoyalties(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _createNFTWithAdditionalRoyalties(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, updaterPkBase58Check string, updaterPrivBase58Check string,
 	nftPostHash *BlockHash, numCopies uint64, hasUnlockable bool, isForSale bool, minBidAmountNanos uint64,
 	nftFee uint64, nftRoyaltyToCreatorBasisPoints uint64, nftRoyaltyToCoinBasisPoints uint64, isBuyNow bool,
@@ -33,7 +32,7 @@ func _createNFTWithAdditionalRoyalties(t *testing.T, chain *Blockchain, db *badg
 		nil)
 }
 
-func _createNFTWithExtraData(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _createNFTWithExtraData(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, updaterPkBase58Check string, updaterPrivBase58Check string,
 	nftPostHash *BlockHash, numCopies uint64, hasUnlockable bool, isForSale bool, minBidAmountNanos uint64,
 	nftFee uint64, nftRoyaltyToCreatorBasisPoints uint64, nftRoyaltyToCoinBasisPoints uint64, isBuyNow bool,
@@ -104,7 +103,7 @@ func _createNFTWithExtraData(t *testing.T, chain *Blockchain, db *badger.DB, par
 	return utxoOps, txn, blockHeight, nil
 }
 
-func _createNFT(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _createNFT(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, updaterPkBase58Check string, updaterPrivBase58Check string,
 	nftPostHash *BlockHash, numCopies uint64, hasUnlockable bool, isForSale bool, minBidAmountNanos uint64,
 	nftFee uint64, nftRoyaltyToCreatorBasisPoints uint64, nftRoyaltyToCoinBasisPoints uint64, isBuyNow bool,
@@ -259,7 +258,7 @@ func _createNFTWithTestMeta(
 	)
 }
 
-func _createNFTBid(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _createNFTBid(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, updaterPkBase58Check string, updaterPrivBase58Check string,
 	nftPostHash *BlockHash, serialNumber uint64, bidAmountNanos uint64,
 ) (_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -338,7 +337,7 @@ func _createNFTBidWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _acceptNFTBid(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _acceptNFTBid(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, updaterPkBase58Check string, updaterPrivBase58Check string, nftPostHash *BlockHash,
 	serialNumber uint64, bidderPkBase58Check string, bidAmountNanos uint64, unencryptedUnlockableText string,
 ) (_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -446,7 +445,7 @@ func _acceptNFTBidWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _updateNFT(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _updateNFT(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, updaterPkBase58Check string, updaterPrivBase58Check string,
 	nftPostHash *BlockHash, serialNumber uint64, isForSale bool, minBidAmountNanos uint64, isBuyNow bool,
 	buyNowPriceNanos uint64,
@@ -538,7 +537,7 @@ func _updateNFTWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _transferNFT(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _transferNFT(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, senderPk string, senderPriv string, receiverPk string,
 	nftPostHash *BlockHash, serialNumber uint64, unlockableText string,
 ) (_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -626,7 +625,7 @@ func _transferNFTWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _acceptNFTTransfer(t *testing.T, chain *Blockchain, db *badger.DB,
+func _acceptNFTTransfer(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, updaterPkBase58Check string,
 	updaterPrivBase58Check string, nftPostHash *BlockHash, serialNumber uint64,
 ) (_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -705,7 +704,7 @@ func _acceptNFTTransferWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _burnNFT(t *testing.T, chain *Blockchain, db *badger.DB,
+func _burnNFT(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, updaterPkBase58Check string,
 	updaterPrivBase58Check string, nftPostHash *BlockHash, serialNumber uint64,
 ) (_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -5,10 +5,8 @@ import (
 	"fmt"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/davecgh/go-spew/spew"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
-	"math"
 	"reflect"
 	"sort"
 )
@@ -19,12 +17,14 @@ func (bav *UtxoView) _getRepostEntryForRepostKey(repostKey *RepostKey) *RepostEn
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingRepostKeyToRepostEntry, *repostKey); exists {
+		return parentEntry.(*RepostEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil. Either way, save the value to the in-memory view mapping got later.
-	repostEntry := DbReposterPubKeyRepostedPostHashToRepostEntry(bav.Handle, bav.Snapshot,
-		repostKey.ReposterPubKey[:], repostKey.RepostedPostHash)
+	repostEntry := bav.GetDbAdapter().GetRepostEntry(repostKey.ReposterPubKey[:], repostKey.RepostedPostHash)
 	if repostEntry != nil {
 		bav._setRepostEntryMappings(repostEntry)
 	}
@@ -87,25 +87,15 @@ func (bav *UtxoView) GetDiamondEntryForDiamondKey(diamondKey *DiamondKey) *Diamo
 	if existsMapValue {
 		return bavDiamondEntry
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingDiamondKeyToDiamondEntry, *diamondKey); exists {
+		return parentEntry.(*DiamondEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
-	var diamondEntry *DiamondEntry
-	if bav.Postgres != nil {
-		diamond := bav.Postgres.GetDiamond(&diamondKey.SenderPKID, &diamondKey.ReceiverPKID, &diamondKey.DiamondPostHash)
-		if diamond != nil {
-			diamondEntry = &DiamondEntry{
-				SenderPKID:      diamond.SenderPKID,
-				ReceiverPKID:    diamond.ReceiverPKID,
-				DiamondPostHash: diamond.DiamondPostHash,
-				DiamondLevel:    int64(diamond.DiamondLevel),
-			}
-		}
-	} else {
-		diamondEntry = DbGetDiamondMappings(bav.Handle, bav.Snapshot,
-			&diamondKey.ReceiverPKID, &diamondKey.SenderPKID, &diamondKey.DiamondPostHash)
-	}
+	diamondEntry := bav.GetDbAdapter().GetDiamondEntry(
+		&diamondKey.SenderPKID, &diamondKey.ReceiverPKID, &diamondKey.DiamondPostHash)
 
 	if diamondEntry != nil {
 		bav._setDiamondEntryMappings(diamondEntry)
@@ -120,47 +110,42 @@ func (bav *UtxoView) GetPostEntryForPostHash(postHash *BlockHash) *PostEntry {
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingPostHashToPostEntry, *postHash); exists {
+		return parentEntry.(*PostEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
-	if bav.Postgres != nil {
-		post := bav.Postgres.GetPost(postHash)
-		if post != nil {
-			return bav.setPostMappings(post)
-		}
-		return nil
-	} else {
-		dbPostEntry := DBGetPostEntryByPostHash(bav.Handle, bav.Snapshot, postHash)
-		if dbPostEntry != nil {
-			bav._setPostEntryMappings(dbPostEntry)
-		}
-		return dbPostEntry
+	dbPostEntry := bav.GetDbAdapter().GetPostEntry(postHash)
+	if dbPostEntry != nil {
+		bav._setPostEntryMappings(dbPostEntry)
 	}
+	return dbPostEntry
 }
 
 func (bav *UtxoView) GetDiamondEntryMapForPublicKey(publicKey []byte, fetchYouDiamonded bool,
 ) (_pkidToDiamondsMap map[PKID][]*DiamondEntry, _err error) {
 	pkidEntry := bav.GetPKIDForPublicKey(publicKey)
 
-	dbPKIDToDiamondsMap, err := DbGetPKIDsThatDiamondedYouMap(bav.Handle, pkidEntry.PKID, fetchYouDiamonded)
+	dbDiamondEntries, err := bav.GetDbAdapter().GetDiamondEntriesForPKID(pkidEntry.PKID, fetchYouDiamonded)
 	if err != nil {
 		return nil, errors.Wrapf(err, "GetDiamondEntryMapForPublicKey: Error Getting "+
 			"PKIDs that diamonded you map from the DB.")
 	}
 
+	bav._pullParentMappings(mappingDiamondKeyToDiamondEntry)
+
 	// Load all of the diamondEntries into the view.
-	for _, diamondEntryList := range dbPKIDToDiamondsMap {
-		for _, diamondEntry := range diamondEntryList {
-			diamondKey := &DiamondKey{
-				SenderPKID:      *diamondEntry.SenderPKID,
-				ReceiverPKID:    *diamondEntry.ReceiverPKID,
-				DiamondPostHash: *diamondEntry.DiamondPostHash,
-			}
-			// If the diamond key is not in the view, add it to the view.
-			if _, ok := bav.DiamondKeyToDiamondEntry[*diamondKey]; !ok {
-				bav._setDiamondEntryMappings(diamondEntry)
-			}
+	for _, diamondEntry := range dbDiamondEntries {
+		diamondKey := &DiamondKey{
+			SenderPKID:      *diamondEntry.SenderPKID,
+			ReceiverPKID:    *diamondEntry.ReceiverPKID,
+			DiamondPostHash: *diamondEntry.DiamondPostHash,
+		}
+		// If the diamond key is not in the view, add it to the view.
+		if _, ok := bav.DiamondKeyToDiamondEntry[*diamondKey]; !ok {
+			bav._setDiamondEntryMappings(diamondEntry)
 		}
 	}
 
@@ -191,11 +176,14 @@ func (bav *UtxoView) GetDiamondEntriesForSenderToReceiver(receiverPublicKey []by
 
 	receiverPKIDEntry := bav.GetPKIDForPublicKey(receiverPublicKey)
 	senderPKIDEntry := bav.GetPKIDForPublicKey(senderPublicKey)
-	dbDiamondEntries, err := DbGetDiamondEntriesForSenderToReceiver(bav.Handle, receiverPKIDEntry.PKID, senderPKIDEntry.PKID)
+	dbDiamondEntries, err := bav.GetDbAdapter().GetDiamondEntriesForSenderToReceiver(
+		receiverPKIDEntry.PKID, senderPKIDEntry.PKID)
 	if err != nil {
 		return nil, errors.Wrapf(err, "GetDiamondEntriesForGiverToReceiver: Error getting diamond entries from DB.")
 	}
 
+	bav._pullParentMappings(mappingDiamondKeyToDiamondEntry)
+
 	// Load all of the diamondEntries into the view
 	for _, diamondEntry := range dbDiamondEntries {
 		diamondKey := &DiamondKey{
@@ -246,15 +234,6 @@ func (bav *UtxoView) _deletePostEntryMappings(postEntry *PostEntry) {
 	bav._setPostEntryMappings(&tombstonePostEntry)
 }
 
-func (bav *UtxoView) setPostMappings(post *PGPost) *PostEntry {
-	postEntry := post.NewPostEntry()
-
-	// Add a mapping for the post.
-	bav.PostHashToPostEntry[*post.PostHash] = postEntry
-
-	return postEntry
-}
-
 func (bav *UtxoView) GetPostEntryReaderState(
 	readerPK []byte, postEntry *PostEntry) *PostEntryReaderState {
 	postEntryReaderState := &PostEntryReaderState{}
@@ -301,25 +280,18 @@ func (bav *UtxoView) GetRepostPostEntryStateForReader(readerPK []byte, postHash
 }
 
 func (bav *UtxoView) GetCommentEntriesForParentStakeID(parentStakeID []byte) ([]*PostEntry, error) {
-	if bav.Postgres != nil {
-		posts := bav.Postgres.GetComments(NewBlockHash(parentStakeID))
-		for _, post := range posts {
-			bav.setPostMappings(post)
-		}
-	} else {
-		_, dbCommentHashes, _, err := DBGetCommentPostHashesForParentStakeID(
-			bav.Handle, bav.Snapshot, parentStakeID, false)
-		if err != nil {
-			return nil, errors.Wrapf(err, "GetCommentEntriesForParentStakeID: Problem fetching comments: %v", err)
-		}
+	dbCommentHashes, err := bav.GetDbAdapter().GetCommentPostHashesForParentStakeID(parentStakeID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetCommentEntriesForParentStakeID: Problem fetching comments: %v", err)
+	}
 
-		// Load comment hashes into the view.
-		for _, commentHash := range dbCommentHashes {
-			bav.GetPostEntryForPostHash(commentHash)
-		}
+	// Load comment hashes into the view.
+	for _, commentHash := range dbCommentHashes {
+		bav.GetPostEntryForPostHash(commentHash)
 	}
 
 	commentEntries := []*PostEntry{}
+	bav._pullParentMappings(mappingPostHashToPostEntry)
 	for _, postEntry := range bav.PostHashToPostEntry {
 		// Ignore deleted or rolled-back posts.
 		if postEntry.isDeleted {
@@ -384,7 +356,7 @@ func (bav *UtxoView) GetAllPosts() (_corePosts []*PostEntry, _commentsByPostHash
 	//
 	// TODO(performance): This currently fetches all posts. We should implement
 	// some kind of pagination instead though.
-	_, _, dbPostEntries, err := DBGetAllPostsByTstamp(bav.Handle, bav.Snapshot, true)
+	dbPostEntries, err := bav.GetDbAdapter().GetAllCorePostEntries()
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "GetAllPosts: Problem fetching PostEntry's from db: ")
 	}
@@ -396,6 +368,8 @@ func (bav *UtxoView) GetAllPosts() (_corePosts []*PostEntry, _commentsByPostHash
 		bav.GetPostEntryForPostHash(dbPostEntry.PostHash)
 	}
 
+	bav._pullParentMappings(mappingPostHashToPostEntry)
+
 	// Do one more pass to load all the comments from the DB.
 	for _, postEntry := range bav.PostHashToPostEntry {
 		// Ignore deleted or rolled-back posts.
@@ -409,8 +383,8 @@ func (bav *UtxoView) GetAllPosts() (_corePosts []*PostEntry, _commentsByPostHash
 		// comments.
 
 		if len(postEntry.ParentStakeID) == 0 {
-			_, dbCommentHashes, _, err := DBGetCommentPostHashesForParentStakeID(
-				bav.Handle, bav.Snapshot, postEntry.ParentStakeID, false)
+			dbCommentHashes, err := bav.GetDbAdapter().GetCommentPostHashesForParentStakeID(
+				postEntry.ParentStakeID)
 			if err != nil {
 				return nil, nil, errors.Wrapf(err, "GetAllPosts: Problem fetching comment PostEntry's from db: ")
 			}
@@ -451,96 +425,47 @@ func (bav *UtxoView) GetAllPosts() (_corePosts []*PostEntry, _commentsByPostHash
 }
 
 func (bav *UtxoView) GetPostsPaginatedForPublicKeyOrderedByTimestamp(publicKey []byte, startPostHash *BlockHash, limit uint64, mediaRequired bool, nftRequired bool) (_posts []*PostEntry, _err error) {
-	if bav.Postgres != nil {
-		var startTime uint64 = math.MaxUint64
-		if startPostHash != nil {
-			startPostEntry := bav.GetPostEntryForPostHash(startPostHash)
-			startTime = startPostEntry.TimestampNanos
+	var startPostEntry *PostEntry
+	if startPostHash != nil {
+		startPostEntry = bav.GetPostEntryForPostHash(startPostHash)
+		if startPostEntry == nil {
+			return nil, fmt.Errorf("GetPostsPaginatedForPublicKeyOrderedByTimestamp: Invalid start post hash")
 		}
-		posts := bav.Postgres.GetPostsForPublicKey(publicKey, startTime, limit)
-		for _, post := range posts {
-			// TODO: Normalize this field so we get the correct number of results from the DB
-			if mediaRequired && !post.HasMedia() {
-				continue
+	}
+	numPosts := uint64(0)
+	err := bav.GetDbAdapter().IteratePostHashesForPublicKeyByTimestamp(publicKey, startPostEntry,
+		func(postHash *BlockHash) (bool, error) {
+			if numPosts >= limit {
+				return false, nil
 			}
-			// nftRequired set to determine if we only want posts that are NFTs
-			if nftRequired && !post.NFT {
-				continue
+			postEntry := bav.GetPostEntryForPostHash(postHash)
+			if postEntry == nil {
+				return false, fmt.Errorf("Missing post entry")
 			}
-			bav.setPostMappings(post)
-		}
-	} else {
-		handle := bav.Handle
-		// FIXME: Db operation like this shouldn't happen in utxoview.
-		dbPrefix := append([]byte{}, Prefixes.PrefixPosterPublicKeyTimestampPostHash...)
-		dbPrefix = append(dbPrefix, publicKey...)
-		var prefix []byte
-		if startPostHash != nil {
-			startPostEntry := bav.GetPostEntryForPostHash(startPostHash)
-			if startPostEntry == nil {
-				return nil, fmt.Errorf("GetPostsPaginatedForPublicKeyOrderedByTimestamp: Invalid start post hash")
-			}
-			prefix = append(dbPrefix, EncodeUint64(startPostEntry.TimestampNanos)...)
-			prefix = append(prefix, startPostEntry.PostHash[:]...)
-		} else {
-			maxBigEndianUint64Bytes := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
-			prefix = append(dbPrefix, maxBigEndianUint64Bytes...)
-		}
-		timestampSizeBytes := 8
-		var posts []*PostEntry
-		err := handle.View(func(txn *badger.Txn) error {
-			opts := badger.DefaultIteratorOptions
-
-			opts.PrefetchValues = false
-
-			// Go in reverse order
-			opts.Reverse = true
-
-			it := txn.NewIterator(opts)
-			defer it.Close()
-			it.Seek(prefix)
-			if startPostHash != nil {
-				// Skip the first post if we have a startPostHash.
-				it.Next()
+			if postEntry.isDeleted || postEntry.ParentStakeID != nil || postEntry.IsHidden {
+				return true, nil
 			}
-			for ; it.ValidForPrefix(dbPrefix) && uint64(len(posts)) < limit; it.Next() {
-				rawKey := it.Item().Key()
-
-				keyWithoutPrefix := rawKey[1:]
-				//posterPublicKey := keyWithoutPrefix[:HashSizeBytes]
-				publicKeySizeBytes := HashSizeBytes + 1
-				//tstampNanos := DecodeUint64(keyWithoutPrefix[publicKeySizeBytes:(publicKeySizeBytes + timestampSizeBytes)])
-
-				postHash := &BlockHash{}
-				copy(postHash[:], keyWithoutPrefix[(publicKeySizeBytes+timestampSizeBytes):])
-				postEntry := bav.GetPostEntryForPostHash(postHash)
-				if postEntry == nil {
-					return fmt.Errorf("Missing post entry")
-				}
-				if postEntry.isDeleted || postEntry.ParentStakeID != nil || postEntry.IsHidden {
-					continue
-				}
-
-				// mediaRequired set to determine if we only want posts that include media and ignore posts without
-				if mediaRequired && !postEntry.HasMedia() {
-					continue
-				}
 
-				// nftRequired set to determine if we only want posts that are NFTs
-				if nftRequired && !postEntry.IsNFT {
-					continue
-				}
+			// mediaRequired set to determine if we only want posts that include media and ignore posts without
+			if mediaRequired && !postEntry.HasMedia() {
+				return true, nil
+			}
 
-				posts = append(posts, postEntry)
+			// nftRequired set to determine if we only want posts that are NFTs
+			if nftRequired && !postEntry.IsNFT {
+				return true, nil
 			}
-			return nil
+
+			numPosts++
+			return true, nil
 		})
-		if err != nil {
-			return nil, err
-		}
+	if err != nil {
+		return nil, err
 	}
 
 	var postEntries []*PostEntry
+	bav._pullParentMappings(mappingPostHashToPostEntry)
+
 	// Iterate over the view. Put all posts authored by the public key into our mempool posts slice
 	for _, postEntry := range bav.PostHashToPostEntry {
 		// Ignore deleted or hidden posts and any comments.
@@ -567,26 +492,16 @@ func (bav *UtxoView) GetPostsPaginatedForPublicKeyOrderedByTimestamp(publicKey [
 }
 
 func (bav *UtxoView) GetDiamondSendersForPostHash(postHash *BlockHash) (_pkidToDiamondLevel map[PKID]int64, _err error) {
-	handle := bav.Handle
-	// FIXME: Db operation like this shouldn't happen in utxoview.
-	dbPrefix := append([]byte{}, Prefixes.PrefixDiamondedPostHashDiamonderPKIDDiamondLevel...)
-	dbPrefix = append(dbPrefix, postHash[:]...)
-	keysFound, _ := EnumerateKeysForPrefix(handle, dbPrefix)
+	dbSenderPKIDs, err := bav.GetDbAdapter().GetDiamondSenderPKIDsForPostHash(postHash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "UtxoView.GetDiamondsForPostHash: ")
+	}
 
 	diamondPostEntry := bav.GetPostEntryForPostHash(postHash)
 	receiverPKIDEntry := bav.GetPKIDForPublicKey(diamondPostEntry.PosterPublicKey)
 
-	// Iterate over all the db keys & values and load them into the view.
-	expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed + 8
-	for _, key := range keysFound {
-		// Sanity check that this is a reasonable key.
-		if len(key) != expectedKeyLength {
-			return nil, fmt.Errorf("UtxoView.GetDiamondsForPostHash: Invalid key length found: %d", len(key))
-		}
-
-		senderPKID := &PKID{}
-		copy(senderPKID[:], key[1+HashSizeBytes:])
-
+	// Iterate over all the diamonds in the db and load them into the view.
+	for _, senderPKID := range dbSenderPKIDs {
 		diamondKey := &DiamondKey{
 			SenderPKID:      *senderPKID,
 			ReceiverPKID:    *receiverPKIDEntry.PKID,
@@ -598,6 +513,7 @@ func (bav *UtxoView) GetDiamondSendersForPostHash(postHash *BlockHash) (_pkidToD
 
 	// Iterate over the view and create the final map to return.
 	pkidToDiamondLevel := make(map[PKID]int64)
+	bav._pullParentMappings(mappingDiamondKeyToDiamondEntry)
 	for _, diamondEntry := range bav.DiamondKeyToDiamondEntry {
 		if !diamondEntry.isDeleted && reflect.DeepEqual(diamondEntry.DiamondPostHash[:], postHash[:]) {
 			pkidToDiamondLevel[*diamondEntry.SenderPKID] = diamondEntry.DiamondLevel
@@ -608,22 +524,13 @@ func (bav *UtxoView) GetDiamondSendersForPostHash(postHash *BlockHash) (_pkidToD
 }
 
 func (bav *UtxoView) GetRepostsForPostHash(postHash *BlockHash) (_reposterPubKeys [][]byte, _err error) {
-	handle := bav.Handle
-	// FIXME: Db operation like this shouldn't happen in utxoview.
-	dbPrefix := append([]byte{}, Prefixes.PrefixRepostedPostHashReposterPubKey...)
-	dbPrefix = append(dbPrefix, postHash[:]...)
-	keysFound, _ := EnumerateKeysForPrefix(handle, dbPrefix)
-
-	// Iterate over all the db keys & values and load them into the view.
-	expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed
-	for _, key := range keysFound {
-		// Sanity check that this is a reasonable key.
-		if len(key) != expectedKeyLength {
-			return nil, fmt.Errorf("UtxoView.GetRepostersForPostHash: Invalid key length found: %d", len(key))
-		}
-
-		reposterPubKey := key[1+HashSizeBytes:]
+	dbReposterPubKeys, err := bav.GetDbAdapter().GetReposterPublicKeysForPostHash(postHash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "UtxoView.GetRepostersForPostHash: ")
+	}
 
+	// Iterate over all the reposts in the db and load them into the view.
+	for _, reposterPubKey := range dbReposterPubKeys {
 		repostKey := &RepostKey{
 			ReposterPubKey:   MakePkMapKey(reposterPubKey),
 			RepostedPostHash: *postHash,
@@ -634,6 +541,7 @@ func (bav *UtxoView) GetRepostsForPostHash(postHash *BlockHash) (_reposterPubKey
 
 	// Iterate over the view and create the final list to return.
 	reposterPubKeys := [][]byte{}
+	bav._pullParentMappings(mappingRepostKeyToRepostEntry)
 	for _, repostEntry := range bav.RepostKeyToRepostEntry {
 		if !repostEntry.isDeleted && reflect.DeepEqual(repostEntry.RepostedPostHash[:], postHash[:]) {
 			reposterPubKeys = append(reposterPubKeys, repostEntry.ReposterPubKey)
@@ -645,25 +553,13 @@ func (bav *UtxoView) GetRepostsForPostHash(postHash *BlockHash) (_reposterPubKey
 
 func (bav *UtxoView) GetQuoteRepostsForPostHash(postHash *BlockHash,
 ) (_quoteReposterPubKeys [][]byte, _quoteReposterPubKeyToPosts map[PkMapKey][]*PostEntry, _err error) {
-	handle := bav.Handle
-	// FIXME: Db operation like this shouldn't happen in utxoview.
-	dbPrefix := append([]byte{}, Prefixes.PrefixRepostedPostHashReposterPubKeyRepostPostHash...)
-	dbPrefix = append(dbPrefix, postHash[:]...)
-	keysFound, _ := EnumerateKeysForPrefix(handle, dbPrefix)
-
-	// Iterate over all the db keys & values and load them into the view.
-	expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed + HashSizeBytes
-
-	repostPostHashIdx := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed
-	for _, key := range keysFound {
-		// Sanity check that this is a reasonable key.
-		if len(key) != expectedKeyLength {
-			return nil, nil, fmt.Errorf("UtxoView.GetQuoteRepostsForPostHash: Invalid key length found: %d", len(key))
-		}
-
-		repostPostHash := &BlockHash{}
-		copy(repostPostHash[:], key[repostPostHashIdx:])
+	dbRepostPostHashes, err := bav.GetDbAdapter().GetQuoteRepostPostHashesForPostHash(postHash)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "UtxoView.GetQuoteRepostsForPostHash: ")
+	}
 
+	// Iterate over all the quote reposts in the db and load them into the view.
+	for _, repostPostHash := range dbRepostPostHashes {
 		bav.GetPostEntryForPostHash(repostPostHash)
 	}
 
@@ -671,6 +567,7 @@ func (bav *UtxoView) GetQuoteRepostsForPostHash(postHash *BlockHash,
 	quoteReposterPubKeys := [][]byte{}
 	quoteReposterPubKeyToPosts := make(map[PkMapKey][]*PostEntry)
 
+	bav._pullParentMappings(mappingPostHashToPostEntry)
 	for _, postEntry := range bav.PostHashToPostEntry {
 		if !postEntry.isDeleted && postEntry.IsQuotedRepost && reflect.DeepEqual(postEntry.RepostedPostHash[:], postHash[:]) {
 			quoteReposterPubKeys = append(quoteReposterPubKeys, postEntry.PosterPublicKey)
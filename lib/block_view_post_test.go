@@ -4,7 +4,6 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,7 +13,7 @@ import (
 	"time"
 )
 
-func _submitPost(t *testing.T, chain *Blockchain, db *badger.DB,
+func _submitPost(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, updaterPkBase58Check string,
 	updaterPrivBase58Check string, postHashToModify []byte,
 	parentStakeID []byte,
@@ -124,7 +123,7 @@ func _submitPostWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _giveDeSoDiamonds(t *testing.T, chain *Blockchain, db *badger.DB, params *DeSoParams,
+func _giveDeSoDiamonds(t *testing.T, chain *Blockchain, db KVStore, params *DeSoParams,
 	feeRateNanosPerKB uint64, senderPkBase58Check string, senderPrivBase58Check string,
 	diamondPostHash *BlockHash, diamondLevel int64, deleteDiamondLevel bool,
 ) (_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -206,7 +205,7 @@ func _giveDeSoDiamondsWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _doSubmitPostTxn(t *testing.T, chain *Blockchain, db *badger.DB,
+func _doSubmitPostTxn(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64,
 	UpdaterPublicKeyBase58Check string, UpdaterPrivateKeyBase58Check string,
 	postHashToModify []byte,
@@ -0,0 +1,299 @@
+package lib
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// preloadKVStore wraps the KVStore of a UtxoView that has been preloaded for a block. Reads
+// for the preloaded keys are answered from memory, including reads for keys that we know
+// aren't in the DB, and everything else goes to the wrapped store.
+//
+// The view keeps calling the usual getters, so preloading doesn't change which entries end
+// up in the view's mappings or what gets flushed. It only changes where the DB reads come
+// from. The preloaded values are dropped as soon as anything is written through the store,
+// and ProcessBlock drops them once ConnectBlock returns, whether or not the block connected,
+// so they can never be served stale.
+type preloadKVStore struct {
+	KVStore
+
+	mtx     sync.RWMutex
+	values  map[string][]byte
+	missing map[string]bool
+}
+
+func (store *preloadKVStore) View(fn func(txn KVTxn) error) error {
+	return store.KVStore.View(func(txn KVTxn) error {
+		return fn(&preloadKVTxn{KVTxn: txn, store: store})
+	})
+}
+
+func (store *preloadKVStore) Update(fn func(txn KVTxn) error) error {
+	store.invalidate()
+	return store.KVStore.Update(fn)
+}
+
+func (store *preloadKVStore) NewTransaction(update bool) KVTxn {
+	if update {
+		store.invalidate()
+		return store.KVStore.NewTransaction(update)
+	}
+	return &preloadKVTxn{KVTxn: store.KVStore.NewTransaction(update), store: store}
+}
+
+func (store *preloadKVStore) NewWriteBatch() KVWriteBatch {
+	store.invalidate()
+	return store.KVStore.NewWriteBatch()
+}
+
+func (store *preloadKVStore) add(requestedKeys [][]byte, values map[string][]byte) {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	for _, key := range requestedKeys {
+		if value, exists := values[string(key)]; exists {
+			store.values[string(key)] = value
+		} else {
+			store.missing[string(key)] = true
+		}
+	}
+}
+
+func (store *preloadKVStore) get(key []byte) (_value []byte, _isPreloaded bool, _exists bool) {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	if value, exists := store.values[string(key)]; exists {
+		return value, true, true
+	}
+	if store.missing[string(key)] {
+		return nil, true, false
+	}
+	return nil, false, false
+}
+
+func (store *preloadKVStore) invalidate() {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	store.values = make(map[string][]byte)
+	store.missing = make(map[string]bool)
+}
+
+type preloadKVTxn struct {
+	KVTxn
+	store *preloadKVStore
+}
+
+func (txn *preloadKVTxn) Get(key []byte) (KVItem, error) {
+	value, isPreloaded, exists := txn.store.get(key)
+	if !isPreloaded {
+		return txn.KVTxn.Get(key)
+	}
+	if !exists {
+		return nil, ErrKVKeyNotFound
+	}
+	return &memoryKVItem{key: key, value: value}, nil
+}
+
+// _getPreloadKVStore wraps the view's handle in a preloadKVStore if it isn't wrapped already.
+func (bav *UtxoView) _getPreloadKVStore() *preloadKVStore {
+	if preloadStore, ok := bav.Handle.(*preloadKVStore); ok {
+		return preloadStore
+	}
+	preloadStore := &preloadKVStore{
+		KVStore: bav.Handle,
+		values:  make(map[string][]byte),
+		missing: make(map[string]bool),
+	}
+	bav.Handle = preloadStore
+	return preloadStore
+}
+
+// _resetPreloadKVStore drops all preloaded values and unwraps the view's handle.
+func (bav *UtxoView) _resetPreloadKVStore() {
+	if preloadStore, ok := bav.Handle.(*preloadKVStore); ok {
+		preloadStore.invalidate()
+		bav.Handle = preloadStore.KVStore
+	}
+}
+
+// _preloadFromDb fetches the DB records that the block's transactions are going to read and
+// keeps them in memory for the duration of the block connection. This is the Badger
+// counterpart of the Postgres preload in Preload.
+//
+// It takes two rounds of sorted multi-gets. The first round fetches the records keyed by
+// public key, post hash, NFT key, or order ID, together with the public key -> PKID
+// mappings. Profiles and creator coin / DAO coin balances are keyed by PKID, so they're
+// fetched in the second round once the PKIDs are known.
+func (bav *UtxoView) _preloadFromDb(desoBlock *MsgDeSoBlock) error {
+	var keys [][]byte
+	var publicKeys [][]byte
+	addPublicKey := func(publicKey []byte) {
+		if len(publicKey) == 0 {
+			return
+		}
+		publicKeys = append(publicKeys, publicKey)
+		keys = append(keys, append(append([]byte{}, Prefixes.PrefixPublicKeyToPKID...), publicKey...))
+		keys = append(keys, _dbKeyForPublicKeyToDeSoBalanceNanos(publicKey))
+	}
+	addPostHash := func(postHash *BlockHash) {
+		if postHash == nil {
+			return
+		}
+		keys = append(keys, _dbKeyForPostEntryHash(postHash))
+	}
+	addNFT := func(nftPostHash *BlockHash, serialNumber uint64) {
+		if nftPostHash == nil {
+			return
+		}
+		addPostHash(nftPostHash)
+		keys = append(keys, _dbKeyForNFTPostHashSerialNumber(nftPostHash, serialNumber))
+	}
+
+	// The balance entries are collected as (holder, creator) public key pairs and are only
+	// turned into keys once we have the PKIDs.
+	type balancePair struct {
+		holderPublicKey  []byte
+		creatorPublicKey []byte
+		isDAOCoin        bool
+	}
+	var balancePairs []balancePair
+	addBalance := func(holderPublicKey []byte, creatorPublicKey []byte, isDAOCoin bool) {
+		if len(holderPublicKey) == 0 || len(creatorPublicKey) == 0 {
+			return
+		}
+		addPublicKey(holderPublicKey)
+		addPublicKey(creatorPublicKey)
+		balancePairs = append(balancePairs, balancePair{holderPublicKey, creatorPublicKey, isDAOCoin})
+		// The creator's own balance is always looked at as well when coins are minted or sold.
+		balancePairs = append(balancePairs, balancePair{creatorPublicKey, creatorPublicKey, isDAOCoin})
+	}
+
+	for _, txn := range desoBlock.Txns {
+		if txn.TxnMeta == nil {
+			continue
+		}
+		addPublicKey(txn.PublicKey)
+		for _, txInput := range txn.TxInputs {
+			keys = append(keys, _DbKeyForUtxoKey((*UtxoKey)(txInput)))
+		}
+		for _, txOutput := range txn.TxOutputs {
+			addPublicKey(txOutput.PublicKey)
+		}
+
+		switch txnMeta := txn.TxnMeta.(type) {
+		case *FollowMetadata:
+			addPublicKey(txnMeta.FollowedPublicKey)
+		case *LikeMetadata:
+			addPostHash(txnMeta.LikedPostHash)
+		case *SubmitPostMetadata:
+			if len(txnMeta.PostHashToModify) == HashSizeBytes {
+				addPostHash(NewBlockHash(txnMeta.PostHashToModify))
+			}
+			if len(txnMeta.ParentStakeID) == HashSizeBytes {
+				addPostHash(NewBlockHash(txnMeta.ParentStakeID))
+			}
+		case *CreatorCoinMetadataa:
+			addBalance(txn.PublicKey, txnMeta.ProfilePublicKey, false)
+		case *CreatorCoinTransferMetadataa:
+			addBalance(txn.PublicKey, txnMeta.ProfilePublicKey, false)
+			addBalance(txnMeta.ReceiverPublicKey, txnMeta.ProfilePublicKey, false)
+		case *DAOCoinMetadata:
+			addBalance(txn.PublicKey, txnMeta.ProfilePublicKey, true)
+		case *DAOCoinTransferMetadata:
+			addBalance(txn.PublicKey, txnMeta.ProfilePublicKey, true)
+			addBalance(txnMeta.ReceiverPublicKey, txnMeta.ProfilePublicKey, true)
+		case *DAOCoinLimitOrderMetadata:
+			if txnMeta.CancelOrderID != nil {
+				keys = append(keys, DBKeyForDAOCoinLimitOrderByOrderID(
+					&DAOCoinLimitOrderEntry{OrderID: txnMeta.CancelOrderID}))
+			}
+			if txnMeta.BuyingDAOCoinCreatorPublicKey != nil {
+				addBalance(txn.PublicKey, txnMeta.BuyingDAOCoinCreatorPublicKey.ToBytes(), true)
+			}
+			if txnMeta.SellingDAOCoinCreatorPublicKey != nil {
+				addBalance(txn.PublicKey, txnMeta.SellingDAOCoinCreatorPublicKey.ToBytes(), true)
+			}
+		case *CreateNFTMetadata:
+			addPostHash(txnMeta.NFTPostHash)
+		case *UpdateNFTMetadata:
+			addNFT(txnMeta.NFTPostHash, txnMeta.SerialNumber)
+		case *NFTBidMetadata:
+			addNFT(txnMeta.NFTPostHash, txnMeta.SerialNumber)
+		case *AcceptNFTBidMetadata:
+			addNFT(txnMeta.NFTPostHash, txnMeta.SerialNumber)
+		case *NFTTransferMetadata:
+			addNFT(txnMeta.NFTPostHash, txnMeta.SerialNumber)
+			addPublicKey(txnMeta.ReceiverPublicKey)
+		case *AcceptNFTTransferMetadata:
+			addNFT(txnMeta.NFTPostHash, txnMeta.SerialNumber)
+		case *BurnNFTMetadata:
+			addNFT(txnMeta.NFTPostHash, txnMeta.SerialNumber)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Do the first round of reads.
+	preloadStore := bav._getPreloadKVStore()
+	values, err := DBMultiGet(preloadStore.KVStore, bav.Snapshot, keys)
+	if err != nil {
+		return errors.Wrapf(err, "_preloadFromDb: Problem fetching records for block")
+	}
+	preloadStore.add(keys, values)
+
+	// Resolve the PKIDs the same way DBGetPKIDEntryForPublicKeyWithTxn does, taking into
+	// account any PKID mappings that are already in the view.
+	pkids := make(map[PkMapKey]*PKID)
+	for _, publicKey := range publicKeys {
+		pkMapKey := MakePkMapKey(publicKey)
+		if _, exists := pkids[pkMapKey]; exists {
+			continue
+		}
+		if pkidEntry, exists := bav.PublicKeyToPKIDEntry[pkMapKey]; exists {
+			pkids[pkMapKey] = pkidEntry.PKID
+			continue
+		}
+		pkidKey := append(append([]byte{}, Prefixes.PrefixPublicKeyToPKID...), publicKey...)
+		pkidBytes, exists := values[string(pkidKey)]
+		if !exists {
+			pkids[pkMapKey] = PublicKeyToPKID(publicKey)
+			continue
+		}
+		pkidEntry := &PKIDEntry{}
+		if exists, err := DecodeFromBytes(pkidEntry, bytes.NewReader(pkidBytes)); !exists || err != nil {
+			// Leave it to the regular getters to surface the problem.
+			continue
+		}
+		pkids[pkMapKey] = pkidEntry.PKID
+	}
+
+	// Do the second round of reads for everything that's keyed by PKID.
+	var pkidKeys [][]byte
+	for _, pkid := range pkids {
+		pkidKeys = append(pkidKeys, _dbKeyForPKIDToProfileEntry(pkid))
+	}
+	for _, pair := range balancePairs {
+		holderPKID, holderExists := pkids[MakePkMapKey(pair.holderPublicKey)]
+		creatorPKID, creatorExists := pkids[MakePkMapKey(pair.creatorPublicKey)]
+		if !holderExists || !creatorExists {
+			continue
+		}
+		pkidKeys = append(pkidKeys,
+			_dbKeyForHODLerPKIDCreatorPKIDToBalanceEntry(holderPKID, creatorPKID, pair.isDAOCoin))
+	}
+	if len(pkidKeys) == 0 {
+		return nil
+	}
+	values, err = DBMultiGet(preloadStore.KVStore, bav.Snapshot, pkidKeys)
+	if err != nil {
+		return errors.Wrapf(err, "_preloadFromDb: Problem fetching PKID records for block")
+	}
+	preloadStore.add(pkidKeys, values)
+
+	return nil
+}
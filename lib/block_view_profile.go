@@ -6,7 +6,6 @@ import (
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/glog"
-	"github.com/holiman/uint256"
 	"github.com/pkg/errors"
 	"reflect"
 	"sort"
@@ -26,7 +25,7 @@ func (bav *UtxoView) GetAllProfiles(readerPK []byte) (
 	//
 	// TODO(performance): This currently fetches all profiles. We should implement
 	// some kind of pagination instead though.
-	_, _, dbProfileEntries, err := DBGetAllProfilesByCoinValue(bav.Handle, bav.Snapshot, true)
+	dbProfileEntries, err := bav.GetDbAdapter().GetAllProfileEntries()
 	if err != nil {
 		return nil, nil, nil, nil, errors.Wrapf(
 			err, "GetAllProfiles: Problem fetching ProfileEntrys from db: ")
@@ -44,14 +43,14 @@ func (bav *UtxoView) GetAllProfiles(readerPK []byte) (
 	// Do one more pass to load all the comments associated with each
 	// profile into the view.
 	commentsByProfilePublicKey := make(map[PkMapKey][]*PostEntry)
+	bav._pullParentMappings(mappingProfilePKIDToProfileEntry)
 	for _, profileEntry := range bav.ProfilePKIDToProfileEntry {
 		// Ignore deleted or rolled-back posts.
 		if profileEntry.isDeleted {
 			continue
 		}
 		commentsByProfilePublicKey[MakePkMapKey(profileEntry.PublicKey)] = []*PostEntry{}
-		_, dbCommentHashes, _, err := DBGetCommentPostHashesForParentStakeID(
-			bav.Handle, bav.Snapshot, profileEntry.PublicKey, false)
+		dbCommentHashes, err := bav.GetDbAdapter().GetCommentPostHashesForParentStakeID(profileEntry.PublicKey)
 		if err != nil {
 			return nil, nil, nil, nil, errors.Wrapf(err, "GetAllPosts: Problem fetching comment PostEntry's from db: ")
 		}
@@ -63,7 +62,7 @@ func (bav *UtxoView) GetAllProfiles(readerPK []byte) (
 	// has made, just go ahead and load *all* the posts into the view so that
 	// they'll get returned in the mapping. Later, we should use the db index
 	// to do this.
-	_, _, dbPostEntries, err := DBGetAllPostsByTstamp(bav.Handle, bav.Snapshot, true)
+	dbPostEntries, err := bav.GetDbAdapter().GetAllCorePostEntries()
 	if err != nil {
 		return nil, nil, nil, nil, errors.Wrapf(
 			err, "GetAllPosts: Problem fetching PostEntry's from db: ")
@@ -76,6 +75,7 @@ func (bav *UtxoView) GetAllProfiles(readerPK []byte) (
 	// to the relevant profiles.  Also adds reader state if a reader pubkey is provided.
 	corePostsByPublicKey := make(map[PkMapKey][]*PostEntry)
 	postEntryReaderStates := make(map[BlockHash]*PostEntryReaderState)
+	bav._pullParentMappings(mappingPostHashToPostEntry)
 	for _, postEntry := range bav.PostHashToPostEntry {
 		// Ignore deleted or rolled-back posts.
 		if postEntry.isDeleted {
@@ -139,27 +139,19 @@ func (bav *UtxoView) GetProfileEntryForUsername(nonLowercaseUsername []byte) *Pr
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingProfileUsernameToProfileEntry, mapKey); exists {
+		return parentEntry.(*ProfileEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
 	// Note that the DB username lookup is case-insensitive.
-	if bav.Postgres != nil {
-		profile := bav.Postgres.GetProfileForUsername(string(nonLowercaseUsername))
-		if profile == nil {
-			bav.ProfileUsernameToProfileEntry[mapKey] = nil
-			return nil
-		}
-
-		profileEntry, _ := bav.setProfileMappings(profile)
-		return profileEntry
-	} else {
-		dbProfileEntry := DBGetProfileEntryForUsername(bav.Handle, bav.Snapshot, nonLowercaseUsername)
-		if dbProfileEntry != nil {
-			bav._setProfileEntryMappings(dbProfileEntry)
-		}
-		return dbProfileEntry
+	dbProfileEntry := bav.GetDbAdapter().GetProfileEntryForUsername(nonLowercaseUsername)
+	if dbProfileEntry != nil {
+		bav._setProfileEntryMappings(dbProfileEntry)
 	}
+	return dbProfileEntry
 }
 
 func (bav *UtxoView) GetPKIDForPublicKey(publicKeyArg []byte) *PKIDEntry {
@@ -171,6 +163,9 @@ func (bav *UtxoView) GetPKIDForPublicKey(publicKeyArg []byte) *PKIDEntry {
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingPublicKeyToPKIDEntry, MakePkMapKey(publicKey)); exists {
+		return parentEntry.(*PKIDEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
@@ -179,26 +174,11 @@ func (bav *UtxoView) GetPKIDForPublicKey(publicKeyArg []byte) *PKIDEntry {
 	// Note that we construct an entry from the DB return value in order to track
 	// isDeleted on the view. If not for isDeleted, we wouldn't need the PKIDEntry
 	// wrapper.
-	if bav.Postgres != nil {
-		profile := bav.Postgres.GetProfileForPublicKey(publicKey)
-		if profile == nil {
-			pkidEntry := &PKIDEntry{
-				PKID:      PublicKeyToPKID(publicKey),
-				PublicKey: publicKey,
-			}
-			bav._setPKIDMappings(pkidEntry)
-			return pkidEntry
-		}
-
-		_, pkidEntry := bav.setProfileMappings(profile)
-		return pkidEntry
-	} else {
-		dbPKIDEntry := DBGetPKIDEntryForPublicKey(bav.Handle, bav.Snapshot, publicKey)
-		if dbPKIDEntry != nil {
-			bav._setPKIDMappings(dbPKIDEntry)
-		}
-		return dbPKIDEntry
+	dbPKIDEntry := bav.GetDbAdapter().GetPKIDEntryForPublicKey(publicKey)
+	if dbPKIDEntry != nil {
+		bav._setPKIDMappings(dbPKIDEntry)
 	}
+	return dbPKIDEntry
 }
 
 func (bav *UtxoView) GetPublicKeyForPKID(pkidArg *PKID) []byte {
@@ -213,6 +193,9 @@ func (bav *UtxoView) GetPublicKeyForPKID(pkidArg *PKID) []byte {
 	if existsMapValue {
 		return mapValue.PublicKey
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingPKIDToPublicKey, *pkid); exists {
+		return parentEntry.(*PKIDEntry).PublicKey
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
@@ -221,29 +204,14 @@ func (bav *UtxoView) GetPublicKeyForPKID(pkidArg *PKID) []byte {
 	// Note that we construct an entry from the DB return value in order to track
 	// isDeleted on the view. If not for isDeleted, we wouldn't need the PKIDEntry
 	// wrapper.
-	if bav.Postgres != nil {
-		profile := bav.Postgres.GetProfile(*pkid)
-		if profile == nil {
-			pkidEntry := &PKIDEntry{
-				PKID:      pkid,
-				PublicKey: PKIDToPublicKey(pkid),
-			}
-			bav._setPKIDMappings(pkidEntry)
-			return pkidEntry.PublicKey
-		}
-
-		_, pkidEntry := bav.setProfileMappings(profile)
-		return pkidEntry.PublicKey
-	} else {
-		dbPublicKey := DBGetPublicKeyForPKID(bav.Handle, bav.Snapshot, pkid)
-		if len(dbPublicKey) != 0 {
-			bav._setPKIDMappings(&PKIDEntry{
-				PKID:      pkid,
-				PublicKey: dbPublicKey,
-			})
-		}
-		return dbPublicKey
+	dbPublicKey := bav.GetDbAdapter().GetPublicKeyForPKID(pkid)
+	if len(dbPublicKey) != 0 {
+		bav._setPKIDMappings(&PKIDEntry{
+			PKID:      pkid,
+			PublicKey: dbPublicKey,
+		})
 	}
+	return dbPublicKey
 }
 
 func (bav *UtxoView) _setPKIDMappings(pkidEntry *PKIDEntry) {
@@ -285,26 +253,18 @@ func (bav *UtxoView) GetProfileEntryForPKID(pkid *PKID) *ProfileEntry {
 	if existsMapValue {
 		return mapValue
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingProfilePKIDToProfileEntry, *pkid); exists {
+		return parentEntry.(*ProfileEntry)
+	}
 
 	// If we get here it means no value exists in our in-memory map. In this case,
 	// defer to the db. If a mapping exists in the db, return it. If not, return
 	// nil.
-	if bav.Postgres != nil {
-		// Note: We should never get here but writing this code just in case
-		profile := bav.Postgres.GetProfile(*pkid)
-		if profile == nil {
-			return nil
-		}
-
-		profileEntry, _ := bav.setProfileMappings(profile)
-		return profileEntry
-	} else {
-		dbProfileEntry := DBGetProfileEntryForPKID(bav.Handle, bav.Snapshot, pkid)
-		if dbProfileEntry != nil {
-			bav._setProfileEntryMappings(dbProfileEntry)
-		}
-		return dbProfileEntry
+	dbProfileEntry := bav.GetDbAdapter().GetProfileEntryForPKID(pkid)
+	if dbProfileEntry != nil {
+		bav._setProfileEntryMappings(dbProfileEntry)
 	}
+	return dbProfileEntry
 }
 
 func (bav *UtxoView) _setProfileEntryMappings(profileEntry *ProfileEntry) {
@@ -343,17 +303,12 @@ func (bav *UtxoView) _getDerivedKeyMappingForOwner(ownerPublicKey []byte, derive
 	if exists {
 		return entry
 	}
+	if parentEntry, exists := bav._pullParentMapping(mappingDerivedKeyToDerivedEntry, derivedKeyMapKey); exists {
+		return parentEntry.(*DerivedKeyEntry)
+	}
 
 	// Check if the entry exists in the DB.
-	if bav.Postgres != nil {
-		if entryPG := bav.Postgres.GetDerivedKey(ownerPk, derivedPk); entryPG != nil {
-			entry = entryPG.NewDerivedKeyEntry()
-		} else {
-			entry = nil
-		}
-	} else {
-		entry = DBGetOwnerToDerivedKeyMapping(bav.Handle, bav.Snapshot, *ownerPk, *derivedPk)
-	}
+	entry = bav.GetDbAdapter().GetDerivedKeyEntry(*ownerPk, *derivedPk)
 
 	// If an entry exists, update the UtxoView map.
 	if entry != nil {
@@ -368,6 +323,8 @@ func (bav *UtxoView) GetAllDerivedKeyMappingsForOwner(ownerPublicKey []byte) (
 	map[PublicKey]*DerivedKeyEntry, error) {
 	derivedKeyMappings := make(map[PublicKey]*DerivedKeyEntry)
 
+	bav._pullParentMappings(mappingDerivedKeyToDerivedEntry)
+
 	// Check for entries in UtxoView.
 	for entryKey, entry := range bav.DerivedKeyToDerivedEntry {
 		if reflect.DeepEqual(entryKey.OwnerPublicKey[:], ownerPublicKey) {
@@ -376,20 +333,11 @@ func (bav *UtxoView) GetAllDerivedKeyMappingsForOwner(ownerPublicKey []byte) (
 	}
 
 	// Check for entries in DB.
-	var dbMappings []*DerivedKeyEntry
 	ownerPk := NewPublicKey(ownerPublicKey)
-	if bav.Postgres != nil {
-		pgMappings := bav.Postgres.GetAllDerivedKeysForOwner(ownerPk)
-		for _, entry := range pgMappings {
-			dbMappings = append(dbMappings, entry.NewDerivedKeyEntry())
-		}
-	} else {
-		var err error
-		dbMappings, err = DBGetAllOwnerToDerivedKeyMappings(bav.Handle, *ownerPk)
-		if err != nil {
-			return nil, errors.Wrapf(err, "GetAllDerivedKeyMappingsForOwner: problem looking up"+
-				"entries in the DB.")
-		}
+	dbMappings, err := bav.GetDbAdapter().GetAllDerivedKeyEntriesForOwner(*ownerPk)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetAllDerivedKeyMappingsForOwner: problem looking up"+
+			"entries in the DB.")
 	}
 
 	// Add entries from the DB that aren't already present.
@@ -445,48 +393,14 @@ func (bav *UtxoView) setProfileMappings(profile *PGProfile) (*ProfileEntry, *PKI
 	}
 	bav._setPKIDMappings(pkidEntry)
 
-	var profileEntry *ProfileEntry
-
-	// Postgres stores profiles with empty usernames when a swap identity occurs.
-	// Storing a nil value for the profile entry preserves badger behavior
+	// Postgres stores profiles with empty usernames when a swap identity occurs. These
+	// only record the PKID, just like badger's PKID mappings.
 	if profile.Empty() {
-		bav.ProfilePKIDToProfileEntry[*pkidEntry.PKID] = nil
-	} else {
-		var daoCoinsInCirculationNanos *uint256.Int
-		if profile.DAOCoinCoinsInCirculationNanos != "" {
-			var err error
-			daoCoinsInCirculationNanos, err = uint256.FromHex(profile.DAOCoinCoinsInCirculationNanos)
-			if err != nil {
-				daoCoinsInCirculationNanos = uint256.NewInt()
-			}
-		} else {
-			daoCoinsInCirculationNanos = uint256.NewInt()
-		}
-		profileEntry = &ProfileEntry{
-			PublicKey:   profile.PublicKey.ToBytes(),
-			Username:    []byte(profile.Username),
-			Description: []byte(profile.Description),
-			ProfilePic:  profile.ProfilePic,
-			CreatorCoinEntry: CoinEntry{
-				CreatorBasisPoints:      profile.CreatorBasisPoints,
-				DeSoLockedNanos:         profile.DeSoLockedNanos,
-				NumberOfHolders:         profile.NumberOfHolders,
-				CoinsInCirculationNanos: *uint256.NewInt().SetUint64(profile.CoinsInCirculationNanos),
-				CoinWatermarkNanos:      profile.CoinWatermarkNanos,
-				MintingDisabled:         profile.MintingDisabled,
-			},
-			DAOCoinEntry: CoinEntry{
-				NumberOfHolders:           profile.DAOCoinNumberOfHolders,
-				CoinsInCirculationNanos:   *daoCoinsInCirculationNanos,
-				MintingDisabled:           profile.DAOCoinMintingDisabled,
-				TransferRestrictionStatus: profile.DAOCoinTransferRestrictionStatus,
-			},
-			ExtraData: profile.ExtraData,
-		}
-
-		bav._setProfileEntryMappings(profileEntry)
+		return nil, pkidEntry
 	}
 
+	profileEntry := profile.NewProfileEntry()
+	bav._setProfileEntryMappings(profileEntry)
 	return profileEntry, pkidEntry
 }
 
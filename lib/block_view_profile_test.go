@@ -2,7 +2,6 @@ package lib
 
 import (
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"log"
@@ -30,7 +29,7 @@ func _swapIdentityWithTestMeta(
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _swapIdentity(t *testing.T, chain *Blockchain, db *badger.DB,
+func _swapIdentity(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, updaterPkBase58Check string,
 	updaterPrivBase58Check string, fromPublicKey []byte, toPublicKey []byte) (
 	_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32, _err error) {
@@ -89,7 +88,7 @@ func _swapIdentity(t *testing.T, chain *Blockchain, db *badger.DB,
 	return utxoOps, txn, blockHeight, nil
 }
 
-func _updateProfile(t *testing.T, chain *Blockchain, db *badger.DB,
+func _updateProfile(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, updaterPkBase58Check string,
 	updaterPrivBase58Check string, profilePubKey []byte, newUsername string,
 	newDescription string, newProfilePic string, newCreatorBasisPoints uint64,
@@ -100,7 +99,7 @@ func _updateProfile(t *testing.T, chain *Blockchain, db *badger.DB,
 		newStakeMultipleBasisPoints, isHidden, nil)
 }
 
-func _updateProfileWithExtraData(t *testing.T, chain *Blockchain, db *badger.DB,
+func _updateProfileWithExtraData(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, updaterPkBase58Check string,
 	updaterPrivBase58Check string, profilePubKey []byte, newUsername string,
 	newDescription string, newProfilePic string, newCreatorBasisPoints uint64,
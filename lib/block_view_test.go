@@ -2,10 +2,10 @@ package lib
 
 import (
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	_ "net/http/pprof"
+	"reflect"
 	"testing"
 )
 
@@ -58,7 +58,7 @@ var (
 	paramUpdaterPkBytes, _, _ = Base58CheckDecode(paramUpdaterPub)
 )
 
-func _doBasicTransferWithViewFlush(t *testing.T, chain *Blockchain, db *badger.DB,
+func _doBasicTransferWithViewFlush(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, pkSenderStr string, pkReceiverStr string, privStr string,
 	amountNanos uint64, feeRateNanosPerKB uint64) (
 	_utxoOps []*UtxoOperation, _txn *MsgDeSoTxn, _height uint32) {
@@ -110,7 +110,7 @@ func _registerOrTransferWithTestMeta(testMeta *TestMeta, username string,
 	testMeta.txns = append(testMeta.txns, currentTxn)
 }
 
-func _updateGlobalParamsEntry(t *testing.T, chain *Blockchain, db *badger.DB,
+func _updateGlobalParamsEntry(t *testing.T, chain *Blockchain, db KVStore,
 	params *DeSoParams, feeRateNanosPerKB uint64, updaterPkBase58Check string,
 	updaterPrivBase58Check string, usdCentsPerBitcoin int64, minimumNetworkFeesNanosPerKB int64,
 	createProfileFeeNanos int64, createNFTFeeNanos int64, maxCopiesPerNFT int64, flushToDb bool) (
@@ -211,7 +211,7 @@ func _updateGlobalParamsEntryWithTestMeta(
 type TestMeta struct {
 	t                      *testing.T
 	chain                  *Blockchain
-	db                     *badger.DB
+	db                     KVStore
 	params                 *DeSoParams
 	mempool                *DeSoMempool
 	miner                  *DeSoMiner
@@ -671,3 +671,219 @@ func TestBasicTransfer(t *testing.T) {
 		require.NoError(err)
 	}
 }
+
+func TestChildUtxoView(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m0PkBytes, 100))
+
+	parentStakeID := m2PkBytes
+	newComment := func(tstampNanos uint64) *PostEntry {
+		return &PostEntry{
+			PostHash:        NewBlockHash(RandomBytes(HashSizeBytes)),
+			PosterPublicKey: m0PkBytes,
+			ParentStakeID:   parentStakeID,
+			TimestampNanos:  tstampNanos,
+		}
+	}
+
+	parentView, err := NewUtxoView(db, params, nil, chain.snapshot)
+	require.NoError(err)
+	parentView.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m1PkBytes)] = 70
+	parentComment := newComment(1)
+	parentView._setPostEntryMappings(parentComment)
+
+	// Reads fall through the parent to the db.
+	childView := parentView.NewChildUtxoView()
+	require.Empty(childView.PublicKeyToDeSoBalanceNanos)
+	balance, err := childView.GetDeSoBalanceNanosForPublicKey(m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(100), balance)
+	balance, err = childView.GetDeSoBalanceNanosForPublicKey(m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(70), balance)
+
+	// Changes to the child, including changes to entries it got from the parent, don't affect the parent.
+	childView.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m1PkBytes)] = 20
+	childComment := childView.GetPostEntryForPostHash(parentComment.PostHash)
+	require.Equal(parentComment, childComment)
+	childComment.Body = []byte("edited")
+	childView._setPostEntryMappings(newComment(2))
+	balance, err = parentView.GetDeSoBalanceNanosForPublicKey(m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(70), balance)
+	require.Empty(parentComment.Body)
+
+	// Functions that iterate over a mapping see the entries of all the layers.
+	comments, err := childView.GetCommentEntriesForParentStakeID(parentStakeID)
+	require.NoError(err)
+	require.Len(comments, 2)
+	comments, err = parentView.GetCommentEntriesForParentStakeID(parentStakeID)
+	require.NoError(err)
+	require.Len(comments, 1)
+
+	// Grandchildren read through all the layers.
+	grandchildView := childView.NewChildUtxoView()
+	grandchildView._setPostEntryMappings(newComment(3))
+	balance, err = grandchildView.GetDeSoBalanceNanosForPublicKey(m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(20), balance)
+	comments, err = grandchildView.GetCommentEntriesForParentStakeID(parentStakeID)
+	require.NoError(err)
+	require.Len(comments, 3)
+
+	// Child views can't be flushed, but they can be committed into their parent.
+	require.Error(childView.FlushToDb(0))
+	require.NoError(childView.CommitToParent())
+	balance, err = parentView.GetDeSoBalanceNanosForPublicKey(m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(20), balance)
+	require.Equal([]byte("edited"), parentView.GetPostEntryForPostHash(parentComment.PostHash).Body)
+	comments, err = parentView.GetCommentEntriesForParentStakeID(parentStakeID)
+	require.NoError(err)
+	require.Len(comments, 2)
+}
+
+func TestPreloadFromDb(t *testing.T) {
+	require := require.New(t)
+
+	_, params, db := NewTestBlockchain()
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m0PkBytes, 100))
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m2PkBytes, 300))
+
+	utxoView, err := NewUtxoView(db, params, nil, nil)
+	require.NoError(err)
+
+	// Preload a block with a transfer from m0 to m1.
+	block := &MsgDeSoBlock{
+		Header: &MsgDeSoHeader{},
+		Txns: []*MsgDeSoTxn{{
+			TxInputs: []*DeSoInput{},
+			TxOutputs: []*DeSoOutput{{
+				PublicKey:   m1PkBytes,
+				AmountNanos: 10,
+			}},
+			PublicKey: m0PkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}},
+	}
+	require.NoError(utxoView.Preload(block, 1))
+	_, isPreloaded := utxoView.Handle.(*preloadKVStore)
+	require.True(isPreloaded)
+
+	// Change the db behind the view's back. The preloaded keys, including m1's missing
+	// balance, are served from memory while everything else still goes to the db.
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m0PkBytes, 1000))
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m1PkBytes, 2000))
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m2PkBytes, 3000))
+	balance, err := utxoView.GetDeSoBalanceNanosForPublicKey(m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(100), balance)
+	balance, err = utxoView.GetDeSoBalanceNanosForPublicKey(m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(0), balance)
+	balance, err = utxoView.GetDeSoBalanceNanosForPublicKey(m2PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(3000), balance)
+
+	// Flushing drops the preloaded records and unwraps the handle.
+	require.NoError(utxoView.FlushToDb(1))
+	_, isPreloaded = utxoView.Handle.(*preloadKVStore)
+	require.False(isPreloaded)
+	balance, err = utxoView.GetDeSoBalanceNanosForPublicKey(m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(100), balance)
+	balance, err = utxoView.GetDeSoBalanceNanosForPublicKey(m3PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(0), balance)
+}
+
+func TestPreloadResetAfterFailedBlock(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	_, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+
+	// A block with too much block reward fails to connect, after it's been preloaded.
+	blockToMine, _, _, err := miner._getBlockToMine(0 /*threadIndex*/)
+	require.NoError(err)
+	blockToMine.Txns[0].TxOutputs[0].AmountNanos = CalcBlockRewardNanos(chain.blockTip().Height+1) + 1
+	merkleRoot, _, err := ComputeMerkleRoot(blockToMine.Txns)
+	require.NoError(err)
+	blockToMine.Header.TransactionMerkleRoot = merkleRoot
+	_, bestNonce, err := FindLowestHash(blockToMine.Header, 10000, 0)
+	require.NoError(err)
+	blockToMine.Header.Nonce = bestNonce
+	require.NoError(miner.BlockProducer.SignBlock(blockToMine))
+	_, _, err = chain.ProcessBlock(blockToMine, true /*verifySignatures*/)
+	require.Error(err)
+	require.Contains(err.Error(), RuleErrorBlockRewardExceedsMaxAllowed)
+
+	// The view is kept for the next block, but it doesn't keep serving the preloaded records.
+	require.NotNil(chain.blockView)
+	_, isPreloaded := chain.blockView.Handle.(*preloadKVStore)
+	require.False(isPreloaded)
+}
+
+func TestChildUtxoViewMappings(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	parentView, err := NewUtxoView(db, params, nil, chain.snapshot)
+	require.NoError(err)
+
+	viewValue := reflect.ValueOf(parentView).Elem()
+	viewType := viewValue.Type()
+	for ii := 0; ii < viewType.NumField(); ii++ {
+		field := viewType.Field(ii)
+		if field.Type.Kind() != reflect.Map || field.PkgPath != "" {
+			continue
+		}
+
+		// Every mapping field must have a utxoViewMapping, otherwise child views won't see the parent's entries.
+		var fieldMapping *utxoViewMapping
+		for _, mapping := range _utxoViewMappings {
+			if reflect.ValueOf(mapping.get(parentView)).Pointer() == viewValue.Field(ii).Pointer() {
+				fieldMapping = mapping
+			}
+		}
+		require.NotNil(fieldMapping, "UtxoView.%s has no utxoViewMapping", field.Name)
+
+		// Set an entry that only the parent has.
+		key := reflect.New(field.Type.Key()).Elem()
+		entry := reflect.New(field.Type.Elem()).Elem()
+		if entry.Kind() == reflect.Ptr {
+			entry = reflect.New(field.Type.Elem().Elem())
+		}
+		viewValue.Field(ii).SetMapIndex(key, entry)
+
+		// A child looking up the key gets a copy of the parent's entry.
+		childView := parentView.NewChildUtxoView()
+		childEntry, exists := childView._pullParentMapping(fieldMapping, key.Interface())
+		require.True(exists, field.Name)
+		require.Equal(entry.Interface(), childEntry, field.Name)
+		if entry.Kind() == reflect.Ptr {
+			require.NotEqual(entry.Pointer(), reflect.ValueOf(childEntry).Pointer(), field.Name)
+		}
+		require.True(reflect.ValueOf(childView).Elem().Field(ii).MapIndex(key).IsValid(), field.Name)
+
+		// A grandchild iterating over the mapping sees the parent's entry too.
+		grandchildView := parentView.NewChildUtxoView().NewChildUtxoView()
+		grandchildView._pullParentMappings(fieldMapping)
+		grandchildEntry := reflect.ValueOf(grandchildView).Elem().Field(ii).MapIndex(key)
+		require.True(grandchildEntry.IsValid(), field.Name)
+		require.Equal(entry.Interface(), grandchildEntry.Interface(), field.Name)
+	}
+
+	// Appending to an accepted bid history pulled from the parent doesn't write into the spare capacity
+	// of the parent's history.
+	nftKey := MakeNFTKey(NewBlockHash(RandomBytes(HashSizeBytes)), 1)
+	parentHistory := make([]*NFTBidEntry, 1, 2)
+	parentHistory[0] = &NFTBidEntry{SerialNumber: 1}
+	parentView.NFTKeyToAcceptedNFTBidHistory[nftKey] = &parentHistory
+	childHistory := parentView.NewChildUtxoView().GetAcceptNFTBidHistoryForNFTKey(&nftKey)
+	require.Equal(parentHistory, *childHistory)
+	*childHistory = append(*childHistory, &NFTBidEntry{SerialNumber: 2})
+	require.Nil(parentHistory[:2][1])
+}
@@ -1328,7 +1328,11 @@ func (op *UtxoOperation) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.
 			if exists, err := DecodeFromBytes(&coinEntry, coinEntryReader); !exists || err != nil {
 				return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading PrevCoinRoyaltyCoinEntries")
 			}
-			op.PrevCoinRoyaltyCoinEntries[*NewPKID(entry.pkid)] = coinEntry
+			pkid := NewPKID(entry.pkid)
+			if pkid == nil {
+				return fmt.Errorf("UtxoOperation.Decode: Missing PKID in PrevCoinRoyaltyCoinEntries")
+			}
+			op.PrevCoinRoyaltyCoinEntries[*pkid] = coinEntry
 		}
 	} else if err != nil {
 		return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading PrevCoinRoyaltyCoinEntries")
@@ -1563,6 +1567,9 @@ func (op *UtxoOperation) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.
 				return errors.Wrapf(err, "UtxoOperation.Decode: Problem reading PrevBalanceEntries")
 			}
 
+			if len(entry.primaryPKID) == 0 || len(entry.secondaryPKID) == 0 {
+				return fmt.Errorf("UtxoOperation.Decode: Missing PKID in PrevBalanceEntries")
+			}
 			primaryPKID := *NewPKID(entry.primaryPKID)
 			secondaryPKID := *NewPKID(entry.secondaryPKID)
 			balanceEntry := &BalanceEntry{}
@@ -2528,7 +2535,10 @@ func (bundle *NFTBidEntryBundle) RawDecodeWithoutMetadata(blockHeight uint64, rr
 	if err != nil {
 		return errors.Wrapf(err, "NFTBidEntryBundle.RawDecodeWithoutMetadata: Problem decoding number of nft bids")
 	}
-	bundle.nftBidEntryBundle = make([]*NFTBidEntry, numEntries)
+	if err = ValidateDecodedLength(rr, numEntries); err != nil {
+		return errors.Wrapf(err, "NFTBidEntryBundle.RawDecodeWithoutMetadata: Invalid number of nft bids")
+	}
+	bundle.nftBidEntryBundle = make([]*NFTBidEntry, 0, numEntries)
 	for ii := uint64(0); ii < numEntries; ii++ {
 		bidEntry := &NFTBidEntry{}
 		if exists, err := DecodeFromBytes(bidEntry, rr); !exists || err != nil {
@@ -2601,12 +2611,20 @@ func (key *DerivedKeyEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *byt
 	if err != nil {
 		return errors.Wrapf(err, "DerivedKeyEntry.Decode: Problem reading OwnerPublicKey")
 	}
-	key.OwnerPublicKey = *NewPublicKey(ownerPublicKeyBytes)
+	ownerPublicKey := NewPublicKey(ownerPublicKeyBytes)
+	if ownerPublicKey == nil {
+		return fmt.Errorf("DerivedKeyEntry.Decode: Invalid OwnerPublicKey length %d", len(ownerPublicKeyBytes))
+	}
+	key.OwnerPublicKey = *ownerPublicKey
 	derivedPublicKeyBytes, err := DecodeByteArray(rr)
 	if err != nil {
 		return errors.Wrapf(err, "DerivedKeyEntry.Decode: Problem reading DerivedPublicKey")
 	}
-	key.DerivedPublicKey = *NewPublicKey(derivedPublicKeyBytes)
+	derivedPublicKey := NewPublicKey(derivedPublicKeyBytes)
+	if derivedPublicKey == nil {
+		return fmt.Errorf("DerivedKeyEntry.Decode: Invalid DerivedPublicKey length %d", len(derivedPublicKeyBytes))
+	}
+	key.DerivedPublicKey = *derivedPublicKey
 
 	key.ExpirationBlock, err = ReadUvarint(rr)
 	if err != nil {
@@ -3305,6 +3323,9 @@ func (be *BalanceEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.R
 	if err != nil {
 		return errors.Wrapf(err, "BalanceEntry.Decode: Problem reading BalanceNanos")
 	}
+	if balanceNanos == nil {
+		return fmt.Errorf("BalanceEntry.Decode: BalanceNanos is missing")
+	}
 	be.BalanceNanos = *balanceNanos
 	be.HasPurchased, err = ReadBoolByte(rr)
 	if err != nil {
@@ -3441,6 +3462,9 @@ func (ce *CoinEntry) RawDecodeWithoutMetadata(blockHeight uint64, rr *bytes.Read
 	if err != nil {
 		return errors.Wrapf(err, "CoinEntry.Decode: Problem reading NumberOfHolders")
 	}
+	if coinsInCirculationNanos == nil {
+		return fmt.Errorf("CoinEntry.Decode: CoinsInCirculationNanos is missing")
+	}
 	ce.CoinsInCirculationNanos = *coinsInCirculationNanos
 
 	ce.CoinWatermarkNanos, err = ReadUvarint(rr)
@@ -3692,9 +3716,7 @@ func DecodeByteArray(reader io.Reader) ([]byte, error) {
 	}
 
 	if pkLen > 0 {
-		result := make([]byte, pkLen)
-
-		_, err = io.ReadFull(reader, result)
+		result, err := SafeReadBytes(reader, pkLen)
 		if err != nil {
 			return nil, errors.Wrapf(err, "DecodeByteArray: Problem when ReadFull")
 		}
@@ -3747,6 +3769,9 @@ func DecodePKIDuint64Map(rr io.Reader) (map[PKID]uint64, error) {
 	}
 
 	if mapLength > 0 {
+		if err = ValidateDecodedLength(rr, mapLength); err != nil {
+			return nil, errors.Wrapf(err, "DecodePKIDuint64Map: Invalid map length")
+		}
 		pkidMap := make(map[PKID]uint64, mapLength)
 
 		for ii := uint64(0); ii < mapLength; ii++ {
@@ -3754,12 +3779,14 @@ func DecodePKIDuint64Map(rr io.Reader) (map[PKID]uint64, error) {
 			if err != nil {
 				return nil, errors.Wrapf(err, "DecodePKIDuint64Map: Problem reading pkid length at ii: (%v)", ii)
 			}
-			pkidBytes := make([]byte, pkidLen)
-			_, err = io.ReadFull(rr, pkidBytes)
+			pkidBytes, err := SafeReadBytes(rr, pkidLen)
 			if err != nil {
 				return nil, errors.Wrapf(err, "DecodePKIDuint64Map: Problem reading pkid bytes at ii: (%v)", ii)
 			}
 			pkid := NewPKID(pkidBytes)
+			if pkid == nil {
+				return nil, fmt.Errorf("DecodePKIDuint64Map: Missing pkid at ii: (%v)", ii)
+			}
 			value, err := ReadUvarint(rr)
 			if err != nil {
 				return nil, errors.Wrapf(err, "DecodePKIDuint64Map: Problem reading value at ii (%v)", ii)
@@ -3809,6 +3836,9 @@ func DecodeExtraData(rr io.Reader) (map[string][]byte, error) {
 	if extraDataLen > MaxMessagePayload {
 		return nil, fmt.Errorf("DecodeExtraData: extraDataLen length %d longer than max %d", extraDataLen, MaxMessagePayload)
 	}
+	if err = ValidateDecodedLength(rr, extraDataLen); err != nil {
+		return nil, errors.Wrapf(err, "DecodeExtraData: Invalid extraDataLen")
+	}
 
 	// Initialize an map of strings to byte slices of size extraDataLen -- extraDataLen is the number of keys.
 	if extraDataLen != 0 {
@@ -3824,8 +3854,8 @@ func DecodeExtraData(rr io.Reader) (map[string][]byte, error) {
 			}
 
 			// De-serialize the key
-			keyBytes := make([]byte, keyLen)
-			_, err = io.ReadFull(rr, keyBytes)
+			var keyBytes []byte
+			keyBytes, err = SafeReadBytes(rr, keyLen)
 			if err != nil {
 				return nil, fmt.Errorf("DecodeExtraData: Problem reading key #{ii}")
 			}
@@ -3845,8 +3875,8 @@ func DecodeExtraData(rr io.Reader) (map[string][]byte, error) {
 			}
 
 			// De-serialize the value
-			value := make([]byte, valueLen)
-			_, err = io.ReadFull(rr, value)
+			var value []byte
+			value, err = SafeReadBytes(rr, valueLen)
 			if err != nil {
 				return nil, fmt.Errorf("DecodeExtraData: Problem read value #{ii}")
 			}
@@ -3893,6 +3923,10 @@ func DecodeMapStringUint64(rr *bytes.Reader) (map[string]uint64, error) {
 		return nil, errors.Wrapf(err, "DecodeExtraData: Problem reading")
 	}
 
+	if err = ValidateDecodedLength(rr, extraDataLen); err != nil {
+		return nil, errors.Wrapf(err, "DecodeMapStringUint64: Invalid map length")
+	}
+
 	// Initialize an map of strings to byte slices of size extraDataLen -- extraDataLen is the number of keys.
 	if extraDataLen != 0 {
 		extraData := make(map[string]uint64, extraDataLen)
@@ -3907,8 +3941,8 @@ func DecodeMapStringUint64(rr *bytes.Reader) (map[string]uint64, error) {
 			}
 
 			// De-serialize the key
-			keyBytes := make([]byte, keyLen)
-			_, err = io.ReadFull(rr, keyBytes)
+			var keyBytes []byte
+			keyBytes, err = SafeReadBytes(rr, keyLen)
 			if err != nil {
 				return nil, fmt.Errorf("DecodeExtraData: Problem reading key #{ii}")
 			}
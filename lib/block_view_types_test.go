@@ -6,7 +6,10 @@ import (
 	"github.com/brianvoe/gofakeit"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"math"
+	"math/rand"
 	"reflect"
+	"runtime"
 	"testing"
 	"time"
 )
@@ -374,3 +377,180 @@ func TestUtxoEntryEncodeDecode(t *testing.T) {
 		require.NoError(utxoView.FlushToDb(0))
 	}
 }
+
+// _allDeSoEncoderTypes lists every EncoderType so that fuzz inputs can pick one by index.
+func _allDeSoEncoderTypes() []EncoderType {
+	var encoderTypes []EncoderType
+	for encoderType := EncoderTypeUtxoEntry; encoderType != EncoderTypeEndBlockView; encoderType++ {
+		encoderTypes = append(encoderTypes, encoderType)
+	}
+	for encoderType := EncoderTypeTransactionMetadata; encoderType != EncoderTypeEndTxIndex; encoderType++ {
+		encoderTypes = append(encoderTypes, encoderType)
+	}
+	return encoderTypes
+}
+
+// _maxDecodeAllocationBytes bounds how much memory decoding an input of the given size may allocate.
+// Decoded structs are bigger than their encodings, but the ratio must not depend on length prefixes
+// inside the input.
+func _maxDecodeAllocationBytes(inputLen int) uint64 {
+	return 1<<20 + 1024*uint64(inputLen)
+}
+
+// _allocatedBytes returns the number of heap bytes allocated while running fn.
+func _allocatedBytes(fn func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	fn()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+// _measureDecodeAllocatedBytes returns how many heap bytes decode allocates for an input of the given size.
+// TotalAlloc counts every goroutine in the process, so a measurement over the bound is repeated after a GC
+// and the smallest one is kept. Other goroutines can only add to a measurement, while memory the decoder
+// allocates shows up in every one of them.
+func _measureDecodeAllocatedBytes(inputLen int, decode func()) uint64 {
+	allocatedBytes := _allocatedBytes(decode)
+	for attempt := 0; attempt < 2 && allocatedBytes > _maxDecodeAllocationBytes(inputLen); attempt++ {
+		runtime.GC()
+		if retryAllocatedBytes := _allocatedBytes(decode); retryAllocatedBytes < allocatedBytes {
+			allocatedBytes = retryAllocatedBytes
+		}
+	}
+	return allocatedBytes
+}
+
+// _mutateFuzzInput deterministically corrupts seed the way a fuzzer would: by flipping bytes,
+// truncating, and splicing in huge varint lengths.
+func _mutateFuzzInput(rand *rand.Rand, seed []byte) []byte {
+	data := append([]byte{}, seed...)
+	for numMutations := 1 + rand.Intn(4); numMutations > 0; numMutations-- {
+		switch rand.Intn(4) {
+		case 0:
+			if len(data) > 0 {
+				data[rand.Intn(len(data))] = byte(rand.Intn(256))
+			}
+		case 1:
+			if len(data) > 0 {
+				data = data[:rand.Intn(len(data))]
+			}
+		case 2:
+			position := rand.Intn(len(data) + 1)
+			hugeLength := UintToBuf(math.MaxUint64 >> uint(rand.Intn(40)))
+			data = append(data[:position], append(hugeLength, data[position:]...)...)
+		case 3:
+			position := rand.Intn(len(data) + 1)
+			randomBytes := make([]byte, rand.Intn(16))
+			rand.Read(randomBytes)
+			data = append(data[:position], append(randomBytes, data[position:]...)...)
+		}
+	}
+	return data
+}
+
+// _checkDeSoEncoderDecoding decodes data as encoderType. Decoding must not panic or allocate more memory
+// than the input justifies, and if it succeeds then re-encoding must be stable at every migration height.
+func _checkDeSoEncoderDecoding(t *testing.T, encoderType EncoderType, data []byte) {
+	require := require.New(t)
+
+	var encoder DeSoEncoder
+	var exists bool
+	var err error
+	allocatedBytes := _measureDecodeAllocatedBytes(len(data), func() {
+		encoder = encoderType.New()
+		exists, err = DecodeFromBytes(encoder, bytes.NewReader(data))
+	})
+	require.LessOrEqual(allocatedBytes, _maxDecodeAllocationBytes(len(data)),
+		"Decoding %d bytes as %v allocated too much memory", len(data), encoderType)
+	if err != nil || !exists {
+		return
+	}
+
+	for _, migration := range GlobalDeSoParams.EncoderMigrationHeightsList {
+		encodedBytes := EncodeToBytes(migration.Height, encoder)
+		reDecodedEncoder := encoderType.New()
+		exists, err := DecodeFromBytes(reDecodedEncoder, bytes.NewReader(encodedBytes))
+		require.NoError(err, "Encoder type: %v, migration: %v", encoderType, migration.Name)
+		require.True(exists)
+		require.Equal(encodedBytes, EncodeToBytes(migration.Height, reDecodedEncoder),
+			"Encoder type: %v, migration: %v", encoderType, migration.Name)
+	}
+}
+
+// _getDeSoEncoderFuzzSeeds returns empty and randomly populated encodings of every DeSoEncoder at every
+// migration height, keyed by the index of the encoder's type in _allDeSoEncoderTypes.
+func _getDeSoEncoderFuzzSeeds() (_encoderTypeIndexes []uint32, _seeds [][]byte) {
+	var encoderTypeIndexes []uint32
+	var seeds [][]byte
+	gofakeit.Seed(1)
+	for ii, encoderType := range _allDeSoEncoderTypes() {
+		randomEncoder := encoderType.New()
+		gofakeit.Struct(randomEncoder)
+		for _, encoder := range []DeSoEncoder{encoderType.New(), randomEncoder} {
+			for _, migration := range GlobalDeSoParams.EncoderMigrationHeightsList {
+				encoderTypeIndexes = append(encoderTypeIndexes, uint32(ii))
+				seeds = append(seeds, EncodeToBytes(migration.Height, encoder))
+			}
+		}
+	}
+	return encoderTypeIndexes, seeds
+}
+
+// FuzzDeSoEncoderDecoding fuzzes DecodeFromBytes for every DeSoEncoder. The first input picks the encoder type.
+func FuzzDeSoEncoderDecoding(f *testing.F) {
+	encoderTypes := _allDeSoEncoderTypes()
+	encoderTypeIndexes, seeds := _getDeSoEncoderFuzzSeeds()
+	for ii := range seeds {
+		f.Add(encoderTypeIndexes[ii], seeds[ii])
+	}
+
+	f.Fuzz(func(t *testing.T, encoderTypeIndex uint32, data []byte) {
+		_checkDeSoEncoderDecoding(t, encoderTypes[encoderTypeIndex%uint32(len(encoderTypes))], data)
+	})
+}
+
+// TestDeSoEncoderDecodingMutations runs a fixed set of mutated encodings through the same checks as
+// FuzzDeSoEncoderDecoding so that regular test runs catch decoding regressions deterministically.
+func TestDeSoEncoderDecodingMutations(t *testing.T) {
+	encoderTypes := _allDeSoEncoderTypes()
+	encoderTypeIndexes, seeds := _getDeSoEncoderFuzzSeeds()
+	rand := rand.New(rand.NewSource(0))
+	for ii := range seeds {
+		encoderType := encoderTypes[encoderTypeIndexes[ii]]
+		_checkDeSoEncoderDecoding(t, encoderType, seeds[ii])
+		for jj := 0; jj < 50; jj++ {
+			_checkDeSoEncoderDecoding(t, encoderType, _mutateFuzzInput(rand, seeds[ii]))
+		}
+	}
+}
+
+// _decodeAllocationSink keeps the buffer allocated in TestDecodeAllocationCheck alive.
+var _decodeAllocationSink []byte
+
+// TestDecodeAllocationCheck makes sure the allocation check catches a decoder that trusts a huge length
+// prefix, and that the real decoders don't.
+func TestDecodeAllocationCheck(t *testing.T) {
+	require := require.New(t)
+
+	// A few bytes claiming a 64MB payload.
+	hugeLength := UintToBuf(64 << 20)
+
+	naiveDecode := func() {
+		length, _ := ReadUvarint(bytes.NewReader(hugeLength))
+		_decodeAllocationSink = make([]byte, length)
+	}
+	require.Greater(_measureDecodeAllocatedBytes(len(hugeLength), naiveDecode),
+		_maxDecodeAllocationBytes(len(hugeLength)))
+	_decodeAllocationSink = nil
+
+	// Every encoder gets the huge length right after its header.
+	for _, encoderType := range _allDeSoEncoderTypes() {
+		data := append([]byte{1}, UintToBuf(uint64(encoderType))...)
+		data = append(data, UintToBuf(0)...)
+		_checkDeSoEncoderDecoding(t, encoderType, append(data, hugeLength...))
+	}
+	for _, msgType := range _allDeSoMessageTypes() {
+		_checkDeSoMessageDecoding(t, msgType, hugeLength)
+	}
+}
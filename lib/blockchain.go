@@ -12,16 +12,17 @@ import (
 	"runtime/debug"
 	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	btcdchain "github.com/btcsuite/btcd/blockchain"
 	chainlib "github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/btcsuite/btcd/wire"
+	"github.com/cloudflare/circl/group"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/deso-protocol/go-deadlock"
 	merkletree "github.com/deso-protocol/go-merkle-tree"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
 )
@@ -367,6 +368,14 @@ func CalcNextDifficultyTarget(
 
 	firstNodeHeight := lastNode.Height - blocksPerRetarget
 	firstNode := lastNode.Ancestor(firstNodeHeight)
+	if firstNode == nil && _isRootedAtTrustedCheckpoint(lastNode) {
+		// A chain bootstrapped from a trusted checkpoint doesn't have the blocks
+		// before the checkpoint, so we can't compute the first retarget after it.
+		// We carry the checkpoint's difficulty forward until a full retarget window
+		// of real headers exists. Loosening it here would let anyone mine the headers
+		// right after the checkpoint at a fraction of the real difficulty.
+		return lastNode.DifficultyTarget, nil
+	}
 	if firstNode == nil {
 		return nil, fmt.Errorf("CalcNextDifficultyTarget: Problem getting block at "+
 			"beginning of retarget interval at height %d during retarget from height %d",
@@ -396,13 +405,96 @@ func CalcNextDifficultyTarget(
 	return BigintToHash(nextDiffBigint), nil
 }
 
+// _isRootedAtTrustedCheckpoint returns true if walking back from the passed node ends at
+// a trusted checkpoint rather than at the genesis block. The checkpoint node is the only
+// node other than genesis that doesn't have a parent.
+func _isRootedAtTrustedCheckpoint(node *BlockNode) bool {
+	for node != nil && node.Parent != nil {
+		node = node.Parent
+	}
+	return node != nil && node.Height > 0
+}
+
+// blockNodeAtHeight returns the node at the given height from a contiguous chain of block
+// nodes such as bestChain or bestHeaderChain. These chains normally start at the genesis
+// block, but a chain bootstrapped from a trusted checkpoint starts at the checkpoint height,
+// so we offset the index by the height of the first node in the chain.
+func blockNodeAtHeight(chain []*BlockNode, height uint32) *BlockNode {
+	if len(chain) == 0 || height < chain[0].Height {
+		return nil
+	}
+	index := height - chain[0].Height
+	if index >= uint32(len(chain)) {
+		return nil
+	}
+	return chain[index]
+}
+
+// TrustedCheckpoint allows a hypersync node to skip downloading and validating headers from
+// genesis. The operator supplies the hash, height, difficulty target, and timestamp of a snapshot
+// epoch block they trust, along with the state checksum at that block. The node then only syncs
+// headers after the checkpoint, hypersyncs state at the checkpoint height, and verifies the
+// resulting state checksum against the one supplied by the operator. The difficulty target and
+// timestamp are what headers built on top of the checkpoint are validated against.
+type TrustedCheckpoint struct {
+	BlockHash        *BlockHash
+	Height           uint32
+	DifficultyTarget *BlockHash
+	TstampSecs       uint64
+	StateChecksum    []byte
+}
+
+// NewTrustedCheckpoint parses the trusted checkpoint flags. It returns nil if no checkpoint
+// block hash was provided, which means the node should sync headers from genesis.
+func NewTrustedCheckpoint(blockHashHex string, height uint32, difficultyTargetHex string,
+	tstampSecs uint64, stateChecksumHex string) (*TrustedCheckpoint, error) {
+	if blockHashHex == "" {
+		return nil, nil
+	}
+
+	blockHashBytes, err := hex.DecodeString(blockHashHex)
+	if err != nil || len(blockHashBytes) != HashSizeBytes {
+		return nil, fmt.Errorf("NewTrustedCheckpoint: Block hash (%v) should be a %v byte hex string",
+			blockHashHex, HashSizeBytes)
+	}
+	if height == 0 {
+		return nil, fmt.Errorf("NewTrustedCheckpoint: Checkpoint height must be greater than zero")
+	}
+	difficultyTargetBytes, err := hex.DecodeString(difficultyTargetHex)
+	if err != nil || len(difficultyTargetBytes) != HashSizeBytes {
+		return nil, fmt.Errorf("NewTrustedCheckpoint: Difficulty target (%v) should be a %v byte hex string",
+			difficultyTargetHex, HashSizeBytes)
+	}
+	if tstampSecs == 0 {
+		return nil, fmt.Errorf("NewTrustedCheckpoint: Checkpoint timestamp must be greater than zero")
+	}
+
+	stateChecksum, err := hex.DecodeString(stateChecksumHex)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewTrustedCheckpoint: Problem decoding state checksum")
+	}
+	// Make sure the checksum is a valid encoding of a point on the checksum curve.
+	if err := group.Ristretto255.NewElement().UnmarshalBinary(stateChecksum); err != nil {
+		return nil, errors.Wrapf(err, "NewTrustedCheckpoint: State checksum (%v) is not a valid checksum",
+			stateChecksumHex)
+	}
+
+	return &TrustedCheckpoint{
+		BlockHash:        NewBlockHash(blockHashBytes),
+		Height:           height,
+		DifficultyTarget: NewBlockHash(difficultyTargetBytes),
+		TstampSecs:       tstampSecs,
+		StateChecksum:    stateChecksum,
+	}, nil
+}
+
 type OrphanBlock struct {
 	Block *MsgDeSoBlock
 	Hash  *BlockHash
 }
 
 type Blockchain struct {
-	db                              *badger.DB
+	db                              KVStore
 	postgres                        *Postgres
 	snapshot                        *Snapshot
 	timeSource                      chainlib.MedianTimeSource
@@ -419,6 +511,15 @@ type Blockchain struct {
 	// syncing node to re-run hypersync, which is a tiny overhead. Moreover, we are moving away from
 	// utxoops overall. They'll only be needed close to the tip to handle reorgs and nowhere else.
 	archivalMode bool
+	// trustedCheckpoint is set when the header chain was bootstrapped from a trusted checkpoint
+	// rather than from genesis. It is cleared once we've hypersynced the state at the checkpoint.
+	trustedCheckpoint *TrustedCheckpoint
+	// headerOnly is set for --sync-type=header-only. We then only sync headers and never connect blocks,
+	// see header_only.go.
+	headerOnly bool
+	// numUncheckedForbiddenBlockProducers counts the block producer signatures a header-only node accepted
+	// without checking whether the block producer is forbidden. It's accessed atomically.
+	numUncheckedForbiddenBlockProducers uint64
 	// Returns true once all of the housekeeping in creating the
 	// blockchain is complete. This includes setting up the genesis block.
 	isInitialized bool
@@ -616,7 +717,7 @@ func NewBlockchain(
 	maxSyncBlockHeight uint32,
 	params *DeSoParams,
 	timeSource chainlib.MedianTimeSource,
-	db *badger.DB,
+	db KVStore,
 	postgres *Postgres,
 	eventManager *EventManager,
 	snapshot *Snapshot,
@@ -670,6 +771,66 @@ func NewBlockchain(
 	return bc, nil
 }
 
+// InitTrustedCheckpoint roots the header chain at the provided trusted checkpoint so that we
+// only download headers after it. This is only done if we haven't synced past the genesis block
+// yet, otherwise the chain we loaded from the db already covers the checkpoint and we ignore it.
+func (bc *Blockchain) InitTrustedCheckpoint(checkpoint *TrustedCheckpoint) error {
+	if checkpoint == nil {
+		return nil
+	}
+	if bc.snapshot == nil {
+		return fmt.Errorf("InitTrustedCheckpoint: A trusted checkpoint can only be used with hypersync")
+	}
+	if bc.archivalMode {
+		return fmt.Errorf("InitTrustedCheckpoint: A trusted checkpoint can't be used in archival mode " +
+			"because blocks prior to the checkpoint will never be downloaded")
+	}
+
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	if bc.blockTip().Height != 0 {
+		glog.Infof("InitTrustedCheckpoint: Ignoring trusted checkpoint at height (%v) because the block "+
+			"tip is already at height (%v)", checkpoint.Height, bc.blockTip().Height)
+		return nil
+	}
+
+	// We don't have the checkpoint header, so we put a placeholder in its place. The header has the
+	// checkpoint's real height and timestamp, so that headers built on top of it are held to the
+	// timestamp check and the difficulty retarget that starts at the checkpoint, and an empty
+	// PrevBlockHash so that we don't look for its parent when reading the block index from the db.
+	checkpointHeader := &MsgDeSoHeader{
+		Version:               CurrentHeaderVersion,
+		PrevBlockHash:         &BlockHash{},
+		TransactionMerkleRoot: &BlockHash{},
+		TstampSecs:            checkpoint.TstampSecs,
+		Height:                uint64(checkpoint.Height),
+	}
+	// Start the cumulative work from the min chain work, since we trust that the chain up to the
+	// checkpoint had at least that much work on it.
+	minChainWorkBytes, err := hex.DecodeString(bc.params.MinChainWorkHex)
+	if err != nil {
+		return errors.Wrapf(err, "InitTrustedCheckpoint: Problem computing min chain work")
+	}
+	checkpointNode := NewBlockNode(
+		nil,
+		checkpoint.BlockHash,
+		checkpoint.Height,
+		checkpoint.DifficultyTarget,
+		BytesToBigint(minChainWorkBytes),
+		checkpointHeader,
+		StatusHeaderValidated)
+
+	bc.blockIndex[*checkpointNode.Hash] = checkpointNode
+	bc.bestHeaderChain = []*BlockNode{checkpointNode}
+	bc.bestHeaderChainMap = map[BlockHash]*BlockNode{*checkpointNode.Hash: checkpointNode}
+	bc.trustedCheckpoint = checkpoint
+
+	glog.Infof(CLog(Magenta, fmt.Sprintf("InitTrustedCheckpoint: Syncing headers from trusted checkpoint "+
+		"at height (%v) with hash (%v)", checkpoint.Height, checkpoint.BlockHash)))
+	return nil
+}
+
 // log2FloorMasks defines the masks to use when quickly calculating
 // floor(log2(x)) in a constant log2(32) = 5 steps, where x is a uint32, using
 // shifts.  They are derived from (2^(2^x) - 1) * (2^(2^x)), for x in 4..0.
@@ -737,10 +898,10 @@ func locateInventory(locator []*BlockHash, stopHash *BlockHash, maxEntries uint3
 	// is no next block it means the most recently known block is the tip of
 	// the best chain, so there is nothing more to do.
 	nextNodeHeight := uint32(startNode.Header.Height) + 1
-	if uint32(len(bestChainList)) <= nextNodeHeight {
+	startNode = blockNodeAtHeight(bestChainList, nextNodeHeight)
+	if startNode == nil {
 		return nil, 0
 	}
-	startNode = bestChainList[nextNodeHeight]
 
 	// Calculate how many entries are needed.
 	tip := bestChainList[len(bestChainList)-1]
@@ -786,7 +947,7 @@ func locateHeaders(locator []*BlockHash, stopHash *BlockHash, maxHeaders uint32,
 		if uint32(len(headers)) == total {
 			break
 		}
-		node = bestChainList[node.Header.Height+1]
+		node = blockNodeAtHeight(bestChainList, uint32(node.Header.Height)+1)
 	}
 	return headers
 }
@@ -854,20 +1015,27 @@ func (bc *Blockchain) LatestLocator(tip *BlockNode) []*BlockHash {
 	}
 	locator := make([]*BlockHash, 0, maxEntries)
 
+	// The header chain normally starts at the genesis block, but it starts at the
+	// checkpoint if we bootstrapped from a trusted checkpoint.
+	rootHeight := int32(0)
+	if len(bc.bestHeaderChain) > 0 {
+		rootHeight = int32(bc.bestHeaderChain[0].Height)
+	}
+
 	step := int32(1)
 	for tip != nil {
 		locator = append(locator, tip.Hash)
 
 		// Nothing more to add once the genesis block has been added.
-		if tip.Header.Height == 0 {
+		if int32(tip.Header.Height) <= rootHeight {
 			break
 		}
 
 		// Calculate height of previous node to include ensuring the
 		// final node is the genesis block.
 		height := int32(tip.Header.Height) - step
-		if height < 0 {
-			height = 0
+		if height < rootHeight {
+			height = rootHeight
 		}
 
 		// When the node is in the current chain view, all of its
@@ -875,7 +1043,7 @@ func (bc *Blockchain) LatestLocator(tip *BlockNode) []*BlockHash {
 		// that case.  Otherwise, fall back to walking backwards through
 		// the nodes of the other chain to the correct ancestor.
 		if _, exists := bc.bestHeaderChainMap[*tip.Hash]; exists {
-			tip = bc.bestHeaderChain[height]
+			tip = blockNodeAtHeight(bc.bestHeaderChain, uint32(height))
 		} else {
 			tip = tip.Ancestor(uint32(height))
 		}
@@ -950,14 +1118,14 @@ func (bc *Blockchain) GetBlockNodesToFetch(
 	currentHeight := headerNodeStart.Height + 1
 	blockNodesToFetch := []*BlockNode{}
 	heightLimit := maxHeight
-	if heightLimit >= uint32(len(bc.bestHeaderChain)) {
-		heightLimit = uint32(len(bc.bestHeaderChain) - 1)
+	if headerTip := bc.headerTip(); heightLimit > headerTip.Height {
+		heightLimit = headerTip.Height
 	}
 	for currentHeight <= heightLimit &&
 		len(blockNodesToFetch) < numBlocks {
 
 		// Get the current hash and increment the height.
-		currentNode := bc.bestHeaderChain[currentHeight]
+		currentNode := blockNodeAtHeight(bc.bestHeaderChain, currentHeight)
 		currentHeight++
 
 		if _, exists := blocksToIgnore[*currentNode.Hash]; exists {
@@ -977,11 +1145,7 @@ func (bc *Blockchain) HasHeader(headerHash *BlockHash) bool {
 }
 
 func (bc *Blockchain) HeaderAtHeight(blockHeight uint32) *BlockNode {
-	if blockHeight >= uint32(len(bc.bestHeaderChain)) {
-		return nil
-	}
-
-	return bc.bestHeaderChain[blockHeight]
+	return blockNodeAtHeight(bc.bestHeaderChain, blockHeight)
 }
 
 func (bc *Blockchain) HasBlock(blockHash *BlockHash) bool {
@@ -1012,13 +1176,12 @@ func (bc *Blockchain) GetBlock(blockHash *BlockHash) *MsgDeSoBlock {
 }
 
 func (bc *Blockchain) GetBlockAtHeight(height uint32) *MsgDeSoBlock {
-	numBlocks := uint32(len(bc.bestChain))
-
-	if height >= numBlocks {
+	blockNode := blockNodeAtHeight(bc.bestChain, height)
+	if blockNode == nil {
 		return nil
 	}
 
-	return bc.GetBlock(bc.bestChain[height].Hash)
+	return bc.GetBlock(blockNode.Hash)
 }
 
 // GetBlockNodeWithHash looks for a block node in the bestChain list that matches the hash.
@@ -1130,6 +1293,11 @@ func (bc *Blockchain) chainState() SyncState {
 		return SyncStateSyncingHeaders
 	}
 
+	// A header-only node doesn't download blocks, so it's current as soon as its headers are.
+	if bc.headerOnly {
+		return SyncStateFullyCurrent
+	}
+
 	// If the header tip is current and the block tip is far in the past, then we're in the SyncStateSyncingSnapshot state.
 	if bc.syncingState {
 		return SyncStateSyncingSnapshot
@@ -1239,7 +1407,7 @@ func (bc *Blockchain) HeaderTip() *BlockNode {
 }
 
 // TODO: This breaks law of demeter and we should fix it
-func (bc *Blockchain) DB() *badger.DB {
+func (bc *Blockchain) DB() KVStore {
 	return bc.db
 }
 
@@ -1607,6 +1775,14 @@ func (bc *Blockchain) processHeader(blockHeader *MsgDeSoHeader, headerHash *Bloc
 		return false, false, HeaderErrorDuplicateHeader
 	}
 
+	// If our header chain was bootstrapped from a trusted checkpoint, we only accept
+	// headers that build on top of the checkpoint. Anything at or below the checkpoint
+	// height would fork off from a part of the chain that we don't have.
+	if len(bc.bestHeaderChain) > 0 && bc.bestHeaderChain[0].Height > 0 &&
+		blockHeader.Height <= uint64(bc.bestHeaderChain[0].Height) {
+		return false, false, HeaderErrorBelowTrustedCheckpoint
+	}
+
 	// If we're here then it means we're processing a header we haven't
 	// seen before.
 
@@ -1777,27 +1953,10 @@ func (bc *Blockchain) ProcessHeader(blockHeader *MsgDeSoHeader, headerHash *Bloc
 	return bc.processHeader(blockHeader, headerHash)
 }
 
-func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures bool) (_isMainChain bool, _isOrphan bool, _err error) {
-	// TODO: Move this to be more isolated.
-	bc.ChainLock.Lock()
-	defer bc.ChainLock.Unlock()
-
-	blockHeight := uint64(bc.BlockTip().Height + 1)
-
-	bc.timer.Start("Blockchain.ProcessBlock: Initial")
-	if desoBlock == nil {
-		return false, false, fmt.Errorf("ProcessBlock: Block is nil")
-	}
-
-	// Start by getting and validating the block's header.
+// verifyBlockProducerSignature checks that the block was signed by one of the trusted block producers
+// if --trusted-block-producer-public-keys is set.
+func (bc *Blockchain) verifyBlockProducerSignature(desoBlock *MsgDeSoBlock, blockHash *BlockHash) error {
 	blockHeader := desoBlock.Header
-	if blockHeader == nil {
-		return false, false, fmt.Errorf("ProcessBlock: Block header was nil")
-	}
-	blockHash, err := blockHeader.Hash()
-	if err != nil {
-		return false, false, errors.Wrapf(err, "ProcessBlock: Problem computing block hash")
-	}
 	// If a trusted block producer public key is set, then we only accept blocks
 	// if they have been signed by one of these public keys.
 	if len(bc.trustedBlockProducerPublicKeys) > 0 {
@@ -1805,8 +1964,8 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			if desoBlock.BlockProducerInfo == nil ||
 				desoBlock.BlockProducerInfo.Signature == nil {
 
-				return false, false, errors.Wrapf(RuleErrorMissingBlockProducerSignature,
-					"ProcessBlock: Block signature is required since "+
+				return errors.Wrapf(RuleErrorMissingBlockProducerSignature,
+					"verifyBlockProducerSignature: Block signature is required since "+
 						"--trusted_block_producer_public_keys is set *and* block height "+
 						"%v is >= --trusted_block_producer_block_height %v.", blockHeader.Height,
 					bc.trustedBlockProducerStartHeight)
@@ -1817,8 +1976,8 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			// Verify that the public key has the valid length
 			publicKey := desoBlock.BlockProducerInfo.PublicKey
 			if len(publicKey) != btcec.PubKeyBytesLenCompressed {
-				return false, false, errors.Wrapf(RuleErrorInvalidBlockProducerPublicKey,
-					"ProcessBlock: Block producer public key is invalid even though "+
+				return errors.Wrapf(RuleErrorInvalidBlockProducerPublicKey,
+					"verifyBlockProducerSignature: Block producer public key is invalid even though "+
 						"--trusted_block_producer_public_keys is set *and* block height "+
 						"%v is >= --trusted_block_producer_block_height %v.", blockHeader.Height,
 					bc.trustedBlockProducerStartHeight)
@@ -1826,17 +1985,21 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 
 			// Verify that the public key is in the allowed set.
 			if _, exists := bc.trustedBlockProducerPublicKeys[MakePkMapKey(publicKey)]; !exists {
-				return false, false, errors.Wrapf(RuleErrorBlockProducerPublicKeyNotInWhitelist,
-					"ProcessBlock: Block producer public key %v is not in the allowed list of "+
+				return errors.Wrapf(RuleErrorBlockProducerPublicKeyNotInWhitelist,
+					"verifyBlockProducerSignature: Block producer public key %v is not in the allowed list of "+
 						"--trusted_block_producer_public_keys: %v.", PkToStringBoth(publicKey),
 					bc.trustedBlockProducerPublicKeys)
 			}
 
-			// Verify that the public key has not been forbidden.
-			dbEntry := DbGetForbiddenBlockSignaturePubKey(bc.db, bc.snapshot, publicKey)
-			if dbEntry != nil {
-				return false, false, errors.Wrapf(RuleErrorForbiddenBlockProducerPublicKey,
-					"ProcessBlock: Block producer public key %v is forbidden", PkToStringBoth(publicKey))
+			// Verify that the public key has not been forbidden. Forbidden public keys are part of the
+			// state, which a header-only node doesn't have, so it can't check them.
+			if bc.headerOnly {
+				atomic.AddUint64(&bc.numUncheckedForbiddenBlockProducers, 1)
+				glog.Warningf("verifyBlockProducerSignature: Can't check whether block producer public key %v "+
+					"is forbidden because header-only nodes don't keep the state", PkToStringBoth(publicKey))
+			} else if dbEntry := DbGetForbiddenBlockSignaturePubKey(bc.db, bc.snapshot, publicKey); dbEntry != nil {
+				return errors.Wrapf(RuleErrorForbiddenBlockProducerPublicKey,
+					"verifyBlockProducerSignature: Block producer public key %v is forbidden", PkToStringBoth(publicKey))
 			}
 
 			// At this point we are confident that we have a valid public key that is
@@ -1845,19 +2008,46 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			signature := desoBlock.BlockProducerInfo.Signature
 			pkObj, err := btcec.ParsePubKey(publicKey, btcec.S256())
 			if err != nil {
-				return false, false, errors.Wrapf(err,
-					"ProcessBlock: Error parsing block producer public key: %v.",
+				return errors.Wrapf(err,
+					"verifyBlockProducerSignature: Error parsing block producer public key: %v.",
 					PkToStringBoth(publicKey))
 			}
 			if !signature.Verify(blockHash[:], pkObj) {
-				return false, false, errors.Wrapf(RuleErrorInvalidBlockProducerSIgnature,
-					"ProcessBlock: Error validating signature %v for public key %v: %v.",
+				return errors.Wrapf(RuleErrorInvalidBlockProducerSIgnature,
+					"verifyBlockProducerSignature: Error validating signature %v for public key %v: %v.",
 					hex.EncodeToString(signature.Serialize()),
 					PkToStringBoth(publicKey),
 					err)
 			}
 		}
 	}
+	return nil
+}
+
+func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures bool) (_isMainChain bool, _isOrphan bool, _err error) {
+	// TODO: Move this to be more isolated.
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+
+	blockHeight := uint64(bc.BlockTip().Height + 1)
+
+	bc.timer.Start("Blockchain.ProcessBlock: Initial")
+	if desoBlock == nil {
+		return false, false, fmt.Errorf("ProcessBlock: Block is nil")
+	}
+
+	// Start by getting and validating the block's header.
+	blockHeader := desoBlock.Header
+	if blockHeader == nil {
+		return false, false, fmt.Errorf("ProcessBlock: Block header was nil")
+	}
+	blockHash, err := blockHeader.Hash()
+	if err != nil {
+		return false, false, errors.Wrapf(err, "ProcessBlock: Problem computing block hash")
+	}
+	if err = bc.verifyBlockProducerSignature(desoBlock, blockHash); err != nil {
+		return false, false, err
+	}
 	bc.timer.End("Blockchain.ProcessBlock: Initial")
 	bc.timer.Start("Blockchain.ProcessBlock: BlockNode")
 
@@ -2018,7 +2208,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			err = errors.Wrapf(err, "ProcessBlock: Problem saving block with StatusBlockStored")
 		}
 	} else {
-		err = bc.db.Update(func(txn *badger.Txn) error {
+		err = bc.db.Update(func(txn KVTxn) error {
 			if bc.snapshot != nil {
 				bc.snapshot.PrepareAncestralRecordsFlush()
 				defer bc.snapshot.StartAncestralRecordsFlush(true)
@@ -2067,13 +2257,8 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 
 	if *parentNode.Hash == *currentTip.Hash {
 		bc.timer.Start("Blockchain.ProcessBlock: Transactions Validation")
-		// Create a new UtxoView representing the current tip.
-		//
-		// TODO: An optimization can be made here where we pre-load all the inputs this txn
-		// requires into the view before-hand. This basically requires two passes over
-		// the txns to account for txns that spend previous txns in the block, but it would
-		// almost certainly be more efficient than doing a separate db call for each input
-		// and output.
+		// Create a new UtxoView representing the current tip. The inputs and the other
+		// records the block touches are fetched in bulk by Preload below.
 		if bc.blockView == nil {
 			utxoView, err := NewUtxoView(bc.db, bc.params, bc.postgres, bc.snapshot)
 			if err != nil {
@@ -2098,6 +2283,9 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 		}
 
 		utxoOpsForBlock, err := bc.blockView.ConnectBlock(desoBlock, txHashes, verifySignatures, nil, blockHeight)
+		// The preloaded records are only meant for this block. The view outlives the block if it
+		// fails to connect, and the db may change under it before the next block, e.g. in a reorg.
+		bc.blockView._resetPreloadKVStore()
 		if err != nil {
 			if IsRuleError(err) {
 				// If we have a RuleError, mark the block as invalid before
@@ -2133,7 +2321,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			}
 
 			// Since we don't have utxo operations in postgres, always write UTXO operations for the block to badger
-			err = bc.db.Update(func(txn *badger.Txn) error {
+			err = bc.db.Update(func(txn KVTxn) error {
 				if err = PutUtxoOperationsForBlockWithTxn(txn, bc.snapshot, blockHeight, blockHash, utxoOpsForBlock); err != nil {
 					return errors.Wrapf(err, "ProcessBlock: Problem writing utxo operations to db on simple add to tip")
 				}
@@ -2141,7 +2329,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 			})
 		} else {
 			bc.timer.Start("Blockchain.ProcessBlock: Transactions Db put")
-			err = bc.db.Update(func(txn *badger.Txn) error {
+			err = bc.db.Update(func(txn KVTxn) error {
 				// This will update the node's status.
 				bc.timer.Start("Blockchain.ProcessBlock: Transactions Db height & hash")
 				if err := PutHeightHashToNodeInfoWithTxn(txn, bc.snapshot, nodeToValidate, false /*bitcoinNodes*/); err != nil {
@@ -2418,7 +2606,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 		// the state after applying the reorg. With this information, it is possible to
 		// roll back the blocks and fast forward the db to the post-reorg state with a
 		// single transaction.
-		err = bc.db.Update(func(txn *badger.Txn) error {
+		err = bc.db.Update(func(txn KVTxn) error {
 			// Set the best node hash to the new tip.
 			if err := PutBestHashWithTxn(txn, bc.snapshot, newTipNode.Hash, ChainTypeDeSoBlock); err != nil {
 				return err
@@ -2560,7 +2748,7 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 			glog.V(1).Info(CLog(Yellow, fmt.Sprintf("DisconnectBlocksToHeight: Found node in blockIndex with "+
 				"larger height than the current block tip. Deleting the corresponding block reward. Node: (%v)", node)))
 			blockToDetach, err := GetBlock(hash, bc.db, nil)
-			if err != nil && err != badger.ErrKeyNotFound {
+			if err != nil && err != ErrKVKeyNotFound {
 				return errors.Wrapf(err, "DisconnectBlocksToHeight: Problem getting block with hash: (%v) and "+
 					"at height: (%v)", hash, node.Height)
 			}
@@ -2578,7 +2766,7 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64) error {
 		prevHash := *bc.bestChain[ii-1].Hash
 		hash := *bc.bestChain[ii].Hash
 		height := uint64(bc.bestChain[ii].Height)
-		err := bc.db.Update(func(txn *badger.Txn) error {
+		err := bc.db.Update(func(txn KVTxn) error {
 			utxoView, err := NewUtxoView(bc.db, bc.params, nil, nil)
 			if err != nil {
 				return err
@@ -4645,7 +4833,7 @@ func (bc *Blockchain) AddInputsAndChangeToTransactionWithSubsidy(
 		var err error
 		var utxoView *UtxoView
 		if mempool != nil {
-			utxoView, err = mempool.GetAugmentedUniversalView()
+			utxoView, err = mempool.GetAugmentedUniversalChildView()
 			if err != nil {
 				return 0, 0, 0, 0, errors.Wrapf(err,
 					"_computeInputsForTxn: Problem getting augmented UtxoView from mempool: ")
@@ -8,12 +8,12 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	chainlib "github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/btcec"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -135,7 +135,7 @@ func getForkedChain(t *testing.T) (blockA1, blockA2, blockB1, blockB2,
 	return
 }
 
-func NewTestBlockchain() (*Blockchain, *DeSoParams, *badger.DB) {
+func NewTestBlockchain() (*Blockchain, *DeSoParams, KVStore) {
 	db, _ := GetTestBadgerDb()
 	timesource := chainlib.NewMedianTime()
 
@@ -151,12 +151,17 @@ func NewTestBlockchain() (*Blockchain, *DeSoParams, *badger.DB) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	testLastLowDifficultyChain = chain
 
 	return chain, &paramsCopy, db
 }
 
+// testLastLowDifficultyChain is the chain NewLowDifficultyBlockchainWithParams created last. It lets
+// test harnesses inspect the state a test left behind.
+var testLastLowDifficultyChain *Blockchain
+
 func NewLowDifficultyBlockchain() (
-	*Blockchain, *DeSoParams, *badger.DB) {
+	*Blockchain, *DeSoParams, KVStore) {
 
 	// Set the number of txns per view regeneration to one while creating the txns
 	ReadOnlyUtxoViewRegenerationIntervalTxns = 1
@@ -165,7 +170,7 @@ func NewLowDifficultyBlockchain() (
 }
 
 func NewLowDifficultyBlockchainWithParams(params *DeSoParams) (
-	*Blockchain, *DeSoParams, *badger.DB) {
+	*Blockchain, *DeSoParams, KVStore) {
 
 	// Set the number of txns per view regeneration to one while creating the txns
 	ReadOnlyUtxoViewRegenerationIntervalTxns = 1
@@ -175,7 +180,12 @@ func NewLowDifficultyBlockchainWithParams(params *DeSoParams) (
 	var postgresDb *Postgres
 
 	if len(os.Getenv("POSTGRES_URI")) > 0 {
-		postgresDb = NewPostgres(pg.Connect(ParsePostgresURI(os.Getenv("POSTGRES_URI"))))
+		pgDb := pg.Connect(ParsePostgresURI(os.Getenv("POSTGRES_URI")))
+		// Every chain starts from an empty db, like the badger db it's paired with.
+		if err := _resetPostgresTables(pgDb); err != nil {
+			log.Fatal(err)
+		}
+		postgresDb = NewPostgres(pgDb)
 	}
 
 	// Set some special parameters for testing. If the blocks above are changed
@@ -232,6 +242,25 @@ func NewLowDifficultyBlockchainWithParams(params *DeSoParams) (
 	return chain, &paramsCopy, db
 }
 
+// _resetPostgresTables empties the tables of the node in a migrated Postgres db. The migration bookkeeping
+// is kept, so the db ends up in the same state as a freshly migrated one.
+func _resetPostgresTables(db *pg.DB) error {
+	var tableNames []string
+	_, err := db.Query(&tableNames, `
+		SELECT tablename FROM pg_tables WHERE schemaname = current_schema() AND tablename LIKE 'pg\_%'
+	`)
+	if err != nil {
+		return fmt.Errorf("_resetPostgresTables: Problem listing tables: %v", err)
+	}
+	if len(tableNames) == 0 {
+		return nil
+	}
+	if _, err = db.Exec("TRUNCATE TABLE " + strings.Join(tableNames, ", ")); err != nil {
+		return fmt.Errorf("_resetPostgresTables: Problem truncating tables: %v", err)
+	}
+	return nil
+}
+
 func NewTestMiner(t *testing.T, chain *Blockchain, params *DeSoParams, isSender bool) (*DeSoMempool, *DeSoMiner) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -626,6 +655,128 @@ func TestProcessHeaderskReorgBlocks(t *testing.T) {
 	}
 }
 
+func TestTrustedCheckpointHeaders(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	_, _ = assert, require
+
+	blockA1, blockA2, blockB1, _, _, _, _ := getForkedChain(t)
+
+	chain, _, _ := NewLowDifficultyBlockchain()
+	checksumBytes, err := chain.snapshot.Checksum.ToBytes()
+	require.NoError(err)
+
+	// Make sure we validate the checkpoint flags.
+	diffTargetHex := chain.params.MinDifficultyTargetHex
+	tstampSecs := blockA1.Header.TstampSecs
+	{
+		checkpoint, err := NewTrustedCheckpoint("", 0, "", 0, "")
+		require.NoError(err)
+		require.Nil(checkpoint)
+
+		_, err = NewTrustedCheckpoint("abcd", 1, diffTargetHex, tstampSecs, hex.EncodeToString(checksumBytes))
+		require.Error(err)
+
+		_, err = NewTrustedCheckpoint(hex.EncodeToString(blockA1.Header.PrevBlockHash[:]), 0,
+			diffTargetHex, tstampSecs, hex.EncodeToString(checksumBytes))
+		require.Error(err)
+
+		_, err = NewTrustedCheckpoint(hex.EncodeToString(blockA1.Header.PrevBlockHash[:]), 1,
+			"abcd", tstampSecs, hex.EncodeToString(checksumBytes))
+		require.Error(err)
+
+		_, err = NewTrustedCheckpoint(hex.EncodeToString(blockA1.Header.PrevBlockHash[:]), 1,
+			diffTargetHex, 0, hex.EncodeToString(checksumBytes))
+		require.Error(err)
+
+		_, err = NewTrustedCheckpoint(hex.EncodeToString(blockA1.Header.PrevBlockHash[:]), 1,
+			diffTargetHex, tstampSecs, "ff")
+		require.Error(err)
+	}
+
+	// Root the header chain at block A1.
+	blockA1Hash, err := blockA1.Hash()
+	require.NoError(err)
+	checkpoint, err := NewTrustedCheckpoint(hex.EncodeToString(blockA1Hash[:]), 1,
+		diffTargetHex, tstampSecs, hex.EncodeToString(checksumBytes))
+	require.NoError(err)
+	require.NoError(chain.InitTrustedCheckpoint(checkpoint))
+	require.Equal(*blockA1Hash, *chain.headerTip().Hash)
+	require.Equal(uint32(1), chain.headerTip().Height)
+	require.Equal(*checkpoint.DifficultyTarget, *chain.headerTip().DifficultyTarget)
+	require.Equal(tstampSecs, chain.headerTip().Header.TstampSecs)
+	require.Nil(chain.HeaderAtHeight(0))
+
+	// A header that isn't newer than the checkpoint should be rejected.
+	{
+		staleHeader := *blockA2.Header
+		staleHeader.TstampSecs = tstampSecs
+		headerHash, err := staleHeader.Hash()
+		require.NoError(err)
+		_, _, err = chain.ProcessHeader(&staleHeader, headerHash)
+		require.Error(err)
+		require.Contains(err.Error(), HeaderErrorTimestampTooEarly)
+	}
+
+	// A2 builds on top of the checkpoint so it should connect without issue.
+	{
+		headerHash, err := blockA2.Header.Hash()
+		require.NoError(err)
+		isMainChain, isOrphan, err := chain.ProcessHeader(blockA2.Header, headerHash)
+		require.NoError(err)
+		require.True(isMainChain)
+		require.False(isOrphan)
+		require.Equal(*headerHash, *chain.headerTip().Hash)
+		require.Equal(*headerHash, *chain.HeaderAtHeight(2).Hash)
+		require.Equal(*blockA1Hash, *chain.HeaderAtHeight(1).Hash)
+
+		// The locator should end at the checkpoint rather than at genesis.
+		locator := chain.LatestHeaderLocator()
+		require.Equal(2, len(locator))
+		require.Equal(*headerHash, *locator[0])
+		require.Equal(*blockA1Hash, *locator[1])
+	}
+
+	// B1 forks off below the checkpoint so it should be rejected.
+	{
+		headerHash, err := blockB1.Header.Hash()
+		require.NoError(err)
+		_, _, err = chain.ProcessHeader(blockB1.Header, headerHash)
+		require.Error(err)
+		require.Contains(err.Error(), HeaderErrorBelowTrustedCheckpoint)
+	}
+
+	// A header right after a checkpoint at a retarget point must meet the checkpoint's difficulty, even
+	// though the retarget spans blocks we don't have.
+	{
+		hardChain, hardParams, _ := NewLowDifficultyBlockchain()
+		blocksPerRetarget := uint32(hardParams.TimeBetweenDifficultyRetargets / hardParams.TimeBetweenBlocks)
+		hardDiffTarget := BigintToHash(new(big.Int).Lsh(big.NewInt(1), 254))
+		hardCheckpoint, err := NewTrustedCheckpoint(hex.EncodeToString(blockA1Hash[:]), 2*blocksPerRetarget,
+			hex.EncodeToString(hardDiffTarget[:]), tstampSecs, hex.EncodeToString(checksumBytes))
+		require.NoError(err)
+		require.NoError(hardChain.InitTrustedCheckpoint(hardCheckpoint))
+
+		// Mine a header that misses the checkpoint's difficulty, but by less than the retarget factor.
+		easyHeader := *blockA2.Header
+		easyHeader.PrevBlockHash = blockA1Hash
+		easyHeader.Height = uint64(2*blocksPerRetarget + 1)
+		easyDiffTarget := new(big.Int).Mul(HashToBigint(hardDiffTarget), big.NewInt(hardParams.MaxDifficultyRetargetFactor))
+		var headerHash *BlockHash
+		for easyHeader.Nonce = 0; ; easyHeader.Nonce++ {
+			headerHash, err = easyHeader.Hash()
+			require.NoError(err)
+			headerHashBigint := HashToBigint(headerHash)
+			if headerHashBigint.Cmp(HashToBigint(hardDiffTarget)) > 0 && headerHashBigint.Cmp(easyDiffTarget) <= 0 {
+				break
+			}
+		}
+		_, _, err = hardChain.ProcessHeader(&easyHeader, headerHash)
+		require.Error(err)
+		require.Contains(err.Error(), HeaderErrorBlockDifficultyAboveTarget)
+	}
+}
+
 func TestProcessBlockReorgBlocks(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -1335,6 +1486,67 @@ func TestCalcNextDifficultyTargetJustRight(t *testing.T) {
 	}, diffsAsInts)
 }
 
+func TestCalcNextDifficultyTargetFromTrustedCheckpoint(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	_ = assert
+	_ = require
+
+	fakeParams := &DeSoParams{
+		MinDifficultyTargetHex:         hex.EncodeToString(BigintToHash(big.NewInt(100000))[:]),
+		TimeBetweenDifficultyRetargets: 6 * time.Second,
+		TimeBetweenBlocks:              2 * time.Second,
+		MaxDifficultyRetargetFactor:    3,
+	}
+
+	// The checkpoint node has no parent, like the one InitTrustedCheckpoint creates.
+	checkpointNode := NewBlockNode(
+		nil,
+		nil,
+		10,
+		BigintToHash(big.NewInt(1000)),
+		nil,
+		&MsgDeSoHeader{
+			TstampSecs: 20,
+		},
+		StatusHeaderValidated,
+	)
+
+	nodes := []*BlockNode{checkpointNode}
+	diffsAsInts := []int64{}
+	for ii := 1; ii < 7; ii++ {
+		lastNode := nodes[ii-1]
+		nextDiff, err := CalcNextDifficultyTarget(lastNode, HeaderVersion0, fakeParams)
+		require.NoErrorf(err, "Block index: %d", ii)
+		nodes = append(nodes, NewBlockNode(
+			lastNode,
+			nil,
+			uint32(10+ii),
+			nextDiff,
+			nil,
+			&MsgDeSoHeader{
+				// Blocks generating every second, which is faster than the target.
+				TstampSecs: uint64(20 + ii),
+			},
+			StatusNone,
+		))
+
+		diffsAsInts = append(diffsAsInts, HashToBigint(nextDiff).Int64())
+	}
+
+	assert.Equal([]int64{
+		1000,
+		1000,
+		// The retarget at height 12 spans blocks we don't have, so it keeps the
+		// checkpoint's difficulty.
+		1000,
+		1000,
+		1000,
+		// The retarget at height 15 starts at height 12, after the checkpoint.
+		500,
+	}, diffsAsInts)
+}
+
 func TestCalcNextDifficultyTargetSlightlyOff(t *testing.T) {
 	assert := assert.New(t)
 	require := require.New(t)
@@ -0,0 +1,216 @@
+package lib
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// compact_blocks.go builds compact blocks out of full blocks, and rebuilds full blocks
+// out of compact blocks using the transactions in our mempool. The flow between two
+// nodes that set SFCompactBlocks is:
+// - The sender accepts a new block and relays a MsgDeSoCompactBlock instead of an inv.
+// - The receiver matches the short txn ids against its mempool. If it's missing any
+//   transactions, it asks for them with a MsgDeSoGetBlockTxns.
+// - The sender replies with a MsgDeSoBlockTxns, and the receiver processes the full block.
+// If the receiver can't rebuild the block, it falls back to fetching headers and the full
+// block as it would after an inv.
+
+// MaxPartialCompactBlocksPerPeer is the number of compact blocks we wait on missing txns for from a
+// single peer. Compact blocks past that are fetched the regular way.
+const MaxPartialCompactBlocksPerPeer = 4
+
+// PartialCompactBlockTimeout is how long we wait on a peer to send the missing txns of a compact
+// block before we fetch the block the regular way.
+const PartialCompactBlockTimeout = 30 * time.Second
+
+// CompactBlockShortTxnIDKey returns the key mixed into every short txn id of a compact block.
+func CompactBlockShortTxnIDKey(blockHash *BlockHash, nonce uint64) []byte {
+	keyData := append([]byte{}, blockHash[:]...)
+	keyData = append(keyData, UintToBuf(nonce)...)
+	key := sha256.Sum256(keyData)
+	return key[:]
+}
+
+// CompactBlockShortTxnID returns the short id of a transaction, which is the first
+// CompactBlockShortTxnIDLen bytes of the hash of the key and the transaction hash.
+func CompactBlockShortTxnID(shortTxnIDKey []byte, txnHash *BlockHash) uint64 {
+	hash := sha256.Sum256(append(append([]byte{}, shortTxnIDKey...), txnHash[:]...))
+	paddedShortTxnID := make([]byte, 8)
+	copy(paddedShortTxnID[8-CompactBlockShortTxnIDLen:], hash[:CompactBlockShortTxnIDLen])
+	return binary.BigEndian.Uint64(paddedShortTxnID)
+}
+
+// NewCompactBlock converts a full block into a compact block. The block reward is always
+// prefilled since no peer has it in its mempool.
+func NewCompactBlock(blk *MsgDeSoBlock) (*MsgDeSoCompactBlock, error) {
+	if blk == nil || blk.Header == nil || len(blk.Txns) == 0 {
+		return nil, fmt.Errorf("NewCompactBlock: Block should have a header and at least one txn")
+	}
+	blockHash, err := blk.Header.Hash()
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewCompactBlock: Problem hashing header")
+	}
+
+	compactBlock := &MsgDeSoCompactBlock{
+		Header:            blk.Header,
+		Nonce:             uint64(RandInt64(math.MaxInt64)),
+		BlockProducerInfo: blk.BlockProducerInfo,
+		PrefilledTxns: []*PrefilledTxn{{
+			Index: 0,
+			Txn:   blk.Txns[0],
+		}},
+	}
+	shortTxnIDKey := CompactBlockShortTxnIDKey(blockHash, compactBlock.Nonce)
+	for _, txn := range blk.Txns[1:] {
+		compactBlock.ShortTxnIDs = append(compactBlock.ShortTxnIDs, CompactBlockShortTxnID(shortTxnIDKey, txn.Hash()))
+	}
+	return compactBlock, nil
+}
+
+// PartialCompactBlock is a compact block we're rebuilding. Txns holds the transactions we
+// found so far, and MissingTxnIndexes the positions of those we still need from the peer.
+type PartialCompactBlock struct {
+	CompactBlock      *MsgDeSoCompactBlock
+	BlockHash         *BlockHash
+	Txns              []*MsgDeSoTxn
+	MissingTxnIndexes []uint64
+	// TimeRequested is when we asked the peer for the missing txns.
+	TimeRequested time.Time
+}
+
+// ExpirePartialCompactBlocks removes the partial blocks that are no longer worth waiting on from
+// partialBlocks: those whose block hasBlock says we already have, and those whose missing txns were
+// requested more than PartialCompactBlockTimeout before now. It returns the timed out blocks, which
+// still have to be fetched some other way.
+func ExpirePartialCompactBlocks(partialBlocks map[BlockHash]*PartialCompactBlock, now time.Time,
+	hasBlock func(blockHash *BlockHash) bool) []*PartialCompactBlock {
+
+	var timedOutBlocks []*PartialCompactBlock
+	for blockHash, partialBlock := range partialBlocks {
+		if hasBlock(partialBlock.BlockHash) {
+			delete(partialBlocks, blockHash)
+			continue
+		}
+		if now.Sub(partialBlock.TimeRequested) > PartialCompactBlockTimeout {
+			delete(partialBlocks, blockHash)
+			timedOutBlocks = append(timedOutBlocks, partialBlock)
+		}
+	}
+	return timedOutBlocks
+}
+
+// ReconstructCompactBlock fills in the transactions of a compact block from the mempool. Short
+// ids that match more than one mempool transaction are treated as missing.
+func (mp *DeSoMempool) ReconstructCompactBlock(compactBlock *MsgDeSoCompactBlock) (*PartialCompactBlock, error) {
+	blockHash, err := compactBlock.Header.Hash()
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReconstructCompactBlock: Problem hashing header")
+	}
+	numTxns := compactBlock.NumTxns()
+	if numTxns == 0 {
+		return nil, fmt.Errorf("ReconstructCompactBlock: Block should have at least one txn")
+	}
+	partialBlock := &PartialCompactBlock{
+		CompactBlock: compactBlock,
+		BlockHash:    blockHash,
+		Txns:         make([]*MsgDeSoTxn, numTxns),
+	}
+
+	// Place the prefilled transactions. The remaining positions are taken by short ids, in order.
+	shortTxnIDIndexes := make(map[uint64][]uint64)
+	nextPrefilledTxn := 0
+	nextShortTxnID := 0
+	for index := uint64(0); index < numTxns; index++ {
+		if nextPrefilledTxn < len(compactBlock.PrefilledTxns) &&
+			compactBlock.PrefilledTxns[nextPrefilledTxn].Index == index {
+
+			partialBlock.Txns[index] = compactBlock.PrefilledTxns[nextPrefilledTxn].Txn
+			nextPrefilledTxn++
+			continue
+		}
+		if nextShortTxnID >= len(compactBlock.ShortTxnIDs) {
+			return nil, fmt.Errorf("ReconstructCompactBlock: Prefilled txn indexes are out of order")
+		}
+		shortTxnID := compactBlock.ShortTxnIDs[nextShortTxnID]
+		shortTxnIDIndexes[shortTxnID] = append(shortTxnIDIndexes[shortTxnID], index)
+		nextShortTxnID++
+	}
+
+	// Match the short ids against the mempool.
+	shortTxnIDKey := CompactBlockShortTxnIDKey(blockHash, compactBlock.Nonce)
+	matchedTxns := make(map[uint64]*MsgDeSoTxn)
+	ambiguousShortTxnIDs := make(map[uint64]bool)
+	mp.mtx.RLock()
+	for txnHash, mempoolTx := range mp.poolMap {
+		shortTxnID := CompactBlockShortTxnID(shortTxnIDKey, &txnHash)
+		if _, exists := shortTxnIDIndexes[shortTxnID]; !exists {
+			continue
+		}
+		if _, exists := matchedTxns[shortTxnID]; exists {
+			ambiguousShortTxnIDs[shortTxnID] = true
+			continue
+		}
+		matchedTxns[shortTxnID] = mempoolTx.Tx
+	}
+	mp.mtx.RUnlock()
+
+	for shortTxnID, indexes := range shortTxnIDIndexes {
+		txn, matched := matchedTxns[shortTxnID]
+		// A block can't contain the same transaction twice, so a short id that's repeated
+		// in the block is also a collision.
+		if matched && !ambiguousShortTxnIDs[shortTxnID] && len(indexes) == 1 {
+			partialBlock.Txns[indexes[0]] = txn
+		}
+	}
+	for index, txn := range partialBlock.Txns {
+		if txn == nil {
+			partialBlock.MissingTxnIndexes = append(partialBlock.MissingTxnIndexes, uint64(index))
+		}
+	}
+	return partialBlock, nil
+}
+
+// FillMissingTxns places the transactions from a MsgDeSoBlockTxns reply, which must be in the
+// order of MissingTxnIndexes.
+func (partialBlock *PartialCompactBlock) FillMissingTxns(blockTxns *MsgDeSoBlockTxns) error {
+	if *blockTxns.BlockHash != *partialBlock.BlockHash {
+		return fmt.Errorf("FillMissingTxns: Got txns for block %v but expected block %v",
+			blockTxns.BlockHash, partialBlock.BlockHash)
+	}
+	if len(blockTxns.Txns) != len(partialBlock.MissingTxnIndexes) {
+		return fmt.Errorf("FillMissingTxns: Got %d txns but expected %d",
+			len(blockTxns.Txns), len(partialBlock.MissingTxnIndexes))
+	}
+	for ii, index := range partialBlock.MissingTxnIndexes {
+		partialBlock.Txns[index] = blockTxns.Txns[ii]
+	}
+	partialBlock.MissingTxnIndexes = nil
+	return nil
+}
+
+// ToBlock returns the full block once all transactions are known. It fails if the transactions
+// don't match the merkle root in the header, which can happen after a short id collision.
+func (partialBlock *PartialCompactBlock) ToBlock() (*MsgDeSoBlock, error) {
+	if len(partialBlock.MissingTxnIndexes) > 0 {
+		return nil, fmt.Errorf("ToBlock: Block is still missing %d txns", len(partialBlock.MissingTxnIndexes))
+	}
+	merkleRoot, _, err := ComputeMerkleRoot(partialBlock.Txns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ToBlock: Problem computing merkle root")
+	}
+	header := partialBlock.CompactBlock.Header
+	if header.TransactionMerkleRoot == nil || *merkleRoot != *header.TransactionMerkleRoot {
+		return nil, fmt.Errorf("ToBlock: Merkle root %v doesn't match header merkle root %v",
+			merkleRoot, header.TransactionMerkleRoot)
+	}
+	return &MsgDeSoBlock{
+		Header:            header,
+		Txns:              partialBlock.Txns,
+		BlockProducerInfo: partialBlock.CompactBlock.BlockProducerInfo,
+	}, nil
+}
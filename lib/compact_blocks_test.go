@@ -0,0 +1,160 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// _newCompactBlockTestBlock returns a block with a block reward and numTxns basic transfers that
+// differ in their output amounts.
+func _newCompactBlockTestBlock(t *testing.T, numTxns int) *MsgDeSoBlock {
+	require := require.New(t)
+
+	txns := []*MsgDeSoTxn{{
+		TxnMeta: &BlockRewardMetadataa{ExtraData: []byte{0x01}},
+	}}
+	for ii := 0; ii < numTxns; ii++ {
+		txns = append(txns, &MsgDeSoTxn{
+			TxOutputs: []*DeSoOutput{{
+				PublicKey:   m0PkBytes,
+				AmountNanos: uint64(ii + 1),
+			}},
+			TxnMeta:   &BasicTransferMetadata{},
+			PublicKey: m1PkBytes,
+		})
+	}
+	merkleRoot, _, err := ComputeMerkleRoot(txns)
+	require.NoError(err)
+	header := *expectedBlockHeader
+	header.TransactionMerkleRoot = merkleRoot
+	return &MsgDeSoBlock{
+		Header: &header,
+		Txns:   txns,
+	}
+}
+
+func _newCompactBlockTestMempool(txns []*MsgDeSoTxn) *DeSoMempool {
+	mempool := &DeSoMempool{
+		poolMap: make(map[BlockHash]*MempoolTx),
+	}
+	for _, txn := range txns {
+		mempool.poolMap[*txn.Hash()] = &MempoolTx{Tx: txn}
+	}
+	return mempool
+}
+
+func TestCompactBlockReconstruction(t *testing.T) {
+	require := require.New(t)
+
+	blk := _newCompactBlockTestBlock(t, 10)
+	blockHash, err := blk.Hash()
+	require.NoError(err)
+	compactBlock, err := NewCompactBlock(blk)
+	require.NoError(err)
+	require.Len(compactBlock.ShortTxnIDs, 10)
+	require.Len(compactBlock.PrefilledTxns, 1)
+
+	// The compact block survives the wire.
+	compactBlockBytes, err := compactBlock.ToBytes(false)
+	require.NoError(err)
+	decodedCompactBlock := &MsgDeSoCompactBlock{}
+	require.NoError(decodedCompactBlock.FromBytes(compactBlockBytes))
+	require.Equal(compactBlock.ShortTxnIDs, decodedCompactBlock.ShortTxnIDs)
+	decodedBlockHash, err := decodedCompactBlock.Header.Hash()
+	require.NoError(err)
+	require.Equal(blockHash, decodedBlockHash)
+
+	// With every txn in the mempool, the block is rebuilt right away.
+	partialBlock, err := _newCompactBlockTestMempool(blk.Txns[1:]).ReconstructCompactBlock(decodedCompactBlock)
+	require.NoError(err)
+	require.Empty(partialBlock.MissingTxnIndexes)
+	reconstructedBlock, err := partialBlock.ToBlock()
+	require.NoError(err)
+	expectedBlockBytes, err := blk.ToBytes(false)
+	require.NoError(err)
+	reconstructedBlockBytes, err := reconstructedBlock.ToBytes(false)
+	require.NoError(err)
+	require.Equal(expectedBlockBytes, reconstructedBlockBytes)
+
+	// Txns missing from the mempool are requested by their index in the block.
+	mempoolTxns := append([]*MsgDeSoTxn{}, blk.Txns[1:3]...)
+	mempoolTxns = append(mempoolTxns, blk.Txns[4:9]...)
+	partialBlock, err = _newCompactBlockTestMempool(mempoolTxns).ReconstructCompactBlock(decodedCompactBlock)
+	require.NoError(err)
+	require.Equal([]uint64{3, 9, 10}, partialBlock.MissingTxnIndexes)
+	_, err = partialBlock.ToBlock()
+	require.Error(err)
+
+	getBlockTxns := &MsgDeSoGetBlockTxns{
+		BlockHash:  blockHash,
+		TxnIndexes: partialBlock.MissingTxnIndexes,
+	}
+	getBlockTxnsBytes, err := getBlockTxns.ToBytes(false)
+	require.NoError(err)
+	decodedGetBlockTxns := &MsgDeSoGetBlockTxns{}
+	require.NoError(decodedGetBlockTxns.FromBytes(getBlockTxnsBytes))
+	require.Equal(getBlockTxns, decodedGetBlockTxns)
+
+	blockTxns := &MsgDeSoBlockTxns{BlockHash: blockHash}
+	for _, index := range decodedGetBlockTxns.TxnIndexes {
+		blockTxns.Txns = append(blockTxns.Txns, blk.Txns[index])
+	}
+	require.Error(partialBlock.FillMissingTxns(&MsgDeSoBlockTxns{
+		BlockHash: blockHash,
+		Txns:      blockTxns.Txns[1:],
+	}))
+	require.NoError(partialBlock.FillMissingTxns(blockTxns))
+	reconstructedBlock, err = partialBlock.ToBlock()
+	require.NoError(err)
+	reconstructedBlockBytes, err = reconstructedBlock.ToBytes(false)
+	require.NoError(err)
+	require.Equal(expectedBlockBytes, reconstructedBlockBytes)
+
+	// Filling a position with the wrong txn is caught by the merkle root check.
+	partialBlock, err = _newCompactBlockTestMempool(nil).ReconstructCompactBlock(decodedCompactBlock)
+	require.NoError(err)
+	wrongTxns := append([]*MsgDeSoTxn{}, blk.Txns[2:]...)
+	wrongTxns = append(wrongTxns, blk.Txns[1])
+	require.NoError(partialBlock.FillMissingTxns(&MsgDeSoBlockTxns{
+		BlockHash: blockHash,
+		Txns:      wrongTxns,
+	}))
+	_, err = partialBlock.ToBlock()
+	require.Error(err)
+}
+
+func TestExpirePartialCompactBlocks(t *testing.T) {
+	require := require.New(t)
+
+	now := time.Now()
+	newPartialBlock := func(blockHashByte byte, timeRequested time.Time) *PartialCompactBlock {
+		blockHash := &BlockHash{blockHashByte}
+		return &PartialCompactBlock{
+			BlockHash:     blockHash,
+			TimeRequested: timeRequested,
+		}
+	}
+	recentBlock := newPartialBlock(1, now.Add(-PartialCompactBlockTimeout/2))
+	timedOutBlock := newPartialBlock(2, now.Add(-2*PartialCompactBlockTimeout))
+	receivedBlock := newPartialBlock(3, now)
+	partialBlocks := map[BlockHash]*PartialCompactBlock{}
+	for _, partialBlock := range []*PartialCompactBlock{recentBlock, timedOutBlock, receivedBlock} {
+		partialBlocks[*partialBlock.BlockHash] = partialBlock
+	}
+	hasBlock := func(blockHash *BlockHash) bool {
+		return *blockHash == *receivedBlock.BlockHash
+	}
+
+	// Blocks we got some other way are dropped, and timed out blocks are returned so that we
+	// can fetch them.
+	timedOutBlocks := ExpirePartialCompactBlocks(partialBlocks, now, hasBlock)
+	require.Equal([]*PartialCompactBlock{timedOutBlock}, timedOutBlocks)
+	require.Equal(map[BlockHash]*PartialCompactBlock{*recentBlock.BlockHash: recentBlock}, partialBlocks)
+
+	// The remaining block times out eventually.
+	timedOutBlocks = ExpirePartialCompactBlocks(partialBlocks, now.Add(PartialCompactBlockTimeout), hasBlock)
+	require.Equal([]*PartialCompactBlock{recentBlock}, timedOutBlocks)
+	require.Empty(partialBlocks)
+}
@@ -1,10 +1,12 @@
 package lib
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"net"
 	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -15,6 +17,7 @@ import (
 	"github.com/deso-protocol/go-deadlock"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
 )
 
 // connection_manager.go contains most of the logic for creating and managing
@@ -28,8 +31,21 @@ const (
 	Uint64Dec = ^uint64(0)
 	// Uint32Dec decrements a uint32 by one.
 	Uint32Dec = ^uint32(0)
+
+	// MaxOnionAddrs is the most onion addresses we remember. Once we know this many, we
+	// drop new onion addresses until old ones expire.
+	MaxOnionAddrs = 4096
+	// OnionAddrExpiry is how long we remember an onion address nobody has told us about
+	// again. It matches how long the addrmgr keeps an address it hasn't seen.
+	OnionAddrExpiry = 30 * 24 * time.Hour
 )
 
+// onionAddrEntry is an onion address we know, and when we last heard about it.
+type onionAddrEntry struct {
+	onionAddr []byte
+	lastSeen  time.Time
+}
+
 type ConnectionManager struct {
 	// Keep a reference to the Server.
 	// TODO: I'm pretty sure we can make it so that the ConnectionManager and the Peer
@@ -43,6 +59,29 @@ type ConnectionManager struct {
 	// we need to connect to a new outbound peer, it chooses one of the addresses
 	// it's aware of at random and provides it to us.
 	AddrMgr *addrmgr.AddrManager
+	// BanManager keeps track of misbehaving IPs and bans them. We refuse inbound and
+	// outbound connections to banned IPs. It's nil if we don't ban peers.
+	BanManager *BanManager
+	// MessageLimits are the per-peer rate limits on the messages peers send us. It's nil
+	// if we don't rate limit peers.
+	MessageLimits *PeerMessageLimits
+	// PeerTransport holds our static key and the keys pinned for connect-ips. We encrypt
+	// connections with peers that support it. It's nil if we don't encrypt connections.
+	PeerTransport *PeerTransport
+	// PeerRecords tracks how the outbound peers we connected to behaved, and is used to prefer
+	// reliable peers when choosing addresses. It's nil if we don't keep records.
+	PeerRecords *PeerRecords
+	// Bandwidth counts our traffic with all peers and enforces the upload caps.
+	Bandwidth *BandwidthManager
+	// proxyDialer is the SOCKS5 proxy all our outbound connections go through. It's nil if we
+	// connect to peers directly, in which case we can't connect to onion addresses.
+	proxyDialer proxy.ContextDialer
+	// onionAddrs maps the address manager key of an onion address's OnionCat address to
+	// the full onion address, which we need to dial it. The OnionCat address only holds the
+	// first bytes of the onion address, so the first onion address we learn for a key is the
+	// one we keep.
+	mtxOnionAddrs deadlock.RWMutex
+	onionAddrs    map[string]*onionAddrEntry
 	// The interfaces we listen on for new incoming connections.
 	listeners []net.Listener
 	// The parameters we are initialized with.
@@ -137,17 +176,30 @@ func NewConnectionManager(
 	_syncType NodeSyncType,
 	_stallTimeoutSeconds uint64,
 	_minFeeRateNanosPerKB uint64,
+	_banManager *BanManager,
+	_peerTransport *PeerTransport,
+	_messageLimits *PeerMessageLimits,
+	_proxyDialer proxy.ContextDialer,
+	_peerRecords *PeerRecords,
+	_bandwidth *BandwidthManager,
 	_serverMessageQueue chan *ServerMessage,
 	_srv *Server) *ConnectionManager {
 
 	ValidateHyperSyncFlags(_hyperSync, _syncType)
 
 	return &ConnectionManager{
-		srv:        _srv,
-		params:     _params,
-		AddrMgr:    _addrMgr,
-		listeners:  _listeners,
-		connectIps: _connectIps,
+		srv:           _srv,
+		params:        _params,
+		AddrMgr:       _addrMgr,
+		BanManager:    _banManager,
+		PeerTransport: _peerTransport,
+		MessageLimits: _messageLimits,
+		proxyDialer:   _proxyDialer,
+		PeerRecords:   _peerRecords,
+		Bandwidth:     _bandwidth,
+		onionAddrs:    make(map[string]*onionAddrEntry),
+		listeners:     _listeners,
+		connectIps:    _connectIps,
 		// We keep track of the last N nonces we've sent in order to detect
 		// self connections.
 		sentNonces: lru.NewCache(1000),
@@ -218,8 +270,110 @@ func (cmgr *ConnectionManager) subFromGroupKey(na *wire.NetAddress) {
 	cmgr.mtxOutboundConnIPGroups.Unlock()
 }
 
+// _isOutboundCandidate returns true if we could make a non-persistent outbound connection
+// to the address: we aren't connected to it and don't have an outbound peer in its group.
+func (cmgr *ConnectionManager) _isOutboundCandidate(na *wire.NetAddress) bool {
+	cmgr.mtxConnectedOutboundAddrs.RLock()
+	isConnected := cmgr.connectedOutboundAddrs[addrmgr.NetAddressKey(na)]
+	cmgr.mtxConnectedOutboundAddrs.RUnlock()
+	if isConnected {
+		glog.V(2).Infof("ConnectionManager._isOutboundCandidate: Not choosing already connected address %v:%v", na.IP, na.Port)
+		return false
+	}
+
+	// We can only have one outbound address per /16. This is similar to
+	// Bitcoin and we do it to prevent Sybil attacks.
+	if cmgr.isRedundantGroupKey(na) {
+		glog.V(2).Infof("ConnectionManager._isOutboundCandidate: Not choosing address due to redundant group key %v:%v", na.IP, na.Port)
+		return false
+	}
+	return true
+}
+
+// _requiredServices returns the services a peer needs to be a sync candidate for us.
+func (cmgr *ConnectionManager) _requiredServices() ServiceFlag {
+	requiredServices := SFFullNodeDeprecated
+	if cmgr.SyncType == NodeSyncTypeHyperSync || cmgr.SyncType == NodeSyncTypeHyperSyncArchival {
+		requiredServices |= SFHyperSync
+	}
+	if IsNodeArchival(cmgr.SyncType) {
+		requiredServices |= SFArchivalNode
+	}
+	return requiredServices
+}
+
+// _peerRecordNetAddr returns the address of a peer record, or nil if we can't connect to it.
+func (cmgr *ConnectionManager) _peerRecordNetAddr(record *PeerRecord) *wire.NetAddress {
+	host, portStr, err := net.SplitHostPort(record.Addr)
+	if err != nil {
+		return nil
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil
+	}
+	if record.OnionAddr != "" {
+		onionAddr, err := ParseOnionAddr(record.OnionAddr)
+		if err != nil || !cmgr.UsesProxy() {
+			return nil
+		}
+		return cmgr.AddOnionAddr(onionAddr, uint16(port), record.Services)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	return wire.NewNetAddressIPPort(ip, uint16(port), wire.ServiceFlag(record.Services))
+}
+
+// _preferPeerRecords returns true if our next non-persistent outbound peer may be chosen for its
+// good record. We only prefer our records once the good ones span MinPeerRecordGroups network
+// groups, and we keep NumRandomOutboundPeers of our outbound connections for random addresses
+// from the addrmgr. Otherwise, a few hosts that behave well could eclipse us.
+func (cmgr *ConnectionManager) _preferPeerRecords(requiredServices ServiceFlag) bool {
+	if cmgr.PeerRecords.NumGoodGroups(requiredServices) < MinPeerRecordGroups ||
+		cmgr.targetOutboundPeers <= NumRandomOutboundPeers {
+		return false
+	}
+
+	cmgr.mtxConnectedOutboundAddrs.RLock()
+	connectedAddrKeys := make([]string, 0, len(cmgr.connectedOutboundAddrs))
+	for addrKey := range cmgr.connectedOutboundAddrs {
+		connectedAddrKeys = append(connectedAddrKeys, addrKey)
+	}
+	cmgr.mtxConnectedOutboundAddrs.RUnlock()
+
+	numGoodRecords := cmgr.PeerRecords.NumGoodRecords(connectedAddrKeys, requiredServices)
+	return uint32(numGoodRecords) < cmgr.targetOutboundPeers-NumRandomOutboundPeers
+}
+
+// getRandomAddr chooses the address of our next non-persistent outbound peer. It compares the
+// best peers from our records with a few random addresses from the addrmgr, and picks the one
+// with the best record. Addresses we know nothing about beat peers that keep failing, so we
+// move on to new addresses once we run out of reliable ones. If we shouldn't prefer our records,
+// see _preferPeerRecords, we pick one of the random addresses that doesn't keep failing instead.
 func (cmgr *ConnectionManager) getRandomAddr() *wire.NetAddress {
-	for tries := 0; tries < 100; tries++ {
+	requiredServices := cmgr._requiredServices()
+	preferPeerRecords := cmgr._preferPeerRecords(requiredServices)
+	candidateKeys := make(map[string]bool)
+	var candidates []*wire.NetAddress
+
+	var bestRecords []*PeerRecord
+	if preferPeerRecords {
+		bestRecords = cmgr.PeerRecords.BestRecords(NumPeerRecordCandidates, requiredServices,
+			func(record *PeerRecord) bool {
+				na := cmgr._peerRecordNetAddr(record)
+				return na == nil || !cmgr._isOutboundCandidate(na)
+			})
+	}
+	for _, record := range bestRecords {
+		na := cmgr._peerRecordNetAddr(record)
+		candidates = append(candidates, na)
+		candidateKeys[addrmgr.NetAddressKey(na)] = true
+	}
+
+	numAddrMgrCandidates := 0
+	for tries := 0; tries < 100 && numAddrMgrCandidates < NumAddrMgrCandidates; tries++ {
 		// Lock the address map since multiple threads will be trying to read
 		// and modify it at the same time.
 		cmgr.mtxConnectedOutboundAddrs.RLock()
@@ -230,26 +384,34 @@ func (cmgr *ConnectionManager) getRandomAddr() *wire.NetAddress {
 			glog.V(2).Infof("ConnectionManager.getRandomAddr: addr from GetAddressWithExclusions was nil")
 			break
 		}
-
-		if cmgr.connectedOutboundAddrs[addrmgr.NetAddressKey(addr.NetAddress())] {
-			glog.V(2).Infof("ConnectionManager.getRandomAddr: Not choosing already connected address %v:%v", addr.NetAddress().IP, addr.NetAddress().Port)
+		na := addr.NetAddress()
+		if candidateKeys[addrmgr.NetAddressKey(na)] || !cmgr._isOutboundCandidate(na) {
 			continue
 		}
+		candidates = append(candidates, na)
+		candidateKeys[addrmgr.NetAddressKey(na)] = true
+		numAddrMgrCandidates++
+	}
 
-		// We can only have one outbound address per /16. This is similar to
-		// Bitcoin and we do it to prevent Sybil attacks.
-		if cmgr.isRedundantGroupKey(addr.NetAddress()) {
-			glog.V(2).Infof("ConnectionManager.getRandomAddr: Not choosing address due to redundant group key %v:%v", addr.NetAddress().IP, addr.NetAddress().Port)
-			continue
+	var bestAddr *wire.NetAddress
+	bestScore := math.Inf(-1)
+	for _, na := range candidates {
+		score := cmgr.PeerRecords.Get(na).Score(requiredServices)
+		if !preferPeerRecords {
+			score = math.Min(score, 0)
+		}
+		if score > bestScore {
+			bestAddr = na
+			bestScore = score
 		}
-
-		glog.V(2).Infof("ConnectionManager.getRandomAddr: Returning %v:%v at %d iterations",
-			addr.NetAddress().IP, addr.NetAddress().Port, tries)
-		return addr.NetAddress()
 	}
-
-	glog.V(2).Infof("ConnectionManager.getRandomAddr: Returning nil")
-	return nil
+	if bestAddr == nil {
+		glog.V(2).Infof("ConnectionManager.getRandomAddr: Returning nil")
+		return nil
+	}
+	glog.V(2).Infof("ConnectionManager.getRandomAddr: Returning %v:%v with score %v out of %d candidates",
+		bestAddr.IP, bestAddr.Port, bestScore, len(candidates))
+	return bestAddr
 }
 
 func _delayRetry(retryCount int, persistentAddrForLogging *wire.NetAddress) {
@@ -271,6 +433,35 @@ func _delayRetry(retryCount int, persistentAddrForLogging *wire.NetAddress) {
 	time.Sleep(retryDelay)
 }
 
+// isBanned returns true if the IP is banned.
+func (cmgr *ConnectionManager) isBanned(ip net.IP) bool {
+	return cmgr.BanManager != nil && cmgr.BanManager.IsBanned(ip)
+}
+
+// BanIP bans the IP for the given duration and disconnects all of its peers. A zero duration
+// uses the default ban duration.
+func (cmgr *ConnectionManager) BanIP(ip net.IP, duration time.Duration, reason string) (*PeerBan, error) {
+	if cmgr.BanManager == nil {
+		return nil, fmt.Errorf("ConnectionManager.BanIP: Peer bans are disabled")
+	}
+	ban, err := cmgr.BanManager.Ban(ip, duration, reason)
+	if err != nil {
+		return nil, err
+	}
+	cmgr.DisconnectBannedPeers(ip)
+	return ban, nil
+}
+
+// DisconnectBannedPeers disconnects all the peers connected from or to the IP.
+func (cmgr *ConnectionManager) DisconnectBannedPeers(ip net.IP) {
+	for _, pp := range cmgr.GetAllPeers() {
+		if pp.netAddr != nil && pp.netAddr.IP.Equal(ip) {
+			glog.Infof("ConnectionManager.DisconnectBannedPeers: Disconnecting banned peer %v", pp)
+			pp.Disconnect()
+		}
+	}
+}
+
 func (cmgr *ConnectionManager) enoughOutboundPeers() bool {
 	val := atomic.LoadUint32(&cmgr.numOutboundPeers)
 	if val > cmgr.targetOutboundPeers {
@@ -286,9 +477,113 @@ func (cmgr *ConnectionManager) enoughOutboundPeers() bool {
 	return false
 }
 
+// UsesProxy returns true if our outbound connections go through a proxy.
+func (cmgr *ConnectionManager) UsesProxy() bool {
+	return cmgr.proxyDialer != nil
+}
+
+// AddOnionAddr remembers an onion address and returns the OnionCat address it's kept under in
+// the address manager. It returns nil if we already know a different onion address under the
+// same OnionCat address, since anyone can make up an onion address that shares its prefix with
+// a real one, or if we already know MaxOnionAddrs onion addresses.
+func (cmgr *ConnectionManager) AddOnionAddr(onionAddr []byte, port uint16, services ServiceFlag) *wire.NetAddress {
+	na := OnionAddrToNetAddr(onionAddr, port, services)
+	key := addrmgr.NetAddressKey(na)
+	now := time.Now()
+
+	cmgr.mtxOnionAddrs.Lock()
+	defer cmgr.mtxOnionAddrs.Unlock()
+	if entry, exists := cmgr.onionAddrs[key]; exists {
+		if !bytes.Equal(entry.onionAddr, onionAddr) {
+			glog.V(1).Infof("ConnectionManager.AddOnionAddr: Ignoring onion address %v because "+
+				"we already know %v under the same OnionCat address",
+				OnionAddrToString(onionAddr), OnionAddrToString(entry.onionAddr))
+			return nil
+		}
+		entry.lastSeen = now
+		return na
+	}
+	if len(cmgr.onionAddrs) >= MaxOnionAddrs {
+		cmgr._expireOnionAddrs(now)
+		if len(cmgr.onionAddrs) >= MaxOnionAddrs {
+			glog.V(1).Infof("ConnectionManager.AddOnionAddr: Ignoring onion address %v because "+
+				"we already know %d onion addresses", OnionAddrToString(onionAddr), len(cmgr.onionAddrs))
+			return nil
+		}
+	}
+	cmgr.onionAddrs[key] = &onionAddrEntry{
+		onionAddr: append([]byte{}, onionAddr...),
+		lastSeen:  now,
+	}
+	return na
+}
+
+// _expireOnionAddrs forgets onion addresses we haven't heard about in OnionAddrExpiry, which
+// the addrmgr will have dropped by then too. It must be called with mtxOnionAddrs held.
+func (cmgr *ConnectionManager) _expireOnionAddrs(now time.Time) {
+	for key, entry := range cmgr.onionAddrs {
+		if now.Sub(entry.lastSeen) > OnionAddrExpiry {
+			delete(cmgr.onionAddrs, key)
+		}
+	}
+}
+
+// GetOnionAddr returns the onion address behind an OnionCat address, or nil if we don't know it.
+func (cmgr *ConnectionManager) GetOnionAddr(na *wire.NetAddress) []byte {
+	cmgr.mtxOnionAddrs.RLock()
+	defer cmgr.mtxOnionAddrs.RUnlock()
+	entry, exists := cmgr.onionAddrs[addrmgr.NetAddressKey(na)]
+	if !exists {
+		return nil
+	}
+	return entry.onionAddr
+}
+
+// NetAddrToSingleAddr converts an address from the address manager to the form we send to
+// peers. It returns nil for an OnionCat address whose onion address we don't know.
+func (cmgr *ConnectionManager) NetAddrToSingleAddr(na *wire.NetAddress) *SingleAddr {
+	singleAddr := &SingleAddr{
+		Timestamp: time.Now(),
+		Port:      na.Port,
+		Services:  (ServiceFlag)(na.Services),
+	}
+	if addrmgr.IsOnionCatTor(na) {
+		singleAddr.OnionAddr = cmgr.GetOnionAddr(na)
+		if singleAddr.OnionAddr == nil {
+			return nil
+		}
+		return singleAddr
+	}
+	singleAddr.IP = na.IP
+	return singleAddr
+}
+
+// _dialAddr connects to the address, through our proxy if we have one.
+func (cmgr *ConnectionManager) _dialAddr(na *wire.NetAddress) (net.Conn, error) {
+	addr := net.JoinHostPort(na.IP.String(), strconv.Itoa(int(na.Port)))
+	if addrmgr.IsOnionCatTor(na) {
+		onionAddr := cmgr.GetOnionAddr(na)
+		if onionAddr == nil {
+			return nil, fmt.Errorf("ConnectionManager._dialAddr: Unknown onion address for %v", addr)
+		}
+		if cmgr.proxyDialer == nil {
+			return nil, fmt.Errorf("ConnectionManager._dialAddr: Can't connect to onion address %v "+
+				"without a proxy", OnionAddrToString(onionAddr))
+		}
+		addr = net.JoinHostPort(OnionAddrToString(onionAddr), strconv.Itoa(int(na.Port)))
+	}
+
+	if cmgr.proxyDialer != nil {
+		return DialWithProxy(cmgr.proxyDialer, addr, cmgr.params.DialTimeout)
+	}
+	return net.DialTimeout("tcp", addr, cmgr.params.DialTimeout)
+}
+
 // Chooses a random address and tries to connect to it. Repeats this process until
-// it finds a peer that can pass version negotiation.
-func (cmgr *ConnectionManager) _getOutboundConn(persistentAddr *wire.NetAddress) net.Conn {
+// it finds a peer that can pass version negotiation. Returns the connection along
+// with the address we dialed, since the remote address of a proxied connection is
+// the proxy's.
+func (cmgr *ConnectionManager) _getOutboundConn(persistentAddr *wire.NetAddress) (net.Conn, *wire.NetAddress) {
 	// If a persistentAddr was provided then the connection is a persistent
 	// one.
 	isPersistent := (persistentAddr != nil)
@@ -296,7 +591,7 @@ func (cmgr *ConnectionManager) _getOutboundConn(persistentAddr *wire.NetAddress)
 	for {
 		if atomic.LoadInt32(&cmgr.shutdown) != 0 {
 			glog.Info("_getOutboundConn: Ignoring connection due to shutdown")
-			return nil
+			return nil, nil
 		}
 		// We want to start backing off exponentially once we've gone through enough
 		// unsuccessful retries. However, we want to give more slack to non-persistent
@@ -318,7 +613,7 @@ func (cmgr *ConnectionManager) _getOutboundConn(persistentAddr *wire.NetAddress)
 		if !isPersistent && cmgr.enoughOutboundPeers() {
 			glog.V(1).Infof("Dropping connection request to non-persistent outbound " +
 				"peer because we have enough of them.")
-			return nil
+			return nil, nil
 		}
 
 		// If we don't have a persistentAddr, pick one from our addrmgr.
@@ -333,26 +628,34 @@ func (cmgr *ConnectionManager) _getOutboundConn(persistentAddr *wire.NetAddress)
 			continue
 		}
 
-		netAddr := net.TCPAddr{
-			IP:   ipNetAddr.IP,
-			Port: int(ipNetAddr.Port),
+		// Don't connect to banned IPs, even if they're persistent. A persistent address will
+		// be retried with backoff until its ban expires or it's unbanned.
+		if cmgr.isBanned(ipNetAddr.IP) {
+			glog.V(1).Infof("_getOutboundConn: Not connecting to banned addr %v:%v",
+				ipNetAddr.IP, ipNetAddr.Port)
+			continue
 		}
 
 		// If the peer is not persistent, update the addrmgr.
-		glog.V(1).Infof("Attempting to connect to addr: %v", netAddr)
+		glog.V(1).Infof("Attempting to connect to addr: %v", addrmgr.NetAddressKey(ipNetAddr))
 		if !isPersistent {
 			cmgr.AddrMgr.Attempt(ipNetAddr)
 		}
-		var err error
-		conn, err := net.DialTimeout(netAddr.Network(), netAddr.String(), cmgr.params.DialTimeout)
+		var onionAddr []byte
+		if addrmgr.IsOnionCatTor(ipNetAddr) {
+			onionAddr = cmgr.GetOnionAddr(ipNetAddr)
+		}
+		cmgr.PeerRecords.RecordAttempt(ipNetAddr, onionAddr, time.Now())
+		conn, err := cmgr._dialAddr(ipNetAddr)
 		if err != nil {
 			// If we failed to connect to this peer, get a new address and try again.
-			glog.V(1).Infof("Connection to addr (%v) failed: %v", netAddr, err)
+			glog.V(1).Infof("Connection to addr (%v) failed: %v", addrmgr.NetAddressKey(ipNetAddr), err)
+			cmgr.PeerRecords.RecordFailure(ipNetAddr)
 			continue
 		}
 
 		// We were able to dial successfully so we'll break out now.
-		glog.V(1).Infof("Connected to addr: %v", netAddr)
+		glog.V(1).Infof("Connected to addr: %v", addrmgr.NetAddressKey(ipNetAddr))
 
 		// If this was a non-persistent outbound connection, mark the address as
 		// connected in the addrmgr.
@@ -361,7 +664,7 @@ func (cmgr *ConnectionManager) _getOutboundConn(persistentAddr *wire.NetAddress)
 		}
 
 		// We made a successful outbound connection so return.
-		return conn
+		return conn, ipNetAddr
 	}
 }
 
@@ -379,6 +682,14 @@ func IPToNetAddr(ipStr string, addrMgr *addrmgr.AddrManager, params *DeSoParams)
 		}
 		port = uint16(pp)
 	}
+	// Onion addresses are kept under their OnionCat address.
+	if IsOnionHost(host) {
+		onionAddr, err := ParseOnionAddr(host)
+		if err != nil {
+			return nil, errors.Wrapf(err, "IPToNetAddr: Problem parsing onion address from %s", ipStr)
+		}
+		return OnionAddrToNetAddr(onionAddr, port, 0), nil
+	}
 	netAddr, err := addrMgr.HostToNetAddress(host, port, 0)
 	if err != nil {
 		return nil, errors.Wrapf(err, "IPToNetAddr: Can not parse port from %s for ip", ipStr)
@@ -409,8 +720,9 @@ func (cmgr *ConnectionManager) ConnectPeer(conn net.Conn, persistentAddr *wire.N
 		retryCount++
 
 		// If this is an outbound peer, create an outbound connection.
+		var dialedAddr *wire.NetAddress
 		if isOutbound {
-			conn = cmgr._getOutboundConn(persistentAddr)
+			conn, dialedAddr = cmgr._getOutboundConn(persistentAddr)
 		}
 
 		if conn == nil {
@@ -425,8 +737,15 @@ func (cmgr *ConnectionManager) ConnectPeer(conn net.Conn, persistentAddr *wire.N
 		}
 
 		// At this point Conn is set so create a peer object to do
-		// a version negotiation.
-		na, err := IPToNetAddr(conn.RemoteAddr().String(), cmgr.AddrMgr, cmgr.params)
+		// a version negotiation. The address of an outbound peer is the one we
+		// dialed, which differs from the remote address when we use a proxy.
+		var na *wire.NetAddress
+		var err error
+		if dialedAddr != nil {
+			na = wire.NewNetAddressIPPort(dialedAddr.IP, dialedAddr.Port, 0)
+		} else {
+			na, err = IPToNetAddr(conn.RemoteAddr().String(), cmgr.AddrMgr, cmgr.params)
+		}
 		if err != nil {
 			glog.Errorf("ConnectPeer: Problem calling ipToNetAddr for addr: (%s) err: (%v)", conn.RemoteAddr().String(), err)
 
@@ -442,6 +761,9 @@ func (cmgr *ConnectionManager) ConnectPeer(conn net.Conn, persistentAddr *wire.N
 			cmgr.minFeeRateNanosPerKB,
 			cmgr.params,
 			cmgr.srv.incomingMessages, cmgr, cmgr.srv, cmgr.SyncType)
+		if isPersistent {
+			peer.pinnedTransportKey = cmgr.PeerTransport.GetPinnedKey(persistentAddr)
+		}
 
 		if err := peer.NegotiateVersion(cmgr.params.VersionNegotiationTimeout); err != nil {
 			glog.Errorf("ConnectPeer: Problem negotiating version with peer with addr: (%s) err: (%v)", conn.RemoteAddr().String(), err)
@@ -449,6 +771,9 @@ func (cmgr *ConnectionManager) ConnectPeer(conn net.Conn, persistentAddr *wire.N
 			// If we have an error in the version negotiation we disconnect
 			// from this peer.
 			peer.Conn.Close()
+			if isOutbound {
+				cmgr.PeerRecords.RecordFailure(na)
+			}
 
 			// If the connection is outbound, then
 			// we try a new connection until we get one that works. Otherwise
@@ -465,6 +790,10 @@ func (cmgr *ConnectionManager) ConnectPeer(conn net.Conn, persistentAddr *wire.N
 		if isOutbound && !isPersistent {
 			cmgr.AddrMgr.Good(na)
 		}
+		peer.connectedAt = time.Now()
+		if isOutbound {
+			cmgr.PeerRecords.RecordSuccess(na, peer.serviceFlags, peer.StartingBlockHeight(), peer.connectedAt)
+		}
 
 		// We connected to the peer and it passed its version negotiation.
 		// Handle the next steps in the main loop.
@@ -494,6 +823,19 @@ func (cmgr *ConnectionManager) _initiateOutboundConnections() {
 				glog.Error(errors.Errorf("Couldn't connect to IP %v: %v", connectIp, err))
 				continue
 			}
+			// Remember onion addresses so that we can dial them.
+			if addrmgr.IsOnionCatTor(ipNetAddr) {
+				onionAddr, err := ParseOnionAddr(strings.Split(connectIp, ":")[0])
+				if err != nil {
+					glog.Error(errors.Errorf("Couldn't connect to onion address %v: %v", connectIp, err))
+					continue
+				}
+				if cmgr.AddOnionAddr(onionAddr, ipNetAddr.Port, 0) == nil {
+					glog.Error(errors.Errorf("Couldn't connect to onion address %v: It conflicts "+
+						"with an onion address we already know", connectIp))
+					continue
+				}
+			}
 
 			go func(na *wire.NetAddress) {
 				cmgr.ConnectPeer(nil, na)
@@ -578,6 +920,15 @@ func (cmgr *ConnectionManager) _handleInboundConnections() {
 					continue
 				}
 
+				// Reject peers from banned IPs before doing any work for them.
+				if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && cmgr.isBanned(tcpAddr.IP) {
+					glog.V(1).Infof("Rejecting INBOUND peer (%s) because its IP is banned.",
+						conn.RemoteAddr().String())
+					conn.Close()
+
+					continue
+				}
+
 				// As a quick check, reject the peer if we have too many already. Note that
 				// this check isn't perfect but we have a later check at the end after doing
 				// a version negotiation that will properly reject the peer if this check
@@ -801,6 +1152,23 @@ func (cmgr *ConnectionManager) Stop() {
 	for _, listener := range cmgr.listeners {
 		_ = listener.Close()
 	}
+
+	if err := cmgr.PeerRecords.Save(); err != nil {
+		glog.Errorf("ConnectionManager.Stop: Problem saving peer records: %v", err)
+	}
+}
+
+// _startPeerRecordsSaver persists the peer records at regular intervals until we shut down.
+func (cmgr *ConnectionManager) _startPeerRecordsSaver() {
+	if cmgr.PeerRecords == nil {
+		return
+	}
+	for atomic.LoadInt32(&cmgr.shutdown) == 0 {
+		time.Sleep(PeerRecordsSaveInterval)
+		if err := cmgr.PeerRecords.Save(); err != nil {
+			glog.Errorf("ConnectionManager._startPeerRecordsSaver: Problem saving peer records: %v", err)
+		}
+	}
 }
 
 func (cmgr *ConnectionManager) Start() {
@@ -830,6 +1198,9 @@ func (cmgr *ConnectionManager) Start() {
 	// Accept inbound connections from peers on our listeners.
 	cmgr._handleInboundConnections()
 
+	// Persist what we learn about our outbound peers.
+	go cmgr._startPeerRecordsSaver()
+
 	glog.Infof("Full node socket initialized")
 
 	for {
@@ -907,6 +1278,12 @@ func (cmgr *ConnectionManager) Start() {
 
 				glog.V(1).Infof("Done with peer (%v).", pp)
 
+				// An outbound peer that doesn't stay connected for long is flaky.
+				if pp.isOutbound && atomic.LoadInt32(&cmgr.shutdown) == 0 &&
+					time.Since(pp.connectedAt) < PeerRecordMinGoodConnection {
+					cmgr.PeerRecords.RecordFailure(pp.netAddr)
+				}
+
 				if !pp.PeerManuallyRemovedFromConnectionManager {
 					// Remove the peer from our data structures.
 					cmgr.RemovePeer(pp)
@@ -1,15 +1,35 @@
 package lib
 
 import (
-	"github.com/dgraph-io/badger/v3"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/holiman/uint256"
+	"github.com/pkg/errors"
 )
 
+// DbAdapter performs the reads of the UtxoView against whichever db backs the node. Badger
+// is the reference: every Postgres read returns exactly what the badger read returns for
+// the same state. Some records are always flushed to badger, even in Postgres mode, and
+// are always read from badger.
+//
+// A failed Postgres read must never look like a missing entry. Getters that return an error
+// return it, and the others panic through _panicOnPostgresError.
 type DbAdapter struct {
-	badgerDb   *badger.DB
+	badgerDb   KVStore
 	postgresDb *Postgres
 	snapshot   *Snapshot
 }
 
+// _panicOnPostgresError is called by the getters that can't return an error, which would otherwise turn a
+// Postgres failure into a missing entry and connect blocks against the wrong state. Not finding a row
+// isn't an error.
+func _panicOnPostgresError(err error, context string) {
+	if err != nil {
+		panic(errors.Wrapf(err, "DbAdapter.%v: Problem reading from Postgres", context))
+	}
+}
+
 func (bav *UtxoView) GetDbAdapter() *DbAdapter {
 	snap := bav.Snapshot
 	if bav.Postgres != nil {
@@ -22,6 +42,110 @@ func (bav *UtxoView) GetDbAdapter() *DbAdapter {
 	}
 }
 
+//
+// Utxos and DeSo balances
+//
+
+func (adapter *DbAdapter) GetUtxoEntryForUtxoKey(utxoKey *UtxoKey) *UtxoEntry {
+	if adapter.postgresDb != nil {
+		utxoEntry, err := adapter.postgresDb.GetUtxoEntryForUtxoKey(utxoKey)
+		_panicOnPostgresError(err, "GetUtxoEntryForUtxoKey")
+		return utxoEntry
+	}
+
+	return DbGetUtxoEntryForUtxoKey(adapter.badgerDb, adapter.snapshot, utxoKey)
+}
+
+func (adapter *DbAdapter) GetUtxoEntriesForPublicKey(publicKey []byte) ([]*UtxoEntry, error) {
+	if adapter.postgresDb != nil {
+		return adapter.postgresDb.GetUtxoEntriesForPublicKey(publicKey)
+	}
+
+	return DbGetUtxosForPubKey(publicKey, adapter.badgerDb, adapter.snapshot)
+}
+
+func (adapter *DbAdapter) GetDeSoBalanceNanosForPublicKey(publicKey []byte) (uint64, error) {
+	if adapter.postgresDb != nil {
+		return adapter.postgresDb.GetBalance(NewPublicKey(publicKey))
+	}
+
+	return DbGetDeSoBalanceNanosForPublicKey(adapter.badgerDb, adapter.snapshot, publicKey)
+}
+
+// GetImmatureBlockRewardsForPublicKey returns the block rewards paid to a public key in the
+// numImmatureBlocks blocks up to the tip.
+func (adapter *DbAdapter) GetImmatureBlockRewardsForPublicKey(publicKey []byte, tipHash *BlockHash,
+	tipHeight uint32, numImmatureBlocks uint32) (uint64, error) {
+
+	immatureBlockRewards := uint64(0)
+	if adapter.postgresDb != nil {
+		// Filter out immature block rewards in postgres. UtxoType needs to be set correctly when importing blocks
+		outputs, err := adapter.postgresDb.GetBlockRewardsForPublicKey(
+			NewPublicKey(publicKey), tipHeight-numImmatureBlocks, tipHeight)
+		if err != nil {
+			return 0, errors.Wrapf(err, "GetImmatureBlockRewardsForPublicKey: Problem reading from Postgres")
+		}
+		for _, output := range outputs {
+			immatureBlockRewards += output.AmountNanos
+		}
+		return immatureBlockRewards, nil
+	}
+
+	nextBlockHash := tipHash
+	for ii := uint64(1); ii < uint64(numImmatureBlocks); ii++ {
+		// Don't look up the genesis block since it isn't in the DB.
+		if GenesisBlockHashHex == nextBlockHash.String() {
+			break
+		}
+
+		blockNode := GetHeightHashToNodeInfo(adapter.badgerDb, adapter.snapshot, tipHeight, nextBlockHash, false)
+		if blockNode == nil {
+			return 0, fmt.Errorf("GetImmatureBlockRewardsForPublicKey: Problem getting block for blockhash %s",
+				nextBlockHash.String())
+		}
+		blockRewardForPK, err := DbGetBlockRewardForPublicKeyBlockHash(
+			adapter.badgerDb, adapter.snapshot, publicKey, nextBlockHash)
+		if err != nil {
+			return 0, errors.Wrapf(err, "GetImmatureBlockRewardsForPublicKey: Problem getting block "+
+				"reward for blockhash %s", nextBlockHash.String())
+		}
+		immatureBlockRewards += blockRewardForPK
+		if blockNode.Parent != nil {
+			nextBlockHash = blockNode.Parent.Hash
+		} else {
+			nextBlockHash = GenesisBlockHash
+		}
+	}
+	return immatureBlockRewards, nil
+}
+
+//
+// Chain-wide state
+//
+// The utxo count, the bitcoin exchange data and the global params are always flushed to
+// badger, so they're read from badger in both modes.
+//
+
+func (adapter *DbAdapter) GetUtxoNumEntries() uint64 {
+	return GetUtxoNumEntries(adapter.badgerDb, adapter.snapshot)
+}
+
+func (adapter *DbAdapter) GetNanosPurchased() uint64 {
+	return DbGetNanosPurchased(adapter.badgerDb, adapter.snapshot)
+}
+
+func (adapter *DbAdapter) GetUSDCentsPerBitcoinExchangeRate() uint64 {
+	return DbGetUSDCentsPerBitcoinExchangeRate(adapter.badgerDb, adapter.snapshot)
+}
+
+func (adapter *DbAdapter) GetGlobalParamsEntry() *GlobalParamsEntry {
+	return DbGetGlobalParamsEntry(adapter.badgerDb, adapter.snapshot)
+}
+
+func (adapter *DbAdapter) ExistsBitcoinBurnTxID(bitcoinBurnTxID *BlockHash) bool {
+	return DbExistsBitcoinBurnTxID(adapter.badgerDb, adapter.snapshot, bitcoinBurnTxID)
+}
+
 //
 // Balance entry
 //
@@ -29,15 +153,105 @@ func (bav *UtxoView) GetDbAdapter() *DbAdapter {
 func (adapter *DbAdapter) GetBalanceEntry(holder *PKID, creator *PKID, isDAOCoin bool) *BalanceEntry {
 	if adapter.postgresDb != nil {
 		if isDAOCoin {
-			return adapter.postgresDb.GetDAOCoinBalance(holder, creator).NewBalanceEntry()
+			balance, err := adapter.postgresDb.GetDAOCoinBalance(holder, creator)
+			_panicOnPostgresError(err, "GetBalanceEntry")
+			return balance.NewBalanceEntry()
 		}
 
-		return adapter.postgresDb.GetCreatorCoinBalance(holder, creator).NewBalanceEntry()
+		balance, err := adapter.postgresDb.GetCreatorCoinBalance(holder, creator)
+		_panicOnPostgresError(err, "GetBalanceEntry")
+		return balance.NewBalanceEntry()
 	}
 
 	return DbGetBalanceEntry(adapter.badgerDb, adapter.snapshot, holder, creator, isDAOCoin)
 }
 
+// GetBalanceEntryForHODLerAndCreatorPKIDs returns an empty BalanceEntry rather than nil when the
+// hodler has never held the creator's coins.
+func (adapter *DbAdapter) GetBalanceEntryForHODLerAndCreatorPKIDs(hodlerPKID *PKID, creatorPKID *PKID,
+	isDAOCoin bool) *BalanceEntry {
+
+	if adapter.postgresDb != nil {
+		balanceEntry := adapter.GetBalanceEntry(hodlerPKID, creatorPKID, isDAOCoin)
+		if balanceEntry == nil {
+			balanceEntry = _newEmptyBalanceEntry(hodlerPKID, creatorPKID)
+		}
+		return balanceEntry
+	}
+
+	return DBGetBalanceEntryForHODLerAndCreatorPKIDs(
+		adapter.badgerDb, adapter.snapshot, hodlerPKID, creatorPKID, isDAOCoin)
+}
+
+// GetBalanceEntriesYouHold returns the non-zero balances held by pkid.
+func (adapter *DbAdapter) GetBalanceEntriesYouHold(pkid *PKID, isDAOCoin bool) ([]*BalanceEntry, error) {
+	if adapter.postgresDb != nil {
+		balanceEntries := []*BalanceEntry{}
+		if isDAOCoin {
+			balances, err := adapter.postgresDb.GetDAOCoinHoldings(pkid)
+			if err != nil {
+				return nil, errors.Wrapf(err, "GetBalanceEntriesYouHold: Problem reading from Postgres")
+			}
+			for _, balance := range balances {
+				balanceEntries = _appendNonZeroBalanceEntry(balanceEntries, balance.NewBalanceEntry())
+			}
+		} else {
+			balances, err := adapter.postgresDb.GetCreatorCoinHoldings(pkid)
+			if err != nil {
+				return nil, errors.Wrapf(err, "GetBalanceEntriesYouHold: Problem reading from Postgres")
+			}
+			for _, balance := range balances {
+				balanceEntries = _appendNonZeroBalanceEntry(balanceEntries, balance.NewBalanceEntry())
+			}
+		}
+		return balanceEntries, nil
+	}
+
+	return DbGetBalanceEntriesYouHold(adapter.badgerDb, adapter.snapshot, pkid, true, isDAOCoin)
+}
+
+// GetBalanceEntriesHodlingYou returns the non-zero balances of pkid's coins.
+func (adapter *DbAdapter) GetBalanceEntriesHodlingYou(pkid *PKID, isDAOCoin bool) ([]*BalanceEntry, error) {
+	if adapter.postgresDb != nil {
+		balanceEntries := []*BalanceEntry{}
+		if isDAOCoin {
+			balances, err := adapter.postgresDb.GetDAOCoinHolders(pkid)
+			if err != nil {
+				return nil, errors.Wrapf(err, "GetBalanceEntriesHodlingYou: Problem reading from Postgres")
+			}
+			for _, balance := range balances {
+				balanceEntries = _appendNonZeroBalanceEntry(balanceEntries, balance.NewBalanceEntry())
+			}
+		} else {
+			balances, err := adapter.postgresDb.GetCreatorCoinHolders(pkid)
+			if err != nil {
+				return nil, errors.Wrapf(err, "GetBalanceEntriesHodlingYou: Problem reading from Postgres")
+			}
+			for _, balance := range balances {
+				balanceEntries = _appendNonZeroBalanceEntry(balanceEntries, balance.NewBalanceEntry())
+			}
+		}
+		return balanceEntries, nil
+	}
+
+	return DbGetBalanceEntriesHodlingYou(adapter.badgerDb, adapter.snapshot, pkid, true, isDAOCoin)
+}
+
+func _newEmptyBalanceEntry(hodlerPKID *PKID, creatorPKID *PKID) *BalanceEntry {
+	return &BalanceEntry{
+		HODLerPKID:   hodlerPKID.NewPKID(),
+		CreatorPKID:  creatorPKID.NewPKID(),
+		BalanceNanos: *uint256.NewInt(),
+	}
+}
+
+func _appendNonZeroBalanceEntry(balanceEntries []*BalanceEntry, balanceEntry *BalanceEntry) []*BalanceEntry {
+	if balanceEntry == nil || balanceEntry.BalanceNanos.IsZero() {
+		return balanceEntries
+	}
+	return append(balanceEntries, balanceEntry)
+}
+
 //
 // DAO coin limit order
 //
@@ -83,7 +297,7 @@ func (adapter *DbAdapter) GetMatchingDAOCoinLimitOrders(inputOrder *DAOCoinLimit
 	var outputOrders []*DAOCoinLimitOrderEntry
 	var err error
 
-	err = adapter.badgerDb.View(func(txn *badger.Txn) error {
+	err = adapter.badgerDb.View(func(txn KVTxn) error {
 		outputOrders, err = DBGetMatchingDAOCoinLimitOrders(txn, inputOrder, lastSeenOrder)
 		return err
 	})
@@ -92,17 +306,698 @@ func (adapter *DbAdapter) GetMatchingDAOCoinLimitOrders(inputOrder *DAOCoinLimit
 }
 
 //
-// PKID
+// PKIDs and profiles
 //
 
 func (adapter *DbAdapter) GetPKIDForPublicKey(pkBytes []byte) *PKID {
+	pkidEntry := adapter.GetPKIDEntryForPublicKey(pkBytes)
+	if pkidEntry == nil {
+		return nil
+	}
+	return pkidEntry.PKID
+}
+
+// GetPKIDEntryForPublicKey returns the public key itself as the PKID if the public key has no PKID mapping.
+func (adapter *DbAdapter) GetPKIDEntryForPublicKey(publicKey []byte) *PKIDEntry {
 	if adapter.postgresDb != nil {
-		profile := adapter.postgresDb.GetProfileForPublicKey(pkBytes)
+		if len(publicKey) == 0 {
+			return nil
+		}
+		// Postgres has no PKID mappings. The profiles, including the empty ones saved by swap
+		// identity, hold them instead.
+		profile, err := adapter.postgresDb.GetProfileForPublicKey(publicKey)
+		_panicOnPostgresError(err, "GetPKIDEntryForPublicKey")
 		if profile == nil {
-			return NewPKID(pkBytes)
+			return &PKIDEntry{
+				PKID:      PublicKeyToPKID(publicKey),
+				PublicKey: publicKey,
+			}
+		}
+		return &PKIDEntry{
+			PKID:      profile.PKID,
+			PublicKey: profile.PublicKey.ToBytes(),
 		}
-		return profile.PKID
 	}
 
-	return DBGetPKIDEntryForPublicKey(adapter.badgerDb, adapter.snapshot, pkBytes).PKID
+	return DBGetPKIDEntryForPublicKey(adapter.badgerDb, adapter.snapshot, publicKey)
+}
+
+// GetPublicKeyForPKID returns the PKID itself as the public key if the PKID has no public key mapping.
+func (adapter *DbAdapter) GetPublicKeyForPKID(pkid *PKID) []byte {
+	if adapter.postgresDb != nil {
+		profile, err := adapter.postgresDb.GetProfile(*pkid)
+		_panicOnPostgresError(err, "GetPublicKeyForPKID")
+		if profile == nil {
+			return pkid.NewPKID()[:]
+		}
+		return profile.PublicKey.ToBytes()
+	}
+
+	return DBGetPublicKeyForPKID(adapter.badgerDb, adapter.snapshot, pkid)
+}
+
+func (adapter *DbAdapter) GetProfileEntryForPKID(pkid *PKID) *ProfileEntry {
+	if adapter.postgresDb != nil {
+		profile, err := adapter.postgresDb.GetProfile(*pkid)
+		_panicOnPostgresError(err, "GetProfileEntryForPKID")
+		if profile == nil || profile.Empty() {
+			return nil
+		}
+		return profile.NewProfileEntry()
+	}
+
+	return DBGetProfileEntryForPKID(adapter.badgerDb, adapter.snapshot, pkid)
+}
+
+// GetProfileEntryForUsername looks the username up case-insensitively.
+func (adapter *DbAdapter) GetProfileEntryForUsername(nonLowercaseUsername []byte) *ProfileEntry {
+	if adapter.postgresDb != nil {
+		profile, err := adapter.postgresDb.GetProfileForUsername(string(nonLowercaseUsername))
+		_panicOnPostgresError(err, "GetProfileEntryForUsername")
+		if profile == nil || profile.Empty() {
+			return nil
+		}
+		return profile.NewProfileEntry()
+	}
+
+	return DBGetProfileEntryForUsername(adapter.badgerDb, adapter.snapshot, nonLowercaseUsername)
+}
+
+func (adapter *DbAdapter) GetAllProfileEntries() ([]*ProfileEntry, error) {
+	if adapter.postgresDb != nil {
+		var profileEntries []*ProfileEntry
+		profiles, err := adapter.postgresDb.GetAllProfiles()
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetAllProfileEntries: Problem reading from Postgres")
+		}
+		for _, profile := range profiles {
+			profileEntries = append(profileEntries, profile.NewProfileEntry())
+		}
+		return profileEntries, nil
+	}
+
+	_, _, profileEntries, err := DBGetAllProfilesByCoinValue(adapter.badgerDb, adapter.snapshot, true)
+	return profileEntries, err
+}
+
+//
+// Derived keys
+//
+
+func (adapter *DbAdapter) GetDerivedKeyEntry(ownerPublicKey PublicKey, derivedPublicKey PublicKey) *DerivedKeyEntry {
+	if adapter.postgresDb != nil {
+		derivedKey, err := adapter.postgresDb.GetDerivedKey(&ownerPublicKey, &derivedPublicKey)
+		_panicOnPostgresError(err, "GetDerivedKeyEntry")
+		if derivedKey == nil {
+			return nil
+		}
+		return derivedKey.NewDerivedKeyEntry()
+	}
+
+	return DBGetOwnerToDerivedKeyMapping(adapter.badgerDb, adapter.snapshot, ownerPublicKey, derivedPublicKey)
+}
+
+func (adapter *DbAdapter) GetAllDerivedKeyEntriesForOwner(ownerPublicKey PublicKey) ([]*DerivedKeyEntry, error) {
+	if adapter.postgresDb != nil {
+		var derivedKeyEntries []*DerivedKeyEntry
+		derivedKeys, err := adapter.postgresDb.GetAllDerivedKeysForOwner(&ownerPublicKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetAllDerivedKeyEntriesForOwner: Problem reading from Postgres")
+		}
+		for _, derivedKey := range derivedKeys {
+			derivedKeyEntries = append(derivedKeyEntries, derivedKey.NewDerivedKeyEntry())
+		}
+		return derivedKeyEntries, nil
+	}
+
+	return DBGetAllOwnerToDerivedKeyMappings(adapter.badgerDb, ownerPublicKey)
+}
+
+//
+// Posts
+//
+
+func (adapter *DbAdapter) GetPostEntry(postHash *BlockHash) *PostEntry {
+	if adapter.postgresDb != nil {
+		post, err := adapter.postgresDb.GetPost(postHash)
+		_panicOnPostgresError(err, "GetPostEntry")
+		if post == nil {
+			return nil
+		}
+		return post.NewPostEntry()
+	}
+
+	return DBGetPostEntryByPostHash(adapter.badgerDb, adapter.snapshot, postHash)
+}
+
+// GetAllCorePostEntries returns all the posts that aren't comments.
+func (adapter *DbAdapter) GetAllCorePostEntries() ([]*PostEntry, error) {
+	if adapter.postgresDb != nil {
+		var postEntries []*PostEntry
+		posts, err := adapter.postgresDb.GetAllCorePosts()
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetAllCorePostEntries: Problem reading from Postgres")
+		}
+		for _, post := range posts {
+			postEntries = append(postEntries, post.NewPostEntry())
+		}
+		return postEntries, nil
+	}
+
+	_, _, postEntries, err := DBGetAllPostsByTstamp(adapter.badgerDb, adapter.snapshot, true)
+	return postEntries, err
+}
+
+// GetCommentPostHashesForParentStakeID returns the hashes of all the comments if parentStakeID is empty.
+func (adapter *DbAdapter) GetCommentPostHashesForParentStakeID(parentStakeID []byte) ([]*BlockHash, error) {
+	if adapter.postgresDb != nil {
+		var comments []*PGPost
+		var err error
+		if len(parentStakeID) == 0 {
+			comments, err = adapter.postgresDb.GetAllComments()
+		} else {
+			comments, err = adapter.postgresDb.GetComments(NewBlockHash(parentStakeID))
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetCommentPostHashesForParentStakeID: Problem reading from Postgres")
+		}
+		var commentHashes []*BlockHash
+		for _, comment := range comments {
+			commentHashes = append(commentHashes, comment.PostHash)
+		}
+		return commentHashes, nil
+	}
+
+	_, commentHashes, _, err := DBGetCommentPostHashesForParentStakeID(
+		adapter.badgerDb, adapter.snapshot, parentStakeID, false)
+	return commentHashes, err
+}
+
+// IteratePostHashesForPublicKeyByTimestamp calls fn with the hashes of the core posts made by a public key,
+// newest first, starting after startPostEntry if it's set. The iteration stops as soon as fn returns false
+// or an error.
+func (adapter *DbAdapter) IteratePostHashesForPublicKeyByTimestamp(publicKey []byte, startPostEntry *PostEntry,
+	fn func(postHash *BlockHash) (_continue bool, _err error)) error {
+
+	if adapter.postgresDb != nil {
+		batchSize := 100
+		for {
+			posts, err := adapter.postgresDb.GetPostKeysForPublicKeyByTimestamp(publicKey, startPostEntry, batchSize)
+			if err != nil {
+				return errors.Wrapf(err, "IteratePostHashesForPublicKeyByTimestamp: Problem reading from Postgres")
+			}
+			for _, post := range posts {
+				if shouldContinue, err := fn(post.PostHash); err != nil || !shouldContinue {
+					return err
+				}
+			}
+			if len(posts) < batchSize {
+				return nil
+			}
+			lastPost := posts[len(posts)-1]
+			startPostEntry = &PostEntry{
+				PostHash:       lastPost.PostHash,
+				TimestampNanos: lastPost.Timestamp,
+			}
+		}
+	}
+
+	dbPrefix := append([]byte{}, Prefixes.PrefixPosterPublicKeyTimestampPostHash...)
+	dbPrefix = append(dbPrefix, publicKey...)
+	var prefix []byte
+	if startPostEntry != nil {
+		prefix = append(dbPrefix, EncodeUint64(startPostEntry.TimestampNanos)...)
+		prefix = append(prefix, startPostEntry.PostHash[:]...)
+	} else {
+		maxBigEndianUint64Bytes := []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+		prefix = append(dbPrefix, maxBigEndianUint64Bytes...)
+	}
+	timestampSizeBytes := 8
+	return adapter.badgerDb.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
+
+		opts.PrefetchValues = false
+
+		// Go in reverse order
+		opts.Reverse = true
+
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Seek(prefix)
+		if startPostEntry != nil {
+			// Skip the first post if we have a startPostEntry.
+			it.Next()
+		}
+		for ; it.ValidForPrefix(dbPrefix); it.Next() {
+			rawKey := it.Item().Key()
+
+			keyWithoutPrefix := rawKey[1:]
+			publicKeySizeBytes := HashSizeBytes + 1
+
+			postHash := &BlockHash{}
+			copy(postHash[:], keyWithoutPrefix[(publicKeySizeBytes+timestampSizeBytes):])
+			if shouldContinue, err := fn(postHash); err != nil || !shouldContinue {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (adapter *DbAdapter) GetQuoteRepostPostHashesForPostHash(postHash *BlockHash) ([]*BlockHash, error) {
+	if adapter.postgresDb != nil {
+		var repostPostHashes []*BlockHash
+		posts, err := adapter.postgresDb.GetReposts(postHash, true)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetQuoteRepostPostHashesForPostHash: Problem reading from Postgres")
+		}
+		for _, post := range posts {
+			repostPostHashes = append(repostPostHashes, post.PostHash)
+		}
+		return repostPostHashes, nil
+	}
+
+	dbPrefix := append([]byte{}, Prefixes.PrefixRepostedPostHashReposterPubKeyRepostPostHash...)
+	dbPrefix = append(dbPrefix, postHash[:]...)
+	keysFound, _ := EnumerateKeysForPrefix(adapter.badgerDb, dbPrefix)
+
+	expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed + HashSizeBytes
+	repostPostHashIdx := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed
+	var repostPostHashes []*BlockHash
+	for _, key := range keysFound {
+		// Sanity check that this is a reasonable key.
+		if len(key) != expectedKeyLength {
+			return nil, fmt.Errorf("GetQuoteRepostPostHashesForPostHash: Invalid key length found: %d", len(key))
+		}
+
+		repostPostHash := &BlockHash{}
+		copy(repostPostHash[:], key[repostPostHashIdx:])
+		repostPostHashes = append(repostPostHashes, repostPostHash)
+	}
+	return repostPostHashes, nil
+}
+
+//
+// Reposts
+//
+// Repost entries are always flushed to badger. The index of reposters by reposted post
+// is written with the posts though, so Postgres reads it from the posts table.
+//
+
+func (adapter *DbAdapter) GetRepostEntry(reposterPublicKey []byte, repostedPostHash BlockHash) *RepostEntry {
+	return DbReposterPubKeyRepostedPostHashToRepostEntry(
+		adapter.badgerDb, adapter.snapshot, reposterPublicKey, repostedPostHash)
+}
+
+func (adapter *DbAdapter) GetReposterPublicKeysForPostHash(postHash *BlockHash) ([][]byte, error) {
+	if adapter.postgresDb != nil {
+		var reposterPublicKeys [][]byte
+		posts, err := adapter.postgresDb.GetReposts(postHash, false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetReposterPublicKeysForPostHash: Problem reading from Postgres")
+		}
+		for _, post := range posts {
+			reposterPublicKeys = append(reposterPublicKeys, post.PosterPublicKey)
+		}
+		return reposterPublicKeys, nil
+	}
+
+	dbPrefix := append([]byte{}, Prefixes.PrefixRepostedPostHashReposterPubKey...)
+	dbPrefix = append(dbPrefix, postHash[:]...)
+	keysFound, _ := EnumerateKeysForPrefix(adapter.badgerDb, dbPrefix)
+
+	expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed
+	var reposterPublicKeys [][]byte
+	for _, key := range keysFound {
+		// Sanity check that this is a reasonable key.
+		if len(key) != expectedKeyLength {
+			return nil, fmt.Errorf("GetReposterPublicKeysForPostHash: Invalid key length found: %d", len(key))
+		}
+		reposterPublicKeys = append(reposterPublicKeys, key[1+HashSizeBytes:])
+	}
+	return reposterPublicKeys, nil
+}
+
+//
+// Diamonds
+//
+
+func (adapter *DbAdapter) GetDiamondEntry(senderPKID *PKID, receiverPKID *PKID, postHash *BlockHash) *DiamondEntry {
+	if adapter.postgresDb != nil {
+		diamond, err := adapter.postgresDb.GetDiamond(senderPKID, receiverPKID, postHash)
+		_panicOnPostgresError(err, "GetDiamondEntry")
+		if diamond == nil {
+			return nil
+		}
+		return diamond.NewDiamondEntry()
+	}
+
+	return DbGetDiamondMappings(adapter.badgerDb, adapter.snapshot, receiverPKID, senderPKID, postHash)
+}
+
+// GetDiamondEntriesForPKID returns the diamonds pkid gave if fetchYouDiamonded is set, and the
+// diamonds pkid received otherwise.
+func (adapter *DbAdapter) GetDiamondEntriesForPKID(pkid *PKID, fetchYouDiamonded bool) ([]*DiamondEntry, error) {
+	if adapter.postgresDb != nil {
+		var diamonds []*PGDiamond
+		var err error
+		if fetchYouDiamonded {
+			diamonds, err = adapter.postgresDb.GetDiamondsForSender(pkid)
+		} else {
+			diamonds, err = adapter.postgresDb.GetDiamondsForReceiver(pkid)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetDiamondEntriesForPKID: Problem reading from Postgres")
+		}
+		var diamondEntries []*DiamondEntry
+		for _, diamond := range diamonds {
+			diamondEntries = append(diamondEntries, diamond.NewDiamondEntry())
+		}
+		return diamondEntries, nil
+	}
+
+	pkidToDiamondsMap, err := DbGetPKIDsThatDiamondedYouMap(adapter.badgerDb, pkid, fetchYouDiamonded)
+	if err != nil {
+		return nil, err
+	}
+	var diamondEntries []*DiamondEntry
+	for _, diamondEntryList := range pkidToDiamondsMap {
+		diamondEntries = append(diamondEntries, diamondEntryList...)
+	}
+	return diamondEntries, nil
+}
+
+func (adapter *DbAdapter) GetDiamondEntriesForSenderToReceiver(receiverPKID *PKID, senderPKID *PKID,
+) ([]*DiamondEntry, error) {
+
+	if adapter.postgresDb != nil {
+		var diamondEntries []*DiamondEntry
+		diamonds, err := adapter.postgresDb.GetDiamondsForSenderToReceiver(senderPKID, receiverPKID)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetDiamondEntriesForSenderToReceiver: Problem reading from Postgres")
+		}
+		for _, diamond := range diamonds {
+			diamondEntries = append(diamondEntries, diamond.NewDiamondEntry())
+		}
+		return diamondEntries, nil
+	}
+
+	return DbGetDiamondEntriesForSenderToReceiver(adapter.badgerDb, receiverPKID, senderPKID)
+}
+
+func (adapter *DbAdapter) GetDiamondSenderPKIDsForPostHash(postHash *BlockHash) ([]*PKID, error) {
+	if adapter.postgresDb != nil {
+		var senderPKIDs []*PKID
+		diamonds, err := adapter.postgresDb.GetDiamondsForPost(postHash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetDiamondSenderPKIDsForPostHash: Problem reading from Postgres")
+		}
+		for _, diamond := range diamonds {
+			senderPKIDs = append(senderPKIDs, diamond.SenderPKID)
+		}
+		return senderPKIDs, nil
+	}
+
+	dbPrefix := append([]byte{}, Prefixes.PrefixDiamondedPostHashDiamonderPKIDDiamondLevel...)
+	dbPrefix = append(dbPrefix, postHash[:]...)
+	keysFound, _ := EnumerateKeysForPrefix(adapter.badgerDb, dbPrefix)
+
+	expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed + 8
+	var senderPKIDs []*PKID
+	for _, key := range keysFound {
+		// Sanity check that this is a reasonable key.
+		if len(key) != expectedKeyLength {
+			return nil, fmt.Errorf("GetDiamondSenderPKIDsForPostHash: Invalid key length found: %d", len(key))
+		}
+
+		senderPKID := &PKID{}
+		copy(senderPKID[:], key[1+HashSizeBytes:])
+		senderPKIDs = append(senderPKIDs, senderPKID)
+	}
+	return senderPKIDs, nil
+}
+
+//
+// Likes
+//
+
+func (adapter *DbAdapter) GetLikeExists(likerPublicKey []byte, likedPostHash *BlockHash) bool {
+	if adapter.postgresDb != nil {
+		like, err := adapter.postgresDb.GetLike(likerPublicKey, likedPostHash)
+		_panicOnPostgresError(err, "GetLikeExists")
+		return like != nil
+	}
+
+	return DbGetLikerPubKeyToLikedPostHashMapping(
+		adapter.badgerDb, adapter.snapshot, likerPublicKey, *likedPostHash) != nil
+}
+
+func (adapter *DbAdapter) GetLikerPublicKeysForPostHash(postHash *BlockHash) ([][]byte, error) {
+	if adapter.postgresDb != nil {
+		var likerPublicKeys [][]byte
+		likes, err := adapter.postgresDb.GetLikesForPost(postHash)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetLikerPublicKeysForPostHash: Problem reading from Postgres")
+		}
+		for _, like := range likes {
+			likerPublicKeys = append(likerPublicKeys, like.LikerPublicKey)
+		}
+		return likerPublicKeys, nil
+	}
+
+	dbPrefix := append([]byte{}, Prefixes.PrefixLikedPostHashToLikerPubKey...)
+	dbPrefix = append(dbPrefix, postHash[:]...)
+	keysFound, _ := EnumerateKeysForPrefix(adapter.badgerDb, dbPrefix)
+
+	expectedKeyLength := 1 + HashSizeBytes + btcec.PubKeyBytesLenCompressed
+	var likerPublicKeys [][]byte
+	for _, key := range keysFound {
+		// Sanity check that this is a reasonable key.
+		if len(key) != expectedKeyLength {
+			return nil, fmt.Errorf("GetLikerPublicKeysForPostHash: Invalid key length found: %d", len(key))
+		}
+		likerPublicKeys = append(likerPublicKeys, key[1+HashSizeBytes:])
+	}
+	return likerPublicKeys, nil
+}
+
+//
+// Follows
+//
+
+func (adapter *DbAdapter) GetFollowExists(followerPKID *PKID, followedPKID *PKID) bool {
+	if adapter.postgresDb != nil {
+		follow, err := adapter.postgresDb.GetFollow(followerPKID, followedPKID)
+		_panicOnPostgresError(err, "GetFollowExists")
+		return follow != nil
+	}
+
+	return DbGetFollowerToFollowedMapping(adapter.badgerDb, adapter.snapshot, followerPKID, followedPKID) != nil
+}
+
+// GetFollowerPKIDs returns the PKIDs that follow pkid.
+func (adapter *DbAdapter) GetFollowerPKIDs(pkid *PKID) ([]*PKID, error) {
+	if adapter.postgresDb != nil {
+		var followerPKIDs []*PKID
+		follows, err := adapter.postgresDb.GetFollowers(pkid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetFollowerPKIDs: Problem reading from Postgres")
+		}
+		for _, follow := range follows {
+			followerPKIDs = append(followerPKIDs, follow.FollowerPKID)
+		}
+		return followerPKIDs, nil
+	}
+
+	return DbGetPKIDsFollowingYou(adapter.badgerDb, pkid)
+}
+
+// GetFollowedPKIDs returns the PKIDs pkid follows.
+func (adapter *DbAdapter) GetFollowedPKIDs(pkid *PKID) ([]*PKID, error) {
+	if adapter.postgresDb != nil {
+		var followedPKIDs []*PKID
+		follows, err := adapter.postgresDb.GetFollowing(pkid)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetFollowedPKIDs: Problem reading from Postgres")
+		}
+		for _, follow := range follows {
+			followedPKIDs = append(followedPKIDs, follow.FollowedPKID)
+		}
+		return followedPKIDs, nil
+	}
+
+	return DbGetPKIDsYouFollow(adapter.badgerDb, pkid)
+}
+
+//
+// Messages
+//
+
+// GetMessageEntry returns the message sent or received by a messaging public key at the given timestamp.
+func (adapter *DbAdapter) GetMessageEntry(messagingPublicKey []byte, tstampNanos uint64) (*MessageEntry, error) {
+	if adapter.postgresDb != nil {
+		message, err := adapter.postgresDb.GetMessageForMessagingKey(messagingPublicKey, tstampNanos)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetMessageEntry: Problem reading from Postgres")
+		}
+		if message == nil {
+			return nil, nil
+		}
+		return message.NewMessageEntry(), nil
+	}
+
+	return DBGetMessageEntry(adapter.badgerDb, adapter.snapshot, messagingPublicKey, tstampNanos), nil
+}
+
+// GetLimitedMessageEntriesForMessagingKeys returns up to limit of the latest messages sent or received by
+// the messaging keys of the groups.
+func (adapter *DbAdapter) GetLimitedMessageEntriesForMessagingKeys(messagingGroupEntries []*MessagingGroupEntry,
+	limit uint64) ([]*MessageEntry, error) {
+
+	if adapter.postgresDb != nil {
+		var messagingPublicKeys []*PublicKey
+		for _, messagingGroupEntry := range messagingGroupEntries {
+			messagingPublicKeys = append(messagingPublicKeys, messagingGroupEntry.MessagingPublicKey)
+		}
+		messageEntries := []*MessageEntry{}
+		messages, err := adapter.postgresDb.GetMessagesForMessagingKeys(messagingPublicKeys, limit)
+		if err != nil {
+			return nil, errors.Wrapf(err, "GetLimitedMessageEntriesForMessagingKeys: Problem reading from Postgres")
+		}
+		for _, message := range messages {
+			messageEntries = append(messageEntries, message.NewMessageEntry())
+		}
+		return messageEntries, nil
+	}
+
+	return DBGetLimitedMessageForMessagingKeys(adapter.badgerDb, messagingGroupEntries, limit)
+}
+
+//
+// Messaging groups
+//
+// Messaging groups are always flushed to badger.
+//
+
+func (adapter *DbAdapter) GetMessagingGroupEntry(messagingGroupKey *MessagingGroupKey) *MessagingGroupEntry {
+	return DBGetMessagingGroupEntry(adapter.badgerDb, adapter.snapshot, messagingGroupKey)
+}
+
+func (adapter *DbAdapter) GetAllUserGroupEntries(ownerPublicKey []byte) ([]*MessagingGroupEntry, error) {
+	return DBGetAllUserGroupEntries(adapter.badgerDb, ownerPublicKey)
+}
+
+//
+// NFTs
+//
+
+func (adapter *DbAdapter) GetNFTEntry(nftPostHash *BlockHash, serialNumber uint64) *NFTEntry {
+	if adapter.postgresDb != nil {
+		nft, err := adapter.postgresDb.GetNFT(nftPostHash, serialNumber)
+		_panicOnPostgresError(err, "GetNFTEntry")
+		if nft == nil {
+			return nil
+		}
+		return nft.NewNFTEntry()
+	}
+
+	return DBGetNFTEntryByPostHashSerialNumber(adapter.badgerDb, adapter.snapshot, nftPostHash, serialNumber)
+}
+
+func (adapter *DbAdapter) GetNFTEntriesForPostHash(nftPostHash *BlockHash) []*NFTEntry {
+	if adapter.postgresDb != nil {
+		nftEntries := []*NFTEntry{}
+		nfts, err := adapter.postgresDb.GetNFTsForPostHash(nftPostHash)
+		_panicOnPostgresError(err, "GetNFTEntriesForPostHash")
+		for _, nft := range nfts {
+			nftEntries = append(nftEntries, nft.NewNFTEntry())
+		}
+		return nftEntries
+	}
+
+	return DBGetNFTEntriesForPostHash(adapter.badgerDb, nftPostHash)
+}
+
+func (adapter *DbAdapter) GetNFTEntriesForPKID(ownerPKID *PKID) []*NFTEntry {
+	if adapter.postgresDb != nil {
+		var nftEntries []*NFTEntry
+		nfts, err := adapter.postgresDb.GetNFTsForPKID(ownerPKID)
+		_panicOnPostgresError(err, "GetNFTEntriesForPKID")
+		for _, nft := range nfts {
+			nftEntries = append(nftEntries, nft.NewNFTEntry())
+		}
+		return nftEntries
+	}
+
+	return DBGetNFTEntriesForPKID(adapter.badgerDb, ownerPKID)
+}
+
+func (adapter *DbAdapter) GetNFTBidEntry(nftBidKey *NFTBidKey) *NFTBidEntry {
+	if adapter.postgresDb != nil {
+		bid, err := adapter.postgresDb.GetNFTBid(&nftBidKey.NFTPostHash, &nftBidKey.BidderPKID, nftBidKey.SerialNumber)
+		_panicOnPostgresError(err, "GetNFTBidEntry")
+		if bid == nil {
+			return nil
+		}
+		return bid.NewNFTBidEntry()
+	}
+
+	return DBGetNFTBidEntryForNFTBidKey(adapter.badgerDb, adapter.snapshot, nftBidKey)
+}
+
+func (adapter *DbAdapter) GetNFTBidEntries(nftPostHash *BlockHash, serialNumber uint64) []*NFTBidEntry {
+	if adapter.postgresDb != nil {
+		nftBidEntries := []*NFTBidEntry{}
+		bids, err := adapter.postgresDb.GetNFTBidsForSerial(nftPostHash, serialNumber)
+		_panicOnPostgresError(err, "GetNFTBidEntries")
+		for _, bid := range bids {
+			nftBidEntries = append(nftBidEntries, bid.NewNFTBidEntry())
+		}
+		return nftBidEntries
+	}
+
+	return DBGetNFTBidEntries(adapter.badgerDb, nftPostHash, serialNumber)
+}
+
+func (adapter *DbAdapter) GetNFTBidEntriesForPKID(bidderPKID *PKID) []*NFTBidEntry {
+	if adapter.postgresDb != nil {
+		nftBidEntries := []*NFTBidEntry{}
+		bids, err := adapter.postgresDb.GetNFTBidsForPKID(bidderPKID)
+		_panicOnPostgresError(err, "GetNFTBidEntriesForPKID")
+		for _, bid := range bids {
+			nftBidEntries = append(nftBidEntries, bid.NewNFTBidEntry())
+		}
+		return nftBidEntries
+	}
+
+	return DBGetNFTBidEntriesForPKID(adapter.badgerDb, bidderPKID)
+}
+
+// GetNFTBidEntriesPaginated returns up to limit bids ordered by bid amount and bidder, starting at startEntry
+// if it's set. The bids only have their key fields set.
+func (adapter *DbAdapter) GetNFTBidEntriesPaginated(nftPostHash *BlockHash, serialNumber uint64,
+	startEntry *NFTBidEntry, limit int, reverse bool) []*NFTBidEntry {
+
+	if adapter.postgresDb != nil {
+		var nftBidEntries []*NFTBidEntry
+		bids, err := adapter.postgresDb.GetNFTBidsPaginated(nftPostHash, serialNumber, startEntry, limit, reverse)
+		_panicOnPostgresError(err, "GetNFTBidEntriesPaginated")
+		for _, bid := range bids {
+			nftBidEntries = append(nftBidEntries, &NFTBidEntry{
+				NFTPostHash:    bid.NFTPostHash,
+				SerialNumber:   bid.SerialNumber,
+				BidAmountNanos: bid.BidAmountNanos,
+				BidderPKID:     bid.BidderPKID,
+			})
+		}
+		return nftBidEntries
+	}
+
+	return DBGetNFTBidEntriesPaginated(adapter.badgerDb, nftPostHash, serialNumber, startEntry, limit, reverse)
+}
+
+// GetAcceptedNFTBidEntries reads the accepted bid history, which is always flushed to badger.
+func (adapter *DbAdapter) GetAcceptedNFTBidEntries(nftPostHash *BlockHash, serialNumber uint64) *[]*NFTBidEntry {
+	return DBGetAcceptedNFTBidEntriesByPostHashSerialNumber(
+		adapter.badgerDb, adapter.snapshot, nftPostHash, serialNumber)
 }
@@ -0,0 +1,310 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var dbAdapterTestPublicKeys = []string{
+	senderPkString, recipientPkString, moneyPkString, paramUpdaterPub,
+	m0Pub, m1Pub, m2Pub, m3Pub, m4Pub, m5Pub, m6Pub,
+}
+
+// _runWithPostgresURI runs testFunc as a subtest with POSTGRES_URI set to postgresURI, so that the chains
+// it creates are backed by Postgres, or by badger alone if postgresURI is empty. It returns the last
+// chain testFunc created.
+func _runWithPostgresURI(t *testing.T, name string, postgresURI string, testFunc func(t *testing.T)) *Blockchain {
+	require := require.New(t)
+
+	oldPostgresURI, hadPostgresURI := os.LookupEnv("POSTGRES_URI")
+	defer func() {
+		if hadPostgresURI {
+			require.NoError(os.Setenv("POSTGRES_URI", oldPostgresURI))
+		} else {
+			require.NoError(os.Unsetenv("POSTGRES_URI"))
+		}
+	}()
+	if len(postgresURI) > 0 {
+		require.NoError(os.Setenv("POSTGRES_URI", postgresURI))
+	} else {
+		require.NoError(os.Unsetenv("POSTGRES_URI"))
+	}
+
+	testLastLowDifficultyChain = nil
+	require.True(t.Run(name, testFunc))
+	require.NotNil(testLastLowDifficultyChain)
+	return testLastLowDifficultyChain
+}
+
+// _dumpDbAdapterState renders everything the DbAdapter reads about the public keys as lines of text.
+// Entries are encoded with EncodeToBytes, and lists the db returns in no particular order are sorted.
+func _dumpDbAdapterState(t *testing.T, chain *Blockchain, publicKeysBase58Check []string) []string {
+	require := require.New(t)
+
+	utxoView, err := NewUtxoView(chain.db, chain.params, chain.postgres, chain.snapshot)
+	require.NoError(err)
+	adapter := utxoView.GetDbAdapter()
+	blockHeight := uint64(chain.blockTip().Height)
+
+	var dump []string
+	encode := func(encoder DeSoEncoder) string {
+		return hex.EncodeToString(EncodeToBytes(blockHeight, encoder))
+	}
+	addSorted := func(label string, values []string) {
+		sort.Strings(values)
+		dump = append(dump, fmt.Sprintf("%s: %v", label, values))
+	}
+
+	dump = append(dump, fmt.Sprintf("global params: %v", encode(adapter.GetGlobalParamsEntry())))
+	dump = append(dump, fmt.Sprintf("nanos purchased: %d", adapter.GetNanosPurchased()))
+
+	for _, publicKeyBase58Check := range publicKeysBase58Check {
+		publicKey, _, err := Base58CheckDecode(publicKeyBase58Check)
+		require.NoError(err)
+		label := func(name string) string {
+			return publicKeyBase58Check + " " + name
+		}
+
+		pkidEntry := adapter.GetPKIDEntryForPublicKey(publicKey)
+		require.NotNil(pkidEntry)
+		pkid := pkidEntry.PKID
+		dump = append(dump, fmt.Sprintf("%s: %v", label("pkid"), encode(pkidEntry)))
+		dump = append(dump, fmt.Sprintf("%s: %v", label("public key for pkid"),
+			hex.EncodeToString(adapter.GetPublicKeyForPKID(pkid))))
+		dump = append(dump, fmt.Sprintf("%s: %v", label("profile"), encode(adapter.GetProfileEntryForPKID(pkid))))
+
+		balanceNanos, err := adapter.GetDeSoBalanceNanosForPublicKey(publicKey)
+		require.NoError(err)
+		dump = append(dump, fmt.Sprintf("%s: %d", label("deso balance"), balanceNanos))
+
+		// Follows
+		followerPKIDs, err := adapter.GetFollowerPKIDs(pkid)
+		require.NoError(err)
+		addSorted(label("followers"), _encodePKIDs(followerPKIDs))
+		followedPKIDs, err := adapter.GetFollowedPKIDs(pkid)
+		require.NoError(err)
+		addSorted(label("followed"), _encodePKIDs(followedPKIDs))
+
+		// Creator coin and DAO coin balances
+		for _, isDAOCoin := range []bool{false, true} {
+			balanceEntriesYouHold, err := adapter.GetBalanceEntriesYouHold(pkid, isDAOCoin)
+			require.NoError(err)
+			var encodedBalanceEntries []string
+			for _, balanceEntry := range balanceEntriesYouHold {
+				encodedBalanceEntries = append(encodedBalanceEntries, encode(balanceEntry))
+			}
+			addSorted(label(fmt.Sprintf("balances held (dao coin: %v)", isDAOCoin)), encodedBalanceEntries)
+
+			balanceEntriesHodlingYou, err := adapter.GetBalanceEntriesHodlingYou(pkid, isDAOCoin)
+			require.NoError(err)
+			encodedBalanceEntries = nil
+			for _, balanceEntry := range balanceEntriesHodlingYou {
+				encodedBalanceEntries = append(encodedBalanceEntries, encode(balanceEntry))
+			}
+			addSorted(label(fmt.Sprintf("balances of holders (dao coin: %v)", isDAOCoin)), encodedBalanceEntries)
+		}
+
+		// Diamonds
+		for _, fetchYouDiamonded := range []bool{false, true} {
+			diamondEntries, err := adapter.GetDiamondEntriesForPKID(pkid, fetchYouDiamonded)
+			require.NoError(err)
+			var encodedDiamondEntries []string
+			for _, diamondEntry := range diamondEntries {
+				encodedDiamondEntries = append(encodedDiamondEntries, encode(diamondEntry))
+			}
+			addSorted(label(fmt.Sprintf("diamonds (given: %v)", fetchYouDiamonded)), encodedDiamondEntries)
+		}
+
+		// NFTs
+		var encodedNFTEntries []string
+		for _, nftEntry := range adapter.GetNFTEntriesForPKID(pkid) {
+			encodedNFTEntries = append(encodedNFTEntries, encode(nftEntry))
+		}
+		addSorted(label("nfts owned"), encodedNFTEntries)
+		var encodedNFTBidEntries []string
+		for _, nftBidEntry := range adapter.GetNFTBidEntriesForPKID(pkid) {
+			encodedNFTBidEntries = append(encodedNFTBidEntries, encode(nftBidEntry))
+		}
+		addSorted(label("nft bids"), encodedNFTBidEntries)
+
+		// Derived keys
+		derivedKeyEntries, err := adapter.GetAllDerivedKeyEntriesForOwner(*NewPublicKey(publicKey))
+		require.NoError(err)
+		var encodedDerivedKeyEntries []string
+		for _, derivedKeyEntry := range derivedKeyEntries {
+			encodedDerivedKeyEntries = append(encodedDerivedKeyEntries, encode(derivedKeyEntry))
+		}
+		addSorted(label("derived keys"), encodedDerivedKeyEntries)
+
+		// Messages, newest first. The base messaging key is enough because the messaging groups
+		// themselves are always read from badger.
+		messageEntries, err := adapter.GetLimitedMessageEntriesForMessagingKeys([]*MessagingGroupEntry{{
+			GroupOwnerPublicKey:   NewPublicKey(publicKey),
+			MessagingPublicKey:    NewPublicKey(publicKey),
+			MessagingGroupKeyName: BaseGroupKeyName(),
+		}}, math.MaxUint64)
+		require.NoError(err)
+		for ii, messageEntry := range messageEntries {
+			dump = append(dump, fmt.Sprintf("%s %d: %v", label("message"), ii, encode(messageEntry)))
+		}
+
+		// Posts, newest first, along with everything attached to them.
+		var postHashes []*BlockHash
+		require.NoError(adapter.IteratePostHashesForPublicKeyByTimestamp(publicKey, nil,
+			func(postHash *BlockHash) (bool, error) {
+				postHashes = append(postHashes, postHash)
+				return true, nil
+			}))
+		for ii, postHash := range postHashes {
+			postLabel := func(name string) string {
+				return fmt.Sprintf("%s %d %s", label("post"), ii, name)
+			}
+			dump = append(dump, fmt.Sprintf("%s: %v", postLabel("entry"), encode(adapter.GetPostEntry(postHash))))
+
+			commentHashes, err := adapter.GetCommentPostHashesForParentStakeID(postHash[:])
+			require.NoError(err)
+			addSorted(postLabel("comments"), _encodeBlockHashes(commentHashes))
+
+			likerPublicKeys, err := adapter.GetLikerPublicKeysForPostHash(postHash)
+			require.NoError(err)
+			addSorted(postLabel("likers"), _encodeByteSlices(likerPublicKeys))
+
+			reposterPublicKeys, err := adapter.GetReposterPublicKeysForPostHash(postHash)
+			require.NoError(err)
+			addSorted(postLabel("reposters"), _encodeByteSlices(reposterPublicKeys))
+
+			quoteRepostPostHashes, err := adapter.GetQuoteRepostPostHashesForPostHash(postHash)
+			require.NoError(err)
+			addSorted(postLabel("quote reposts"), _encodeBlockHashes(quoteRepostPostHashes))
+
+			diamondSenderPKIDs, err := adapter.GetDiamondSenderPKIDsForPostHash(postHash)
+			require.NoError(err)
+			addSorted(postLabel("diamond senders"), _encodePKIDs(diamondSenderPKIDs))
+
+			for _, nftEntry := range adapter.GetNFTEntriesForPostHash(postHash) {
+				var encodedBidEntries []string
+				for _, nftBidEntry := range adapter.GetNFTBidEntries(postHash, nftEntry.SerialNumber) {
+					encodedBidEntries = append(encodedBidEntries, encode(nftBidEntry))
+				}
+				addSorted(postLabel(fmt.Sprintf("nft %d bids", nftEntry.SerialNumber)), encodedBidEntries)
+			}
+		}
+	}
+
+	return dump
+}
+
+func _encodePKIDs(pkids []*PKID) []string {
+	var encodedPKIDs []string
+	for _, pkid := range pkids {
+		encodedPKIDs = append(encodedPKIDs, hex.EncodeToString(pkid[:]))
+	}
+	return encodedPKIDs
+}
+
+func _encodeBlockHashes(blockHashes []*BlockHash) []string {
+	var encodedBlockHashes []string
+	for _, blockHash := range blockHashes {
+		encodedBlockHashes = append(encodedBlockHashes, blockHash.String())
+	}
+	return encodedBlockHashes
+}
+
+func _encodeByteSlices(byteSlices [][]byte) []string {
+	var encodedByteSlices []string
+	for _, byteSlice := range byteSlices {
+		encodedByteSlices = append(encodedByteSlices, hex.EncodeToString(byteSlice))
+	}
+	return encodedByteSlices
+}
+
+// TestDbAdapterBackendParity runs block view tests on badger and on Postgres, and requires the DbAdapter
+// to read the same state back from both. It needs a Postgres at POSTGRES_URI.
+func TestDbAdapterBackendParity(t *testing.T) {
+	postgresURI := os.Getenv("POSTGRES_URI")
+	if len(postgresURI) == 0 {
+		t.Skip("POSTGRES_URI isn't set, skipping the backend parity test")
+	}
+
+	blockViewTests := []struct {
+		name     string
+		testFunc func(t *testing.T)
+	}{
+		{"FollowTxns", TestFollowTxns},
+		{"LikeTxns", TestLikeTxns},
+		{"SubmitPost", TestSubmitPost},
+		{"DeSoDiamonds", TestDeSoDiamonds},
+		{"UpdateProfile", TestUpdateProfile},
+		{"SwapIdentityWithFollows", TestSwapIdentityWithFollows},
+		{"PrivateMessage", TestPrivateMessage},
+		{"NFTBasic", TestNFTBasic},
+		{"DAOCoinBasic", TestDAOCoinBasic},
+	}
+	for _, blockViewTest := range blockViewTests {
+		testFunc := blockViewTest.testFunc
+		t.Run(blockViewTest.name, func(t *testing.T) {
+			badgerChain := _runWithPostgresURI(t, "badger", "", testFunc)
+			postgresChain := _runWithPostgresURI(t, "postgres", postgresURI, testFunc)
+			require.Equal(t,
+				_dumpDbAdapterState(t, badgerChain, dbAdapterTestPublicKeys),
+				_dumpDbAdapterState(t, postgresChain, dbAdapterTestPublicKeys))
+		})
+	}
+}
+
+func TestDbAdapterIteratePostHashesForPublicKeyByTimestamp(t *testing.T) {
+	require := require.New(t)
+
+	// Create a test db and clean up the files at the end.
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	adapter := &DbAdapter{badgerDb: db}
+
+	// Three core posts by the same poster, two of them with the same timestamp.
+	posterPublicKey := m0PkBytes
+	var postEntries []*PostEntry
+	for ii, timestampNanos := range []uint64{100, 200, 200} {
+		postEntry := &PostEntry{
+			PostHash:        NewBlockHash(bytes.Repeat([]byte{byte(ii + 1)}, HashSizeBytes)),
+			PosterPublicKey: posterPublicKey,
+			TimestampNanos:  timestampNanos,
+		}
+		postEntries = append(postEntries, postEntry)
+		require.NoError(db.Update(func(txn KVTxn) error {
+			return DBPutPostEntryMappingsWithTxn(txn, nil, 0, postEntry, &DeSoTestnetParams)
+		}))
+	}
+
+	iterate := func(startPostEntry *PostEntry, limit int) []*BlockHash {
+		var postHashes []*BlockHash
+		require.NoError(adapter.IteratePostHashesForPublicKeyByTimestamp(posterPublicKey, startPostEntry,
+			func(postHash *BlockHash) (bool, error) {
+				postHashes = append(postHashes, postHash)
+				return len(postHashes) < limit, nil
+			}))
+		return postHashes
+	}
+
+	// Newest first, and the post hash breaks ties.
+	expectedPostHashes := []*BlockHash{postEntries[2].PostHash, postEntries[1].PostHash, postEntries[0].PostHash}
+	require.Equal(expectedPostHashes, iterate(nil, 10))
+	require.Equal(expectedPostHashes[:2], iterate(nil, 2))
+
+	// The start post itself is skipped.
+	require.Equal(expectedPostHashes[1:], iterate(postEntries[2], 10))
+	require.Equal(expectedPostHashes[2:], iterate(postEntries[1], 10))
+	require.Empty(iterate(postEntries[0], 10))
+
+	// Other posters' posts are never returned.
+	require.NoError(adapter.IteratePostHashesForPublicKeyByTimestamp(m1PkBytes, nil,
+		func(postHash *BlockHash) (bool, error) {
+			return false, fmt.Errorf("unexpected post %v", postHash)
+		}))
+}
@@ -0,0 +1,379 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// -------------------------------------------------------------------------------------
+// DBIndexedEntryType
+// -------------------------------------------------------------------------------------
+
+// DBIndexedEntryType declares how an entry type is stored in the db. Every entry is stored
+// under PrimaryPrefix, keyed by its primary key, and under the prefix of each of its
+// secondary indexes, keyed by the index's key. Once the type is declared, the put, delete,
+// lookup, and prefix scan functions below, as well as the UtxoView mappings and flush logic
+// in block_view_indexed_entry.go, keep all of the prefixes in sync, which is otherwise easy
+// to get wrong when writing the DbPut* and DbDelete* functions for each prefix by hand.
+//
+// The prefixes still have to be declared in DBPrefixes, but they don't need an is_state tag.
+// RegisterDBIndexedEntryType tags all of them as state prefixes if IsState is set, and the
+// records are written through DBSetWithTxn and DBDeleteWithTxn, so state entry types get
+// ancestral records and checksum updates just like any other state prefix. Registered types
+// also don't need a case in StatePrefixToDeSoEncoder.
+//
+// Entry types are only stored in Badger. The UtxoView reads and flushes them through its Badger
+// handle in Postgres mode too, so the Postgres flush doesn't need to know about them.
+type DBIndexedEntryType struct {
+	// Name identifies the entry type in the UtxoView mappings and in errors. It has to be unique.
+	Name string
+
+	// PrimaryPrefix maps primary keys to the encoded entries.
+	PrimaryPrefix []byte
+	// PrimaryKey returns the primary key of an entry, without the prefix.
+	PrimaryKey func(entry DeSoEncoder) []byte
+	// NewEntry returns an empty entry for records to be decoded into.
+	NewEntry func() DeSoEncoder
+
+	SecondaryIndexes []*DBSecondaryIndex
+
+	// IsState makes all of the prefixes of the entry type state prefixes, which are part of the
+	// snapshot and the state checksum.
+	IsState bool
+}
+
+// DBSecondaryIndex is a secondary index of a DBIndexedEntryType.
+type DBSecondaryIndex struct {
+	// Name identifies the index when scanning it. It has to be unique within the entry type.
+	Name   string
+	Prefix []byte
+	// Key returns the key of an entry in this index, without the prefix. Two entries must never
+	// have the same key, which is usually achieved by ending the key with the primary key.
+	Key func(entry DeSoEncoder) []byte
+	// StoreEntry makes the index map its keys to the encoded entry, same as the primary prefix,
+	// which saves a lookup when scanning the index. Otherwise the index maps its keys to the
+	// primary key of the entry.
+	StoreEntry bool
+}
+
+// dbIndexedEntryTypes are the entry types added with RegisterDBIndexedEntryType.
+var dbIndexedEntryTypes []*DBIndexedEntryType
+
+// RegisterDBIndexedEntryType validates an entry type and registers it. It's meant to be called
+// when initializing a package-level variable, and panics if the entry type is invalid or
+// conflicts with one that was registered before, the same way a bad DBPrefixes tag does.
+func RegisterDBIndexedEntryType(entryType *DBIndexedEntryType) *DBIndexedEntryType {
+	if err := entryType.Validate(); err != nil {
+		panic(err)
+	}
+	for _, registeredType := range dbIndexedEntryTypes {
+		if registeredType.Name == entryType.Name {
+			panic(fmt.Errorf("RegisterDBIndexedEntryType: Entry type (%v) is already registered", entryType.Name))
+		}
+		for _, prefix := range entryType.prefixes() {
+			for _, registeredPrefix := range registeredType.prefixes() {
+				if bytes.Equal(prefix, registeredPrefix) {
+					panic(fmt.Errorf("RegisterDBIndexedEntryType: Prefix (%v) of entry type (%v) is already "+
+						"used by entry type (%v)", prefix, entryType.Name, registeredType.Name))
+				}
+			}
+		}
+	}
+	dbIndexedEntryTypes = append(dbIndexedEntryTypes, entryType)
+	entryType.tagStatePrefixes()
+	return entryType
+}
+
+// tagStatePrefixes adds the prefixes of a state entry type to the StatePrefixes, the same way
+// an is_state tag in DBPrefixes does.
+func (entryType *DBIndexedEntryType) tagStatePrefixes() {
+	if !entryType.IsState {
+		return
+	}
+	for _, prefix := range entryType.prefixes() {
+		if StatePrefixes.StatePrefixesMap[prefix[0]] {
+			continue
+		}
+		StatePrefixes.StatePrefixesMap[prefix[0]] = true
+		StatePrefixes.StatePrefixesList = append(StatePrefixes.StatePrefixesList, []byte{prefix[0]})
+	}
+	sort.Slice(StatePrefixes.StatePrefixesList, func(ii, jj int) bool {
+		return bytes.Compare(StatePrefixes.StatePrefixesList[ii], StatePrefixes.StatePrefixesList[jj]) < 0
+	})
+}
+
+// Validate checks that the entry type is complete and that its prefixes are declared in DBPrefixes
+// and don't overlap. A prefix that's already tagged with is_state in DBPrefixes has to agree with
+// IsState, and txindex prefixes can't be state prefixes.
+func (entryType *DBIndexedEntryType) Validate() error {
+	if entryType.Name == "" {
+		return fmt.Errorf("DBIndexedEntryType.Validate: Entry type doesn't have a name")
+	}
+	if entryType.PrimaryKey == nil || entryType.NewEntry == nil {
+		return fmt.Errorf("DBIndexedEntryType.Validate: Entry type (%v) is missing PrimaryKey or NewEntry",
+			entryType.Name)
+	}
+
+	indexNames := make(map[string]bool)
+	for _, index := range entryType.SecondaryIndexes {
+		if index.Name == "" || index.Key == nil {
+			return fmt.Errorf("DBIndexedEntryType.Validate: Secondary index of entry type (%v) is missing "+
+				"Name or Key", entryType.Name)
+		}
+		if indexNames[index.Name] {
+			return fmt.Errorf("DBIndexedEntryType.Validate: Entry type (%v) has two indexes named (%v)",
+				entryType.Name, index.Name)
+		}
+		indexNames[index.Name] = true
+	}
+
+	usedPrefixes := make(map[byte]bool)
+	for _, prefix := range entryType.prefixes() {
+		if len(prefix) != MaxPrefixLen {
+			return fmt.Errorf("DBIndexedEntryType.Validate: Prefix (%v) of entry type (%v) should have "+
+				"length %v", prefix, entryType.Name, MaxPrefixLen)
+		}
+		isState, exists := StatePrefixes.StatePrefixesMap[prefix[0]]
+		if !exists {
+			return fmt.Errorf("DBIndexedEntryType.Validate: Prefix (%v) of entry type (%v) isn't declared "+
+				"in DBPrefixes", prefix, entryType.Name)
+		}
+		if usedPrefixes[prefix[0]] {
+			return fmt.Errorf("DBIndexedEntryType.Validate: Prefix (%v) is used twice by entry type (%v)",
+				prefix, entryType.Name)
+		}
+		usedPrefixes[prefix[0]] = true
+		if isState && !entryType.IsState {
+			return fmt.Errorf("DBIndexedEntryType.Validate: Prefix (%v) of entry type (%v) is tagged with "+
+				"is_state but the entry type doesn't set IsState", prefix, entryType.Name)
+		}
+		if entryType.IsState && isTxIndexKey(prefix) {
+			return fmt.Errorf("DBIndexedEntryType.Validate: Prefix (%v) of state entry type (%v) is a "+
+				"txindex prefix", prefix, entryType.Name)
+		}
+	}
+	return nil
+}
+
+// prefixes returns the primary prefix followed by the prefixes of the secondary indexes.
+func (entryType *DBIndexedEntryType) prefixes() [][]byte {
+	prefixes := [][]byte{entryType.PrimaryPrefix}
+	for _, index := range entryType.SecondaryIndexes {
+		prefixes = append(prefixes, index.Prefix)
+	}
+	return prefixes
+}
+
+func (entryType *DBIndexedEntryType) GetSecondaryIndex(indexName string) (*DBSecondaryIndex, error) {
+	for _, index := range entryType.SecondaryIndexes {
+		if index.Name == indexName {
+			return index, nil
+		}
+	}
+	return nil, fmt.Errorf("GetSecondaryIndex: Entry type (%v) doesn't have index (%v)", entryType.Name, indexName)
+}
+
+func (entryType *DBIndexedEntryType) GetPrimaryDBKey(entry DeSoEncoder) []byte {
+	return append(append([]byte{}, entryType.PrimaryPrefix...), entryType.PrimaryKey(entry)...)
+}
+
+func (entryType *DBIndexedEntryType) GetSecondaryDBKey(index *DBSecondaryIndex, entry DeSoEncoder) []byte {
+	return append(append([]byte{}, index.Prefix...), index.Key(entry)...)
+}
+
+func (entryType *DBIndexedEntryType) decodeEntry(entryBytes []byte) (DeSoEncoder, error) {
+	entry := entryType.NewEntry()
+	exists, err := DecodeFromBytes(entry, bytes.NewReader(entryBytes))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Problem decoding (%v) entry", entryType.Name)
+	}
+	if !exists {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// PutWithTxn writes the entry under the primary prefix and all of the secondary indexes. If the
+// entry may already be in the db with different index keys, use ReplaceWithTxn instead.
+func (entryType *DBIndexedEntryType) PutWithTxn(txn KVTxn, snap *Snapshot, entry DeSoEncoder, blockHeight uint64) error {
+	primaryKey := entryType.PrimaryKey(entry)
+	entryBytes := EncodeToBytes(blockHeight, entry)
+	primaryDBKey := append(append([]byte{}, entryType.PrimaryPrefix...), primaryKey...)
+	if err := DBSetWithTxn(txn, snap, primaryDBKey, entryBytes); err != nil {
+		return errors.Wrapf(err, "PutWithTxn: Problem putting (%v) entry", entryType.Name)
+	}
+	for _, index := range entryType.SecondaryIndexes {
+		value := primaryKey
+		if index.StoreEntry {
+			value = entryBytes
+		}
+		if err := DBSetWithTxn(txn, snap, entryType.GetSecondaryDBKey(index, entry), value); err != nil {
+			return errors.Wrapf(err, "PutWithTxn: Problem putting (%v) entry in index (%v)",
+				entryType.Name, index.Name)
+		}
+	}
+	return nil
+}
+
+// DeleteWithTxn deletes the entry from the primary prefix and all of the secondary indexes. The
+// index keys are computed from the passed entry, so it has to match what's in the db.
+func (entryType *DBIndexedEntryType) DeleteWithTxn(txn KVTxn, snap *Snapshot, entry DeSoEncoder) error {
+	if err := DBDeleteWithTxn(txn, snap, entryType.GetPrimaryDBKey(entry)); err != nil {
+		return errors.Wrapf(err, "DeleteWithTxn: Problem deleting (%v) entry", entryType.Name)
+	}
+	for _, index := range entryType.SecondaryIndexes {
+		if err := DBDeleteWithTxn(txn, snap, entryType.GetSecondaryDBKey(index, entry)); err != nil {
+			return errors.Wrapf(err, "DeleteWithTxn: Problem deleting (%v) entry from index (%v)",
+				entryType.Name, index.Name)
+		}
+	}
+	return nil
+}
+
+// ReplaceWithTxn deletes the entry with the same primary key that's currently in the db, if any,
+// along with its index keys, and then puts the new entry.
+func (entryType *DBIndexedEntryType) ReplaceWithTxn(txn KVTxn, snap *Snapshot, entry DeSoEncoder, blockHeight uint64) error {
+	if err := entryType.DeleteByPrimaryKeyWithTxn(txn, snap, entryType.PrimaryKey(entry)); err != nil {
+		return errors.Wrapf(err, "ReplaceWithTxn: Problem deleting existing (%v) entry", entryType.Name)
+	}
+	return entryType.PutWithTxn(txn, snap, entry, blockHeight)
+}
+
+// DeleteByPrimaryKeyWithTxn deletes the entry with the given primary key, along with its index keys.
+// It's a no-op if there's no such entry.
+func (entryType *DBIndexedEntryType) DeleteByPrimaryKeyWithTxn(txn KVTxn, snap *Snapshot, primaryKey []byte) error {
+	existingEntry, err := entryType.GetWithTxn(txn, snap, primaryKey)
+	if err != nil {
+		return errors.Wrapf(err, "DeleteByPrimaryKeyWithTxn: Problem fetching existing (%v) entry", entryType.Name)
+	}
+	if existingEntry == nil {
+		return nil
+	}
+	return entryType.DeleteWithTxn(txn, snap, existingEntry)
+}
+
+// GetWithTxn fetches the entry with the given primary key. It returns nil if there's no such entry.
+func (entryType *DBIndexedEntryType) GetWithTxn(txn KVTxn, snap *Snapshot, primaryKey []byte) (DeSoEncoder, error) {
+	primaryDBKey := append(append([]byte{}, entryType.PrimaryPrefix...), primaryKey...)
+	entryBytes, err := DBGetWithTxn(txn, snap, primaryDBKey)
+	if err == ErrKVKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetWithTxn: Problem fetching (%v) entry", entryType.Name)
+	}
+	return entryType.decodeEntry(entryBytes)
+}
+
+func (entryType *DBIndexedEntryType) Get(handle KVStore, snap *Snapshot, primaryKey []byte) (DeSoEncoder, error) {
+	var entry DeSoEncoder
+	err := handle.View(func(txn KVTxn) error {
+		var err error
+		entry, err = entryType.GetWithTxn(txn, snap, primaryKey)
+		return err
+	})
+	return entry, err
+}
+
+// EnumerateWithTxn returns all the entries whose primary key starts with keyPrefix, in key order.
+func (entryType *DBIndexedEntryType) EnumerateWithTxn(txn KVTxn, keyPrefix []byte) ([]DeSoEncoder, error) {
+	dbPrefix := append(append([]byte{}, entryType.PrimaryPrefix...), keyPrefix...)
+	_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, dbPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "EnumerateWithTxn: Problem scanning (%v) entries", entryType.Name)
+	}
+	return entryType._decodeEntries(valsFound)
+}
+
+func (entryType *DBIndexedEntryType) Enumerate(handle KVStore, keyPrefix []byte) ([]DeSoEncoder, error) {
+	var entries []DeSoEncoder
+	err := handle.View(func(txn KVTxn) error {
+		var err error
+		entries, err = entryType.EnumerateWithTxn(txn, keyPrefix)
+		return err
+	})
+	return entries, err
+}
+
+// EnumerateByIndexWithTxn returns all the entries whose key in the given secondary index starts with
+// keyPrefix, in index key order.
+func (entryType *DBIndexedEntryType) EnumerateByIndexWithTxn(txn KVTxn, snap *Snapshot, indexName string,
+	keyPrefix []byte) ([]DeSoEncoder, error) {
+
+	index, err := entryType.GetSecondaryIndex(indexName)
+	if err != nil {
+		return nil, err
+	}
+	dbPrefix := append(append([]byte{}, index.Prefix...), keyPrefix...)
+	_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, dbPrefix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "EnumerateByIndexWithTxn: Problem scanning index (%v) of (%v)",
+			index.Name, entryType.Name)
+	}
+	if index.StoreEntry {
+		return entryType._decodeEntries(valsFound)
+	}
+
+	var entries []DeSoEncoder
+	for _, primaryKey := range valsFound {
+		entry, err := entryType.GetWithTxn(txn, snap, primaryKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "EnumerateByIndexWithTxn: Problem fetching entry from index (%v)", index.Name)
+		}
+		if entry == nil {
+			return nil, fmt.Errorf("EnumerateByIndexWithTxn: Index (%v) of (%v) points to missing entry "+
+				"with primary key (%v)", index.Name, entryType.Name, primaryKey)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (entryType *DBIndexedEntryType) EnumerateByIndex(handle KVStore, snap *Snapshot, indexName string,
+	keyPrefix []byte) ([]DeSoEncoder, error) {
+
+	var entries []DeSoEncoder
+	err := handle.View(func(txn KVTxn) error {
+		var err error
+		entries, err = entryType.EnumerateByIndexWithTxn(txn, snap, indexName, keyPrefix)
+		return err
+	})
+	return entries, err
+}
+
+func (entryType *DBIndexedEntryType) _decodeEntries(valsFound [][]byte) ([]DeSoEncoder, error) {
+	var entries []DeSoEncoder
+	for _, entryBytes := range valsFound {
+		entry, err := entryType.decodeEntry(entryBytes)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, nil
+}
+
+// _dbIndexedEntryTypePrefixToDeSoEncoder tells StatePrefixToDeSoEncoder which encoder, if any, is
+// stored under a prefix that belongs to a registered entry type.
+func _dbIndexedEntryTypePrefixToDeSoEncoder(prefix []byte) (_isRegistered bool, _isEncoder bool, _encoder DeSoEncoder) {
+	for _, entryType := range dbIndexedEntryTypes {
+		if bytes.Equal(prefix, entryType.PrimaryPrefix) {
+			return true, true, entryType.NewEntry()
+		}
+		for _, index := range entryType.SecondaryIndexes {
+			if !bytes.Equal(prefix, index.Prefix) {
+				continue
+			}
+			if index.StoreEntry {
+				return true, true, entryType.NewEntry()
+			}
+			return true, false, nil
+		}
+	}
+	return false, false, nil
+}
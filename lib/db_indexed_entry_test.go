@@ -0,0 +1,263 @@
+package lib
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/holiman/uint256"
+	"github.com/stretchr/testify/require"
+)
+
+// _testDAOCoinLimitOrderEntryType declares the DAO coin limit order prefixes as an indexed entry type. It
+// isn't registered, since the prefixes are already handled by the hand-written DAO coin limit order code.
+var _testDAOCoinLimitOrderEntryType = &DBIndexedEntryType{
+	Name:          "TestDAOCoinLimitOrder",
+	PrimaryPrefix: Prefixes.PrefixDAOCoinLimitOrderByOrderID,
+	PrimaryKey: func(entry DeSoEncoder) []byte {
+		return DBKeyForDAOCoinLimitOrderByOrderID(entry.(*DAOCoinLimitOrderEntry))[1:]
+	},
+	NewEntry: func() DeSoEncoder { return &DAOCoinLimitOrderEntry{} },
+	SecondaryIndexes: []*DBSecondaryIndex{
+		{
+			Name:   "OrderBook",
+			Prefix: Prefixes.PrefixDAOCoinLimitOrder,
+			Key: func(entry DeSoEncoder) []byte {
+				return DBKeyForDAOCoinLimitOrder(entry.(*DAOCoinLimitOrderEntry))[1:]
+			},
+			StoreEntry: true,
+		},
+		{
+			Name:   "Transactor",
+			Prefix: Prefixes.PrefixDAOCoinLimitOrderByTransactorPKID,
+			Key: func(entry DeSoEncoder) []byte {
+				return DBKeyForDAOCoinLimitOrderByTransactorPKID(entry.(*DAOCoinLimitOrderEntry))[1:]
+			},
+			StoreEntry: true,
+		},
+	},
+	IsState: true,
+}
+
+func _testIndexedLimitOrder(orderID byte, transactor byte, exchangeRate uint64) *DAOCoinLimitOrderEntry {
+	return &DAOCoinLimitOrderEntry{
+		OrderID:                   NewBlockHash(append(make([]byte, HashSizeBytes-1), orderID)),
+		TransactorPKID:            NewPKID(append(make([]byte, PublicKeyLenCompressed-1), transactor)),
+		BuyingDAOCoinCreatorPKID:  &ZeroPKID,
+		SellingDAOCoinCreatorPKID: NewPKID(append(make([]byte, PublicKeyLenCompressed-1), 0xff)),
+		ScaledExchangeRateCoinsToSellPerCoinToBuy: uint256.NewInt().SetUint64(exchangeRate),
+		QuantityToFillInBaseUnits:                 uint256.NewInt().SetUint64(100),
+		OperationType:                             DAOCoinLimitOrderOperationTypeBID,
+		FillType:                                  DAOCoinLimitOrderFillTypeGoodTillCancelled,
+		BlockHeight:                               uint32(orderID),
+	}
+}
+
+func _dumpIndexedEntryTypeRecords(t *testing.T, handle KVStore, entryType *DBIndexedEntryType) map[string]string {
+	records := make(map[string]string)
+	require.NoError(t, handle.View(func(txn KVTxn) error {
+		for _, prefix := range entryType.prefixes() {
+			keys, vals, err := _enumerateKeysForPrefixWithTxn(txn, prefix)
+			if err != nil {
+				return err
+			}
+			for ii := range keys {
+				records[string(keys[ii])] = string(vals[ii])
+			}
+		}
+		return nil
+	}))
+	return records
+}
+
+func TestDBIndexedEntryType(t *testing.T) {
+	require := require.New(t)
+	entryType := _testDAOCoinLimitOrderEntryType
+	require.NoError(entryType.Validate())
+
+	orders := []*DAOCoinLimitOrderEntry{
+		_testIndexedLimitOrder(1, 1, 30),
+		_testIndexedLimitOrder(2, 2, 10),
+		_testIndexedLimitOrder(3, 1, 20),
+	}
+
+	// Writing the orders through the entry type produces exactly the same records as the
+	// hand-written DAO coin limit order functions.
+	genericDb := NewMemoryKVStore()
+	manualDb := NewMemoryKVStore()
+	for _, order := range orders {
+		require.NoError(genericDb.Update(func(txn KVTxn) error {
+			return entryType.PutWithTxn(txn, nil, order, 0)
+		}))
+		require.NoError(manualDb.Update(func(txn KVTxn) error {
+			return DBPutDAOCoinLimitOrderWithTxn(txn, nil, order, 0)
+		}))
+	}
+	genericRecords := _dumpIndexedEntryTypeRecords(t, genericDb, entryType)
+	require.Equal(3*len(orders), len(genericRecords))
+	require.Equal(_dumpIndexedEntryTypeRecords(t, manualDb, entryType), genericRecords)
+
+	// Lookups and scans.
+	entry, err := entryType.Get(genericDb, nil, orders[1].OrderID.ToBytes())
+	require.NoError(err)
+	require.True(entry.(*DAOCoinLimitOrderEntry).Eq(orders[1]))
+	entry, err = entryType.Get(genericDb, nil, ZeroBlockHash.ToBytes())
+	require.NoError(err)
+	require.Nil(entry)
+
+	entries, err := entryType.EnumerateByIndex(genericDb, nil, "Transactor", orders[0].TransactorPKID.ToBytes())
+	require.NoError(err)
+	require.Equal(2, len(entries))
+	require.True(entries[0].(*DAOCoinLimitOrderEntry).Eq(orders[0]))
+	require.True(entries[1].(*DAOCoinLimitOrderEntry).Eq(orders[2]))
+
+	entries, err = entryType.EnumerateByIndex(genericDb, nil, "OrderBook", nil)
+	require.NoError(err)
+	require.Equal(3, len(entries))
+	require.True(entries[0].(*DAOCoinLimitOrderEntry).Eq(orders[1]))
+	require.True(entries[1].(*DAOCoinLimitOrderEntry).Eq(orders[2]))
+	require.True(entries[2].(*DAOCoinLimitOrderEntry).Eq(orders[0]))
+
+	_, err = entryType.EnumerateByIndex(genericDb, nil, "Missing", nil)
+	require.Error(err)
+
+	// Deleting removes the records from every prefix.
+	require.NoError(genericDb.Update(func(txn KVTxn) error {
+		return entryType.DeleteWithTxn(txn, nil, orders[1])
+	}))
+	require.NoError(manualDb.Update(func(txn KVTxn) error {
+		return DBDeleteDAOCoinLimitOrderWithTxn(txn, nil, orders[1])
+	}))
+	require.Equal(_dumpIndexedEntryTypeRecords(t, manualDb, entryType),
+		_dumpIndexedEntryTypeRecords(t, genericDb, entryType))
+
+	// Invalid entry types.
+	require.Error((&DBIndexedEntryType{
+		Name:          "Undeclared",
+		PrimaryPrefix: []byte{250},
+		PrimaryKey:    entryType.PrimaryKey,
+		NewEntry:      entryType.NewEntry,
+	}).Validate())
+	require.Error((&DBIndexedEntryType{
+		Name:          "MissingIsState",
+		PrimaryPrefix: Prefixes.PrefixDAOCoinLimitOrderByOrderID,
+		PrimaryKey:    entryType.PrimaryKey,
+		NewEntry:      entryType.NewEntry,
+		SecondaryIndexes: []*DBSecondaryIndex{{
+			Name:   "Index",
+			Prefix: Prefixes.PrefixDBSchemaVersion,
+			Key:    entryType.PrimaryKey,
+		}},
+	}).Validate())
+
+	// The prefixes of a state entry type are tagged as state prefixes when it's registered, even if
+	// they aren't tagged with is_state in DBPrefixes.
+	statePrefixesMap, statePrefixesList := StatePrefixes.StatePrefixesMap, StatePrefixes.StatePrefixesList
+	defer func() {
+		StatePrefixes.StatePrefixesMap, StatePrefixes.StatePrefixesList = statePrefixesMap, statePrefixesList
+	}()
+	StatePrefixes.StatePrefixesMap = make(map[byte]bool)
+	for prefix, isState := range statePrefixesMap {
+		StatePrefixes.StatePrefixesMap[prefix] = isState
+	}
+	StatePrefixes.StatePrefixesList = append([][]byte{}, statePrefixesList...)
+	derivedStateType := &DBIndexedEntryType{
+		Name:          "DerivedState",
+		PrimaryPrefix: Prefixes.PrefixDBSchemaVersion,
+		PrimaryKey:    entryType.PrimaryKey,
+		NewEntry:      entryType.NewEntry,
+		IsState:       true,
+	}
+	require.False(isStateKey(Prefixes.PrefixDBSchemaVersion))
+	require.NoError(derivedStateType.Validate())
+	derivedStateType.tagStatePrefixes()
+	require.True(isStateKey(Prefixes.PrefixDBSchemaVersion))
+	require.Len(StatePrefixes.StatePrefixesList, len(statePrefixesList)+1)
+	require.True(sort.SliceIsSorted(StatePrefixes.StatePrefixesList, func(ii, jj int) bool {
+		return bytes.Compare(StatePrefixes.StatePrefixesList[ii], StatePrefixes.StatePrefixesList[jj]) < 0
+	}))
+}
+
+func TestUtxoViewIndexedEntries(t *testing.T) {
+	require := require.New(t)
+	entryType := _testDAOCoinLimitOrderEntryType
+
+	db := NewMemoryKVStore()
+	orders := []*DAOCoinLimitOrderEntry{
+		_testIndexedLimitOrder(1, 1, 30),
+		_testIndexedLimitOrder(2, 2, 10),
+		_testIndexedLimitOrder(3, 1, 20),
+	}
+	for _, order := range orders[:2] {
+		require.NoError(db.Update(func(txn KVTxn) error {
+			return entryType.PutWithTxn(txn, nil, order, 0)
+		}))
+	}
+
+	utxoView, err := NewUtxoView(db, &DeSoTestnetParams, nil, nil)
+	require.NoError(err)
+
+	// Delete the first order, move the second one to another transactor, and add the third.
+	entry, err := utxoView.GetIndexedEntry(entryType, orders[0].OrderID.ToBytes())
+	require.NoError(err)
+	require.NotNil(entry)
+	utxoView.DeleteIndexedEntry(entryType, entry)
+
+	movedOrder := orders[1].Copy()
+	movedOrder.TransactorPKID = orders[0].TransactorPKID
+	utxoView.SetIndexedEntry(entryType, movedOrder)
+	utxoView.SetIndexedEntry(entryType, orders[2])
+
+	entry, err = utxoView.GetIndexedEntry(entryType, orders[0].OrderID.ToBytes())
+	require.NoError(err)
+	require.Nil(entry)
+
+	// Scans combine the db and the view.
+	entries, err := utxoView.GetIndexedEntriesByIndex(entryType, "Transactor", orders[0].TransactorPKID.ToBytes())
+	require.NoError(err)
+	require.Equal(2, len(entries))
+	require.True(entries[0].(*DAOCoinLimitOrderEntry).Eq(movedOrder))
+	require.True(entries[1].(*DAOCoinLimitOrderEntry).Eq(orders[2]))
+	entries, err = utxoView.GetIndexedEntriesByIndex(entryType, "Transactor", orders[1].TransactorPKID.ToBytes())
+	require.NoError(err)
+	require.Equal(0, len(entries))
+	entries, err = utxoView.GetIndexedEntries(entryType, nil)
+	require.NoError(err)
+	require.Equal(2, len(entries))
+
+	// A child view sees the parent's changes, and its own changes only reach the parent once committed.
+	childView := utxoView.NewChildUtxoView()
+	childView.DeleteIndexedEntry(entryType, orders[2])
+	entries, err = childView.GetIndexedEntries(entryType, nil)
+	require.NoError(err)
+	require.Equal(1, len(entries))
+	require.True(entries[0].(*DAOCoinLimitOrderEntry).Eq(movedOrder))
+	entries, err = utxoView.GetIndexedEntries(entryType, nil)
+	require.NoError(err)
+	require.Equal(2, len(entries))
+	require.NoError(childView.CommitToParent())
+
+	// Flushing leaves exactly the records of the remaining order, without the stale index keys.
+	require.NoError(utxoView.FlushToDb(0))
+	expectedDb := NewMemoryKVStore()
+	require.NoError(expectedDb.Update(func(txn KVTxn) error {
+		return entryType.PutWithTxn(txn, nil, movedOrder, 0)
+	}))
+	require.Equal(_dumpIndexedEntryTypeRecords(t, expectedDb, entryType),
+		_dumpIndexedEntryTypeRecords(t, db, entryType))
+	require.Equal(0, len(utxoView.IndexedEntries))
+
+	// In Postgres mode, indexed entries are still flushed to Badger.
+	postgresView, err := NewUtxoView(db, &DeSoTestnetParams, nil, nil)
+	require.NoError(err)
+	postgresView.Postgres = &Postgres{}
+	postgresView.SetIndexedEntry(entryType, orders[0])
+	require.NoError(db.Update(func(txn KVTxn) error {
+		return postgresView.FlushToDbWithTxn(txn, 0)
+	}))
+	require.NoError(expectedDb.Update(func(txn KVTxn) error {
+		return entryType.PutWithTxn(txn, nil, orders[0], 0)
+	}))
+	require.Equal(_dumpIndexedEntryTypeRecords(t, expectedDb, entryType),
+		_dumpIndexedEntryTypeRecords(t, db, entryType))
+}
@@ -0,0 +1,362 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DataDog/datadog-go/statsd"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultDBMaintenanceIdleSeconds is how long the node has to go without connecting a block
+	// before we consider it idle enough to run maintenance.
+	DefaultDBMaintenanceIdleSeconds = 30
+	// DefaultValueLogGCDiscardRatio is the fraction of a value log file that has to be stale
+	// for badger to rewrite it.
+	DefaultValueLogGCDiscardRatio = 0.5
+	// DBMaintenanceMaxGCRunsPerDB caps the number of value log files rewritten per db in one
+	// maintenance run, so that a single run doesn't go on for too long.
+	DBMaintenanceMaxGCRunsPerDB = 100
+)
+
+// DBUsageKeysPerTxn is the number of keys ComputeDBUsage reads per transaction. Badger keeps the
+// value log files that an open transaction may read from, so we don't hold one for the whole scan.
+var DBUsageKeysPerTxn = 100000
+
+// errDBUsageInterrupted is returned by ComputeDBUsage when it's stopped before it finishes.
+var errDBUsageInterrupted = errors.New("ComputeDBUsage: Interrupted")
+
+// DBUsage is the disk usage of one badger db. On-disk bytes come from the size of the LSM
+// tree and value log files, while live bytes are the estimated size of the latest version
+// of every key and its value. The difference between the two is what GC and compaction can
+// reclaim.
+type DBUsage struct {
+	Name string
+
+	LSMBytes      int64
+	ValueLogBytes int64
+
+	NumKeys         uint64
+	LiveBytes       uint64
+	PrefixLiveBytes map[byte]uint64
+}
+
+func (usage *DBUsage) OnDiskBytes() int64 {
+	return usage.LSMBytes + usage.ValueLogBytes
+}
+
+// DBMaintainer is a background job that periodically runs badger's value log GC on the
+// node's dbs and reports their disk usage. Badger only reclaims the space used by
+// overwritten and deleted values when GC is run, so without it the value logs keep
+// growing. GC adds load on the LSM tree, so we wait for the node to be idle between
+// blocks before running it, and stop early if a new block comes in.
+type DBMaintainer struct {
+	blockchain   *Blockchain
+	statsdClient *statsd.Client
+
+	// interval is the time between consecutive maintenance runs.
+	interval time.Duration
+	// idleDuration is how long the node has to go without connecting a block before we run GC.
+	idleDuration time.Duration
+	discardRatio float64
+	// flatten makes us compact the LSM tree of every db before running GC.
+	flatten bool
+
+	databasesLock sync.Mutex
+	databaseNames []string
+	databases     map[string]*BadgerKVStore
+
+	// lastBlockConnectedUnixNano is accessed atomically.
+	lastBlockConnectedUnixNano int64
+
+	lastUsageLock sync.RWMutex
+	lastUsage     []*DBUsage
+
+	exitChannel chan struct{}
+	waitGroup   sync.WaitGroup
+}
+
+func NewDBMaintainer(blockchain *Blockchain, eventManager *EventManager, statsdClient *statsd.Client,
+	interval time.Duration, idleDuration time.Duration, discardRatio float64, flatten bool) *DBMaintainer {
+
+	if idleDuration == 0 {
+		idleDuration = DefaultDBMaintenanceIdleSeconds * time.Second
+	}
+	if discardRatio <= 0 || discardRatio >= 1 {
+		discardRatio = DefaultValueLogGCDiscardRatio
+	}
+
+	maintainer := &DBMaintainer{
+		blockchain:                 blockchain,
+		statsdClient:               statsdClient,
+		interval:                   interval,
+		idleDuration:               idleDuration,
+		discardRatio:               discardRatio,
+		flatten:                    flatten,
+		databases:                  make(map[string]*BadgerKVStore),
+		lastBlockConnectedUnixNano: time.Now().UnixNano(),
+		exitChannel:                make(chan struct{}),
+	}
+	if eventManager != nil {
+		eventManager.OnBlockConnected(func(event *BlockEvent) {
+			atomic.StoreInt64(&maintainer.lastBlockConnectedUnixNano, time.Now().UnixNano())
+		})
+	}
+	return maintainer
+}
+
+// AddDatabase registers a db to be maintained. Only badger dbs need maintenance, anything
+// else is ignored.
+func (maintainer *DBMaintainer) AddDatabase(name string, store KVStore) {
+	badgerStore, ok := store.(*BadgerKVStore)
+	if !ok {
+		glog.V(1).Infof("DBMaintainer.AddDatabase: Skipping (%v) db because it isn't a badger db", name)
+		return
+	}
+
+	maintainer.databasesLock.Lock()
+	defer maintainer.databasesLock.Unlock()
+	if _, exists := maintainer.databases[name]; !exists {
+		maintainer.databaseNames = append(maintainer.databaseNames, name)
+	}
+	maintainer.databases[name] = badgerStore
+}
+
+func (maintainer *DBMaintainer) Start() {
+	glog.Infof("DBMaintainer.Start: Starting db maintenance with interval (%v)", maintainer.interval)
+
+	maintainer.waitGroup.Add(1)
+	go func() {
+		defer maintainer.waitGroup.Done()
+
+		ticker := time.NewTicker(maintainer.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-maintainer.exitChannel:
+				return
+			case <-ticker.C:
+			}
+
+			if !maintainer.waitUntilIdle() {
+				return
+			}
+			if _, err := maintainer.RunMaintenance(); err != nil {
+				glog.Errorf("DBMaintainer: Problem running db maintenance, error (%v)", err)
+			}
+		}
+	}()
+}
+
+func (maintainer *DBMaintainer) Stop() {
+	close(maintainer.exitChannel)
+	maintainer.waitGroup.Wait()
+	glog.Infof("DBMaintainer.Stop: Stopped db maintenance")
+}
+
+// IsIdle returns true if the node isn't syncing and hasn't connected a block for the idle duration.
+func (maintainer *DBMaintainer) IsIdle() bool {
+	if maintainer.blockchain != nil {
+		maintainer.blockchain.ChainLock.RLock()
+		isSyncing := maintainer.blockchain.isSyncing()
+		maintainer.blockchain.ChainLock.RUnlock()
+		if isSyncing {
+			return false
+		}
+	}
+	lastBlockConnected := time.Unix(0, atomic.LoadInt64(&maintainer.lastBlockConnectedUnixNano))
+	return time.Since(lastBlockConnected) >= maintainer.idleDuration
+}
+
+// waitUntilIdle blocks until the node is idle. It returns false if the maintainer was stopped.
+func (maintainer *DBMaintainer) waitUntilIdle() bool {
+	for !maintainer.IsIdle() {
+		select {
+		case <-maintainer.exitChannel:
+			return false
+		case <-time.After(time.Second):
+		}
+	}
+	return true
+}
+
+func (maintainer *DBMaintainer) isStopped() bool {
+	select {
+	case <-maintainer.exitChannel:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunMaintenance compacts and garbage collects every registered db while the node is idle,
+// and then measures and reports their disk usage. Measuring scans the whole db, so it's also
+// only done while the node is idle. The dbs that couldn't be measured keep the usage from the
+// previous run.
+func (maintainer *DBMaintainer) RunMaintenance() ([]*DBUsage, error) {
+	maintainer.databasesLock.Lock()
+	names := append([]string{}, maintainer.databaseNames...)
+	databases := make(map[string]*BadgerKVStore)
+	for name, store := range maintainer.databases {
+		databases[name] = store
+	}
+	maintainer.databasesLock.Unlock()
+
+	lastUsage := make(map[string]*DBUsage)
+	for _, usage := range maintainer.GetLastDBUsage() {
+		lastUsage[usage.Name] = usage
+	}
+	var allUsage []*DBUsage
+	for _, name := range names {
+		if maintainer.isStopped() {
+			break
+		}
+		db := databases[name].BadgerDB()
+		if db.IsClosed() {
+			continue
+		}
+
+		if maintainer.IsIdle() {
+			startTime := time.Now()
+			numRewrites, err := maintainer.runValueLogGC(name, db)
+			if err != nil {
+				return allUsage, errors.Wrapf(err, "DBMaintainer.RunMaintenance: Problem running GC on (%v) db", name)
+			}
+			glog.V(1).Infof("DBMaintainer.RunMaintenance: Rewrote %v value log files of (%v) db in %v",
+				numRewrites, name, time.Since(startTime))
+		} else {
+			glog.V(1).Infof("DBMaintainer.RunMaintenance: Skipping GC on (%v) db because node is busy", name)
+		}
+
+		usage, err := ComputeDBUsage(name, db, func() bool {
+			return maintainer.isStopped() || !maintainer.IsIdle()
+		})
+		if err == errDBUsageInterrupted {
+			glog.V(1).Infof("DBMaintainer.RunMaintenance: Skipping usage of (%v) db because node is busy", name)
+			if lastUsage[name] != nil {
+				allUsage = append(allUsage, lastUsage[name])
+			}
+			continue
+		}
+		if err != nil {
+			return allUsage, errors.Wrapf(err, "DBMaintainer.RunMaintenance: Problem computing usage of (%v) db", name)
+		}
+		maintainer.reportUsage(usage)
+		allUsage = append(allUsage, usage)
+	}
+
+	maintainer.lastUsageLock.Lock()
+	maintainer.lastUsage = allUsage
+	maintainer.lastUsageLock.Unlock()
+	return allUsage, nil
+}
+
+// GetLastDBUsage returns the disk usage measured during the last maintenance run.
+func (maintainer *DBMaintainer) GetLastDBUsage() []*DBUsage {
+	maintainer.lastUsageLock.RLock()
+	defer maintainer.lastUsageLock.RUnlock()
+	return maintainer.lastUsage
+}
+
+// runValueLogGC rewrites value log files of the db until badger doesn't find any more files
+// worth rewriting, or until a block comes in.
+func (maintainer *DBMaintainer) runValueLogGC(name string, db *badger.DB) (_numRewrites int, _err error) {
+	if maintainer.flatten {
+		if err := db.Flatten(1); err != nil {
+			return 0, errors.Wrapf(err, "runValueLogGC: Problem flattening")
+		}
+	}
+
+	numRewrites := 0
+	for ; numRewrites < DBMaintenanceMaxGCRunsPerDB; numRewrites++ {
+		if maintainer.isStopped() || !maintainer.IsIdle() {
+			break
+		}
+		err := db.RunValueLogGC(maintainer.discardRatio)
+		if err == badger.ErrNoRewrite || err == badger.ErrRejected {
+			break
+		}
+		if err != nil {
+			return numRewrites, err
+		}
+	}
+	return numRewrites, nil
+}
+
+// ComputeDBUsage measures the on-disk and live size of the db. Live bytes are computed by
+// iterating over the keys without fetching the values, which only touches the LSM tree. The
+// keys are read in chunks of DBUsageKeysPerTxn, and shouldStop is checked before every chunk.
+// If it returns true, ComputeDBUsage returns errDBUsageInterrupted.
+func ComputeDBUsage(name string, db *badger.DB, shouldStop func() bool) (*DBUsage, error) {
+	usage := &DBUsage{
+		Name:            name,
+		PrefixLiveBytes: make(map[byte]uint64),
+	}
+	usage.LSMBytes, usage.ValueLogBytes = db.Size()
+
+	var lastKey []byte
+	for {
+		if shouldStop != nil && shouldStop() {
+			return nil, errDBUsageInterrupted
+		}
+		startKey := lastKey
+		numKeys := 0
+		err := db.View(func(txn *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			it := txn.NewIterator(opts)
+			defer it.Close()
+
+			for it.Seek(startKey); it.Valid() && numKeys < DBUsageKeysPerTxn; it.Next() {
+				item := it.Item()
+				key := item.Key()
+				// The previous chunk ended with the start key.
+				if startKey != nil && bytes.Equal(key, startKey) {
+					continue
+				}
+				numKeys++
+				lastKey = item.KeyCopy(nil)
+				if len(key) == 0 {
+					continue
+				}
+				size := uint64(item.EstimatedSize())
+				usage.NumKeys++
+				usage.LiveBytes += size
+				usage.PrefixLiveBytes[key[0]] += size
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if numKeys < DBUsageKeysPerTxn {
+			return usage, nil
+		}
+	}
+}
+
+func (maintainer *DBMaintainer) reportUsage(usage *DBUsage) {
+	glog.Infof("DBMaintainer: (%v) db has %v live bytes in %v keys, and uses %v bytes on disk "+
+		"(LSM: %v, value log: %v)", usage.Name, usage.LiveBytes, usage.NumKeys, usage.OnDiskBytes(),
+		usage.LSMBytes, usage.ValueLogBytes)
+
+	if maintainer.statsdClient == nil {
+		return
+	}
+	tags := []string{"db:" + usage.Name}
+	maintainer.statsdClient.Gauge("DB.LSM_BYTES", float64(usage.LSMBytes), tags, 1)
+	maintainer.statsdClient.Gauge("DB.VALUE_LOG_BYTES", float64(usage.ValueLogBytes), tags, 1)
+	maintainer.statsdClient.Gauge("DB.ONDISK_BYTES", float64(usage.OnDiskBytes()), tags, 1)
+	maintainer.statsdClient.Gauge("DB.LIVE_BYTES", float64(usage.LiveBytes), tags, 1)
+
+	for prefix, liveBytes := range usage.PrefixLiveBytes {
+		prefixTags := []string{"db:" + usage.Name, fmt.Sprintf("prefix:%d", prefix)}
+		maintainer.statsdClient.Gauge("DB.PREFIX.LIVE_BYTES", float64(liveBytes), prefixTags, 1)
+	}
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBMaintainer(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "badgerdb")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	opts := badger.DefaultOptions(dir)
+	opts.ValueThreshold = 64
+	opts.ValueLogFileSize = 1 << 20
+	opts.Logger = nil
+	db, err := OpenBadgerKVStore(opts)
+	require.NoError(err)
+	defer db.Close()
+
+	// Write large values under one prefix and small values under another, then overwrite the
+	// large ones so that the value log holds stale data.
+	bigValue := make([]byte, 1000)
+	for round := 0; round < 3; round++ {
+		require.NoError(db.Update(func(txn KVTxn) error {
+			for ii := 0; ii < 500; ii++ {
+				bigValue[0] = byte(round)
+				if err := txn.Set([]byte(fmt.Sprintf("\x01big-%04d", ii)), bigValue); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	require.NoError(db.Update(func(txn KVTxn) error {
+		for ii := 0; ii < 100; ii++ {
+			if err := txn.Set([]byte(fmt.Sprintf("\x02small-%04d", ii)), []byte{1, 2, 3}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+
+	usage, err := ComputeDBUsage("test", db.BadgerDB(), nil)
+	require.NoError(err)
+	require.Equal(uint64(600), usage.NumKeys)
+	require.Equal(2, len(usage.PrefixLiveBytes))
+	require.Equal(usage.LiveBytes, usage.PrefixLiveBytes[1]+usage.PrefixLiveBytes[2])
+	// Only the latest version of every key counts as live.
+	require.True(usage.PrefixLiveBytes[1] >= 500*1000 && usage.PrefixLiveBytes[1] < 2*500*1000)
+	require.True(usage.PrefixLiveBytes[2] < usage.PrefixLiveBytes[1]/10)
+
+	// Reading the keys in chunks gives the same usage, and the scan can be stopped between chunks.
+	oldKeysPerTxn := DBUsageKeysPerTxn
+	defer func() { DBUsageKeysPerTxn = oldKeysPerTxn }()
+	DBUsageKeysPerTxn = 7
+	chunkedUsage, err := ComputeDBUsage("test", db.BadgerDB(), nil)
+	require.NoError(err)
+	require.Equal(usage, chunkedUsage)
+	numChunks := 0
+	_, err = ComputeDBUsage("test", db.BadgerDB(), func() bool {
+		numChunks++
+		return numChunks > 3
+	})
+	require.Equal(errDBUsageInterrupted, err)
+
+	maintainer := NewDBMaintainer(nil, nil, nil, time.Hour, time.Hour, 0, false)
+	maintainer.AddDatabase("test", db)
+	// Stores other than badger are ignored.
+	maintainer.AddDatabase("memory", NewMemoryKVStore())
+
+	// The node just started, so it isn't idle yet. Maintenance skips both GC and the usage scan.
+	require.False(maintainer.IsIdle())
+	allUsage, err := maintainer.RunMaintenance()
+	require.NoError(err)
+	require.Empty(allUsage)
+
+	// Once idle, GC runs and doesn't lose any data, and the usage is reported.
+	maintainer.idleDuration = 0
+	require.True(maintainer.IsIdle())
+	allUsage, err = maintainer.RunMaintenance()
+	require.NoError(err)
+	require.Equal(1, len(allUsage))
+	require.Equal("test", allUsage[0].Name)
+	require.Equal(usage.NumKeys, allUsage[0].NumKeys)
+	require.Equal(allUsage, maintainer.GetLastDBUsage())
+
+	// A run that can't measure the db keeps the previous usage.
+	maintainer.idleDuration = time.Hour
+	busyUsage, err := maintainer.RunMaintenance()
+	require.NoError(err)
+	require.Equal(allUsage, busyUsage)
+	require.NoError(db.View(func(txn KVTxn) error {
+		for ii := 0; ii < 500; ii++ {
+			item, err := txn.Get([]byte(fmt.Sprintf("\x01big-%04d", ii)))
+			if err != nil {
+				return err
+			}
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			require.Equal(byte(2), value[0])
+		}
+		return nil
+	}))
+}
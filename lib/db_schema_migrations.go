@@ -0,0 +1,387 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DBSchemaMigrationBatchSize is the number of records a schema migration transforms in a single db
+// transaction. The migration progress is saved in the same transaction, so a migration that gets
+// interrupted picks up after the last batch that was committed.
+const DBSchemaMigrationBatchSize = 1000
+
+// DBSchemaMigration is a single step that transforms the records under some prefixes in place.
+// Steps are applied in the order of their versions and every step is applied exactly once. The
+// version of the last applied step is stored in the db under PrefixDBSchemaVersion.
+//
+// Schema migrations write to the db directly and bypass the snapshot. A step that changes records
+// under state prefixes also changes the state checksum, so such steps have to come with a way to
+// bring the checksum in line, e.g. by being paired with an encoder migration.
+type DBSchemaMigration struct {
+	// Version of the schema after this step has been applied. Versions start at 1 and increase
+	// by one with every step.
+	Version uint64
+	Name    string
+
+	// Prefixes whose records are passed to MigrateEntry.
+	Prefixes [][]byte
+
+	// MigrateEntry is called with every record under Prefixes and returns the record that should
+	// replace it. Returning a nil value deletes the record. A record may be moved to a new key,
+	// as long as the new key isn't under one of the step's own Prefixes, because otherwise the
+	// step could visit it twice.
+	MigrateEntry func(key []byte, value []byte) (_newKey []byte, _newValue []byte, _err error)
+}
+
+// DBSchemaMigrations is the registry of schema migration steps. New steps are appended to the
+// end with the next version. Steps must never be removed or reordered once they're released.
+var DBSchemaMigrations = []*DBSchemaMigration{}
+
+// GetLatestDBSchemaVersion returns the schema version of a db with all of the passed steps applied.
+func GetLatestDBSchemaVersion(migrations []*DBSchemaMigration) uint64 {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// ValidateDBSchemaMigrations checks that the steps are ordered with consecutive versions.
+func ValidateDBSchemaMigrations(migrations []*DBSchemaMigration) error {
+	for ii, migration := range migrations {
+		if migration.Version != uint64(ii+1) {
+			return fmt.Errorf("ValidateDBSchemaMigrations: Migration (%v) has version (%v), expected (%v)",
+				migration.Name, migration.Version, ii+1)
+		}
+		if migration.MigrateEntry == nil {
+			return fmt.Errorf("ValidateDBSchemaMigrations: Migration (%v) has no MigrateEntry", migration.Name)
+		}
+	}
+	return nil
+}
+
+// DBSchemaVersion is stored under PrefixDBSchemaVersion. It holds the version of the last applied
+// migration step and, while a step is running, how far the step got.
+type DBSchemaVersion struct {
+	Version uint64
+
+	// InProgressVersion is the version of the step that's currently running, or zero if there isn't
+	// one. InProgressLastKey is the last key the step transformed, and InProgressEntries is the number
+	// of records it transformed so far.
+	InProgressVersion uint64
+	InProgressLastKey []byte
+	InProgressEntries uint64
+}
+
+func (version *DBSchemaVersion) ToBytes() []byte {
+	var data []byte
+	data = append(data, UintToBuf(version.Version)...)
+	data = append(data, UintToBuf(version.InProgressVersion)...)
+	data = append(data, EncodeByteArray(version.InProgressLastKey)...)
+	data = append(data, UintToBuf(version.InProgressEntries)...)
+	return data
+}
+
+func (version *DBSchemaVersion) FromBytes(rr *bytes.Reader) error {
+	var err error
+	if version.Version, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "DBSchemaVersion.FromBytes: Problem reading Version")
+	}
+	if version.InProgressVersion, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "DBSchemaVersion.FromBytes: Problem reading InProgressVersion")
+	}
+	if version.InProgressLastKey, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "DBSchemaVersion.FromBytes: Problem reading InProgressLastKey")
+	}
+	if version.InProgressEntries, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "DBSchemaVersion.FromBytes: Problem reading InProgressEntries")
+	}
+	return nil
+}
+
+// DbGetDBSchemaVersionWithTxn returns the stored schema version, or nil if the db doesn't have one.
+func DbGetDBSchemaVersionWithTxn(txn KVTxn) (*DBSchemaVersion, error) {
+	item, err := txn.Get(Prefixes.PrefixDBSchemaVersion)
+	if err == ErrKVKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetDBSchemaVersionWithTxn: Problem reading schema version")
+	}
+	versionBytes, err := item.ValueCopy(nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "DbGetDBSchemaVersionWithTxn: Problem copying schema version")
+	}
+	version := &DBSchemaVersion{}
+	if err = version.FromBytes(bytes.NewReader(versionBytes)); err != nil {
+		return nil, errors.Wrapf(err, "DbGetDBSchemaVersionWithTxn: ")
+	}
+	return version, nil
+}
+
+func DbGetDBSchemaVersion(handle KVStore) (*DBSchemaVersion, error) {
+	var version *DBSchemaVersion
+	err := handle.View(func(txn KVTxn) error {
+		var err error
+		version, err = DbGetDBSchemaVersionWithTxn(txn)
+		return err
+	})
+	return version, err
+}
+
+func DbPutDBSchemaVersionWithTxn(txn KVTxn, version *DBSchemaVersion) error {
+	return txn.Set(Prefixes.PrefixDBSchemaVersion, version.ToBytes())
+}
+
+// _dbIsEmpty returns true if the db doesn't have any records.
+func _dbIsEmpty(handle KVStore) bool {
+	isEmpty := true
+	handle.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Seek([]byte{})
+		isEmpty = !it.Valid()
+		return nil
+	})
+	return isEmpty
+}
+
+// InitDBSchemaVersion makes sure the db has a schema version and returns it. A new db starts at
+// the latest version since there's nothing to migrate. A db that was created before schema
+// versions existed starts at version zero.
+func InitDBSchemaVersion(handle KVStore, migrations []*DBSchemaMigration) (*DBSchemaVersion, error) {
+	version, err := DbGetDBSchemaVersion(handle)
+	if err != nil {
+		return nil, err
+	}
+	if version != nil {
+		return version, nil
+	}
+
+	version = &DBSchemaVersion{}
+	if _dbIsEmpty(handle) {
+		version.Version = GetLatestDBSchemaVersion(migrations)
+	}
+	err = handle.Update(func(txn KVTxn) error {
+		return DbPutDBSchemaVersionWithTxn(txn, version)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "InitDBSchemaVersion: Problem saving schema version")
+	}
+	return version, nil
+}
+
+// GetPendingDBSchemaMigrations returns the steps that haven't been applied to the db yet.
+func GetPendingDBSchemaMigrations(handle KVStore, migrations []*DBSchemaMigration) (
+	_pending []*DBSchemaMigration, _version *DBSchemaVersion, _err error) {
+
+	if err := ValidateDBSchemaMigrations(migrations); err != nil {
+		return nil, nil, err
+	}
+	version, err := InitDBSchemaVersion(handle, migrations)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version.Version > GetLatestDBSchemaVersion(migrations) {
+		return nil, nil, fmt.Errorf("GetPendingDBSchemaMigrations: The db has schema version (%v) but this "+
+			"node only knows about versions up to (%v). Was the db created by a newer version of the node?",
+			version.Version, GetLatestDBSchemaVersion(migrations))
+	}
+	return migrations[version.Version:], version, nil
+}
+
+// RunDBSchemaMigrations applies all the pending steps to the db. If backupPath is set, a backup
+// of the db is written there before the first step runs. A step that was interrupted before is
+// resumed where it left off, in which case no new backup is taken because the db is already
+// partially migrated. The node must not be running other operations on the db at the same time.
+func RunDBSchemaMigrations(handle KVStore, migrations []*DBSchemaMigration, backupPath string) error {
+	pending, version, err := GetPendingDBSchemaMigrations(handle, migrations)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	glog.Infof("RunDBSchemaMigrations: Migrating the db from schema version (%v) to (%v)",
+		version.Version, GetLatestDBSchemaVersion(migrations))
+
+	if backupPath != "" && version.InProgressVersion == 0 {
+		if err = BackupDBBeforeSchemaMigration(handle, backupPath); err != nil {
+			return err
+		}
+	}
+
+	for _, migration := range pending {
+		if err = _runDBSchemaMigration(handle, migration); err != nil {
+			return errors.Wrapf(err, "RunDBSchemaMigrations: Problem running migration (%v)", migration.Name)
+		}
+	}
+	return nil
+}
+
+// BackupDBBeforeSchemaMigration writes a full backup of the db to backupPath. The backup can be
+// loaded into an empty db with badger's restore tooling.
+func BackupDBBeforeSchemaMigration(handle KVStore, backupPath string) error {
+	badgerStore, ok := handle.(*BadgerKVStore)
+	if !ok {
+		return fmt.Errorf("BackupDBBeforeSchemaMigration: Backups are only supported for Badger dbs")
+	}
+
+	backupFile, err := os.OpenFile(backupPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "BackupDBBeforeSchemaMigration: Problem creating backup file (%v)", backupPath)
+	}
+	defer backupFile.Close()
+
+	glog.Infof("BackupDBBeforeSchemaMigration: Writing a backup of the db to (%v)", backupPath)
+	if _, err = badgerStore.BadgerDB().Backup(backupFile, 0); err != nil {
+		return errors.Wrapf(err, "BackupDBBeforeSchemaMigration: Problem writing backup")
+	}
+	if err = backupFile.Sync(); err != nil {
+		return errors.Wrapf(err, "BackupDBBeforeSchemaMigration: Problem syncing backup")
+	}
+	glog.Infof("BackupDBBeforeSchemaMigration: Finished writing the backup")
+	return nil
+}
+
+func _runDBSchemaMigration(handle KVStore, migration *DBSchemaMigration) error {
+	version, err := DbGetDBSchemaVersion(handle)
+	if err != nil {
+		return err
+	}
+	if version == nil || version.Version+1 != migration.Version {
+		return fmt.Errorf("_runDBSchemaMigration: The db isn't at the version before (%v)", migration.Version)
+	}
+
+	// Resume the step if it was interrupted, otherwise start from the beginning.
+	if version.InProgressVersion != migration.Version {
+		version.InProgressVersion = migration.Version
+		version.InProgressLastKey = nil
+		version.InProgressEntries = 0
+		glog.Infof("_runDBSchemaMigration: Starting migration (%v) to schema version (%v)",
+			migration.Name, migration.Version)
+	} else {
+		glog.Infof("_runDBSchemaMigration: Resuming migration (%v) to schema version (%v) after (%v) "+
+			"entries", migration.Name, migration.Version, version.InProgressEntries)
+	}
+
+	// Skip the prefixes that were finished before the step was interrupted.
+	resumePrefixIndex := 0
+	for ii, prefix := range migration.Prefixes {
+		if len(version.InProgressLastKey) > 0 && bytes.HasPrefix(version.InProgressLastKey, prefix) {
+			resumePrefixIndex = ii
+			break
+		}
+	}
+	for _, prefix := range migration.Prefixes[resumePrefixIndex:] {
+		for {
+			numEntries, err := _runDBSchemaMigrationBatch(handle, migration, prefix, version)
+			if err != nil {
+				return err
+			}
+			if numEntries == 0 {
+				break
+			}
+			glog.Infof("_runDBSchemaMigration: Migration (%v) transformed (%v) entries so far",
+				migration.Name, version.InProgressEntries)
+		}
+	}
+
+	// Mark the step as applied.
+	version.Version = migration.Version
+	version.InProgressVersion = 0
+	version.InProgressLastKey = nil
+	version.InProgressEntries = 0
+	err = handle.Update(func(txn KVTxn) error {
+		return DbPutDBSchemaVersionWithTxn(txn, version)
+	})
+	if err != nil {
+		return errors.Wrapf(err, "_runDBSchemaMigration: Problem saving schema version")
+	}
+	glog.Infof("_runDBSchemaMigration: Finished migration (%v), the db is at schema version (%v)",
+		migration.Name, migration.Version)
+	return nil
+}
+
+// _runDBSchemaMigrationBatch transforms the next batch of records under the prefix and saves the
+// progress in the same transaction. It returns the number of records in the batch, which is zero
+// once the prefix is done. The passed version is updated to the saved progress.
+func _runDBSchemaMigrationBatch(handle KVStore, migration *DBSchemaMigration, prefix []byte,
+	version *DBSchemaVersion) (_numEntries int, _err error) {
+
+	var newVersion DBSchemaVersion
+	numEntries := 0
+	err := handle.Update(func(txn KVTxn) error {
+		newVersion = *version
+
+		// Collect the batch first so we don't write under the iterator.
+		var keys, values [][]byte
+		opts := DefaultKVIteratorOptions
+		opts.Prefix = prefix
+		it := txn.NewIterator(opts)
+		startKey := prefix
+		if bytes.HasPrefix(version.InProgressLastKey, prefix) {
+			startKey = version.InProgressLastKey
+		}
+		for it.Seek(startKey); it.ValidForPrefix(prefix) && len(keys) < DBSchemaMigrationBatchSize; it.Next() {
+			key := it.Item().KeyCopy(nil)
+			if bytes.Equal(key, version.InProgressLastKey) {
+				continue
+			}
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				it.Close()
+				return err
+			}
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		it.Close()
+
+		for ii := range keys {
+			newKey, newValue, err := migration.MigrateEntry(keys[ii], values[ii])
+			if err != nil {
+				return errors.Wrapf(err, "Problem migrating key (%v)", keys[ii])
+			}
+			if newValue != nil && !bytes.Equal(newKey, keys[ii]) {
+				for _, migrationPrefix := range migration.Prefixes {
+					if bytes.HasPrefix(newKey, migrationPrefix) {
+						return fmt.Errorf("Key (%v) was moved to (%v), which is under the migrated "+
+							"prefix (%v)", keys[ii], newKey, migrationPrefix)
+					}
+				}
+			}
+
+			if newValue == nil || !bytes.Equal(newKey, keys[ii]) {
+				if err = txn.Delete(keys[ii]); err != nil {
+					return err
+				}
+			}
+			if newValue != nil {
+				if err = txn.Set(newKey, newValue); err != nil {
+					return err
+				}
+			}
+		}
+
+		numEntries = len(keys)
+		if numEntries == 0 {
+			return nil
+		}
+		newVersion.InProgressLastKey = keys[len(keys)-1]
+		newVersion.InProgressEntries += uint64(numEntries)
+		return DbPutDBSchemaVersionWithTxn(txn, &newVersion)
+	})
+	if err != nil {
+		return 0, errors.Wrapf(err, "_runDBSchemaMigrationBatch: ")
+	}
+	if numEntries > 0 {
+		*version = newVersion
+	}
+	return numEntries, nil
+}
@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBSchemaMigrations(t *testing.T) {
+	require := require.New(t)
+
+	prefixA := []byte{200}
+	prefixB := []byte{201}
+	prefixC := []byte{202}
+	makeKey := func(prefix []byte, ii int) []byte {
+		return append(append([]byte{}, prefix...), []byte(fmt.Sprintf("%06d", ii))...)
+	}
+	getValue := func(db KVStore, key []byte) []byte {
+		var value []byte
+		require.NoError(db.View(func(txn KVTxn) error {
+			item, err := txn.Get(key)
+			if err != nil {
+				return nil
+			}
+			value, err = item.ValueCopy(nil)
+			return err
+		}))
+		return value
+	}
+
+	// The first step rewrites the values under prefixA and fails once halfway through. The second
+	// step moves the even records under prefixB to prefixC and deletes the odd ones.
+	numEntries := 2*DBSchemaMigrationBatchSize + 500
+	failAfter := DBSchemaMigrationBatchSize + 200
+	numCalls := 0
+	migrations := []*DBSchemaMigration{
+		{
+			Version:  1,
+			Name:     "rewrite-a",
+			Prefixes: [][]byte{prefixA},
+			MigrateEntry: func(key []byte, value []byte) ([]byte, []byte, error) {
+				numCalls++
+				if numCalls == failAfter {
+					return nil, nil, fmt.Errorf("interrupted")
+				}
+				return key, append(value, []byte("-v1")...), nil
+			},
+		},
+		{
+			Version:  2,
+			Name:     "move-b",
+			Prefixes: [][]byte{prefixB},
+			MigrateEntry: func(key []byte, value []byte) ([]byte, []byte, error) {
+				if value[len(value)-1]%2 == 1 {
+					return nil, nil, nil
+				}
+				return append(append([]byte{}, prefixC...), key[1:]...), value, nil
+			},
+		},
+	}
+
+	// A new db starts at the latest version.
+	{
+		db := NewMemoryKVStore()
+		pending, version, err := GetPendingDBSchemaMigrations(db, migrations)
+		require.NoError(err)
+		require.Len(pending, 0)
+		require.Equal(uint64(2), version.Version)
+
+		// A node that doesn't know about the latest version refuses to touch the db.
+		_, _, err = GetPendingDBSchemaMigrations(db, migrations[:1])
+		require.Error(err)
+	}
+
+	// An existing db without a schema version starts at zero.
+	db := NewMemoryKVStore()
+	require.NoError(db.Update(func(txn KVTxn) error {
+		for ii := 0; ii < numEntries; ii++ {
+			if err := txn.Set(makeKey(prefixA, ii), []byte(fmt.Sprintf("%d", ii))); err != nil {
+				return err
+			}
+		}
+		for ii := 0; ii < 10; ii++ {
+			if err := txn.Set(makeKey(prefixB, ii), []byte(fmt.Sprintf("%d", ii))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+	pending, version, err := GetPendingDBSchemaMigrations(db, migrations)
+	require.NoError(err)
+	require.Len(pending, 2)
+	require.Equal(uint64(0), version.Version)
+
+	// The first run gets interrupted after the first batch was committed.
+	require.Error(RunDBSchemaMigrations(db, migrations, ""))
+	version, err = DbGetDBSchemaVersion(db)
+	require.NoError(err)
+	require.Equal(uint64(0), version.Version)
+	require.Equal(uint64(1), version.InProgressVersion)
+	require.Equal(uint64(DBSchemaMigrationBatchSize), version.InProgressEntries)
+
+	// The second run resumes and every record is migrated exactly once.
+	require.NoError(RunDBSchemaMigrations(db, migrations, ""))
+	version, err = DbGetDBSchemaVersion(db)
+	require.NoError(err)
+	require.Equal(&DBSchemaVersion{Version: 2}, version)
+	for ii := 0; ii < numEntries; ii++ {
+		require.Equal([]byte(fmt.Sprintf("%d-v1", ii)), getValue(db, makeKey(prefixA, ii)))
+	}
+	for ii := 0; ii < 10; ii++ {
+		require.Nil(getValue(db, makeKey(prefixB, ii)))
+		if ii%2 == 0 {
+			require.True(bytes.Equal([]byte(fmt.Sprintf("%d", ii)), getValue(db, makeKey(prefixC, ii))))
+		} else {
+			require.Nil(getValue(db, makeKey(prefixC, ii)))
+		}
+	}
+
+	// Nothing is left to do.
+	pending, _, err = GetPendingDBSchemaMigrations(db, migrations)
+	require.NoError(err)
+	require.Len(pending, 0)
+
+	// Steps have to be numbered consecutively.
+	require.Error(ValidateDBSchemaMigrations([]*DBSchemaMigration{migrations[1]}))
+}
@@ -37,6 +37,9 @@ const (
 	// of a node when making major updates. Not having this structure would
 	// require node operators like Coinbase to change their --data-dir flag when
 	// deploying a non-backwards-compatible version of the node.
+	//
+	// Prefer adding a DBSchemaMigration over bumping this string. Migrations
+	// upgrade the existing database in place rather than requiring a resync.
 	DBVersionString = "v-00000"
 )
 
@@ -322,7 +325,12 @@ type DBPrefixes struct {
 	PrefixDAOCoinLimitOrder                 []byte `prefix_id:"[60]" is_state:"true"`
 	PrefixDAOCoinLimitOrderByTransactorPKID []byte `prefix_id:"[61]" is_state:"true"`
 	PrefixDAOCoinLimitOrderByOrderID        []byte `prefix_id:"[62]" is_state:"true"`
-	// NEXT_TAG: 63
+
+	// The schema version of the database, along with the progress of the schema migration
+	// that's currently running, if any. See DBSchemaMigration.
+	// <prefix_id> -> <DBSchemaVersion>
+	PrefixDBSchemaVersion []byte `prefix_id:"[63]"`
+	// NEXT_TAG: 64
 }
 
 // StatePrefixToDeSoEncoder maps each state prefix to a DeSoEncoder type that is stored under that prefix.
@@ -479,6 +487,9 @@ func StatePrefixToDeSoEncoder(prefix []byte) (_isEncoder bool, _encoder DeSoEnco
 	} else if bytes.Equal(prefix, Prefixes.PrefixDAOCoinLimitOrderByOrderID) {
 		// prefix_id:"[62]"
 		return true, &DAOCoinLimitOrderEntry{}
+	} else if isRegistered, isEncoder, encoder := _dbIndexedEntryTypePrefixToDeSoEncoder(prefix); isRegistered {
+		// Prefixes of entry types registered with RegisterDBIndexedEntryType.
+		return isEncoder, encoder
 	}
 
 	return true, nil
@@ -651,7 +662,7 @@ func EncodeKeyAndValueForChecksum(key []byte, value []byte, blockHeight uint64)
 // DBSetWithTxn is a wrapper around BadgerDB Set function which allows us to add computation
 // prior to DB writes. In particular, we use it to maintain a dynamic LRU cache, compute the
 // state checksum, and to build DB snapshots with ancestral records.
-func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) error {
+func DBSetWithTxn(txn KVTxn, snap *Snapshot, key []byte, value []byte) error {
 	// We only cache / update ancestral records when we're dealing with state prefix.
 	isState := snap != nil && snap.isState(key)
 	var ancestralValue []byte
@@ -665,7 +676,7 @@ func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) err
 		ancestralValue, getError = DBGetWithTxn(txn, snap, key)
 
 		// If there is some error with the DB read, other than non-existent key, we return.
-		if getError != nil && getError != badger.ErrKeyNotFound {
+		if getError != nil && getError != ErrKVKeyNotFound {
 			return errors.Wrapf(getError, "DBSetWithTxn: problem reading record "+
 				"from DB with key: %v", key)
 		}
@@ -683,7 +694,7 @@ func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) err
 		keyString := hex.EncodeToString(key)
 
 		// Update ancestral record structures depending on the existing DB record.
-		if err := snap.PrepareAncestralRecord(keyString, ancestralValue, getError != badger.ErrKeyNotFound); err != nil {
+		if err := snap.PrepareAncestralRecord(keyString, ancestralValue, getError != ErrKVKeyNotFound); err != nil {
 			return errors.Wrapf(err, "DBSetWithTxn: Problem preparing ancestral record")
 		}
 		// Now save the newest record to cache.
@@ -706,7 +717,7 @@ func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) err
 // the DB entry associated with the given key and handles the logic around the LRU cache.
 // Whenever we read/write records in the DB, we place a copy in the LRU cache to save
 // us lookup time.
-func DBGetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) ([]byte, error) {
+func DBGetWithTxn(txn KVTxn, snap *Snapshot, key []byte) ([]byte, error) {
 	// We only cache / update ancestral records when we're dealing with state prefix.
 	isState := snap != nil && snap.isState(key)
 	keyString := hex.EncodeToString(key)
@@ -740,9 +751,40 @@ func DBGetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) ([]byte, error) {
 	return itemData, nil
 }
 
+// DBMultiGet fetches the values for all of the passed keys in a single read transaction. The
+// keys are visited in sorted order so that neighbouring records are read from the same
+// tables and blocks, which is considerably cheaper than the same number of random point
+// reads. Keys that aren't in the DB are left out of the returned map.
+func DBMultiGet(handle KVStore, snap *Snapshot, keys [][]byte) (map[string][]byte, error) {
+	sortedKeys := make([][]byte, len(keys))
+	copy(sortedKeys, keys)
+	sort.Slice(sortedKeys, func(ii, jj int) bool {
+		return bytes.Compare(sortedKeys[ii], sortedKeys[jj]) < 0
+	})
+
+	values := make(map[string][]byte, len(sortedKeys))
+	err := handle.View(func(txn KVTxn) error {
+		for _, key := range sortedKeys {
+			value, err := DBGetWithTxn(txn, snap, key)
+			if err == ErrKVKeyNotFound {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "DBMultiGet: Problem fetching key %v", key)
+			}
+			values[string(key)] = value
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
 // DBDeleteWithTxn is a wrapper function around BadgerDB delete function.
 // It allows us to update the snapshot LRU cache, checksum, and ancestral records.
-func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
+func DBDeleteWithTxn(txn KVTxn, snap *Snapshot, key []byte) error {
 	var ancestralValue []byte
 	var getError error
 	isState := snap != nil && snap.isState(key)
@@ -754,7 +796,7 @@ func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
 		// Otherwise, we fetch the current value of this record from the DB.
 		ancestralValue, getError = DBGetWithTxn(txn, snap, key)
 		// If the key doesn't exist then there is no point in deleting this entry.
-		if getError == badger.ErrKeyNotFound {
+		if getError == ErrKVKeyNotFound {
 			return nil
 		}
 
@@ -795,14 +837,14 @@ func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
 // If the startKey is a valid key in the db, it will be the first entry in the returned dbEntries.
 // If we have exhausted all entries for a prefix then _isChunkFull will be set as false, and true otherwise,
 // when there are more entries in the db at the prefix.
-func DBIteratePrefixKeys(db *badger.DB, prefix []byte, startKey []byte, targetBytes uint32) (
+func DBIteratePrefixKeys(db KVStore, prefix []byte, startKey []byte, targetBytes uint32) (
 	_dbEntries []*DBEntry, _isChunkFull bool, _err error) {
 	var dbEntries []*DBEntry
 	var totalBytes int
 	var isChunkFull bool
 
-	err := db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := db.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
 
 		// Iterate over the prefix as long as there are valid keys in the DB.
 		it := txn.NewIterator(opts)
@@ -837,7 +879,7 @@ func DBIteratePrefixKeys(db *badger.DB, prefix []byte, startKey []byte, targetBy
 // DBDeleteAllStateRecords is an auxiliary function that is used to clean up the state
 // before starting hyper sync. _shouldErase = true is returned when it is faster to use
 // os.RemoveAll(dbDir) instead of deleting records manually.
-func DBDeleteAllStateRecords(db *badger.DB) (_shouldErase bool, _error error) {
+func DBDeleteAllStateRecords(db KVStore) (_shouldErase bool, _error error) {
 	maxKeys := 10000
 	shouldErase := false
 
@@ -861,10 +903,9 @@ func DBDeleteAllStateRecords(db *badger.DB) (_shouldErase bool, _error error) {
 			}
 			var isChunkFull bool
 			var keys [][]byte
-			err := db.View(func(txn *badger.Txn) error {
+			err := db.View(func(txn KVTxn) error {
 				totalKeys := 0
-				opts := badger.DefaultIteratorOptions
-				opts.AllVersions = false
+				opts := DefaultKVIteratorOptions
 				opts.PrefetchValues = false
 				// Iterate over the prefix as long as there are valid keys in the DB.
 				it := txn.NewIterator(opts)
@@ -887,7 +928,7 @@ func DBDeleteAllStateRecords(db *badger.DB) (_shouldErase bool, _error error) {
 			glog.V(1).Infof("DeleteAllStateRecords: Deleting prefix: (%v) with total of (%v) "+
 				"entries", prefix, len(keys))
 			// Now delete all these keys.
-			err = db.Update(func(txn *badger.Txn) error {
+			err = db.Update(func(txn KVTxn) error {
 				for _, key := range keys {
 					err := txn.Delete(key)
 					if err != nil {
@@ -908,7 +949,7 @@ func DBDeleteAllStateRecords(db *badger.DB) (_shouldErase bool, _error error) {
 // DB Controllers
 // -------------------------------------------------------------------------------------
 
-func DBGetPKIDEntryForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) *PKIDEntry {
+func DBGetPKIDEntryForPublicKeyWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte) *PKIDEntry {
 	if len(publicKey) == 0 {
 		return nil
 	}
@@ -935,16 +976,16 @@ func DBGetPKIDEntryForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKe
 	return pkidEntryObj
 }
 
-func DBGetPKIDEntryForPublicKey(db *badger.DB, snap *Snapshot, publicKey []byte) *PKIDEntry {
+func DBGetPKIDEntryForPublicKey(db KVStore, snap *Snapshot, publicKey []byte) *PKIDEntry {
 	var pkid *PKIDEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		pkid = DBGetPKIDEntryForPublicKeyWithTxn(txn, snap, publicKey)
 		return nil
 	})
 	return pkid
 }
 
-func DBGetPublicKeyForPKIDWithTxn(txn *badger.Txn, snap *Snapshot, pkidd *PKID) []byte {
+func DBGetPublicKeyForPKIDWithTxn(txn KVTxn, snap *Snapshot, pkidd *PKID) []byte {
 	prefix := append([]byte{}, Prefixes.PrefixPKIDToPublicKey...)
 	pkidBytes, err := DBGetWithTxn(txn, snap, append(prefix, pkidd[:]...))
 
@@ -961,16 +1002,16 @@ func DBGetPublicKeyForPKIDWithTxn(txn *badger.Txn, snap *Snapshot, pkidd *PKID)
 	return pkidBytes
 }
 
-func DBGetPublicKeyForPKID(db *badger.DB, snap *Snapshot, pkidd *PKID) []byte {
+func DBGetPublicKeyForPKID(db KVStore, snap *Snapshot, pkidd *PKID) []byte {
 	var publicKey []byte
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		publicKey = DBGetPublicKeyForPKIDWithTxn(txn, snap, pkidd)
 		return nil
 	})
 	return publicKey
 }
 
-func DBPutPKIDMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutPKIDMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	publicKey []byte, pkidEntry *PKIDEntry, params *DeSoParams) error {
 
 	// If the PKID entry is identical to the public key, there's no point in saving it in the DB.
@@ -1006,7 +1047,7 @@ func DBPutPKIDMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint6
 	return nil
 }
 
-func DBDeletePKIDMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeletePKIDMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	publicKey []byte, params *DeSoParams) error {
 
 	// Look up the pkid for the public key.
@@ -1037,16 +1078,16 @@ func DBDeletePKIDMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func EnumerateKeysForPrefix(db *badger.DB, dbPrefix []byte) (_keysFound [][]byte, _valsFound [][]byte) {
+func EnumerateKeysForPrefix(db KVStore, dbPrefix []byte) (_keysFound [][]byte, _valsFound [][]byte) {
 	return _enumerateKeysForPrefix(db, dbPrefix)
 }
 
 // A helper function to enumerate all of the values for a particular prefix.
-func _enumerateKeysForPrefix(db *badger.DB, dbPrefix []byte) (_keysFound [][]byte, _valsFound [][]byte) {
+func _enumerateKeysForPrefix(db KVStore, dbPrefix []byte) (_keysFound [][]byte, _valsFound [][]byte) {
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	dbErr := db.View(func(txn *badger.Txn) error {
+	dbErr := db.View(func(txn KVTxn) error {
 		var err error
 		keysFound, valsFound, err = _enumerateKeysForPrefixWithTxn(txn, dbPrefix)
 		if err != nil {
@@ -1062,11 +1103,11 @@ func _enumerateKeysForPrefix(db *badger.DB, dbPrefix []byte) (_keysFound [][]byt
 	return keysFound, valsFound
 }
 
-func _enumerateKeysForPrefixWithTxn(txn *badger.Txn, dbPrefix []byte) (_keysFound [][]byte, _valsFound [][]byte, _err error) {
+func _enumerateKeysForPrefixWithTxn(txn KVTxn, dbPrefix []byte) (_keysFound [][]byte, _valsFound [][]byte, _err error) {
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	opts := badger.DefaultIteratorOptions
+	opts := DefaultKVIteratorOptions
 	nodeIterator := txn.NewIterator(opts)
 	defer nodeIterator.Close()
 	prefix := dbPrefix
@@ -1086,11 +1127,11 @@ func _enumerateKeysForPrefixWithTxn(txn *badger.Txn, dbPrefix []byte) (_keysFoun
 }
 
 // A helper function to enumerate a limited number of the values for a particular prefix.
-func _enumerateLimitedKeysReversedForPrefix(db *badger.DB, dbPrefix []byte, limit uint64) (_keysFound [][]byte, _valsFound [][]byte) {
+func _enumerateLimitedKeysReversedForPrefix(db KVStore, dbPrefix []byte, limit uint64) (_keysFound [][]byte, _valsFound [][]byte) {
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	dbErr := db.View(func(txn *badger.Txn) error {
+	dbErr := db.View(func(txn KVTxn) error {
 		var err error
 		keysFound, valsFound, err = _enumerateLimitedKeysReversedForPrefixWithTxn(txn, dbPrefix, limit)
 		return err
@@ -1103,11 +1144,11 @@ func _enumerateLimitedKeysReversedForPrefix(db *badger.DB, dbPrefix []byte, limi
 	return keysFound, valsFound
 }
 
-func _enumerateLimitedKeysReversedForPrefixWithTxn(txn *badger.Txn, dbPrefix []byte, limit uint64) (_keysFound [][]byte, _valsFound [][]byte, _err error) {
+func _enumerateLimitedKeysReversedForPrefixWithTxn(txn KVTxn, dbPrefix []byte, limit uint64) (_keysFound [][]byte, _valsFound [][]byte, _err error) {
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	opts := badger.DefaultIteratorOptions
+	opts := DefaultKVIteratorOptions
 
 	// Go in reverse order
 	opts.Reverse = true
@@ -1152,14 +1193,14 @@ func DbGetPrefixForPublicKeyToDesoBalanceNanos() []byte {
 	return append([]byte{}, Prefixes.PrefixPublicKeyToDeSoBalanceNanos...)
 }
 
-func DbGetDeSoBalanceNanosForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte,
+func DbGetDeSoBalanceNanosForPublicKeyWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte,
 ) (_balance uint64, _err error) {
 
 	key := _dbKeyForPublicKeyToDeSoBalanceNanos(publicKey)
 
 	desoBalanceBytes, err := DBGetWithTxn(txn, snap, key)
 	// If balance hasn't been set before, then we would error with key not found.
-	if err == badger.ErrKeyNotFound {
+	if err == ErrKVKeyNotFound {
 		return uint64(0), nil
 	}
 	if err != nil {
@@ -1173,10 +1214,10 @@ func DbGetDeSoBalanceNanosForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, p
 	return desoBalance, nil
 }
 
-func DbGetDeSoBalanceNanosForPublicKey(db *badger.DB, snap *Snapshot, publicKey []byte,
+func DbGetDeSoBalanceNanosForPublicKey(db KVStore, snap *Snapshot, publicKey []byte,
 ) (_balance uint64, _err error) {
 	ret := uint64(0)
-	dbErr := db.View(func(txn *badger.Txn) error {
+	dbErr := db.View(func(txn KVTxn) error {
 		var err error
 		ret, err = DbGetDeSoBalanceNanosForPublicKeyWithTxn(txn, snap, publicKey)
 		if err != nil {
@@ -1190,7 +1231,7 @@ func DbGetDeSoBalanceNanosForPublicKey(db *badger.DB, snap *Snapshot, publicKey
 	return ret, nil
 }
 
-func DbPutDeSoBalanceForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbPutDeSoBalanceForPublicKeyWithTxn(txn KVTxn, snap *Snapshot,
 	publicKey []byte, balanceNanos uint64) error {
 
 	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
@@ -1210,15 +1251,15 @@ func DbPutDeSoBalanceForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DbPutDeSoBalanceForPublicKey(handle *badger.DB, snap *Snapshot,
+func DbPutDeSoBalanceForPublicKey(handle KVStore, snap *Snapshot,
 	publicKey []byte, balanceNanos uint64) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutDeSoBalanceForPublicKeyWithTxn(txn, snap, publicKey, balanceNanos)
 	})
 }
 
-func DbDeletePublicKeyToDeSoBalanceWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) error {
+func DbDeletePublicKeyToDeSoBalanceWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte) error {
 
 	if err := DBDeleteWithTxn(txn, snap, _dbKeyForPublicKeyToDeSoBalanceNanos(publicKey)); err != nil {
 		return errors.Wrapf(err, "DbDeletePublicKeyToDeSoBalanceWithTxn: Problem deleting "+
@@ -1228,8 +1269,8 @@ func DbDeletePublicKeyToDeSoBalanceWithTxn(txn *badger.Txn, snap *Snapshot, publ
 	return nil
 }
 
-func DbDeletePublicKeyToDeSoBalance(handle *badger.DB, snap *Snapshot, publicKey []byte) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func DbDeletePublicKeyToDeSoBalance(handle KVStore, snap *Snapshot, publicKey []byte) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeletePublicKeyToDeSoBalanceWithTxn(txn, snap, publicKey)
 	})
 }
@@ -1254,7 +1295,7 @@ func _dbSeekPrefixForMessagePublicKey(publicKey []byte) []byte {
 }
 
 // Note that this adds a mapping for the sender *and* the recipient.
-func DBPutMessageEntryWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutMessageEntryWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	messageKey MessageKey, messageEntry *MessageEntry) error {
 
 	if err := IsByteArrayValidPublicKey(messageEntry.SenderPublicKey[:]); err != nil {
@@ -1279,15 +1320,15 @@ func DBPutMessageEntryWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint6
 	return nil
 }
 
-func DBPutMessageEntry(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutMessageEntry(handle KVStore, snap *Snapshot, blockHeight uint64,
 	messageKey MessageKey, messageEntry *MessageEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutMessageEntryWithTxn(txn, snap, blockHeight, messageKey, messageEntry)
 	})
 }
 
-func DBGetMessageEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetMessageEntryWithTxn(txn KVTxn, snap *Snapshot,
 	publicKey []byte, tstampNanos uint64) *MessageEntry {
 
 	key := _dbKeyForMessageEntry(publicKey, tstampNanos)
@@ -1302,11 +1343,11 @@ func DBGetMessageEntryWithTxn(txn *badger.Txn, snap *Snapshot,
 	return privateMessageObj
 }
 
-func DBGetMessageEntry(db *badger.DB, snap *Snapshot,
+func DBGetMessageEntry(db KVStore, snap *Snapshot,
 	publicKey []byte, tstampNanos uint64) *MessageEntry {
 
 	var ret *MessageEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetMessageEntryWithTxn(txn, snap, publicKey, tstampNanos)
 		return nil
 	})
@@ -1315,7 +1356,7 @@ func DBGetMessageEntry(db *badger.DB, snap *Snapshot,
 
 // Note this deletes the message for the sender *and* receiver since a mapping
 // should exist for each.
-func DBDeleteMessageEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteMessageEntryMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	publicKey []byte, tstampNanos uint64) error {
 
 	// First pull up the mapping that exists for the public key passed in.
@@ -1335,15 +1376,15 @@ func DBDeleteMessageEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DBDeleteMessageEntryMappings(handle *badger.DB, snap *Snapshot,
+func DBDeleteMessageEntryMappings(handle KVStore, snap *Snapshot,
 	publicKey []byte, tstampNanos uint64) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteMessageEntryMappingsWithTxn(txn, snap, publicKey, tstampNanos)
 	})
 }
 
-func DBGetMessageEntriesForPublicKey(handle *badger.DB, publicKey []byte) (
+func DBGetMessageEntriesForPublicKey(handle KVStore, publicKey []byte) (
 	_privateMessages []*MessageEntry, _err error) {
 
 	// Setting the prefix to a tstamp of zero should return all the messages
@@ -1371,7 +1412,7 @@ func DBGetMessageEntriesForPublicKey(handle *badger.DB, publicKey []byte) (
 }
 
 func _enumerateLimitedMessagesForMessagingKeysReversedWithTxn(
-	txn *badger.Txn, messagingGroupEntries []*MessagingGroupEntry,
+	txn KVTxn, messagingGroupEntries []*MessagingGroupEntry,
 	limit uint64) (_privateMessages []*MessageEntry, _err error) {
 
 	// Users can have many messaging keys. By default, a users has the base messaging key, which
@@ -1390,9 +1431,9 @@ func _enumerateLimitedMessagesForMessagingKeysReversedWithTxn(
 	}
 
 	// Initialize all iterators, add the 0xff byte to the seek prefix so that we can iterate backwards.
-	var messagingIterators []*badger.Iterator
+	var messagingIterators []KVIterator
 	for _, prefix := range prefixes {
-		opts := badger.DefaultIteratorOptions
+		opts := DefaultKVIteratorOptions
 		opts.Reverse = true
 		iterator := txn.NewIterator(opts)
 		iterator.Seek(append(prefix, 0xff))
@@ -1454,7 +1495,7 @@ func _enumerateLimitedMessagesForMessagingKeysReversedWithTxn(
 	return privateMessages, nil
 }
 
-func DBGetLimitedMessageForMessagingKeys(handle *badger.DB, messagingKeys []*MessagingGroupEntry, limit uint64) (
+func DBGetLimitedMessageForMessagingKeys(handle KVStore, messagingKeys []*MessagingGroupEntry, limit uint64) (
 	_privateMessages []*MessageEntry, _err error) {
 
 	// Setting the prefix to a tstamp of zero should return all the messages
@@ -1465,7 +1506,7 @@ func DBGetLimitedMessageForMessagingKeys(handle *badger.DB, messagingKeys []*Mes
 	// Limit the number of keys to speed up load times.
 	// Get all user messaging keys.
 
-	err := handle.Update(func(txn *badger.Txn) error {
+	err := handle.Update(func(txn KVTxn) error {
 		var err error
 		_privateMessages, err = _enumerateLimitedMessagesForMessagingKeysReversedWithTxn(txn, messagingKeys, limit)
 		if err != nil {
@@ -1498,7 +1539,7 @@ func _dbSeekPrefixForMessagingGroupEntry(ownerPublicKey *PublicKey) []byte {
 	return append(prefixCopy, ownerPublicKey[:]...)
 }
 
-func DBPutMessagingGroupEntryWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutMessagingGroupEntryWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	ownerPublicKey *PublicKey, messagingGroupEntry *MessagingGroupEntry) error {
 
 	messagingKey := &MessagingGroupKey{
@@ -1512,15 +1553,15 @@ func DBPutMessagingGroupEntryWithTxn(txn *badger.Txn, snap *Snapshot, blockHeigh
 	return nil
 }
 
-func DBPutMessagingGroupEntry(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutMessagingGroupEntry(handle KVStore, snap *Snapshot, blockHeight uint64,
 	ownerPublicKey *PublicKey, messagingGroupEntry *MessagingGroupEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutMessagingGroupEntryWithTxn(txn, snap, blockHeight, ownerPublicKey, messagingGroupEntry)
 	})
 }
 
-func DBGetMessagingGroupEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetMessagingGroupEntryWithTxn(txn KVTxn, snap *Snapshot,
 	messagingGroupKey *MessagingGroupKey) *MessagingGroupEntry {
 
 	key := _dbKeyForMessagingGroupEntry(messagingGroupKey)
@@ -1535,17 +1576,17 @@ func DBGetMessagingGroupEntryWithTxn(txn *badger.Txn, snap *Snapshot,
 	return messagingGroupEntry
 }
 
-func DBGetMessagingGroupEntry(db *badger.DB, snap *Snapshot,
+func DBGetMessagingGroupEntry(db KVStore, snap *Snapshot,
 	messagingGroupKey *MessagingGroupKey) *MessagingGroupEntry {
 	var ret *MessagingGroupEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetMessagingGroupEntryWithTxn(txn, snap, messagingGroupKey)
 		return nil
 	})
 	return ret
 }
 
-func DBDeleteMessagingGroupEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteMessagingGroupEntryWithTxn(txn KVTxn, snap *Snapshot,
 	messagingGroupKey *MessagingGroupKey) error {
 
 	// First pull up the entry that exists for the messaging key.
@@ -1563,14 +1604,14 @@ func DBDeleteMessagingGroupEntryWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DBDeleteMessagingGroupEntry(handle *badger.DB, snap *Snapshot,
+func DBDeleteMessagingGroupEntry(handle KVStore, snap *Snapshot,
 	messagingGroupKey *MessagingGroupKey) error {
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteMessagingGroupEntryWithTxn(txn, snap, messagingGroupKey)
 	})
 }
 
-func DBGetMessagingGroupEntriesForOwnerWithTxn(txn *badger.Txn, ownerPublicKey *PublicKey) (
+func DBGetMessagingGroupEntriesForOwnerWithTxn(txn KVTxn, ownerPublicKey *PublicKey) (
 	_messagingKeyEntries []*MessagingGroupEntry, _err error) {
 
 	// Setting the prefix to owner's public key will allow us to fetch all messaging keys
@@ -1598,7 +1639,7 @@ func DBGetMessagingGroupEntriesForOwnerWithTxn(txn *badger.Txn, ownerPublicKey *
 	return messagingKeyEntries, nil
 }
 
-func DBGetAllUserGroupEntiresWithTxn(txn *badger.Txn, ownerPublicKey []byte) ([]*MessagingGroupEntry, error) {
+func DBGetAllUserGroupEntiresWithTxn(txn KVTxn, ownerPublicKey []byte) ([]*MessagingGroupEntry, error) {
 	// This function fetches all MessagingGroupEntries for the user from the DB. This includes the
 	// base entry, the owner group entries, and the member group entries.
 
@@ -1629,11 +1670,11 @@ func DBGetAllUserGroupEntiresWithTxn(txn *badger.Txn, ownerPublicKey []byte) ([]
 	return userGroupEntries, nil
 }
 
-func DBGetAllUserGroupEntries(handle *badger.DB, ownerPublicKey []byte) ([]*MessagingGroupEntry, error) {
+func DBGetAllUserGroupEntries(handle KVStore, ownerPublicKey []byte) ([]*MessagingGroupEntry, error) {
 	var err error
 	var messagingGroupEntries []*MessagingGroupEntry
 
-	err = handle.View(func(txn *badger.Txn) error {
+	err = handle.View(func(txn KVTxn) error {
 		messagingGroupEntries, err = DBGetAllUserGroupEntiresWithTxn(txn, ownerPublicKey)
 		return err
 	})
@@ -1660,7 +1701,7 @@ func _dbSeekPrefixForMessagingGroupMember(memberPublicKey *PublicKey) []byte {
 	return append(prefixCopy, memberPublicKey[:]...)
 }
 
-func DBPutMessagingGroupMemberWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutMessagingGroupMemberWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	messagingGroupMember *MessagingGroupMember, groupOwnerPublicKey *PublicKey,
 	messagingGroupEntry *MessagingGroupEntry) error {
 	// Sanity-check that public keys have the correct length.
@@ -1695,15 +1736,15 @@ func DBPutMessagingGroupMemberWithTxn(txn *badger.Txn, snap *Snapshot, blockHeig
 	return nil
 }
 
-func DBPutMessagingGroupMember(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutMessagingGroupMember(handle KVStore, snap *Snapshot, blockHeight uint64,
 	messagingGroupMember *MessagingGroupMember, ownerPublicKey *PublicKey, messagingGroupEntry *MessagingGroupEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutMessagingGroupMemberWithTxn(txn, snap, blockHeight, messagingGroupMember, ownerPublicKey, messagingGroupEntry)
 	})
 }
 
-func DBGetMessagingGroupMemberWithTxn(txn *badger.Txn, snap *Snapshot, messagingGroupMember *MessagingGroupMember,
+func DBGetMessagingGroupMemberWithTxn(txn KVTxn, snap *Snapshot, messagingGroupMember *MessagingGroupMember,
 	messagingGroupEntry *MessagingGroupEntry) *MessagingGroupEntry {
 
 	key := _dbKeyForMessagingGroupMember(
@@ -1721,18 +1762,18 @@ func DBGetMessagingGroupMemberWithTxn(txn *badger.Txn, snap *Snapshot, messaging
 	return messagingGroupMemberEntry
 }
 
-func DBGetMessagingMember(db *badger.DB, snap *Snapshot, messagingMember *MessagingGroupMember,
+func DBGetMessagingMember(db KVStore, snap *Snapshot, messagingMember *MessagingGroupMember,
 	messagingGroupEntry *MessagingGroupEntry) *MessagingGroupEntry {
 
 	var ret *MessagingGroupEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetMessagingGroupMemberWithTxn(txn, snap, messagingMember, messagingGroupEntry)
 		return nil
 	})
 	return ret
 }
 
-func DBGetAllMessagingGroupEntriesForMemberWithTxn(txn *badger.Txn, ownerPublicKey *PublicKey) (
+func DBGetAllMessagingGroupEntriesForMemberWithTxn(txn KVTxn, ownerPublicKey *PublicKey) (
 	[]*MessagingGroupEntry, error) {
 
 	// This function is used to fetch all messaging
@@ -1760,7 +1801,7 @@ func DBGetAllMessagingGroupEntriesForMemberWithTxn(txn *badger.Txn, ownerPublicK
 
 // Note this deletes the message for the sender *and* receiver since a mapping
 // should exist for each.
-func DBDeleteMessagingGroupMemberMappingWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteMessagingGroupMemberMappingWithTxn(txn KVTxn, snap *Snapshot,
 	messagingGroupMember *MessagingGroupMember, messagingGroupEntry *MessagingGroupEntry) error {
 
 	// First pull up the mapping that exists for the public key passed in.
@@ -1782,10 +1823,10 @@ func DBDeleteMessagingGroupMemberMappingWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DBDeleteMessagingGroupMemberMappings(handle *badger.DB, snap *Snapshot,
+func DBDeleteMessagingGroupMemberMappings(handle KVStore, snap *Snapshot,
 	messagingGroupMember *MessagingGroupMember, messagingGroupEntry *MessagingGroupEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteMessagingGroupMemberMappingWithTxn(txn, snap, messagingGroupMember, messagingGroupEntry)
 	})
 }
@@ -1802,7 +1843,7 @@ func _dbKeyForForbiddenBlockSignaturePubKeys(publicKey []byte) []byte {
 	return key
 }
 
-func DbPutForbiddenBlockSignaturePubKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) error {
+func DbPutForbiddenBlockSignaturePubKeyWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte) error {
 
 	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
 		return fmt.Errorf("DbPutForbiddenBlockSignaturePubKeyWithTxn: Forbidden public key "+
@@ -1816,14 +1857,14 @@ func DbPutForbiddenBlockSignaturePubKeyWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DbPutForbiddenBlockSignaturePubKey(handle *badger.DB, snap *Snapshot, publicKey []byte) error {
+func DbPutForbiddenBlockSignaturePubKey(handle KVStore, snap *Snapshot, publicKey []byte) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutForbiddenBlockSignaturePubKeyWithTxn(txn, snap, publicKey)
 	})
 }
 
-func DbGetForbiddenBlockSignaturePubKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) []byte {
+func DbGetForbiddenBlockSignaturePubKeyWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte) []byte {
 
 	key := _dbKeyForForbiddenBlockSignaturePubKeys(publicKey)
 	_, err := DBGetWithTxn(txn, snap, key)
@@ -1836,9 +1877,9 @@ func DbGetForbiddenBlockSignaturePubKeyWithTxn(txn *badger.Txn, snap *Snapshot,
 	return []byte{}
 }
 
-func DbGetForbiddenBlockSignaturePubKey(db *badger.DB, snap *Snapshot, publicKey []byte) []byte {
+func DbGetForbiddenBlockSignaturePubKey(db KVStore, snap *Snapshot, publicKey []byte) []byte {
 	var ret []byte
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DbGetForbiddenBlockSignaturePubKeyWithTxn(txn, snap, publicKey)
 		return nil
 	})
@@ -1846,7 +1887,7 @@ func DbGetForbiddenBlockSignaturePubKey(db *badger.DB, snap *Snapshot, publicKey
 }
 
 func DbDeleteForbiddenBlockSignaturePubKeyWithTxn(
-	txn *badger.Txn, snap *Snapshot, publicKey []byte) error {
+	txn KVTxn, snap *Snapshot, publicKey []byte) error {
 
 	existingEntry := DbGetForbiddenBlockSignaturePubKeyWithTxn(txn, snap, publicKey)
 	if existingEntry == nil {
@@ -1862,9 +1903,9 @@ func DbDeleteForbiddenBlockSignaturePubKeyWithTxn(
 }
 
 func DbDeleteForbiddenBlockSignaturePubKey(
-	handle *badger.DB, snap *Snapshot, publicKey []byte) error {
+	handle KVStore, snap *Snapshot, publicKey []byte) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeleteForbiddenBlockSignaturePubKeyWithTxn(txn, snap, publicKey)
 	})
 }
@@ -1906,7 +1947,7 @@ func _dbSeekPrefixForLikerPubKeysLikingAPostHash(likedPostHash BlockHash) []byte
 }
 
 // Note that this adds a mapping for the user *and* the liked post.
-func DbPutLikeMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbPutLikeMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	userPubKey []byte, likedPostHash BlockHash) error {
 
 	if len(userPubKey) != btcec.PubKeyBytesLenCompressed {
@@ -1930,15 +1971,15 @@ func DbPutLikeMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DbPutLikeMappings(handle *badger.DB, snap *Snapshot,
+func DbPutLikeMappings(handle KVStore, snap *Snapshot,
 	userPubKey []byte, likedPostHash BlockHash) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutLikeMappingsWithTxn(txn, snap, userPubKey, likedPostHash)
 	})
 }
 
-func DbGetLikerPubKeyToLikedPostHashMappingWithTxn(txn *badger.Txn,
+func DbGetLikerPubKeyToLikedPostHashMappingWithTxn(txn KVTxn,
 	snap *Snapshot, userPubKey []byte, likedPostHash BlockHash) []byte {
 
 	key := _dbKeyForLikerPubKeyToLikedPostHashMapping(userPubKey, likedPostHash)
@@ -1953,9 +1994,9 @@ func DbGetLikerPubKeyToLikedPostHashMappingWithTxn(txn *badger.Txn,
 }
 
 func DbGetLikerPubKeyToLikedPostHashMapping(
-	db *badger.DB, snap *Snapshot, userPubKey []byte, likedPostHash BlockHash) []byte {
+	db KVStore, snap *Snapshot, userPubKey []byte, likedPostHash BlockHash) []byte {
 	var ret []byte
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DbGetLikerPubKeyToLikedPostHashMappingWithTxn(txn, snap, userPubKey, likedPostHash)
 		return nil
 	})
@@ -1964,7 +2005,7 @@ func DbGetLikerPubKeyToLikedPostHashMapping(
 
 // Note this deletes the like for the user *and* the liked post since a mapping
 // should exist for each.
-func DbDeleteLikeMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbDeleteLikeMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	userPubKey []byte, likedPostHash BlockHash) error {
 
 	// First check that a mapping exists. If one doesn't exist then there's nothing to do.
@@ -1991,15 +2032,15 @@ func DbDeleteLikeMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DbDeleteLikeMappings(handle *badger.DB, snap *Snapshot,
+func DbDeleteLikeMappings(handle KVStore, snap *Snapshot,
 	userPubKey []byte, likedPostHash BlockHash) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeleteLikeMappingsWithTxn(txn, snap, userPubKey, likedPostHash)
 	})
 }
 
-func DbGetPostHashesYouLike(handle *badger.DB, yourPublicKey []byte) (
+func DbGetPostHashesYouLike(handle KVStore, yourPublicKey []byte) (
 	_postHashes []*BlockHash, _err error) {
 
 	prefix := _dbSeekPrefixForPostHashesYouLike(yourPublicKey)
@@ -2016,7 +2057,7 @@ func DbGetPostHashesYouLike(handle *badger.DB, yourPublicKey []byte) (
 	return postHashesYouLike, nil
 }
 
-func DbGetLikerPubKeysLikingAPostHash(handle *badger.DB, likedPostHash BlockHash) (
+func DbGetLikerPubKeysLikingAPostHash(handle KVStore, likedPostHash BlockHash) (
 	_pubKeys [][]byte, _err error) {
 
 	prefix := _dbSeekPrefixForLikerPubKeysLikingAPostHash(likedPostHash)
@@ -2097,7 +2138,7 @@ func _dbKeyForRepostedPostHashReposterPubKeyRepostPostHash(
 }
 
 // Note that this adds a mapping for the user *and* the reposted post.
-func DbPutRepostMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DbPutRepostMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	repostEntry RepostEntry) error {
 
 	if len(repostEntry.ReposterPubKey) != btcec.PubKeyBytesLenCompressed {
@@ -2121,15 +2162,15 @@ func DbPutRepostMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uin
 	return nil
 }
 
-func DbPutRepostMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DbPutRepostMappings(handle KVStore, snap *Snapshot, blockHeight uint64,
 	userPubKey []byte, repostedPostHash BlockHash, repostEntry RepostEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutRepostMappingsWithTxn(txn, snap, blockHeight, repostEntry)
 	})
 }
 
-func DbGetReposterPubKeyRepostedPostHashToRepostEntryWithTxn(txn *badger.Txn,
+func DbGetReposterPubKeyRepostedPostHashToRepostEntryWithTxn(txn KVTxn,
 	snap *Snapshot, userPubKey []byte, repostedPostHash BlockHash) *RepostEntry {
 
 	key := _dbSeekKeyForReposterPubKeyRepostedPostHashToRepostPostHash(userPubKey, repostedPostHash)
@@ -2146,11 +2187,11 @@ func DbGetReposterPubKeyRepostedPostHashToRepostEntryWithTxn(txn *badger.Txn,
 	return nil
 }
 
-func DbReposterPubKeyRepostedPostHashToRepostEntry(db *badger.DB,
+func DbReposterPubKeyRepostedPostHashToRepostEntry(db KVStore,
 	snap *Snapshot, userPubKey []byte, repostedPostHash BlockHash) *RepostEntry {
 
 	var ret *RepostEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DbGetReposterPubKeyRepostedPostHashToRepostEntryWithTxn(txn, snap, userPubKey, repostedPostHash)
 		return nil
 	})
@@ -2159,7 +2200,7 @@ func DbReposterPubKeyRepostedPostHashToRepostEntry(db *badger.DB,
 
 // Note this deletes the repost for the user *and* the reposted post since a mapping
 // should exist for each.
-func DbDeleteRepostMappingsWithTxn(txn *badger.Txn, snap *Snapshot, repostEntry RepostEntry) error {
+func DbDeleteRepostMappingsWithTxn(txn KVTxn, snap *Snapshot, repostEntry RepostEntry) error {
 
 	// First check that a mapping exists. If one doesn't exist then there's nothing to do.
 	_, err := DBGetWithTxn(txn, snap, _dbKeyForReposterPubKeyRepostedPostHashToRepostPostHash(
@@ -2178,7 +2219,7 @@ func DbDeleteRepostMappingsWithTxn(txn *badger.Txn, snap *Snapshot, repostEntry
 	return nil
 }
 
-func DbDeleteAllRepostMappingsWithTxn(txn *badger.Txn, snap *Snapshot, userPubKey []byte, repostedPostHash BlockHash) error {
+func DbDeleteAllRepostMappingsWithTxn(txn KVTxn, snap *Snapshot, userPubKey []byte, repostedPostHash BlockHash) error {
 
 	key := _dbSeekKeyForReposterPubKeyRepostedPostHashToRepostPostHash(userPubKey, repostedPostHash)
 	keysFound, _, err := _enumerateKeysForPrefixWithTxn(txn, key)
@@ -2194,7 +2235,7 @@ func DbDeleteAllRepostMappingsWithTxn(txn *badger.Txn, snap *Snapshot, userPubKe
 	return nil
 }
 
-func DbGetPostHashesYouRepost(handle *badger.DB, yourPublicKey []byte) (
+func DbGetPostHashesYouRepost(handle KVStore, yourPublicKey []byte) (
 	_postHashes []*BlockHash, _err error) {
 
 	prefix := _dbSeekPrefixForPostHashesYouRepost(yourPublicKey)
@@ -2248,7 +2289,7 @@ func _dbSeekPrefixForPKIDsFollowingYou(yourPKID *PKID) []byte {
 }
 
 // Note that this adds a mapping for the follower *and* the pub key being followed.
-func DbPutFollowMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbPutFollowMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	followerPKID *PKID, followedPKID *PKID) error {
 
 	if len(followerPKID) != btcec.PubKeyBytesLenCompressed {
@@ -2276,15 +2317,15 @@ func DbPutFollowMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DbPutFollowMappings(handle *badger.DB, snap *Snapshot,
+func DbPutFollowMappings(handle KVStore, snap *Snapshot,
 	followerPKID *PKID, followedPKID *PKID) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutFollowMappingsWithTxn(txn, snap, followerPKID, followedPKID)
 	})
 }
 
-func DbGetFollowerToFollowedMappingWithTxn(txn *badger.Txn,
+func DbGetFollowerToFollowedMappingWithTxn(txn KVTxn,
 	snap *Snapshot, followerPKID *PKID, followedPKID *PKID) []byte {
 
 	key := _dbKeyForFollowerToFollowedMapping(followerPKID, followedPKID)
@@ -2298,11 +2339,11 @@ func DbGetFollowerToFollowedMappingWithTxn(txn *badger.Txn,
 	return []byte{}
 }
 
-func DbGetFollowerToFollowedMapping(db *badger.DB, snap *Snapshot,
+func DbGetFollowerToFollowedMapping(db KVStore, snap *Snapshot,
 	followerPKID *PKID, followedPKID *PKID) []byte {
 
 	var ret []byte
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DbGetFollowerToFollowedMappingWithTxn(txn, snap, followerPKID, followedPKID)
 		return nil
 	})
@@ -2311,7 +2352,7 @@ func DbGetFollowerToFollowedMapping(db *badger.DB, snap *Snapshot,
 
 // Note this deletes the follow for the follower *and* followed since a mapping
 // should exist for each.
-func DbDeleteFollowMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbDeleteFollowMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	followerPKID *PKID, followedPKID *PKID) error {
 
 	// First check that a mapping exists for the PKIDs passed in.
@@ -2337,15 +2378,15 @@ func DbDeleteFollowMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DbDeleteFollowMappings(handle *badger.DB, snap *Snapshot,
+func DbDeleteFollowMappings(handle KVStore, snap *Snapshot,
 	followerPKID *PKID, followedPKID *PKID) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeleteFollowMappingsWithTxn(txn, snap, followerPKID, followedPKID)
 	})
 }
 
-func DbGetPKIDsYouFollow(handle *badger.DB, yourPKID *PKID) (
+func DbGetPKIDsYouFollow(handle KVStore, yourPKID *PKID) (
 	_pkids []*PKID, _err error) {
 
 	prefix := _dbSeekPrefixForPKIDsYouFollow(yourPKID)
@@ -2363,7 +2404,7 @@ func DbGetPKIDsYouFollow(handle *badger.DB, yourPKID *PKID) (
 	return pkidsYouFollow, nil
 }
 
-func DbGetPKIDsFollowingYou(handle *badger.DB, yourPKID *PKID) (
+func DbGetPKIDsFollowingYou(handle KVStore, yourPKID *PKID) (
 	_pkids []*PKID, _err error) {
 
 	prefix := _dbSeekPrefixForPKIDsFollowingYou(yourPKID)
@@ -2381,7 +2422,7 @@ func DbGetPKIDsFollowingYou(handle *badger.DB, yourPKID *PKID) (
 	return pkidsFollowingYou, nil
 }
 
-func DbGetPubKeysYouFollow(handle *badger.DB, snap *Snapshot, yourPubKey []byte) (
+func DbGetPubKeysYouFollow(handle KVStore, snap *Snapshot, yourPubKey []byte) (
 	_pubKeys [][]byte, _err error) {
 
 	// Get the PKID for the pub key
@@ -2402,7 +2443,7 @@ func DbGetPubKeysYouFollow(handle *badger.DB, snap *Snapshot, yourPubKey []byte)
 	return followPubKeys, nil
 }
 
-func DbGetPubKeysFollowingYou(handle *badger.DB, snap *Snapshot, yourPubKey []byte) (
+func DbGetPubKeysFollowingYou(handle KVStore, snap *Snapshot, yourPubKey []byte) (
 	_pubKeys [][]byte, _err error) {
 
 	// Get the PKID for the pub key
@@ -2495,7 +2536,7 @@ func _dbSeekPrefixForReceiverPKIDAndSenderPKID(receiverPKID *PKID, senderPKID *P
 	return append(key, senderPKID[:]...)
 }
 
-func DbPutDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DbPutDiamondMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	diamondEntry *DiamondEntry) error {
 
 	if len(diamondEntry.ReceiverPKID) != btcec.PubKeyBytesLenCompressed {
@@ -2526,15 +2567,15 @@ func DbPutDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight ui
 	return nil
 }
 
-func DbPutDiamondMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DbPutDiamondMappings(handle KVStore, snap *Snapshot, blockHeight uint64,
 	diamondEntry *DiamondEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutDiamondMappingsWithTxn(txn, snap, blockHeight, diamondEntry)
 	})
 }
 
-func DbGetDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, diamondReceiverPKID *PKID,
+func DbGetDiamondMappingsWithTxn(txn KVTxn, snap *Snapshot, diamondReceiverPKID *PKID,
 	diamondSenderPKID *PKID, diamondPostHash *BlockHash) *DiamondEntry {
 
 	key := _dbKeyForDiamondReceiverToDiamondSenderMappingWithoutEntry(
@@ -2553,11 +2594,11 @@ func DbGetDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, diamondReceive
 	return diamondEntry
 }
 
-func DbGetDiamondMappings(db *badger.DB, snap *Snapshot, diamondReceiverPKID *PKID,
+func DbGetDiamondMappings(db KVStore, snap *Snapshot, diamondReceiverPKID *PKID,
 	diamondSenderPKID *PKID, diamondPostHash *BlockHash) *DiamondEntry {
 
 	var ret *DiamondEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DbGetDiamondMappingsWithTxn(
 			txn, snap, diamondReceiverPKID, diamondSenderPKID, diamondPostHash)
 		return nil
@@ -2565,7 +2606,7 @@ func DbGetDiamondMappings(db *badger.DB, snap *Snapshot, diamondReceiverPKID *PK
 	return ret
 }
 
-func DbDeleteDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, diamondEntry *DiamondEntry) error {
+func DbDeleteDiamondMappingsWithTxn(txn KVTxn, snap *Snapshot, diamondEntry *DiamondEntry) error {
 
 	// First check that a mapping exists for the PKIDs passed in.
 	// If one doesn't exist then there's nothing to do.
@@ -2606,15 +2647,15 @@ func DbDeleteDiamondMappingsWithTxn(txn *badger.Txn, snap *Snapshot, diamondEntr
 	return nil
 }
 
-func DbDeleteDiamondMappings(handle *badger.DB, snap *Snapshot, diamondEntry *DiamondEntry) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func DbDeleteDiamondMappings(handle KVStore, snap *Snapshot, diamondEntry *DiamondEntry) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeleteDiamondMappingsWithTxn(txn, snap, diamondEntry)
 	})
 }
 
 // This function returns a map of PKIDs that gave diamonds to a list of DiamondEntrys
 // that contain post hashes.
-func DbGetPKIDsThatDiamondedYouMap(handle *badger.DB, yourPKID *PKID, fetchYouDiamonded bool) (
+func DbGetPKIDsThatDiamondedYouMap(handle KVStore, yourPKID *PKID, fetchYouDiamonded bool) (
 	_pkidToDiamondsMap map[PKID][]*DiamondEntry, _err error) {
 
 	prefix := _dbSeekPrefixForPKIDsThatDiamondedYou(yourPKID)
@@ -2699,7 +2740,7 @@ func DbGetPKIDsThatDiamondedYouMap(handle *badger.DB, yourPKID *PKID, fetchYouDi
 }
 
 // This function returns a list of DiamondEntrys given by giverPKID to receiverPKID that contain post hashes.
-func DbGetDiamondEntriesForSenderToReceiver(handle *badger.DB, receiverPKID *PKID, senderPKID *PKID) (
+func DbGetDiamondEntriesForSenderToReceiver(handle KVStore, receiverPKID *PKID, senderPKID *PKID) (
 	_diamondEntries []*DiamondEntry, _err error) {
 
 	prefix := _dbSeekPrefixForReceiverPKIDAndSenderPKID(receiverPKID, senderPKID)
@@ -2767,11 +2808,11 @@ func _keyForBitcoinBurnTxID(bitcoinBurnTxID *BlockHash) []byte {
 	return append(prefixCopy, bitcoinBurnTxID[:]...)
 }
 
-func DbPutBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurnTxID *BlockHash) error {
+func DbPutBitcoinBurnTxIDWithTxn(txn KVTxn, snap *Snapshot, bitcoinBurnTxID *BlockHash) error {
 	return DBSetWithTxn(txn, snap, _keyForBitcoinBurnTxID(bitcoinBurnTxID), []byte{})
 }
 
-func DbExistsBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
+func DbExistsBitcoinBurnTxIDWithTxn(txn KVTxn, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
 	// We don't care about the value because we're just checking to see if the key exists.
 	if _, err := DBGetWithTxn(txn, snap, _keyForBitcoinBurnTxID(bitcoinBurnTxID)); err != nil {
 		return false
@@ -2779,20 +2820,20 @@ func DbExistsBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurn
 	return true
 }
 
-func DbExistsBitcoinBurnTxID(db *badger.DB, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
+func DbExistsBitcoinBurnTxID(db KVStore, snap *Snapshot, bitcoinBurnTxID *BlockHash) bool {
 	var exists bool
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		exists = DbExistsBitcoinBurnTxIDWithTxn(txn, snap, bitcoinBurnTxID)
 		return nil
 	})
 	return exists
 }
 
-func DbDeleteBitcoinBurnTxIDWithTxn(txn *badger.Txn, snap *Snapshot, bitcoinBurnTxID *BlockHash) error {
+func DbDeleteBitcoinBurnTxIDWithTxn(txn KVTxn, snap *Snapshot, bitcoinBurnTxID *BlockHash) error {
 	return DBDeleteWithTxn(txn, snap, _keyForBitcoinBurnTxID(bitcoinBurnTxID))
 }
 
-func DbGetAllBitcoinBurnTxIDs(handle *badger.DB) (_bitcoinBurnTxIDs []*BlockHash) {
+func DbGetAllBitcoinBurnTxIDs(handle KVStore) (_bitcoinBurnTxIDs []*BlockHash) {
 	keysFound, _ := _enumerateKeysForPrefix(handle, Prefixes.PrefixBitcoinBurnTxIDs)
 	bitcoinBurnTxIDs := []*BlockHash{}
 	for _, key := range keysFound {
@@ -2804,7 +2845,7 @@ func DbGetAllBitcoinBurnTxIDs(handle *badger.DB) (_bitcoinBurnTxIDs []*BlockHash
 	return bitcoinBurnTxIDs
 }
 
-func _getBlockHashForPrefixWithTxn(txn *badger.Txn, snap *Snapshot, prefix []byte) *BlockHash {
+func _getBlockHashForPrefixWithTxn(txn KVTxn, snap *Snapshot, prefix []byte) *BlockHash {
 	blockHash, err := DBGetWithTxn(txn, snap, prefix)
 	if err != nil {
 		return nil
@@ -2813,9 +2854,9 @@ func _getBlockHashForPrefixWithTxn(txn *badger.Txn, snap *Snapshot, prefix []byt
 	return NewBlockHash(blockHash)
 }
 
-func _getBlockHashForPrefix(handle *badger.DB, snap *Snapshot, prefix []byte) *BlockHash {
+func _getBlockHashForPrefix(handle KVStore, snap *Snapshot, prefix []byte) *BlockHash {
 	var ret *BlockHash
-	err := handle.View(func(txn *badger.Txn) error {
+	err := handle.View(func(txn KVTxn) error {
 		ret = _getBlockHashForPrefixWithTxn(txn, snap, prefix)
 		return nil
 	})
@@ -2850,17 +2891,17 @@ func DecodeUint64(scoreBytes []byte) uint64 {
 	return binary.BigEndian.Uint64(scoreBytes)
 }
 
-func DbPutNanosPurchasedWithTxn(txn *badger.Txn, snap *Snapshot, nanosPurchased uint64) error {
+func DbPutNanosPurchasedWithTxn(txn KVTxn, snap *Snapshot, nanosPurchased uint64) error {
 	return DBSetWithTxn(txn, snap, Prefixes.PrefixNanosPurchased, EncodeUint64(nanosPurchased))
 }
 
-func DbPutNanosPurchased(handle *badger.DB, snap *Snapshot, nanosPurchased uint64) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func DbPutNanosPurchased(handle KVStore, snap *Snapshot, nanosPurchased uint64) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutNanosPurchasedWithTxn(txn, snap, nanosPurchased)
 	})
 }
 
-func DbGetNanosPurchasedWithTxn(txn *badger.Txn, snap *Snapshot) uint64 {
+func DbGetNanosPurchasedWithTxn(txn KVTxn, snap *Snapshot) uint64 {
 	nanosPurchasedBytes, err := DBGetWithTxn(txn, snap, Prefixes.PrefixNanosPurchased)
 	if err != nil {
 		return 0
@@ -2869,9 +2910,9 @@ func DbGetNanosPurchasedWithTxn(txn *badger.Txn, snap *Snapshot) uint64 {
 	return DecodeUint64(nanosPurchasedBytes)
 }
 
-func DbGetNanosPurchased(handle *badger.DB, snap *Snapshot) uint64 {
+func DbGetNanosPurchased(handle KVStore, snap *Snapshot) uint64 {
 	var nanosPurchased uint64
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		nanosPurchased = DbGetNanosPurchasedWithTxn(txn, snap)
 		return nil
 	})
@@ -2879,15 +2920,15 @@ func DbGetNanosPurchased(handle *badger.DB, snap *Snapshot) uint64 {
 	return nanosPurchased
 }
 
-func DbPutGlobalParamsEntry(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DbPutGlobalParamsEntry(handle KVStore, snap *Snapshot, blockHeight uint64,
 	globalParamsEntry GlobalParamsEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutGlobalParamsEntryWithTxn(txn, snap, blockHeight, globalParamsEntry)
 	})
 }
 
-func DbPutGlobalParamsEntryWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DbPutGlobalParamsEntryWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	globalParamsEntry GlobalParamsEntry) error {
 
 	err := DBSetWithTxn(txn, snap, Prefixes.PrefixGlobalParams, EncodeToBytes(blockHeight, &globalParamsEntry))
@@ -2897,7 +2938,7 @@ func DbPutGlobalParamsEntryWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight
 	return nil
 }
 
-func DbGetGlobalParamsEntryWithTxn(txn *badger.Txn, snap *Snapshot) *GlobalParamsEntry {
+func DbGetGlobalParamsEntryWithTxn(txn KVTxn, snap *Snapshot) *GlobalParamsEntry {
 	globalParamsEntryBytes, err := DBGetWithTxn(txn, snap, Prefixes.PrefixGlobalParams)
 	if err != nil {
 		return &InitialGlobalParamsEntry
@@ -2909,23 +2950,23 @@ func DbGetGlobalParamsEntryWithTxn(txn *badger.Txn, snap *Snapshot) *GlobalParam
 	return globalParamsEntryObj
 }
 
-func DbGetGlobalParamsEntry(handle *badger.DB, snap *Snapshot) *GlobalParamsEntry {
+func DbGetGlobalParamsEntry(handle KVStore, snap *Snapshot) *GlobalParamsEntry {
 	var globalParamsEntry *GlobalParamsEntry
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		globalParamsEntry = DbGetGlobalParamsEntryWithTxn(txn, snap)
 		return nil
 	})
 	return globalParamsEntry
 }
 
-func DbPutUSDCentsPerBitcoinExchangeRateWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbPutUSDCentsPerBitcoinExchangeRateWithTxn(txn KVTxn, snap *Snapshot,
 	usdCentsPerBitcoinExchangeRate uint64) error {
 
 	return DBSetWithTxn(txn, snap, Prefixes.PrefixUSDCentsPerBitcoinExchangeRate,
 		EncodeUint64(usdCentsPerBitcoinExchangeRate))
 }
 
-func DbGetUSDCentsPerBitcoinExchangeRateWithTxn(txn *badger.Txn, snap *Snapshot) uint64 {
+func DbGetUSDCentsPerBitcoinExchangeRateWithTxn(txn KVTxn, snap *Snapshot) uint64 {
 	usdCentsPerBitcoinExchangeRateBytes, err := DBGetWithTxn(txn, snap, Prefixes.PrefixUSDCentsPerBitcoinExchangeRate)
 	if err != nil {
 		return InitialUSDCentsPerBitcoinExchangeRate
@@ -2934,9 +2975,9 @@ func DbGetUSDCentsPerBitcoinExchangeRateWithTxn(txn *badger.Txn, snap *Snapshot)
 	return DecodeUint64(usdCentsPerBitcoinExchangeRateBytes)
 }
 
-func DbGetUSDCentsPerBitcoinExchangeRate(handle *badger.DB, snap *Snapshot) uint64 {
+func DbGetUSDCentsPerBitcoinExchangeRate(handle KVStore, snap *Snapshot) uint64 {
 	var usdCentsPerBitcoinExchangeRate uint64
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		usdCentsPerBitcoinExchangeRate = DbGetUSDCentsPerBitcoinExchangeRateWithTxn(txn, snap)
 		return nil
 	})
@@ -2944,7 +2985,7 @@ func DbGetUSDCentsPerBitcoinExchangeRate(handle *badger.DB, snap *Snapshot) uint
 	return usdCentsPerBitcoinExchangeRate
 }
 
-func GetUtxoNumEntriesWithTxn(txn *badger.Txn, snap *Snapshot) uint64 {
+func GetUtxoNumEntriesWithTxn(txn KVTxn, snap *Snapshot) uint64 {
 	indexBytes, err := DBGetWithTxn(txn, snap, Prefixes.PrefixUtxoNumEntries)
 	if err != nil {
 		return 0
@@ -2953,9 +2994,9 @@ func GetUtxoNumEntriesWithTxn(txn *badger.Txn, snap *Snapshot) uint64 {
 	return DecodeUint64(indexBytes)
 }
 
-func GetUtxoNumEntries(handle *badger.DB, snap *Snapshot) uint64 {
+func GetUtxoNumEntries(handle KVStore, snap *Snapshot) uint64 {
 	var numEntries uint64
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		numEntries = GetUtxoNumEntriesWithTxn(txn, snap)
 		return nil
 	})
@@ -2991,17 +3032,17 @@ func _UtxoKeyFromDbKey(utxoDbKey []byte) *UtxoKey {
 	}
 }
 
-func PutUtxoNumEntriesWithTxn(txn *badger.Txn, snap *Snapshot, newNumEntries uint64) error {
+func PutUtxoNumEntriesWithTxn(txn KVTxn, snap *Snapshot, newNumEntries uint64) error {
 	return DBSetWithTxn(txn, snap, Prefixes.PrefixUtxoNumEntries, EncodeUint64(newNumEntries))
 }
 
-func PutUtxoEntryForUtxoKeyWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func PutUtxoEntryForUtxoKeyWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	utxoKey *UtxoKey, utxoEntry *UtxoEntry) error {
 
 	return DBSetWithTxn(txn, snap, _DbKeyForUtxoKey(utxoKey), EncodeToBytes(blockHeight, utxoEntry))
 }
 
-func DbGetUtxoEntryForUtxoKeyWithTxn(txn *badger.Txn, snap *Snapshot, utxoKey *UtxoKey) *UtxoEntry {
+func DbGetUtxoEntryForUtxoKeyWithTxn(txn KVTxn, snap *Snapshot, utxoKey *UtxoKey) *UtxoEntry {
 	utxoDbKey := _DbKeyForUtxoKey(utxoKey)
 	utxoEntryBytes, err := DBGetWithTxn(txn, snap, utxoDbKey)
 	if err != nil {
@@ -3014,9 +3055,9 @@ func DbGetUtxoEntryForUtxoKeyWithTxn(txn *badger.Txn, snap *Snapshot, utxoKey *U
 	return utxoEntry
 }
 
-func DbGetUtxoEntryForUtxoKey(handle *badger.DB, snap *Snapshot, utxoKey *UtxoKey) *UtxoEntry {
+func DbGetUtxoEntryForUtxoKey(handle KVStore, snap *Snapshot, utxoKey *UtxoKey) *UtxoEntry {
 	var ret *UtxoEntry
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		ret = DbGetUtxoEntryForUtxoKeyWithTxn(txn, snap, utxoKey)
 		return nil
 	})
@@ -3024,11 +3065,11 @@ func DbGetUtxoEntryForUtxoKey(handle *badger.DB, snap *Snapshot, utxoKey *UtxoKe
 	return ret
 }
 
-func DeleteUtxoEntryForKeyWithTxn(txn *badger.Txn, snap *Snapshot, utxoKey *UtxoKey) error {
+func DeleteUtxoEntryForKeyWithTxn(txn KVTxn, snap *Snapshot, utxoKey *UtxoKey) error {
 	return DBDeleteWithTxn(txn, snap, _DbKeyForUtxoKey(utxoKey))
 }
 
-func DeletePubKeyUtxoKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
+func DeletePubKeyUtxoKeyMappingWithTxn(txn KVTxn, snap *Snapshot,
 	publicKey []byte, utxoKey *UtxoKey) error {
 	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
 		return fmt.Errorf("DeletePubKeyUtxoKeyMappingWithTxn: Public key has improper length %d != %d", len(publicKey), btcec.PubKeyBytesLenCompressed)
@@ -3040,7 +3081,7 @@ func DeletePubKeyUtxoKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
 	return DBDeleteWithTxn(txn, snap, keyToDelete)
 }
 
-func PutPubKeyUtxoKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte, utxoKey *UtxoKey) error {
+func PutPubKeyUtxoKeyWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte, utxoKey *UtxoKey) error {
 	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
 		return fmt.Errorf("PutPubKeyUtxoKeyWithTxn: Public key has improper length %d != %d", len(publicKey), btcec.PubKeyBytesLenCompressed)
 	}
@@ -3054,7 +3095,7 @@ func PutPubKeyUtxoKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte,
 // DbGetUtxosForPubKey finds the UtxoEntry's corresponding to the public
 // key passed in. It also attaches the UtxoKeys to the UtxoEntry's it
 // returns for easy access.
-func DbGetUtxosForPubKey(publicKey []byte, handle *badger.DB, snap *Snapshot) ([]*UtxoEntry, error) {
+func DbGetUtxosForPubKey(publicKey []byte, handle KVStore, snap *Snapshot) ([]*UtxoEntry, error) {
 	// Verify the length of the public key.
 	if len(publicKey) != btcec.PubKeyBytesLenCompressed {
 		return nil, fmt.Errorf("DbGetUtxosForPubKey: Public key has improper "+
@@ -3062,10 +3103,10 @@ func DbGetUtxosForPubKey(publicKey []byte, handle *badger.DB, snap *Snapshot) ([
 	}
 	// Look up the utxo keys for this public key.
 	utxoEntriesFound := []*UtxoEntry{}
-	err := handle.View(func(txn *badger.Txn) error {
+	err := handle.View(func(txn KVTxn) error {
 		// Start by looping through to find all the UtxoKeys.
 		utxoKeysFound := []*UtxoKey{}
-		opts := badger.DefaultIteratorOptions
+		opts := DefaultKVIteratorOptions
 		nodeIterator := txn.NewIterator(opts)
 		defer nodeIterator.Close()
 		prefix := append(append([]byte{}, Prefixes.PrefixPubKeyUtxoKey...), publicKey...)
@@ -3114,7 +3155,7 @@ func DbGetUtxosForPubKey(publicKey []byte, handle *badger.DB, snap *Snapshot) ([
 	return utxoEntriesFound, nil
 }
 
-func DeleteUnmodifiedMappingsForUtxoWithTxn(txn *badger.Txn, snap *Snapshot, utxoKey *UtxoKey) error {
+func DeleteUnmodifiedMappingsForUtxoWithTxn(txn KVTxn, snap *Snapshot, utxoKey *UtxoKey) error {
 	// Get the entry for the utxoKey from the db.
 	utxoEntry := DbGetUtxoEntryForUtxoKeyWithTxn(txn, snap, utxoKey)
 	if utxoEntry == nil {
@@ -3138,7 +3179,7 @@ func DeleteUnmodifiedMappingsForUtxoWithTxn(txn *badger.Txn, snap *Snapshot, utx
 	return nil
 }
 
-func PutMappingsForUtxoWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func PutMappingsForUtxoWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	utxoKey *UtxoKey, utxoEntry *UtxoEntry) error {
 	// Put the <utxoKey -> utxoEntry> mapping.
 	if err := PutUtxoEntryForUtxoKeyWithTxn(txn, snap, blockHeight, utxoKey, utxoEntry); err != nil {
@@ -3157,7 +3198,7 @@ func _DbKeyForUtxoOps(blockHash *BlockHash) []byte {
 	return append(append([]byte{}, Prefixes.PrefixBlockHashToUtxoOperations...), blockHash[:]...)
 }
 
-func GetUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
+func GetUtxoOperationsForBlockWithTxn(txn KVTxn, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
 	utxoOpsBytes, err := DBGetWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash))
 	if err != nil {
 		return nil, err
@@ -3172,9 +3213,9 @@ func GetUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash
 	return utxoOpsBundle.UtxoOpBundle, nil
 }
 
-func GetUtxoOperationsForBlock(handle *badger.DB, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
+func GetUtxoOperationsForBlock(handle KVStore, snap *Snapshot, blockHash *BlockHash) ([][]*UtxoOperation, error) {
 	var ops [][]*UtxoOperation
-	err := handle.View(func(txn *badger.Txn) error {
+	err := handle.View(func(txn KVTxn) error {
 		var err error
 		ops, err = GetUtxoOperationsForBlockWithTxn(txn, snap, blockHash)
 		return err
@@ -3183,7 +3224,7 @@ func GetUtxoOperationsForBlock(handle *badger.DB, snap *Snapshot, blockHash *Blo
 	return ops, err
 }
 
-func PutUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func PutUtxoOperationsForBlockWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	blockHash *BlockHash, utxoOpsForBlock [][]*UtxoOperation) error {
 
 	opBundle := &UtxoOperationBundle{
@@ -3192,7 +3233,7 @@ func PutUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHeig
 	return DBSetWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash), EncodeToBytes(blockHeight, opBundle))
 }
 
-func DeleteUtxoOperationsForBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) error {
+func DeleteUtxoOperationsForBlockWithTxn(txn KVTxn, snap *Snapshot, blockHash *BlockHash) error {
 	return DBDeleteWithTxn(txn, snap, _DbKeyForUtxoOps(blockHash))
 }
 
@@ -3321,7 +3362,7 @@ func _prefixForChainType(chainType ChainType) []byte {
 	return prefix
 }
 
-func DbGetBestHash(handle *badger.DB, snap *Snapshot, chainType ChainType) *BlockHash {
+func DbGetBestHash(handle KVStore, snap *Snapshot, chainType ChainType) *BlockHash {
 	prefix := _prefixForChainType(chainType)
 	if len(prefix) == 0 {
 		glog.Errorf("DbGetBestHash: Problem getting prefix for ChainType: %d", chainType)
@@ -3330,7 +3371,7 @@ func DbGetBestHash(handle *badger.DB, snap *Snapshot, chainType ChainType) *Bloc
 	return _getBlockHashForPrefix(handle, snap, prefix)
 }
 
-func PutBestHashWithTxn(txn *badger.Txn, snap *Snapshot,
+func PutBestHashWithTxn(txn KVTxn, snap *Snapshot,
 	bh *BlockHash, chainType ChainType) error {
 
 	prefix := _prefixForChainType(chainType)
@@ -3341,8 +3382,8 @@ func PutBestHashWithTxn(txn *badger.Txn, snap *Snapshot,
 	return DBSetWithTxn(txn, snap, prefix, bh[:])
 }
 
-func PutBestHash(handle *badger.DB, snap *Snapshot, bh *BlockHash, chainType ChainType) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func PutBestHash(handle KVStore, snap *Snapshot, bh *BlockHash, chainType ChainType) error {
+	return handle.Update(func(txn KVTxn) error {
 		return PutBestHashWithTxn(txn, snap, bh, chainType)
 	})
 }
@@ -3359,7 +3400,7 @@ func PublicKeyBlockHashToBlockRewardKey(publicKey []byte, blockHash *BlockHash)
 	return key
 }
 
-func GetBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) *MsgDeSoBlock {
+func GetBlockWithTxn(txn KVTxn, snap *Snapshot, blockHash *BlockHash) *MsgDeSoBlock {
 	hashKey := BlockHashToBlockKey(blockHash)
 
 	blockBytes, err := DBGetWithTxn(txn, snap, hashKey)
@@ -3375,10 +3416,10 @@ func GetBlockWithTxn(txn *badger.Txn, snap *Snapshot, blockHash *BlockHash) *Msg
 	return blockRet
 }
 
-func GetBlock(blockHash *BlockHash, handle *badger.DB, snap *Snapshot) (*MsgDeSoBlock, error) {
+func GetBlock(blockHash *BlockHash, handle KVStore, snap *Snapshot) (*MsgDeSoBlock, error) {
 	hashKey := BlockHashToBlockKey(blockHash)
 	var blockRet *MsgDeSoBlock
-	err := handle.View(func(txn *badger.Txn) error {
+	err := handle.View(func(txn KVTxn) error {
 		blockBytes, err := DBGetWithTxn(txn, snap, hashKey)
 		if err != nil {
 			return err
@@ -3398,7 +3439,7 @@ func GetBlock(blockHash *BlockHash, handle *badger.DB, snap *Snapshot) (*MsgDeSo
 	return blockRet, nil
 }
 
-func PutBlockWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
+func PutBlockWithTxn(txn KVTxn, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
 	if desoBlock.Header == nil {
 		return fmt.Errorf("PutBlockWithTxn: Header was nil in block %v", desoBlock)
 	}
@@ -3452,8 +3493,8 @@ func PutBlockWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) e
 	return nil
 }
 
-func PutBlock(handle *badger.DB, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
-	err := handle.Update(func(txn *badger.Txn) error {
+func PutBlock(handle KVStore, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
+	err := handle.Update(func(txn KVTxn) error {
 		return PutBlockWithTxn(txn, snap, desoBlock)
 	})
 	if err != nil {
@@ -3463,13 +3504,13 @@ func PutBlock(handle *badger.DB, snap *Snapshot, desoBlock *MsgDeSoBlock) error
 	return nil
 }
 
-func DeleteBlockReward(handle *badger.DB, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func DeleteBlockReward(handle KVStore, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DeleteBlockRewardWithTxn(txn, snap, desoBlock)
 	})
 }
 
-func DeleteBlockRewardWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
+func DeleteBlockRewardWithTxn(txn KVTxn, snap *Snapshot, desoBlock *MsgDeSoBlock) error {
 	blockHash, err := desoBlock.Header.Hash()
 	if err != nil {
 		return errors.Wrapf(err, "DeleteBlockRewardWithTxn: Problem hashing header: ")
@@ -3501,7 +3542,7 @@ func DeleteBlockRewardWithTxn(txn *badger.Txn, snap *Snapshot, desoBlock *MsgDeS
 	return nil
 }
 
-func DbGetBlockRewardForPublicKeyBlockHashWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte, blockHash *BlockHash,
+func DbGetBlockRewardForPublicKeyBlockHashWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte, blockHash *BlockHash,
 ) (_balance uint64, _err error) {
 	key := PublicKeyBlockHashToBlockRewardKey(publicKey, blockHash)
 	desoBalanceBytes, err := DBGetWithTxn(txn, snap, key)
@@ -3511,10 +3552,10 @@ func DbGetBlockRewardForPublicKeyBlockHashWithTxn(txn *badger.Txn, snap *Snapsho
 	return DecodeUint64(desoBalanceBytes), nil
 }
 
-func DbGetBlockRewardForPublicKeyBlockHash(db *badger.DB, snap *Snapshot, publicKey []byte, blockHash *BlockHash,
+func DbGetBlockRewardForPublicKeyBlockHash(db KVStore, snap *Snapshot, publicKey []byte, blockHash *BlockHash,
 ) (_balance uint64, _err error) {
 	ret := uint64(0)
-	dbErr := db.View(func(txn *badger.Txn) error {
+	dbErr := db.View(func(txn KVTxn) error {
 		var err error
 		ret, err = DbGetBlockRewardForPublicKeyBlockHashWithTxn(txn, snap, publicKey, blockHash)
 		if err != nil {
@@ -3548,7 +3589,7 @@ func _heightHashToNodeIndexKey(height uint32, hash *BlockHash, bitcoinNodes bool
 	return key
 }
 
-func GetHeightHashToNodeInfoWithTxn(txn *badger.Txn, snap *Snapshot,
+func GetHeightHashToNodeInfoWithTxn(txn KVTxn, snap *Snapshot,
 	height uint32, hash *BlockHash, bitcoinNodes bool) *BlockNode {
 
 	key := _heightHashToNodeIndexKey(height, hash, bitcoinNodes)
@@ -3565,18 +3606,18 @@ func GetHeightHashToNodeInfoWithTxn(txn *badger.Txn, snap *Snapshot,
 	return blockNode
 }
 
-func GetHeightHashToNodeInfo(handle *badger.DB, snap *Snapshot,
+func GetHeightHashToNodeInfo(handle KVStore, snap *Snapshot,
 	height uint32, hash *BlockHash, bitcoinNodes bool) *BlockNode {
 
 	var blockNode *BlockNode
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		blockNode = GetHeightHashToNodeInfoWithTxn(txn, snap, height, hash, bitcoinNodes)
 		return nil
 	})
 	return blockNode
 }
 
-func PutHeightHashToNodeInfoWithTxn(txn *badger.Txn, snap *Snapshot,
+func PutHeightHashToNodeInfoWithTxn(txn KVTxn, snap *Snapshot,
 	node *BlockNode, bitcoinNodes bool) error {
 
 	key := _heightHashToNodeIndexKey(node.Height, node.Hash, bitcoinNodes)
@@ -3591,8 +3632,8 @@ func PutHeightHashToNodeInfoWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func PutHeightHashToNodeInfo(handle *badger.DB, snap *Snapshot, node *BlockNode, bitcoinNodes bool) error {
-	err := handle.Update(func(txn *badger.Txn) error {
+func PutHeightHashToNodeInfo(handle KVStore, snap *Snapshot, node *BlockNode, bitcoinNodes bool) error {
+	err := handle.Update(func(txn KVTxn) error {
 		return PutHeightHashToNodeInfoWithTxn(txn, snap, node, bitcoinNodes)
 	})
 
@@ -3603,16 +3644,16 @@ func PutHeightHashToNodeInfo(handle *badger.DB, snap *Snapshot, node *BlockNode,
 	return nil
 }
 
-func DbDeleteHeightHashToNodeInfoWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbDeleteHeightHashToNodeInfoWithTxn(txn KVTxn, snap *Snapshot,
 	node *BlockNode, bitcoinNodes bool) error {
 
 	return DBDeleteWithTxn(txn, snap, _heightHashToNodeIndexKey(node.Height, node.Hash, bitcoinNodes))
 }
 
-func DbBulkDeleteHeightHashToNodeInfo(handle *badger.DB, snap *Snapshot,
+func DbBulkDeleteHeightHashToNodeInfo(handle KVStore, snap *Snapshot,
 	nodes []*BlockNode, bitcoinNodes bool) error {
 
-	err := handle.Update(func(txn *badger.Txn) error {
+	err := handle.Update(func(txn KVTxn) error {
 		for _, nn := range nodes {
 			if err := DbDeleteHeightHashToNodeInfoWithTxn(txn, snap, nn, bitcoinNodes); err != nil {
 				return err
@@ -3630,7 +3671,7 @@ func DbBulkDeleteHeightHashToNodeInfo(handle *badger.DB, snap *Snapshot,
 
 // InitDbWithGenesisBlock initializes the database to contain only the genesis
 // block.
-func InitDbWithDeSoGenesisBlock(params *DeSoParams, handle *badger.DB,
+func InitDbWithDeSoGenesisBlock(params *DeSoParams, handle KVStore,
 	eventManager *EventManager, snap *Snapshot) error {
 	// Construct a node for the genesis block. Its height is zero and it has
 	// no parents. Its difficulty should be set to the initial
@@ -3771,13 +3812,13 @@ func InitDbWithDeSoGenesisBlock(params *DeSoParams, handle *badger.DB,
 	return nil
 }
 
-func GetBlockIndex(handle *badger.DB, bitcoinNodes bool) (map[BlockHash]*BlockNode, error) {
+func GetBlockIndex(handle KVStore, bitcoinNodes bool) (map[BlockHash]*BlockNode, error) {
 	blockIndex := make(map[BlockHash]*BlockNode)
 
 	prefix := _heightHashToNodeIndexPrefix(bitcoinNodes)
 
-	err := handle.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := handle.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
 		nodeIterator := txn.NewIterator(opts)
 		defer nodeIterator.Close()
 		for nodeIterator.Seek(prefix); nodeIterator.ValidForPrefix(prefix); nodeIterator.Next() {
@@ -3926,16 +3967,16 @@ func PkToStringTestnet(pk []byte) string {
 	return Base58CheckEncode(pk, false, &DeSoTestnetParams)
 }
 
-func DbGetTxindexTip(handle *badger.DB, snap *Snapshot) *BlockHash {
+func DbGetTxindexTip(handle KVStore, snap *Snapshot) *BlockHash {
 	return _getBlockHashForPrefix(handle, snap, Prefixes.PrefixTransactionIndexTip)
 }
 
-func DbPutTxindexTipWithTxn(txn *badger.Txn, snap *Snapshot, tipHash *BlockHash) error {
+func DbPutTxindexTipWithTxn(txn KVTxn, snap *Snapshot, tipHash *BlockHash) error {
 	return DBSetWithTxn(txn, snap, Prefixes.PrefixTransactionIndexTip, tipHash[:])
 }
 
-func DbPutTxindexTip(handle *badger.DB, snap *Snapshot, tipHash *BlockHash) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func DbPutTxindexTip(handle KVStore, snap *Snapshot, tipHash *BlockHash) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutTxindexTipWithTxn(txn, snap, tipHash)
 	})
 }
@@ -3953,7 +3994,7 @@ func DbTxindexPublicKeyIndexToTxnKey(publicKey []byte, index uint32) []byte {
 	return append(prefix, _EncodeUint32(index)...)
 }
 
-func DbGetTxindexTxnsForPublicKeyWithTxn(txn *badger.Txn, publicKey []byte) []*BlockHash {
+func DbGetTxindexTxnsForPublicKeyWithTxn(txn KVTxn, publicKey []byte) []*BlockHash {
 	txIDs := []*BlockHash{}
 	_, valsFound, err := _enumerateKeysForPrefixWithTxn(txn, DbTxindexPublicKeyPrefix(publicKey))
 	if err != nil {
@@ -3968,19 +4009,19 @@ func DbGetTxindexTxnsForPublicKeyWithTxn(txn *badger.Txn, publicKey []byte) []*B
 	return txIDs
 }
 
-func DbGetTxindexTxnsForPublicKey(handle *badger.DB, publicKey []byte) []*BlockHash {
+func DbGetTxindexTxnsForPublicKey(handle KVStore, publicKey []byte) []*BlockHash {
 	txIDs := []*BlockHash{}
-	handle.Update(func(txn *badger.Txn) error {
+	handle.Update(func(txn KVTxn) error {
 		txIDs = DbGetTxindexTxnsForPublicKeyWithTxn(txn, publicKey)
 		return nil
 	})
 	return txIDs
 }
 
-func _DbGetTxindexNextIndexForPublicKeBySeekWithTxn(txn *badger.Txn, publicKey []byte) uint64 {
+func _DbGetTxindexNextIndexForPublicKeBySeekWithTxn(txn KVTxn, publicKey []byte) uint64 {
 	dbPrefixx := DbTxindexPublicKeyPrefix(publicKey)
 
-	opts := badger.DefaultIteratorOptions
+	opts := DefaultKVIteratorOptions
 
 	opts.PrefetchValues = false
 
@@ -4015,21 +4056,21 @@ func _DbGetTxindexNextIndexForPublicKeBySeekWithTxn(txn *badger.Txn, publicKey [
 	return 0
 }
 
-func DbGetTxindexNextIndexForPublicKey(handle *badger.DB, snap *Snapshot, publicKey []byte) *uint64 {
+func DbGetTxindexNextIndexForPublicKey(handle KVStore, snap *Snapshot, publicKey []byte) *uint64 {
 	var nextIndex *uint64
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		nextIndex = _DbGetTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey)
 		return nil
 	})
 	return nextIndex
 }
 
-func _DbGetTxindexNextIndexForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) *uint64 {
+func _DbGetTxindexNextIndexForPublicKeyWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte) *uint64 {
 	key := _DbTxindexPublicKeyNextIndexPrefix(publicKey)
 	valBytes, err := DBGetWithTxn(txn, snap, key)
 	if err != nil {
 		// If we haven't seen this public key yet, we won't have a next index for this key yet, so return 0.
-		if errors.Is(err, badger.ErrKeyNotFound) {
+		if errors.Is(err, ErrKVKeyNotFound) {
 			nextIndexVal := _DbGetTxindexNextIndexForPublicKeBySeekWithTxn(txn, publicKey)
 			return &nextIndexVal
 		} else {
@@ -4044,7 +4085,7 @@ func _DbGetTxindexNextIndexForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot,
 
 }
 
-func DbPutTxindexNextIndexForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbPutTxindexNextIndexForPublicKeyWithTxn(txn KVTxn, snap *Snapshot,
 	publicKey []byte, nextIndex uint64) error {
 
 	key := _DbTxindexPublicKeyNextIndexPrefix(publicKey)
@@ -4053,12 +4094,12 @@ func DbPutTxindexNextIndexForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot,
 	return DBSetWithTxn(txn, snap, key, valBuf)
 }
 
-func DbDeleteTxindexNextIndexForPublicKeyWithTxn(txn *badger.Txn, snap *Snapshot, publicKey []byte) error {
+func DbDeleteTxindexNextIndexForPublicKeyWithTxn(txn KVTxn, snap *Snapshot, publicKey []byte) error {
 	key := _DbTxindexPublicKeyNextIndexPrefix(publicKey)
 	return DBDeleteWithTxn(txn, snap, key)
 }
 
-func DbPutTxindexPublicKeyToTxnMappingSingleWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbPutTxindexPublicKeyToTxnMappingSingleWithTxn(txn KVTxn, snap *Snapshot,
 	publicKey []byte, txID *BlockHash) error {
 
 	nextIndex := _DbGetTxindexNextIndexForPublicKeyWithTxn(txn, snap, publicKey)
@@ -4073,7 +4114,7 @@ func DbPutTxindexPublicKeyToTxnMappingSingleWithTxn(txn *badger.Txn, snap *Snaps
 	return DBSetWithTxn(txn, snap, key, txID[:])
 }
 
-func DbDeleteTxindexPublicKeyToTxnMappingSingleWithTxn(txn *badger.Txn,
+func DbDeleteTxindexPublicKeyToTxnMappingSingleWithTxn(txn KVTxn,
 	snap *Snapshot, publicKey []byte, txID *BlockHash) error {
 
 	// Get all the mappings corresponding to the public key passed in.
@@ -4464,6 +4505,9 @@ func (txnMeta *DAOCoinTransferTxindexMetadata) RawDecodeWithoutMetadata(blockHei
 	if err != nil {
 		return errors.Wrapf(err, "DAOCoinTransferTxindexMetadata.Decode: Problem reading DAOCoinToTransferNanos")
 	}
+	if DAOCoinToTransferNanos == nil {
+		return fmt.Errorf("DAOCoinTransferTxindexMetadata.Decode: DAOCoinToTransferNanos is missing")
+	}
 	txnMeta.DAOCoinToTransferNanos = *DAOCoinToTransferNanos
 	return nil
 }
@@ -5630,7 +5674,7 @@ func (txnMeta *TransactionMetadata) GetEncoderType() EncoderType {
 	return EncoderTypeTransactionMetadata
 }
 
-func DBCheckTxnExistenceWithTxn(txn *badger.Txn, snap *Snapshot, txID *BlockHash) bool {
+func DBCheckTxnExistenceWithTxn(txn KVTxn, snap *Snapshot, txID *BlockHash) bool {
 	key := DbTxindexTxIDKey(txID)
 	_, err := DBGetWithTxn(txn, snap, key)
 	if err != nil {
@@ -5639,16 +5683,16 @@ func DBCheckTxnExistenceWithTxn(txn *badger.Txn, snap *Snapshot, txID *BlockHash
 	return true
 }
 
-func DbCheckTxnExistence(handle *badger.DB, snap *Snapshot, txID *BlockHash) bool {
+func DbCheckTxnExistence(handle KVStore, snap *Snapshot, txID *BlockHash) bool {
 	var exists bool
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		exists = DBCheckTxnExistenceWithTxn(txn, snap, txID)
 		return nil
 	})
 	return exists
 }
 
-func DbGetTxindexTransactionRefByTxIDWithTxn(txn *badger.Txn, snap *Snapshot, txID *BlockHash) *TransactionMetadata {
+func DbGetTxindexTransactionRefByTxIDWithTxn(txn KVTxn, snap *Snapshot, txID *BlockHash) *TransactionMetadata {
 	key := DbTxindexTxIDKey(txID)
 	valObj := &TransactionMetadata{}
 
@@ -5663,25 +5707,25 @@ func DbGetTxindexTransactionRefByTxIDWithTxn(txn *badger.Txn, snap *Snapshot, tx
 	return valObj
 }
 
-func DbGetTxindexTransactionRefByTxID(handle *badger.DB, snap *Snapshot, txID *BlockHash) *TransactionMetadata {
+func DbGetTxindexTransactionRefByTxID(handle KVStore, snap *Snapshot, txID *BlockHash) *TransactionMetadata {
 	var valObj *TransactionMetadata
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		valObj = DbGetTxindexTransactionRefByTxIDWithTxn(txn, snap, txID)
 		return nil
 	})
 	return valObj
 }
-func DbPutTxindexTransactionWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DbPutTxindexTransactionWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	txID *BlockHash, txnMeta *TransactionMetadata) error {
 
 	key := append(append([]byte{}, Prefixes.PrefixTransactionIDToMetadata...), txID[:]...)
 	return DBSetWithTxn(txn, snap, key, EncodeToBytes(blockHeight, txnMeta))
 }
 
-func DbPutTxindexTransaction(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DbPutTxindexTransaction(handle KVStore, snap *Snapshot, blockHeight uint64,
 	txID *BlockHash, txnMeta *TransactionMetadata) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutTxindexTransactionWithTxn(txn, snap, blockHeight, txID, txnMeta)
 	})
 }
@@ -5726,7 +5770,7 @@ func _getPublicKeysForTxn(
 	return publicKeys
 }
 
-func DbPutTxindexTransactionMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DbPutTxindexTransactionMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	desoTxn *MsgDeSoTxn, params *DeSoParams, txnMeta *TransactionMetadata) error {
 
 	txID := desoTxn.Hash()
@@ -5752,16 +5796,16 @@ func DbPutTxindexTransactionMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blo
 	return nil
 }
 
-func DbPutTxindexTransactionMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DbPutTxindexTransactionMappings(handle KVStore, snap *Snapshot, blockHeight uint64,
 	desoTxn *MsgDeSoTxn, params *DeSoParams, txnMeta *TransactionMetadata) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutTxindexTransactionMappingsWithTxn(
 			txn, snap, blockHeight, desoTxn, params, txnMeta)
 	})
 }
 
-func DbDeleteTxindexTransactionMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DbDeleteTxindexTransactionMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	desoTxn *MsgDeSoTxn, params *DeSoParams) error {
 
 	txID := desoTxn.Hash()
@@ -5793,10 +5837,10 @@ func DbDeleteTxindexTransactionMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DbDeleteTxindexTransactionMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DbDeleteTxindexTransactionMappings(handle KVStore, snap *Snapshot, blockHeight uint64,
 	desoTxn *MsgDeSoTxn, params *DeSoParams) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeleteTxindexTransactionMappingsWithTxn(txn, snap, blockHeight, desoTxn, params)
 	})
 }
@@ -5804,13 +5848,13 @@ func DbDeleteTxindexTransactionMappings(handle *badger.DB, snap *Snapshot, block
 // DbGetTxindexFullTransactionByTxID
 // TODO: This makes lookups inefficient when blocks are large. Shouldn't be a
 // problem for a while, but keep an eye on it.
-func DbGetTxindexFullTransactionByTxID(txindexDBHandle *badger.DB, snap *Snapshot,
-	blockchainDBHandle *badger.DB, txID *BlockHash) (
+func DbGetTxindexFullTransactionByTxID(txindexDBHandle KVStore, snap *Snapshot,
+	blockchainDBHandle KVStore, txID *BlockHash) (
 	_txn *MsgDeSoTxn, _txnMeta *TransactionMetadata) {
 
 	var txnFound *MsgDeSoTxn
 	var txnMeta *TransactionMetadata
-	err := txindexDBHandle.View(func(txn *badger.Txn) error {
+	err := txindexDBHandle.View(func(txn KVTxn) error {
 		txnMeta = DbGetTxindexTransactionRefByTxIDWithTxn(txn, snap, txID)
 		if txnMeta == nil {
 			return fmt.Errorf("DbGetTxindexFullTransactionByTxID: Transaction not found")
@@ -5890,7 +5934,7 @@ func _dbKeyForCommentParentStakeIDToPostHash(
 	return key
 }
 
-func DBGetPostEntryByPostHashWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetPostEntryByPostHashWithTxn(txn KVTxn, snap *Snapshot,
 	postHash *BlockHash) *PostEntry {
 
 	key := _dbKeyForPostEntryHash(postHash)
@@ -5905,16 +5949,16 @@ func DBGetPostEntryByPostHashWithTxn(txn *badger.Txn, snap *Snapshot,
 	return postEntryObj
 }
 
-func DBGetPostEntryByPostHash(db *badger.DB, snap *Snapshot, postHash *BlockHash) *PostEntry {
+func DBGetPostEntryByPostHash(db KVStore, snap *Snapshot, postHash *BlockHash) *PostEntry {
 	var ret *PostEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetPostEntryByPostHashWithTxn(txn, snap, postHash)
 		return nil
 	})
 	return ret
 }
 
-func DBDeletePostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeletePostEntryMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	postHash *BlockHash, params *DeSoParams) error {
 
 	// First pull up the mapping that exists for the post hash passed in.
@@ -6000,15 +6044,15 @@ func DBDeletePostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DBDeletePostEntryMappings(handle *badger.DB, snap *Snapshot,
+func DBDeletePostEntryMappings(handle KVStore, snap *Snapshot,
 	postHash *BlockHash, params *DeSoParams) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeletePostEntryMappingsWithTxn(txn, snap, postHash, params)
 	})
 }
 
-func DBPutPostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutPostEntryMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	postEntry *PostEntry, params *DeSoParams) error {
 
 	if err := DBSetWithTxn(txn, snap, _dbKeyForPostEntryHash(
@@ -6100,10 +6144,10 @@ func DBPutPostEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight
 	return nil
 }
 
-func DBPutPostEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutPostEntryMappings(handle KVStore, snap *Snapshot, blockHeight uint64,
 	postEntry *PostEntry, params *DeSoParams) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutPostEntryMappingsWithTxn(txn, snap, blockHeight, postEntry, params)
 	})
 }
@@ -6111,7 +6155,7 @@ func DBPutPostEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight uint6
 // Specifying minTimestampNanos gives you all posts after minTimestampNanos
 // Pass minTimestampNanos = 0 && maxTimestampNanos = 0 if you want all posts
 // Setting maxTimestampNanos = 0, will default maxTimestampNanos to the current time.
-func DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp(handle *badger.DB,
+func DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp(handle KVStore,
 	snap *Snapshot, publicKey []byte, fetchEntries bool, minTimestampNanos uint64, maxTimestampNanos uint64) (
 	_tstamps []uint64, _postAndCommentHashes []*BlockHash, _postAndCommentEntries []*PostEntry, _err error) {
 
@@ -6121,8 +6165,8 @@ func DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp(handle *badger.DB,
 	dbPrefixx := append([]byte{}, Prefixes.PrefixPosterPublicKeyTimestampPostHash...)
 	dbPrefixx = append(dbPrefixx, publicKey...)
 
-	err := handle.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := handle.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
 
 		opts.PrefetchValues = false
 
@@ -6192,7 +6236,7 @@ func DBGetAllPostsAndCommentsForPublicKeyOrderedByTimestamp(handle *badger.DB,
 //
 // TODO(performance): This currently fetches all posts. We should implement
 // some kind of pagination instead though.
-func DBGetAllPostsByTstamp(handle *badger.DB, snap *Snapshot, fetchEntries bool) (
+func DBGetAllPostsByTstamp(handle KVStore, snap *Snapshot, fetchEntries bool) (
 	_tstamps []uint64, _postHashes []*BlockHash, _postEntries []*PostEntry, _err error) {
 
 	tstampsFetched := []uint64{}
@@ -6200,8 +6244,8 @@ func DBGetAllPostsByTstamp(handle *badger.DB, snap *Snapshot, fetchEntries bool)
 	postEntriesFetched := []*PostEntry{}
 	dbPrefixx := append([]byte{}, Prefixes.PrefixTstampNanosPostHash...)
 
-	err := handle.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := handle.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
 
 		opts.PrefetchValues = false
 
@@ -6265,7 +6309,7 @@ func DBGetAllPostsByTstamp(handle *badger.DB, snap *Snapshot, fetchEntries bool)
 // TODO(performance): This currently fetches all comments. We should implement
 // something where we only get the comments for particular posts instead.
 func DBGetCommentPostHashesForParentStakeID(
-	handle *badger.DB, snap *Snapshot, stakeIDXXX []byte, fetchEntries bool) (
+	handle KVStore, snap *Snapshot, stakeIDXXX []byte, fetchEntries bool) (
 	_tstamps []uint64, _commentPostHashes []*BlockHash, _commentPostEntryes []*PostEntry, _err error) {
 
 	tstampsFetched := []uint64{}
@@ -6274,8 +6318,8 @@ func DBGetCommentPostHashesForParentStakeID(
 	dbPrefixx := append([]byte{}, Prefixes.PrefixCommentParentStakeIDToPostHash...)
 	dbPrefixx = append(dbPrefixx, stakeIDXXX...)
 
-	err := handle.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := handle.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
 
 		opts.PrefetchValues = false
 
@@ -6354,7 +6398,7 @@ func _dbKeyForPKIDIsForSaleBidAmountNanosNFTPostHashSerialNumber(pkid *PKID, isF
 	return key
 }
 
-func DBGetNFTEntryByPostHashSerialNumberWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetNFTEntryByPostHashSerialNumberWithTxn(txn KVTxn, snap *Snapshot,
 	postHash *BlockHash, serialNumber uint64) *NFTEntry {
 
 	key := _dbKeyForNFTPostHashSerialNumber(postHash, serialNumber)
@@ -6369,18 +6413,18 @@ func DBGetNFTEntryByPostHashSerialNumberWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nftEntryObj
 }
 
-func DBGetNFTEntryByPostHashSerialNumber(db *badger.DB, snap *Snapshot,
+func DBGetNFTEntryByPostHashSerialNumber(db KVStore, snap *Snapshot,
 	postHash *BlockHash, serialNumber uint64) *NFTEntry {
 
 	var ret *NFTEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetNFTEntryByPostHashSerialNumberWithTxn(txn, snap, postHash, serialNumber)
 		return nil
 	})
 	return ret
 }
 
-func DBDeleteNFTMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteNFTMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	nftPostHash *BlockHash, serialNumber uint64) error {
 
 	// First pull up the mapping that exists for the post / serial # passed in.
@@ -6409,14 +6453,14 @@ func DBDeleteNFTMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 }
 
 func DBDeleteNFTMappings(
-	handle *badger.DB, snap *Snapshot, postHash *BlockHash, serialNumber uint64) error {
+	handle KVStore, snap *Snapshot, postHash *BlockHash, serialNumber uint64) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteNFTMappingsWithTxn(txn, snap, postHash, serialNumber)
 	})
 }
 
-func DBPutNFTEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64, nftEntry *NFTEntry) error {
+func DBPutNFTEntryMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64, nftEntry *NFTEntry) error {
 	nftEntryBytes := EncodeToBytes(blockHeight, nftEntry)
 
 	if err := DBSetWithTxn(txn, snap, _dbKeyForNFTPostHashSerialNumber(
@@ -6435,15 +6479,15 @@ func DBPutNFTEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight u
 	return nil
 }
 
-func DBPutNFTEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64, nftEntry *NFTEntry) error {
+func DBPutNFTEntryMappings(handle KVStore, snap *Snapshot, blockHeight uint64, nftEntry *NFTEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutNFTEntryMappingsWithTxn(txn, snap, blockHeight, nftEntry)
 	})
 }
 
 // DBGetNFTEntriesForPostHash gets NFT Entries *from the DB*. Does not include mempool txns.
-func DBGetNFTEntriesForPostHash(handle *badger.DB, nftPostHash *BlockHash) (_nftEntries []*NFTEntry) {
+func DBGetNFTEntriesForPostHash(handle KVStore, nftPostHash *BlockHash) (_nftEntries []*NFTEntry) {
 	nftEntries := []*NFTEntry{}
 	prefix := append([]byte{}, Prefixes.PrefixPostHashSerialNumberToNFTEntry...)
 	keyPrefix := append(prefix, nftPostHash[:]...)
@@ -6463,7 +6507,7 @@ func DBGetNFTEntriesForPostHash(handle *badger.DB, nftPostHash *BlockHash) (_nft
 // outside of the protocol layer once update to the creation of TxIndex are complete.
 // =======================================================================================
 
-func DBGetNFTEntryByNFTOwnershipDetailsWithTxn(txn *badger.Txn, snap *Snapshot, ownerPKID *PKID,
+func DBGetNFTEntryByNFTOwnershipDetailsWithTxn(txn KVTxn, snap *Snapshot, ownerPKID *PKID,
 	isForSale bool, bidAmountNanos uint64, postHash *BlockHash, serialNumber uint64) *NFTEntry {
 
 	key := _dbKeyForPKIDIsForSaleBidAmountNanosNFTPostHashSerialNumber(ownerPKID, isForSale, bidAmountNanos, postHash, serialNumber)
@@ -6478,11 +6522,11 @@ func DBGetNFTEntryByNFTOwnershipDetailsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nftEntryObj
 }
 
-func DBGetNFTEntryByNFTOwnershipDetails(db *badger.DB, snap *Snapshot, ownerPKID *PKID,
+func DBGetNFTEntryByNFTOwnershipDetails(db KVStore, snap *Snapshot, ownerPKID *PKID,
 	isForSale bool, bidAmountNanos uint64, postHash *BlockHash, serialNumber uint64) *NFTEntry {
 
 	var ret *NFTEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetNFTEntryByNFTOwnershipDetailsWithTxn(txn, snap, ownerPKID, isForSale, bidAmountNanos, postHash, serialNumber)
 		return nil
 	})
@@ -6490,7 +6534,7 @@ func DBGetNFTEntryByNFTOwnershipDetails(db *badger.DB, snap *Snapshot, ownerPKID
 }
 
 // DBGetNFTEntriesForPKID gets NFT Entries *from the DB*. Does not include mempool txns.
-func DBGetNFTEntriesForPKID(handle *badger.DB, ownerPKID *PKID) (_nftEntries []*NFTEntry) {
+func DBGetNFTEntriesForPKID(handle KVStore, ownerPKID *PKID) (_nftEntries []*NFTEntry) {
 	var nftEntries []*NFTEntry
 	prefix := append([]byte{}, Prefixes.PrefixPKIDIsForSaleBidAmountNanosPostHashSerialNumberToNFTEntry...)
 	keyPrefix := append(prefix, ownerPKID[:]...)
@@ -6517,7 +6561,7 @@ func _dbKeyForPostHashSerialNumberToAcceptedBidEntries(nftPostHash *BlockHash, s
 }
 
 // TODO: are we sure we want to pass a pointer to an array here?
-func DBPutAcceptedNFTBidEntriesMappingWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutAcceptedNFTBidEntriesMappingWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	nftKey NFTKey, nftBidEntries *[]*NFTBidEntry) error {
 
 	nftBidEntryBundle := &NFTBidEntryBundle{
@@ -6532,15 +6576,15 @@ func DBPutAcceptedNFTBidEntriesMappingWithTxn(txn *badger.Txn, snap *Snapshot, b
 	return nil
 }
 
-func DBPutAcceptedNFTBidEntriesMapping(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutAcceptedNFTBidEntriesMapping(handle KVStore, snap *Snapshot, blockHeight uint64,
 	nftKey NFTKey, nftBidEntries *[]*NFTBidEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutAcceptedNFTBidEntriesMappingWithTxn(txn, snap, blockHeight, nftKey, nftBidEntries)
 	})
 }
 
-func DBGetAcceptedNFTBidEntriesByPostHashSerialNumberWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetAcceptedNFTBidEntriesByPostHashSerialNumberWithTxn(txn KVTxn, snap *Snapshot,
 	postHash *BlockHash, serialNumber uint64) *[]*NFTBidEntry {
 
 	key := _dbKeyForPostHashSerialNumberToAcceptedBidEntries(postHash, serialNumber)
@@ -6558,18 +6602,18 @@ func DBGetAcceptedNFTBidEntriesByPostHashSerialNumberWithTxn(txn *badger.Txn, sn
 	return &nftBidEntriesBundle.nftBidEntryBundle
 }
 
-func DBGetAcceptedNFTBidEntriesByPostHashSerialNumber(db *badger.DB, snap *Snapshot,
+func DBGetAcceptedNFTBidEntriesByPostHashSerialNumber(db KVStore, snap *Snapshot,
 	postHash *BlockHash, serialNumber uint64) *[]*NFTBidEntry {
 
 	var ret *[]*NFTBidEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetAcceptedNFTBidEntriesByPostHashSerialNumberWithTxn(txn, snap, postHash, serialNumber)
 		return nil
 	})
 	return ret
 }
 
-func DBDeleteAcceptedNFTBidEntriesMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteAcceptedNFTBidEntriesMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	nftPostHash *BlockHash, serialNumber uint64) error {
 
 	// First check to see if there is an existing mapping. If one doesn't exist, there's nothing to do.
@@ -6588,10 +6632,10 @@ func DBDeleteAcceptedNFTBidEntriesMappingsWithTxn(txn *badger.Txn, snap *Snapsho
 	return nil
 }
 
-func DBDeleteAcceptedNFTBidMappings(handle *badger.DB, snap *Snapshot,
+func DBDeleteAcceptedNFTBidMappings(handle KVStore, snap *Snapshot,
 	postHash *BlockHash, serialNumber uint64) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteAcceptedNFTBidEntriesMappingsWithTxn(txn, snap, postHash, serialNumber)
 	})
 }
@@ -6628,7 +6672,7 @@ func _dbSeekKeyForNFTBids(nftHash *BlockHash, serialNumber uint64) []byte {
 	return key
 }
 
-func DBGetNFTBidEntryForNFTBidKeyWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetNFTBidEntryForNFTBidKeyWithTxn(txn KVTxn, snap *Snapshot,
 	nftBidKey *NFTBidKey) *NFTBidEntry {
 
 	key := _dbKeyForNFTBidderPKIDPostHashSerialNumber(
@@ -6652,16 +6696,16 @@ func DBGetNFTBidEntryForNFTBidKeyWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nftBidEntry
 }
 
-func DBGetNFTBidEntryForNFTBidKey(db *badger.DB, snap *Snapshot, nftBidKey *NFTBidKey) *NFTBidEntry {
+func DBGetNFTBidEntryForNFTBidKey(db KVStore, snap *Snapshot, nftBidKey *NFTBidKey) *NFTBidEntry {
 	var ret *NFTBidEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetNFTBidEntryForNFTBidKeyWithTxn(txn, snap, nftBidKey)
 		return nil
 	})
 	return ret
 }
 
-func DBDeleteNFTBidMappingsWithTxn(txn *badger.Txn, snap *Snapshot, nftBidKey *NFTBidKey) error {
+func DBDeleteNFTBidMappingsWithTxn(txn KVTxn, snap *Snapshot, nftBidKey *NFTBidKey) error {
 
 	// First check to see if there is an existing mapping. If one doesn't exist, there's nothing to do.
 	nftBidEntry := DBGetNFTBidEntryForNFTBidKeyWithTxn(txn, snap, nftBidKey)
@@ -6685,14 +6729,14 @@ func DBDeleteNFTBidMappingsWithTxn(txn *badger.Txn, snap *Snapshot, nftBidKey *N
 	return nil
 }
 
-func DBDeleteNFTBidMappings(handle *badger.DB, snap *Snapshot, nftBidKey *NFTBidKey) error {
+func DBDeleteNFTBidMappings(handle KVStore, snap *Snapshot, nftBidKey *NFTBidKey) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteNFTBidMappingsWithTxn(txn, snap, nftBidKey)
 	})
 }
 
-func DBPutNFTBidEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, nftBidEntry *NFTBidEntry) error {
+func DBPutNFTBidEntryMappingsWithTxn(txn KVTxn, snap *Snapshot, nftBidEntry *NFTBidEntry) error {
 	// We store two indexes for NFT bids. (1) sorted by bid amount nanos in the key and
 	// (2) sorted by the bidder PKID. Both come in handy.
 
@@ -6716,14 +6760,14 @@ func DBPutNFTBidEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, nftBidEntr
 	return nil
 }
 
-func DBPutNFTBidEntryMappings(handle *badger.DB, snap *Snapshot, nftEntry *NFTBidEntry) error {
+func DBPutNFTBidEntryMappings(handle KVStore, snap *Snapshot, nftEntry *NFTBidEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutNFTBidEntryMappingsWithTxn(txn, snap, nftEntry)
 	})
 }
 
-func DBGetNFTBidEntriesForPKID(handle *badger.DB, bidderPKID *PKID) (_nftBidEntries []*NFTBidEntry) {
+func DBGetNFTBidEntriesForPKID(handle KVStore, bidderPKID *PKID) (_nftBidEntries []*NFTBidEntry) {
 	nftBidEntries := []*NFTBidEntry{}
 	{
 		prefix := append([]byte{}, Prefixes.PrefixBidderPKIDPostHashSerialNumberToBidNanos...)
@@ -6758,7 +6802,7 @@ func DBGetNFTBidEntriesForPKID(handle *badger.DB, bidderPKID *PKID) (_nftBidEntr
 }
 
 // Get NFT bid Entries *from the DB*. Does not include mempool txns.
-func DBGetNFTBidEntries(handle *badger.DB, nftPostHash *BlockHash, serialNumber uint64,
+func DBGetNFTBidEntries(handle KVStore, nftPostHash *BlockHash, serialNumber uint64,
 ) (_nftBidEntries []*NFTBidEntry) {
 	nftBidEntries := []*NFTBidEntry{}
 	{
@@ -6793,7 +6837,7 @@ func DBGetNFTBidEntries(handle *badger.DB, nftPostHash *BlockHash, serialNumber
 }
 
 func DBGetNFTBidEntriesPaginated(
-	handle *badger.DB,
+	handle KVStore,
 	nftHash *BlockHash,
 	serialNumber uint64,
 	startEntry *NFTBidEntry,
@@ -6871,7 +6915,7 @@ func _dbSeekPrefixForDerivedKeyMappings(
 	return key
 }
 
-func DBPutDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutDerivedKeyMappingWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	ownerPublicKey PublicKey, derivedPublicKey PublicKey, derivedKeyEntry *DerivedKeyEntry) error {
 
 	key := _dbKeyForOwnerToDerivedKeyMapping(ownerPublicKey, derivedPublicKey)
@@ -6879,15 +6923,15 @@ func DBPutDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight
 	return DBSetWithTxn(txn, snap, key, EncodeToBytes(blockHeight, derivedKeyEntry))
 }
 
-func DBPutDerivedKeyMapping(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutDerivedKeyMapping(handle KVStore, snap *Snapshot, blockHeight uint64,
 	ownerPublicKey PublicKey, derivedPublicKey PublicKey, derivedKeyEntry *DerivedKeyEntry) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutDerivedKeyMappingWithTxn(txn, snap, blockHeight, ownerPublicKey, derivedPublicKey, derivedKeyEntry)
 	})
 }
 
-func DBGetOwnerToDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetOwnerToDerivedKeyMappingWithTxn(txn KVTxn, snap *Snapshot,
 	ownerPublicKey PublicKey, derivedPublicKey PublicKey) *DerivedKeyEntry {
 
 	key := _dbKeyForOwnerToDerivedKeyMapping(ownerPublicKey, derivedPublicKey)
@@ -6902,18 +6946,18 @@ func DBGetOwnerToDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
 	return derivedKeyEntry
 }
 
-func DBGetOwnerToDerivedKeyMapping(db *badger.DB, snap *Snapshot,
+func DBGetOwnerToDerivedKeyMapping(db KVStore, snap *Snapshot,
 	ownerPublicKey PublicKey, derivedPublicKey PublicKey) *DerivedKeyEntry {
 
 	var derivedKeyEntry *DerivedKeyEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		derivedKeyEntry = DBGetOwnerToDerivedKeyMappingWithTxn(txn, snap, ownerPublicKey, derivedPublicKey)
 		return nil
 	})
 	return derivedKeyEntry
 }
 
-func DBDeleteDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteDerivedKeyMappingWithTxn(txn KVTxn, snap *Snapshot,
 	ownerPublicKey PublicKey, derivedPublicKey PublicKey) error {
 
 	// When a mapping exists, delete it.
@@ -6926,14 +6970,14 @@ func DBDeleteDerivedKeyMappingWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DBDeleteDerivedKeyMapping(handle *badger.DB, snap *Snapshot,
+func DBDeleteDerivedKeyMapping(handle KVStore, snap *Snapshot,
 	ownerPublicKey PublicKey, derivedPublicKey PublicKey) error {
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteDerivedKeyMappingWithTxn(txn, snap, ownerPublicKey, derivedPublicKey)
 	})
 }
 
-func DBGetAllOwnerToDerivedKeyMappings(handle *badger.DB, ownerPublicKey PublicKey) (
+func DBGetAllOwnerToDerivedKeyMappings(handle KVStore, ownerPublicKey PublicKey) (
 	_entries []*DerivedKeyEntry, _err error) {
 
 	prefix := _dbSeekPrefixForDerivedKeyMappings(ownerPublicKey)
@@ -6980,7 +7024,7 @@ func DbPrefixForCreatorDeSoLockedNanosCreatorPKID() []byte {
 	return append([]byte{}, Prefixes.PrefixCreatorDeSoLockedNanosCreatorPKID...)
 }
 
-func DBGetPKIDForUsernameWithTxn(txn *badger.Txn,
+func DBGetPKIDForUsernameWithTxn(txn KVTxn,
 	snap *Snapshot, username []byte) *PKID {
 
 	key := _dbKeyForProfileUsernameToPKID(username)
@@ -6992,16 +7036,16 @@ func DBGetPKIDForUsernameWithTxn(txn *badger.Txn,
 	return PublicKeyToPKID(profileBytes)
 }
 
-func DBGetPKIDForUsername(db *badger.DB, snap *Snapshot, username []byte) *PKID {
+func DBGetPKIDForUsername(db KVStore, snap *Snapshot, username []byte) *PKID {
 	var ret *PKID
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetPKIDForUsernameWithTxn(txn, snap, username)
 		return nil
 	})
 	return ret
 }
 
-func DBGetProfileEntryForUsernameWithTxn(txn *badger.Txn,
+func DBGetProfileEntryForUsernameWithTxn(txn KVTxn,
 	snap *Snapshot, username []byte) *ProfileEntry {
 
 	pkid := DBGetPKIDForUsernameWithTxn(txn, snap, username)
@@ -7012,16 +7056,16 @@ func DBGetProfileEntryForUsernameWithTxn(txn *badger.Txn,
 	return DBGetProfileEntryForPKIDWithTxn(txn, snap, pkid)
 }
 
-func DBGetProfileEntryForUsername(db *badger.DB, snap *Snapshot, username []byte) *ProfileEntry {
+func DBGetProfileEntryForUsername(db KVStore, snap *Snapshot, username []byte) *ProfileEntry {
 	var ret *ProfileEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetProfileEntryForUsernameWithTxn(txn, snap, username)
 		return nil
 	})
 	return ret
 }
 
-func DBGetProfileEntryForPKIDWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetProfileEntryForPKIDWithTxn(txn KVTxn, snap *Snapshot,
 	pkid *PKID) *ProfileEntry {
 
 	key := _dbKeyForPKIDToProfileEntry(pkid)
@@ -7036,16 +7080,16 @@ func DBGetProfileEntryForPKIDWithTxn(txn *badger.Txn, snap *Snapshot,
 	return profileEntryObj
 }
 
-func DBGetProfileEntryForPKID(db *badger.DB, snap *Snapshot, pkid *PKID) *ProfileEntry {
+func DBGetProfileEntryForPKID(db KVStore, snap *Snapshot, pkid *PKID) *ProfileEntry {
 	var ret *ProfileEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DBGetProfileEntryForPKIDWithTxn(txn, snap, pkid)
 		return nil
 	})
 	return ret
 }
 
-func DBDeleteProfileEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteProfileEntryMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	pkid *PKID, params *DeSoParams) error {
 
 	// First pull up the mapping that exists for the profile pub key passed in.
@@ -7081,7 +7125,7 @@ func DBDeleteProfileEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DBPutProfileEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutProfileEntryMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	profileEntry *ProfileEntry, pkid *PKID, params *DeSoParams) error {
 
 	// Set the main PKID -> profile entry mapping.
@@ -7113,10 +7157,10 @@ func DBPutProfileEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeig
 	return nil
 }
 
-func DBPutProfileEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutProfileEntryMappings(handle KVStore, snap *Snapshot, blockHeight uint64,
 	profileEntry *ProfileEntry, pkid *PKID, params *DeSoParams) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutProfileEntryMappingsWithTxn(txn, snap, blockHeight, profileEntry, pkid, params)
 	})
 }
@@ -7126,7 +7170,7 @@ func DBPutProfileEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight ui
 //
 // TODO(performance): This currently fetches all profiles. We should implement
 // some kind of pagination instead though.
-func DBGetAllProfilesByCoinValue(handle *badger.DB, snap *Snapshot, fetchEntries bool) (
+func DBGetAllProfilesByCoinValue(handle KVStore, snap *Snapshot, fetchEntries bool) (
 	_lockedDeSoNanos []uint64, _profilePKIDs []*PKID,
 	_profileEntries []*ProfileEntry, _err error) {
 
@@ -7135,8 +7179,8 @@ func DBGetAllProfilesByCoinValue(handle *badger.DB, snap *Snapshot, fetchEntries
 	profileEntriesFetched := []*ProfileEntry{}
 	dbPrefixx := append([]byte{}, Prefixes.PrefixCreatorDeSoLockedNanosCreatorPKID...)
 
-	err := handle.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
+	err := handle.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
 
 		opts.PrefetchValues = false
 
@@ -7228,7 +7272,7 @@ func _dbKeyForCreatorPKIDHODLerPKIDToBalanceEntry(creatorPKID *PKID, hodlerPKID
 	return key
 }
 
-func DBGetBalanceEntryForHODLerAndCreatorPKIDsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetBalanceEntryForHODLerAndCreatorPKIDsWithTxn(txn KVTxn, snap *Snapshot,
 	hodlerPKID *PKID, creatorPKID *PKID, isDAOCoin bool) *BalanceEntry {
 
 	key := _dbKeyForHODLerPKIDCreatorPKIDToBalanceEntry(hodlerPKID, creatorPKID, isDAOCoin)
@@ -7246,11 +7290,11 @@ func DBGetBalanceEntryForHODLerAndCreatorPKIDsWithTxn(txn *badger.Txn, snap *Sna
 	return balanceEntryObj
 }
 
-func DBGetBalanceEntryForHODLerAndCreatorPKIDs(handle *badger.DB, snap *Snapshot,
+func DBGetBalanceEntryForHODLerAndCreatorPKIDs(handle KVStore, snap *Snapshot,
 	hodlerPKID *PKID, creatorPKID *PKID, isDAOCoin bool) *BalanceEntry {
 
 	var ret *BalanceEntry
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		ret = DBGetBalanceEntryForHODLerAndCreatorPKIDsWithTxn(
 			txn, snap, hodlerPKID, creatorPKID, isDAOCoin)
 		return nil
@@ -7258,7 +7302,7 @@ func DBGetBalanceEntryForHODLerAndCreatorPKIDs(handle *badger.DB, snap *Snapshot
 	return ret
 }
 
-func DBGetBalanceEntryForCreatorPKIDAndHODLerPubKeyWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBGetBalanceEntryForCreatorPKIDAndHODLerPubKeyWithTxn(txn KVTxn, snap *Snapshot,
 	creatorPKID *PKID, hodlerPKID *PKID, isDAOCoin bool) *BalanceEntry {
 
 	key := _dbKeyForCreatorPKIDHODLerPKIDToBalanceEntry(creatorPKID, hodlerPKID, isDAOCoin)
@@ -7273,7 +7317,7 @@ func DBGetBalanceEntryForCreatorPKIDAndHODLerPubKeyWithTxn(txn *badger.Txn, snap
 	return balanceEntryObj
 }
 
-func DBDeleteBalanceEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
+func DBDeleteBalanceEntryMappingsWithTxn(txn KVTxn, snap *Snapshot,
 	hodlerPKID *PKID, creatorPKID *PKID, isDAOCoin bool) error {
 
 	// First pull up the mappings that exists for the keys passed in.
@@ -7303,16 +7347,16 @@ func DBDeleteBalanceEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot,
 	return nil
 }
 
-func DBDeleteBalanceEntryMappings(handle *badger.DB, snap *Snapshot,
+func DBDeleteBalanceEntryMappings(handle KVStore, snap *Snapshot,
 	hodlerPKID *PKID, creatorPKID *PKID, isDAOCoin bool) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBDeleteBalanceEntryMappingsWithTxn(
 			txn, snap, hodlerPKID, creatorPKID, isDAOCoin)
 	})
 }
 
-func DBPutBalanceEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
+func DBPutBalanceEntryMappingsWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64,
 	balanceEntry *BalanceEntry, isDAOCoin bool) error {
 
 	// If the balance is zero, then there is no point in storing this entry.
@@ -7347,10 +7391,10 @@ func DBPutBalanceEntryMappingsWithTxn(txn *badger.Txn, snap *Snapshot, blockHeig
 	return nil
 }
 
-func DBPutBalanceEntryMappings(handle *badger.DB, snap *Snapshot, blockHeight uint64,
+func DBPutBalanceEntryMappings(handle KVStore, snap *Snapshot, blockHeight uint64,
 	balanceEntry *BalanceEntry, isDAOCoin bool) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DBPutBalanceEntryMappingsWithTxn(
 			txn, snap, blockHeight, balanceEntry, isDAOCoin)
 	})
@@ -7384,17 +7428,17 @@ func GetSingleBalanceEntryFromPublicKeys(holder []byte, creator []byte, utxoView
 }
 
 // DbGetBalanceEntry returns a balance entry from the database
-func DbGetBalanceEntry(db *badger.DB, snap *Snapshot,
+func DbGetBalanceEntry(db KVStore, snap *Snapshot,
 	holder *PKID, creator *PKID, isDAOCoin bool) *BalanceEntry {
 	var ret *BalanceEntry
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DbGetHolderPKIDCreatorPKIDToBalanceEntryWithTxn(txn, snap, holder, creator, isDAOCoin)
 		return nil
 	})
 	return ret
 }
 
-func DbGetHolderPKIDCreatorPKIDToBalanceEntryWithTxn(txn *badger.Txn, snap *Snapshot,
+func DbGetHolderPKIDCreatorPKIDToBalanceEntryWithTxn(txn KVTxn, snap *Snapshot,
 	holder *PKID, creator *PKID, isDAOCoin bool) *BalanceEntry {
 
 	key := _dbKeyForCreatorPKIDHODLerPKIDToBalanceEntry(creator, holder, isDAOCoin)
@@ -7414,7 +7458,7 @@ func DbGetHolderPKIDCreatorPKIDToBalanceEntryWithTxn(txn *badger.Txn, snap *Snap
 }
 
 // DbGetBalanceEntriesYouHold fetches the BalanceEntries that the passed in pkid holds.
-func DbGetBalanceEntriesYouHold(db *badger.DB, snap *Snapshot, pkid *PKID, filterOutZeroBalances bool, isDAOCoin bool) ([]*BalanceEntry, error) {
+func DbGetBalanceEntriesYouHold(db KVStore, snap *Snapshot, pkid *PKID, filterOutZeroBalances bool, isDAOCoin bool) ([]*BalanceEntry, error) {
 	// Get the balance entries for the coins that *you hold*
 	balanceEntriesYouHodl := []*BalanceEntry{}
 	{
@@ -7436,7 +7480,7 @@ func DbGetBalanceEntriesYouHold(db *badger.DB, snap *Snapshot, pkid *PKID, filte
 }
 
 // DbGetBalanceEntriesHodlingYou fetches the BalanceEntries that hold the pkid passed in.
-func DbGetBalanceEntriesHodlingYou(db *badger.DB, snap *Snapshot, pkid *PKID, filterOutZeroBalances bool, isDAOCoin bool) ([]*BalanceEntry, error) {
+func DbGetBalanceEntriesHodlingYou(db KVStore, snap *Snapshot, pkid *PKID, filterOutZeroBalances bool, isDAOCoin bool) ([]*BalanceEntry, error) {
 	// Get the balance entries for the coins that *hold you*
 	balanceEntriesThatHodlYou := []*BalanceEntry{}
 	{
@@ -7478,7 +7522,7 @@ func DbGetBalanceEntriesHodlingYou(db *badger.DB, snap *Snapshot, pkid *PKID, fi
 // numToFetch specifies the number of entries to fetch. If set to zero then it
 // fetches all entries that match the validForPrefix passed in.
 func DBGetPaginatedKeysAndValuesForPrefixWithTxn(
-	txn *badger.Txn, startPrefix []byte, validForPrefix []byte,
+	txn KVTxn, startPrefix []byte, validForPrefix []byte,
 	maxKeyLen int, numToFetch int, reverse bool, fetchValues bool) (
 
 	_keysFound [][]byte, _valsFound [][]byte, _err error) {
@@ -7486,7 +7530,7 @@ func DBGetPaginatedKeysAndValuesForPrefixWithTxn(
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	opts := badger.DefaultIteratorOptions
+	opts := DefaultKVIteratorOptions
 
 	opts.PrefetchValues = fetchValues
 
@@ -7540,14 +7584,14 @@ func DBGetPaginatedKeysAndValuesForPrefixWithTxn(
 }
 
 func DBGetPaginatedKeysAndValuesForPrefix(
-	db *badger.DB, startPrefix []byte, validForPrefix []byte,
+	db KVStore, startPrefix []byte, validForPrefix []byte,
 	keyLen int, numToFetch int, reverse bool, fetchValues bool) (
 	_keysFound [][]byte, _valsFound [][]byte, _err error) {
 
 	keysFound := [][]byte{}
 	valsFound := [][]byte{}
 
-	dbErr := db.View(func(txn *badger.Txn) error {
+	dbErr := db.View(func(txn KVTxn) error {
 		var err error
 		keysFound, valsFound, err = DBGetPaginatedKeysAndValuesForPrefixWithTxn(
 			txn, startPrefix, validForPrefix, keyLen,
@@ -7565,7 +7609,7 @@ func DBGetPaginatedKeysAndValuesForPrefix(
 }
 
 func DBGetPaginatedPostsOrderedByTime(
-	db *badger.DB, snap *Snapshot, startPostTimestampNanos uint64,
+	db KVStore, snap *Snapshot, startPostTimestampNanos uint64,
 	startPostHash *BlockHash, numToFetch int, fetchPostEntries bool, reverse bool) (
 	_postHashes []*BlockHash, _tstampNanos []uint64, _postEntries []*PostEntry,
 	_err error) {
@@ -7622,7 +7666,7 @@ func DBGetPaginatedPostsOrderedByTime(
 	return postHashes, tstamps, postEntries, nil
 }
 
-func DBGetProfilesByUsernamePrefixAndDeSoLocked(db *badger.DB,
+func DBGetProfilesByUsernamePrefixAndDeSoLocked(db KVStore,
 	snap *Snapshot, usernamePrefix string, utxoView *UtxoView) (
 	_profileEntries []*ProfileEntry, _err error) {
 
@@ -7656,6 +7700,7 @@ func DBGetProfilesByUsernamePrefixAndDeSoLocked(db *badger.DB,
 		}
 	}
 
+	utxoView._pullParentMappings(mappingProfileUsernameToProfileEntry)
 	for username, profileEntry := range utxoView.ProfileUsernameToProfileEntry {
 		if strings.HasPrefix(string(username[:]), lowercaseUsernamePrefixString) {
 			pkMapKey := MakePkMapKey(profileEntry.PublicKey)
@@ -7688,7 +7733,7 @@ func DBGetProfilesByUsernamePrefixAndDeSoLocked(db *badger.DB,
 
 // DBGetPaginatedProfilesByDeSoLocked returns up to 'numToFetch' profiles from the db.
 func DBGetPaginatedProfilesByDeSoLocked(
-	db *badger.DB, snap *Snapshot, startDeSoLockedNanos uint64,
+	db KVStore, snap *Snapshot, startDeSoLockedNanos uint64,
 	startProfilePubKeyy []byte, numToFetch int, fetchProfileEntries bool) (
 	_profilePublicKeys [][]byte, _profileEntries []*ProfileEntry, _err error) {
 
@@ -7793,13 +7838,13 @@ func DBKeyForDAOCoinLimitOrderByOrderID(order *DAOCoinLimitOrderEntry) []byte {
 	return key
 }
 
-func DBGetDAOCoinLimitOrder(handle *badger.DB, snap *Snapshot, orderID *BlockHash) (
+func DBGetDAOCoinLimitOrder(handle KVStore, snap *Snapshot, orderID *BlockHash) (
 	*DAOCoinLimitOrderEntry, error) {
 
 	var ret *DAOCoinLimitOrderEntry
 	var err error
 
-	handle.View(func(txn *badger.Txn) error {
+	handle.View(func(txn KVTxn) error {
 		ret, err = DBGetDAOCoinLimitOrderWithTxn(txn, snap, orderID)
 		return nil
 	})
@@ -7807,7 +7852,7 @@ func DBGetDAOCoinLimitOrder(handle *badger.DB, snap *Snapshot, orderID *BlockHas
 	return ret, err
 }
 
-func DBGetDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, orderID *BlockHash) (
+func DBGetDAOCoinLimitOrderWithTxn(txn KVTxn, snap *Snapshot, orderID *BlockHash) (
 	_order *DAOCoinLimitOrderEntry, _err error) {
 
 	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrderByOrderID...)
@@ -7816,7 +7861,7 @@ func DBGetDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, orderID *Blo
 	if err != nil {
 		// We don't want to error if the key isn't found.
 		// Instead, we just want to return nil.
-		if err == badger.ErrKeyNotFound {
+		if err == ErrKVKeyNotFound {
 			return nil, nil
 		}
 
@@ -7833,7 +7878,7 @@ func DBGetDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, orderID *Blo
 }
 
 func DBGetMatchingDAOCoinLimitOrders(
-	txn *badger.Txn, inputOrder *DAOCoinLimitOrderEntry, lastSeenOrder *DAOCoinLimitOrderEntry) ([]*DAOCoinLimitOrderEntry, error) {
+	txn KVTxn, inputOrder *DAOCoinLimitOrderEntry, lastSeenOrder *DAOCoinLimitOrderEntry) ([]*DAOCoinLimitOrderEntry, error) {
 
 	queryOrder := inputOrder.Copy()
 	queryQuantityToFill := queryOrder.QuantityToFillInBaseUnits.Clone()
@@ -7863,7 +7908,7 @@ func DBGetMatchingDAOCoinLimitOrders(
 	// Go in reverse order to find the highest prices first.
 	// We break once we hit the input order's inverted scaled
 	// price or the input order's quantity is fulfilled.
-	opts := badger.DefaultIteratorOptions
+	opts := DefaultKVIteratorOptions
 	opts.Reverse = true
 	iterator := txn.NewIterator(opts)
 	defer iterator.Close()
@@ -7910,14 +7955,14 @@ func DBGetMatchingDAOCoinLimitOrders(
 	return matchingOrders, nil
 }
 
-func DBGetAllDAOCoinLimitOrders(handle *badger.DB) ([]*DAOCoinLimitOrderEntry, error) {
+func DBGetAllDAOCoinLimitOrders(handle KVStore) ([]*DAOCoinLimitOrderEntry, error) {
 	// Get all DAO Coin limit orders.
 	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrder...)
 	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
 }
 
 func DBGetAllDAOCoinLimitOrdersForThisDAOCoinPair(
-	handle *badger.DB,
+	handle KVStore,
 	buyingDAOCoinCreatorPKID *PKID,
 	sellingDAOCoinCreatorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
 
@@ -7928,14 +7973,14 @@ func DBGetAllDAOCoinLimitOrdersForThisDAOCoinPair(
 	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
 }
 
-func DBGetAllDAOCoinLimitOrdersForThisTransactor(handle *badger.DB, transactorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
+func DBGetAllDAOCoinLimitOrdersForThisTransactor(handle KVStore, transactorPKID *PKID) ([]*DAOCoinLimitOrderEntry, error) {
 	// Get all DAO coin limit orders for this transactor.
 	key := append([]byte{}, Prefixes.PrefixDAOCoinLimitOrderByTransactorPKID...)
 	key = append(key, transactorPKID[:]...)
 	return _DBGetAllDAOCoinLimitOrdersByPrefix(handle, key)
 }
 
-func _DBGetAllDAOCoinLimitOrdersByPrefix(handle *badger.DB, prefixKey []byte) ([]*DAOCoinLimitOrderEntry, error) {
+func _DBGetAllDAOCoinLimitOrdersByPrefix(handle KVStore, prefixKey []byte) ([]*DAOCoinLimitOrderEntry, error) {
 	// Get all DAO coin limit orders containing this prefix.
 	_, valsFound := _enumerateKeysForPrefix(handle, prefixKey)
 	orders := []*DAOCoinLimitOrderEntry{}
@@ -7954,7 +7999,7 @@ func _DBGetAllDAOCoinLimitOrdersByPrefix(handle *badger.DB, prefixKey []byte) ([
 	return orders, nil
 }
 
-func DBPutDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, order *DAOCoinLimitOrderEntry, blockHeight uint64) error {
+func DBPutDAOCoinLimitOrderWithTxn(txn KVTxn, snap *Snapshot, order *DAOCoinLimitOrderEntry, blockHeight uint64) error {
 	if order == nil {
 		return nil
 	}
@@ -7982,7 +8027,7 @@ func DBPutDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, order *DAOCo
 	return nil
 }
 
-func DBDeleteDAOCoinLimitOrderWithTxn(txn *badger.Txn, snap *Snapshot, order *DAOCoinLimitOrderEntry) error {
+func DBDeleteDAOCoinLimitOrderWithTxn(txn KVTxn, snap *Snapshot, order *DAOCoinLimitOrderEntry) error {
 	if order == nil {
 		return nil
 	}
@@ -8023,7 +8068,7 @@ func _dbKeyForMempoolTxn(mempoolTx *MempoolTx) []byte {
 	return key
 }
 
-func DbPutMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
+func DbPutMempoolTxnWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
 
 	mempoolTxnBytes, err := mempoolTx.Tx.ToBytes(false /*preSignatureBool*/)
 	if err != nil {
@@ -8037,14 +8082,14 @@ func DbPutMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64,
 	return nil
 }
 
-func DbPutMempoolTxn(handle *badger.DB, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
+func DbPutMempoolTxn(handle KVStore, snap *Snapshot, blockHeight uint64, mempoolTx *MempoolTx) error {
 
-	return handle.Update(func(txn *badger.Txn) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbPutMempoolTxnWithTxn(txn, snap, blockHeight, mempoolTx)
 	})
 }
 
-func DbGetMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
+func DbGetMempoolTxnWithTxn(txn KVTxn, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
 
 	mempoolTxnObj := &MsgDeSoTxn{}
 	mempoolTxnBytes, err := DBGetWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx))
@@ -8059,16 +8104,16 @@ func DbGetMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *MempoolT
 	return mempoolTxnObj
 }
 
-func DbGetMempoolTxn(db *badger.DB, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
+func DbGetMempoolTxn(db KVStore, snap *Snapshot, mempoolTx *MempoolTx) *MsgDeSoTxn {
 	var ret *MsgDeSoTxn
-	db.View(func(txn *badger.Txn) error {
+	db.View(func(txn KVTxn) error {
 		ret = DbGetMempoolTxnWithTxn(txn, snap, mempoolTx)
 		return nil
 	})
 	return ret
 }
 
-func DbGetAllMempoolTxnsSortedByTimeAdded(handle *badger.DB) (_mempoolTxns []*MsgDeSoTxn, _error error) {
+func DbGetAllMempoolTxnsSortedByTimeAdded(handle KVStore) (_mempoolTxns []*MsgDeSoTxn, _error error) {
 	_, valuesFound := _enumerateKeysForPrefix(handle, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn)
 
 	mempoolTxns := []*MsgDeSoTxn{}
@@ -8087,7 +8132,7 @@ func DbGetAllMempoolTxnsSortedByTimeAdded(handle *badger.DB) (_mempoolTxns []*Ms
 	return mempoolTxns, nil
 }
 
-func DbDeleteAllMempoolTxnsWithTxn(txn *badger.Txn, snap *Snapshot) error {
+func DbDeleteAllMempoolTxnsWithTxn(txn KVTxn, snap *Snapshot) error {
 	txnKeysFound, _, err := _enumerateKeysForPrefixWithTxn(txn, Prefixes.PrefixMempoolTxnHashToMsgDeSoTxn)
 	if err != nil {
 		return errors.Wrapf(err, "DbDeleteAllMempoolTxnsWithTxn: ")
@@ -8103,7 +8148,7 @@ func DbDeleteAllMempoolTxnsWithTxn(txn *badger.Txn, snap *Snapshot) error {
 	return nil
 }
 
-func FlushMempoolToDbWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
+func FlushMempoolToDbWithTxn(txn KVTxn, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
 	for _, mempoolTx := range allTxns {
 		err := DbPutMempoolTxnWithTxn(txn, snap, blockHeight, mempoolTx)
 		if err != nil {
@@ -8115,8 +8160,8 @@ func FlushMempoolToDbWithTxn(txn *badger.Txn, snap *Snapshot, blockHeight uint64
 	return nil
 }
 
-func FlushMempoolToDb(handle *badger.DB, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
-	err := handle.Update(func(txn *badger.Txn) error {
+func FlushMempoolToDb(handle KVStore, snap *Snapshot, blockHeight uint64, allTxns []*MempoolTx) error {
+	err := handle.Update(func(txn KVTxn) error {
 		return FlushMempoolToDbWithTxn(txn, snap, blockHeight, allTxns)
 	})
 	if err != nil {
@@ -8126,15 +8171,15 @@ func FlushMempoolToDb(handle *badger.DB, snap *Snapshot, blockHeight uint64, all
 	return nil
 }
 
-func DbDeleteAllMempoolTxns(handle *badger.DB, snap *Snapshot) error {
-	handle.Update(func(txn *badger.Txn) error {
+func DbDeleteAllMempoolTxns(handle KVStore, snap *Snapshot) error {
+	handle.Update(func(txn KVTxn) error {
 		return DbDeleteAllMempoolTxnsWithTxn(txn, snap)
 	})
 
 	return nil
 }
 
-func DbDeleteMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *MempoolTx) error {
+func DbDeleteMempoolTxnWithTxn(txn KVTxn, snap *Snapshot, mempoolTx *MempoolTx) error {
 
 	// When a mapping exists, delete it.
 	if err := DBDeleteWithTxn(txn, snap, _dbKeyForMempoolTxn(mempoolTx)); err != nil {
@@ -8145,19 +8190,19 @@ func DbDeleteMempoolTxnWithTxn(txn *badger.Txn, snap *Snapshot, mempoolTx *Mempo
 	return nil
 }
 
-func DbDeleteMempoolTxn(handle *badger.DB, snap *Snapshot, mempoolTx *MempoolTx) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func DbDeleteMempoolTxn(handle KVStore, snap *Snapshot, mempoolTx *MempoolTx) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeleteMempoolTxnWithTxn(txn, snap, mempoolTx)
 	})
 }
 
-func DbDeleteMempoolTxnKey(handle *badger.DB, snap *Snapshot, txnKey []byte) error {
-	return handle.Update(func(txn *badger.Txn) error {
+func DbDeleteMempoolTxnKey(handle KVStore, snap *Snapshot, txnKey []byte) error {
+	return handle.Update(func(txn KVTxn) error {
 		return DbDeleteMempoolTxnKeyWithTxn(txn, snap, txnKey)
 	})
 }
 
-func DbDeleteMempoolTxnKeyWithTxn(txn *badger.Txn, snap *Snapshot, txnKey []byte) error {
+func DbDeleteMempoolTxnKeyWithTxn(txn KVTxn, snap *Snapshot, txnKey []byte) error {
 
 	// When a mapping exists, delete it.
 	if err := DBDeleteWithTxn(txn, snap, txnKey); err != nil {
@@ -8168,7 +8213,7 @@ func DbDeleteMempoolTxnKeyWithTxn(txn *badger.Txn, snap *Snapshot, txnKey []byte
 	return nil
 }
 
-func LogDBSummarySnapshot(db *badger.DB) {
+func LogDBSummarySnapshot(db KVStore) {
 	keyCountMap := make(map[byte]int)
 	for prefixByte := byte(0); prefixByte < byte(40); prefixByte++ {
 		keysForPrefix, _ := EnumerateKeysForPrefix(db, []byte{prefixByte})
@@ -8177,7 +8222,7 @@ func LogDBSummarySnapshot(db *badger.DB) {
 	glog.Info(spew.Printf("LogDBSummarySnapshot: Current DB summary snapshot: %v", keyCountMap))
 }
 
-func StartDBSummarySnapshots(db *badger.DB) {
+func StartDBSummarySnapshots(db KVStore) {
 	// Periodically count the number of keys for each prefix in the DB and log.
 	go func() {
 		for {
@@ -9,7 +9,6 @@ import (
 	"time"
 
 	"github.com/btcsuite/btcd/btcec"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -69,7 +68,7 @@ func _GetTestBlockNode() *BlockNode {
 	return &bs
 }
 
-func GetTestBadgerDb() (_db *badger.DB, _dir string) {
+func GetTestBadgerDb() (_db KVStore, _dir string) {
 	dir, err := ioutil.TempDir("", "badgerdb")
 	if err != nil {
 		log.Fatal(err)
@@ -79,7 +78,7 @@ func GetTestBadgerDb() (_db *badger.DB, _dir string) {
 	opts := PerformanceBadgerOptions(dir)
 	opts.Dir = dir
 	opts.ValueDir = dir
-	db, err := badger.Open(opts)
+	db, err := OpenBadgerKVStore(opts)
 	if err != nil {
 		log.Fatal(err)
 	}
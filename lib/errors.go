@@ -413,6 +413,7 @@ const (
 	HeaderErrorBlockDifficultyAboveTarget                                        RuleError = "HeaderErrorBlockDifficultyAboveTarget"
 	HeaderErrorHeightInvalid                                                     RuleError = "HeaderErrorHeightInvalid"
 	HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent RuleError = "HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent"
+	HeaderErrorBelowTrustedCheckpoint                                            RuleError = "HeaderErrorBelowTrustedCheckpoint"
 
 	TxErrorTooLarge                     RuleError = "TxErrorTooLarge"
 	TxErrorDuplicate                    RuleError = "TxErrorDuplicate"
@@ -3,6 +3,7 @@ package lib
 type TransactionEventFunc func(event *TransactionEvent)
 type BlockEventFunc func(event *BlockEvent)
 type SnapshotCompletedEventFunc func()
+type StateChecksumMismatchEventFunc func(event *StateChecksumMismatchEvent)
 
 type TransactionEvent struct {
 	Txn     *MsgDeSoTxn
@@ -21,12 +22,25 @@ type BlockEvent struct {
 	UtxoOps  [][]*UtxoOperation
 }
 
+type StateChecksumMismatchEvent struct {
+	// BlockHeight is the block height at which the state was scrubbed.
+	BlockHeight uint64
+	// ComputedChecksum is the checksum re-computed from the main db, and IncrementalChecksum
+	// is the checksum maintained by the Snapshot.
+	ComputedChecksum    []byte
+	IncrementalChecksum []byte
+	// MismatchedPrefixes are the state prefixes whose records changed without the checksum being
+	// updated since the previous scrub. It can be empty if the mismatch couldn't be attributed.
+	MismatchedPrefixes [][]byte
+}
+
 type EventManager struct {
-	transactionConnectedHandlers []TransactionEventFunc
-	blockConnectedHandlers       []BlockEventFunc
-	blockDisconnectedHandlers    []BlockEventFunc
-	blockAcceptedHandlers        []BlockEventFunc
-	snapshotCompletedHandlers    []SnapshotCompletedEventFunc
+	transactionConnectedHandlers  []TransactionEventFunc
+	blockConnectedHandlers        []BlockEventFunc
+	blockDisconnectedHandlers     []BlockEventFunc
+	blockAcceptedHandlers         []BlockEventFunc
+	snapshotCompletedHandlers     []SnapshotCompletedEventFunc
+	stateChecksumMismatchHandlers []StateChecksumMismatchEventFunc
 }
 
 func NewEventManager() *EventManager {
@@ -82,3 +96,13 @@ func (em *EventManager) blockAccepted(event *BlockEvent) {
 		handler(event)
 	}
 }
+
+func (em *EventManager) OnStateChecksumMismatch(handler StateChecksumMismatchEventFunc) {
+	em.stateChecksumMismatchHandlers = append(em.stateChecksumMismatchHandlers, handler)
+}
+
+func (em *EventManager) stateChecksumMismatch(event *StateChecksumMismatchEvent) {
+	for _, handler := range em.stateChecksumMismatchHandlers {
+		handler(event)
+	}
+}
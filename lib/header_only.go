@@ -0,0 +1,417 @@
+package lib
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// header_only.go implements --sync-type=header-only. A header-only node syncs and validates the header
+// chain like any other node, checking that the proof of work of every header beats the difficulty computed
+// with CalcNextDifficultyTarget, but it never downloads blocks in bulk and doesn't connect them. Since it
+// keeps no state, it can't validate transactions and doesn't take part in transaction relay.
+//
+// Instead, it checks whether a transaction is included in a block of its best header chain when asked.
+// It first asks peers that set SFTxnProofs for a MsgDeSoTxnProof, which it checks against the header with
+// VerifyTxnMerkleProof. If no peer proves it, it fetches the whole block and checks it against the header
+// by recomputing its Merkle root with ComputeMerkleRoot. Either way, the block producer signature is checked
+// against --trusted-block-producer-public-keys. Headers don't carry the block producer signature, so it can
+// only be checked on the blocks and proofs we fetch.
+//
+// Block producer public keys can also be forbidden by a transaction, which a full node records in its state.
+// A header-only node has no state, so it can't tell whether a trusted block producer was forbidden since.
+// It accepts their signatures anyway, logs a warning, counts them in the HEADER_ONLY.UNCHECKED_FORBIDDEN_PRODUCERS
+// metric, and sets ForbiddenBlockProducerUnchecked on the TxnInclusion. Deployments that rely on forbidding
+// block producers should remove them from --trusted-block-producer-public-keys on their header-only nodes too.
+//
+// This is meant for services such as wallet backends and monitoring that need trust-minimized confirmations
+// of transactions without running a full node. Like with any SPV client, a transaction is as confirmed as
+// the work built on top of its block.
+
+const (
+	// HeaderOnlyBlockRequestTimeout is how long we wait for a peer to send us a block or txn proof we
+	// requested on demand before asking another peer.
+	HeaderOnlyBlockRequestTimeout = 30 * time.Second
+
+	// HeaderOnlyMaxBlockRequestPeers is how many peers we ask for a block or txn proof before giving up.
+	HeaderOnlyMaxBlockRequestPeers = 3
+)
+
+// TxnInclusion describes a transaction that is included in a block of our best header chain.
+type TxnInclusion struct {
+	TxnHash     *BlockHash
+	BlockHash   *BlockHash
+	BlockHeight uint64
+	// TxnIndex is the position of the transaction in the block.
+	TxnIndex uint64
+	// NumConfirmations is the number of blocks in our best header chain from the block to the tip,
+	// counting the block itself.
+	NumConfirmations uint64
+	// Txn is only set if we checked the inclusion with the whole block rather than a proof.
+	Txn *MsgDeSoTxn
+	// ForbiddenBlockProducerUnchecked is set if the block producer signature was checked, but we couldn't
+	// check whether the block producer is forbidden because we're a header-only node.
+	ForbiddenBlockProducerUnchecked bool
+}
+
+// IsHeaderOnly returns true if the node runs with --sync-type=header-only.
+func (bc *Blockchain) IsHeaderOnly() bool {
+	return bc.headerOnly
+}
+
+// TakeNumUncheckedForbiddenBlockProducers returns how many block producer signatures we accepted without
+// checking whether the block producer is forbidden since the last call, and resets the count.
+func (bc *Blockchain) TakeNumUncheckedForbiddenBlockProducers() uint64 {
+	return atomic.SwapUint64(&bc.numUncheckedForbiddenBlockProducers, 0)
+}
+
+// ValidateBlockAgainstHeader checks that a block we didn't connect matches its header in our best header
+// chain: its Merkle root is the one committed to in the header, and it's signed by a trusted block producer.
+// It returns the node of the block in the best header chain.
+func (bc *Blockchain) ValidateBlockAgainstHeader(blk *MsgDeSoBlock) (*BlockNode, error) {
+	if blk == nil || blk.Header == nil {
+		return nil, fmt.Errorf("ValidateBlockAgainstHeader: Block or header is nil")
+	}
+	blockHash, err := blk.Header.Hash()
+	if err != nil {
+		return nil, errors.Wrapf(err, "ValidateBlockAgainstHeader: Problem computing block hash")
+	}
+
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+
+	// The header of the block is the one we validated, since they have the same hash.
+	blockNode, exists := bc.bestHeaderChainMap[*blockHash]
+	if !exists {
+		return nil, fmt.Errorf("ValidateBlockAgainstHeader: Block %v is not in our best header chain", blockHash)
+	}
+
+	merkleRoot, _, err := ComputeMerkleRoot(blk.Txns)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ValidateBlockAgainstHeader: Problem computing Merkle root of block %v",
+			blockHash)
+	}
+	if *merkleRoot != *blockNode.Header.TransactionMerkleRoot {
+		return nil, errors.Wrapf(RuleErrorInvalidTxnMerkleRoot, "ValidateBlockAgainstHeader: Merkle root "+
+			"of block %v is %v, but its header commits to %v", blockHash, merkleRoot,
+			blockNode.Header.TransactionMerkleRoot)
+	}
+
+	if err = bc.verifyBlockProducerSignature(blk, blockHash); err != nil {
+		return nil, errors.Wrapf(err, "ValidateBlockAgainstHeader: ")
+	}
+	return blockNode, nil
+}
+
+// GetTxnInclusion validates a block against our best header chain, and returns where the transaction is
+// in the block and how many confirmations it has.
+func (bc *Blockchain) GetTxnInclusion(blk *MsgDeSoBlock, txnHash *BlockHash) (*TxnInclusion, error) {
+	blockNode, err := bc.ValidateBlockAgainstHeader(blk)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetTxnInclusion: ")
+	}
+
+	for txnIndex, txn := range blk.Txns {
+		if *txn.Hash() != *txnHash {
+			continue
+		}
+		bc.ChainLock.RLock()
+		defer bc.ChainLock.RUnlock()
+		return bc._txnInclusion(blockNode, txnHash, uint64(txnIndex), txn)
+	}
+	return nil, fmt.Errorf("GetTxnInclusion: Txn %v is not in block %v", txnHash, blockNode.Hash)
+}
+
+// ValidateTxnProofAgainstHeader checks that a txn proof matches the header of its block in our best header
+// chain, and that the block is signed by a trusted block producer. It returns the node of the block in the
+// best header chain.
+func (bc *Blockchain) ValidateTxnProofAgainstHeader(msg *MsgDeSoTxnProof) (*BlockNode, error) {
+	if err := msg.Verify(); err != nil {
+		return nil, errors.Wrapf(err, "ValidateTxnProofAgainstHeader: ")
+	}
+
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+
+	// The proof was checked against the header in the message, which is the one we validated since they
+	// have the same hash.
+	blockNode, exists := bc.bestHeaderChainMap[*msg.BlockHash]
+	if !exists {
+		return nil, fmt.Errorf("ValidateTxnProofAgainstHeader: Block %v is not in our best header chain",
+			msg.BlockHash)
+	}
+	// The block producer signs the block hash, so the signature can be checked without the txns.
+	err := bc.verifyBlockProducerSignature(&MsgDeSoBlock{
+		Header:            blockNode.Header,
+		BlockProducerInfo: msg.BlockProducerInfo,
+	}, blockNode.Hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ValidateTxnProofAgainstHeader: ")
+	}
+	return blockNode, nil
+}
+
+// GetTxnInclusionFromProof checks a txn proof against the header in our best header chain, and returns
+// where the transaction is in the block and how many confirmations it has.
+func (bc *Blockchain) GetTxnInclusionFromProof(msg *MsgDeSoTxnProof) (*TxnInclusion, error) {
+	blockNode, err := bc.ValidateTxnProofAgainstHeader(msg)
+	if err != nil {
+		return nil, errors.Wrapf(err, "GetTxnInclusionFromProof: ")
+	}
+
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+	return bc._txnInclusion(blockNode, msg.TxnHash, msg.Proof.TxnIndex, nil)
+}
+
+// _txnInclusion returns the inclusion of a transaction in a block of our best header chain. It must be
+// called with the ChainLock held.
+func (bc *Blockchain) _txnInclusion(blockNode *BlockNode, txnHash *BlockHash, txnIndex uint64,
+	txn *MsgDeSoTxn) (*TxnInclusion, error) {
+
+	// The block may have been reorged out of the best header chain since we validated it, in which
+	// case the header tip is no longer a descendant of the block.
+	headerTip := bc.headerTip()
+	if headerTip.Height < blockNode.Height || headerTip.Ancestor(blockNode.Height) != blockNode {
+		return nil, fmt.Errorf("_txnInclusion: Block %v is no longer in our best header chain", blockNode.Hash)
+	}
+	return &TxnInclusion{
+		TxnHash:          txnHash,
+		BlockHash:        blockNode.Hash,
+		BlockHeight:      uint64(blockNode.Height),
+		TxnIndex:         txnIndex,
+		NumConfirmations: uint64(headerTip.Height-blockNode.Height) + 1,
+		Txn:              txn,
+		ForbiddenBlockProducerUnchecked: bc.headerOnly && len(bc.trustedBlockProducerPublicKeys) > 0 &&
+			uint64(blockNode.Height) >= bc.trustedBlockProducerStartHeight,
+	}, nil
+}
+
+// VerifyTxnInclusion checks that the transaction is included in a block of our best header chain. If we
+// don't have the block, we ask our peers for a proof, and fetch the block if none of them proves it.
+func (srv *Server) VerifyTxnInclusion(txnHash *BlockHash, blockHash *BlockHash) (*TxnInclusion, error) {
+	if blk := srv.blockchain.GetBlock(blockHash); blk != nil {
+		return srv.blockchain.GetTxnInclusion(blk, txnHash)
+	}
+
+	proof, err := srv.FetchTxnProof(blockHash, txnHash)
+	if err == nil {
+		return srv.blockchain.GetTxnInclusionFromProof(proof)
+	}
+	glog.V(1).Infof("Server.VerifyTxnInclusion: Fetching block %v since we couldn't get a proof for "+
+		"txn %v: %v", blockHash, txnHash, err)
+
+	blk, err := srv.FetchBlock(blockHash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "VerifyTxnInclusion: ")
+	}
+	return srv.blockchain.GetTxnInclusion(blk, txnHash)
+}
+
+// FetchBlock requests a block of our best header chain from our peers, one at a time, until one of them
+// sends a block that matches the header. Peers that keep all historical blocks are asked first.
+func (srv *Server) FetchBlock(blockHash *BlockHash) (*MsgDeSoBlock, error) {
+	peers, err := srv._onDemandRequestPeers(blockHash, SFFullNodeDeprecated)
+	if err != nil {
+		return nil, errors.Wrapf(err, "FetchBlock: ")
+	}
+	request := onDemandRequestKey{MsgType: MsgTypeBlock, BlockHash: *blockHash}
+	msg, err := srv._requestOnDemand(peers, request, &MsgDeSoGetBlocks{HashList: []*BlockHash{blockHash}},
+		PeerMisbehaviorInvalidBlock, func(msg DeSoMessage) error {
+			_, err := srv.blockchain.ValidateBlockAgainstHeader(msg.(*MsgDeSoBlock))
+			return err
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "FetchBlock: Problem fetching block %v", blockHash)
+	}
+	return msg.(*MsgDeSoBlock), nil
+}
+
+// FetchTxnProof requests a proof that the transaction is in a block of our best header chain from our
+// peers that set SFTxnProofs, one at a time, until one of them sends a valid proof.
+func (srv *Server) FetchTxnProof(blockHash *BlockHash, txnHash *BlockHash) (*MsgDeSoTxnProof, error) {
+	peers, err := srv._onDemandRequestPeers(blockHash, SFFullNodeDeprecated|SFTxnProofs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "FetchTxnProof: ")
+	}
+	request := onDemandRequestKey{MsgType: MsgTypeTxnProof, BlockHash: *blockHash, TxnHash: *txnHash}
+	msg, err := srv._requestOnDemand(peers, request, &MsgDeSoGetTxnProof{BlockHash: blockHash, TxnHash: txnHash},
+		PeerMisbehaviorInvalidTxnProof, func(msg DeSoMessage) error {
+			proof := msg.(*MsgDeSoTxnProof)
+			if proof.Header == nil || proof.Proof == nil {
+				return errOnDemandNotFound
+			}
+			_, err := srv.blockchain.ValidateTxnProofAgainstHeader(proof)
+			return err
+		})
+	if err != nil {
+		return nil, errors.Wrapf(err, "FetchTxnProof: Problem fetching proof of txn %v in block %v",
+			txnHash, blockHash)
+	}
+	return msg.(*MsgDeSoTxnProof), nil
+}
+
+// _onDemandRequestPeers returns the peers we can ask for data of a block of our best header chain,
+// archival peers first.
+func (srv *Server) _onDemandRequestPeers(blockHash *BlockHash, requiredServices ServiceFlag) ([]*Peer, error) {
+	srv.blockchain.ChainLock.RLock()
+	blockNode, exists := srv.blockchain.bestHeaderChainMap[*blockHash]
+	srv.blockchain.ChainLock.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("_onDemandRequestPeers: Block %v is not in our best header chain", blockHash)
+	}
+
+	var archivalPeers, otherPeers []*Peer
+	for _, pp := range srv.cmgr.GetAllPeers() {
+		pp.PeerInfoMtx.Lock()
+		serviceFlags := pp.serviceFlags
+		pp.PeerInfoMtx.Unlock()
+		if !pp.Connected() || serviceFlags&requiredServices != requiredServices ||
+			pp.StartingBlockHeight() < blockNode.Height {
+			continue
+		}
+		if serviceFlags&SFArchivalNode != 0 {
+			archivalPeers = append(archivalPeers, pp)
+		} else {
+			otherPeers = append(otherPeers, pp)
+		}
+	}
+	peers := append(archivalPeers, otherPeers...)
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("_onDemandRequestPeers: No connected peer has block %v at height %v",
+			blockHash, blockNode.Height)
+	}
+	if len(peers) > HeaderOnlyMaxBlockRequestPeers {
+		peers = peers[:HeaderOnlyMaxBlockRequestPeers]
+	}
+	return peers, nil
+}
+
+// onDemandRequestKey identifies a response we wait for in header-only mode: a block, or a proof that a
+// transaction is in a block, from the peer we asked for it.
+type onDemandRequestKey struct {
+	MsgType   MsgType
+	BlockHash BlockHash
+	TxnHash   BlockHash
+	PeerID    uint64
+}
+
+// errOnDemandNotFound is returned when validating a response in which the peer tells us it doesn't have
+// what we asked for, which an honest peer can do.
+var errOnDemandNotFound = errors.New("Peer doesn't have the requested data")
+
+// _requestOnDemand sends the request to the peers one at a time, and returns the first response that passes
+// validate. We wait up to HeaderOnlyBlockRequestTimeout for each peer, and only accept a response from the
+// peer we're currently asking, so that a peer can't answer in place of the next ones. A peer whose response
+// fails validate is scored with misbehavior.
+func (srv *Server) _requestOnDemand(peers []*Peer, key onDemandRequestKey, request DeSoMessage,
+	misbehavior PeerMisbehavior, validate func(DeSoMessage) error) (DeSoMessage, error) {
+
+	for _, pp := range peers {
+		msg, err := srv._requestOnDemandFromPeer(pp, key, request)
+		if err != nil {
+			glog.V(1).Infof("Server._requestOnDemand: %v", err)
+			continue
+		}
+		if err = validate(msg); err != nil {
+			if err == errOnDemandNotFound {
+				glog.V(1).Infof("Server._requestOnDemand: Peer %v doesn't have the %v we asked for",
+					pp, key.MsgType)
+				continue
+			}
+			pp.Misbehaving(misbehavior, fmt.Sprintf("Sent an invalid %v: %v", msg.GetMsgType(), err))
+			continue
+		}
+		return msg, nil
+	}
+	return nil, fmt.Errorf("_requestOnDemand: No valid %v from %v peers", key.MsgType, len(peers))
+}
+
+// _requestOnDemandFromPeer sends the request to the peer and waits up to HeaderOnlyBlockRequestTimeout for
+// its response.
+func (srv *Server) _requestOnDemandFromPeer(pp *Peer, key onDemandRequestKey, request DeSoMessage) (
+	DeSoMessage, error) {
+
+	key.PeerID = pp.ID
+	responseChan := srv._addOnDemandRequest(key)
+	defer srv._removeOnDemandRequest(key, responseChan)
+
+	glog.V(1).Infof("Server._requestOnDemandFromPeer: Sending %v to peer %v", request.GetMsgType(), pp)
+	pp.AddDeSoMessage(request, false)
+	select {
+	case msg := <-responseChan:
+		return msg, nil
+	case <-time.After(HeaderOnlyBlockRequestTimeout):
+		return nil, fmt.Errorf("_requestOnDemandFromPeer: Timed out waiting for %v from peer %v",
+			key.MsgType, pp)
+	}
+}
+
+func (srv *Server) _addOnDemandRequest(key onDemandRequestKey) chan DeSoMessage {
+	srv.onDemandRequestsLock.Lock()
+	defer srv.onDemandRequestsLock.Unlock()
+
+	responseChan := make(chan DeSoMessage, 1)
+	srv.onDemandRequests[key] = append(srv.onDemandRequests[key], responseChan)
+	return responseChan
+}
+
+func (srv *Server) _removeOnDemandRequest(key onDemandRequestKey, responseChan chan DeSoMessage) {
+	srv.onDemandRequestsLock.Lock()
+	defer srv.onDemandRequestsLock.Unlock()
+
+	responseChans := srv.onDemandRequests[key]
+	for ii, requestChan := range responseChans {
+		if requestChan == responseChan {
+			responseChans = append(responseChans[:ii], responseChans[ii+1:]...)
+			break
+		}
+	}
+	if len(responseChans) == 0 {
+		delete(srv.onDemandRequests, key)
+		return
+	}
+	srv.onDemandRequests[key] = responseChans
+}
+
+// _deliverOnDemandResponse hands a response to the requests waiting for it. It returns false if no
+// request is waiting, for example because it timed out.
+func (srv *Server) _deliverOnDemandResponse(key onDemandRequestKey, msg DeSoMessage) bool {
+	srv.onDemandRequestsLock.Lock()
+	defer srv.onDemandRequestsLock.Unlock()
+
+	responseChans, exists := srv.onDemandRequests[key]
+	if !exists {
+		return false
+	}
+	for _, responseChan := range responseChans {
+		select {
+		case responseChan <- msg:
+		default:
+		}
+	}
+	return true
+}
+
+// _handleHeaderOnlyBlock hands a block we received in header-only mode to the requests waiting for it from
+// the peer. Blocks we didn't ask the peer for, or that arrive after the request timed out, are dropped.
+func (srv *Server) _handleHeaderOnlyBlock(pp *Peer, blk *MsgDeSoBlock, blockHash *BlockHash) {
+	key := onDemandRequestKey{MsgType: MsgTypeBlock, BlockHash: *blockHash, PeerID: pp.ID}
+	if !srv._deliverOnDemandResponse(key, blk) {
+		glog.V(1).Infof("Server._handleHeaderOnlyBlock: Dropping block %v from peer %v because no "+
+			"request is waiting for it", blockHash, pp)
+	}
+}
+
+// _handleTxnProof hands a txn proof to the requests waiting for it from the peer.
+func (srv *Server) _handleTxnProof(pp *Peer, msg *MsgDeSoTxnProof) {
+	key := onDemandRequestKey{MsgType: MsgTypeTxnProof, BlockHash: *msg.BlockHash, TxnHash: *msg.TxnHash,
+		PeerID: pp.ID}
+	if !srv._deliverOnDemandResponse(key, msg) {
+		glog.V(1).Infof("Server._handleTxnProof: Dropping proof of txn %v in block %v from peer %v because "+
+			"no request is waiting for it", msg.TxnHash, msg.BlockHash, pp)
+	}
+}
@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderOnlyTxnInclusion(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	mempool, miner := NewTestMiner(t, chain, params, true /*isSender*/)
+	var blocks []*MsgDeSoBlock
+	for ii := 0; ii < 3; ii++ {
+		blk, err := miner.MineAndProcessSingleBlock(0 /*threadIndex*/, mempool)
+		require.NoError(err)
+		blocks = append(blocks, blk)
+	}
+
+	// The header-only chain only processes the headers, and is current once it has them all.
+	headerChain, _, _ := NewLowDifficultyBlockchain()
+	headerChain.headerOnly = true
+	require.True(headerChain.IsHeaderOnly())
+	for _, blk := range blocks {
+		blockHash, err := blk.Header.Hash()
+		require.NoError(err)
+		isMainChain, isOrphan, err := headerChain.ProcessHeader(blk.Header, blockHash)
+		require.NoError(err)
+		require.True(isMainChain)
+		require.False(isOrphan)
+	}
+	require.Equal(SyncStateFullyCurrent, headerChain.chainState())
+	require.Equal(uint32(0), headerChain.BlockTip().Height)
+
+	// The block reward of the first block is confirmed by all three blocks.
+	firstBlock := blocks[0]
+	firstBlockHash, err := firstBlock.Header.Hash()
+	require.NoError(err)
+	inclusion, err := headerChain.GetTxnInclusion(firstBlock, firstBlock.Txns[0].Hash())
+	require.NoError(err)
+	require.Equal(*firstBlockHash, *inclusion.BlockHash)
+	require.Equal(uint64(1), inclusion.BlockHeight)
+	require.Equal(uint64(0), inclusion.TxnIndex)
+	require.Equal(uint64(3), inclusion.NumConfirmations)
+
+	// A header-only chain can't check whether the block producer is forbidden, and says so.
+	require.True(inclusion.ForbiddenBlockProducerUnchecked)
+	require.Equal(uint64(1), headerChain.TakeNumUncheckedForbiddenBlockProducers())
+	require.Equal(uint64(0), headerChain.TakeNumUncheckedForbiddenBlockProducers())
+	fullInclusion, err := chain.GetTxnInclusion(firstBlock, firstBlock.Txns[0].Hash())
+	require.NoError(err)
+	require.False(fullInclusion.ForbiddenBlockProducerUnchecked)
+
+	// A proof from a full node shows the same, without the rest of the block.
+	proofMsg := chain.NewTxnProofMessage(firstBlockHash, firstBlock.Txns[0].Hash())
+	proofInclusion, err := headerChain.GetTxnInclusionFromProof(proofMsg)
+	require.NoError(err)
+	require.Equal(uint64(3), proofInclusion.NumConfirmations)
+	require.Nil(proofInclusion.Txn)
+	proofMsg.BlockProducerInfo = nil
+	_, err = headerChain.GetTxnInclusionFromProof(proofMsg)
+	require.Equal(RuleErrorMissingBlockProducerSignature, errors.Cause(err))
+
+	// A txn that isn't in the block isn't included.
+	_, err = headerChain.GetTxnInclusion(firstBlock, blocks[1].Txns[0].Hash())
+	require.Error(err)
+
+	// A block whose txns don't match its header is rejected.
+	tamperedBlock := *firstBlock
+	tamperedBlock.Txns = blocks[1].Txns
+	_, err = headerChain.ValidateBlockAgainstHeader(&tamperedBlock)
+	require.Equal(RuleErrorInvalidTxnMerkleRoot, errors.Cause(err))
+
+	// So is a block without the signature of a trusted block producer.
+	unsignedBlock := *firstBlock
+	unsignedBlock.BlockProducerInfo = nil
+	_, err = headerChain.ValidateBlockAgainstHeader(&unsignedBlock)
+	require.Equal(RuleErrorMissingBlockProducerSignature, errors.Cause(err))
+}
+
+func TestHeaderOnlyOnDemandDelivery(t *testing.T) {
+	require := require.New(t)
+
+	srv := &Server{
+		onDemandRequests: make(map[onDemandRequestKey][]chan DeSoMessage),
+	}
+	askedPeer := &Peer{ID: 1}
+	otherPeer := &Peer{ID: 2}
+	blk := &MsgDeSoBlock{Header: &MsgDeSoHeader{Height: 1}}
+	blockHash, err := blk.Header.Hash()
+	require.NoError(err)
+	blockKey := onDemandRequestKey{MsgType: MsgTypeBlock, BlockHash: *blockHash, PeerID: askedPeer.ID}
+
+	// Blocks nobody asked for are dropped.
+	require.False(srv._deliverOnDemandResponse(blockKey, blk))
+
+	// Every request waiting for the block from the peer gets it, and only it.
+	firstChan := srv._addOnDemandRequest(blockKey)
+	secondChan := srv._addOnDemandRequest(blockKey)
+	proofChan := srv._addOnDemandRequest(onDemandRequestKey{
+		MsgType: MsgTypeTxnProof, BlockHash: *blockHash, PeerID: askedPeer.ID})
+	srv._handleHeaderOnlyBlock(otherPeer, blk, blockHash)
+	require.Empty(firstChan)
+	srv._handleHeaderOnlyBlock(askedPeer, blk, blockHash)
+	require.Equal(blk, <-firstChan)
+	require.Equal(blk, <-secondChan)
+	require.Empty(proofChan)
+
+	srv._removeOnDemandRequest(blockKey, firstChan)
+	require.Len(srv.onDemandRequests[blockKey], 1)
+	srv._removeOnDemandRequest(blockKey, secondChan)
+	require.NotContains(srv.onDemandRequests, blockKey)
+}
@@ -0,0 +1,517 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/google/btree"
+)
+
+var (
+	// ErrKVKeyNotFound is returned by KVTxn.Get when the key doesn't exist.
+	ErrKVKeyNotFound = errors.New("KVStore: Key not found")
+	// ErrKVConflict is returned when committing an update that read a key another transaction wrote after the
+	// update started. The update can be retried.
+	ErrKVConflict = errors.New("KVStore: Transaction conflict, please retry")
+	// ErrKVReadOnlyTxn is returned when writing in a read-only transaction.
+	ErrKVReadOnlyTxn = errors.New("KVStore: No writes are allowed in a read-only transaction")
+	// ErrKVDiscardedTxn is returned when using a transaction that was committed or discarded.
+	ErrKVDiscardedTxn = errors.New("KVStore: Transaction has already been committed or discarded")
+)
+
+// KVStore is the key-value storage interface used by the UtxoView, the db_utils helpers, and the Snapshot. It's a
+// narrow subset of what Badger offers, so that the node can run on top of other engines, and so that tests can run
+// on the MemoryKVStore instead of creating temporary Badger directories.
+//
+// The interface intentionally mirrors the Badger API, which lets the existing code call it the same way it used
+// to call Badger. Implementations report errors with the ErrKV errors below rather than their own, so callers
+// don't depend on the engine.
+type KVStore interface {
+	// View runs fn in a read-only transaction.
+	View(fn func(txn KVTxn) error) error
+	// Update runs fn in a read-write transaction, and commits it atomically if fn doesn't return an error.
+	Update(fn func(txn KVTxn) error) error
+	// NewTransaction creates a transaction that has to be committed or discarded by the caller.
+	NewTransaction(update bool) KVTxn
+	// NewWriteBatch creates a batch for bulk writes, which are not atomic as a whole.
+	NewWriteBatch() KVWriteBatch
+	Close() error
+}
+
+// KVTxn is a transaction in the KVStore. Reads in the transaction see the state at the time it was
+// created, as well as its own writes.
+type KVTxn interface {
+	Get(key []byte) (KVItem, error)
+	Set(key []byte, value []byte) error
+	Delete(key []byte) error
+	NewIterator(opts KVIteratorOptions) KVIterator
+	Commit() error
+	Discard()
+}
+
+// KVItem is a key-value pair returned by KVTxn.Get or KVIterator.Item. Slices passed to Value and returned by
+// Key are only valid until the transaction ends, use ValueCopy and KeyCopy to keep them longer.
+type KVItem interface {
+	Key() []byte
+	KeyCopy(dst []byte) []byte
+	Value(fn func(val []byte) error) error
+	ValueCopy(dst []byte) ([]byte, error)
+}
+
+// KVIterator iterates over the keys in lexicographic order, or in reverse order if KVIteratorOptions.Reverse
+// is set. In reverse mode, Seek positions the iterator at the largest key smaller or equal to the seek key.
+type KVIterator interface {
+	Seek(key []byte)
+	Valid() bool
+	ValidForPrefix(prefix []byte) bool
+	Next()
+	Item() KVItem
+	Close()
+}
+
+// KVWriteBatch buffers writes and commits them in as many transactions as needed.
+type KVWriteBatch interface {
+	Set(key []byte, value []byte) error
+	Delete(key []byte) error
+	Flush() error
+	Cancel()
+}
+
+type KVIteratorOptions struct {
+	// PrefetchValues is a hint that the values will be read, it's ignored by implementations that don't need it.
+	PrefetchValues bool
+	// Reverse iterates over keys in descending order.
+	Reverse bool
+	// Prefix restricts the iterator to the keys starting with the prefix.
+	Prefix []byte
+}
+
+var DefaultKVIteratorOptions = KVIteratorOptions{
+	PrefetchValues: true,
+}
+
+// ------------------------------------------------------------------------------------------------------------------
+// Badger
+// ------------------------------------------------------------------------------------------------------------------
+
+// BadgerKVStore is the KVStore backed by Badger.
+type BadgerKVStore struct {
+	db *badger.DB
+}
+
+func NewBadgerKVStore(db *badger.DB) *BadgerKVStore {
+	return &BadgerKVStore{db: db}
+}
+
+// OpenBadgerKVStore opens a Badger db with the provided options.
+func OpenBadgerKVStore(opts badger.Options) (*BadgerKVStore, error) {
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return NewBadgerKVStore(db), nil
+}
+
+// BadgerDB returns the underlying Badger db, for the few operations, like value log GC, that are Badger-specific.
+func (store *BadgerKVStore) BadgerDB() *badger.DB {
+	return store.db
+}
+
+func (store *BadgerKVStore) View(fn func(txn KVTxn) error) error {
+	return _badgerKVError(store.db.View(func(txn *badger.Txn) error {
+		return fn(&badgerKVTxn{txn: txn})
+	}))
+}
+
+func (store *BadgerKVStore) Update(fn func(txn KVTxn) error) error {
+	return _badgerKVError(store.db.Update(func(txn *badger.Txn) error {
+		return fn(&badgerKVTxn{txn: txn})
+	}))
+}
+
+func (store *BadgerKVStore) NewTransaction(update bool) KVTxn {
+	return &badgerKVTxn{txn: store.db.NewTransaction(update)}
+}
+
+func (store *BadgerKVStore) NewWriteBatch() KVWriteBatch {
+	return store.db.NewWriteBatch()
+}
+
+func (store *BadgerKVStore) Close() error {
+	return store.db.Close()
+}
+
+// _badgerKVError translates the Badger errors that have a KVStore counterpart.
+func _badgerKVError(err error) error {
+	switch err {
+	case badger.ErrKeyNotFound:
+		return ErrKVKeyNotFound
+	case badger.ErrConflict:
+		return ErrKVConflict
+	case badger.ErrReadOnlyTxn:
+		return ErrKVReadOnlyTxn
+	case badger.ErrDiscardedTxn:
+		return ErrKVDiscardedTxn
+	}
+	return err
+}
+
+type badgerKVTxn struct {
+	txn *badger.Txn
+}
+
+func (txn *badgerKVTxn) Get(key []byte) (KVItem, error) {
+	item, err := txn.txn.Get(key)
+	if err != nil {
+		return nil, _badgerKVError(err)
+	}
+	return item, nil
+}
+
+func (txn *badgerKVTxn) Set(key []byte, value []byte) error {
+	return _badgerKVError(txn.txn.Set(key, value))
+}
+
+func (txn *badgerKVTxn) Delete(key []byte) error {
+	return _badgerKVError(txn.txn.Delete(key))
+}
+
+func (txn *badgerKVTxn) NewIterator(opts KVIteratorOptions) KVIterator {
+	badgerOpts := badger.DefaultIteratorOptions
+	badgerOpts.PrefetchValues = opts.PrefetchValues
+	badgerOpts.Reverse = opts.Reverse
+	badgerOpts.Prefix = opts.Prefix
+	return &badgerKVIterator{txn.txn.NewIterator(badgerOpts)}
+}
+
+func (txn *badgerKVTxn) Commit() error {
+	return _badgerKVError(txn.txn.Commit())
+}
+
+func (txn *badgerKVTxn) Discard() {
+	txn.txn.Discard()
+}
+
+// badgerKVIterator only overrides Item, which returns a concrete type in Badger.
+type badgerKVIterator struct {
+	*badger.Iterator
+}
+
+func (it *badgerKVIterator) Item() KVItem {
+	return it.Iterator.Item()
+}
+
+// ------------------------------------------------------------------------------------------------------------------
+// In-memory
+// ------------------------------------------------------------------------------------------------------------------
+
+// MemoryKVStore is a KVStore that keeps everything in memory. Committed data is never modified in place. Instead,
+// every commit creates a new version of the btree that holds it, which lets transactions keep reading the version
+// they started with without holding any locks. New versions share all the nodes that the commit didn't touch.
+//
+// Like Badger, commits detect conflicts: an update fails with ErrKVConflict if another transaction committed a
+// write to one of the keys it read after it started.
+type MemoryKVStore struct {
+	mtx  sync.RWMutex
+	data *btree.BTree
+	// version is incremented by every commit.
+	version uint64
+	// keyVersions holds the version that last wrote each key, including deletes. It's only accessed under mtx.
+	keyVersions map[string]uint64
+}
+
+// memoryKVBTreeDegree is the degree of the btrees that hold the data of the MemoryKVStore.
+const memoryKVBTreeDegree = 32
+
+// memoryKVPair is an item in the btree of a MemoryKVStore.
+type memoryKVPair struct {
+	key   string
+	value []byte
+}
+
+func (pair *memoryKVPair) Less(than btree.Item) bool {
+	return pair.key < than.(*memoryKVPair).key
+}
+
+func NewMemoryKVStore() *MemoryKVStore {
+	return &MemoryKVStore{
+		data:        btree.New(memoryKVBTreeDegree),
+		keyVersions: make(map[string]uint64),
+	}
+}
+
+func (store *MemoryKVStore) View(fn func(txn KVTxn) error) error {
+	txn := store.NewTransaction(false)
+	defer txn.Discard()
+	return fn(txn)
+}
+
+func (store *MemoryKVStore) Update(fn func(txn KVTxn) error) error {
+	txn := store.NewTransaction(true)
+	defer txn.Discard()
+	if err := fn(txn); err != nil {
+		return err
+	}
+	return txn.Commit()
+}
+
+func (store *MemoryKVStore) NewTransaction(update bool) KVTxn {
+	store.mtx.RLock()
+	defer store.mtx.RUnlock()
+
+	return &memoryKVTxn{
+		store:       store,
+		data:        store.data,
+		readVersion: store.version,
+		update:      update,
+		writes:      make(map[string]*memoryKVWrite),
+		reads:       make(map[string]struct{}),
+	}
+}
+
+func (store *MemoryKVStore) NewWriteBatch() KVWriteBatch {
+	return &memoryKVWriteBatch{txn: store.NewTransaction(true).(*memoryKVTxn)}
+}
+
+func (store *MemoryKVStore) Close() error {
+	return nil
+}
+
+// commit checks that none of the keys read by a transaction that started at readVersion have been written since,
+// and then creates a new version of the data with the writes applied.
+func (store *MemoryKVStore) commit(readVersion uint64, reads map[string]struct{}, writes map[string]*memoryKVWrite) error {
+	store.mtx.Lock()
+	defer store.mtx.Unlock()
+
+	for key := range reads {
+		if store.keyVersions[key] > readVersion {
+			return ErrKVConflict
+		}
+	}
+
+	store.version++
+	newData := store.data.Clone()
+	for key, write := range writes {
+		if write.deleted {
+			newData.Delete(&memoryKVPair{key: key})
+		} else {
+			newData.ReplaceOrInsert(&memoryKVPair{key: key, value: write.value})
+		}
+		store.keyVersions[key] = store.version
+	}
+	store.data = newData
+	return nil
+}
+
+type memoryKVWrite struct {
+	value   []byte
+	deleted bool
+}
+
+type memoryKVTxn struct {
+	store       *MemoryKVStore
+	data        *btree.BTree
+	readVersion uint64
+	update      bool
+	writes      map[string]*memoryKVWrite
+	// reads are the keys an update read, which are checked for conflicts when it commits.
+	reads     map[string]struct{}
+	discarded bool
+}
+
+func (txn *memoryKVTxn) get(key string) ([]byte, bool) {
+	if write, exists := txn.writes[key]; exists {
+		return write.value, !write.deleted
+	}
+	if txn.update {
+		txn.reads[key] = struct{}{}
+	}
+	item := txn.data.Get(&memoryKVPair{key: key})
+	if item == nil {
+		return nil, false
+	}
+	return item.(*memoryKVPair).value, true
+}
+
+func (txn *memoryKVTxn) Get(key []byte) (KVItem, error) {
+	if txn.discarded {
+		return nil, ErrKVDiscardedTxn
+	}
+	value, exists := txn.get(string(key))
+	if !exists {
+		return nil, ErrKVKeyNotFound
+	}
+	return &memoryKVItem{key: key, value: value}, nil
+}
+
+func (txn *memoryKVTxn) Set(key []byte, value []byte) error {
+	if err := txn.checkWritable(); err != nil {
+		return err
+	}
+	txn.writes[string(key)] = &memoryKVWrite{value: append([]byte{}, value...)}
+	return nil
+}
+
+func (txn *memoryKVTxn) Delete(key []byte) error {
+	if err := txn.checkWritable(); err != nil {
+		return err
+	}
+	txn.writes[string(key)] = &memoryKVWrite{deleted: true}
+	return nil
+}
+
+func (txn *memoryKVTxn) checkWritable() error {
+	if txn.discarded {
+		return ErrKVDiscardedTxn
+	}
+	if !txn.update {
+		return ErrKVReadOnlyTxn
+	}
+	return nil
+}
+
+// NewIterator collects the keys visible to the transaction upfront, which is simple and fast enough for the
+// amounts of data the MemoryKVStore is meant for.
+func (txn *memoryKVTxn) NewIterator(opts KVIteratorOptions) KVIterator {
+	prefix := string(opts.Prefix)
+	keySet := make(map[string]struct{})
+	txn.data.AscendGreaterOrEqual(&memoryKVPair{key: prefix}, func(item btree.Item) bool {
+		key := item.(*memoryKVPair).key
+		if !strings.HasPrefix(key, prefix) {
+			return false
+		}
+		keySet[key] = struct{}{}
+		return true
+	})
+	for key, write := range txn.writes {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if write.deleted {
+			delete(keySet, key)
+		} else {
+			keySet[key] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(keySet))
+	for key := range keySet {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if opts.Reverse {
+		for ii, jj := 0, len(keys)-1; ii < jj; ii, jj = ii+1, jj-1 {
+			keys[ii], keys[jj] = keys[jj], keys[ii]
+		}
+	}
+
+	return &memoryKVIterator{
+		txn:     txn,
+		keys:    keys,
+		reverse: opts.Reverse,
+	}
+}
+
+func (txn *memoryKVTxn) Commit() error {
+	if txn.discarded {
+		return ErrKVDiscardedTxn
+	}
+	txn.discarded = true
+	if len(txn.writes) > 0 {
+		return txn.store.commit(txn.readVersion, txn.reads, txn.writes)
+	}
+	return nil
+}
+
+func (txn *memoryKVTxn) Discard() {
+	txn.discarded = true
+}
+
+type memoryKVItem struct {
+	key   []byte
+	value []byte
+}
+
+func (item *memoryKVItem) Key() []byte {
+	return item.key
+}
+
+func (item *memoryKVItem) KeyCopy(dst []byte) []byte {
+	return append(dst[:0], item.key...)
+}
+
+func (item *memoryKVItem) Value(fn func(val []byte) error) error {
+	return fn(item.value)
+}
+
+func (item *memoryKVItem) ValueCopy(dst []byte) ([]byte, error) {
+	return append(dst[:0], item.value...), nil
+}
+
+type memoryKVIterator struct {
+	txn     *memoryKVTxn
+	keys    []string
+	reverse bool
+	index   int
+}
+
+func (it *memoryKVIterator) Seek(key []byte) {
+	seekKey := string(key)
+	if !it.reverse {
+		it.index = sort.Search(len(it.keys), func(ii int) bool {
+			return it.keys[ii] >= seekKey
+		})
+	} else {
+		it.index = sort.Search(len(it.keys), func(ii int) bool {
+			return it.keys[ii] <= seekKey
+		})
+	}
+}
+
+func (it *memoryKVIterator) Valid() bool {
+	return it.index < len(it.keys)
+}
+
+func (it *memoryKVIterator) ValidForPrefix(prefix []byte) bool {
+	return it.Valid() && bytes.HasPrefix([]byte(it.keys[it.index]), prefix)
+}
+
+func (it *memoryKVIterator) Next() {
+	it.index++
+}
+
+func (it *memoryKVIterator) Item() KVItem {
+	key := it.keys[it.index]
+	value, _ := it.txn.get(key)
+	return &memoryKVItem{key: []byte(key), value: value}
+}
+
+func (it *memoryKVIterator) Close() {
+}
+
+type memoryKVWriteBatch struct {
+	txn *memoryKVTxn
+}
+
+func (wb *memoryKVWriteBatch) Set(key []byte, value []byte) error {
+	return wb.txn.Set(key, value)
+}
+
+func (wb *memoryKVWriteBatch) Delete(key []byte) error {
+	return wb.txn.Delete(key)
+}
+
+func (wb *memoryKVWriteBatch) Flush() error {
+	if err := wb.txn.Commit(); err != nil {
+		return fmt.Errorf("memoryKVWriteBatch.Flush: %v", err)
+	}
+	return nil
+}
+
+func (wb *memoryKVWriteBatch) Cancel() {
+	wb.txn.Discard()
+}
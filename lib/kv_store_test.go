@@ -0,0 +1,236 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKVStore(t *testing.T) {
+	badgerDb, badgerDir := GetTestBadgerDb()
+	defer os.RemoveAll(badgerDir)
+	defer badgerDb.Close()
+
+	stores := map[string]KVStore{
+		"badger": badgerDb,
+		"memory": NewMemoryKVStore(),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			_testKVStore(t, store)
+		})
+	}
+}
+
+func _testKVStore(t *testing.T, store KVStore) {
+	require := require.New(t)
+
+	getValue := func(txn KVTxn, key string) []byte {
+		item, err := txn.Get([]byte(key))
+		if err == ErrKVKeyNotFound {
+			return nil
+		}
+		require.NoError(err)
+		value, err := item.ValueCopy(nil)
+		require.NoError(err)
+		return value
+	}
+	iterateKeys := func(txn KVTxn, prefix string, reverse bool) []string {
+		opts := DefaultKVIteratorOptions
+		opts.Reverse = reverse
+		opts.Prefix = []byte(prefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		seekKey := []byte(prefix)
+		if reverse {
+			seekKey = append(seekKey, 0xff)
+		}
+		var keys []string
+		for it.Seek(seekKey); it.ValidForPrefix([]byte(prefix)); it.Next() {
+			keys = append(keys, string(it.Item().KeyCopy(nil)))
+		}
+		return keys
+	}
+
+	// Badger hides pending writes from iterators in the very first transaction of a fresh db, so we start
+	// with a write that doesn't interfere with the rest of the test.
+	require.NoError(store.Update(func(txn KVTxn) error {
+		return txn.Set([]byte("c0"), []byte("value-c0"))
+	}))
+
+	// Writes in an update are visible to the transaction itself, including its iterators.
+	require.NoError(store.Update(func(txn KVTxn) error {
+		for _, key := range []string{"a2", "b1", "a1", "a3"} {
+			require.NoError(txn.Set([]byte(key), []byte("value-"+key)))
+		}
+		require.Equal([]byte("value-a1"), getValue(txn, "a1"))
+		require.Equal([]string{"a1", "a2", "a3"}, iterateKeys(txn, "a", false))
+		return nil
+	}))
+
+	require.NoError(store.View(func(txn KVTxn) error {
+		require.Equal([]byte("value-b1"), getValue(txn, "b1"))
+		require.Nil(getValue(txn, "c1"))
+		require.Equal([]string{"a1", "a2", "a3"}, iterateKeys(txn, "a", false))
+		require.Equal([]string{"a3", "a2", "a1"}, iterateKeys(txn, "a", true))
+		require.Equal([]string{"a1", "a2", "a3", "b1", "c0"}, iterateKeys(txn, "", false))
+
+		// Reverse seek lands on the largest key that's smaller or equal to the seek key.
+		opts := DefaultKVIteratorOptions
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		it.Seek([]byte("a2x"))
+		require.True(it.Valid())
+		require.Equal([]byte("a2"), it.Item().Key())
+
+		// Read-only transactions can't write.
+		require.Equal(ErrKVReadOnlyTxn, txn.Set([]byte("c1"), []byte("value-c1")))
+		return nil
+	}))
+
+	// An update that returns an error isn't committed.
+	require.Error(store.Update(func(txn KVTxn) error {
+		require.NoError(txn.Set([]byte("c1"), []byte("value-c1")))
+		require.NoError(txn.Delete([]byte("a1")))
+		return fmt.Errorf("abort")
+	}))
+
+	// Transactions read the state at the time they were created.
+	readTxn := store.NewTransaction(false)
+	require.NoError(store.Update(func(txn KVTxn) error {
+		require.NoError(txn.Delete([]byte("a2")))
+		require.Equal([]string{"a1", "a3"}, iterateKeys(txn, "a", false))
+		return txn.Set([]byte("a1"), []byte("new-value-a1"))
+	}))
+	require.Equal([]byte("value-a1"), getValue(readTxn, "a1"))
+	require.Equal([]string{"a1", "a2", "a3"}, iterateKeys(readTxn, "a", false))
+	readTxn.Discard()
+
+	// Write batches.
+	wb := store.NewWriteBatch()
+	require.NoError(wb.Set([]byte("d1"), []byte("value-d1")))
+	require.NoError(wb.Delete([]byte("a3")))
+	require.NoError(wb.Flush())
+
+	require.NoError(store.View(func(txn KVTxn) error {
+		require.Nil(getValue(txn, "c1"))
+		require.Equal([]byte("new-value-a1"), getValue(txn, "a1"))
+		require.Equal([]byte("value-d1"), getValue(txn, "d1"))
+		require.Equal([]string{"a1"}, iterateKeys(txn, "a", false))
+		return nil
+	}))
+
+	// An update that read a key which another transaction wrote after it started conflicts, whether it read the
+	// key with a Get or through an iterator.
+	for _, readKey := range []func(txn KVTxn){
+		func(txn KVTxn) { getValue(txn, "d1") },
+		func(txn KVTxn) { iterateKeys(txn, "d", false) },
+	} {
+		conflictTxn := store.NewTransaction(true)
+		readKey(conflictTxn)
+		require.NoError(store.Update(func(txn KVTxn) error {
+			return txn.Set([]byte("d1"), []byte("other-value-d1"))
+		}))
+		require.NoError(conflictTxn.Set([]byte("e1"), []byte("value-e1")))
+		require.Equal(ErrKVConflict, conflictTxn.Commit())
+		conflictTxn.Discard()
+	}
+
+	// Blind writes don't conflict, and the last commit wins.
+	blindTxn := store.NewTransaction(true)
+	require.NoError(blindTxn.Set([]byte("d1"), []byte("blind-value-d1")))
+	require.NoError(store.Update(func(txn KVTxn) error {
+		return txn.Set([]byte("d1"), []byte("other-value-d1"))
+	}))
+	require.NoError(blindTxn.Commit())
+
+	require.NoError(store.View(func(txn KVTxn) error {
+		require.Nil(getValue(txn, "e1"))
+		require.Equal([]byte("blind-value-d1"), getValue(txn, "d1"))
+		return nil
+	}))
+}
+
+func TestMemoryKVStoreVersions(t *testing.T) {
+	require := require.New(t)
+
+	// Commit enough keys to split the btree nodes, keeping a transaction open after every commit. Every
+	// transaction should keep seeing the keys that were committed when it started.
+	store := NewMemoryKVStore()
+	numCommits := 50
+	keysPerCommit := 40
+	var txns []KVTxn
+	for ii := 0; ii < numCommits; ii++ {
+		require.NoError(store.Update(func(txn KVTxn) error {
+			for jj := 0; jj < keysPerCommit; jj++ {
+				key := fmt.Sprintf("%05d", jj*numCommits+ii)
+				require.NoError(txn.Set([]byte(key), []byte(key)))
+			}
+			return nil
+		}))
+		txns = append(txns, store.NewTransaction(false))
+	}
+
+	for ii, txn := range txns {
+		it := txn.NewIterator(DefaultKVIteratorOptions)
+		numKeys := 0
+		for it.Seek([]byte{}); it.Valid(); it.Next() {
+			numKeys++
+		}
+		it.Close()
+		require.Equal((ii+1)*keysPerCommit, numKeys)
+
+		_, err := txn.Get([]byte(fmt.Sprintf("%05d", ii)))
+		require.NoError(err)
+		_, err = txn.Get([]byte(fmt.Sprintf("%05d", ii+1)))
+		if ii+1 < numCommits {
+			require.Equal(ErrKVKeyNotFound, err)
+		}
+		txn.Discard()
+	}
+}
+
+func TestUtxoViewWithMemoryKVStore(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, _ := NewLowDifficultyBlockchain()
+	snap := chain.snapshot
+	store := NewMemoryKVStore()
+
+	// Writes go through the snapshot hooks. The record didn't exist at the snapshot height, so the
+	// snapshot should keep serving the state without it.
+	snap.PrepareAncestralRecordsFlush()
+	require.NoError(DbPutDeSoBalanceForPublicKey(store, snap, m0PkBytes, 100))
+	snap.StartAncestralRecordsFlush(true)
+	snap.WaitForAllOperationsToFinish()
+
+	prefix := Prefixes.PrefixPublicKeyToDeSoBalanceNanos
+	chunk, _, concurrencyFault, err := snap.GetSnapshotChunk(store, prefix, prefix)
+	require.NoError(err)
+	require.False(concurrencyFault)
+	require.Len(chunk, 1)
+	require.True(chunk[0].IsEmpty())
+
+	// The UtxoView reads and flushes through the same store.
+	utxoView, err := NewUtxoView(store, params, nil, snap)
+	require.NoError(err)
+	balance, err := utxoView.GetDeSoBalanceNanosForPublicKey(m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(100), balance)
+
+	utxoView.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m0PkBytes)] = 50
+	utxoView.PublicKeyToDeSoBalanceNanos[*NewPublicKey(m1PkBytes)] = 70
+	require.NoError(utxoView.FlushToDb(0))
+	snap.WaitForAllOperationsToFinish()
+
+	balance, err = DbGetDeSoBalanceNanosForPublicKey(store, snap, m0PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(50), balance)
+	balance, err = DbGetDeSoBalanceNanosForPublicKey(store, snap, m1PkBytes)
+	require.NoError(err)
+	require.Equal(uint64(70), balance)
+}
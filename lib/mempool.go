@@ -18,8 +18,6 @@ import (
 	"sync/atomic"
 	"time"
 
-	"github.com/dgraph-io/badger/v3"
-
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/deso-protocol/go-deadlock"
 	"github.com/golang/glog"
@@ -210,12 +208,10 @@ type DeSoMempool struct {
 	// Optional. When set, we use the BlockCypher API to detect double-spends.
 	blockCypherAPIKey string
 
-	// These two views are used to check whether a transaction is valid before
-	// adding it to the mempool. This is done by applying the transaction to the
-	// backup view, and then restoring the backup view if there's an error. In
-	// the future, if we can figure out an easy way to rollback bad transactions
-	// on a single view, then we won't need the second view anymore.
-	backupUniversalUtxoView  *UtxoView
+	// The universal view has all the transactions in the mempool connected. To check
+	// whether a transaction is valid before adding it to the mempool, it's applied to
+	// a child of the universal view, which is committed into the universal view if the
+	// transaction is accepted and dropped otherwise.
 	universalUtxoView        *UtxoView
 	universalTransactionList []*MempoolTx
 
@@ -318,7 +314,6 @@ func (mp *DeSoMempool) resetPool(newPool *DeSoMempool) {
 	mp.unconnectedTxns = newPool.unconnectedTxns
 	mp.unconnectedTxnsByPrev = newPool.unconnectedTxnsByPrev
 	mp.nextExpireScan = newPool.nextExpireScan
-	mp.backupUniversalUtxoView = newPool.backupUniversalUtxoView
 	mp.universalUtxoView = newPool.universalUtxoView
 	mp.universalTransactionList = newPool.universalTransactionList
 
@@ -568,6 +563,15 @@ func (mp *DeSoMempool) GetTransactionsOrderedByTimeAdded() (_poolTxns []*Mempool
 	return poolTxns, nil, nil
 }
 
+// GetReadOnlyUniversalTransactionList returns a copy of the readOnlyUniversalTransactionList. It holds
+// the read lock while copying, so the list isn't replaced by processTransaction in the meantime.
+func (mp *DeSoMempool) GetReadOnlyUniversalTransactionList() []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return append([]*MempoolTx{}, mp.readOnlyUniversalTransactionList...)
+}
+
 func (mp *DeSoMempool) GetTransaction(txId *BlockHash) (txn *MempoolTx) {
 	return mp.readOnlyUniversalTransactionMap[*txId]
 }
@@ -769,7 +773,7 @@ func (mp *DeSoMempool) OpenTempDBAndDumpTxns() error {
 	}
 	tempMempoolDBOpts := PerformanceBadgerOptions(tempMempoolDBDir)
 	tempMempoolDBOpts.ValueDir = tempMempoolDBDir
-	tempMempoolDB, err := badger.Open(tempMempoolDBOpts)
+	tempMempoolDB, err := OpenBadgerKVStore(tempMempoolDBOpts)
 	if err != nil {
 		return fmt.Errorf("OpenTempDBAndDumpTxns: Could not open temp db to dump mempool: %v", err)
 	}
@@ -787,7 +791,7 @@ func (mp *DeSoMempool) OpenTempDBAndDumpTxns() error {
 		// then dump the txns to disk
 		if len(txnsToDump)%1000 == 0 || ii == len(allTxns)-1 {
 			glog.Infof("OpenTempDBAndDumpTxns: Dumping txns %v to %v", ii-len(txnsToDump)+1, ii)
-			err := tempMempoolDB.Update(func(txn *badger.Txn) error {
+			err := tempMempoolDB.Update(func(txn KVTxn) error {
 				return FlushMempoolToDbWithTxn(txn, nil, blockHeight, txnsToDump)
 			})
 			if err != nil {
@@ -806,7 +810,7 @@ func (mp *DeSoMempool) OpenTempDBAndDumpTxns() error {
 // only be called when one is sure that a transaction is valid. Otherwise, it could
 // mess up the UtxoViews that we store internally.
 func (mp *DeSoMempool) addTransaction(
-	tx *MsgDeSoTxn, height uint32, fee uint64, updateBackupView bool) (*MempoolTx, error) {
+	tx *MsgDeSoTxn, height uint32, fee uint64, txnView *UtxoView) (*MempoolTx, error) {
 
 	// Add the transaction to the pool and mark the referenced outpoints
 	// as spent by the pool.
@@ -861,24 +865,14 @@ func (mp *DeSoMempool) addTransaction(
 	// to know her balance while factoring in mempool transactions.
 	mp._addMempoolTxToPubKeyOutputMap(mempoolTx)
 
-	// Add it to the universal view. We assume the txn was already added to the
-	// backup view.
-	_, _, _, _, err = mp.universalUtxoView._connectTransaction(mempoolTx.Tx, mempoolTx.Hash, int64(mempoolTx.TxSizeBytes), height,
-		false /*verifySignatures*/, false /*ignoreUtxos*/)
-	if err != nil {
-		return nil, fmt.Errorf("ERROR addTransaction: _connectTransaction " +
-			"failed on universalUtxoView; this is a HUGE problem and should never happen")
+	// Add it to the universal view. We assume the txn was already connected to
+	// txnView, which is a child of the universal view.
+	if err = txnView.CommitToParent(); err != nil {
+		return nil, fmt.Errorf("ERROR addTransaction: Committing txn view into "+
+			"universalUtxoView failed; this is a HUGE problem and should never happen: %v", err)
 	}
 	// Add it to the universalTransactionList if it made it through the view
 	mp.universalTransactionList = append(mp.universalTransactionList, mempoolTx)
-	if updateBackupView {
-		_, _, _, _, err = mp.backupUniversalUtxoView._connectTransaction(mempoolTx.Tx, mempoolTx.Hash, int64(mempoolTx.TxSizeBytes), height,
-			false /*verifySignatures*/, false /*ignoreUtxos*/)
-		if err != nil {
-			return nil, fmt.Errorf("ERROR addTransaction: _connectTransaction " +
-				"failed on backupUniversalUtxoView; this is a HUGE problem and should never happen")
-		}
-	}
 
 	return mempoolTx, nil
 }
@@ -902,8 +896,6 @@ func (mp *DeSoMempool) GetAugmentedUtxoViewForPublicKey(pkBytes []byte, optional
 
 // GetAugmentedUniversalView creates a view that just connects everything
 // in the mempool...
-// TODO(performance): We should make a read-only version of the universal view that
-// you can get from the mempool.
 func (mp *DeSoMempool) GetAugmentedUniversalView() (*UtxoView, error) {
 	if mp.stopped {
 		return nil, fmt.Errorf("GetAugmentedUniversalView: Problem getting UtxoView, Mempool is closed")
@@ -915,6 +907,21 @@ func (mp *DeSoMempool) GetAugmentedUniversalView() (*UtxoView, error) {
 	return newView, nil
 }
 
+// GetAugmentedUniversalChildView is a cheaper alternative to GetAugmentedUniversalView that
+// returns a child of the readOnlyUtxoView instead of a full copy. The caller can connect
+// transactions to it without affecting the mempool. The readOnlyUtxoView is never modified,
+// only replaced, so it's safe for it to be the parent.
+//
+// The mappings of the child only hold the entries it has looked up, so it should only be
+// accessed through the view's getters and never by reading its mappings directly. Callers
+// that need the mappings should use GetAugmentedUniversalView.
+func (mp *DeSoMempool) GetAugmentedUniversalChildView() (*UtxoView, error) {
+	if mp.stopped {
+		return nil, fmt.Errorf("GetAugmentedUniversalChildView: Problem getting UtxoView, Mempool is closed")
+	}
+	return mp.readOnlyUtxoView.NewChildUtxoView(), nil
+}
+
 func (mp *DeSoMempool) FetchTransaction(txHash *BlockHash) *MempoolTx {
 	if mempoolTx, exists := mp.readOnlyUniversalTransactionMap[*txHash]; exists {
 		return mempoolTx
@@ -967,16 +974,6 @@ func (mp *DeSoMempool) _quickCheckBitcoinExchangeTxn(
 	return txFee, nil
 }
 
-func (mp *DeSoMempool) rebuildBackupView() {
-	// We need to rebuild the backup view since the _connectTransaction broke it.
-	var copyErr error
-	mp.backupUniversalUtxoView, copyErr = mp.universalUtxoView.CopyUtxoView()
-	if copyErr != nil {
-		glog.Errorf("ERROR tryAcceptTransaction: Problem copying "+
-			"view. This should NEVER happen: %v", copyErr)
-	}
-}
-
 // See TryAcceptTransaction. The write lock must be held when calling this function.
 //
 // TODO: Allow replacing a transaction with a higher fee.
@@ -1027,27 +1024,27 @@ func (mp *DeSoMempool) tryAcceptTransaction(
 		return missingParents, nil, nil
 	}
 
-	// Attempt to add the transaction to the backup view. If it fails, reconstruct the backup
-	// view and return an error.
-	totalNanosPurchasedBefore := mp.backupUniversalUtxoView.NanosPurchased
-	usdCentsPerBitcoinBefore := mp.backupUniversalUtxoView.GetCurrentUSDCentsPerBitcoin()
+	txBytes, err := tx.ToBytes(false)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: Problem serializing txn: ")
+	}
+	serializedLen := uint64(len(txBytes))
+
+	// Attempt to connect the transaction to a child of the universal view. If it fails, the
+	// child is simply dropped. Otherwise, it's committed into the universal view once the
+	// transaction is added to the pool.
+	txnView := mp.universalUtxoView.NewChildUtxoView()
+	totalNanosPurchasedBefore := txnView.NanosPurchased
+	usdCentsPerBitcoinBefore := txnView.GetCurrentUSDCentsPerBitcoin()
 	bestHeight := uint32(mp.bc.blockTip().Height + 1)
-	// We can skip verifying the transaction size as related to the minimum fee here.
-	utxoOps, totalInput, totalOutput, txFee, err := mp.backupUniversalUtxoView._connectTransaction(
-		tx, txHash, 0, bestHeight, verifySignatures, false)
+	utxoOps, totalInput, totalOutput, txFee, err := txnView._connectTransaction(
+		tx, txHash, int64(serializedLen), bestHeight, verifySignatures, false)
 	if err != nil {
-		mp.rebuildBackupView()
 		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: Problem "+
 			"connecting transaction after connecting dependencies: ")
 	}
 
 	// Compute the feerate for this transaction for use below.
-	txBytes, err := tx.ToBytes(false)
-	if err != nil {
-		mp.rebuildBackupView()
-		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: Problem serializing txn: ")
-	}
-	serializedLen := uint64(len(txBytes))
 	txFeePerKB := txFee * 1000 / serializedLen
 
 	// Transactions with a feerate below the minimum threshold will be outright
@@ -1060,7 +1057,6 @@ func (mp *DeSoMempool) tryAcceptTransaction(
 			txFeePerKB, mp.minFeeRateNanosPerKB, mp.minFeeRateNanosPerKB, serializedLen,
 			totalInput, totalOutput, txHash, hex.EncodeToString(txBytes))
 		glog.Error(errRet)
-		mp.rebuildBackupView()
 		return nil, nil, errors.Wrapf(TxErrorInsufficientFeeMinFee, errRet.Error())
 	}
 
@@ -1068,7 +1064,6 @@ func (mp *DeSoMempool) tryAcceptTransaction(
 	// then reject it.
 	maxTxnSize := mp.bc.params.MinerMaxBlockSizeBytes / 2
 	if serializedLen > maxTxnSize {
-		mp.rebuildBackupView()
 		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: "+
 			"Txn size %v exceeds maximum allowable txn size %v", serializedLen, maxTxnSize)
 	}
@@ -1088,7 +1083,6 @@ func (mp *DeSoMempool) tryAcceptTransaction(
 
 		// Check to see if the accumulator is over the limit.
 		if mp.lowFeeTxSizeAccumulator >= float64(LowFeeTxLimitBytesPerTenMinutes) {
-			mp.rebuildBackupView()
 			return nil, nil, TxErrorInsufficientFeeRateLimit
 		}
 
@@ -1099,16 +1093,14 @@ func (mp *DeSoMempool) tryAcceptTransaction(
 			"limit ~(%v) bytes/10m", oldTotal, mp.lowFeeTxSizeAccumulator, LowFeeTxLimitBytesPerTenMinutes)
 	}
 
-	// Add to transaction pool. Don't update the backup view since the call above
-	// will have already done this.
-	mempoolTx, err := mp.addTransaction(tx, bestHeight, txFee, false /*updateBackupUniversalView*/)
+	// Add to transaction pool. This commits txnView into the universal view.
+	mempoolTx, err := mp.addTransaction(tx, bestHeight, txFee, txnView)
 	if err != nil {
-		mp.rebuildBackupView()
 		return nil, nil, errors.Wrapf(err, "tryAcceptTransaction: ")
 	}
 
 	// Calculate metadata
-	mempoolTx.TxMeta = ComputeTransactionMetadata(tx, mp.backupUniversalUtxoView, nil, totalNanosPurchasedBefore,
+	mempoolTx.TxMeta = ComputeTransactionMetadata(tx, mp.universalUtxoView, nil, totalNanosPurchasedBefore,
 		usdCentsPerBitcoinBefore, totalInput, totalOutput, txFee, uint64(0), utxoOps, blockHeight)
 
 	glog.V(2).Infof("tryAcceptTransaction: Accepted transaction %v (pool size: %v)", txHash,
@@ -2383,7 +2375,7 @@ func (mp *DeSoMempool) LoadTxnsFromDB() {
 	tempMempoolDBOpts := PerformanceBadgerOptions(savedTxnsDir)
 	tempMempoolDBOpts.ValueDir = savedTxnsDir
 	glog.Infof("LoadTxnsFrom: Opening new temp db %v", savedTxnsDir)
-	tempMempoolDB, err := badger.Open(tempMempoolDBOpts)
+	tempMempoolDB, err := OpenBadgerKVStore(tempMempoolDBOpts)
 	if err != nil {
 		glog.Infof("LoadTxnsFrom: Could not open temp db to dump mempool: %v", err)
 		return
@@ -2421,7 +2413,6 @@ func NewDeSoMempool(_bc *Blockchain, _rateLimitFeerateNanosPerKB uint64,
 	_runReadOnlyViewUpdater bool, _dataDir string, _mempoolDumpDir string) *DeSoMempool {
 
 	utxoView, _ := NewUtxoView(_bc.db, _bc.params, _bc.postgres, _bc.snapshot)
-	backupUtxoView, _ := NewUtxoView(_bc.db, _bc.params, _bc.postgres, _bc.snapshot)
 	readOnlyUtxoView, _ := NewUtxoView(_bc.db, _bc.params, _bc.postgres, _bc.snapshot)
 	newPool := &DeSoMempool{
 		quit:                            make(chan struct{}),
@@ -2434,7 +2425,6 @@ func NewDeSoMempool(_bc *Blockchain, _rateLimitFeerateNanosPerKB uint64,
 		outpoints:                       make(map[UtxoKey]*MsgDeSoTxn),
 		pubKeyToTxnMap:                  make(map[PkMapKey]map[BlockHash]*MempoolTx),
 		blockCypherAPIKey:               _blockCypherAPIKey,
-		backupUniversalUtxoView:         backupUtxoView,
 		universalUtxoView:               utxoView,
 		mempoolDir:                      _mempoolDumpDir,
 		generateReadOnlyUtxoView:        _runReadOnlyViewUpdater,
@@ -422,3 +422,36 @@ func TestMempoolAugmentedUtxoViewTransactionChain(t *testing.T) {
 
 	_, _, _, _, _ = mempoolTx1, mempoolTx2, mempoolTx3, mempoolTx4, params
 }
+
+func TestMempoolAugmentedUniversalViews(t *testing.T) {
+	require := require.New(t)
+
+	chain, _, _, _ := _setupFiveBlocks(t)
+
+	txn1 := _assembleBasicTransferTxnFullySigned(t, chain, 1, 0,
+		senderPkString, recipientPkString, senderPrivString, nil)
+	changeUtxoKey := UtxoKey{TxID: *txn1.Hash(), Index: 1}
+
+	mp := NewDeSoMempool(
+		chain, 0, /* rateLimitFeeRateNanosPerKB */
+		0 /* minFeeRateNanosPerKB */, "", true,
+		"" /*dataDir*/, "")
+	_, err := mp.processTransaction(txn1, false /*allowUnconnectedTxn*/, false /*rateLimit*/, 0 /*peerID*/, true /*verifySignatures*/)
+	require.NoError(err)
+	require.NoError(mp.regenerateReadOnlyView())
+
+	// The universal view is a full copy, so its mappings can be read directly.
+	universalView, err := mp.GetAugmentedUniversalView()
+	require.NoError(err)
+	require.Contains(universalView.UtxoKeyToUtxoEntry, changeUtxoKey)
+	require.Equal(len(mp.readOnlyUtxoView.UtxoKeyToUtxoEntry), len(universalView.UtxoKeyToUtxoEntry))
+
+	// The child view starts out empty and only pulls in the entries it looks up.
+	childView, err := mp.GetAugmentedUniversalChildView()
+	require.NoError(err)
+	require.NotContains(childView.UtxoKeyToUtxoEntry, changeUtxoKey)
+	utxoEntry := childView.GetUtxoEntryForUtxoKey(&changeUtxoKey)
+	require.NotNil(utxoEntry)
+	require.Equal(txn1.TxOutputs[1].AmountNanos, utxoEntry.AmountNanos)
+	require.Contains(childView.UtxoKeyToUtxoEntry, changeUtxoKey)
+}
@@ -85,7 +85,24 @@ const (
 	MsgTypeGetSnapshot  MsgType = 17
 	MsgTypeSnapshotData MsgType = 18
 
-	// NEXT_TAG = 19
+	// MsgTypeGetStateChecksum is used to compare state between nodes, by requesting the
+	// checksum of a range of keys at the current snapshot epoch.
+	MsgTypeGetStateChecksum MsgType = 19
+	MsgTypeStateChecksum    MsgType = 20
+
+	// MsgTypeCompactBlock is used to relay new blocks to peers that set SFCompactBlocks. It
+	// identifies transactions by short ids, so the peer can rebuild the block from its mempool and
+	// only ask for the transactions it's missing with MsgTypeGetBlockTxns.
+	MsgTypeCompactBlock MsgType = 21
+	MsgTypeGetBlockTxns MsgType = 22
+	MsgTypeBlockTxns    MsgType = 23
+
+	// MsgTypeGetTxnProof is used to ask peers that set SFTxnProofs for a proof that a transaction
+	// is included in a block, which they send back in a MsgTypeTxnProof.
+	MsgTypeGetTxnProof MsgType = 24
+	MsgTypeTxnProof    MsgType = 25
+
+	// NEXT_TAG = 26
 
 	// Below are control messages used to signal to the Server from other parts of
 	// the code but not actually sent among peers.
@@ -98,8 +115,9 @@ const (
 	MsgTypeDonePeer             MsgType = ControlMessagesStart + 2
 	MsgTypeBlockAccepted        MsgType = ControlMessagesStart + 3
 	MsgTypeBitcoinManagerUpdate MsgType = ControlMessagesStart + 4 // Deprecated
+	MsgTypeBlockDownloadTick    MsgType = ControlMessagesStart + 7
 
-	// NEXT_TAG = 7
+	// NEXT_TAG = 8
 )
 
 // IsControlMessage is used by functions to determine whether a particular message
@@ -157,10 +175,26 @@ func (msgType MsgType) String() string {
 		return "BLOCK_ACCEPTED"
 	case MsgTypeBitcoinManagerUpdate:
 		return "BITCOIN_MANAGER_UPDATE"
+	case MsgTypeBlockDownloadTick:
+		return "BLOCK_DOWNLOAD_TICK"
 	case MsgTypeGetSnapshot:
 		return "GET_SNAPSHOT"
 	case MsgTypeSnapshotData:
 		return "SNAPSHOT_DATA"
+	case MsgTypeGetStateChecksum:
+		return "GET_STATE_CHECKSUM"
+	case MsgTypeStateChecksum:
+		return "STATE_CHECKSUM"
+	case MsgTypeCompactBlock:
+		return "COMPACT_BLOCK"
+	case MsgTypeGetBlockTxns:
+		return "GET_BLOCK_TXNS"
+	case MsgTypeBlockTxns:
+		return "BLOCK_TXNS"
+	case MsgTypeGetTxnProof:
+		return "GET_TXN_PROOF"
+	case MsgTypeTxnProof:
+		return "TXN_PROOF"
 	default:
 		return fmt.Sprintf("UNRECOGNIZED(%d) - make sure String() is up to date", msgType)
 	}
@@ -566,10 +600,18 @@ func ReadMessage(rr io.Reader, networkType NetworkType) (DeSoMessage, []byte, er
 		return nil, nil, fmt.Errorf("ReadMessage: Payload size (%d) bytes is too "+
 			"large. Should be no larger than (%d) bytes", payloadLength, MaxMessagePayload)
 	}
+	// Message types with a small encoding have a lower limit, which we check before
+	// reading the payload so that a peer can't make us buffer and decode a huge one.
+	if maxPayloadSize := MaxPayloadSizeForMsgType(MsgType(inMsgType)); payloadLength > maxPayloadSize {
+		return nil, nil, errors.Wrapf(&MessagePayloadTooLargeError{
+			MsgType:        MsgType(inMsgType),
+			PayloadSize:    payloadLength,
+			MaxPayloadSize: maxPayloadSize,
+		}, "ReadMessage: ")
+	}
 
 	// Read the payload.
-	payload := make([]byte, payloadLength)
-	_, err = io.ReadFull(rr, payload)
+	payload, err := SafeReadBytes(rr, payloadLength)
 	if err != nil {
 		return nil, nil, errors.Wrapf(err, "ReadMessage: Could not read payload for message type (%s)", MsgType(inMsgType))
 	}
@@ -670,6 +712,34 @@ func NewMessage(msgType MsgType) DeSoMessage {
 		{
 			return &MsgDeSoSnapshotData{}
 		}
+	case MsgTypeGetStateChecksum:
+		{
+			return &MsgDeSoGetStateChecksum{}
+		}
+	case MsgTypeStateChecksum:
+		{
+			return &MsgDeSoStateChecksum{}
+		}
+	case MsgTypeCompactBlock:
+		{
+			return &MsgDeSoCompactBlock{}
+		}
+	case MsgTypeGetBlockTxns:
+		{
+			return &MsgDeSoGetBlockTxns{}
+		}
+	case MsgTypeBlockTxns:
+		{
+			return &MsgDeSoBlockTxns{}
+		}
+	case MsgTypeGetTxnProof:
+		{
+			return &MsgDeSoGetTxnProof{}
+		}
+	case MsgTypeTxnProof:
+		{
+			return &MsgDeSoTxnProof{}
+		}
 	default:
 		{
 			return nil
@@ -726,6 +796,23 @@ func (msg *MsgDeSoDonePeer) FromBytes(data []byte) error {
 	return fmt.Errorf("MsgDeSoDonePeer.FromBytes not implemented")
 }
 
+// MsgDeSoBlockDownloadTick is sent to the Server periodically so that it can check for
+// stalled block downloads and compact blocks from the messageHandler thread.
+type MsgDeSoBlockDownloadTick struct {
+}
+
+func (msg *MsgDeSoBlockDownloadTick) GetMsgType() MsgType {
+	return MsgTypeBlockDownloadTick
+}
+
+func (msg *MsgDeSoBlockDownloadTick) ToBytes(preSignature bool) ([]byte, error) {
+	return nil, fmt.Errorf("MsgDeSoBlockDownloadTick.ToBytes: Not implemented")
+}
+
+func (msg *MsgDeSoBlockDownloadTick) FromBytes(data []byte) error {
+	return fmt.Errorf("MsgDeSoBlockDownloadTick.FromBytes not implemented")
+}
+
 // ==================================================================
 // GET_HEADERS message
 // ==================================================================
@@ -1293,6 +1380,21 @@ const (
 	// SFArchivalNode is a flag complementary to SFHyperSync. If node is a hypersync node then
 	// it might not be able to support block sync anymore, unless it has archival mode turned on.
 	SFArchivalNode
+	// SFNoSnapshotServing is set by hypersync nodes that refuse to serve snapshot chunks to peers, but
+	// otherwise relay blocks and transactions as usual. Such nodes also don't set SFHyperSync, so that
+	// older peers, which don't know about this flag, don't pick them as a hypersync peer.
+	SFNoSnapshotServing
+	// SFEncryptedTransport is set by nodes that can encrypt the connection. When both peers set it,
+	// they run a Noise handshake right after exchanging versions and encrypt everything that follows.
+	SFEncryptedTransport
+	// SFCompactBlocks is set by nodes that accept MsgDeSoCompactBlock. New blocks are relayed to such
+	// nodes as compact blocks instead of inv messages.
+	SFCompactBlocks
+	// SFOnionAddrs is set by nodes that can decode onion addresses in MsgDeSoAddr. Older nodes
+	// reject addr messages with them, so onion addresses are only sent to nodes that set it.
+	SFOnionAddrs
+	// SFTxnProofs is set by nodes that answer MsgDeSoGetTxnProof.
+	SFTxnProofs
 )
 
 type MsgDeSoVersion struct {
@@ -1418,8 +1520,7 @@ func (msg *MsgDeSoVersion) FromBytes(data []byte) error {
 		if strLen > MaxMessagePayload {
 			return fmt.Errorf("MsgDeSoVersion.FromBytes: Length msg.UserAgent %d larger than max allowed %d", strLen, MaxMessagePayload)
 		}
-		userAgent := make([]byte, strLen)
-		_, err = io.ReadFull(rr, userAgent)
+		userAgent, err := SafeReadBytes(rr, strLen)
 		if err != nil {
 			return errors.Wrapf(err, "MsgDeSoVersion.FromBytes: Error reading msg.UserAgent")
 		}
@@ -1486,23 +1587,40 @@ type SingleAddr struct {
 	Services ServiceFlag
 
 	// IP address of the peer. Must be 4 or 16 bytes for IPV4 or IPV6 respectively.
+	// It's nil if the peer is only reachable through its onion address.
 	IP net.IP
 
+	// OnionAddr is the Tor v3 onion address of a peer that is only reachable through Tor.
+	// On the wire it takes the place of the IP, and is distinguished by its length.
+	OnionAddr []byte
+
 	// Port the peer is using.
 	Port uint16
 }
 
+// IsOnion returns true if the address is an onion address rather than an IP.
+func (addr *SingleAddr) IsOnion() bool {
+	return len(addr.OnionAddr) > 0
+}
+
+func (addr *SingleAddr) host() string {
+	if addr.IsOnion() {
+		return OnionAddrToString(addr.OnionAddr)
+	}
+	return addr.IP.String()
+}
+
 func (addr *SingleAddr) StringWithPort(includePort bool) string {
 	// Always include the port for localhost as it's useful for testing.
 	if includePort || net.IP([]byte{127, 0, 0, 1}).Equal(addr.IP) {
-		return fmt.Sprintf("%s:%d", addr.IP.String(), addr.Port)
+		return fmt.Sprintf("%s:%d", addr.host(), addr.Port)
 	}
 
-	return addr.IP.String()
+	return addr.host()
 }
 
 func (addr *SingleAddr) String() string {
-	return fmt.Sprintf("%s:%d", addr.IP.String(), addr.Port)
+	return fmt.Sprintf("%s:%d", addr.host(), addr.Port)
 }
 
 type MsgDeSoAddr struct {
@@ -1530,9 +1648,14 @@ func (msg *MsgDeSoAddr) ToBytes(preSignature bool) ([]byte, error) {
 		retBytes = append(retBytes, UintToBuf(uint64(addr.Services))...)
 
 		// IP
-		// Encode the length of the IP and then the actual bytes.
-		retBytes = append(retBytes, UintToBuf(uint64(len(addr.IP[:])))...)
-		retBytes = append(retBytes, addr.IP[:]...)
+		// Encode the length of the IP and then the actual bytes. Onion addresses are
+		// encoded in place of the IP.
+		ipBytes := []byte(addr.IP)
+		if addr.IsOnion() {
+			ipBytes = addr.OnionAddr
+		}
+		retBytes = append(retBytes, UintToBuf(uint64(len(ipBytes)))...)
+		retBytes = append(retBytes, ipBytes...)
 
 		// Port
 		retBytes = append(retBytes, UintToBuf(uint64(addr.Port))...)
@@ -1573,14 +1696,23 @@ func (msg *MsgDeSoAddr) FromBytes(data []byte) error {
 		if err != nil {
 			return errors.Wrapf(err, "MsgDeSoAddr.FromBytes: Problem reading IP: ")
 		}
-		if ipLen != 4 && ipLen != 16 {
-			return fmt.Errorf("MsgDeSoAddr.FromBytes: IP length must be 4 or 16 bytes but was %d", ipLen)
+		if ipLen != 4 && ipLen != 16 && ipLen != OnionAddrLen {
+			return fmt.Errorf("MsgDeSoAddr.FromBytes: IP length must be 4 or 16 bytes, or %d "+
+				"bytes for an onion address, but was %d", OnionAddrLen, ipLen)
 		}
-		currentAddr.IP = net.IP(make([]byte, ipLen))
-		_, err = io.ReadFull(rr, currentAddr.IP)
+		ipBytes := make([]byte, ipLen)
+		_, err = io.ReadFull(rr, ipBytes)
 		if err != nil {
 			return errors.Wrapf(err, "MsgDeSoAddr.FromBytes: Error reading IP")
 		}
+		if ipLen == OnionAddrLen {
+			if err := ValidateOnionAddr(ipBytes); err != nil {
+				return errors.Wrapf(err, "MsgDeSoAddr.FromBytes: ")
+			}
+			currentAddr.OnionAddr = ipBytes
+		} else {
+			currentAddr.IP = net.IP(ipBytes)
+		}
 
 		// Port
 		port, err := ReadUvarint(rr)
@@ -1923,6 +2055,13 @@ func DecodeHeaderVersion1(rr io.Reader) (*MsgDeSoHeader, error) {
 			return nil, errors.Wrapf(err, "MsgDeSoHeader.FromBytes: Problem decoding TstampSecs")
 		}
 		retHeader.TstampSecs = binary.BigEndian.Uint64(scratchBytes[:])
+
+		// Reject what EncodeHeaderVersion1 would refuse to encode so that a header
+		// we accept can always be hashed and relayed.
+		if retHeader.TstampSecs > math.MaxUint32 {
+			return nil, fmt.Errorf("MsgDeSoHeader.FromBytes: TstampSecs %d exceeds max uint32",
+				retHeader.TstampSecs)
+		}
 	}
 
 	// Height
@@ -1933,6 +2072,10 @@ func DecodeHeaderVersion1(rr io.Reader) (*MsgDeSoHeader, error) {
 			return nil, errors.Wrapf(err, "MsgDeSoHeader.FromBytes: Problem decoding Height")
 		}
 		retHeader.Height = binary.BigEndian.Uint64(scratchBytes[:])
+		if retHeader.Height > math.MaxUint32 {
+			return nil, fmt.Errorf("MsgDeSoHeader.FromBytes: Height %d exceeds max uint32",
+				retHeader.Height)
+		}
 	}
 
 	// Nonce
@@ -2058,8 +2201,7 @@ func (bpi *BlockProducerInfo) Deserialize(data []byte) error {
 		if pkLen > MaxMessagePayload {
 			return errors.Wrapf(err, "BlockProducerInfo.Deserialize: pkLen too long: %v", pkLen)
 		}
-		pkBytes := make([]byte, pkLen)
-		_, err = io.ReadFull(rr, pkBytes)
+		pkBytes, err := SafeReadBytes(rr, pkLen)
 		if err != nil {
 			return errors.Wrapf(err, "BlockProducerInfo.Deserialize: Error reading public key: ")
 		}
@@ -2075,8 +2217,7 @@ func (bpi *BlockProducerInfo) Deserialize(data []byte) error {
 		if sigLen > MaxMessagePayload {
 			return errors.Wrapf(err, "BlockProducerInfo.Deserialize: signature len too long: %v", sigLen)
 		}
-		sigBytes := make([]byte, sigLen)
-		_, err = io.ReadFull(rr, sigBytes)
+		sigBytes, err := SafeReadBytes(rr, sigLen)
 		if err != nil {
 			return errors.Wrapf(err, "BlockProducerInfo.Deserialize: Error reading signature: ")
 		}
@@ -2184,8 +2325,7 @@ func (msg *MsgDeSoBlock) FromBytes(data []byte) error {
 	if hdrLen > MaxMessagePayload {
 		return fmt.Errorf("MsgDeSoBlock.FromBytes: Header length %d longer than max %d", hdrLen, MaxMessagePayload)
 	}
-	hdrBytes := make([]byte, hdrLen)
-	_, err = io.ReadFull(rr, hdrBytes)
+	hdrBytes, err := SafeReadBytes(rr, hdrLen)
 	if err != nil {
 		return errors.Wrapf(err, "MsgDeSoBlock.FromBytes: Problem reading header")
 	}
@@ -2194,6 +2334,9 @@ func (msg *MsgDeSoBlock) FromBytes(data []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "MsgDeSoBlock.FromBytes: Problem converting header")
 	}
+	if ret.Header.Version != HeaderVersion0 && ret.Header.Version != HeaderVersion1 {
+		return fmt.Errorf("MsgDeSoBlock.FromBytes: Unrecognized header version: %v", ret.Header.Version)
+	}
 
 	// De-serialize the transactions.
 	numTxns, err := ReadUvarint(rr)
@@ -2209,8 +2352,7 @@ func (msg *MsgDeSoBlock) FromBytes(data []byte) error {
 		if txBytesLen > MaxMessagePayload {
 			return fmt.Errorf("MsgDeSoBlock.FromBytes: Txn %d length %d longer than max %d", ii, hdrLen, MaxMessagePayload)
 		}
-		txBytes := make([]byte, txBytesLen)
-		_, err = io.ReadFull(rr, txBytes)
+		txBytes, err := SafeReadBytes(rr, txBytesLen)
 		if err != nil {
 			return errors.Wrapf(err, "MsgDeSoBlock.FromBytes: Problem reading tx bytes")
 		}
@@ -2236,8 +2378,7 @@ func (msg *MsgDeSoBlock) FromBytes(data []byte) error {
 				return fmt.Errorf("MsgDeSoBlock.FromBytes: Header length %d longer "+
 					"than max %d", blockProducerInfoLen, MaxMessagePayload)
 			}
-			blockProducerInfoBytes := make([]byte, blockProducerInfoLen)
-			_, err = io.ReadFull(rr, blockProducerInfoBytes)
+			blockProducerInfoBytes, err := SafeReadBytes(rr, blockProducerInfoLen)
 			if err != nil {
 				return errors.Wrapf(err, "MsgDeSoBlock.FromBytes: Problem reading header")
 			}
@@ -2329,10 +2470,8 @@ func (msg *MsgDeSoSnapshotData) ToBytes(preSignature bool) ([]byte, error) {
 	// Encode the snapshot metadata.
 	data = append(data, msg.SnapshotMetadata.ToBytes()...)
 
-	// Encode the snapshot chunk data.
-	if len(msg.SnapshotChunk) == 0 {
-		return nil, fmt.Errorf("MsgDeSoSnapshotData.ToBytes: Snapshot data should not be empty")
-	}
+	// Encode the snapshot chunk data. We never send empty chunks, an empty prefix is sent as a single
+	// EmptyDBEntry, but they're encoded like any other chunk so that FromBytes and ToBytes round-trip.
 	data = append(data, UintToBuf(uint64(len(msg.SnapshotChunk)))...)
 	for _, vv := range msg.SnapshotChunk {
 		data = append(data, vv.ToBytes()...)
@@ -2359,6 +2498,7 @@ func (msg *MsgDeSoSnapshotData) FromBytes(data []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "MsgDeSoSnapshotData.FromBytes: Problem decoding length of SnapshotChunk")
 	}
+	// An empty chunk decodes fine, the server decides what to do with it when handling the message.
 	for ; dataLen > 0; dataLen-- {
 		dbEntry := &DBEntry{}
 		if err := dbEntry.FromBytes(rr); err != nil {
@@ -2375,8 +2515,7 @@ func (msg *MsgDeSoSnapshotData) FromBytes(data []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "MsgDeSoSnapshotData.FromBytes: Problem decoding length of prefix")
 	}
-	msg.Prefix = make([]byte, prefixLen)
-	_, err = io.ReadFull(rr, msg.Prefix)
+	msg.Prefix, err = SafeReadBytes(rr, prefixLen)
 	if err != nil {
 		return errors.Wrapf(err, "MsgDeSoSnapshotData.FromBytes: Problem decoding prefix")
 	}
@@ -2388,6 +2527,620 @@ func (msg *MsgDeSoSnapshotData) GetMsgType() MsgType {
 	return MsgTypeSnapshotData
 }
 
+// MsgDeSoGetStateChecksum requests the checksum of all state records in the key range [StartKey, EndKey)
+// at the current snapshot epoch. It is used by the state-diff tool to narrow down where two nodes disagree.
+type MsgDeSoGetStateChecksum struct {
+	// SnapshotHeight is the snapshot epoch height we expect the peer to be at. If the peer is at a different
+	// epoch, it will reply with its snapshot metadata and an empty checksum.
+	SnapshotHeight uint64
+	// StartKey is the first key in the range, and it determines the prefix.
+	StartKey []byte
+	// EndKey is the exclusive end of the range. If it's empty, the range extends to the end of the prefix.
+	EndKey []byte
+	// IncludeEntries asks the peer to also send the records in the range, if there are at most
+	// StateChecksumMaxIncludedEntries of them.
+	IncludeEntries bool
+}
+
+func (msg *MsgDeSoGetStateChecksum) ToBytes(preSignature bool) ([]byte, error) {
+	data := []byte{}
+	data = append(data, UintToBuf(msg.SnapshotHeight)...)
+	data = append(data, EncodeByteArray(msg.StartKey)...)
+	data = append(data, EncodeByteArray(msg.EndKey)...)
+	data = append(data, BoolToByte(msg.IncludeEntries))
+
+	return data, nil
+}
+
+func (msg *MsgDeSoGetStateChecksum) FromBytes(data []byte) error {
+	var err error
+
+	rr := bytes.NewReader(data)
+
+	msg.SnapshotHeight, err = ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetStateChecksum.FromBytes: Error reading snapshot height")
+	}
+	msg.StartKey, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetStateChecksum.FromBytes: Error reading start key")
+	}
+	if len(msg.StartKey) == 0 {
+		return fmt.Errorf("MsgDeSoGetStateChecksum.FromBytes: Received an empty StartKey")
+	}
+	msg.EndKey, err = DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetStateChecksum.FromBytes: Error reading end key")
+	}
+	msg.IncludeEntries, err = ReadBoolByte(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetStateChecksum.FromBytes: Error reading IncludeEntries")
+	}
+	return nil
+}
+
+func (msg *MsgDeSoGetStateChecksum) GetMsgType() MsgType {
+	return MsgTypeGetStateChecksum
+}
+
+func (msg *MsgDeSoGetStateChecksum) GetPrefix() []byte {
+	return msg.StartKey[:1]
+}
+
+// MsgDeSoStateChecksum is the reply to MsgDeSoGetStateChecksum.
+type MsgDeSoStateChecksum struct {
+	// SnapshotMetadata is the information about the peer's current snapshot epoch.
+	SnapshotMetadata *SnapshotEpochMetadata
+
+	// StartKey and EndKey are the range covered by the checksum. EndKey can be smaller than the requested
+	// EndKey if the range had more than StateChecksumMaxRangeEntries records, in which case the rest of
+	// the range should be requested separately.
+	StartKey []byte
+	EndKey   []byte
+
+	// Checksum is the state checksum of all records in the range, and NumEntries is their count. Checksum
+	// is empty if the peer is at a different snapshot epoch than the one requested.
+	Checksum   []byte
+	NumEntries uint64
+	// SplitKey is the key of the middle record in the range. It can be used to split the range in half.
+	SplitKey []byte
+
+	// Entries are the records in the range, if they were requested and there weren't too many of them.
+	Entries []*DBEntry
+}
+
+func (msg *MsgDeSoStateChecksum) ToBytes(preSignature bool) ([]byte, error) {
+	if msg.SnapshotMetadata == nil {
+		return nil, fmt.Errorf("MsgDeSoStateChecksum.ToBytes: SnapshotMetadata should not be nil")
+	}
+	data := []byte{}
+	data = append(data, msg.SnapshotMetadata.ToBytes()...)
+	data = append(data, EncodeByteArray(msg.StartKey)...)
+	data = append(data, EncodeByteArray(msg.EndKey)...)
+	data = append(data, EncodeByteArray(msg.Checksum)...)
+	data = append(data, UintToBuf(msg.NumEntries)...)
+	data = append(data, EncodeByteArray(msg.SplitKey)...)
+	data = append(data, UintToBuf(uint64(len(msg.Entries)))...)
+	for _, entry := range msg.Entries {
+		data = append(data, entry.ToBytes()...)
+	}
+
+	return data, nil
+}
+
+func (msg *MsgDeSoStateChecksum) FromBytes(data []byte) error {
+	var err error
+
+	rr := bytes.NewReader(data)
+
+	msg.SnapshotMetadata = &SnapshotEpochMetadata{}
+	if err := msg.SnapshotMetadata.FromBytes(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding snapshot metadata")
+	}
+	if msg.StartKey, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding StartKey")
+	}
+	if msg.EndKey, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding EndKey")
+	}
+	if msg.Checksum, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding Checksum")
+	}
+	if msg.NumEntries, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding NumEntries")
+	}
+	if msg.SplitKey, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding SplitKey")
+	}
+	numEntries, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding length of Entries")
+	}
+	if numEntries > StateChecksumMaxIncludedEntries {
+		return fmt.Errorf("MsgDeSoStateChecksum.FromBytes: Number of entries (%v) exceeds the max (%v)",
+			numEntries, StateChecksumMaxIncludedEntries)
+	}
+	for ; numEntries > 0; numEntries-- {
+		dbEntry := &DBEntry{}
+		if err := dbEntry.FromBytes(rr); err != nil {
+			return errors.Wrapf(err, "MsgDeSoStateChecksum.FromBytes: Problem decoding Entries")
+		}
+		msg.Entries = append(msg.Entries, dbEntry)
+	}
+
+	return nil
+}
+
+func (msg *MsgDeSoStateChecksum) GetMsgType() MsgType {
+	return MsgTypeStateChecksum
+}
+
+// ==================================================================
+// Compact block messages
+// ==================================================================
+
+// CompactBlockShortTxnIDLen is the number of bytes of a short transaction id in a compact block.
+const CompactBlockShortTxnIDLen = 6
+
+// PrefilledTxn is a transaction sent in full as part of a compact block. Index is the position of the
+// transaction in the block.
+type PrefilledTxn struct {
+	Index uint64
+	Txn   *MsgDeSoTxn
+}
+
+// MsgDeSoCompactBlock is a block in which most transactions are replaced by short ids. Transactions
+// the receiver is unlikely to have, like the block reward, are prefilled. The short ids are listed in
+// block order and fill the positions that aren't taken by prefilled transactions.
+type MsgDeSoCompactBlock struct {
+	Header *MsgDeSoHeader
+	// Nonce is mixed into the short ids together with the block hash, so that a short id collision
+	// in one block doesn't carry over to others.
+	Nonce         uint64
+	ShortTxnIDs   []uint64
+	PrefilledTxns []*PrefilledTxn
+
+	BlockProducerInfo *BlockProducerInfo
+}
+
+// NumTxns is the number of transactions in the block.
+func (msg *MsgDeSoCompactBlock) NumTxns() uint64 {
+	return uint64(len(msg.ShortTxnIDs) + len(msg.PrefilledTxns))
+}
+
+func (msg *MsgDeSoCompactBlock) ToBytes(preSignature bool) ([]byte, error) {
+	if msg.Header == nil {
+		return nil, fmt.Errorf("MsgDeSoCompactBlock.ToBytes: Header should not be nil")
+	}
+	hdrBytes, err := msg.Header.ToBytes(preSignature)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MsgDeSoCompactBlock.ToBytes: Problem encoding header")
+	}
+	data := []byte{}
+	data = append(data, EncodeByteArray(hdrBytes)...)
+	data = append(data, UintToBuf(msg.Nonce)...)
+
+	data = append(data, UintToBuf(uint64(len(msg.ShortTxnIDs)))...)
+	for _, shortTxnID := range msg.ShortTxnIDs {
+		if shortTxnID>>(8*CompactBlockShortTxnIDLen) != 0 {
+			return nil, fmt.Errorf("MsgDeSoCompactBlock.ToBytes: Short txn id %d is longer "+
+				"than %d bytes", shortTxnID, CompactBlockShortTxnIDLen)
+		}
+		shortTxnIDBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(shortTxnIDBytes, shortTxnID)
+		data = append(data, shortTxnIDBytes[8-CompactBlockShortTxnIDLen:]...)
+	}
+
+	data = append(data, UintToBuf(uint64(len(msg.PrefilledTxns)))...)
+	for _, prefilledTxn := range msg.PrefilledTxns {
+		if prefilledTxn.Txn == nil {
+			return nil, fmt.Errorf("MsgDeSoCompactBlock.ToBytes: Prefilled txn %d should not be nil",
+				prefilledTxn.Index)
+		}
+		txnBytes, err := prefilledTxn.Txn.ToBytes(preSignature)
+		if err != nil {
+			return nil, errors.Wrapf(err, "MsgDeSoCompactBlock.ToBytes: Problem encoding prefilled txn")
+		}
+		data = append(data, UintToBuf(prefilledTxn.Index)...)
+		data = append(data, EncodeByteArray(txnBytes)...)
+	}
+
+	blockProducerInfoBytes := []byte{}
+	if msg.BlockProducerInfo != nil {
+		blockProducerInfoBytes = msg.BlockProducerInfo.Serialize()
+	}
+	data = append(data, EncodeByteArray(blockProducerInfoBytes)...)
+
+	return data, nil
+}
+
+func (msg *MsgDeSoCompactBlock) FromBytes(data []byte) error {
+	ret := &MsgDeSoCompactBlock{}
+	rr := bytes.NewReader(data)
+
+	hdrBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading header")
+	}
+	ret.Header = NewMessage(MsgTypeHeader).(*MsgDeSoHeader)
+	if err = ret.Header.FromBytes(hdrBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding header")
+	}
+	if ret.Header.Version != HeaderVersion0 && ret.Header.Version != HeaderVersion1 {
+		return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: Unrecognized header version: %v", ret.Header.Version)
+	}
+	if ret.Nonce, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading nonce")
+	}
+
+	numShortTxnIDs, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading number of short txn ids")
+	}
+	if numShortTxnIDs > MaxMessagePayload/CompactBlockShortTxnIDLen {
+		return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: Number of short txn ids %d exceeds max %d",
+			numShortTxnIDs, MaxMessagePayload/CompactBlockShortTxnIDLen)
+	}
+	shortTxnIDBytes, err := SafeReadBytes(rr, numShortTxnIDs*CompactBlockShortTxnIDLen)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading short txn ids")
+	}
+	ret.ShortTxnIDs = make([]uint64, numShortTxnIDs)
+	for ii := range ret.ShortTxnIDs {
+		paddedShortTxnID := make([]byte, 8)
+		copy(paddedShortTxnID[8-CompactBlockShortTxnIDLen:],
+			shortTxnIDBytes[ii*CompactBlockShortTxnIDLen:(ii+1)*CompactBlockShortTxnIDLen])
+		ret.ShortTxnIDs[ii] = binary.BigEndian.Uint64(paddedShortTxnID)
+	}
+
+	numPrefilledTxns, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading number of prefilled txns")
+	}
+	// Every prefilled txn takes at least two bytes, for its index and its length.
+	if err = ValidateDecodedLength(rr, 2*numPrefilledTxns); err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Too many prefilled txns")
+	}
+	numTxns := numShortTxnIDs + numPrefilledTxns
+	for ii := uint64(0); ii < numPrefilledTxns; ii++ {
+		index, err := ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading prefilled txn index")
+		}
+		// Indexes must be increasing and point inside the block.
+		if index >= numTxns || (ii > 0 && index <= ret.PrefilledTxns[ii-1].Index) {
+			return fmt.Errorf("MsgDeSoCompactBlock.FromBytes: Invalid prefilled txn index %d", index)
+		}
+		txnBytes, err := DecodeByteArray(rr)
+		if err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading prefilled txn")
+		}
+		txn := NewMessage(MsgTypeTxn).(*MsgDeSoTxn)
+		if err = txn.FromBytes(txnBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding prefilled txn")
+		}
+		ret.PrefilledTxns = append(ret.PrefilledTxns, &PrefilledTxn{
+			Index: index,
+			Txn:   txn,
+		})
+	}
+
+	blockProducerInfoBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem reading block producer info")
+	}
+	if len(blockProducerInfoBytes) > 0 {
+		ret.BlockProducerInfo = &BlockProducerInfo{}
+		if err = ret.BlockProducerInfo.Deserialize(blockProducerInfoBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoCompactBlock.FromBytes: Problem decoding block producer info")
+		}
+	}
+
+	*msg = *ret
+	return nil
+}
+
+func (msg *MsgDeSoCompactBlock) GetMsgType() MsgType {
+	return MsgTypeCompactBlock
+}
+
+func (msg *MsgDeSoCompactBlock) String() string {
+	if msg == nil || msg.Header == nil {
+		return "<nil compact block or header>"
+	}
+	return fmt.Sprintf("<Header: %v, ShortTxnIDs: %d, PrefilledTxns: %d, %v>", msg.Header.String(),
+		len(msg.ShortTxnIDs), len(msg.PrefilledTxns), msg.BlockProducerInfo)
+}
+
+// _encodeTxnIndexes encodes a list of increasing txn indexes in a block as differences between
+// consecutive indexes, which keeps them small.
+func _encodeTxnIndexes(txnIndexes []uint64) ([]byte, error) {
+	data := []byte{}
+	data = append(data, UintToBuf(uint64(len(txnIndexes)))...)
+	for ii, index := range txnIndexes {
+		if ii > 0 && index <= txnIndexes[ii-1] {
+			return nil, fmt.Errorf("_encodeTxnIndexes: Indexes should be increasing")
+		}
+		if ii == 0 {
+			data = append(data, UintToBuf(index)...)
+		} else {
+			data = append(data, UintToBuf(index-txnIndexes[ii-1]-1)...)
+		}
+	}
+	return data, nil
+}
+
+func _decodeTxnIndexes(rr *bytes.Reader) ([]uint64, error) {
+	numIndexes, err := ReadUvarint(rr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "_decodeTxnIndexes: Problem reading number of indexes")
+	}
+	if err = ValidateDecodedLength(rr, numIndexes); err != nil {
+		return nil, errors.Wrapf(err, "_decodeTxnIndexes: Too many indexes")
+	}
+	txnIndexes := make([]uint64, 0, numIndexes)
+	for ii := uint64(0); ii < numIndexes; ii++ {
+		delta, err := ReadUvarint(rr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "_decodeTxnIndexes: Problem reading index")
+		}
+		index := delta
+		if ii > 0 {
+			index = txnIndexes[ii-1] + delta + 1
+			if index <= txnIndexes[ii-1] {
+				return nil, fmt.Errorf("_decodeTxnIndexes: Index overflows")
+			}
+		}
+		txnIndexes = append(txnIndexes, index)
+	}
+	return txnIndexes, nil
+}
+
+// MsgDeSoGetBlockTxns asks a peer that sent us a compact block for the transactions we couldn't find
+// in our mempool. TxnIndexes are the positions of those transactions in the block, in increasing order.
+type MsgDeSoGetBlockTxns struct {
+	BlockHash  *BlockHash
+	TxnIndexes []uint64
+}
+
+func (msg *MsgDeSoGetBlockTxns) ToBytes(preSignature bool) ([]byte, error) {
+	if msg.BlockHash == nil {
+		return nil, fmt.Errorf("MsgDeSoGetBlockTxns.ToBytes: BlockHash should not be nil")
+	}
+	data := []byte{}
+	data = append(data, msg.BlockHash[:]...)
+	txnIndexesBytes, err := _encodeTxnIndexes(msg.TxnIndexes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MsgDeSoGetBlockTxns.ToBytes: ")
+	}
+	data = append(data, txnIndexesBytes...)
+
+	return data, nil
+}
+
+func (msg *MsgDeSoGetBlockTxns) FromBytes(data []byte) error {
+	ret := &MsgDeSoGetBlockTxns{}
+	rr := bytes.NewReader(data)
+
+	blockHashBytes := make([]byte, HashSizeBytes)
+	if _, err := io.ReadFull(rr, blockHashBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetBlockTxns.FromBytes: Problem reading block hash")
+	}
+	ret.BlockHash = NewBlockHash(blockHashBytes)
+	txnIndexes, err := _decodeTxnIndexes(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetBlockTxns.FromBytes: ")
+	}
+	ret.TxnIndexes = txnIndexes
+
+	*msg = *ret
+	return nil
+}
+
+func (msg *MsgDeSoGetBlockTxns) GetMsgType() MsgType {
+	return MsgTypeGetBlockTxns
+}
+
+// MsgDeSoBlockTxns is the reply to MsgDeSoGetBlockTxns. Txns are in the order they were requested.
+type MsgDeSoBlockTxns struct {
+	BlockHash *BlockHash
+	Txns      []*MsgDeSoTxn
+}
+
+func (msg *MsgDeSoBlockTxns) ToBytes(preSignature bool) ([]byte, error) {
+	if msg.BlockHash == nil {
+		return nil, fmt.Errorf("MsgDeSoBlockTxns.ToBytes: BlockHash should not be nil")
+	}
+	data := []byte{}
+	data = append(data, msg.BlockHash[:]...)
+	data = append(data, UintToBuf(uint64(len(msg.Txns)))...)
+	for _, txn := range msg.Txns {
+		txnBytes, err := txn.ToBytes(preSignature)
+		if err != nil {
+			return nil, errors.Wrapf(err, "MsgDeSoBlockTxns.ToBytes: Problem encoding txn")
+		}
+		data = append(data, EncodeByteArray(txnBytes)...)
+	}
+
+	return data, nil
+}
+
+func (msg *MsgDeSoBlockTxns) FromBytes(data []byte) error {
+	ret := &MsgDeSoBlockTxns{}
+	rr := bytes.NewReader(data)
+
+	blockHashBytes := make([]byte, HashSizeBytes)
+	if _, err := io.ReadFull(rr, blockHashBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoBlockTxns.FromBytes: Problem reading block hash")
+	}
+	ret.BlockHash = NewBlockHash(blockHashBytes)
+	numTxns, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoBlockTxns.FromBytes: Problem reading number of txns")
+	}
+	if err = ValidateDecodedLength(rr, numTxns); err != nil {
+		return errors.Wrapf(err, "MsgDeSoBlockTxns.FromBytes: Too many txns")
+	}
+	for ii := uint64(0); ii < numTxns; ii++ {
+		txnBytes, err := DecodeByteArray(rr)
+		if err != nil {
+			return errors.Wrapf(err, "MsgDeSoBlockTxns.FromBytes: Problem reading txn")
+		}
+		txn := NewMessage(MsgTypeTxn).(*MsgDeSoTxn)
+		if err = txn.FromBytes(txnBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoBlockTxns.FromBytes: Problem decoding txn")
+		}
+		ret.Txns = append(ret.Txns, txn)
+	}
+
+	*msg = *ret
+	return nil
+}
+
+func (msg *MsgDeSoBlockTxns) GetMsgType() MsgType {
+	return MsgTypeBlockTxns
+}
+
+// MsgDeSoGetTxnProof asks a peer for a proof that a transaction is included in a block.
+type MsgDeSoGetTxnProof struct {
+	BlockHash *BlockHash
+	TxnHash   *BlockHash
+}
+
+func (msg *MsgDeSoGetTxnProof) ToBytes(preSignature bool) ([]byte, error) {
+	if msg.BlockHash == nil || msg.TxnHash == nil {
+		return nil, fmt.Errorf("MsgDeSoGetTxnProof.ToBytes: BlockHash and TxnHash should not be nil")
+	}
+	data := []byte{}
+	data = append(data, msg.BlockHash[:]...)
+	data = append(data, msg.TxnHash[:]...)
+
+	return data, nil
+}
+
+func (msg *MsgDeSoGetTxnProof) FromBytes(data []byte) error {
+	ret := &MsgDeSoGetTxnProof{}
+	rr := bytes.NewReader(data)
+
+	blockHashBytes := make([]byte, HashSizeBytes)
+	if _, err := io.ReadFull(rr, blockHashBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetTxnProof.FromBytes: Problem reading block hash")
+	}
+	ret.BlockHash = NewBlockHash(blockHashBytes)
+	txnHashBytes := make([]byte, HashSizeBytes)
+	if _, err := io.ReadFull(rr, txnHashBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoGetTxnProof.FromBytes: Problem reading txn hash")
+	}
+	ret.TxnHash = NewBlockHash(txnHashBytes)
+
+	*msg = *ret
+	return nil
+}
+
+func (msg *MsgDeSoGetTxnProof) GetMsgType() MsgType {
+	return MsgTypeGetTxnProof
+}
+
+// MsgDeSoTxnProof is the reply to MsgDeSoGetTxnProof. Header, BlockProducerInfo, and Proof are nil if the
+// peer doesn't have the block or the transaction isn't in it. BlockProducerInfo is also nil for blocks
+// that weren't signed.
+type MsgDeSoTxnProof struct {
+	BlockHash         *BlockHash
+	TxnHash           *BlockHash
+	Header            *MsgDeSoHeader
+	BlockProducerInfo *BlockProducerInfo
+	Proof             *TxnMerkleProof
+}
+
+func (msg *MsgDeSoTxnProof) ToBytes(preSignature bool) ([]byte, error) {
+	if msg.BlockHash == nil || msg.TxnHash == nil {
+		return nil, fmt.Errorf("MsgDeSoTxnProof.ToBytes: BlockHash and TxnHash should not be nil")
+	}
+	data := []byte{}
+	data = append(data, msg.BlockHash[:]...)
+	data = append(data, msg.TxnHash[:]...)
+
+	hdrBytes := []byte{}
+	if msg.Header != nil {
+		var err error
+		hdrBytes, err = msg.Header.ToBytes(preSignature)
+		if err != nil {
+			return nil, errors.Wrapf(err, "MsgDeSoTxnProof.ToBytes: Problem encoding header")
+		}
+	}
+	data = append(data, EncodeByteArray(hdrBytes)...)
+
+	blockProducerInfoBytes := []byte{}
+	if msg.BlockProducerInfo != nil {
+		blockProducerInfoBytes = msg.BlockProducerInfo.Serialize()
+	}
+	data = append(data, EncodeByteArray(blockProducerInfoBytes)...)
+
+	proofBytes := []byte{}
+	if msg.Proof != nil {
+		proofBytes = msg.Proof.ToBytes()
+	}
+	data = append(data, EncodeByteArray(proofBytes)...)
+
+	return data, nil
+}
+
+func (msg *MsgDeSoTxnProof) FromBytes(data []byte) error {
+	ret := &MsgDeSoTxnProof{}
+	rr := bytes.NewReader(data)
+
+	blockHashBytes := make([]byte, HashSizeBytes)
+	if _, err := io.ReadFull(rr, blockHashBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem reading block hash")
+	}
+	ret.BlockHash = NewBlockHash(blockHashBytes)
+	txnHashBytes := make([]byte, HashSizeBytes)
+	if _, err := io.ReadFull(rr, txnHashBytes); err != nil {
+		return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem reading txn hash")
+	}
+	ret.TxnHash = NewBlockHash(txnHashBytes)
+
+	hdrBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem reading header")
+	}
+	if len(hdrBytes) > 0 {
+		ret.Header = NewMessage(MsgTypeHeader).(*MsgDeSoHeader)
+		if err = ret.Header.FromBytes(hdrBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem decoding header")
+		}
+	}
+
+	blockProducerInfoBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem reading block producer info")
+	}
+	if len(blockProducerInfoBytes) > 0 {
+		ret.BlockProducerInfo = &BlockProducerInfo{}
+		if err = ret.BlockProducerInfo.Deserialize(blockProducerInfoBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem decoding block producer info")
+		}
+	}
+
+	proofBytes, err := DecodeByteArray(rr)
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem reading proof")
+	}
+	if len(proofBytes) > 0 {
+		ret.Proof = &TxnMerkleProof{}
+		if err = ret.Proof.FromBytes(proofBytes); err != nil {
+			return errors.Wrapf(err, "MsgDeSoTxnProof.FromBytes: Problem decoding proof")
+		}
+	}
+
+	*msg = *ret
+	return nil
+}
+
+func (msg *MsgDeSoTxnProof) GetMsgType() MsgType {
+	return MsgTypeTxnProof
+}
+
 // ==================================================================
 // TXN Message
 // ==================================================================
@@ -2714,8 +3467,7 @@ func _readTransaction(rr io.Reader) (*MsgDeSoTxn, error) {
 	if metaLen > MaxMessagePayload {
 		return nil, fmt.Errorf("_readTransaction.FromBytes: metaLen length %d longer than max %d", metaLen, MaxMessagePayload)
 	}
-	metaBuf := make([]byte, metaLen)
-	_, err = io.ReadFull(rr, metaBuf)
+	metaBuf, err := SafeReadBytes(rr, metaLen)
 	if err != nil {
 		return nil, errors.Wrapf(err, "_readTransaction: Problem reading TxnMeta")
 	}
@@ -2734,8 +3486,7 @@ func _readTransaction(rr io.Reader) (*MsgDeSoTxn, error) {
 	}
 	ret.PublicKey = nil
 	if pkLen != 0 {
-		ret.PublicKey = make([]byte, pkLen)
-		_, err = io.ReadFull(rr, ret.PublicKey)
+		ret.PublicKey, err = SafeReadBytes(rr, pkLen)
 		if err != nil {
 			return nil, errors.Wrapf(err, "_readTransaction: Problem reading DeSoTxn.PublicKey")
 		}
@@ -2759,8 +3510,7 @@ func _readTransaction(rr io.Reader) (*MsgDeSoTxn, error) {
 
 	ret.Signature = nil
 	if sigLen != 0 {
-		sigBytes := make([]byte, sigLen)
-		_, err = io.ReadFull(rr, sigBytes)
+		sigBytes, err := SafeReadBytes(rr, sigLen)
 		if err != nil {
 			return nil, errors.Wrapf(err, "_readTransaction: Problem reading DeSoTxn.Signature")
 		}
@@ -3151,8 +3901,7 @@ func (txnData *BitcoinExchangeMetadata) FromBytes(data []byte) error {
 		return fmt.Errorf("BitcoinExchangeMetadata.FromBytes: txnBytesLen %d "+
 			"exceeds max %d", txnBytesLen, MaxMessagePayload)
 	}
-	txnBytes := make([]byte, txnBytesLen)
-	_, err = io.ReadFull(rr, txnBytes)
+	txnBytes, err := SafeReadBytes(rr, txnBytesLen)
 	if err != nil {
 		return fmt.Errorf("BitcoinExchangeMetadata.FromBytes: Error reading txnBytes: %v", err)
 	}
@@ -3294,8 +4043,7 @@ func (txnData *PrivateMessageMetadata) FromBytes(data []byte) error {
 		return fmt.Errorf("PrivateMessageMetadata.FromBytes: encryptedTextLen %d "+
 			"exceeds max %d", encryptedTextLen, MaxMessagePayload)
 	}
-	ret.EncryptedText = make([]byte, encryptedTextLen)
-	_, err = io.ReadFull(rr, ret.EncryptedText)
+	ret.EncryptedText, err = SafeReadBytes(rr, encryptedTextLen)
 	if err != nil {
 		return fmt.Errorf("PrivateMessageMetadata.FromBytes: Error reading EncryptedText: %v", err)
 	}
@@ -3487,6 +4235,64 @@ func BoolToByte(val bool) byte {
 	return 0
 }
 
+// _getRemainingBytes returns the number of unread bytes in rr if rr knows it. Decoders almost
+// always read from a *bytes.Reader, which does.
+func _getRemainingBytes(rr io.Reader) (_remainingBytes uint64, _ok bool) {
+	lenReader, ok := rr.(interface{ Len() int })
+	if !ok {
+		return 0, false
+	}
+	return uint64(lenReader.Len()), true
+}
+
+// ValidateDecodedLength returns an error if a length read from rr claims more elements than rr
+// has bytes left. Every element takes at least one byte to encode, so decoders call this before
+// allocating anything sized by a length they've read. Otherwise a few bytes from a peer could
+// make us allocate gigabytes of memory.
+func ValidateDecodedLength(rr io.Reader, length uint64) error {
+	if remainingBytes, ok := _getRemainingBytes(rr); ok {
+		if length > remainingBytes {
+			return errors.Wrapf(io.ErrUnexpectedEOF, "ValidateDecodedLength: Length %d exceeds "+
+				"the %d bytes remaining in the input", length, remainingBytes)
+		}
+		return nil
+	}
+	if length > MaxMessagePayload {
+		return fmt.Errorf("ValidateDecodedLength: Length %d exceeds max %d",
+			length, MaxMessagePayload)
+	}
+	return nil
+}
+
+// SafeReadBytes reads exactly length bytes from rr. Unlike allocating length bytes and calling
+// io.ReadFull, it never allocates more memory than rr can actually supply.
+func SafeReadBytes(rr io.Reader, length uint64) ([]byte, error) {
+	if err := ValidateDecodedLength(rr, length); err != nil {
+		return nil, errors.Wrapf(err, "SafeReadBytes: Problem validating length")
+	}
+	if _, ok := _getRemainingBytes(rr); ok {
+		ret := make([]byte, length)
+		if _, err := io.ReadFull(rr, ret); err != nil {
+			return nil, errors.Wrapf(err, "SafeReadBytes: Problem reading %d bytes", length)
+		}
+		return ret, nil
+	}
+
+	// We don't know how much data the reader has, so grow the buffer as the bytes arrive.
+	initialCapacity := length
+	if initialCapacity > 4096 {
+		initialCapacity = 4096
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, initialCapacity))
+	if _, err := io.CopyN(buf, rr, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, errors.Wrapf(err, "SafeReadBytes: Problem reading %d bytes", length)
+	}
+	return buf.Bytes(), nil
+}
+
 type SubmitPostMetadata struct {
 	// The creator of the post is assumed to be the originator of the
 	// top-level transaction.
@@ -3573,8 +4379,7 @@ func ReadVarString(rr io.Reader) ([]byte, error) {
 		return nil, fmt.Errorf("SubmitPostMetadata.FromBytes: StringLen %d "+
 			"exceeds max %d", StringLen, MaxMessagePayload)
 	}
-	ret := make([]byte, StringLen)
-	_, err = io.ReadFull(rr, ret)
+	ret, err := SafeReadBytes(rr, StringLen)
 	if err != nil {
 		return nil, fmt.Errorf("SubmitPostMetadata.FromBytes: Error reading StringText: %v", err)
 	}
@@ -4316,6 +5121,9 @@ func (txnData *AcceptNFTBidMetadata) FromBytes(dataa []byte) error {
 		return fmt.Errorf(
 			"AcceptNFTBidMetadata.FromBytes: Error reading BidderPublicKey: %v", err)
 	}
+	if len(bidderPKIDBytes) == 0 {
+		return fmt.Errorf("AcceptNFTBidMetadata.FromBytes: BidderPublicKey is missing")
+	}
 	ret.BidderPKID = PublicKeyToPKID(bidderPKIDBytes)
 
 	// BidAmountNanos uint64
@@ -4334,8 +5142,7 @@ func (txnData *AcceptNFTBidMetadata) FromBytes(dataa []byte) error {
 		return fmt.Errorf("AcceptNFTBidMetadata.FromBytes: unlockableTextLen %d "+
 			"exceeds max %d", unlockableTextLen, MaxMessagePayload)
 	}
-	ret.UnlockableText = make([]byte, unlockableTextLen)
-	_, err = io.ReadFull(rr, ret.UnlockableText)
+	ret.UnlockableText, err = SafeReadBytes(rr, unlockableTextLen)
 	if err != nil {
 		return fmt.Errorf("AcceptNFTBidMetadata.FromBytes: Error reading EncryptedText: %v", err)
 	}
@@ -4518,8 +5325,7 @@ func (txnData *NFTTransferMetadata) FromBytes(dataa []byte) error {
 		return fmt.Errorf("NFTTransferMetadata.FromBytes: unlockableTextLen %d "+
 			"exceeds max %d", unlockableTextLen, MaxMessagePayload)
 	}
-	ret.UnlockableText = make([]byte, unlockableTextLen)
-	_, err = io.ReadFull(rr, ret.UnlockableText)
+	ret.UnlockableText, err = SafeReadBytes(rr, unlockableTextLen)
 	if err != nil {
 		return fmt.Errorf("NFTTransferMetadata.FromBytes: Error reading EncryptedText: %v", err)
 	}
@@ -5129,8 +5935,8 @@ func (nftOperationLimitKey *NFTOperationLimitKey) Decode(rr *bytes.Reader) error
 		return err
 	}
 	// De-serialize the key
-	blockhashBytes := make([]byte, blockHashLen)
-	if _, err = io.ReadFull(rr, blockhashBytes); err != nil {
+	blockhashBytes, err := SafeReadBytes(rr, blockHashLen)
+	if err != nil {
 		return err
 	}
 	blockHash := NewBlockHash(blockhashBytes)
@@ -5238,8 +6044,8 @@ func (creatorCoinOperationLimitKey *CreatorCoinOperationLimitKey) Decode(rr *byt
 		return err
 	}
 	// De-serialize the key
-	creatorPKIDBytes := make([]byte, creatorPKIDBytesLen)
-	if _, err = io.ReadFull(rr, creatorPKIDBytes); err != nil {
+	creatorPKIDBytes, err := SafeReadBytes(rr, creatorPKIDBytesLen)
+	if err != nil {
 		return err
 	}
 	creatorPKID := NewPKID(creatorPKIDBytes)
@@ -5352,8 +6158,8 @@ func (daoCoinOperationLimitKey *DAOCoinOperationLimitKey) Decode(rr *bytes.Reade
 		return err
 	}
 	// De-serialize the key
-	creatorPKIDBytes := make([]byte, creatorPKIDBytesLen)
-	if _, err = io.ReadFull(rr, creatorPKIDBytes); err != nil {
+	creatorPKIDBytes, err := SafeReadBytes(rr, creatorPKIDBytesLen)
+	if err != nil {
 		return err
 	}
 	creatorPKID := NewPKID(creatorPKIDBytes)
@@ -5577,8 +6383,7 @@ func (txnData *DAOCoinMetadata) FromBytes(data []byte) error {
 			return fmt.Errorf("DAOCoinMetadata.FromBytes: coinsToMintLen %d "+
 				"exceeds max %d", intLen, MaxMessagePayload)
 		}
-		coinsToMintBytes := make([]byte, intLen)
-		_, err = io.ReadFull(rr, coinsToMintBytes)
+		coinsToMintBytes, err := SafeReadBytes(rr, intLen)
 		if err != nil {
 			return fmt.Errorf("DAOCoinMetadata.FromBytes: Error reading coinsToMintBytes: %v", err)
 		}
@@ -5595,8 +6400,7 @@ func (txnData *DAOCoinMetadata) FromBytes(data []byte) error {
 			return fmt.Errorf("DAOCoinMetadata.FromBytes: coinsToBurnLen %d "+
 				"exceeds max %d", intLen, MaxMessagePayload)
 		}
-		coinsToBurnBytes := make([]byte, intLen)
-		_, err = io.ReadFull(rr, coinsToBurnBytes)
+		coinsToBurnBytes, err := SafeReadBytes(rr, intLen)
 		if err != nil {
 			return fmt.Errorf("DAOCoinMetadata.FromBytes: Error reading coinsToBurnBytes: %v", err)
 		}
@@ -5680,8 +6484,7 @@ func (txnData *DAOCoinTransferMetadata) FromBytes(data []byte) error {
 			return fmt.Errorf("DAOCoinTransferMetadata.FromBytes: coinsToTransferLen %d "+
 				"exceeds max %d", intLen, MaxMessagePayload)
 		}
-		coinsToTransferBytes := make([]byte, intLen)
-		_, err = io.ReadFull(rr, coinsToTransferBytes)
+		coinsToTransferBytes, err := SafeReadBytes(rr, intLen)
 		if err != nil {
 			return fmt.Errorf("DAOCoinTransferMetadata.FromBytes: Error reading coinsToTransferBytes: %v", err)
 		}
@@ -5950,6 +6753,9 @@ func DeserializePubKeyToUint64Map(data []byte) (map[PublicKey]uint64, error) {
 		return nil, errors.Wrapf(err, "DeserializePubKeyToUint64Map.FromBytes: Problem "+
 			"reading num keys")
 	}
+	if err = ValidateDecodedLength(rr, numKeys); err != nil {
+		return nil, errors.Wrapf(err, "DeserializePubKeyToUint64Map.FromBytes: Invalid num keys")
+	}
 	mm := make(map[PublicKey]uint64, numKeys)
 	for ii := uint64(0); ii < numKeys; ii++ {
 		// Read in the public key bytes
@@ -3,7 +3,9 @@ package lib
 import (
 	"bytes"
 	"encoding/hex"
+	"github.com/brianvoe/gofakeit"
 	"github.com/holiman/uint256"
+	"math/rand"
 	"reflect"
 	"strings"
 	"testing"
@@ -518,6 +520,46 @@ func TestSerializeAddresses(t *testing.T) {
 	require.Equal(addrs, parsedAddrs)
 }
 
+func TestSerializeStateChecksum(t *testing.T) {
+	require := require.New(t)
+
+	getChecksum := &MsgDeSoGetStateChecksum{
+		SnapshotHeight: 1000,
+		StartKey:       []byte{5, 1, 2, 3},
+		EndKey:         []byte{5, 4},
+		IncludeEntries: true,
+	}
+	bb, err := getChecksum.ToBytes(false)
+	require.NoError(err)
+	parsedGetChecksum := &MsgDeSoGetStateChecksum{}
+	require.NoError(parsedGetChecksum.FromBytes(bb))
+	require.Equal(getChecksum, parsedGetChecksum)
+	require.Equal([]byte{5}, parsedGetChecksum.GetPrefix())
+
+	checksum := &MsgDeSoStateChecksum{
+		SnapshotMetadata: &SnapshotEpochMetadata{
+			SnapshotBlockHeight:       1000,
+			FirstSnapshotBlockHeight:  500,
+			CurrentEpochChecksumBytes: []byte{1, 2, 3},
+			CurrentEpochBlockHash:     &BlockHash{0x01},
+		},
+		StartKey:   []byte{5, 1, 2, 3},
+		EndKey:     []byte{5, 4},
+		Checksum:   []byte{4, 5, 6},
+		NumEntries: 2,
+		SplitKey:   []byte{5, 2},
+		Entries: []*DBEntry{
+			{Key: []byte{5, 1, 2, 3}, Value: []byte{7}},
+			{Key: []byte{5, 2}, Value: []byte{8, 9}},
+		},
+	}
+	bb, err = checksum.ToBytes(false)
+	require.NoError(err)
+	parsedChecksum := &MsgDeSoStateChecksum{}
+	require.NoError(parsedChecksum.FromBytes(bb))
+	require.Equal(checksum, parsedChecksum)
+}
+
 func TestSerializeGetBlocks(t *testing.T) {
 	require := require.New(t)
 
@@ -1294,3 +1336,154 @@ func TestDecodeBlockVersion0(t *testing.T) {
 
 	require.Equal(expectedBytes, blockBytes)
 }
+
+// _allDeSoMessageTypes lists every MsgType that can be read off the wire.
+func _allDeSoMessageTypes() []MsgType {
+	var msgTypes []MsgType
+	for msgType := MsgTypeVersion; msgType < ControlMessagesStart; msgType++ {
+		if NewMessage(msgType) != nil {
+			msgTypes = append(msgTypes, msgType)
+		}
+	}
+	return msgTypes
+}
+
+// _checkDeSoMessageDecoding parses data as a message of msgType. Parsing must not panic or allocate more
+// memory than the input justifies, and if it succeeds then ToBytes must round-trip.
+func _checkDeSoMessageDecoding(t *testing.T, msgType MsgType, data []byte) {
+	require := require.New(t)
+
+	var msg DeSoMessage
+	var err error
+	allocatedBytes := _measureDecodeAllocatedBytes(len(data), func() {
+		msg = NewMessage(msgType)
+		err = msg.FromBytes(data)
+	})
+	require.LessOrEqual(allocatedBytes, _maxDecodeAllocationBytes(len(data)),
+		"Parsing %d bytes as %v allocated too much memory", len(data), msgType)
+	if err != nil {
+		return
+	}
+
+	encodedBytes, err := msg.ToBytes(false)
+	require.NoError(err, "Message type: %v", msgType)
+	reDecodedMsg := NewMessage(msgType)
+	require.NoError(reDecodedMsg.FromBytes(encodedBytes), "Message type: %v", msgType)
+	reEncodedBytes, err := reDecodedMsg.ToBytes(false)
+	require.NoError(err, "Message type: %v", msgType)
+	require.Equal(encodedBytes, reEncodedBytes, "Message type: %v", msgType)
+}
+
+// _tryDeSoMessageToBytes serializes msg, reporting false if msg is too incomplete to serialize. Seed
+// messages are built from zero values, and many ToBytes implementations dereference pointer fields
+// without checking them.
+func _tryDeSoMessageToBytes(msg DeSoMessage) (_msgBytes []byte, _ok bool) {
+	defer func() {
+		if recover() != nil {
+			_msgBytes, _ok = nil, false
+		}
+	}()
+	msgBytes, err := msg.ToBytes(false)
+	if err != nil {
+		return nil, false
+	}
+	return msgBytes, true
+}
+
+// _getDeSoMessageFuzzSeeds returns serialized messages of every type, including a transaction for every
+// TxnType, keyed by the index of the message's type in _allDeSoMessageTypes.
+func _getDeSoMessageFuzzSeeds() (_msgTypeIndexes []uint32, _seeds [][]byte) {
+	msgTypeIndexes := make(map[MsgType]uint32)
+	for ii, msgType := range _allDeSoMessageTypes() {
+		msgTypeIndexes[msgType] = uint32(ii)
+	}
+
+	seedMessages := []DeSoMessage{expectedVer, expectedBlockHeader, expectedV0Header, expectedBlock}
+	for _, txn := range expectedBlock.Txns {
+		seedMessages = append(seedMessages, txn)
+	}
+	gofakeit.Seed(1)
+	for txnType := TxnTypeUnset; txnType < TxnType(64); txnType++ {
+		txnMeta, err := NewTxnMetadata(txnType)
+		if err != nil {
+			continue
+		}
+		if bitcoinExchangeMeta, ok := txnMeta.(*BitcoinExchangeMetadata); ok {
+			bitcoinExchangeMeta.BitcoinTransaction = &wire.MsgTx{}
+			bitcoinExchangeMeta.BitcoinBlockHash = &BlockHash{}
+			bitcoinExchangeMeta.BitcoinMerkleRoot = &BlockHash{}
+		}
+		randomTxnMeta, _ := NewTxnMetadata(txnType)
+		gofakeit.Struct(randomTxnMeta)
+		for _, meta := range []DeSoTxnMetadata{txnMeta, randomTxnMeta} {
+			seedMessages = append(seedMessages, &MsgDeSoTxn{
+				PublicKey: pkForTesting1,
+				TxnMeta:   meta,
+			})
+		}
+	}
+	for _, msgType := range _allDeSoMessageTypes() {
+		seedMessages = append(seedMessages, NewMessage(msgType))
+	}
+
+	var indexes []uint32
+	var seeds [][]byte
+	for _, msg := range seedMessages {
+		msgBytes, ok := _tryDeSoMessageToBytes(msg)
+		if !ok {
+			continue
+		}
+		indexes = append(indexes, msgTypeIndexes[msg.GetMsgType()])
+		seeds = append(seeds, msgBytes)
+	}
+	return indexes, seeds
+}
+
+// FuzzDeSoMessageDecoding fuzzes FromBytes for every message a peer can send us. The first input picks
+// the message type.
+func FuzzDeSoMessageDecoding(f *testing.F) {
+	msgTypes := _allDeSoMessageTypes()
+	msgTypeIndexes, seeds := _getDeSoMessageFuzzSeeds()
+	for ii := range seeds {
+		f.Add(msgTypeIndexes[ii], seeds[ii])
+	}
+
+	f.Fuzz(func(t *testing.T, msgTypeIndex uint32, data []byte) {
+		_checkDeSoMessageDecoding(t, msgTypes[msgTypeIndex%uint32(len(msgTypes))], data)
+	})
+}
+
+// TestDeSoMessageDecodingMutations runs a fixed set of mutated messages through the same checks as
+// FuzzDeSoMessageDecoding so that regular test runs catch parsing regressions deterministically.
+func TestDeSoMessageDecodingMutations(t *testing.T) {
+	msgTypes := _allDeSoMessageTypes()
+	msgTypeIndexes, seeds := _getDeSoMessageFuzzSeeds()
+	rand := rand.New(rand.NewSource(0))
+	for ii := range seeds {
+		msgType := msgTypes[msgTypeIndexes[ii]]
+		_checkDeSoMessageDecoding(t, msgType, seeds[ii])
+		for jj := 0; jj < 200; jj++ {
+			_checkDeSoMessageDecoding(t, msgType, _mutateFuzzInput(rand, seeds[ii]))
+		}
+	}
+}
+
+func TestSnapshotDataEmptyChunk(t *testing.T) {
+	require := require.New(t)
+
+	// An empty chunk is a valid message, it's up to the server to reject it.
+	msg := &MsgDeSoSnapshotData{
+		SnapshotMetadata: &SnapshotEpochMetadata{
+			CurrentEpochChecksumBytes: []byte{},
+			CurrentEpochBlockHash:     &BlockHash{},
+		},
+		Prefix: []byte{5},
+	}
+	msgBytes, err := msg.ToBytes(false)
+	require.NoError(err)
+	decodedMsg := &MsgDeSoSnapshotData{}
+	require.NoError(decodedMsg.FromBytes(msgBytes))
+	require.Empty(decodedMsg.SnapshotChunk)
+	require.Equal(msg.Prefix, decodedMsg.Prefix)
+	_checkDeSoMessageDecoding(t, MsgTypeSnapshotData, msgBytes)
+}
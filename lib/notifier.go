@@ -3,7 +3,6 @@ package lib
 import (
 	"encoding/json"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/gernest/mention"
 	"github.com/go-pg/pg/v10"
 	"reflect"
@@ -21,7 +20,7 @@ type Notifier struct {
 	db *pg.DB
 
 	// Shortcut to coreChain.db
-	badger *badger.DB
+	badger KVStore
 }
 
 func NewNotifier(coreChain *Blockchain, postgres *Postgres) *Notifier {
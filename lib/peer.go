@@ -1,6 +1,8 @@
 package lib
 
 import (
+	"bytes"
+	"encoding/hex"
 	"fmt"
 	"github.com/decred/dcrd/lru"
 	"math"
@@ -43,11 +45,15 @@ type Peer struct {
 	totalMessages uint64
 	lastRecv      int64
 	lastSend      int64
+	// traffic counts the messages and bytes we exchanged with the peer by message type.
+	traffic *TrafficCounters
 
 	// Stats that should be accessed using the mutex below.
 	StatsMtx       deadlock.RWMutex
 	TimeOffsetSecs int64
 	TimeConnected  time.Time
+	// connectedAt is when we finished negotiating versions with the peer.
+	connectedAt    time.Time
 	startingHeight uint32
 	ID             uint64
 	// Ping-related fields.
@@ -79,6 +85,10 @@ type Peer struct {
 	// messy; ideally we could do it without keeping global state.
 	VersionNonceSent     uint64
 	VersionNonceReceived uint64
+	// versionPayloadSent and versionPayloadReceived are the serialized version messages we
+	// exchanged. The transport handshake is bound to them.
+	versionPayloadSent     []byte
+	versionPayloadReceived []byte
 
 	// A pointer to the Server
 	srv *Server
@@ -136,12 +146,37 @@ type Peer struct {
 	requestedBlocks map[BlockHash]bool
 
 	// We will only allow peer fetch one snapshot chunk at a time so we will keep
-	// track whether this peer has a get snapshot request in flight.
-	snapshotChunkRequestInFlight bool
+	// track whether this peer has a get snapshot request in flight. The chunk is served
+	// in the background, so this should be accessed atomically.
+	snapshotChunkRequestInFlight uint32
+	// stateChecksumRequestInFlight is the GetStateChecksum counterpart of snapshotChunkRequestInFlight.
+	stateChecksumRequestInFlight bool
+
+	// queuedBlocksToSend are the blocks requested by this peer that wait behind historical blocks
+	// held back by the upload caps, in the order they were requested. They're sent by a single
+	// sendQueuedBlocks goroutine, which is running while sendingQueuedBlocks is set.
+	queuedBlocksMtx     deadlock.Mutex
+	queuedBlocksToSend  []*BlockHash
+	sendingQueuedBlocks bool
 
 	// SyncType indicates whether blocksync should not be requested for this peer. If set to true
 	// then we'll only hypersync from this peer.
 	syncType NodeSyncType
+
+	// misbehaviorScore is the sum of the weights of the offenses this peer committed during this
+	// connection. Should be accessed atomically.
+	misbehaviorScore uint32
+
+	// pinnedTransportKey is the static key this peer must prove it owns during the transport
+	// handshake. It's only set for --connect-ips with a pinned key, and such peers must encrypt.
+	pinnedTransportKey []byte
+	// transportPublicKey is the static key the peer proved it owns during the transport
+	// handshake. It's nil if the connection isn't encrypted.
+	transportPublicKey []byte
+
+	// partialCompactBlocks are the compact blocks from this peer that we're waiting on missing
+	// txns for. Only accessed from the Server's messageHandler.
+	partialCompactBlocks map[BlockHash]*PartialCompactBlock
 }
 
 func (pp *Peer) AddDeSoMessage(desoMessage DeSoMessage, inbound bool) {
@@ -290,6 +325,11 @@ func (pp *Peer) HelpHandleInv(msg *MsgDeSoInv) {
 		copy(currentHash[:], invVect.Hash[:])
 
 		if invVect.Type == InvTypeTx {
+			// A header-only node has no state to validate transactions against.
+			if pp.srv.blockchain.headerOnly {
+				continue
+			}
+
 			// For transactions, check that the transaction isn't in the
 			// mempool and that it isn't currently being requested.
 			_, requestIsInFlight := pp.srv.requestedTransactionsMap[currentHash]
@@ -388,44 +428,190 @@ func (pp *Peer) HandleGetBlocks(msg *MsgDeSoGetBlocks) {
 	// With HyperSync there is a potential that a node will request blocks that we haven't yet stored, although we're
 	// fully synced. This can happen to archival nodes that haven't yet downloaded all historical blocks. If a GetBlock
 	// is sent to a non-archival node for blocks that we don't have, then the peer is misbehaving and should be disconnected.
-	for _, hashToSend := range msg.HashList {
+	for ii, hashToSend := range msg.HashList {
 		blockToSend := pp.srv.blockchain.GetBlock(hashToSend)
 		if blockToSend == nil {
 			// Don't ask us for blocks before verifying that we have them with a
 			// GetHeaders request.
 			glog.Errorf("Server._handleGetBlocks: Disconnecting peer %v because "+
 				"she asked for a block with hash %v that we don't have", pp, msg.HashList[0])
+			pp.Misbehaving(PeerMisbehaviorUnknownBlockRequest, fmt.Sprintf("Block %v", hashToSend))
 			pp.Disconnect()
 			return
 		}
+		// Blocks deep below our tip are historical and are held back once we reach an upload cap. Waiting for
+		// the caps here would block the message loop, so the rest of the blocks are queued, in order, and
+		// sent in the background.
+		if pp.cmgr != nil && pp.cmgr.Bandwidth.HasUploadCaps() &&
+			(pp.isHistoricalBlock(pp.srv.blockchain, blockToSend) || pp.hasQueuedBlocks()) {
+
+			pp.queueBlocksToSend(pp.srv.blockchain, pp.cmgr.Bandwidth, msg.HashList[ii:])
+			return
+		}
+		pp.AddDeSoMessage(blockToSend, false)
+	}
+}
+
+// isHistoricalBlock returns true if the block is deep enough below our tip to be held back by the upload caps.
+func (pp *Peer) isHistoricalBlock(blockchain *Blockchain, blk *MsgDeSoBlock) bool {
+	return blk.Header.Height+UploadCapRecentBlocks < uint64(blockchain.BlockTip().Height)
+}
+
+// hasQueuedBlocks returns true if blocks are waiting to be sent by sendQueuedBlocks. Blocks requested
+// in the meantime are queued behind them so that the peer receives the blocks in the order it asked.
+func (pp *Peer) hasQueuedBlocks() bool {
+	pp.queuedBlocksMtx.Lock()
+	defer pp.queuedBlocksMtx.Unlock()
+
+	return pp.sendingQueuedBlocks
+}
+
+// queueBlocksToSend adds the blocks to the queue of blocks to send, and starts sending them in the
+// background if we aren't already.
+func (pp *Peer) queueBlocksToSend(blockchain *Blockchain, bandwidth *BandwidthManager, hashes []*BlockHash) {
+	pp.queuedBlocksMtx.Lock()
+	defer pp.queuedBlocksMtx.Unlock()
+
+	pp.queuedBlocksToSend = append(pp.queuedBlocksToSend, hashes...)
+	if pp.sendingQueuedBlocks {
+		return
+	}
+	pp.sendingQueuedBlocks = true
+	go pp.sendQueuedBlocks(blockchain, bandwidth)
+}
+
+// sendQueuedBlocks sends the queued blocks one at a time, so that each historical block counts towards
+// the per-second cap before we serve the next. The blockchain and bandwidth manager are passed in because
+// the peer drops its references to the server and connection manager when it disconnects.
+func (pp *Peer) sendQueuedBlocks(blockchain *Blockchain, bandwidth *BandwidthManager) {
+	for {
+		pp.queuedBlocksMtx.Lock()
+		if len(pp.queuedBlocksToSend) == 0 || !pp.Connected() {
+			pp.queuedBlocksToSend = nil
+			pp.sendingQueuedBlocks = false
+			pp.queuedBlocksMtx.Unlock()
+			return
+		}
+		hashToSend := pp.queuedBlocksToSend[0]
+		pp.queuedBlocksToSend = pp.queuedBlocksToSend[1:]
+		pp.queuedBlocksMtx.Unlock()
+
+		blockToSend := blockchain.GetBlock(hashToSend)
+		if blockToSend == nil {
+			glog.Errorf("Peer.sendQueuedBlocks: Disconnecting peer %v because "+
+				"she asked for a block with hash %v that we don't have", pp, hashToSend)
+			pp.Misbehaving(PeerMisbehaviorUnknownBlockRequest, fmt.Sprintf("Block %v", hashToSend))
+			pp.Disconnect()
+			continue
+		}
+		if pp.isHistoricalBlock(blockchain, blockToSend) {
+			blockBytes, err := blockToSend.ToBytes(false)
+			if err != nil {
+				glog.Errorf("Peer.sendQueuedBlocks: Problem serializing block %v: %v", hashToSend, err)
+				continue
+			}
+			blockSize := MessageSizeOnWire(pp.Params.NetworkType, MsgTypeBlock, uint64(len(blockBytes)))
+			if !bandwidth.WaitForHistoricalUpload(blockSize, pp.quit) {
+				// Disconnecting lets the peer download the blocks from someone else.
+				glog.Infof("Peer.sendQueuedBlocks: Disconnecting peer %v because we reached "+
+					"the daily upload cap and don't serve historical blocks", pp)
+				pp.Disconnect()
+				continue
+			}
+		}
 		pp.AddDeSoMessage(blockToSend, false)
 	}
 }
 
+// HandleGetBlockTxns replies to a peer that is missing some transactions of a compact block we sent it.
+// Like GetBlocks, it's handled here because fetching the block is costly.
+func (pp *Peer) HandleGetBlockTxns(msg *MsgDeSoGetBlockTxns) {
+	blk := pp.srv.blockchain.GetBlock(msg.BlockHash)
+	if blk == nil {
+		glog.Errorf("Peer.HandleGetBlockTxns: Disconnecting peer %v because "+
+			"she asked for txns of a block with hash %v that we don't have", pp, msg.BlockHash)
+		pp.Misbehaving(PeerMisbehaviorUnknownBlockRequest, fmt.Sprintf("Block txns %v", msg.BlockHash))
+		pp.Disconnect()
+		return
+	}
+
+	res := &MsgDeSoBlockTxns{
+		BlockHash: msg.BlockHash,
+	}
+	for _, index := range msg.TxnIndexes {
+		if index >= uint64(len(blk.Txns)) {
+			glog.Errorf("Peer.HandleGetBlockTxns: Disconnecting peer %v because she asked for txn %d "+
+				"of block %v, which only has %d txns", pp, index, msg.BlockHash, len(blk.Txns))
+			pp.Misbehaving(PeerMisbehaviorUnknownBlockRequest, fmt.Sprintf("Block txn %d of %v", index, msg.BlockHash))
+			pp.Disconnect()
+			return
+		}
+		res.Txns = append(res.Txns, blk.Txns[index])
+	}
+	pp.AddDeSoMessage(res, false)
+}
+
+// HandleGetTxnProof replies to a peer asking for a proof that a transaction is in a block. Like GetBlocks,
+// it's handled here because fetching the block is costly. A peer may legitimately ask about a transaction
+// that isn't in the block or a block we don't have, so we reply without a proof rather than disconnect.
+func (pp *Peer) HandleGetTxnProof(msg *MsgDeSoGetTxnProof) {
+	pp.AddDeSoMessage(pp.srv.blockchain.NewTxnProofMessage(msg.BlockHash, msg.TxnHash), false)
+}
+
+// SupportsCompactBlocks returns true if the peer accepts compact blocks.
+func (pp *Peer) SupportsCompactBlocks() bool {
+	pp.PeerInfoMtx.Lock()
+	defer pp.PeerInfoMtx.Unlock()
+
+	return (pp.serviceFlags & SFCompactBlocks) != 0
+}
+
+// SupportsOnionAddrs returns true if the peer can decode onion addresses in addr messages.
+func (pp *Peer) SupportsOnionAddrs() bool {
+	pp.PeerInfoMtx.Lock()
+	defer pp.PeerInfoMtx.Unlock()
+
+	return (pp.serviceFlags & SFOnionAddrs) != 0
+}
+
 // HandleGetSnapshot gets called whenever we receive a GetSnapshot message from a peer. This means
 // a peer is asking us to send him some data from our most recent snapshot. To respond to the peer we
 // will retrieve the chunk from our main and ancestral records db and attach it to the response message.
-// This function is handled within peer's inbound message loop because retrieving a chunk is costly.
+// The request is validated within peer's inbound message loop, while retrieving the chunk, which is
+// costly and may have to wait for the snapshot serving limits, is done by serveSnapshotChunk in the background.
 func (pp *Peer) HandleGetSnapshot(msg *MsgDeSoGetSnapshot) {
-	// Start a timer to measure how much time sending a snapshot takes.
-	pp.srv.timer.Start("Send Snapshot")
-	defer pp.srv.timer.End("Send Snapshot")
-	defer pp.srv.timer.Print("Send Snapshot")
-
 	// Make sure this peer can only request one snapshot chunk at a time.
-	if pp.snapshotChunkRequestInFlight {
+	if !atomic.CompareAndSwapUint32(&pp.snapshotChunkRequestInFlight, 0, 1) {
 		glog.V(1).Infof("Peer.HandleGetSnapshot: Ignoring GetSnapshot from Peer %v"+
 			"because he already requested a GetSnapshot", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "GetSnapshot already in flight")
 		pp.Disconnect()
+		return
 	}
-	pp.snapshotChunkRequestInFlight = true
-	defer func(pp *Peer) { pp.snapshotChunkRequestInFlight = false }(pp)
+	// Once the chunk is handed over to serveSnapshotChunk, it's responsible for clearing the in-flight flag.
+	servingInBackground := false
+	defer func(pp *Peer) {
+		if !servingInBackground {
+			atomic.StoreUint32(&pp.snapshotChunkRequestInFlight, 0)
+		}
+	}(pp)
 
 	// Ignore GetSnapshot requests and disconnect the peer if we're not a hypersync node.
 	if pp.srv.snapshot == nil {
 		glog.Errorf("Peer.HandleGetSnapshot: Ignoring GetSnapshot from Peer %v "+
 			"and disconnecting because node doesn't support HyperSync", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "We're not a hypersync node")
+		pp.Disconnect()
+		return
+	}
+
+	// Same if we've disabled snapshot serving, the peer should've known that from our service flags.
+	if pp.srv.disableSnapshotServing {
+		glog.Errorf("Peer.HandleGetSnapshot: Ignoring GetSnapshot from Peer %v "+
+			"and disconnecting because node has snapshot serving disabled", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "Snapshot serving is disabled")
 		pp.Disconnect()
+		return
 	}
 
 	// Ignore GetSnapshot requests if we're still syncing. We will only serve snapshot chunk when our
@@ -447,11 +633,46 @@ func (pp *Peer) HandleGetSnapshot(msg *MsgDeSoGetSnapshot) {
 	if len(msg.SnapshotStartKey) == 0 || len(msg.GetPrefix()) == 0 {
 		glog.Errorf("Peer.HandleGetSnapshot: Ignoring GetSnapshot from Peer %v "+
 			"because SnapshotStartKey or Prefix are empty", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "Empty start key or prefix")
 		pp.Disconnect()
 		return
 	}
 
-	// FIXME: Any restrictions on how many snapshots a peer can request?
+	// Snapshot chunks are historical data, which we stop serving once we reach an upload cap. Like with
+	// historical blocks, we disconnect the peer once we reach the daily cap so it can sync from someone else.
+	// We check the daily cap before reading the chunk, and wait for the per-second cap once we know its size.
+	if pp.cmgr != nil && pp.cmgr.Bandwidth.DailyUploadCapReached(time.Now()) {
+		glog.Infof("Peer.HandleGetSnapshot: Disconnecting peer %v because we reached "+
+			"the daily upload cap and don't serve snapshots", pp)
+		pp.Disconnect()
+		return
+	}
+
+	// Waiting for the snapshot serving limits would block the message loop, which would in turn delay
+	// the inventory and other messages exchanged with this peer, so the chunk is served in the background.
+	servingInBackground = true
+	go pp.serveSnapshotChunk(pp.srv, pp.cmgr, msg)
+}
+
+// serveSnapshotChunk fetches the snapshot chunk requested with msg, and sends it to the peer once it fits in
+// our bandwidth limits. The server and connection manager are passed in because the peer drops its references
+// to them when it disconnects.
+func (pp *Peer) serveSnapshotChunk(srv *Server, cmgr *ConnectionManager, msg *MsgDeSoGetSnapshot) {
+	defer atomic.StoreUint32(&pp.snapshotChunkRequestInFlight, 0)
+
+	// Start a timer to measure how much time sending a snapshot takes.
+	srv.timer.Start("Send Snapshot")
+	defer srv.timer.End("Send Snapshot")
+	defer srv.timer.Print("Send Snapshot")
+
+	// Wait for our turn to read from the database. The limiter caps the number of snapshot chunks fetched
+	// concurrently, and shares the capacity fairly between the peers that are hypersyncing from us.
+	if limiter := srv.snapshotServingLimiter; limiter != nil {
+		if !limiter.AcquireSlot(pp.ID, pp.quit) {
+			return
+		}
+		defer limiter.ReleaseSlot()
+	}
 
 	// Get the snapshot chunk from the database. This operation can happen concurrently with updates
 	// to the main DB or the ancestral records DB, and we don't want to slow down any of these updates.
@@ -465,18 +686,18 @@ func (pp *Peer) HandleGetSnapshot(msg *MsgDeSoGetSnapshot) {
 	}
 	if isStateKey(msg.GetPrefix()) {
 		snapshotDataMsg.SnapshotChunk, snapshotDataMsg.SnapshotChunkFull, concurrencyFault, err =
-			pp.srv.snapshot.GetSnapshotChunk(pp.srv.blockchain.db, msg.GetPrefix(), msg.SnapshotStartKey)
+			srv.snapshot.GetSnapshotChunk(srv.blockchain.db, msg.GetPrefix(), msg.SnapshotStartKey)
 
-		snapshotDataMsg.SnapshotMetadata = pp.srv.snapshot.CurrentEpochSnapshotMetadata
+		snapshotDataMsg.SnapshotMetadata = srv.snapshot.CurrentEpochSnapshotMetadata
 	} else if isTxIndexKey(msg.GetPrefix()) {
-		if pp.srv.TxIndex != nil && pp.srv.TxIndex.FinishedSyncing() {
+		if srv.TxIndex != nil && srv.TxIndex.FinishedSyncing() {
 			snapshotDataMsg.SnapshotChunk, snapshotDataMsg.SnapshotChunkFull, concurrencyFault, err =
-				pp.srv.TxIndex.TXIndexChain.snapshot.GetSnapshotChunk(
-					pp.srv.TxIndex.TXIndexChain.db, msg.GetPrefix(), msg.SnapshotStartKey)
+				srv.TxIndex.TXIndexChain.snapshot.GetSnapshotChunk(
+					srv.TxIndex.TXIndexChain.db, msg.GetPrefix(), msg.SnapshotStartKey)
 
-			snapshotDataMsg.SnapshotMetadata = pp.srv.TxIndex.TXIndexChain.snapshot.CurrentEpochSnapshotMetadata
+			snapshotDataMsg.SnapshotMetadata = srv.TxIndex.TXIndexChain.snapshot.CurrentEpochSnapshotMetadata
 		} else {
-			glog.V(1).Infof("Peer.HandleGetSnapshot: Received a TxIndex prefix but ignoring "+
+			glog.V(1).Infof("Peer.serveSnapshotChunk: Received a TxIndex prefix but ignoring "+
 				"GetSnapshot from Peer %v because node is still syncing or doesn't support TxIndex", pp)
 			pp.AddDeSoMessage(&MsgDeSoSnapshotData{
 				SnapshotMetadata:  nil,
@@ -488,13 +709,13 @@ func (pp *Peer) HandleGetSnapshot(msg *MsgDeSoGetSnapshot) {
 		}
 	}
 	if err != nil {
-		glog.Errorf("Peer.HandleGetSnapshot: something went wrong during fetching "+
+		glog.Errorf("Peer.serveSnapshotChunk: something went wrong during fetching "+
 			"snapshot chunk for peer (%v), error (%v)", pp, err)
 		return
 	}
 	// When concurrencyFault occurs, we will wait a bit and then enqueue the message again.
 	if concurrencyFault {
-		glog.Errorf("Peer.HandleGetSnapshot: concurrency fault occurred so we enqueue the msg again to peer (%v)", pp)
+		glog.Errorf("Peer.serveSnapshotChunk: concurrency fault occurred so we enqueue the msg again to peer (%v)", pp)
 		go func() {
 			time.Sleep(GetSnapshotTimeout)
 			pp.AddDeSoMessage(msg, true)
@@ -502,14 +723,109 @@ func (pp *Peer) HandleGetSnapshot(msg *MsgDeSoGetSnapshot) {
 		return
 	}
 
+	// Wait until sending the chunk fits in our bandwidth limits. We hold on to the slot in the meantime so that
+	// the number of chunks kept in memory is bounded by the number of concurrent requests.
+	var chunkSize uint64
+	for _, entry := range snapshotDataMsg.SnapshotChunk {
+		chunkSize += uint64(len(entry.Key) + len(entry.Value))
+	}
+	if limiter := srv.snapshotServingLimiter; limiter != nil {
+		if !limiter.WaitForBandwidth(pp.ID, chunkSize, pp.quit) {
+			return
+		}
+	}
+	if cmgr != nil && !cmgr.Bandwidth.WaitForHistoricalUpload(
+		MessageSizeOnWire(pp.Params.NetworkType, MsgTypeSnapshotData, chunkSize), pp.quit) {
+
+		glog.Infof("Peer.serveSnapshotChunk: Disconnecting peer %v because we reached "+
+			"the daily upload cap and don't serve snapshots", pp)
+		pp.Disconnect()
+		return
+	}
+
 	pp.AddDeSoMessage(snapshotDataMsg, false)
 
 	glog.V(2).Infof("Server._handleGetSnapshot: Sending a SnapshotChunk message to peer (%v) "+
 		"with SnapshotHeight (%v) and CurrentEpochChecksumBytes (%v) and Snapshotdata length (%v)", pp,
-		pp.srv.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight,
+		srv.snapshot.CurrentEpochSnapshotMetadata.SnapshotBlockHeight,
 		snapshotDataMsg.SnapshotMetadata, len(snapshotDataMsg.SnapshotChunk))
 }
 
+// HandleGetStateChecksum replies to a GetStateChecksum message with the checksum of the requested range of
+// state records. Similarly to GetSnapshot, computing the checksum is expensive, so we only serve one request
+// at a time and only when our blockchain state is fully current.
+func (pp *Peer) HandleGetStateChecksum(msg *MsgDeSoGetStateChecksum) {
+	if pp.stateChecksumRequestInFlight {
+		glog.V(1).Infof("Peer.HandleGetStateChecksum: Ignoring GetStateChecksum from Peer %v "+
+			"because he already requested a GetStateChecksum", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "GetStateChecksum already in flight")
+		pp.Disconnect()
+		return
+	}
+	pp.stateChecksumRequestInFlight = true
+	defer func(pp *Peer) { pp.stateChecksumRequestInFlight = false }(pp)
+
+	if pp.srv.snapshot == nil {
+		glog.Errorf("Peer.HandleGetStateChecksum: Ignoring GetStateChecksum from Peer %v "+
+			"and disconnecting because node doesn't support HyperSync", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "We're not a hypersync node")
+		pp.Disconnect()
+		return
+	}
+
+	// Only state prefixes are part of the checksum.
+	if len(msg.StartKey) == 0 || !isStateKey(msg.GetPrefix()) ||
+		(len(msg.EndKey) > 0 && bytes.Compare(msg.EndKey, msg.StartKey) <= 0) {
+		glog.Errorf("Peer.HandleGetStateChecksum: Ignoring GetStateChecksum from Peer %v "+
+			"because the range (%v, %v) is invalid", pp, msg.StartKey, msg.EndKey)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "Invalid state checksum range")
+		pp.Disconnect()
+		return
+	}
+
+	// If we're syncing, we reply with our metadata but without a checksum, so that the peer knows it can't
+	// compare the state with us.
+	if pp.srv.blockchain.isSyncing() {
+		glog.V(1).Infof("Peer.HandleGetStateChecksum: Ignoring GetStateChecksum from Peer %v "+
+			"because node is syncing with ChainState (%v)", pp, pp.srv.blockchain.chainState())
+		pp.AddDeSoMessage(&MsgDeSoStateChecksum{
+			SnapshotMetadata: pp.srv.snapshot.CurrentEpochSnapshotMetadata,
+			StartKey:         msg.StartKey,
+			EndKey:           msg.EndKey,
+		}, false)
+		return
+	}
+
+	// Computing the checksum reads as much from the database as serving a snapshot chunk, so it shares the limits.
+	if limiter := pp.srv.snapshotServingLimiter; limiter != nil {
+		if !limiter.AcquireSlot(pp.ID, pp.quit) {
+			return
+		}
+		defer limiter.ReleaseSlot()
+	}
+
+	reply, concurrencyFault, err := pp.srv.snapshot.GetStateChecksum(pp.srv.blockchain.db, msg)
+	if err != nil {
+		glog.Errorf("Peer.HandleGetStateChecksum: something went wrong during computing "+
+			"state checksum for peer (%v), error (%v)", pp, err)
+		return
+	}
+	// When concurrencyFault occurs, we will wait a bit and then enqueue the message again.
+	if concurrencyFault {
+		glog.Errorf("Peer.HandleGetStateChecksum: concurrency fault occurred so we enqueue the msg again to peer (%v)", pp)
+		go func() {
+			time.Sleep(GetSnapshotTimeout)
+			pp.AddDeSoMessage(msg, true)
+		}()
+		return
+	}
+
+	pp.AddDeSoMessage(reply, false)
+
+	glog.V(2).Infof("Peer.HandleGetStateChecksum: Sending a StateChecksum message to peer (%v) "+
+		"with StartKey (%v), EndKey (%v) and NumEntries (%v)", pp, reply.StartKey, reply.EndKey, reply.NumEntries)
+}
+
 func (pp *Peer) cleanupMessageProcessor() {
 	pp.mtxMessageQueue.Lock()
 	defer pp.mtxMessageQueue.Unlock()
@@ -567,12 +883,30 @@ func (pp *Peer) StartDeSoMessageProcessor() {
 					"num hashes %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), len(msg.HashList), pp)
 				pp.HandleGetBlocks(msg)
 
+			} else if msgToProcess.DeSoMessage.GetMsgType() == MsgTypeGetBlockTxns {
+				msg := msgToProcess.DeSoMessage.(*MsgDeSoGetBlockTxns)
+				glog.V(1).Infof("StartDeSoMessageProcessor: RECEIVED message of type %v with "+
+					"num indexes %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), len(msg.TxnIndexes), pp)
+				pp.HandleGetBlockTxns(msg)
+
+			} else if msgToProcess.DeSoMessage.GetMsgType() == MsgTypeGetTxnProof {
+				msg := msgToProcess.DeSoMessage.(*MsgDeSoGetTxnProof)
+				glog.V(1).Infof("StartDeSoMessageProcessor: RECEIVED message of type %v for txn %v "+
+					"in block %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), msg.TxnHash, msg.BlockHash, pp)
+				pp.HandleGetTxnProof(msg)
+
 			} else if msgToProcess.DeSoMessage.GetMsgType() == MsgTypeGetSnapshot {
 				msg := msgToProcess.DeSoMessage.(*MsgDeSoGetSnapshot)
 				glog.V(1).Infof("StartDeSoMessageProcessor: RECEIVED message of type %v with start key %v "+
 					"and prefix %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), msg.SnapshotStartKey, msg.GetPrefix(), pp)
 
 				pp.HandleGetSnapshot(msg)
+			} else if msgToProcess.DeSoMessage.GetMsgType() == MsgTypeGetStateChecksum {
+				msg := msgToProcess.DeSoMessage.(*MsgDeSoGetStateChecksum)
+				glog.V(1).Infof("StartDeSoMessageProcessor: RECEIVED message of type %v with start key %v "+
+					"and end key %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), msg.StartKey, msg.EndKey, pp)
+
+				pp.HandleGetStateChecksum(msg)
 			} else {
 				glog.Errorf("StartDeSoMessageProcessor: ERROR RECEIVED message of "+
 					"type %v from peer %v", msgToProcess.DeSoMessage.GetMsgType(), pp)
@@ -612,7 +946,9 @@ func NewPeer(_conn net.Conn, _isOutbound bool, _netAddr *wire.NetAddress,
 		Params:                 params,
 		MessageChan:            messageChan,
 		requestedBlocks:        make(map[BlockHash]bool),
+		partialCompactBlocks:   make(map[BlockHash]*PartialCompactBlock),
 		syncType:               _syncType,
+		traffic:                NewTrafficCounters(),
 	}
 	if _cmgr != nil {
 		pp.ID = atomic.AddUint64(&_cmgr.peerIndex, 1)
@@ -706,6 +1042,9 @@ func (pp *Peer) HandlePongMsg(msg *MsgDeSoPong) {
 		pp.LastPingMicros /= 1000 // convert to usec.
 		pp.LastPingNonce = 0
 		glog.V(2).Infof("Peer.HandlePongMsg: LastPingMicros(%d) from Peer %v", pp.LastPingMicros, pp)
+		if pp.isOutbound && pp.cmgr != nil {
+			pp.cmgr.PeerRecords.RecordPing(pp.netAddr, pp.LastPingMicros)
+		}
 	}
 }
 
@@ -823,6 +1162,22 @@ func (pp *Peer) _handleOutExpectedResponse(msg DeSoMessage) {
 		})
 	}
 
+	// If we're asking for the missing txns of a compact block, the peer should respond with a BlockTxns.
+	if msg.GetMsgType() == MsgTypeGetBlockTxns {
+		pp._addExpectedResponse(&ExpectedResponse{
+			TimeExpected: time.Now().Add(stallTimeout),
+			MessageType:  MsgTypeBlockTxns,
+		})
+	}
+
+	// If we're asking for a txn proof, the peer should respond with a TxnProof.
+	if msg.GetMsgType() == MsgTypeGetTxnProof {
+		pp._addExpectedResponse(&ExpectedResponse{
+			TimeExpected: time.Now().Add(stallTimeout),
+			MessageType:  MsgTypeTxnProof,
+		})
+	}
+
 	// If we're sending a GetSnapshot message, the peer should respond within a few seconds with a SnapshotData.
 	if msg.GetMsgType() == MsgTypeGetSnapshot {
 		pp._addExpectedResponse(&ExpectedResponse{
@@ -833,11 +1188,17 @@ func (pp *Peer) _handleOutExpectedResponse(msg DeSoMessage) {
 }
 
 func (pp *Peer) _filterAddrMsg(addrMsg *MsgDeSoAddr) *MsgDeSoAddr {
+	supportsOnionAddrs := pp.SupportsOnionAddrs()
+
 	pp.knownAddressesMapLock.Lock()
 	defer pp.knownAddressesMapLock.Unlock()
 
 	filteredAddrMsg := &MsgDeSoAddr{}
 	for _, addr := range addrMsg.AddrList {
+		// Peers that don't know about onion addresses would fail to decode the message.
+		if addr.IsOnion() && !supportsOnionAddrs {
+			continue
+		}
 		if _, hasAddr := pp.knownAddressesMap[addr.StringWithPort(false /*includePort*/)]; hasAddr {
 			continue
 		}
@@ -957,6 +1318,7 @@ func (pp *Peer) _maybeAddBlocksToSend(msg DeSoMessage) error {
 	// If the peer has exceeded the number of blocks she is allowed to request
 	// then disconnect her.
 	if len(pp.blocksToSend) > MaxBlocksInFlight {
+		pp.Misbehaving(PeerMisbehaviorTooManyBlocksRequested, fmt.Sprintf("%d blocks in flight", len(pp.blocksToSend)))
 		pp.Disconnect()
 		return fmt.Errorf("_maybeAddBlocksToSend: Disconnecting peer %v because she requested %d "+
 			"blocks, which is more than the %d blocks allowed "+
@@ -1014,6 +1376,8 @@ func (pp *Peer) _handleInExpectedResponse(rmsg DeSoMessage) error {
 	if msgType == MsgTypeBlock ||
 		msgType == MsgTypeHeaderBundle ||
 		msgType == MsgTypeTransactionBundle ||
+		msgType == MsgTypeBlockTxns ||
+		msgType == MsgTypeTxnProof ||
 		msgType == MsgTypeSnapshotData {
 
 		expectedResponse := pp._removeEarliestExpectedResponse(msgType)
@@ -1046,6 +1410,14 @@ func (pp *Peer) inHandler() {
 		pp.Disconnect()
 	})
 
+	// Each peer gets its own token buckets for the message types we rate limit.
+	var messageLimits *PeerMessageLimits
+	if pp.cmgr != nil {
+		messageLimits = pp.cmgr.MessageLimits
+	}
+	rateLimiter := messageLimits.NewPeerRateLimiter()
+	numRateLimitViolations := 0
+
 out:
 	for {
 		// Read a message and stop the idle timer as soon as the read
@@ -1055,10 +1427,28 @@ out:
 		idleTimer.Stop()
 		if err != nil {
 			glog.Errorf("Peer.inHandler: Can't read message from peer %v: %v", pp, err)
+			if tooLargeErr, ok := errors.Cause(err).(*MessagePayloadTooLargeError); ok {
+				messageLimits.RecordOversizedMessage(tooLargeErr.MsgType)
+				pp.Misbehaving(PeerMisbehaviorOversizedMessage, tooLargeErr.Error())
+			}
 
 			break out
 		}
 
+		// Drop messages over the peer's rate limit for their type, and disconnect peers
+		// that keep sending them.
+		if !rateLimiter.AllowMessage(rmsg.GetMsgType(), time.Now()) {
+			numRateLimitViolations++
+			pp.Misbehaving(PeerMisbehaviorMessageRateExceeded, rmsg.GetMsgType().String())
+			if numRateLimitViolations >= MaxMessageRateViolationsPerPeer {
+				glog.Errorf("Peer.inHandler: Peer %v went over the message rate limits %d times -- "+
+					"disconnecting", pp, numRateLimitViolations)
+				break out
+			}
+			idleTimer.Reset(idleTimeout)
+			continue
+		}
+
 		// Adjust what we expect our Peer to send us based on what we're now
 		// receiving with this message.
 		if err := pp._handleInExpectedResponse(rmsg); err != nil {
@@ -1079,6 +1469,7 @@ out:
 		if IsControlMessage(rmsg.GetMsgType()) {
 			glog.Errorf("Peer.inHandler: Received control message of type %v from "+
 				"Peer %v; this should never happen. Disconnecting the Peer", rmsg.GetMsgType(), pp)
+			pp.Misbehaving(PeerMisbehaviorControlMessage, rmsg.GetMsgType().String())
 			break out
 		}
 
@@ -1098,6 +1489,7 @@ out:
 			// statement, so getting one here is an error.
 
 			glog.Errorf("Peer.inHandler: Already received 'version' from peer %v -- disconnecting", pp)
+			pp.Misbehaving(PeerMisbehaviorDuplicateVersion, "Version after negotiation")
 			break out
 
 		case *MsgDeSoVerack:
@@ -1105,6 +1497,7 @@ out:
 			// statement, so getting one here is an error.
 
 			glog.Errorf("Peer.inHandler: Already received 'verack' from peer %v -- disconnecting", pp)
+			pp.Misbehaving(PeerMisbehaviorDuplicateVersion, "Verack after negotiation")
 			break out
 
 		case *MsgDeSoPing:
@@ -1115,11 +1508,12 @@ out:
 			// Measure the ping time when we receive a pong.
 			pp.HandlePongMsg(msg)
 
-		case *MsgDeSoNewPeer, *MsgDeSoDonePeer, *MsgDeSoQuit:
+		case *MsgDeSoNewPeer, *MsgDeSoDonePeer, *MsgDeSoQuit, *MsgDeSoBlockDownloadTick:
 
 			// We should never receive control messages from a Peer. Disconnect if we do.
 			glog.Errorf("Peer.inHandler: Received control message of type %v from "+
 				"Peer %v which should never happen -- disconnecting", msg.GetMsgType(), pp)
+			pp.Misbehaving(PeerMisbehaviorControlMessage, msg.GetMsgType().String())
 			break out
 
 		default:
@@ -1169,7 +1563,7 @@ func (pp *Peer) Start() {
 func (pp *Peer) IsSyncCandidate() bool {
 	isFullNode := (pp.serviceFlags & SFFullNodeDeprecated) != 0
 	// TODO: This is a bit of a messy way to determine whether the node was run with --hypersync
-	nodeSupportsHypersync := (pp.serviceFlags & SFHyperSync) != 0
+	nodeSupportsHypersync := (pp.serviceFlags&SFHyperSync) != 0 && (pp.serviceFlags&SFNoSnapshotServing) == 0
 	weRequireHypersync := (pp.syncType == NodeSyncTypeHyperSync ||
 		pp.syncType == NodeSyncTypeHyperSyncArchival)
 	if weRequireHypersync && !nodeSupportsHypersync {
@@ -1212,6 +1606,12 @@ func (pp *Peer) WriteDeSoMessage(msg DeSoMessage) error {
 	// Only track the payload sent in the statistics we track.
 	atomic.AddUint64(&pp.bytesSent, uint64(len(payload)))
 	atomic.StoreInt64(&pp.lastSend, time.Now().Unix())
+	// The traffic counters and the upload caps count the whole message.
+	msgSize := MessageSizeOnWire(pp.Params.NetworkType, msg.GetMsgType(), uint64(len(payload)))
+	pp.traffic.RecordSent(msg.GetMsgType(), msgSize)
+	if pp.cmgr != nil {
+		pp.cmgr.Bandwidth.RecordSent(msg.GetMsgType(), msgSize, time.Now())
+	}
 
 	// Useful for debugging.
 	// TODO: This may be too verbose
@@ -1223,24 +1623,70 @@ func (pp *Peer) WriteDeSoMessage(msg DeSoMessage) error {
 }
 
 func (pp *Peer) ReadDeSoMessage() (DeSoMessage, error) {
+	msg, _, err := pp.readDeSoMessageWithPayload()
+	return msg, err
+}
+
+// readDeSoMessageWithPayload is ReadDeSoMessage that also returns the serialized message as we
+// received it.
+func (pp *Peer) readDeSoMessageWithPayload() (DeSoMessage, []byte, error) {
 	msg, payload, err := ReadMessage(pp.Conn, pp.Params.NetworkType)
 	if err != nil {
 		err := errors.Wrapf(err, "ReadDeSoMessage: ")
 		glog.Error(err)
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Only track the payload received in the statistics we track.
 	msgLen := uint64(len(payload))
 	atomic.AddUint64(&pp.bytesReceived, msgLen)
 	atomic.StoreInt64(&pp.lastRecv, time.Now().Unix())
+	msgSize := MessageSizeOnWire(pp.Params.NetworkType, msg.GetMsgType(), msgLen)
+	pp.traffic.RecordReceived(msg.GetMsgType(), msgSize)
+	if pp.cmgr != nil {
+		pp.cmgr.Bandwidth.RecordReceived(msg.GetMsgType(), msgSize)
+	}
 
 	// Useful for debugging.
 	messageSeq := atomic.AddUint64(&pp.totalMessages, 1)
 	glog.V(3).Infof("RECEIVED( seq=%d ) message of type: %v from peer %v: %v",
 		messageSeq, msg.GetMsgType(), pp, msg)
 
-	return msg, nil
+	return msg, payload, nil
+}
+
+// localServiceFlags returns the services we advertise to the peer.
+func (pp *Peer) localServiceFlags() ServiceFlag {
+	// TODO: Right now all peers are full nodes. Later on we'll want to change this,
+	// at which point we'll need to do a little refactoring.
+	// A header-only node doesn't have blocks to serve, so it doesn't claim to be a full node and isn't
+	// chosen to sync from.
+	headerOnly := pp.srv != nil && pp.srv.blockchain.headerOnly
+	var services ServiceFlag
+	if !headerOnly {
+		services = SFFullNodeDeprecated
+	}
+	// Once we reach the daily upload cap we don't serve historical data, so we don't tell new peers
+	// we're a good node to sync from.
+	dailyUploadCapReached := pp.cmgr != nil && pp.cmgr.Bandwidth.DailyUploadCapReached(time.Now())
+	if pp.cmgr != nil && pp.cmgr.HyperSync {
+		if (pp.srv != nil && pp.srv.disableSnapshotServing) || dailyUploadCapReached {
+			services |= SFNoSnapshotServing
+		} else {
+			services |= SFHyperSync
+		}
+	}
+	if pp.srv != nil && pp.srv.blockchain.archivalMode && !dailyUploadCapReached {
+		services |= SFArchivalNode
+	}
+	if pp.cmgr != nil && pp.cmgr.PeerTransport != nil {
+		services |= SFEncryptedTransport
+	}
+	if !headerOnly {
+		services |= SFCompactBlocks | SFTxnProofs
+	}
+	services |= SFOnionAddrs
+	return services
 }
 
 func (pp *Peer) NewVersionMessage(params *DeSoParams) *MsgDeSoVersion {
@@ -1253,15 +1699,7 @@ func (pp *Peer) NewVersionMessage(params *DeSoParams) *MsgDeSoVersion {
 	// unique value.
 	ver.Nonce = uint64(RandInt64(math.MaxInt64))
 	ver.UserAgent = params.UserAgent
-	// TODO: Right now all peers are full nodes. Later on we'll want to change this,
-	// at which point we'll need to do a little refactoring.
-	ver.Services = SFFullNodeDeprecated
-	if pp.cmgr != nil && pp.cmgr.HyperSync {
-		ver.Services |= SFHyperSync
-	}
-	if pp.srv.blockchain.archivalMode {
-		ver.Services |= SFArchivalNode
-	}
+	ver.Services = pp.localServiceFlags()
 
 	// When a node asks you for what height you have, you should reply with
 	// the height of the latest actual block you have. This makes it so that
@@ -1327,6 +1765,12 @@ func (pp *Peer) sendVersion() error {
 		pp.cmgr.sentNonces.Add(pp.VersionNonceSent)
 	}
 
+	versionPayload, err := verMsg.ToBytes(false)
+	if err != nil {
+		return errors.Wrap(err, "sendVersion: ")
+	}
+	pp.versionPayloadSent = versionPayload
+
 	if err := pp.WriteDeSoMessage(verMsg); err != nil {
 		return errors.Wrap(err, "sendVersion: ")
 	}
@@ -1335,7 +1779,7 @@ func (pp *Peer) sendVersion() error {
 }
 
 func (pp *Peer) readVersion() error {
-	msg, err := pp.ReadDeSoMessage()
+	msg, payload, err := pp.readDeSoMessageWithPayload()
 	if err != nil {
 		return errors.Wrap(err, "readVersion: ")
 	}
@@ -1362,6 +1806,7 @@ func (pp *Peer) readVersion() error {
 	}
 	// Save the version nonce so we can include it in our verack message.
 	pp.VersionNonceReceived = msgNonce
+	pp.versionPayloadReceived = payload
 
 	// Set the peer info-related fields.
 	pp.PeerInfoMtx.Lock()
@@ -1435,6 +1880,12 @@ func (pp *Peer) NegotiateVersion(versionNegotiationTimeout time.Duration) error
 		}
 	}
 
+	// If we both support it, encrypt the connection before the veracks so that the
+	// version nonces we echo back are also checked over the encrypted channel.
+	if err := pp.negotiateTransport(versionNegotiationTimeout); err != nil {
+		return errors.Wrapf(err, "negotiateVersion: Problem negotiating transport with Peer %v", pp)
+	}
+
 	// After sending and receiving a compatible version, complete the
 	// negotiation by sending and receiving a verack message.
 	if err := pp.sendVerack(); err != nil {
@@ -1453,6 +1904,58 @@ func (pp *Peer) NegotiateVersion(versionNegotiationTimeout time.Duration) error
 	return nil
 }
 
+// negotiateTransport runs the Noise handshake and swaps the peer's connection for an encrypted
+// one if both we and the peer set SFEncryptedTransport. Peers with a pinned key must
+// encrypt and prove they own that key.
+func (pp *Peer) negotiateTransport(handshakeTimeout time.Duration) error {
+	var transport *PeerTransport
+	if pp.cmgr != nil {
+		transport = pp.cmgr.PeerTransport
+	}
+	if transport == nil || (pp.serviceFlags&SFEncryptedTransport) == 0 {
+		if pp.pinnedTransportKey != nil {
+			return fmt.Errorf("negotiateTransport: Peer has a pinned key but doesn't support encrypted transport")
+		}
+		return nil
+	}
+
+	// Bind the handshake to the version messages we exchanged, in outbound-inbound order.
+	prologue := PeerTransportPrologue(pp.versionPayloadSent, pp.versionPayloadReceived)
+	if !pp.isOutbound {
+		prologue = PeerTransportPrologue(pp.versionPayloadReceived, pp.versionPayloadSent)
+	}
+
+	var encryptedConn net.Conn
+	var remoteStaticKey []byte
+	err := pp.ReadWithTimeout(func() error {
+		var err error
+		encryptedConn, remoteStaticKey, err = transport.Handshake(pp.Conn, pp.isOutbound, prologue)
+		return err
+	}, handshakeTimeout)
+	if err != nil {
+		return errors.Wrapf(err, "negotiateTransport: Problem running handshake")
+	}
+	if pp.pinnedTransportKey != nil && !PeerTransportKeysEqual(pp.pinnedTransportKey, remoteStaticKey) {
+		return fmt.Errorf("negotiateTransport: Peer proved it owns key %v but its pinned key is %v",
+			hex.EncodeToString(remoteStaticKey), hex.EncodeToString(pp.pinnedTransportKey))
+	}
+
+	pp.PeerInfoMtx.Lock()
+	pp.Conn = encryptedConn
+	pp.transportPublicKey = remoteStaticKey
+	pp.PeerInfoMtx.Unlock()
+	return nil
+}
+
+// TransportPublicKey returns the static key the peer proved it owns when the connection
+// was encrypted, or nil if the connection isn't encrypted.
+func (pp *Peer) TransportPublicKey() []byte {
+	pp.PeerInfoMtx.Lock()
+	defer pp.PeerInfoMtx.Unlock()
+
+	return pp.transportPublicKey
+}
+
 // Disconnect closes a peer's network connection.
 func (pp *Peer) Disconnect() {
 	// Only run the logic the first time Disconnect is called.
@@ -1464,8 +1967,12 @@ func (pp *Peer) Disconnect() {
 
 	glog.V(1).Infof("Peer.Disconnect: Running Disconnect for the first time for Peer %v", pp)
 
-	// Close the connection object.
-	pp.Conn.Close()
+	// Close the connection object. negotiateTransport may be swapping it for an encrypted one
+	// concurrently, so read it under the lock.
+	pp.PeerInfoMtx.Lock()
+	conn := pp.Conn
+	pp.PeerInfoMtx.Unlock()
+	conn.Close()
 
 	// Signaling the quit channel allows all the other goroutines to stop running.
 	close(pp.quit)
@@ -1477,6 +1984,40 @@ func (pp *Peer) Disconnect() {
 	}
 }
 
+// Misbehaving records that the peer committed an offense. The offense counts towards the misbehavior score
+// of the peer's IP, and once the score crosses the ban threshold the IP is banned and all of its peers are
+// disconnected. Callers still decide whether to disconnect the peer for the offense itself.
+//
+// Persistent peers and localhost are only scored, never banned automatically, since the operator
+// explicitly asked us to connect to them.
+func (pp *Peer) Misbehaving(misbehavior PeerMisbehavior, reason string) {
+	if pp == nil {
+		return
+	}
+	peerScore := atomic.AddUint32(&pp.misbehaviorScore, misbehavior.Weight())
+	glog.Warningf("Peer.Misbehaving: Peer %v misbehaved (%v), peer score is now (%v): %v",
+		pp, misbehavior, peerScore, reason)
+
+	if pp.cmgr == nil || pp.cmgr.BanManager == nil || pp.netAddr == nil ||
+		pp.isPersistent || pp.netAddr.IP.IsLoopback() {
+		return
+	}
+	ipScore, ban, err := pp.cmgr.BanManager.AddMisbehavior(pp.netAddr.IP, misbehavior, reason)
+	if err != nil {
+		glog.Errorf("Peer.Misbehaving: Problem adding misbehavior for peer %v: %v", pp, err)
+	}
+	if ban == nil {
+		glog.V(1).Infof("Peer.Misbehaving: IP of peer %v has misbehavior score (%v)", pp, ipScore)
+		return
+	}
+	pp.cmgr.DisconnectBannedPeers(pp.netAddr.IP)
+}
+
+// MisbehaviorScore returns the misbehavior score the peer accumulated during this connection.
+func (pp *Peer) MisbehaviorScore() uint32 {
+	return atomic.LoadUint32(&pp.misbehaviorScore)
+}
+
 func (pp *Peer) _logVersionSuccess() {
 	inboundStr := "INBOUND"
 	if pp.isOutbound {
@@ -1486,7 +2027,12 @@ func (pp *Peer) _logVersionSuccess() {
 	if !pp.isPersistent {
 		persistentStr = "NON-PERSISTENT"
 	}
-	logStr := fmt.Sprintf("SUCCESS version negotiation for (%s) (%s) peer (%v).", inboundStr, persistentStr, pp)
+	transportStr := "PLAINTEXT"
+	if transportPublicKey := pp.TransportPublicKey(); transportPublicKey != nil {
+		transportStr = fmt.Sprintf("ENCRYPTED key=%v", hex.EncodeToString(transportPublicKey))
+	}
+	logStr := fmt.Sprintf("SUCCESS version negotiation for (%s) (%s) (%s) peer (%v).",
+		inboundStr, persistentStr, transportStr, pp)
 	glog.V(1).Info(logStr)
 }
 
@@ -0,0 +1,376 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// PeerBansFileName is the file in the data directory where bans are persisted so they survive restarts.
+const PeerBansFileName = "peer_bans.json"
+
+// PeerMisbehavior is a kind of offense a peer can commit. Every offense adds its weight to the misbehavior
+// score of the peer and of the peer's IP. Once the score of an IP crosses the ban threshold, the IP is banned.
+type PeerMisbehavior uint8
+
+const (
+	// The peer sent us a header that failed validation, see IsPeerMisbehaviorHeaderError.
+	PeerMisbehaviorInvalidHeader PeerMisbehavior = iota
+	// The peer sent us headers that don't connect to our header chain, even though every header bundle
+	// is a response to our locator.
+	PeerMisbehaviorUnconnectedHeaders
+	// The peer sent us a block that failed validation.
+	PeerMisbehaviorInvalidBlock
+	// The peer sent us a block at a time we can't have asked for it.
+	PeerMisbehaviorUnrequestedBlock
+	// The peer sent us a snapshot chunk although we aren't hypersyncing from it.
+	PeerMisbehaviorUnrequestedSnapshot
+	// The peer sent us a snapshot chunk that doesn't match our request or its own previous chunks.
+	PeerMisbehaviorInvalidSnapshot
+	// The peer sent us a control message, which should only ever originate from within the node.
+	PeerMisbehaviorControlMessage
+	// The peer sent us another version or verack after the version negotiation.
+	PeerMisbehaviorDuplicateVersion
+	// The peer asked for blocks we don't have, which it would know if it had synced our headers first.
+	PeerMisbehaviorUnknownBlockRequest
+	// The peer asked for more blocks than MaxBlocksInFlight.
+	PeerMisbehaviorTooManyBlocksRequested
+	// The peer asked for snapshot data we don't serve or in a way we don't allow.
+	PeerMisbehaviorInvalidSnapshotRequest
+	// The peer sent us a transaction with a fee below the min fee rate from our version message.
+	PeerMisbehaviorLowFeeTransaction
+	// The peer sent us an addr message with more than MaxAddrsPerAddrMsg addresses.
+	PeerMisbehaviorOversizedAddrMessage
+	// The peer sent us a message over its rate limit for the message type.
+	PeerMisbehaviorMessageRateExceeded
+	// The peer sent us a message with a payload larger than MaxPayloadSizeForMsgType allows.
+	PeerMisbehaviorOversizedMessage
+	// The peer sent us a txn proof that doesn't match the header of its block or isn't signed by a trusted
+	// block producer.
+	PeerMisbehaviorInvalidTxnProof
+)
+
+// peerMisbehaviorWeights is the score each offense adds. Offenses that an honest node can't commit are
+// weighted so that a single one gets the peer banned with the default threshold. Invalid headers and blocks
+// take two, since a header or block can also fail because of a problem with our own state, and offenses an
+// honest node could commit because of a race or a bug on either side take a few repetitions.
+var peerMisbehaviorWeights = map[PeerMisbehavior]uint32{
+	PeerMisbehaviorInvalidHeader:          50,
+	PeerMisbehaviorUnconnectedHeaders:     20,
+	PeerMisbehaviorInvalidBlock:           50,
+	PeerMisbehaviorUnrequestedBlock:       20,
+	PeerMisbehaviorUnrequestedSnapshot:    20,
+	PeerMisbehaviorInvalidSnapshot:        50,
+	PeerMisbehaviorControlMessage:         100,
+	PeerMisbehaviorDuplicateVersion:       50,
+	PeerMisbehaviorUnknownBlockRequest:    10,
+	PeerMisbehaviorTooManyBlocksRequested: 50,
+	PeerMisbehaviorInvalidSnapshotRequest: 10,
+	PeerMisbehaviorLowFeeTransaction:      10,
+	PeerMisbehaviorOversizedAddrMessage:   50,
+	PeerMisbehaviorMessageRateExceeded:    5,
+	PeerMisbehaviorOversizedMessage:       50,
+	PeerMisbehaviorInvalidTxnProof:        50,
+}
+
+func (misbehavior PeerMisbehavior) String() string {
+	switch misbehavior {
+	case PeerMisbehaviorInvalidHeader:
+		return "INVALID_HEADER"
+	case PeerMisbehaviorUnconnectedHeaders:
+		return "UNCONNECTED_HEADERS"
+	case PeerMisbehaviorInvalidBlock:
+		return "INVALID_BLOCK"
+	case PeerMisbehaviorUnrequestedBlock:
+		return "UNREQUESTED_BLOCK"
+	case PeerMisbehaviorUnrequestedSnapshot:
+		return "UNREQUESTED_SNAPSHOT"
+	case PeerMisbehaviorInvalidSnapshot:
+		return "INVALID_SNAPSHOT"
+	case PeerMisbehaviorControlMessage:
+		return "CONTROL_MESSAGE"
+	case PeerMisbehaviorDuplicateVersion:
+		return "DUPLICATE_VERSION"
+	case PeerMisbehaviorUnknownBlockRequest:
+		return "UNKNOWN_BLOCK_REQUEST"
+	case PeerMisbehaviorTooManyBlocksRequested:
+		return "TOO_MANY_BLOCKS_REQUESTED"
+	case PeerMisbehaviorInvalidSnapshotRequest:
+		return "INVALID_SNAPSHOT_REQUEST"
+	case PeerMisbehaviorLowFeeTransaction:
+		return "LOW_FEE_TRANSACTION"
+	case PeerMisbehaviorOversizedAddrMessage:
+		return "OVERSIZED_ADDR_MESSAGE"
+	case PeerMisbehaviorMessageRateExceeded:
+		return "MESSAGE_RATE_EXCEEDED"
+	case PeerMisbehaviorOversizedMessage:
+		return "OVERSIZED_MESSAGE"
+	case PeerMisbehaviorInvalidTxnProof:
+		return "INVALID_TXN_PROOF"
+	default:
+		return fmt.Sprintf("UNRECOGNIZED(%d) - make sure String() is up to date", misbehavior)
+	}
+}
+
+// Weight returns the score the offense adds to the peer's misbehavior score.
+func (misbehavior PeerMisbehavior) Weight() uint32 {
+	return peerMisbehaviorWeights[misbehavior]
+}
+
+// IsPeerMisbehaviorHeaderError returns whether an error from ProcessHeader means the peer sent us a header
+// that an honest node could never have produced. Errors that depend on our clock or on our own state, such
+// as a header that's too far in the future, one we already have, or one below our trusted checkpoint, can
+// be hit by an honest peer, so they shouldn't count towards its misbehavior score.
+func IsPeerMisbehaviorHeaderError(err error) bool {
+	switch errors.Cause(err) {
+	case HeaderErrorNilPrevHash,
+		HeaderErrorInvalidParent,
+		HeaderErrorHeightInvalid,
+		HeaderErrorTimestampTooEarly,
+		HeaderErrorBlockDifficultyAboveTarget,
+		HeaderErrorDifficultyBitsNotConsistentWithTargetDifficultyComputedFromParent:
+		return true
+	default:
+		return false
+	}
+}
+
+// PeerBan is a ban of an IP address, either applied automatically because of misbehavior or manually
+// through the admin interface.
+type PeerBan struct {
+	IP        string
+	BannedAt  time.Time
+	ExpiresAt time.Time
+	Reason    string
+}
+
+type ipMisbehaviorScore struct {
+	score         uint32
+	lastOffenseAt time.Time
+}
+
+// BanManager keeps the misbehavior scores of IPs and the list of banned IPs. Scores only live in memory
+// and are forgotten banDuration after the IP's last offense. Bans are persisted to banFilePath.
+type BanManager struct {
+	// banThreshold is the score at which an IP is banned. Zero disables automatic bans, although bans
+	// can still be added through the admin interface.
+	banThreshold uint32
+	banDuration  time.Duration
+	banFilePath  string
+
+	mtx      sync.Mutex
+	ipScores map[string]*ipMisbehaviorScore
+	bans     map[string]*PeerBan
+}
+
+// NewBanManager creates a BanManager and loads the bans persisted in banFilePath. If banFilePath is empty,
+// bans aren't persisted.
+func NewBanManager(banThreshold uint32, banDuration time.Duration, banFilePath string) (*BanManager, error) {
+	banManager := &BanManager{
+		banThreshold: banThreshold,
+		banDuration:  banDuration,
+		banFilePath:  banFilePath,
+		ipScores:     make(map[string]*ipMisbehaviorScore),
+		bans:         make(map[string]*PeerBan),
+	}
+	if err := banManager.loadBans(); err != nil {
+		return nil, errors.Wrapf(err, "NewBanManager: Problem loading bans")
+	}
+	return banManager, nil
+}
+
+// GetPeerBansFilePath returns the file in the data directory where the BanManager persists bans.
+func GetPeerBansFilePath(dataDir string) string {
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, PeerBansFileName)
+}
+
+func _banKey(ip net.IP) string {
+	return ip.String()
+}
+
+// IsBanned returns true if the IP has a ban that hasn't expired yet.
+func (banManager *BanManager) IsBanned(ip net.IP) bool {
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	ban, exists := banManager.bans[_banKey(ip)]
+	return exists && time.Now().Before(ban.ExpiresAt)
+}
+
+// AddMisbehavior adds the weight of the offense to the score of the IP. If the score crosses the ban threshold,
+// the IP is banned and the ban is returned. Otherwise the returned ban is nil.
+func (banManager *BanManager) AddMisbehavior(ip net.IP, misbehavior PeerMisbehavior, reason string) (
+	_score uint32, _ban *PeerBan, _err error) {
+
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	now := time.Now()
+	banManager._pruneScores(now)
+
+	key := _banKey(ip)
+	ipScore, exists := banManager.ipScores[key]
+	if !exists {
+		ipScore = &ipMisbehaviorScore{}
+		banManager.ipScores[key] = ipScore
+	}
+	ipScore.score += misbehavior.Weight()
+	ipScore.lastOffenseAt = now
+
+	if banManager.banThreshold == 0 || ipScore.score < banManager.banThreshold {
+		return ipScore.score, nil, nil
+	}
+
+	// The IP crossed the threshold. It starts over with a clean score once the ban expires.
+	score := ipScore.score
+	delete(banManager.ipScores, key)
+	ban, err := banManager._ban(key, banManager.banDuration,
+		fmt.Sprintf("Misbehavior score %d reached threshold %d, last offense %v: %v",
+			score, banManager.banThreshold, misbehavior, reason), now)
+	if err != nil {
+		return score, nil, err
+	}
+	return score, ban, nil
+}
+
+// Ban bans the IP for the given duration. A zero duration uses the BanManager's default ban duration.
+func (banManager *BanManager) Ban(ip net.IP, duration time.Duration, reason string) (*PeerBan, error) {
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	if duration == 0 {
+		duration = banManager.banDuration
+	}
+	return banManager._ban(_banKey(ip), duration, reason, time.Now())
+}
+
+func (banManager *BanManager) _ban(key string, duration time.Duration, reason string, now time.Time) (*PeerBan, error) {
+	ban := &PeerBan{
+		IP:        key,
+		BannedAt:  now,
+		ExpiresAt: now.Add(duration),
+		Reason:    reason,
+	}
+	banManager.bans[key] = ban
+	if err := banManager._saveBans(now); err != nil {
+		return nil, errors.Wrapf(err, "BanManager._ban: Problem saving bans")
+	}
+	glog.Infof(CLog(Yellow, fmt.Sprintf("BanManager: Banned IP (%v) until %v: %v", key, ban.ExpiresAt, reason)))
+	return ban, nil
+}
+
+// Unban removes the ban of the IP, and forgets its misbehavior score. It returns false if the IP wasn't banned.
+func (banManager *BanManager) Unban(ip net.IP) (bool, error) {
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	key := _banKey(ip)
+	delete(banManager.ipScores, key)
+	if _, exists := banManager.bans[key]; !exists {
+		return false, nil
+	}
+	delete(banManager.bans, key)
+	if err := banManager._saveBans(time.Now()); err != nil {
+		return true, errors.Wrapf(err, "BanManager.Unban: Problem saving bans")
+	}
+	glog.Infof(CLog(Yellow, fmt.Sprintf("BanManager: Unbanned IP (%v)", key)))
+	return true, nil
+}
+
+// GetBans returns the bans that haven't expired yet, sorted by when they expire.
+func (banManager *BanManager) GetBans() []*PeerBan {
+	banManager.mtx.Lock()
+	defer banManager.mtx.Unlock()
+
+	now := time.Now()
+	bans := []*PeerBan{}
+	for _, ban := range banManager.bans {
+		if now.Before(ban.ExpiresAt) {
+			banCopy := *ban
+			bans = append(bans, &banCopy)
+		}
+	}
+	sort.Slice(bans, func(ii, jj int) bool {
+		return bans[ii].ExpiresAt.Before(bans[jj].ExpiresAt)
+	})
+	return bans
+}
+
+// _pruneScores forgets the scores of IPs that haven't misbehaved for banDuration, so that the score map
+// doesn't grow forever and an occasional honest mistake doesn't eventually add up to a ban.
+func (banManager *BanManager) _pruneScores(now time.Time) {
+	for key, ipScore := range banManager.ipScores {
+		if now.Sub(ipScore.lastOffenseAt) > banManager.banDuration {
+			delete(banManager.ipScores, key)
+		}
+	}
+}
+
+func (banManager *BanManager) loadBans() error {
+	if banManager.banFilePath == "" {
+		return nil
+	}
+	bansBytes, err := ioutil.ReadFile(banManager.banFilePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "BanManager.loadBans: Problem reading (%v)", banManager.banFilePath)
+	}
+	var bans []*PeerBan
+	if err = json.Unmarshal(bansBytes, &bans); err != nil {
+		return errors.Wrapf(err, "BanManager.loadBans: Problem parsing (%v)", banManager.banFilePath)
+	}
+	now := time.Now()
+	for _, ban := range bans {
+		if now.Before(ban.ExpiresAt) {
+			banManager.bans[ban.IP] = ban
+		}
+	}
+	glog.V(1).Infof("BanManager.loadBans: Loaded (%v) bans from (%v)", len(banManager.bans), banManager.banFilePath)
+	return nil
+}
+
+// _saveBans drops the expired bans and writes the remaining ones to the ban file. The file is replaced
+// atomically so a crash can't leave a partially written ban list behind.
+func (banManager *BanManager) _saveBans(now time.Time) error {
+	bans := []*PeerBan{}
+	for key, ban := range banManager.bans {
+		if !now.Before(ban.ExpiresAt) {
+			delete(banManager.bans, key)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	if banManager.banFilePath == "" {
+		return nil
+	}
+	sort.Slice(bans, func(ii, jj int) bool {
+		return bans[ii].IP < bans[jj].IP
+	})
+
+	bansBytes, err := json.MarshalIndent(bans, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "BanManager._saveBans: Problem encoding bans")
+	}
+	tmpFilePath := banManager.banFilePath + ".tmp"
+	if err = ioutil.WriteFile(tmpFilePath, bansBytes, 0600); err != nil {
+		return errors.Wrapf(err, "BanManager._saveBans: Problem writing (%v)", tmpFilePath)
+	}
+	if err = os.Rename(tmpFilePath, banManager.banFilePath); err != nil {
+		return errors.Wrapf(err, "BanManager._saveBans: Problem replacing (%v)", banManager.banFilePath)
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBanManagerMisbehavior(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "peerbans")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	banFilePath := filepath.Join(dir, PeerBansFileName)
+
+	banManager, err := NewBanManager(100, time.Hour, banFilePath)
+	require.NoError(err)
+	ip := net.ParseIP("1.2.3.4")
+	otherIP := net.ParseIP("5.6.7.8")
+
+	// Offenses add up until the score reaches the threshold.
+	for ii := 1; ii < 5; ii++ {
+		score, ban, err := banManager.AddMisbehavior(ip, PeerMisbehaviorUnrequestedBlock, "test")
+		require.NoError(err)
+		require.Nil(ban)
+		require.Equal(uint32(ii)*PeerMisbehaviorUnrequestedBlock.Weight(), score)
+		require.False(banManager.IsBanned(ip))
+	}
+	score, ban, err := banManager.AddMisbehavior(ip, PeerMisbehaviorUnrequestedBlock, "test")
+	require.NoError(err)
+	require.NotNil(ban)
+	require.Equal(uint32(100), score)
+	require.True(banManager.IsBanned(ip))
+	require.False(banManager.IsBanned(otherIP))
+
+	// A single offense an honest node can't commit is enough for a ban.
+	_, ban, err = banManager.AddMisbehavior(otherIP, PeerMisbehaviorControlMessage, "test")
+	require.NoError(err)
+	require.NotNil(ban)
+	require.Len(banManager.GetBans(), 2)
+
+	// Bans survive restarts.
+	reloadedBanManager, err := NewBanManager(100, time.Hour, banFilePath)
+	require.NoError(err)
+	require.True(reloadedBanManager.IsBanned(ip))
+	require.True(reloadedBanManager.IsBanned(otherIP))
+
+	// Unbanned IPs start over with a clean score.
+	wasBanned, err := reloadedBanManager.Unban(ip)
+	require.NoError(err)
+	require.True(wasBanned)
+	require.False(reloadedBanManager.IsBanned(ip))
+	score, ban, err = reloadedBanManager.AddMisbehavior(ip, PeerMisbehaviorUnrequestedBlock, "test")
+	require.NoError(err)
+	require.Nil(ban)
+	require.Equal(PeerMisbehaviorUnrequestedBlock.Weight(), score)
+	wasBanned, err = reloadedBanManager.Unban(ip)
+	require.NoError(err)
+	require.False(wasBanned)
+
+	reloadedBanManager, err = NewBanManager(100, time.Hour, banFilePath)
+	require.NoError(err)
+	require.False(reloadedBanManager.IsBanned(ip))
+	require.True(reloadedBanManager.IsBanned(otherIP))
+}
+
+func TestBanManagerExpiration(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "peerbans")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	banFilePath := filepath.Join(dir, PeerBansFileName)
+
+	// With a zero threshold, misbehavior never leads to a ban but manual bans still work.
+	banManager, err := NewBanManager(0, time.Hour, banFilePath)
+	require.NoError(err)
+	ip := net.ParseIP("1.2.3.4")
+	_, ban, err := banManager.AddMisbehavior(ip, PeerMisbehaviorControlMessage, "test")
+	require.NoError(err)
+	require.Nil(ban)
+	require.False(banManager.IsBanned(ip))
+
+	_, err = banManager.Ban(ip, 50*time.Millisecond, "test")
+	require.NoError(err)
+	require.True(banManager.IsBanned(ip))
+	time.Sleep(100 * time.Millisecond)
+	require.False(banManager.IsBanned(ip))
+	require.Empty(banManager.GetBans())
+
+	// Expired bans aren't loaded again.
+	reloadedBanManager, err := NewBanManager(0, time.Hour, banFilePath)
+	require.NoError(err)
+	require.False(reloadedBanManager.IsBanned(ip))
+	require.Empty(reloadedBanManager.GetBans())
+}
+
+func TestIsPeerMisbehaviorHeaderError(t *testing.T) {
+	require := require.New(t)
+
+	require.True(IsPeerMisbehaviorHeaderError(HeaderErrorNilPrevHash))
+	require.True(IsPeerMisbehaviorHeaderError(
+		errors.Wrapf(HeaderErrorBlockDifficultyAboveTarget, "Target: %v", "test")))
+
+	// Errors an honest peer can run into because of our clock or our own state aren't scored.
+	require.False(IsPeerMisbehaviorHeaderError(HeaderErrorBlockTooFarInTheFuture))
+	require.False(IsPeerMisbehaviorHeaderError(HeaderErrorDuplicateHeader))
+	require.False(IsPeerMisbehaviorHeaderError(HeaderErrorBelowTrustedCheckpoint))
+	require.False(IsPeerMisbehaviorHeaderError(fmt.Errorf("some other error")))
+}
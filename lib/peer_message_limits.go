@@ -0,0 +1,245 @@
+package lib
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// peer_message_limits.go caps what a single peer can make us do. Every message type has a
+// maximum payload size that ReadMessage checks before reading the payload, and some message
+// types that are expensive for us to answer are rate limited per peer with a token bucket.
+// Messages over the rate limit are dropped and count as misbehavior, and a peer that keeps
+// going over the limit is disconnected.
+
+// MaxMessageRateViolationsPerPeer is the number of messages over the rate limit we drop from a
+// peer before disconnecting it.
+const MaxMessageRateViolationsPerPeer = 20
+
+// maxMessagePayloadSizes is the maximum payload size of the message types that have a small,
+// bounded encoding. Other message types are only limited by MaxMessagePayload.
+var maxMessagePayloadSizes = map[MsgType]uint64{
+	MsgTypeVersion:    16 * 1024,
+	MsgTypeVerack:     1024,
+	MsgTypePing:       64,
+	MsgTypePong:       64,
+	MsgTypeGetAddr:    64,
+	MsgTypeMempool:    64,
+	MsgTypeAddr:       MaxAddrsPerAddrMsg * 64,
+	MsgTypeGetHeaders: 64 * 1024,
+	MsgTypeGetBlocks:  (MaxBlocksInFlight + 1) * HashSizeBytes,
+	// The start key of a snapshot chunk is a db key, which is well below this.
+	MsgTypeGetSnapshot: 64 * 1024,
+	MsgTypeGetTxnProof: 2 * HashSizeBytes,
+	// The start and end keys of a state checksum range are db keys, like the start key of a snapshot chunk.
+	MsgTypeGetStateChecksum: 2 * 64 * 1024,
+}
+
+// MaxPayloadSizeForMsgType returns the largest payload we accept for the message type.
+func MaxPayloadSizeForMsgType(msgType MsgType) uint64 {
+	if maxPayloadSize, exists := maxMessagePayloadSizes[msgType]; exists {
+		return maxPayloadSize
+	}
+	return MaxMessagePayload
+}
+
+// MessagePayloadTooLargeError is returned by ReadMessage when a message's payload is larger than
+// MaxPayloadSizeForMsgType allows.
+type MessagePayloadTooLargeError struct {
+	MsgType        MsgType
+	PayloadSize    uint64
+	MaxPayloadSize uint64
+}
+
+func (err *MessagePayloadTooLargeError) Error() string {
+	return fmt.Sprintf("Payload size (%d) bytes for message type (%s) is too large. Should be no "+
+		"larger than (%d) bytes", err.PayloadSize, err.MsgType, err.MaxPayloadSize)
+}
+
+// MessageRateLimit lets a peer send MessagesPerSecond messages of a type on average, with bursts
+// of up to Burst messages.
+type MessageRateLimit struct {
+	MessagesPerSecond float64
+	Burst             uint32
+}
+
+// DefaultMessageRateLimits are the rate limits for message types that cost us a lot to answer.
+// An honest node sends GetAddr and Mempool once per connection, CompactBlock once per new block,
+// and the others as fast as it processes our replies.
+var DefaultMessageRateLimits = map[MsgType]MessageRateLimit{
+	MsgTypeGetAddr:      {MessagesPerSecond: 1.0 / 60, Burst: 5},
+	MsgTypeMempool:      {MessagesPerSecond: 1.0 / 60, Burst: 5},
+	MsgTypeGetSnapshot:  {MessagesPerSecond: 20, Burst: 100},
+	MsgTypeGetHeaders:   {MessagesPerSecond: 10, Burst: 100},
+	MsgTypePing:         {MessagesPerSecond: 1, Burst: 10},
+	MsgTypeGetTxnProof:  {MessagesPerSecond: 10, Burst: 100},
+	MsgTypeCompactBlock: {MessagesPerSecond: 1, Burst: 10},
+	// State checksums are only served to pinned peers, but each one reads a whole key range.
+	MsgTypeGetStateChecksum: {MessagesPerSecond: 5, Burst: 50},
+}
+
+// ParseMessageRateLimits applies rate limits given as "<MSG_TYPE>=<messages per second>:<burst>"
+// on top of DefaultMessageRateLimits, e.g. "GET_ADDR=0.1:10". A rate of zero removes the limit for
+// the message type.
+func ParseMessageRateLimits(rateLimitSpecs []string) (map[MsgType]MessageRateLimit, error) {
+	rateLimits := make(map[MsgType]MessageRateLimit)
+	for msgType, rateLimit := range DefaultMessageRateLimits {
+		rateLimits[msgType] = rateLimit
+	}
+
+	for _, rateLimitSpec := range rateLimitSpecs {
+		specParts := strings.Split(rateLimitSpec, "=")
+		if len(specParts) != 2 {
+			return nil, fmt.Errorf("ParseMessageRateLimits: Rate limit %v should look like "+
+				"<MSG_TYPE>=<messages per second>:<burst>", rateLimitSpec)
+		}
+		msgType, err := ParseMsgType(specParts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "ParseMessageRateLimits: Problem parsing rate limit %v", rateLimitSpec)
+		}
+		limitParts := strings.Split(specParts[1], ":")
+		messagesPerSecond, err := strconv.ParseFloat(limitParts[0], 64)
+		if err != nil || messagesPerSecond < 0 {
+			return nil, fmt.Errorf("ParseMessageRateLimits: Invalid rate in rate limit %v", rateLimitSpec)
+		}
+		if messagesPerSecond == 0 {
+			delete(rateLimits, msgType)
+			continue
+		}
+		if len(limitParts) != 2 {
+			return nil, fmt.Errorf("ParseMessageRateLimits: Rate limit %v is missing a burst", rateLimitSpec)
+		}
+		burst, err := strconv.ParseUint(limitParts[1], 10, 32)
+		if err != nil || burst == 0 {
+			return nil, fmt.Errorf("ParseMessageRateLimits: Invalid burst in rate limit %v", rateLimitSpec)
+		}
+		rateLimits[msgType] = MessageRateLimit{
+			MessagesPerSecond: messagesPerSecond,
+			Burst:             uint32(burst),
+		}
+	}
+	return rateLimits, nil
+}
+
+// ParseMsgType returns the message type with the given name, as returned by MsgType.String().
+func ParseMsgType(msgTypeName string) (MsgType, error) {
+	for ii := uint8(1); NewMessage(MsgType(ii)) != nil; ii++ {
+		if MsgType(ii).String() == strings.ToUpper(msgTypeName) {
+			return MsgType(ii), nil
+		}
+	}
+	return MsgTypeUnset, fmt.Errorf("ParseMsgType: Unknown message type %v", msgTypeName)
+}
+
+// PeerMessageLimits holds the rate limits we apply to every peer, and counts the messages we
+// dropped or rejected so they can be reported as metrics.
+type PeerMessageLimits struct {
+	rateLimits map[MsgType]MessageRateLimit
+
+	mtx            sync.Mutex
+	numRateLimited map[MsgType]uint64
+	numOversized   map[MsgType]uint64
+}
+
+func NewPeerMessageLimits(rateLimits map[MsgType]MessageRateLimit) *PeerMessageLimits {
+	return &PeerMessageLimits{
+		rateLimits:     rateLimits,
+		numRateLimited: make(map[MsgType]uint64),
+		numOversized:   make(map[MsgType]uint64),
+	}
+}
+
+// NewPeerRateLimiter returns a rate limiter with full token buckets for a new peer. It returns nil,
+// which allows every message, if the limits are nil.
+func (limits *PeerMessageLimits) NewPeerRateLimiter() *PeerRateLimiter {
+	if limits == nil {
+		return nil
+	}
+	return &PeerRateLimiter{
+		limits:  limits,
+		buckets: make(map[MsgType]*messageTokenBucket),
+	}
+}
+
+// RecordOversizedMessage counts a message that was rejected for exceeding its maximum payload size.
+func (limits *PeerMessageLimits) RecordOversizedMessage(msgType MsgType) {
+	if limits == nil {
+		return
+	}
+	limits.mtx.Lock()
+	defer limits.mtx.Unlock()
+	limits.numOversized[msgType]++
+}
+
+// TakeStats returns the number of messages of each type we dropped for exceeding the rate limit and
+// rejected for exceeding the payload size since the last call.
+func (limits *PeerMessageLimits) TakeStats() (_numRateLimited map[MsgType]uint64, _numOversized map[MsgType]uint64) {
+	if limits == nil {
+		return nil, nil
+	}
+	limits.mtx.Lock()
+	defer limits.mtx.Unlock()
+
+	numRateLimited, numOversized := limits.numRateLimited, limits.numOversized
+	limits.numRateLimited = make(map[MsgType]uint64)
+	limits.numOversized = make(map[MsgType]uint64)
+	return numRateLimited, numOversized
+}
+
+// PeerRateLimiter keeps a token bucket per rate limited message type for a single peer. It's only
+// used from the peer's inHandler.
+type PeerRateLimiter struct {
+	limits  *PeerMessageLimits
+	buckets map[MsgType]*messageTokenBucket
+}
+
+// AllowMessage takes a token from the message type's bucket and returns false if it was empty.
+func (limiter *PeerRateLimiter) AllowMessage(msgType MsgType, now time.Time) bool {
+	if limiter == nil {
+		return true
+	}
+	rateLimit, isLimited := limiter.limits.rateLimits[msgType]
+	if !isLimited {
+		return true
+	}
+	bucket, exists := limiter.buckets[msgType]
+	if !exists {
+		bucket = &messageTokenBucket{
+			tokens:     float64(rateLimit.Burst),
+			lastRefill: now,
+		}
+		limiter.buckets[msgType] = bucket
+	}
+	if bucket.take(rateLimit, now) {
+		return true
+	}
+
+	limiter.limits.mtx.Lock()
+	limiter.limits.numRateLimited[msgType]++
+	limiter.limits.mtx.Unlock()
+	return false
+}
+
+type messageTokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+func (bucket *messageTokenBucket) take(rateLimit MessageRateLimit, now time.Time) bool {
+	if elapsed := now.Sub(bucket.lastRefill); elapsed > 0 {
+		bucket.tokens += elapsed.Seconds() * rateLimit.MessagesPerSecond
+		if bucket.tokens > float64(rateLimit.Burst) {
+			bucket.tokens = float64(rateLimit.Burst)
+		}
+		bucket.lastRefill = now
+	}
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerRateLimiter(t *testing.T) {
+	require := require.New(t)
+
+	rateLimits, err := ParseMessageRateLimits([]string{"get_addr=2:3", "PING=0"})
+	require.NoError(err)
+	require.Equal(MessageRateLimit{MessagesPerSecond: 2, Burst: 3}, rateLimits[MsgTypeGetAddr])
+	require.NotContains(rateLimits, MsgTypePing)
+	require.Equal(DefaultMessageRateLimits[MsgTypeMempool], rateLimits[MsgTypeMempool])
+	for _, invalidSpec := range []string{"GET_ADDR", "NOT_A_TYPE=1:1", "GET_ADDR=-1:1", "GET_ADDR=1", "GET_ADDR=1:0"} {
+		_, err = ParseMessageRateLimits([]string{invalidSpec})
+		require.Error(err, invalidSpec)
+	}
+
+	limits := NewPeerMessageLimits(rateLimits)
+	limiter := limits.NewPeerRateLimiter()
+	otherLimiter := limits.NewPeerRateLimiter()
+	now := time.Now()
+
+	// A peer can send a burst, and then messages at the rate of the limit.
+	for ii := 0; ii < 3; ii++ {
+		require.True(limiter.AllowMessage(MsgTypeGetAddr, now))
+	}
+	require.False(limiter.AllowMessage(MsgTypeGetAddr, now))
+	require.True(limiter.AllowMessage(MsgTypeGetAddr, now.Add(500*time.Millisecond)))
+	require.False(limiter.AllowMessage(MsgTypeGetAddr, now.Add(500*time.Millisecond)))
+
+	// Other peers and message types without a limit aren't affected.
+	require.True(otherLimiter.AllowMessage(MsgTypeGetAddr, now))
+	for ii := 0; ii < 100; ii++ {
+		require.True(limiter.AllowMessage(MsgTypePing, now))
+	}
+
+	// The bucket doesn't fill past the burst.
+	for ii := 0; ii < 3; ii++ {
+		require.True(limiter.AllowMessage(MsgTypeGetAddr, now.Add(time.Hour)))
+	}
+	require.False(limiter.AllowMessage(MsgTypeGetAddr, now.Add(time.Hour)))
+
+	numRateLimited, numOversized := limits.TakeStats()
+	require.Equal(map[MsgType]uint64{MsgTypeGetAddr: 3}, numRateLimited)
+	require.Empty(numOversized)
+	numRateLimited, _ = limits.TakeStats()
+	require.Empty(numRateLimited)
+
+	// Without limits every message is allowed.
+	var noLimits *PeerMessageLimits
+	require.True(noLimits.NewPeerRateLimiter().AllowMessage(MsgTypeGetAddr, now))
+}
+
+func TestReadMessagePayloadSizeLimit(t *testing.T) {
+	require := require.New(t)
+
+	// A GetAddr claiming a payload larger than its limit is rejected before the payload is read,
+	// even though the payload isn't there.
+	networkType := NetworkType_MAINNET
+	msgBytes := UintToBuf(uint64(networkType))
+	msgBytes = append(msgBytes, UintToBuf(uint64(MsgTypeGetAddr))...)
+	msgBytes = append(msgBytes, make([]byte, 8)...)
+	msgBytes = append(msgBytes, UintToBuf(MaxPayloadSizeForMsgType(MsgTypeGetAddr)+1)...)
+	_, _, err := ReadMessage(bytes.NewReader(msgBytes), networkType)
+	require.Error(err)
+	tooLargeErr, ok := errors.Cause(err).(*MessagePayloadTooLargeError)
+	require.True(ok)
+	require.Equal(MsgTypeGetAddr, tooLargeErr.MsgType)
+
+	// Messages at their limit still go through.
+	getBlocks := &MsgDeSoGetBlocks{}
+	for ii := 0; ii < MaxBlocksInFlight; ii++ {
+		getBlocks.HashList = append(getBlocks.HashList, &BlockHash{byte(ii)})
+	}
+	var buf bytes.Buffer
+	_, err = WriteMessage(&buf, getBlocks, networkType)
+	require.NoError(err)
+	readMsg, _, err := ReadMessage(bytes.NewReader(buf.Bytes()), networkType)
+	require.NoError(err)
+	require.Equal(getBlocks, readMsg)
+}
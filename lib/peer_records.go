@@ -0,0 +1,382 @@
+package lib
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// peer_records.go keeps track of how the outbound peers we connected to behaved, so that we
+// prefer reliable peers when we choose which addresses to connect to. The btcd addrmgr only
+// knows whether an address ever worked, so without this a node that restarts picks its
+// outbound peers mostly at random and often ends up with flaky ones.
+//
+// Records are kept for the addresses we dial and are persisted to the data directory so they
+// survive restarts. Only the ConnectionManager updates them. Diversity is still enforced by
+// the ConnectionManager, which never connects to two outbound peers in the same network
+// group. To make sure a few well-behaved hosts can't take over our outbound connections, it
+// only prefers our records once the good ones span MinPeerRecordGroups network groups, and it
+// keeps NumRandomOutboundPeers of its outbound connections for addresses picked at random
+// from the addrmgr.
+
+const (
+	// PeerRecordsFileName is the file in the data directory where peer records are persisted.
+	PeerRecordsFileName = "peer_records.json"
+
+	// MaxPeerRecords is the number of records we keep. The worst records are dropped first.
+	MaxPeerRecords = 2000
+
+	// PeerRecordsSaveInterval is how often the ConnectionManager persists the records.
+	PeerRecordsSaveInterval = 5 * time.Minute
+
+	// PeerRecordMinGoodConnection is how long an outbound connection needs to last for the
+	// peer not to count as flaky when it disconnects.
+	PeerRecordMinGoodConnection = 5 * time.Minute
+
+	// PeerRecordPingWeight is the weight of a new ping sample in a record's average ping.
+	PeerRecordPingWeight = 0.2
+
+	// NumPeerRecordCandidates and NumAddrMgrCandidates are how many addresses from our records
+	// and from the addrmgr we compare when choosing an outbound peer.
+	NumPeerRecordCandidates = 4
+	NumAddrMgrCandidates    = 4
+
+	// MinPeerRecordGroups is the number of distinct network groups the peers with a good record
+	// need to span before we prefer them over addresses from the addrmgr.
+	MinPeerRecordGroups = 4
+
+	// NumRandomOutboundPeers is the number of non-persistent outbound connections we keep for
+	// addresses picked at random from the addrmgr rather than for peers with a good record.
+	NumRandomOutboundPeers = 2
+)
+
+// PeerRecord is what we know about an address from connecting to it.
+type PeerRecord struct {
+	// Addr is the key of the address in the addrmgr, "<ip>:<port>". For an onion address,
+	// OnionAddr holds the address we need to dial it.
+	Addr      string
+	OnionAddr string `json:",omitempty"`
+
+	LastAttempt time.Time
+	LastSuccess time.Time
+	// NumFailures counts the failures since the last successful connection. A failure is a
+	// dial or version negotiation that didn't work, or a connection that dropped quickly.
+	NumFailures uint32
+
+	// Services and StartingHeight are from the peer's last version message.
+	Services       ServiceFlag
+	StartingHeight uint32
+	// AvgPingMicros is a moving average of the peer's ping times.
+	AvgPingMicros int64
+	// NumBlocksServed counts the blocks the peer sent us that we connected, across connections.
+	NumBlocksServed uint64
+}
+
+// Score rates how much we want to connect to the peer. Peers that worked before score above
+// zero, which is the score of an address we know nothing about, and peers that keep failing
+// score below it. Peers with the services we need, a low ping, and a history of serving us
+// blocks score higher.
+func (record *PeerRecord) Score(requiredServices ServiceFlag) float64 {
+	if record == nil {
+		return 0
+	}
+	score := 0.0
+	if !record.LastSuccess.IsZero() {
+		score += 1
+		if record.Services&requiredServices == requiredServices {
+			score += 1
+		} else {
+			score -= 1
+		}
+	}
+	score -= 0.5 * math.Min(float64(record.NumFailures), 10)
+	if record.AvgPingMicros > 0 {
+		// Up to a point for a ping below 100ms, going down to nothing at a second.
+		pingSeconds := float64(record.AvgPingMicros) / 1e6
+		score += math.Max(0, 1-math.Max(pingSeconds-0.1, 0)/0.9)
+	}
+	score += math.Min(math.Log10(1+float64(record.NumBlocksServed))/4, 1)
+	return score
+}
+
+// PeerRecords holds the records of the addresses we connected to, keyed by addrmgr.NetAddressKey.
+type PeerRecords struct {
+	filePath string
+
+	mtx     sync.Mutex
+	records map[string]*PeerRecord
+}
+
+// NewPeerRecords creates PeerRecords and loads the records persisted in filePath. If filePath is
+// empty, records aren't persisted.
+func NewPeerRecords(filePath string) (*PeerRecords, error) {
+	peerRecords := &PeerRecords{
+		filePath: filePath,
+		records:  make(map[string]*PeerRecord),
+	}
+	if err := peerRecords.load(); err != nil {
+		return nil, errors.Wrapf(err, "NewPeerRecords: Problem loading peer records")
+	}
+	return peerRecords, nil
+}
+
+// GetPeerRecordsFilePath returns the file in the data directory where peer records are persisted.
+func GetPeerRecordsFilePath(dataDir string) string {
+	if dataDir == "" {
+		return ""
+	}
+	return filepath.Join(dataDir, PeerRecordsFileName)
+}
+
+// _getOrCreate returns the record of the address, creating it if it doesn't exist.
+func (peerRecords *PeerRecords) _getOrCreate(na *wire.NetAddress, onionAddr []byte) *PeerRecord {
+	key := addrmgr.NetAddressKey(na)
+	record, exists := peerRecords.records[key]
+	if !exists {
+		record = &PeerRecord{Addr: key}
+		peerRecords.records[key] = record
+	}
+	if onionAddr != nil {
+		record.OnionAddr = OnionAddrToString(onionAddr)
+	}
+	return record
+}
+
+// Get returns a copy of the record of the address, or nil if we don't have one.
+func (peerRecords *PeerRecords) Get(na *wire.NetAddress) *PeerRecord {
+	if peerRecords == nil {
+		return nil
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	record, exists := peerRecords.records[addrmgr.NetAddressKey(na)]
+	if !exists {
+		return nil
+	}
+	recordCopy := *record
+	return &recordCopy
+}
+
+// RecordAttempt notes that we're about to dial the address.
+func (peerRecords *PeerRecords) RecordAttempt(na *wire.NetAddress, onionAddr []byte, now time.Time) {
+	if peerRecords == nil {
+		return
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	peerRecords._getOrCreate(na, onionAddr).LastAttempt = now
+}
+
+// RecordFailure counts a failed connection to the address.
+func (peerRecords *PeerRecords) RecordFailure(na *wire.NetAddress) {
+	if peerRecords == nil {
+		return
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	peerRecords._getOrCreate(na, nil).NumFailures++
+}
+
+// RecordSuccess notes that we connected to the address and negotiated versions with the peer.
+func (peerRecords *PeerRecords) RecordSuccess(na *wire.NetAddress, services ServiceFlag,
+	startingHeight uint32, now time.Time) {
+
+	if peerRecords == nil {
+		return
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	record := peerRecords._getOrCreate(na, nil)
+	record.LastSuccess = now
+	record.NumFailures = 0
+	record.Services = services
+	record.StartingHeight = startingHeight
+}
+
+// RecordPing adds a ping time to the average ping of the address.
+func (peerRecords *PeerRecords) RecordPing(na *wire.NetAddress, pingMicros int64) {
+	if peerRecords == nil || pingMicros <= 0 {
+		return
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	record := peerRecords._getOrCreate(na, nil)
+	if record.AvgPingMicros == 0 {
+		record.AvgPingMicros = pingMicros
+		return
+	}
+	record.AvgPingMicros = int64(PeerRecordPingWeight*float64(pingMicros) +
+		(1-PeerRecordPingWeight)*float64(record.AvgPingMicros))
+}
+
+// RecordBlocksServed counts blocks the peer at the address sent us that we connected.
+func (peerRecords *PeerRecords) RecordBlocksServed(na *wire.NetAddress, numBlocks uint64) {
+	if peerRecords == nil {
+		return
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	peerRecords._getOrCreate(na, nil).NumBlocksServed += numBlocks
+}
+
+// BestRecords returns copies of up to numRecords records with a positive score that aren't
+// excluded, best first.
+func (peerRecords *PeerRecords) BestRecords(numRecords int, requiredServices ServiceFlag,
+	exclude func(record *PeerRecord) bool) []*PeerRecord {
+
+	if peerRecords == nil {
+		return nil
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	var bestRecords []*PeerRecord
+	for _, record := range peerRecords._sortedRecords(requiredServices) {
+		if len(bestRecords) >= numRecords || record.Score(requiredServices) <= 0 {
+			break
+		}
+		if exclude != nil && exclude(record) {
+			continue
+		}
+		recordCopy := *record
+		bestRecords = append(bestRecords, &recordCopy)
+	}
+	return bestRecords
+}
+
+// NumGoodGroups returns the number of distinct network groups of the records with a positive
+// score. Onion addresses are cheap to come by, so they don't count towards it.
+func (peerRecords *PeerRecords) NumGoodGroups(requiredServices ServiceFlag) int {
+	if peerRecords == nil {
+		return 0
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	groupKeys := make(map[string]bool)
+	for _, record := range peerRecords.records {
+		if record.OnionAddr != "" || record.Score(requiredServices) <= 0 {
+			continue
+		}
+		host, _, err := net.SplitHostPort(record.Addr)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		groupKeys[addrmgr.GroupKey(wire.NewNetAddressIPPort(ip, 0, 0))] = true
+	}
+	return len(groupKeys)
+}
+
+// NumGoodRecords returns how many of the addresses, given as addrmgr.NetAddressKey, have a record
+// with a positive score.
+func (peerRecords *PeerRecords) NumGoodRecords(addrKeys []string, requiredServices ServiceFlag) int {
+	if peerRecords == nil {
+		return 0
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	numGoodRecords := 0
+	for _, addrKey := range addrKeys {
+		if record, exists := peerRecords.records[addrKey]; exists && record.Score(requiredServices) > 0 {
+			numGoodRecords++
+		}
+	}
+	return numGoodRecords
+}
+
+// _sortedRecords returns the records from best to worst.
+func (peerRecords *PeerRecords) _sortedRecords(requiredServices ServiceFlag) []*PeerRecord {
+	records := make([]*PeerRecord, 0, len(peerRecords.records))
+	for _, record := range peerRecords.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(ii, jj int) bool {
+		scoreii, scorejj := records[ii].Score(requiredServices), records[jj].Score(requiredServices)
+		if scoreii != scorejj {
+			return scoreii > scorejj
+		}
+		return records[ii].Addr < records[jj].Addr
+	})
+	return records
+}
+
+func (peerRecords *PeerRecords) load() error {
+	if peerRecords.filePath == "" {
+		return nil
+	}
+	recordsBytes, err := ioutil.ReadFile(peerRecords.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "PeerRecords.load: Problem reading (%v)", peerRecords.filePath)
+	}
+	var records []*PeerRecord
+	if err = json.Unmarshal(recordsBytes, &records); err != nil {
+		return errors.Wrapf(err, "PeerRecords.load: Problem parsing (%v)", peerRecords.filePath)
+	}
+	for _, record := range records {
+		peerRecords.records[record.Addr] = record
+	}
+	glog.V(1).Infof("PeerRecords.load: Loaded (%v) peer records from (%v)", len(peerRecords.records),
+		peerRecords.filePath)
+	return nil
+}
+
+// Save drops the worst records past MaxPeerRecords and writes the rest to the records file. The
+// file is replaced atomically so a crash can't leave partially written records behind.
+func (peerRecords *PeerRecords) Save() error {
+	if peerRecords == nil {
+		return nil
+	}
+	peerRecords.mtx.Lock()
+	defer peerRecords.mtx.Unlock()
+
+	// Records are ranked without regard to services so that the ones we keep don't depend on
+	// what we're syncing.
+	records := peerRecords._sortedRecords(0)
+	if len(records) > MaxPeerRecords {
+		for _, record := range records[MaxPeerRecords:] {
+			delete(peerRecords.records, record.Addr)
+		}
+		records = records[:MaxPeerRecords]
+	}
+	if peerRecords.filePath == "" {
+		return nil
+	}
+
+	recordsBytes, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "PeerRecords.Save: Problem encoding peer records")
+	}
+	tmpFilePath := peerRecords.filePath + ".tmp"
+	if err = ioutil.WriteFile(tmpFilePath, recordsBytes, 0600); err != nil {
+		return errors.Wrapf(err, "PeerRecords.Save: Problem writing (%v)", tmpFilePath)
+	}
+	if err = os.Rename(tmpFilePath, peerRecords.filePath); err != nil {
+		return errors.Wrapf(err, "PeerRecords.Save: Problem replacing (%v)", peerRecords.filePath)
+	}
+	return nil
+}
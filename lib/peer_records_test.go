@@ -0,0 +1,139 @@
+package lib
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	chainlib "github.com/btcsuite/btcd/blockchain"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerRecords(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "peerrecords")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	recordsFilePath := filepath.Join(dir, PeerRecordsFileName)
+
+	peerRecords, err := NewPeerRecords(recordsFilePath)
+	require.NoError(err)
+	goodAddr := wire.NewNetAddressIPPort(net.ParseIP("1.2.3.4"), 17000, 0)
+	flakyAddr := wire.NewNetAddressIPPort(net.ParseIP("5.6.7.8"), 17000, 0)
+	archivalServices := SFFullNodeDeprecated | SFArchivalNode
+	now := time.Now()
+
+	// A peer that worked, with a low ping and a history of serving blocks, scores well, and
+	// better if it has the services we need.
+	peerRecords.RecordAttempt(goodAddr, nil, now)
+	peerRecords.RecordSuccess(goodAddr, archivalServices, 1000, now)
+	peerRecords.RecordPing(goodAddr, 50000)
+	peerRecords.RecordPing(goodAddr, 100000)
+	peerRecords.RecordBlocksServed(goodAddr, 100)
+	goodRecord := peerRecords.Get(goodAddr)
+	require.Equal(int64(60000), goodRecord.AvgPingMicros)
+	require.Equal(uint32(1000), goodRecord.StartingHeight)
+	require.Greater(goodRecord.Score(archivalServices), goodRecord.Score(SFHyperSync))
+	require.Greater(goodRecord.Score(archivalServices), 0.0)
+
+	// A peer that keeps failing scores below one we know nothing about, and a success resets
+	// its failures.
+	require.Nil(peerRecords.Get(flakyAddr))
+	require.Equal(0.0, peerRecords.Get(flakyAddr).Score(archivalServices))
+	peerRecords.RecordSuccess(flakyAddr, archivalServices, 1000, now)
+	for ii := 0; ii < 5; ii++ {
+		peerRecords.RecordFailure(flakyAddr)
+	}
+	require.Less(peerRecords.Get(flakyAddr).Score(archivalServices), 0.0)
+	require.Len(peerRecords.BestRecords(10, archivalServices, nil), 1)
+
+	// Records survive restarts, along with onion addresses.
+	onionAddr, err := ParseOnionAddr("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion")
+	require.NoError(err)
+	onionNetAddr := OnionAddrToNetAddr(onionAddr, 17000, 0)
+	peerRecords.RecordAttempt(onionNetAddr, onionAddr, now)
+	require.NoError(peerRecords.Save())
+	reloadedRecords, err := NewPeerRecords(recordsFilePath)
+	require.NoError(err)
+	require.Equal(goodRecord.Score(archivalServices), reloadedRecords.Get(goodAddr).Score(archivalServices))
+	require.Equal(uint32(5), reloadedRecords.Get(flakyAddr).NumFailures)
+	require.Equal(OnionAddrToString(onionAddr), reloadedRecords.Get(onionNetAddr).OnionAddr)
+	require.Equal([]string{addrmgr.NetAddressKey(goodAddr)}, _peerRecordAddrs(
+		reloadedRecords.BestRecords(10, archivalServices, nil)))
+	require.Empty(reloadedRecords.BestRecords(10, archivalServices, func(record *PeerRecord) bool {
+		return record.Addr == addrmgr.NetAddressKey(goodAddr)
+	}))
+
+	// Without a file, records only live in memory.
+	var noRecords *PeerRecords
+	noRecords.RecordFailure(goodAddr)
+	require.Nil(noRecords.Get(goodAddr))
+	require.NoError(noRecords.Save())
+}
+
+func _peerRecordAddrs(records []*PeerRecord) []string {
+	var addrs []string
+	for _, record := range records {
+		addrs = append(addrs, record.Addr)
+	}
+	return addrs
+}
+
+func TestGetRandomAddrPrefersReliablePeers(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "peerrecords")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+
+	addrMgr := addrmgr.New(dir, nil)
+	peerRecords, err := NewPeerRecords("")
+	require.NoError(err)
+	cmgr := NewConnectionManager(&DeSoTestnetParams, addrMgr, nil, nil, chainlib.NewMedianTime(),
+		8, 8, false, false, NodeSyncTypeBlockSync, 0, 0, nil, nil, nil, nil, peerRecords, nil, nil, nil)
+
+	var goodAddrs []*wire.NetAddress
+	for ii := 1; ii <= 7; ii++ {
+		goodAddrs = append(goodAddrs, wire.NewNetAddressIPPort(net.IPv4(byte(ii), 2, 3, 4), 17000, 0))
+	}
+	unknownAddr := wire.NewNetAddressIPPort(net.ParseIP("20.6.7.8"), 17000, 0)
+	flakyAddr := wire.NewNetAddressIPPort(net.ParseIP("30.10.11.12"), 17000, 0)
+	addrMgr.AddAddresses([]*wire.NetAddress{unknownAddr, flakyAddr}, unknownAddr)
+	now := time.Now()
+	for ii := 0; ii < 3; ii++ {
+		peerRecords.RecordFailure(flakyAddr)
+	}
+
+	// Good records are only preferred once they span enough network groups. Until then, an address
+	// we know nothing about beats the flaky one.
+	for _, goodAddr := range goodAddrs[:MinPeerRecordGroups-1] {
+		peerRecords.RecordSuccess(goodAddr, SFFullNodeDeprecated|SFArchivalNode, 1000, now)
+	}
+	require.Equal(addrmgr.NetAddressKey(unknownAddr), addrmgr.NetAddressKey(cmgr.getRandomAddr()))
+
+	// Then a peer that worked before is chosen even though the addrmgr doesn't know about it.
+	for _, goodAddr := range goodAddrs[MinPeerRecordGroups-1:] {
+		peerRecords.RecordSuccess(goodAddr, SFFullNodeDeprecated|SFArchivalNode, 1000, now)
+	}
+	require.Equal(addrmgr.NetAddressKey(goodAddrs[0]), addrmgr.NetAddressKey(cmgr.getRandomAddr()))
+
+	// Some outbound connections are kept for random addresses from the addrmgr, so once we're connected
+	// to enough peers with a good record, we pick a random address even though a good one is available.
+	for _, goodAddr := range goodAddrs[:8-NumRandomOutboundPeers] {
+		cmgr.connectedOutboundAddrs[addrmgr.NetAddressKey(goodAddr)] = true
+		cmgr.addToGroupKey(goodAddr)
+	}
+	require.Equal(addrmgr.NetAddressKey(unknownAddr), addrmgr.NetAddressKey(cmgr.getRandomAddr()))
+
+	// We still never have two outbound peers in the same group.
+	cmgr.addToGroupKey(unknownAddr)
+	require.Equal(addrmgr.NetAddressKey(flakyAddr), addrmgr.NetAddressKey(cmgr.getRandomAddr()))
+	cmgr.addToGroupKey(flakyAddr)
+	require.Nil(cmgr.getRandomAddr())
+}
@@ -0,0 +1,347 @@
+package lib
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/flynn/noise"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Peers that both set SFEncryptedTransport in their version messages run a Noise XX
+// handshake right after exchanging versions, and send everything from the verack onward
+// over the resulting encrypted channel. The handshake uses
+// Noise_XX_25519_ChaChaPoly_SHA256 from the Noise protocol framework
+// (https://noiseprotocol.org/noise.html): both peers prove that they own a long-lived
+// X25519 static key, which lets a node pin the keys of the peers it connects to. The
+// protocol itself is implemented by github.com/flynn/noise.
+
+const (
+	// PeerTransportKeyFileName is the file in the data directory that holds the
+	// node's static transport key, hex-encoded.
+	PeerTransportKeyFileName = "peer_transport_key"
+
+	// PeerTransportKeyLen is the length of both the private and the public
+	// transport keys.
+	PeerTransportKeyLen = curve25519.ScalarSize
+
+	// Every handshake and transport message is prefixed by its length as a
+	// big-endian uint16, which caps messages at 65535 bytes.
+	noiseMaxMessageLen   = math.MaxUint16
+	noiseMaxPlaintextLen = noiseMaxMessageLen - chacha20poly1305.Overhead
+)
+
+// noiseCipherSuite is the 25519_ChaChaPoly_SHA256 part of the protocol name.
+var noiseCipherSuite = noise.NewCipherSuite(noise.DH25519, noise.CipherChaChaPoly, noise.HashSHA256)
+
+// PeerTransportKey is a node's static X25519 key pair. The public key identifies the
+// node to peers that pin it.
+type PeerTransportKey struct {
+	PrivateKey []byte
+	PublicKey  []byte
+}
+
+// NewPeerTransportKey generates a random static key pair.
+func NewPeerTransportKey() (*PeerTransportKey, error) {
+	privateKey := make([]byte, PeerTransportKeyLen)
+	if _, err := rand.Read(privateKey); err != nil {
+		return nil, errors.Wrapf(err, "NewPeerTransportKey: Problem generating private key")
+	}
+	return _peerTransportKeyFromPrivateKey(privateKey)
+}
+
+func _peerTransportKeyFromPrivateKey(privateKey []byte) (*PeerTransportKey, error) {
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "_peerTransportKeyFromPrivateKey: Problem computing public key")
+	}
+	return &PeerTransportKey{
+		PrivateKey: privateKey,
+		PublicKey:  publicKey,
+	}, nil
+}
+
+// GetPeerTransportKeyFilePath returns the location of the static transport key in the
+// data directory.
+func GetPeerTransportKeyFilePath(dataDir string) string {
+	return filepath.Join(dataDir, PeerTransportKeyFileName)
+}
+
+// LoadOrCreatePeerTransportKey reads the static transport key from keyFilePath. If the
+// file doesn't exist, a new key is generated and saved there, so a node keeps the same
+// identity across restarts.
+func LoadOrCreatePeerTransportKey(keyFilePath string) (*PeerTransportKey, error) {
+	keyHex, err := ioutil.ReadFile(keyFilePath)
+	if err == nil {
+		privateKey, err := hex.DecodeString(strings.TrimSpace(string(keyHex)))
+		if err != nil || len(privateKey) != PeerTransportKeyLen {
+			return nil, fmt.Errorf("LoadOrCreatePeerTransportKey: Key file %v should contain a "+
+				"hex-encoded %d-byte private key", keyFilePath, PeerTransportKeyLen)
+		}
+		return _peerTransportKeyFromPrivateKey(privateKey)
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "LoadOrCreatePeerTransportKey: Problem reading key file %v", keyFilePath)
+	}
+
+	key, err := NewPeerTransportKey()
+	if err != nil {
+		return nil, errors.Wrapf(err, "LoadOrCreatePeerTransportKey: ")
+	}
+	if err = os.MkdirAll(filepath.Dir(keyFilePath), 0700); err != nil {
+		return nil, errors.Wrapf(err, "LoadOrCreatePeerTransportKey: Problem creating key directory")
+	}
+	if err = ioutil.WriteFile(keyFilePath, []byte(hex.EncodeToString(key.PrivateKey)), 0600); err != nil {
+		return nil, errors.Wrapf(err, "LoadOrCreatePeerTransportKey: Problem writing key file %v", keyFilePath)
+	}
+	return key, nil
+}
+
+// PeerTransport holds what a node needs to encrypt its peer connections: its own static
+// key and the static keys pinned for some of its --connect-ips.
+type PeerTransport struct {
+	StaticKey *PeerTransportKey
+
+	// pinnedKeys maps the addrmgr.NetAddressKey of a connect-ip to the static
+	// public key the peer at that address must prove it owns.
+	pinnedKeys map[string][]byte
+}
+
+// NewPeerTransport loads the node's static key and resolves the pinned keys. Each
+// pinned key has the form <connect-ip>=<hex public key>, where <connect-ip> is written
+// the same way as in --connect-ips.
+func NewPeerTransport(keyFilePath string, pinnedPeerKeys []string, addrMgr *addrmgr.AddrManager,
+	params *DeSoParams) (*PeerTransport, error) {
+
+	staticKey, err := LoadOrCreatePeerTransportKey(keyFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewPeerTransport: Problem loading static key")
+	}
+
+	pinnedKeys := make(map[string][]byte)
+	for _, pinnedPeerKey := range pinnedPeerKeys {
+		parts := strings.Split(pinnedPeerKey, "=")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("NewPeerTransport: Pinned key %v should have the form "+
+				"<connect-ip>=<hex public key>", pinnedPeerKey)
+		}
+		publicKey, err := ParsePeerTransportPublicKey(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewPeerTransport: Problem parsing pinned key for %v", parts[0])
+		}
+		netAddr, err := IPToNetAddr(parts[0], addrMgr, params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewPeerTransport: Problem resolving pinned peer %v", parts[0])
+		}
+		pinnedKeys[addrmgr.NetAddressKey(netAddr)] = publicKey
+	}
+
+	return &PeerTransport{
+		StaticKey:  staticKey,
+		pinnedKeys: pinnedKeys,
+	}, nil
+}
+
+// ParsePeerTransportPublicKey decodes a hex-encoded static public key.
+func ParsePeerTransportPublicKey(publicKeyHex string) ([]byte, error) {
+	publicKey, err := hex.DecodeString(strings.TrimSpace(publicKeyHex))
+	if err != nil {
+		return nil, errors.Wrapf(err, "ParsePeerTransportPublicKey: Problem decoding hex")
+	}
+	if len(publicKey) != PeerTransportKeyLen {
+		return nil, fmt.Errorf("ParsePeerTransportPublicKey: Public key has length %d but "+
+			"should have length %d", len(publicKey), PeerTransportKeyLen)
+	}
+	return publicKey, nil
+}
+
+// GetPinnedKey returns the static public key pinned for netAddr, or nil if there is none.
+func (transport *PeerTransport) GetPinnedKey(netAddr *wire.NetAddress) []byte {
+	if transport == nil || netAddr == nil {
+		return nil
+	}
+	return transport.pinnedKeys[addrmgr.NetAddressKey(netAddr)]
+}
+
+// PeerTransportPrologue returns the prologue of the handshake between two peers: the hash of
+// both serialized version messages, the outbound peer's first. Since both sides must pass
+// the same prologue, a man in the middle that tampers with either version message, e.g. to
+// change the services a peer advertises, makes the handshake fail.
+func PeerTransportPrologue(outboundVersionPayload []byte, inboundVersionPayload []byte) []byte {
+	hasher := sha256.New()
+	hasher.Write(UintToBuf(uint64(len(outboundVersionPayload))))
+	hasher.Write(outboundVersionPayload)
+	hasher.Write(UintToBuf(uint64(len(inboundVersionPayload))))
+	hasher.Write(inboundVersionPayload)
+	return hasher.Sum(nil)
+}
+
+// Handshake runs the Noise XX handshake over conn. The outbound side of the connection
+// is the initiator. The prologue is mixed into the handshake hash, so both sides must
+// pass the same bytes. On success it returns a connection that encrypts everything
+// written to and read from it, along with the peer's static public key.
+func (transport *PeerTransport) Handshake(conn net.Conn, isInitiator bool, prologue []byte) (
+	_encryptedConn net.Conn, _remoteStaticKey []byte, _err error) {
+
+	hs, err := noise.NewHandshakeState(noise.Config{
+		CipherSuite: noiseCipherSuite,
+		Random:      rand.Reader,
+		Pattern:     noise.HandshakeXX,
+		Initiator:   isInitiator,
+		Prologue:    prologue,
+		StaticKeypair: noise.DHKey{
+			Private: transport.StaticKey.PrivateKey,
+			Public:  transport.StaticKey.PublicKey,
+		},
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Handshake: Problem initializing handshake")
+	}
+
+	// -> e
+	// <- e, ee, s, es
+	// -> s, se
+	// The initiator writes the even messages and the responder the odd ones. Our handshake
+	// messages carry empty payloads. The call that processes the last message returns the
+	// cipher states for sending from the initiator to the responder and back.
+	var initiatorCipher, responderCipher *noise.CipherState
+	for ii := range noise.HandshakeXX.Messages {
+		if (ii%2 == 0) == isInitiator {
+			var message []byte
+			message, initiatorCipher, responderCipher, err = hs.WriteMessage(nil, nil)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Handshake: Problem creating message %d", ii)
+			}
+			if err = _writeNoiseMessage(conn, message); err != nil {
+				return nil, nil, errors.Wrapf(err, "Handshake: Problem writing message %d", ii)
+			}
+		} else {
+			message, err := _readNoiseMessage(conn)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Handshake: Problem reading message %d", ii)
+			}
+			var payload []byte
+			payload, initiatorCipher, responderCipher, err = hs.ReadMessage(nil, message)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "Handshake: Problem processing message %d", ii)
+			}
+			if len(payload) != 0 {
+				return nil, nil, fmt.Errorf("Handshake: Message %d has a payload of %d bytes but "+
+					"should have none", ii, len(payload))
+			}
+		}
+	}
+	if initiatorCipher == nil || responderCipher == nil {
+		return nil, nil, fmt.Errorf("Handshake: Handshake didn't complete")
+	}
+
+	sendCipher, recvCipher := initiatorCipher, responderCipher
+	if !isInitiator {
+		sendCipher, recvCipher = responderCipher, initiatorCipher
+	}
+	return &encryptedConn{
+		Conn:       conn,
+		sendCipher: sendCipher,
+		recvCipher: recvCipher,
+	}, hs.PeerStatic(), nil
+}
+
+func _writeNoiseMessage(ww io.Writer, message []byte) error {
+	if len(message) > noiseMaxMessageLen {
+		return fmt.Errorf("_writeNoiseMessage: Message length %d exceeds max %d",
+			len(message), noiseMaxMessageLen)
+	}
+	frame := make([]byte, 2+len(message))
+	binary.BigEndian.PutUint16(frame, uint16(len(message)))
+	copy(frame[2:], message)
+	_, err := ww.Write(frame)
+	return err
+}
+
+func _readNoiseMessage(rr io.Reader) ([]byte, error) {
+	lenBytes := make([]byte, 2)
+	if _, err := io.ReadFull(rr, lenBytes); err != nil {
+		return nil, err
+	}
+	message := make([]byte, binary.BigEndian.Uint16(lenBytes))
+	if _, err := io.ReadFull(rr, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// encryptedConn wraps a connection on which the Noise handshake completed. Writes are
+// split into frames of at most noiseMaxPlaintextLen bytes, each encrypted on its own.
+type encryptedConn struct {
+	net.Conn
+
+	writeMtx   sync.Mutex
+	sendCipher *noise.CipherState
+
+	readMtx    sync.Mutex
+	recvCipher *noise.CipherState
+	// Decrypted bytes from the last frame that haven't been read yet.
+	readBuf []byte
+}
+
+func (conn *encryptedConn) Write(bb []byte) (int, error) {
+	conn.writeMtx.Lock()
+	defer conn.writeMtx.Unlock()
+
+	written := 0
+	for written < len(bb) {
+		chunkLen := len(bb) - written
+		if chunkLen > noiseMaxPlaintextLen {
+			chunkLen = noiseMaxPlaintextLen
+		}
+		ciphertext, err := conn.sendCipher.Encrypt(nil, nil, bb[written:written+chunkLen])
+		if err != nil {
+			return written, errors.Wrapf(err, "encryptedConn.Write: ")
+		}
+		if err = _writeNoiseMessage(conn.Conn, ciphertext); err != nil {
+			return written, err
+		}
+		written += chunkLen
+	}
+	return written, nil
+}
+
+func (conn *encryptedConn) Read(bb []byte) (int, error) {
+	conn.readMtx.Lock()
+	defer conn.readMtx.Unlock()
+
+	for len(conn.readBuf) == 0 {
+		ciphertext, err := _readNoiseMessage(conn.Conn)
+		if err != nil {
+			return 0, err
+		}
+		conn.readBuf, err = conn.recvCipher.Decrypt(nil, nil, ciphertext)
+		if err != nil {
+			return 0, errors.Wrapf(err, "encryptedConn.Read: ")
+		}
+	}
+	nn := copy(bb, conn.readBuf)
+	conn.readBuf = conn.readBuf[nn:]
+	return nn, nil
+}
+
+// PeerTransportKeysEqual compares two static public keys in constant time.
+func PeerTransportKeysEqual(key1 []byte, key2 []byte) bool {
+	return len(key1) == len(key2) && subtle.ConstantTimeCompare(key1, key2) == 1
+}
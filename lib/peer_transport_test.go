@@ -0,0 +1,163 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type _peerTransportHandshakeResult struct {
+	conn            net.Conn
+	remoteStaticKey []byte
+	err             error
+}
+
+func _runPeerTransportHandshake(initiator *PeerTransport, responder *PeerTransport,
+	initiatorPrologue []byte, responderPrologue []byte) (*_peerTransportHandshakeResult, *_peerTransportHandshakeResult) {
+
+	initiatorConn, responderConn := net.Pipe()
+	responderResultChan := make(chan *_peerTransportHandshakeResult)
+	go func() {
+		conn, remoteStaticKey, err := responder.Handshake(responderConn, false, responderPrologue)
+		if err != nil {
+			// Unblock the initiator if it's still waiting on us.
+			responderConn.Close()
+		}
+		responderResultChan <- &_peerTransportHandshakeResult{conn, remoteStaticKey, err}
+	}()
+	conn, remoteStaticKey, err := initiator.Handshake(initiatorConn, true, initiatorPrologue)
+	if err != nil {
+		initiatorConn.Close()
+	}
+	return &_peerTransportHandshakeResult{conn, remoteStaticKey, err}, <-responderResultChan
+}
+
+func _newTestPeerTransport(t *testing.T) *PeerTransport {
+	key, err := NewPeerTransportKey()
+	require.NoError(t, err)
+	return &PeerTransport{StaticKey: key}
+}
+
+func TestPeerTransportHandshake(t *testing.T) {
+	require := require.New(t)
+
+	initiator := _newTestPeerTransport(t)
+	responder := _newTestPeerTransport(t)
+	prologue := []byte("version nonces")
+	initiatorResult, responderResult := _runPeerTransportHandshake(initiator, responder, prologue, prologue)
+	require.NoError(initiatorResult.err)
+	require.NoError(responderResult.err)
+
+	// Each side learns the static key of the other.
+	require.Equal(responder.StaticKey.PublicKey, initiatorResult.remoteStaticKey)
+	require.Equal(initiator.StaticKey.PublicKey, responderResult.remoteStaticKey)
+
+	// Messages longer than a single frame arrive intact in both directions.
+	message := make([]byte, 3*noiseMaxPlaintextLen+17)
+	for ii := range message {
+		message[ii] = byte(ii)
+	}
+	for _, conns := range [][2]net.Conn{
+		{initiatorResult.conn, responderResult.conn},
+		{responderResult.conn, initiatorResult.conn},
+	} {
+		writeErrChan := make(chan error)
+		go func(conn net.Conn) {
+			_, err := conn.Write(message)
+			writeErrChan <- err
+		}(conns[0])
+		received := make([]byte, len(message))
+		_, err := io.ReadFull(conns[1], received)
+		require.NoError(err)
+		require.NoError(<-writeErrChan)
+		require.True(bytes.Equal(message, received))
+	}
+
+	// Peers that disagree on the prologue fail the handshake.
+	initiatorResult, responderResult = _runPeerTransportHandshake(initiator, responder,
+		[]byte("prologue"), []byte("other prologue"))
+	require.True(initiatorResult.err != nil || responderResult.err != nil)
+}
+
+func TestPeerTransportPrologue(t *testing.T) {
+	require := require.New(t)
+
+	outboundVersion := &MsgDeSoVersion{Version: 1, Services: SFEncryptedTransport, Nonce: 1}
+	inboundVersion := &MsgDeSoVersion{Version: 1, Services: SFEncryptedTransport, Nonce: 2}
+	outboundPayload, err := outboundVersion.ToBytes(false)
+	require.NoError(err)
+	inboundPayload, err := inboundVersion.ToBytes(false)
+	require.NoError(err)
+	prologue := PeerTransportPrologue(outboundPayload, inboundPayload)
+	require.Len(prologue, sha256.Size)
+
+	// The order of the version messages matters.
+	require.NotEqual(prologue, PeerTransportPrologue(inboundPayload, outboundPayload))
+
+	// Changing any field of either version message changes the prologue, not just the nonces.
+	tamperedVersion := *inboundVersion
+	tamperedVersion.Services = SFFullNodeDeprecated
+	tamperedPayload, err := tamperedVersion.ToBytes(false)
+	require.NoError(err)
+	require.NotEqual(prologue, PeerTransportPrologue(outboundPayload, tamperedPayload))
+}
+
+func TestPeerTransportTampering(t *testing.T) {
+	require := require.New(t)
+
+	initiatorResult, responderResult := _runPeerTransportHandshake(
+		_newTestPeerTransport(t), _newTestPeerTransport(t), nil, nil)
+	require.NoError(initiatorResult.err)
+	require.NoError(responderResult.err)
+
+	// The responder rejects a frame whose ciphertext was modified on the way.
+	initiatorConn := initiatorResult.conn.(*encryptedConn)
+	ciphertext, err := initiatorConn.sendCipher.Encrypt(nil, nil, []byte("hello"))
+	require.NoError(err)
+	ciphertext[0] ^= 0x01
+	go _writeNoiseMessage(initiatorConn.Conn, ciphertext)
+	_, err = responderResult.conn.Read(make([]byte, 5))
+	require.Error(err)
+}
+
+func TestLoadOrCreatePeerTransportKey(t *testing.T) {
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "peertransport")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	keyFilePath := GetPeerTransportKeyFilePath(dir)
+
+	// The key is created on first use and the same key is loaded afterwards.
+	key, err := LoadOrCreatePeerTransportKey(keyFilePath)
+	require.NoError(err)
+	require.Len(key.PublicKey, PeerTransportKeyLen)
+	reloadedKey, err := LoadOrCreatePeerTransportKey(keyFilePath)
+	require.NoError(err)
+	require.Equal(key, reloadedKey)
+
+	// Pinned keys are looked up by the address of the connect-ip.
+	params := &DeSoTestnetParams
+	transport, err := NewPeerTransport(keyFilePath, []string{
+		"127.0.0.1:18000=" + hex.EncodeToString(key.PublicKey),
+	}, nil, params)
+	require.NoError(err)
+	netAddr, err := IPToNetAddr("127.0.0.1:18000", nil, params)
+	require.NoError(err)
+	require.Equal(key.PublicKey, transport.GetPinnedKey(netAddr))
+	otherNetAddr, err := IPToNetAddr("127.0.0.1:18001", nil, params)
+	require.NoError(err)
+	require.Nil(transport.GetPinnedKey(otherNetAddr))
+
+	_, err = NewPeerTransport(keyFilePath, []string{"127.0.0.1:18000=abcd"}, nil, params)
+	require.Error(err)
+	_, err = NewPeerTransport(keyFilePath, []string{"127.0.0.1:18000"}, nil, params)
+	require.Error(err)
+}
@@ -6,11 +6,11 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/go-pg/pg/v10"
 	"github.com/go-pg/pg/v10/orm"
 	"github.com/golang/glog"
 	"github.com/holiman/uint256"
+	"math"
 	"net/url"
 	"regexp"
 	"strings"
@@ -493,6 +493,38 @@ func (profile *PGProfile) Empty() bool {
 	return profile.Username == ""
 }
 
+func (profile *PGProfile) NewProfileEntry() *ProfileEntry {
+	daoCoinsInCirculationNanos := uint256.NewInt()
+	if profile.DAOCoinCoinsInCirculationNanos != "" {
+		var err error
+		daoCoinsInCirculationNanos, err = uint256.FromHex(profile.DAOCoinCoinsInCirculationNanos)
+		if err != nil {
+			daoCoinsInCirculationNanos = uint256.NewInt()
+		}
+	}
+	return &ProfileEntry{
+		PublicKey:   profile.PublicKey.ToBytes(),
+		Username:    []byte(profile.Username),
+		Description: []byte(profile.Description),
+		ProfilePic:  profile.ProfilePic,
+		CreatorCoinEntry: CoinEntry{
+			CreatorBasisPoints:      profile.CreatorBasisPoints,
+			DeSoLockedNanos:         profile.DeSoLockedNanos,
+			NumberOfHolders:         profile.NumberOfHolders,
+			CoinsInCirculationNanos: *uint256.NewInt().SetUint64(profile.CoinsInCirculationNanos),
+			CoinWatermarkNanos:      profile.CoinWatermarkNanos,
+			MintingDisabled:         profile.MintingDisabled,
+		},
+		DAOCoinEntry: CoinEntry{
+			NumberOfHolders:           profile.DAOCoinNumberOfHolders,
+			CoinsInCirculationNanos:   *daoCoinsInCirculationNanos,
+			MintingDisabled:           profile.DAOCoinMintingDisabled,
+			TransferRestrictionStatus: profile.DAOCoinTransferRestrictionStatus,
+		},
+		ExtraData: profile.ExtraData,
+	}
+}
+
 type PGPost struct {
 	tableName struct{} `pg:"pg_posts"`
 
@@ -621,6 +653,15 @@ type PGDiamond struct {
 	DiamondLevel    uint8
 }
 
+func (diamond *PGDiamond) NewDiamondEntry() *DiamondEntry {
+	return &DiamondEntry{
+		SenderPKID:      diamond.SenderPKID,
+		ReceiverPKID:    diamond.ReceiverPKID,
+		DiamondPostHash: diamond.DiamondPostHash,
+		DiamondLevel:    int64(diamond.DiamondLevel),
+	}
+}
+
 // TODO: This doesn't need to be a table. Just add sender to PGMetadataPrivateMessage?
 // The only reason we might not want to do this is if we end up pruning Metadata tables.
 type PGMessage struct {
@@ -631,7 +672,12 @@ type PGMessage struct {
 	RecipientPublicKey []byte
 	EncryptedText      []byte
 	TimestampNanos     uint64
-	// TODO: Version
+
+	Version                        uint8         `pg:",use_zero"`
+	SenderMessagingPublicKey       *PublicKey    `pg:",type:bytea"`
+	SenderMessagingGroupKeyName    *GroupKeyName `pg:",type:bytea"`
+	RecipientMessagingPublicKey    *PublicKey    `pg:",type:bytea"`
+	RecipientMessagingGroupKeyName *GroupKeyName `pg:",type:bytea"`
 
 	ExtraData map[string][]byte
 
@@ -639,6 +685,38 @@ type PGMessage struct {
 	isDeleted bool
 }
 
+func (message *PGMessage) NewMessageEntry() *MessageEntry {
+	messageEntry := &MessageEntry{
+		SenderPublicKey:                NewPublicKey(message.SenderPublicKey),
+		RecipientPublicKey:             NewPublicKey(message.RecipientPublicKey),
+		EncryptedText:                  message.EncryptedText,
+		TstampNanos:                    message.TimestampNanos,
+		Version:                        message.Version,
+		SenderMessagingPublicKey:       message.SenderMessagingPublicKey,
+		SenderMessagingGroupKeyName:    message.SenderMessagingGroupKeyName,
+		RecipientMessagingPublicKey:    message.RecipientMessagingPublicKey,
+		RecipientMessagingGroupKeyName: message.RecipientMessagingGroupKeyName,
+		ExtraData:                      message.ExtraData,
+	}
+
+	// We don't know the messaging keys of messages stored before they were tracked, so we fall back to the
+	// owner keys, which is what V1 and V2 messages use.
+	if messageEntry.SenderMessagingPublicKey == nil {
+		messageEntry.SenderMessagingPublicKey = NewPublicKey(message.SenderPublicKey)
+	}
+	if messageEntry.SenderMessagingGroupKeyName == nil {
+		messageEntry.SenderMessagingGroupKeyName = BaseGroupKeyName()
+	}
+	if messageEntry.RecipientMessagingPublicKey == nil {
+		messageEntry.RecipientMessagingPublicKey = NewPublicKey(message.RecipientPublicKey)
+	}
+	if messageEntry.RecipientMessagingGroupKeyName == nil {
+		messageEntry.RecipientMessagingGroupKeyName = BaseGroupKeyName()
+	}
+
+	return messageEntry
+}
+
 type PGMessagingGroup struct {
 	tableName struct{} `pg:"pg_messaging_group"`
 
@@ -1223,7 +1301,10 @@ func (postgres *Postgres) InsertTransactionsTx(tx *pg.Tx, desoTxns []*MsgDeSoTxn
 			})
 
 			//get related NFT
-			pgBidNft := postgres.GetNFT(txMeta.NFTPostHash, txMeta.SerialNumber)
+			pgBidNft, err := postgres.GetNFT(txMeta.NFTPostHash, txMeta.SerialNumber)
+			if err != nil {
+				return err
+			}
 
 			//check if is buy now and BidAmountNanos > then BuyNowPriceNanos
 			if pgBidNft != nil && pgBidNft.IsBuyNow && txMeta.BidAmountNanos >= pgBidNft.BuyNowPriceNanos {
@@ -1231,7 +1312,10 @@ func (postgres *Postgres) InsertTransactionsTx(tx *pg.Tx, desoTxns []*MsgDeSoTxn
 				// Initialize bidderPKID with naive NewPKID from txn.PublicKey
 				bidderPKID := NewPKID(txn.PublicKey)
 				//get related profile
-				pgBidProfile := postgres.GetProfileForPublicKey(txn.PublicKey)
+				pgBidProfile, err := postgres.GetProfileForPublicKey(txn.PublicKey)
+				if err != nil {
+					return err
+				}
 				// If profile is non-nil, update bidderPKID to value from pgBidProfile
 				if pgBidProfile != nil {
 					bidderPKID = pgBidProfile.PKID
@@ -2217,7 +2301,7 @@ func (postgres *Postgres) flushDAOCoinLimitOrders(tx *pg.Tx, view *UtxoView) err
 // UTXOS
 //
 
-func (postgres *Postgres) GetUtxoEntryForUtxoKey(utxoKey *UtxoKey) *UtxoEntry {
+func (postgres *Postgres) GetUtxoEntryForUtxoKey(utxoKey *UtxoKey) (*UtxoEntry, error) {
 	utxo := &PGTransactionOutput{
 		OutputHash:  &utxoKey.TxID,
 		OutputIndex: utxoKey.Index,
@@ -2225,18 +2309,21 @@ func (postgres *Postgres) GetUtxoEntryForUtxoKey(utxoKey *UtxoKey) *UtxoEntry {
 	}
 
 	err := postgres.db.Model(utxo).WherePK().Select()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
-	return utxo.NewUtxoEntry()
+	return utxo.NewUtxoEntry(), nil
 }
 
-func (postgres *Postgres) GetUtxoEntriesForPublicKey(publicKey []byte) []*UtxoEntry {
+func (postgres *Postgres) GetUtxoEntriesForPublicKey(publicKey []byte) ([]*UtxoEntry, error) {
 	var transactionOutputs []*PGTransactionOutput
 	err := postgres.db.Model(&transactionOutputs).Where("public_key = ?", publicKey).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
 
 	var utxoEntries []*UtxoEntry
@@ -2244,7 +2331,7 @@ func (postgres *Postgres) GetUtxoEntriesForPublicKey(publicKey []byte) []*UtxoEn
 		utxoEntries = append(utxoEntries, utxo.NewUtxoEntry())
 	}
 
-	return utxoEntries
+	return utxoEntries, nil
 }
 
 func (postgres *Postgres) GetOutputs(outputs []*PGTransactionOutput) []*PGTransactionOutput {
@@ -2255,45 +2342,54 @@ func (postgres *Postgres) GetOutputs(outputs []*PGTransactionOutput) []*PGTransa
 	return outputs
 }
 
-func (postgres *Postgres) GetBlockRewardsForPublicKey(publicKey *PublicKey, startHeight uint32, endHeight uint32) []*PGTransactionOutput {
+func (postgres *Postgres) GetBlockRewardsForPublicKey(publicKey *PublicKey, startHeight uint32, endHeight uint32) ([]*PGTransactionOutput, error) {
 	var transactionOutputs []*PGTransactionOutput
 	err := postgres.db.Model(&transactionOutputs).Where("public_key = ?", publicKey).
 		Where("height > ?", startHeight).Where("height < ?", endHeight).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return transactionOutputs
+	return transactionOutputs, nil
 }
 
 //
 // Profiles
 //
 
-func (postgres *Postgres) GetProfileForUsername(nonLowercaseUsername string) *PGProfile {
+func (postgres *Postgres) GetProfileForUsername(nonLowercaseUsername string) (*PGProfile, error) {
 	var profile PGProfile
 	err := postgres.db.Model(&profile).Where("LOWER(username) = ?", strings.ToLower(nonLowercaseUsername)).First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &profile
+	return &profile, nil
 }
 
-func (postgres *Postgres) GetProfileForPublicKey(publicKey []byte) *PGProfile {
+func (postgres *Postgres) GetProfileForPublicKey(publicKey []byte) (*PGProfile, error) {
 	var profile PGProfile
 	err := postgres.db.Model(&profile).Where("public_key = ?", publicKey).First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &profile
+	return &profile, nil
 }
 
-func (postgres *Postgres) GetProfile(pkid PKID) *PGProfile {
+func (postgres *Postgres) GetProfile(pkid PKID) (*PGProfile, error) {
 	var profile PGProfile
 	err := postgres.db.Model(&profile).Where("pkid = ?", pkid).First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &profile
+	return &profile, nil
 }
 
 func (postgres *Postgres) GetProfilesForPublicKeys(publicKeys []*PublicKey) []*PGProfile {
@@ -2305,6 +2401,17 @@ func (postgres *Postgres) GetProfilesForPublicKeys(publicKeys []*PublicKey) []*P
 	return profiles
 }
 
+// GetAllProfiles returns all the profiles that have a username. Profiles without a username only
+// record the PKID of a public key after a swap identity.
+func (postgres *Postgres) GetAllProfiles() ([]*PGProfile, error) {
+	var profiles []*PGProfile
+	err := postgres.db.Model(&profiles).Where("username != ''").OrderExpr("deso_locked_nanos DESC").Select()
+	if err != nil {
+		return nil, err
+	}
+	return profiles, nil
+}
+
 func (postgres *Postgres) GetProfilesByCoinValue(startLockedNanos uint64, limit int) []*PGProfile {
 	var profiles []*PGProfile
 	err := postgres.db.Model(&profiles).Where("deso_locked_nanos < ?", startLockedNanos).
@@ -2338,13 +2445,16 @@ func (postgres *Postgres) GetProfilesForUsername(usernames []string) []*PGProfil
 // Posts
 //
 
-func (postgres *Postgres) GetPost(postHash *BlockHash) *PGPost {
+func (postgres *Postgres) GetPost(postHash *BlockHash) (*PGPost, error) {
 	var post PGPost
 	err := postgres.db.Model(&post).Where("post_hash = ?", postHash).First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &post
+	return &post, nil
 }
 
 func (postgres *Postgres) GetPosts(posts []*PGPost) []*PGPost {
@@ -2367,20 +2477,71 @@ func (postgres *Postgres) GetPostsForPublicKey(publicKey []byte, startTime uint6
 	return posts
 }
 
+// GetPostKeysForPublicKeyByTimestamp returns the core posts made by a public key, newest first, with only
+// their hash and timestamp set. If startPost is set, only the posts that come after it in this order are returned.
+func (postgres *Postgres) GetPostKeysForPublicKeyByTimestamp(publicKey []byte, startPost *PostEntry,
+	limit int) ([]*PGPost, error) {
+
+	var posts []*PGPost
+	query := postgres.db.Model(&posts).Column("post_hash", "timestamp").
+		Where("poster_public_key = ?", publicKey).Where("parent_post_hash IS NULL")
+	if startPost != nil {
+		query = query.Where("(timestamp, post_hash) < (?, ?)", startPost.TimestampNanos, startPost.PostHash)
+	}
+	err := query.OrderExpr("timestamp DESC, post_hash DESC").Limit(limit).Select()
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (postgres *Postgres) GetAllCorePosts() ([]*PGPost, error) {
+	var posts []*PGPost
+	err := postgres.db.Model(&posts).Where("parent_post_hash IS NULL").
+		OrderExpr("timestamp ASC, post_hash ASC").Select()
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (postgres *Postgres) GetReposts(repostedPostHash *BlockHash, quotedRepost bool) ([]*PGPost, error) {
+	var posts []*PGPost
+	err := postgres.db.Model(&posts).Where("reposted_post_hash = ?", repostedPostHash).
+		Where("quoted_repost = ?", quotedRepost).Select()
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
 //
 // Comments
 //
 
 // TODO: Pagination
-func (postgres *Postgres) GetComments(parentPostHash *BlockHash) []*PGPost {
+func (postgres *Postgres) GetComments(parentPostHash *BlockHash) ([]*PGPost, error) {
 	var posts []*PGPost
 	err := postgres.db.Model(&posts).Where("parent_post_hash = ?", parentPostHash).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return posts
+	return posts, nil
 }
 
+func (postgres *Postgres) GetAllComments() ([]*PGPost, error) {
+	var posts []*PGPost
+	err := postgres.db.Model(&posts).Where("parent_post_hash IS NOT NULL").Select()
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+//
+// Messages
+//
+
 func (postgres *Postgres) GetMessage(messageHash *BlockHash) *PGMessage {
 	var message PGMessage
 	err := postgres.db.Model(&message).Where("message_hash = ?", messageHash).First()
@@ -2390,20 +2551,76 @@ func (postgres *Postgres) GetMessage(messageHash *BlockHash) *PGMessage {
 	return &message
 }
 
+// GetMessageForMessagingKey returns the message sent or received by a messaging public key at the
+// given timestamp. Messages stored before the messaging keys were tracked don't have them, and are
+// matched by their owner keys instead, like in NewMessageEntry.
+func (postgres *Postgres) GetMessageForMessagingKey(messagingPublicKey []byte, timestampNanos uint64) (*PGMessage, error) {
+	var message PGMessage
+	err := postgres.db.Model(&message).Where("timestamp_nanos = ?", timestampNanos).
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.Where("sender_messaging_public_key = ?", messagingPublicKey).
+				WhereOr("sender_messaging_public_key IS NULL AND sender_public_key = ?", messagingPublicKey).
+				WhereOr("recipient_messaging_public_key = ?", messagingPublicKey).
+				WhereOr("recipient_messaging_public_key IS NULL AND recipient_public_key = ?", messagingPublicKey), nil
+		}).First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+// GetMessagesForMessagingKeys returns up to limit of the latest messages sent or received by any of the
+// messaging public keys, newest first. Like in GetMessageForMessagingKey, messages without messaging keys
+// are matched by their owner keys.
+func (postgres *Postgres) GetMessagesForMessagingKeys(messagingPublicKeys []*PublicKey, limit uint64) ([]*PGMessage, error) {
+	var messages []*PGMessage
+	if len(messagingPublicKeys) == 0 || limit == 0 {
+		return messages, nil
+	}
+	query := postgres.db.Model(&messages).
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.WhereIn("sender_messaging_public_key IN (?)", messagingPublicKeys).
+				WhereOrGroup(func(q *orm.Query) (*orm.Query, error) {
+					return q.Where("sender_messaging_public_key IS NULL").
+						WhereIn("sender_public_key IN (?)", messagingPublicKeys), nil
+				}).
+				WhereOrGroup(func(q *orm.Query) (*orm.Query, error) {
+					return q.WhereIn("recipient_messaging_public_key IN (?)", messagingPublicKeys), nil
+				}).
+				WhereOrGroup(func(q *orm.Query) (*orm.Query, error) {
+					return q.Where("recipient_messaging_public_key IS NULL").
+						WhereIn("recipient_public_key IN (?)", messagingPublicKeys), nil
+				}), nil
+		}).OrderExpr("timestamp_nanos DESC")
+	if limit < math.MaxInt32 {
+		query = query.Limit(int(limit))
+	}
+	if err := query.Select(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
 //
 // LIKES
 //
 
-func (postgres *Postgres) GetLike(likerPublicKey []byte, likedPostHash *BlockHash) *PGLike {
+func (postgres *Postgres) GetLike(likerPublicKey []byte, likedPostHash *BlockHash) (*PGLike, error) {
 	like := PGLike{
 		LikerPublicKey: likerPublicKey,
 		LikedPostHash:  likedPostHash,
 	}
 	err := postgres.db.Model(&like).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &like
+	return &like, nil
 }
 
 func (postgres *Postgres) GetLikes(likes []*PGLike) []*PGLike {
@@ -2414,29 +2631,32 @@ func (postgres *Postgres) GetLikes(likes []*PGLike) []*PGLike {
 	return likes
 }
 
-func (postgres *Postgres) GetLikesForPost(postHash *BlockHash) []*PGLike {
+func (postgres *Postgres) GetLikesForPost(postHash *BlockHash) ([]*PGLike, error) {
 	var likes []*PGLike
 	err := postgres.db.Model(&likes).Where("liked_post_hash = ?", postHash).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return likes
+	return likes, nil
 }
 
 //
 // Follows
 //
 
-func (postgres *Postgres) GetFollow(followerPkid *PKID, followedPkid *PKID) *PGFollow {
+func (postgres *Postgres) GetFollow(followerPkid *PKID, followedPkid *PKID) (*PGFollow, error) {
 	follow := PGFollow{
 		FollowerPKID: followerPkid,
 		FollowedPKID: followedPkid,
 	}
 	err := postgres.db.Model(&follow).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &follow
+	return &follow, nil
 }
 
 func (postgres *Postgres) GetFollows(follows []*PGFollow) []*PGFollow {
@@ -2447,35 +2667,75 @@ func (postgres *Postgres) GetFollows(follows []*PGFollow) []*PGFollow {
 	return follows
 }
 
-func (postgres *Postgres) GetFollowing(pkid *PKID) []*PGFollow {
+func (postgres *Postgres) GetFollowing(pkid *PKID) ([]*PGFollow, error) {
 	var follows []*PGFollow
 	err := postgres.db.Model(&follows).Where("follower_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return follows
+	return follows, nil
 }
 
-func (postgres *Postgres) GetFollowers(pkid *PKID) []*PGFollow {
+func (postgres *Postgres) GetFollowers(pkid *PKID) ([]*PGFollow, error) {
 	var follows []*PGFollow
 	err := postgres.db.Model(&follows).Where("followed_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return follows
+	return follows, nil
 }
 
-func (postgres *Postgres) GetDiamond(senderPkid *PKID, receiverPkid *PKID, postHash *BlockHash) *PGDiamond {
+func (postgres *Postgres) GetDiamond(senderPkid *PKID, receiverPkid *PKID, postHash *BlockHash) (*PGDiamond, error) {
 	diamond := PGDiamond{
 		SenderPKID:      senderPkid,
 		ReceiverPKID:    receiverPkid,
 		DiamondPostHash: postHash,
 	}
 	err := postgres.db.Model(&diamond).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
+	}
+	return &diamond, nil
+}
+
+func (postgres *Postgres) GetDiamondsForReceiver(receiverPkid *PKID) ([]*PGDiamond, error) {
+	var diamonds []*PGDiamond
+	err := postgres.db.Model(&diamonds).Where("receiver_pkid = ?", receiverPkid).Select()
+	if err != nil {
+		return nil, err
+	}
+	return diamonds, nil
+}
+
+func (postgres *Postgres) GetDiamondsForSender(senderPkid *PKID) ([]*PGDiamond, error) {
+	var diamonds []*PGDiamond
+	err := postgres.db.Model(&diamonds).Where("sender_pkid = ?", senderPkid).Select()
+	if err != nil {
+		return nil, err
+	}
+	return diamonds, nil
+}
+
+func (postgres *Postgres) GetDiamondsForSenderToReceiver(senderPkid *PKID, receiverPkid *PKID) ([]*PGDiamond, error) {
+	var diamonds []*PGDiamond
+	err := postgres.db.Model(&diamonds).Where("sender_pkid = ?", senderPkid).
+		Where("receiver_pkid = ?", receiverPkid).Select()
+	if err != nil {
+		return nil, err
+	}
+	return diamonds, nil
+}
+
+func (postgres *Postgres) GetDiamondsForPost(postHash *BlockHash) ([]*PGDiamond, error) {
+	var diamonds []*PGDiamond
+	err := postgres.db.Model(&diamonds).Where("diamond_post_hash = ?", postHash).Select()
+	if err != nil {
+		return nil, err
 	}
-	return &diamond
+	return diamonds, nil
 }
 
 //
@@ -2490,34 +2750,37 @@ func (postgres *Postgres) GetCreatorCoinBalances(balances []*PGCreatorCoinBalanc
 	return balances
 }
 
-func (postgres *Postgres) GetCreatorCoinBalance(holderPkid *PKID, creatorPkid *PKID) *PGCreatorCoinBalance {
+func (postgres *Postgres) GetCreatorCoinBalance(holderPkid *PKID, creatorPkid *PKID) (*PGCreatorCoinBalance, error) {
 	balance := PGCreatorCoinBalance{
 		HolderPKID:  holderPkid,
 		CreatorPKID: creatorPkid,
 	}
 	err := postgres.db.Model(&balance).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &balance
+	return &balance, nil
 }
 
-func (postgres *Postgres) GetCreatorCoinHoldings(pkid *PKID) []*PGCreatorCoinBalance {
+func (postgres *Postgres) GetCreatorCoinHoldings(pkid *PKID) ([]*PGCreatorCoinBalance, error) {
 	var holdings []*PGCreatorCoinBalance
 	err := postgres.db.Model(&holdings).Where("holder_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return holdings
+	return holdings, nil
 }
 
-func (postgres *Postgres) GetCreatorCoinHolders(pkid *PKID) []*PGCreatorCoinBalance {
+func (postgres *Postgres) GetCreatorCoinHolders(pkid *PKID) ([]*PGCreatorCoinBalance, error) {
 	var holdings []*PGCreatorCoinBalance
 	err := postgres.db.Model(&holdings).Where("creator_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return holdings
+	return holdings, nil
 }
 
 //
@@ -2532,34 +2795,37 @@ func (postgres *Postgres) GetDAOCoinBalances(balances []*PGDAOCoinBalance) []*PG
 	return balances
 }
 
-func (postgres *Postgres) GetDAOCoinBalance(holderPkid *PKID, creatorPkid *PKID) *PGDAOCoinBalance {
+func (postgres *Postgres) GetDAOCoinBalance(holderPkid *PKID, creatorPkid *PKID) (*PGDAOCoinBalance, error) {
 	balance := PGDAOCoinBalance{
 		HolderPKID:  holderPkid,
 		CreatorPKID: creatorPkid,
 	}
 	err := postgres.db.Model(&balance).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &balance
+	return &balance, nil
 }
 
-func (postgres *Postgres) GetDAOCoinHoldings(pkid *PKID) []*PGDAOCoinBalance {
+func (postgres *Postgres) GetDAOCoinHoldings(pkid *PKID) ([]*PGDAOCoinBalance, error) {
 	var holdings []*PGDAOCoinBalance
 	err := postgres.db.Model(&holdings).Where("holder_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return holdings
+	return holdings, nil
 }
 
-func (postgres *Postgres) GetDAOCoinHolders(pkid *PKID) []*PGDAOCoinBalance {
+func (postgres *Postgres) GetDAOCoinHolders(pkid *PKID) ([]*PGDAOCoinBalance, error) {
 	var holdings []*PGDAOCoinBalance
 	err := postgres.db.Model(&holdings).Where("creator_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return holdings
+	return holdings, nil
 }
 
 //
@@ -2702,113 +2968,150 @@ func (postgres *Postgres) GetMatchingDAOCoinLimitOrders(inputOrder *DAOCoinLimit
 // NFTS
 //
 
-func (postgres *Postgres) GetNFT(nftPostHash *BlockHash, serialNumber uint64) *PGNFT {
+func (postgres *Postgres) GetNFT(nftPostHash *BlockHash, serialNumber uint64) (*PGNFT, error) {
 	nft := PGNFT{
 		NFTPostHash:  nftPostHash,
 		SerialNumber: serialNumber,
 	}
 	err := postgres.db.Model(&nft).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &nft
+	return &nft, nil
 }
 
-func (postgres *Postgres) GetNFTsForPostHash(nftPostHash *BlockHash) []*PGNFT {
+func (postgres *Postgres) GetNFTsForPostHash(nftPostHash *BlockHash) ([]*PGNFT, error) {
 	var nfts []*PGNFT
 	err := postgres.db.Model(&nfts).Where("nft_post_hash = ?", nftPostHash).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return nfts
+	return nfts, nil
 }
 
-func (postgres *Postgres) GetNFTsForPKID(pkid *PKID) []*PGNFT {
+func (postgres *Postgres) GetNFTsForPKID(pkid *PKID) ([]*PGNFT, error) {
 	var nfts []*PGNFT
 	err := postgres.db.Model(&nfts).Where("owner_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return nfts
+	return nfts, nil
 }
 
-func (postgres *Postgres) GetNFTBidsForPKID(pkid *PKID) []*PGNFTBid {
+func (postgres *Postgres) GetNFTBidsForPKID(pkid *PKID) ([]*PGNFTBid, error) {
 	var nftBids []*PGNFTBid
 	err := postgres.db.Model(&nftBids).Where("bidder_pkid = ?", pkid).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return nftBids
+	return nftBids, nil
 }
 
-func (postgres *Postgres) GetNFTBidsForSerial(nftPostHash *BlockHash, serialNumber uint64) []*PGNFTBid {
+func (postgres *Postgres) GetNFTBidsForSerial(nftPostHash *BlockHash, serialNumber uint64) ([]*PGNFTBid, error) {
 	var nftBids []*PGNFTBid
 	err := postgres.db.Model(&nftBids).Where("nft_post_hash = ?", nftPostHash).
 		Where("serial_number = ?", serialNumber).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return nftBids
+	return nftBids, nil
 }
 
-func (postgres *Postgres) GetNFTBid(nftPostHash *BlockHash, bidderPKID *PKID, serialNumber uint64) *PGNFTBid {
+// GetNFTBidsPaginated returns up to limit bids on a serial number ordered by bid amount and bidder, starting
+// at startBid if it's set. The bids are returned from the highest to the lowest if reverse is set.
+func (postgres *Postgres) GetNFTBidsPaginated(nftPostHash *BlockHash, serialNumber uint64, startBid *NFTBidEntry,
+	limit int, reverse bool) ([]*PGNFTBid, error) {
+
+	var nftBids []*PGNFTBid
+	query := postgres.db.Model(&nftBids).Where("nft_post_hash = ?", nftPostHash).
+		Where("serial_number = ?", serialNumber)
+	order := "bid_amount_nanos ASC, bidder_pkid ASC"
+	comparison := ">="
+	if reverse {
+		order = "bid_amount_nanos DESC, bidder_pkid DESC"
+		comparison = "<="
+	}
+	if startBid != nil {
+		query = query.Where("(bid_amount_nanos, bidder_pkid) "+comparison+" (?, ?)",
+			startBid.BidAmountNanos, startBid.BidderPKID)
+	}
+	err := query.OrderExpr(order).Limit(limit).Select()
+	if err != nil {
+		return nil, err
+	}
+	return nftBids, nil
+}
+
+func (postgres *Postgres) GetNFTBid(nftPostHash *BlockHash, bidderPKID *PKID, serialNumber uint64) (*PGNFTBid, error) {
 	bid := PGNFTBid{
 		NFTPostHash:  nftPostHash,
 		BidderPKID:   bidderPKID,
 		SerialNumber: serialNumber,
 	}
 	err := postgres.db.Model(&bid).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &bid
+	return &bid, nil
 }
 
 //
 // Derived Keys
 //
 
-func (postgres *Postgres) GetDerivedKey(ownerPublicKey *PublicKey, derivedPublicKey *PublicKey) *PGDerivedKey {
+func (postgres *Postgres) GetDerivedKey(ownerPublicKey *PublicKey, derivedPublicKey *PublicKey) (*PGDerivedKey, error) {
 	key := PGDerivedKey{
 		OwnerPublicKey:   *ownerPublicKey,
 		DerivedPublicKey: *derivedPublicKey,
 	}
 	err := postgres.db.Model(&key).WherePK().First()
+	if err == pg.ErrNoRows {
+		return nil, nil
+	}
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return &key
+	return &key, nil
 }
 
-func (postgres *Postgres) GetAllDerivedKeysForOwner(ownerPublicKey *PublicKey) []*PGDerivedKey {
+func (postgres *Postgres) GetAllDerivedKeysForOwner(ownerPublicKey *PublicKey) ([]*PGDerivedKey, error) {
 	var keys []*PGDerivedKey
 	err := postgres.db.Model(&keys).Where("owner_public_key = ?", *ownerPublicKey).Select()
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	return keys
+	return keys, nil
 }
 
 //
 // Balances
 //
 
-func (postgres *Postgres) GetBalance(publicKey *PublicKey) uint64 {
+func (postgres *Postgres) GetBalance(publicKey *PublicKey) (uint64, error) {
 	balance := PGBalance{
 		PublicKey: publicKey,
 	}
 	err := postgres.db.Model(&balance).WherePK().First()
+	if err == pg.ErrNoRows {
+		return 0, nil
+	}
 	if err != nil {
-		return 0
+		return 0, err
 	}
-	return balance.BalanceNanos
+	return balance.BalanceNanos, nil
 }
 
 //
 // PGChain Init
 //
 
-func (postgres *Postgres) InitGenesisBlock(params *DeSoParams, db *badger.DB) error {
+func (postgres *Postgres) InitGenesisBlock(params *DeSoParams, db KVStore) error {
 	// Construct a node for the genesis block. Its height is zero and it has no parents. Its difficulty should be
 	// set to the initial difficulty specified in the parameters and it should be assumed to be
 	// valid and stored by the end of this function.
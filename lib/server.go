@@ -20,9 +20,9 @@ import (
 	"github.com/btcsuite/btcd/wire"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/deso-protocol/go-deadlock"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
 	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
 )
 
 // ServerMessage is the core data structure processed by the Server in its main
@@ -53,12 +53,29 @@ type Server struct {
 	cmgr          *ConnectionManager
 	blockchain    *Blockchain
 	snapshot      *Snapshot
+	stateScrubber *StateScrubber
 	mempool       *DeSoMempool
 	miner         *DeSoMiner
 	blockProducer *DeSoBlockProducer
 	eventManager  *EventManager
 	TxIndex       *TXIndex
 
+	// DBMaintainer runs value log GC on the node's dbs. It's nil if db maintenance is disabled.
+	DBMaintainer *DBMaintainer
+
+	// snapshotServingLimiter throttles serving snapshot chunks to peers. It's nil if no limits are set.
+	snapshotServingLimiter *SnapshotServingLimiter
+	// disableSnapshotServing is set if we refuse to serve snapshot chunks altogether.
+	disableSnapshotServing bool
+	// stateChecksumPeerKeys are the transport keys of the peers we answer GetStateChecksum
+	// requests from. Nobody else can make us compute state checksums.
+	stateChecksumPeerKeys [][]byte
+
+	// onDemandRequests holds the requests waiting for blocks and txn proofs fetched with FetchBlock
+	// and FetchTxnProof.
+	onDemandRequestsLock deadlock.Mutex
+	onDemandRequests     map[onDemandRequestKey][]chan DeSoMessage
+
 	// All messages received from peers get sent from the ConnectionManager to the
 	// Server through this channel.
 	//
@@ -87,13 +104,11 @@ type Server struct {
 	// The waitGroup is used to manage the cleanup of the Server.
 	waitGroup deadlock.WaitGroup
 
-	// During initial block download, we request headers and blocks from a single
-	// peer. Note: These fields should only be accessed from the messageHandler thread.
-	//
-	// TODO: This could be much faster if we were to download blocks in parallel
-	// rather than from a single peer but it won't be a problem until later, at which
-	// point we can make the optimization.
-	SyncPeer *Peer
+	// During initial block download, we request headers from a single peer. Blocks
+	// are requested from all of our sync peers through the blockDownloader. Note: These
+	// fields should only be accessed from the messageHandler thread.
+	SyncPeer        *Peer
+	blockDownloader *BlockDownloadScheduler
 
 	// If we're syncing state using hypersync, we'll keep track of the progress using HyperSyncProgress.
 	// It stores information about all the prefixes that we're fetching. The way that HyperSyncProgress
@@ -123,6 +138,12 @@ type Server struct {
 	addrsToBroadcastLock deadlock.RWMutex
 	addrsToBroadcastt    map[string][]*SingleAddr
 
+	// torController keeps our onion service published, and onionAddr is the onion address we
+	// relay to peers. Both are nil if we don't run an onion service.
+	torController *TorController
+	onionAddr     []byte
+	onionPort     uint16
+
 	// When set to true, we disable the ConnectionManager
 	DisableNetworking bool
 
@@ -274,6 +295,9 @@ const (
 	NodeSyncTypeBlockSync         = "blocksync"
 	NodeSyncTypeHyperSyncArchival = "hypersync-archival"
 	NodeSyncTypeHyperSync         = "hypersync"
+	// A header-only node only syncs and validates headers, and fetches blocks on demand to check
+	// that transactions are included in them. See header_only.go.
+	NodeSyncTypeHeaderOnly = "header-only"
 )
 
 func IsNodeArchival(syncType NodeSyncType) bool {
@@ -284,17 +308,23 @@ func IsNodeArchival(syncType NodeSyncType) bool {
 
 func NodeCanHypersyncState(syncType NodeSyncType) bool {
 	// We can hypersync state from another node in all cases except
-	// where block sync is required.
-	return syncType != NodeSyncTypeBlockSync
+	// where block sync is required, or where we don't keep state at all.
+	return syncType != NodeSyncTypeBlockSync && syncType != NodeSyncTypeHeaderOnly
 }
 
 func ValidateHyperSyncFlags(isHypersync bool, syncType NodeSyncType) {
 	if syncType != NodeSyncTypeAny &&
 		syncType != NodeSyncTypeBlockSync &&
 		syncType != NodeSyncTypeHyperSyncArchival &&
-		syncType != NodeSyncTypeHyperSync {
+		syncType != NodeSyncTypeHyperSync &&
+		syncType != NodeSyncTypeHeaderOnly {
 		glog.Fatalf("Unrecognized --sync-type flag %v", syncType)
 	}
+	if isHypersync &&
+		syncType == NodeSyncTypeHeaderOnly {
+		glog.Fatal("Cannot set --sync-type=header-only with --hypersync=true since a header-only node " +
+			"doesn't keep any state")
+	}
 	if !isHypersync &&
 		syncType == NodeSyncTypeHyperSync {
 		glog.Fatal("Cannot set --sync-type=hypersync without also setting --hypersync=true")
@@ -334,17 +364,30 @@ func NewServer(
 	_listeners []net.Listener,
 	_desoAddrMgr *addrmgr.AddrManager,
 	_connectIps []string,
-	_db *badger.DB,
+	_db KVStore,
 	postgres *Postgres,
 	_targetOutboundPeers uint32,
 	_maxInboundPeers uint32,
 	_minerPublicKeys []string,
 	_numMiningThreads uint64,
 	_limitOneInboundConnectionPerIP bool,
+	_peerBanThreshold uint32,
+	_peerBanDurationMinutes uint64,
+	_encryptPeerConnections bool,
+	_pinnedPeerKeys []string,
+	_stateChecksumPeerKeys []string,
+	_peerMessageRateLimits []string,
+	_proxyAddr string,
+	_torOnionServicePort uint16,
+	_torControlAddr string,
+	_torControlPassword string,
+	_maxUploadBytesPerDay uint64,
+	_maxUploadBytesPerSecond uint64,
 	_hyperSync bool,
 	_syncType NodeSyncType,
 	_maxSyncBlockHeight uint32,
 	_disableEncoderMigrations bool,
+	_trustedCheckpoint *TrustedCheckpoint,
 	_rateLimitFeerateNanosPerKB uint64,
 	_minFeeRateNanosPerKB uint64,
 	_stallTimeoutSeconds uint64,
@@ -353,6 +396,16 @@ func NewServer(
 	_blockCypherAPIKey string,
 	_runReadOnlyUtxoViewUpdater bool,
 	_snapshotBlockHeightPeriod uint64,
+	_stateScrubberIntervalMinutes uint64,
+	_stateScrubberMaxEntriesPerSecond uint64,
+	_disableSnapshotServing bool,
+	_snapshotServingMaxConcurrentRequests uint64,
+	_snapshotServingPeerBytesPerSecond uint64,
+	_snapshotServingGlobalBytesPerSecond uint64,
+	_dbMaintenanceIntervalMinutes uint64,
+	_dbMaintenanceIdleSeconds uint64,
+	_valueLogGCDiscardRatio float64,
+	_dbMaintenanceFlatten bool,
 	_dataDir string,
 	_mempoolDumpDir string,
 	_disableNetworking bool,
@@ -399,12 +452,83 @@ func NewServer(
 	// we can keep a consistent clock.
 	timesource := chainlib.NewMedianTime()
 
+	// Load the banned peers. Bans are kept in the data directory so that they survive restarts.
+	banManager, err := NewBanManager(_peerBanThreshold, time.Duration(_peerBanDurationMinutes)*time.Minute,
+		GetPeerBansFilePath(_dataDir))
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem initializing ban manager"), false
+	}
+
+	// Load our static transport key if we encrypt peer connections. The key is kept in the data
+	// directory so that peers who pinned it keep recognizing us after a restart.
+	var peerTransport *PeerTransport
+	if _encryptPeerConnections {
+		peerTransport, err = NewPeerTransport(GetPeerTransportKeyFilePath(_dataDir), _pinnedPeerKeys,
+			_desoAddrMgr, _params)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewServer: Problem initializing peer transport"), false
+		}
+		glog.Infof("NewServer: Encrypting peer connections with transport public key %v",
+			hex.EncodeToString(peerTransport.StaticKey.PublicKey))
+	}
+
+	// Only peers that prove they own one of these keys over an encrypted connection can request
+	// state checksums.
+	var stateChecksumPeerKeys [][]byte
+	if len(_stateChecksumPeerKeys) > 0 && peerTransport == nil {
+		return nil, fmt.Errorf("NewServer: State checksum peer keys require encrypted peer connections"), false
+	}
+	for _, publicKeyHex := range _stateChecksumPeerKeys {
+		publicKey, err := ParsePeerTransportPublicKey(publicKeyHex)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewServer: Problem parsing state checksum peer key"), false
+		}
+		stateChecksumPeerKeys = append(stateChecksumPeerKeys, publicKey)
+	}
+
+	// Rate limits for the messages peers send us are our defaults, adjusted by the flags.
+	messageRateLimits, err := ParseMessageRateLimits(_peerMessageRateLimits)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem parsing peer message rate limits"), false
+	}
+
+	// Load what we know about the peers we connected to before. The records are kept in the data
+	// directory so that we can reconnect to reliable peers after a restart.
+	peerRecords, err := NewPeerRecords(GetPeerRecordsFilePath(_dataDir))
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem loading peer records"), false
+	}
+
+	// All of our outbound connections go through the proxy if we have one, and we can only
+	// connect to onion addresses through it.
+	var proxyDialer proxy.ContextDialer
+	if _proxyAddr != "" {
+		proxyDialer, err = NewProxyDialer(_proxyAddr, _params.DialTimeout)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewServer: Problem setting up proxy"), false
+		}
+		glog.Infof("NewServer: Connecting to peers through proxy %v", _proxyAddr)
+	}
+
+	// Publish our onion service if we have one. Tor forwards connections to it to our protocol
+	// port on localhost, where we accept them like any other inbound connection.
+	if _torOnionServicePort != 0 && !_disableNetworking {
+		srv.torController, srv.onionAddr, err = StartOnionService(_torControlAddr, _torControlPassword,
+			GetTorOnionServiceKeyFilePath(_dataDir), _torOnionServicePort)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewServer: Problem starting onion service"), false
+		}
+		srv.onionPort = _torOnionServicePort
+	}
+
 	// Create a new connection manager but note that it won't be initialized until Start().
 	_incomingMessages := make(chan *ServerMessage, (_targetOutboundPeers+_maxInboundPeers)*3)
 	_cmgr := NewConnectionManager(
 		_params, _desoAddrMgr, _listeners, _connectIps, timesource,
 		_targetOutboundPeers, _maxInboundPeers, _limitOneInboundConnectionPerIP,
 		_hyperSync, _syncType, _stallTimeoutSeconds, _minFeeRateNanosPerKB,
+		banManager, peerTransport, NewPeerMessageLimits(messageRateLimits), proxyDialer, peerRecords,
+		NewBandwidthManager(_maxUploadBytesPerDay, _maxUploadBytesPerSecond, time.Now()),
 		_incomingMessages, srv)
 
 	// Set up the blockchain data structure. This is responsible for accepting new
@@ -429,6 +553,13 @@ func NewServer(
 	if err != nil {
 		return nil, errors.Wrapf(err, "NewServer: Problem initializing blockchain"), true
 	}
+	_chain.headerOnly = _syncType == NodeSyncTypeHeaderOnly
+	srv.onDemandRequests = make(map[onDemandRequestKey][]chan DeSoMessage)
+
+	// If we were given a trusted checkpoint, we will only sync headers after the checkpoint.
+	if err := _chain.InitTrustedCheckpoint(_trustedCheckpoint); err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem initializing trusted checkpoint"), false
+	}
 
 	glog.V(1).Infof("Initialized chain: Best Header Height: %d, Header Hash: %s, Header CumWork: %s, Best Block Height: %d, Block Hash: %s, Block CumWork: %s",
 		_chain.headerTip().Height,
@@ -508,6 +639,7 @@ func NewServer(
 	// Make this hold a multiple of what we hold for individual peers.
 	srv.inventoryBeingProcessed = lru.NewCache(maxKnownInventory)
 	srv.requestTimeoutSeconds = 10
+	srv.blockDownloader = NewBlockDownloadScheduler(BlockDownloadStallTimeout)
 
 	srv.statsdClient = statsd
 
@@ -520,6 +652,30 @@ func NewServer(
 		srv.StartStatsdReporter()
 	}
 
+	// If requested, periodically verify the incremental state checksum against the db.
+	if _snapshot != nil && _stateScrubberIntervalMinutes > 0 {
+		srv.stateScrubber = NewStateScrubber(_db, _snapshot, _chain, eventManager, _params,
+			time.Duration(_stateScrubberIntervalMinutes)*time.Minute, _stateScrubberMaxEntriesPerSecond)
+	}
+
+	srv.disableSnapshotServing = _disableSnapshotServing
+	srv.stateChecksumPeerKeys = stateChecksumPeerKeys
+	if _snapshot != nil && (_snapshotServingMaxConcurrentRequests > 0 || _snapshotServingPeerBytesPerSecond > 0 ||
+		_snapshotServingGlobalBytesPerSecond > 0) {
+		srv.snapshotServingLimiter = NewSnapshotServingLimiter(_snapshotServingMaxConcurrentRequests,
+			_snapshotServingPeerBytesPerSecond, _snapshotServingGlobalBytesPerSecond)
+	}
+
+	if _db != nil && _dbMaintenanceIntervalMinutes > 0 {
+		srv.DBMaintainer = NewDBMaintainer(_chain, eventManager, statsd,
+			time.Duration(_dbMaintenanceIntervalMinutes)*time.Minute, time.Duration(_dbMaintenanceIdleSeconds)*time.Second,
+			_valueLogGCDiscardRatio, _dbMaintenanceFlatten)
+		srv.DBMaintainer.AddDatabase("chain", _db)
+		if _snapshot != nil {
+			srv.DBMaintainer.AddDatabase("snapshot", _snapshot.SnapshotDb)
+		}
+	}
+
 	// Initialize the addrs to broadcast map.
 	srv.addrsToBroadcastt = make(map[string][]*SingleAddr)
 
@@ -664,7 +820,9 @@ func (srv *Server) GetSnapshot(pp *Peer) {
 }
 
 // GetBlocksToStore is part of the archival mode, which makes the node download all historical blocks after completing
-// hypersync. We will go through all blocks corresponding to the snapshot and download the blocks.
+// hypersync. We will go through all blocks corresponding to the snapshot and download the blocks. The blocks are
+// spread across all of our sync peers, starting with pp. Historical blocks don't need to arrive in order since their
+// parents are already processed.
 func (srv *Server) GetBlocksToStore(pp *Peer) {
 	glog.V(2).Infof("GetBlocksToStore: Calling for peer (%v)", pp)
 
@@ -678,7 +836,8 @@ func (srv *Server) GetBlocksToStore(pp *Peer) {
 	for _, blockNode := range srv.blockchain.bestChain {
 		// We find the first block that's not stored and get ready to download blocks starting from this block onwards.
 		if blockNode.Status&StatusBlockStored == 0 {
-			numBlocksToFetch := MaxBlocksInFlight - len(pp.requestedBlocks)
+			numBlocksToFetch := srv.blockDownloader.Capacity(pp)
+			blocksToIgnore := srv.blockDownloader.BlocksToIgnore()
 			currentHeight := int(blockNode.Height)
 			blockNodesToFetch := []*BlockNode{}
 			// In case there are blocks at tip that are already stored (which shouldn't really happen), we'll not download them.
@@ -699,24 +858,14 @@ func (srv *Server) GetBlocksToStore(pp *Peer) {
 				currentHeight++
 
 				// If we've already requested this block then we don't request it again.
-				if _, exists := pp.requestedBlocks[*currentNode.Hash]; exists {
+				if _, exists := blocksToIgnore[*currentNode.Hash]; exists {
 					continue
 				}
 
 				blockNodesToFetch = append(blockNodesToFetch, currentNode)
 			}
 
-			var hashList []*BlockHash
-			for _, node := range blockNodesToFetch {
-				hashList = append(hashList, node.Hash)
-				pp.requestedBlocks[*node.Hash] = true
-			}
-			pp.AddDeSoMessage(&MsgDeSoGetBlocks{
-				HashList: hashList,
-			}, false)
-
-			glog.V(1).Infof("GetBlocksToStore: Downloading blocks to store for header %v from peer %v",
-				blockNode.Header, pp)
+			srv._requestBlocks("GetBlocksToStore", blockNodesToFetch, pp, -1)
 			return
 		}
 	}
@@ -725,36 +874,44 @@ func (srv *Server) GetBlocksToStore(pp *Peer) {
 	srv.blockchain.downloadingHistoricalBlocks = false
 }
 
-// GetBlocks computes what blocks we need to fetch and asks for them from the
-// corresponding peer. It is typically called after we have exited
-// SyncStateSyncingHeaders.
+// GetBlocks computes what blocks we need to fetch and asks for them from pp and the
+// rest of our sync peers. maxHeight limits the blocks we ask pp for, and the other
+// peers are only asked for blocks they had when they connected. We don't fetch blocks
+// further than BlockDownloadWindow past our block tip, since blocks that arrive ahead
+// of their parent are held in memory until the parent is connected. It is typically
+// called after we have exited SyncStateSyncingHeaders.
 func (srv *Server) GetBlocks(pp *Peer, maxHeight int) {
-	// Fetch as many blocks as we can from this peer.
-	numBlocksToFetch := MaxBlocksInFlight - len(pp.requestedBlocks)
+	numBlocksToFetch := srv.blockDownloader.Capacity(pp)
+	windowHeight := int(srv.blockchain.blockTip().Height) + BlockDownloadWindow
 	blockNodesToFetch := srv.blockchain.GetBlockNodesToFetch(
-		numBlocksToFetch, maxHeight, pp.requestedBlocks)
+		numBlocksToFetch, windowHeight, srv.blockDownloader.BlocksToIgnore())
+	srv._requestBlocks("GetBlocks", blockNodesToFetch, pp, maxHeight)
+}
+
+// _requestBlocks assigns blockNodesToFetch to our sync peers and sends each of them a GetBlocks.
+func (srv *Server) _requestBlocks(caller string, blockNodesToFetch []*BlockNode, pp *Peer, maxHeight int) {
 	if len(blockNodesToFetch) == 0 {
 		// This can happen if, for example, we're already requesting the maximum
 		// number of blocks we can. Just return in this case.
 		return
 	}
 
-	// If we're here then we have some blocks to fetch so fetch them.
-	hashList := []*BlockHash{}
-	for _, node := range blockNodesToFetch {
-		hashList = append(hashList, node.Hash)
+	assignments := srv.blockDownloader.AssignBlocks(blockNodesToFetch, pp, maxHeight, time.Now())
+	for _, assignment := range assignments {
+		hashList := []*BlockHash{}
+		for _, node := range assignment.BlockNodes {
+			hashList = append(hashList, node.Hash)
+		}
+		assignment.Peer.AddDeSoMessage(&MsgDeSoGetBlocks{
+			HashList: hashList,
+		}, false)
 
-		pp.requestedBlocks[*node.Hash] = true
+		glog.V(1).Infof("%v: Downloading %d blocks from header %v to header %v from peer %v",
+			caller, len(assignment.BlockNodes),
+			assignment.BlockNodes[0].Header,
+			assignment.BlockNodes[len(assignment.BlockNodes)-1].Header,
+			assignment.Peer)
 	}
-	pp.AddDeSoMessage(&MsgDeSoGetBlocks{
-		HashList: hashList,
-	}, false)
-
-	glog.V(1).Infof("GetBlocks: Downloading %d blocks from header %v to header %v from peer %v",
-		len(blockNodesToFetch),
-		blockNodesToFetch[0].Header,
-		blockNodesToFetch[len(blockNodesToFetch)-1].Header,
-		pp)
 }
 
 func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
@@ -823,6 +980,13 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 				"because error occurred processing header: %v, isOrphan: %v",
 				pp, srv.blockchain.chainState(), err, isOrphan)
 
+			if err != nil {
+				if IsPeerMisbehaviorHeaderError(err) {
+					pp.Misbehaving(PeerMisbehaviorInvalidHeader, fmt.Sprintf("Header %v: %v", headerHash, err))
+				}
+			} else {
+				pp.Misbehaving(PeerMisbehaviorUnconnectedHeaders, fmt.Sprintf("Orphan header %v", headerHash))
+			}
 			pp.Disconnect()
 			return
 		}
@@ -860,6 +1024,13 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 			return
 		}
 
+		// A header-only node is done once it has the headers. It only fetches blocks on demand.
+		if srv.blockchain.headerOnly {
+			glog.V(1).Infof("Server._handleHeaderBundle: Header tip is up-to-date at height %d, not "+
+				"downloading blocks since we're header-only. Peer: %v", srv.blockchain.headerTip().Height, pp)
+			return
+		}
+
 		// If we get here it means that we've just finished syncing headers and we will proceed to
 		// syncing state either through hyper sync or block sync. First let's check if the peer
 		// supports hypersync and if our block tip is old enough so that it makes sense to sync state.
@@ -877,6 +1048,11 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 			if srv.cmgr.HyperSync {
 				bestHeaderHeight := uint64(srv.blockchain.headerTip().Height)
 				expectedSnapshotHeight := bestHeaderHeight - (bestHeaderHeight % srv.snapshot.SnapshotBlockHeightPeriod)
+				// If we bootstrapped from a trusted checkpoint, we want the snapshot taken at the checkpoint.
+				trustedCheckpoint := srv.blockchain.trustedCheckpoint
+				if trustedCheckpoint != nil {
+					expectedSnapshotHeight = uint64(trustedCheckpoint.Height)
+				}
 				srv.blockchain.snapshot.Migrations.CleanupMigrations(expectedSnapshotHeight)
 
 				if len(srv.HyperSyncProgress.PrefixProgress) != 0 {
@@ -917,7 +1093,12 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 					SnapshotBlockHeight:       expectedSnapshotHeight,
 					FirstSnapshotBlockHeight:  expectedSnapshotHeight,
 					CurrentEpochChecksumBytes: []byte{},
-					CurrentEpochBlockHash:     srv.blockchain.bestHeaderChain[expectedSnapshotHeight].Hash,
+					CurrentEpochBlockHash:     srv.blockchain.HeaderAtHeight(uint32(expectedSnapshotHeight)).Hash,
+				}
+				// When syncing from a trusted checkpoint, we know the checksum upfront. Setting it here makes us
+				// disconnect any peer that sends us snapshot metadata with a different checksum.
+				if trustedCheckpoint != nil {
+					srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes = trustedCheckpoint.StateChecksum
 				}
 				srv.HyperSyncProgress.PrefixProgress = []*SyncPrefixProgress{}
 				srv.HyperSyncProgress.Completed = false
@@ -1028,6 +1209,7 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 			"she indicated that she has more headers but the last hash %v in "+
 			"the header bundle does not correspond to a block in our index.",
 			pp, lastHash)
+		pp.Misbehaving(PeerMisbehaviorUnconnectedHeaders, fmt.Sprintf("Last header %v is not in our index", lastHash))
 		pp.Disconnect()
 		return
 	}
@@ -1059,6 +1241,37 @@ func (srv *Server) _handleGetSnapshot(pp *Peer, msg *MsgDeSoGetSnapshot) {
 	pp.AddDeSoMessage(msg, true /*inbound*/)
 }
 
+// _handleGetStateChecksum gets called whenever we receive a GetStateChecksum message from a peer, which
+// is used to compare the state of two nodes. Computing checksums is expensive, so we only take requests
+// from peers with a key in --state-checksum-peer-keys. Same as with GetSnapshot, we delegate them to the peer.
+func (srv *Server) _handleGetStateChecksum(pp *Peer, msg *MsgDeSoGetStateChecksum) {
+	glog.V(1).Infof("srv._handleGetStateChecksum: Called with message %v from Peer %v", msg, pp)
+
+	if !srv.isStateChecksumPeer(pp) {
+		glog.Errorf("Server._handleGetStateChecksum: Disconnecting from peer %v because it isn't allowed "+
+			"to request state checksums", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshotRequest, "Not allowed to request state checksums")
+		pp.Disconnect()
+		return
+	}
+
+	pp.AddDeSoMessage(msg, true /*inbound*/)
+}
+
+// isStateChecksumPeer returns true if pp proved it owns one of the keys in --state-checksum-peer-keys.
+func (srv *Server) isStateChecksumPeer(pp *Peer) bool {
+	transportPublicKey := pp.TransportPublicKey()
+	if transportPublicKey == nil {
+		return false
+	}
+	for _, publicKey := range srv.stateChecksumPeerKeys {
+		if PeerTransportKeysEqual(publicKey, transportPublicKey) {
+			return true
+		}
+	}
+	return false
+}
+
 // _handleSnapshot gets called when we receive a SnapshotData message from a peer. The message contains
 // a snapshot chunk, which is a sorted list of <key, value> pairs representing a section of the database
 // at current snapshot epoch. We will set these entries in our node's database as well as update the checksum.
@@ -1070,6 +1283,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	if srv.snapshot == nil {
 		glog.Errorf("srv._handleSnapshot: Received a snapshot message from a peer but srv.snapshot is nil. " +
 			"This peer shouldn't send us snapshot messages because we didn't pass the SFHyperSync flag.")
+		pp.Misbehaving(PeerMisbehaviorUnrequestedSnapshot, "We're not a hypersync node")
 		pp.Disconnect()
 		return
 	}
@@ -1078,6 +1292,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	if srv.blockchain.ChainState() != SyncStateSyncingSnapshot {
 		glog.Errorf("srv._handleSnapshot: Received a snapshot message from peer but chain is not currently syncing from "+
 			"snapshot. This means peer is most likely misbehaving so we'll disconnect them. Peer: (%v)", pp)
+		pp.Misbehaving(PeerMisbehaviorUnrequestedSnapshot, "We're not syncing from a snapshot")
 		pp.Disconnect()
 		return
 	}
@@ -1086,6 +1301,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 		// We should disconnect the peer because he is misbehaving or doesn't have the snapshot.
 		glog.Errorf("srv._handleSnapshot: Received a snapshot messages with empty snapshot chunk "+
 			"disconnecting misbehaving peer (%v)", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, "Empty snapshot chunk")
 		pp.Disconnect()
 		return
 	}
@@ -1100,6 +1316,17 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	if msg.SnapshotMetadata.SnapshotBlockHeight > srv.HyperSyncProgress.SnapshotMetadata.SnapshotBlockHeight &&
 		uint64(srv.blockchain.HeaderTip().Height) >= msg.SnapshotMetadata.SnapshotBlockHeight {
 
+		// Restarting won't help if we're syncing from a trusted checkpoint, because we'd just request the
+		// same checkpoint again. The peer has moved on to a newer epoch, so we'll look for a different peer.
+		if srv.blockchain.trustedCheckpoint != nil {
+			glog.Errorf(CLog(Red, fmt.Sprintf("srv._handleSnapshot: Peer (%v) is serving a snapshot at height (%v) "+
+				"but our trusted checkpoint is at height (%v). The trusted checkpoint is likely stale and should be "+
+				"updated to a more recent snapshot epoch. Disconnecting peer.", pp,
+				msg.SnapshotMetadata.SnapshotBlockHeight, srv.blockchain.trustedCheckpoint.Height)))
+			pp.Disconnect()
+			return
+		}
+
 		// TODO: Figure out how to handle header not reaching us, yet peer is telling us that the new epoch has started.
 		if srv.nodeMessageChannel != nil {
 			srv.nodeMessageChannel <- NodeRestart
@@ -1122,6 +1349,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 			"hyper sync height (%v) and hash (%v)",
 			msg.SnapshotMetadata.SnapshotBlockHeight, msg.SnapshotMetadata.CurrentEpochBlockHash,
 			srv.HyperSyncProgress.SnapshotMetadata.SnapshotBlockHeight, srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochBlockHash)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, "Snapshot metadata doesn't match the requested epoch")
 		pp.Disconnect()
 		return
 	}
@@ -1139,6 +1367,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 		// We should disconnect the peer because he is misbehaving
 		glog.Errorf("srv._handleSnapshot: Problem finding appropriate sync prefix progress "+
 			"disconnecting misbehaving peer (%v)", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, fmt.Sprintf("Unknown prefix %v", msg.Prefix))
 		pp.Disconnect()
 		return
 	}
@@ -1153,6 +1382,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 		// We should disconnect the peer because he is misbehaving
 		glog.Errorf("srv._handleSnapshot: HyperSyncProgress epoch checksum bytes does not match that received from peer, "+
 			"disconnecting misbehaving peer (%v)", pp)
+		pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, "Epoch checksum doesn't match previous chunks")
 		pp.Disconnect()
 		return
 	}
@@ -1176,6 +1406,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 			glog.Errorf("srv._handleSnapshot: Snapshot chunk DBEntry key has mismatched prefix "+
 				"disconnecting misbehaving peer (%v)", pp)
 			srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes = prevChecksumBytes
+			pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, "First chunk entry has mismatched prefix")
 			pp.Disconnect()
 			return
 		}
@@ -1188,6 +1419,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 			glog.Errorf("srv._handleSnapshot: Received a snapshot chunk that's not in-line with the sync progress "+
 				"disconnecting misbehaving peer (%v)", pp)
 			srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes = prevChecksumBytes
+			pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, "Chunk doesn't continue from the last received key")
 			pp.Disconnect()
 			return
 		}
@@ -1205,6 +1437,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 				glog.Errorf("srv._handleSnapshot: DBEntry key has mismatched prefix "+
 					"disconnecting misbehaving peer (%v)", pp)
 				srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes = prevChecksumBytes
+				pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, "Chunk entry has mismatched prefix")
 				pp.Disconnect()
 				return
 			}
@@ -1215,6 +1448,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 					"value (%v) and second entry with index (%v) and value (%v) disconnecting misbehaving peer (%v)",
 					ii-1, dbChunk[ii-1].Key, ii, dbChunk[ii].Key, pp)
 				srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes = prevChecksumBytes
+				pp.Misbehaving(PeerMisbehaviorInvalidSnapshot, "Chunk entries are not sorted")
 				pp.Disconnect()
 				return
 			}
@@ -1290,7 +1524,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 		srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes)))
 
 	glog.Infof(CLog(Yellow, fmt.Sprintf("Best header chain %v best block chain %v",
-		srv.blockchain.bestHeaderChain[msg.SnapshotMetadata.SnapshotBlockHeight], srv.blockchain.bestChain)))
+		srv.blockchain.HeaderAtHeight(uint32(msg.SnapshotMetadata.SnapshotBlockHeight)), srv.blockchain.bestChain)))
 
 	// Verify that the state checksum matches the one in HyperSyncProgress snapshot metadata.
 	// If the checksums don't match, it means that we've been interacting with a peer that was misbehaving.
@@ -1298,6 +1532,18 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	if err != nil {
 		glog.Errorf("Server._handleSnapshot: Problem getting checksum bytes, error (%v)", err)
 	}
+	// If we're syncing from a trusted checkpoint, also make sure the state matches the checksum supplied by the
+	// operator. This should be implied by the check above, but we don't want to rely on the peer here.
+	trustedCheckpoint := srv.blockchain.trustedCheckpoint
+	if trustedCheckpoint != nil && !reflect.DeepEqual(checksumBytes, trustedCheckpoint.StateChecksum) {
+		if srv.nodeMessageChannel != nil {
+			srv.nodeMessageChannel <- NodeErase
+		}
+		glog.Errorf(CLog(Red, fmt.Sprintf("Server._handleSnapshot: The final db checksum (%v) doesn't match the "+
+			"trusted checkpoint state checksum (%v). We'll restart the node and attempt to HyperSync from the "+
+			"beginning.", checksumBytes, trustedCheckpoint.StateChecksum)))
+		return
+	}
 	if !reflect.DeepEqual(checksumBytes, srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes) {
 		if srv.nodeMessageChannel != nil {
 			srv.nodeMessageChannel <- NodeErase
@@ -1315,9 +1561,19 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	// already synced all the state corresponding to the sub-blockchain ending at the snapshot
 	// height, we will now mark all these blocks as processed. To do so, we will iterate through
 	// the blockNodes in the header chain and set them in the blockchain data structures.
-	err = srv.blockchain.db.Update(func(txn *badger.Txn) error {
-		for ii := uint64(1); ii <= srv.HyperSyncProgress.SnapshotMetadata.SnapshotBlockHeight; ii++ {
-			curretNode := srv.blockchain.bestHeaderChain[ii]
+	//
+	// If we bootstrapped from a trusted checkpoint, the header chain starts at the checkpoint rather than at
+	// genesis. The genesis block isn't an ancestor of the checkpoint node, so we drop it from the best chain
+	// and start the best chain at the checkpoint instead.
+	firstHeight := uint64(1)
+	if trustedCheckpoint != nil {
+		firstHeight = uint64(trustedCheckpoint.Height)
+		srv.blockchain.bestChain = []*BlockNode{}
+		srv.blockchain.bestChainMap = make(map[BlockHash]*BlockNode)
+	}
+	err = srv.blockchain.db.Update(func(txn KVTxn) error {
+		for ii := firstHeight; ii <= srv.HyperSyncProgress.SnapshotMetadata.SnapshotBlockHeight; ii++ {
+			curretNode := srv.blockchain.HeaderAtHeight(uint32(ii))
 			// Do not set the StatusBlockStored flag, because we still need to download the past blocks.
 			curretNode.Status |= StatusBlockProcessed
 			curretNode.Status |= StatusBlockValidated
@@ -1342,12 +1598,13 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 
 	// If we got here then we finished the snapshot sync so set appropriate flags.
 	srv.blockchain.syncingState = false
+	srv.blockchain.trustedCheckpoint = nil
 	srv.blockchain.snapshot.CurrentEpochSnapshotMetadata = srv.HyperSyncProgress.SnapshotMetadata
 
 	// Update the snapshot epoch metadata in the snapshot DB.
 	for ii := 0; ii < MetadataRetryCount; ii++ {
 		srv.snapshot.SnapshotDbMutex.Lock()
-		err = srv.snapshot.SnapshotDb.Update(func(txn *badger.Txn) error {
+		err = srv.snapshot.SnapshotDb.Update(func(txn KVTxn) error {
 			return txn.Set(_prefixLastEpochMetadata, srv.snapshot.CurrentEpochSnapshotMetadata.ToBytes())
 		})
 		srv.snapshot.SnapshotDbMutex.Unlock()
@@ -1457,6 +1714,11 @@ func (srv *Server) _handleNewPeer(pp *Peer) {
 	// Request a sync if we're ready
 	srv._maybeRequestSync(pp)
 
+	// Sync candidates also serve us blocks while we're syncing.
+	if isSyncCandidate {
+		srv.blockDownloader.AddPeer(pp)
+	}
+
 	// Start syncing by choosing the best candidate.
 	if isSyncCandidate && srv.SyncPeer == nil {
 		srv._startSync()
@@ -1534,14 +1796,51 @@ func (srv *Server) _handleDonePeer(pp *Peer) {
 	glog.V(1).Infof("Server._handleDonePeer: Processing DonePeer: %v", pp)
 
 	srv._cleanupDonePeerState(pp)
+	if srv.snapshotServingLimiter != nil {
+		srv.snapshotServingLimiter.RemovePeer(pp.ID)
+	}
 
 	// Attempt to find a new peer to sync from if the quitting peer is the
 	// sync peer and if our blockchain isn't current.
+	srv.blockDownloader.RemovePeer(pp)
 	if srv.SyncPeer == pp && srv.blockchain.isSyncing() {
 
 		srv.SyncPeer = nil
 		srv._startSync()
+		return
 	}
+
+	// Hand the blocks we were waiting on from this peer to our other sync peers.
+	srv._resumeBlockDownload()
+}
+
+// _resumeBlockDownload requests the blocks that aren't in flight from our sync peers, if we're
+// downloading blocks.
+func (srv *Server) _resumeBlockDownload() {
+	if srv.SyncPeer == nil {
+		return
+	}
+	switch srv.blockchain.chainState() {
+	case SyncStateSyncingBlocks:
+		srv.GetBlocks(srv.SyncPeer, -1)
+	case SyncStateSyncingHistoricalBlocks:
+		srv.GetBlocksToStore(srv.SyncPeer)
+	}
+}
+
+// _handleBlockDownloadTick re-assigns the blocks that our peers haven't delivered in time.
+func (srv *Server) _handleBlockDownloadTick() {
+	for _, pp := range srv.cmgr.GetAllPeers() {
+		srv._expirePartialCompactBlocks(pp)
+	}
+
+	numReleased := srv.blockDownloader.ReleaseStalledBlocks(time.Now())
+	if numReleased == 0 {
+		return
+	}
+	glog.V(1).Infof("Server._handleBlockDownloadTick: Re-assigning %d stalled blocks across %d peers",
+		numReleased, srv.blockDownloader.NumPeers())
+	srv._resumeBlockDownload()
 }
 
 func (srv *Server) _relayTransactions() {
@@ -1673,6 +1972,11 @@ func (srv *Server) _handleBlockMainChainDisconnectedd(event *BlockEvent) {
 }
 
 func (srv *Server) _maybeRequestSync(pp *Peer) {
+	// A header-only node can't validate transactions, so it doesn't sync the mempool.
+	if srv.blockchain.headerOnly {
+		return
+	}
+
 	// Send the mempool message if DeSo and Bitcoin are fully current
 	if srv.blockchain.chainState() == SyncStateFullyCurrent {
 		// If peer is not nil and we haven't set a max sync blockheight, we will
@@ -1704,87 +2008,222 @@ func (srv *Server) _handleBlockAccepted(event *BlockEvent) {
 		Hash: *blockHash,
 	}
 
+	// Peers that support compact blocks get the block right away as a compact block, unless
+	// they're the ones who sent it to us. All other peers get an inv.
+	compactBlock, err := NewCompactBlock(blk)
+	if err != nil {
+		glog.Errorf("Server._handleBlockAccepted: Problem creating compact block, relaying "+
+			"inv instead: %v", err)
+	}
+
 	// Iterate through all the peers and relay the InvVect to them. This will only
 	// actually be relayed if it's not already in the peer's knownInventory.
 	allPeers := srv.cmgr.GetAllPeers()
 	for _, pp := range allPeers {
+		if compactBlock != nil && pp.SupportsCompactBlocks() {
+			if !pp.knownInventory.Contains(*invVect) {
+				pp.knownInventory.Add(*invVect)
+				pp.AddDeSoMessage(compactBlock, false)
+			}
+			continue
+		}
 		pp.AddDeSoMessage(&MsgDeSoInv{
 			InvList: []*InvVect{invVect},
 		}, false)
 	}
 }
 
-func (srv *Server) _logAndDisconnectPeer(pp *Peer, blockMsg *MsgDeSoBlock, suffix string) {
-	// Disconnect the Peer. Generally-speaking, disconnecting from the peer will cause its
-	// requested blocks and txns to be removed from the global maps and cause it to be
-	// replaced by another peer. Furthermore,
-	// if we're in the process of syncing our node, the startSync process will also
-	// be restarted as a resul. If we're not syncing our peer and have instead reached
-	// the steady-state, then the next interesting inv message should cause us to
-	// fetch headers, blocks, etc. So we'll be back.
-	glog.Errorf("Server._handleBlock: Encountered an error processing "+
-		"block %v. Disconnecting from peer %v: %s", blockMsg, pp, suffix)
-	pp.Disconnect()
-}
+// _handleCompactBlock rebuilds a block relayed as a compact block from our mempool. If we're missing
+// transactions, we ask the peer for them and finish the block in _handleBlockTxns.
+func (srv *Server) _handleCompactBlock(pp *Peer, msg *MsgDeSoCompactBlock) {
+	blockHash, err := msg.Header.Hash()
+	if err != nil {
+		glog.Errorf("Server._handleCompactBlock: Problem hashing header from peer %v: %v", pp, err)
+		return
+	}
+	glog.V(1).Infof("Server._handleCompactBlock: Received compact block %v with %d short txn ids "+
+		"from peer %v", blockHash, len(msg.ShortTxnIDs), pp)
 
-func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
-	glog.Infof(CLog(Cyan, fmt.Sprintf("Server._handleBlock: Received block ( %v / %v ) from Peer %v",
-		blk.Header.Height, srv.blockchain.headerTip().Height, pp)))
+	// Make sure we don't send the block back to the peer.
+	invVect := InvVect{
+		Type: InvTypeBlock,
+		Hash: *blockHash,
+	}
+	pp.knownInventory.Add(invVect)
 
-	srv.timer.Start("Server._handleBlock: General")
-	// Pull out the header for easy access.
-	blockHeader := blk.Header
-	if blockHeader == nil {
-		// Should never happen but check it nevertheless.
-		srv._logAndDisconnectPeer(pp, blk, "Header was nil")
+	// A header-only node only needs the header, which it gets like it would after an inv.
+	if srv.blockchain.headerOnly {
+		if !srv.blockchain.HasHeader(blockHash) {
+			pp.AddDeSoMessage(&MsgDeSoGetHeaders{
+				StopHash:     &BlockHash{},
+				BlockLocator: srv.blockchain.LatestHeaderLocator(),
+			}, false)
+		}
 		return
 	}
 
-	// If we've set a maximum sync height and we've reached that height, then we will
-	// stop accepting new blocks.
-	if srv.blockchain.isTipMaxed(srv.blockchain.blockTip()) &&
-		blockHeader.Height > uint64(srv.blockchain.blockTip().Height) {
+	// We only take compact blocks that extend a block we have. Anything else is left to the
+	// regular sync, which we kick off like we would after an inv.
+	if srv.blockchain.isSyncing() || srv.blockchain.HasBlock(blockHash) {
+		return
+	}
+	if !srv.blockchain.HasBlock(msg.Header.PrevBlockHash) {
+		srv._requestBlockAfterCompactBlockFailure(pp, blockHash, "we don't have the parent block")
+		return
+	}
 
-		glog.Infof("Server._handleBlock: Exiting because block tip is maxed out")
+	// Check the header's proof of work and difficulty before we spend any effort rebuilding the
+	// block from our mempool. The parent is in our block index, so the header can't be an orphan.
+	if !srv.blockchain.HasHeader(blockHash) {
+		if _, _, err := srv.blockchain.ProcessHeader(msg.Header, blockHash); err != nil {
+			glog.Errorf("Server._handleCompactBlock: Disconnecting from peer %v because compact block %v "+
+				"has an invalid header: %v", pp, blockHash, err)
+			if IsPeerMisbehaviorHeaderError(err) {
+				pp.Misbehaving(PeerMisbehaviorInvalidHeader, fmt.Sprintf("Compact block header %v: %v", blockHash, err))
+			}
+			pp.Disconnect()
+			return
+		}
+	}
+
+	partialBlock, err := srv.mempool.ReconstructCompactBlock(msg)
+	if err != nil {
+		srv._requestBlockAfterCompactBlockFailure(pp, blockHash, err.Error())
+		return
+	}
+	if len(partialBlock.MissingTxnIndexes) > 0 {
+		srv._expirePartialCompactBlocks(pp)
+		if len(pp.partialCompactBlocks) >= MaxPartialCompactBlocksPerPeer {
+			srv._requestBlockAfterCompactBlockFailure(pp, blockHash, "too many compact blocks are waiting on txns")
+			return
+		}
+		glog.V(1).Infof("Server._handleCompactBlock: Requesting %d missing txns of compact block %v "+
+			"from peer %v", len(partialBlock.MissingTxnIndexes), blockHash, pp)
+		partialBlock.TimeRequested = time.Now()
+		pp.partialCompactBlocks[*blockHash] = partialBlock
+		pp.AddDeSoMessage(&MsgDeSoGetBlockTxns{
+			BlockHash:  blockHash,
+			TxnIndexes: partialBlock.MissingTxnIndexes,
+		}, false)
 		return
 	}
+	srv._processPartialCompactBlock(pp, partialBlock)
+}
 
-	// Compute the hash of the block.
-	blockHash, err := blk.Header.Hash()
+func (srv *Server) _handleGetBlockTxns(pp *Peer, msg *MsgDeSoGetBlockTxns) {
+	glog.V(1).Infof("srv._handleGetBlockTxns: Called with message %v from Peer %v", msg, pp)
+
+	// Let the peer handle this since it has to fetch the block.
+	pp.AddDeSoMessage(msg, true /*inbound*/)
+}
+
+func (srv *Server) _handleGetTxnProof(pp *Peer, msg *MsgDeSoGetTxnProof) {
+	glog.V(1).Infof("srv._handleGetTxnProof: Called with message %v from Peer %v", msg, pp)
+
+	// Let the peer handle this since it has to fetch the block.
+	pp.AddDeSoMessage(msg, true /*inbound*/)
+}
+
+func (srv *Server) _handleBlockTxns(pp *Peer, msg *MsgDeSoBlockTxns) {
+	partialBlock, exists := pp.partialCompactBlocks[*msg.BlockHash]
+	if !exists && srv.blockchain.HasBlock(msg.BlockHash) {
+		// We stop waiting on the txns once the block arrives some other way.
+		glog.V(1).Infof("Server._handleBlockTxns: Ignoring txns for block %v from peer %v because we "+
+			"already have the block", msg.BlockHash, pp)
+		return
+	}
+	if !exists {
+		glog.Errorf("Server._handleBlockTxns: Disconnecting peer %v because she sent txns for block %v "+
+			"that we didn't ask for", pp, msg.BlockHash)
+		pp.Misbehaving(PeerMisbehaviorUnrequestedBlock, fmt.Sprintf("Block txns %v", msg.BlockHash))
+		pp.Disconnect()
+		return
+	}
+	delete(pp.partialCompactBlocks, *msg.BlockHash)
+
+	if err := partialBlock.FillMissingTxns(msg); err != nil {
+		srv._requestBlockAfterCompactBlockFailure(pp, partialBlock.BlockHash, err.Error())
+		return
+	}
+	srv._processPartialCompactBlock(pp, partialBlock)
+}
+
+// _expirePartialCompactBlocks stops waiting on the compact blocks from pp that we got some other way
+// or whose missing txns pp didn't send in time. The latter are fetched the regular way.
+func (srv *Server) _expirePartialCompactBlocks(pp *Peer) {
+	timedOutBlocks := ExpirePartialCompactBlocks(pp.partialCompactBlocks, time.Now(), srv.blockchain.HasBlock)
+	for _, partialBlock := range timedOutBlocks {
+		srv._requestBlockAfterCompactBlockFailure(pp, partialBlock.BlockHash,
+			"the peer didn't send the missing txns in time")
+	}
+}
+
+// _processPartialCompactBlock processes a compact block whose transactions are all known as a regular block.
+func (srv *Server) _processPartialCompactBlock(pp *Peer, partialBlock *PartialCompactBlock) {
+	// Check the merkle root before processing the block, so that a short id collision on our end
+	// doesn't get the block marked as invalid.
+	blk, err := partialBlock.ToBlock()
 	if err != nil {
-		// This should never happen if we got this far but log the error, clear the
-		// requestedBlocks, disconnect from the peer and return just in case.
-		srv._logAndDisconnectPeer(
-			pp, blk, "Problem computing block hash")
+		srv._requestBlockAfterCompactBlockFailure(pp, partialBlock.BlockHash, err.Error())
+		return
+	}
+	if srv.blockchain.HasBlock(partialBlock.BlockHash) {
 		return
 	}
+	pp.requestedBlocks[*partialBlock.BlockHash] = true
+	srv._handleBlock(pp, blk)
+}
 
-	if pp != nil {
-		if _, exists := pp.requestedBlocks[*blockHash]; !exists {
-			glog.Errorf("_handleBlock: Getting a block that we haven't requested before, "+
-				"block hash (%v)", *blockHash)
-		}
-		delete(pp.requestedBlocks, *blockHash)
-	} else {
-		glog.Errorf("_handleBlock: Called with nil peer, this should never happen.")
+// _requestBlockAfterCompactBlockFailure falls back to fetching a block the regular way, by asking the
+// peer for headers and then for the full block, as we would after an inv.
+func (srv *Server) _requestBlockAfterCompactBlockFailure(pp *Peer, blockHash *BlockHash, reason string) {
+	glog.V(1).Infof("Server._requestBlockAfterCompactBlockFailure: Can't use compact block %v from "+
+		"peer %v because %v. Requesting headers instead", blockHash, pp, reason)
+	if srv.blockchain.HasHeader(blockHash) && !srv.blockchain.HasBlock(blockHash) {
+		// If we already have the header, the regular block download will pick the block up.
+		srv.GetBlocks(pp, int(srv.blockchain.headerTip().Height))
+		return
 	}
+	pp.AddDeSoMessage(&MsgDeSoGetHeaders{
+		StopHash:     &BlockHash{},
+		BlockLocator: srv.blockchain.LatestHeaderLocator(),
+	}, false)
+}
+
+func (srv *Server) _logAndDisconnectPeer(pp *Peer, blockMsg *MsgDeSoBlock, misbehavior PeerMisbehavior, suffix string) {
+	// Disconnect the Peer. Generally-speaking, disconnecting from the peer will cause its
+	// requested blocks and txns to be removed from the global maps and cause it to be
+	// replaced by another peer. Furthermore,
+	// if we're in the process of syncing our node, the startSync process will also
+	// be restarted as a resul. If we're not syncing our peer and have instead reached
+	// the steady-state, then the next interesting inv message should cause us to
+	// fetch headers, blocks, etc. So we'll be back.
+	glog.Errorf("Server._handleBlock: Encountered an error processing "+
+		"block %v. Disconnecting from peer %v: %s", blockMsg, pp, suffix)
+	pp.Misbehaving(misbehavior, suffix)
+	pp.Disconnect()
+}
+
+// _processBlock runs a block we've received from pp through ProcessBlock, and disconnects pp if
+// the block is invalid. It returns false if the block wasn't connected.
+func (srv *Server) _processBlock(pp *Peer, blk *MsgDeSoBlock, blockHash *BlockHash) bool {
+	var err error
 
 	// Check that the mempool has not received a transaction that would forbid this block's signature pubkey.
 	// This is a minimal check, a more thorough check is made in the ProcessBlock function. This check is
 	// necessary because the ProcessBlock function only has access to mined transactions. Therefore, if an
 	// attacker were to prevent a "forbid X pubkey" transaction from mining, they could force nodes to continue
 	// processing their blocks.
-	if len(srv.blockchain.trustedBlockProducerPublicKeys) > 0 && blockHeader.Height >= srv.blockchain.trustedBlockProducerStartHeight {
+	if len(srv.blockchain.trustedBlockProducerPublicKeys) > 0 && blk.Header.Height >= srv.blockchain.trustedBlockProducerStartHeight {
 		if blk.BlockProducerInfo != nil {
 			_, entryExists := srv.mempool.readOnlyUtxoView.ForbiddenPubKeyToForbiddenPubKeyEntry[MakePkMapKey(
 				blk.BlockProducerInfo.PublicKey)]
 			if entryExists {
-				srv._logAndDisconnectPeer(pp, blk, "Got forbidden block signature public key.")
-				return
+				srv._logAndDisconnectPeer(pp, blk, PeerMisbehaviorInvalidBlock, "Got forbidden block signature public key.")
+				return false
 			}
 		}
 	}
-	srv.timer.End("Server._handleBlock: General")
 	srv.timer.Start("Server._handleBlock: Process Block")
 
 	// Only verify signatures for recent blocks.
@@ -1816,9 +2255,9 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 			glog.Warningf("Got duplicate block %v from peer %v", blk, pp)
 		} else {
 			srv._logAndDisconnectPeer(
-				pp, blk,
+				pp, blk, PeerMisbehaviorInvalidBlock,
 				errors.Wrapf(err, "Error while processing block: ").Error())
-			return
+			return false
 		}
 	}
 	if isOrphan {
@@ -1826,17 +2265,123 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 		// went wrong in our headers syncing.
 		glog.Errorf("ERROR: Received orphan block with hash %v height %v. "+
 			"This should never happen", blockHash, blk.Header.Height)
-		return
+		return false
 	}
 	srv.timer.End("Server._handleBlock: Process Block")
+	srv.timer.Print("Server._handleBlock: Process Block")
+
+	// Remember which outbound peers are useful to sync from.
+	if pp != nil && pp.isOutbound {
+		srv.cmgr.PeerRecords.RecordBlocksServed(pp.netAddr, 1)
+	}
+
+	return true
+}
+
+func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
+	glog.Infof(CLog(Cyan, fmt.Sprintf("Server._handleBlock: Received block ( %v / %v ) from Peer %v",
+		blk.Header.Height, srv.blockchain.headerTip().Height, pp)))
+
+	srv.timer.Start("Server._handleBlock: General")
+	// Pull out the header for easy access.
+	blockHeader := blk.Header
+	if blockHeader == nil {
+		// Should never happen but check it nevertheless.
+		srv._logAndDisconnectPeer(pp, blk, PeerMisbehaviorInvalidBlock, "Header was nil")
+		return
+	}
+
+	// If we've set a maximum sync height and we've reached that height, then we will
+	// stop accepting new blocks.
+	if srv.blockchain.isTipMaxed(srv.blockchain.blockTip()) &&
+		blockHeader.Height > uint64(srv.blockchain.blockTip().Height) {
+
+		glog.Infof("Server._handleBlock: Exiting because block tip is maxed out")
+		return
+	}
+
+	// Compute the hash of the block.
+	blockHash, err := blk.Header.Hash()
+	if err != nil {
+		// This should never happen if we got this far but log the error, clear the
+		// requestedBlocks, disconnect from the peer and return just in case.
+		srv._logAndDisconnectPeer(
+			pp, blk, PeerMisbehaviorInvalidBlock, "Problem computing block hash")
+		return
+	}
+
+	// A header-only node only receives the blocks it fetches on demand.
+	if srv.blockchain.headerOnly {
+		srv._handleHeaderOnlyBlock(pp, blk, blockHash)
+		return
+	}
 
+	if pp != nil {
+		if _, exists := pp.requestedBlocks[*blockHash]; !exists {
+			glog.Errorf("_handleBlock: Getting a block that we haven't requested before, "+
+				"block hash (%v)", *blockHash)
+		}
+		delete(pp.requestedBlocks, *blockHash)
+		// Stop waiting on the missing txns of the block if it came as a compact block before.
+		delete(pp.partialCompactBlocks, *blockHash)
+	} else {
+		glog.Errorf("_handleBlock: Called with nil peer, this should never happen.")
+	}
+
+	downloadRequest := srv.blockDownloader.BlockReceived(pp, blockHash)
+	srv.timer.End("Server._handleBlock: General")
 	srv.timer.Print("Server._handleBlock: General")
-	srv.timer.Print("Server._handleBlock: Process Block")
+
+	// While syncing, blocks are downloaded from several peers at once and can arrive before
+	// their parent. Hold on to such blocks until the parent is connected instead of processing
+	// them as orphans.
+	if srv.blockchain.chainState() == SyncStateSyncingBlocks &&
+		srv.blockchain.HasHeader(blockHeader.PrevBlockHash) &&
+		!srv.blockchain.HasBlock(blockHeader.PrevBlockHash) {
+
+		// We only hold blocks we asked this peer for, and only within the download window, so
+		// that a peer can't fill the buffer or displace a block we're waiting on. A peer that
+		// stalled on the block may still deliver it late, which isn't its fault, so we just
+		// drop it since another peer has been asked for it.
+		windowHeight := uint64(srv.blockchain.blockTip().Height) + BlockDownloadWindow
+		if downloadRequest == nil || blockHeader.Height > windowHeight {
+			if srv.blockDownloader.StalledOnPeer(pp, blockHash) {
+				glog.V(1).Infof("Server._handleBlock: Dropping block %v from peer %v because it "+
+					"arrived after we asked another peer for it", blockHash, pp)
+				return
+			}
+			glog.Errorf("Server._handleBlock: Dropping block %v at height %v from peer %v because "+
+				"we didn't ask the peer for it or it's past the download window", blockHash,
+				blockHeader.Height, pp)
+			pp.Misbehaving(PeerMisbehaviorUnrequestedBlock, fmt.Sprintf("Block %v", blockHash))
+			return
+		}
+
+		glog.V(1).Infof("Server._handleBlock: Holding block %v from peer %v until its parent "+
+			"is connected", blockHash, pp)
+		srv.blockDownloader.BufferBlock(pp, blk, blockHash)
+		srv.GetBlocks(pp, -1)
+		return
+	}
+
+	if !srv._processBlock(pp, blk, blockHash) {
+		return
+	}
+
+	// Connect the blocks that were waiting on this one, in order.
+	for bufferedBlock := srv.blockDownloader.PopBufferedChild(blockHash); bufferedBlock != nil; bufferedBlock =
+		srv.blockDownloader.PopBufferedChild(blockHash) {
+
+		if !srv._processBlock(bufferedBlock.Peer, bufferedBlock.Block, bufferedBlock.BlockHash) {
+			break
+		}
+		blockHash = bufferedBlock.BlockHash
+	}
 
 	// We shouldn't be receiving blocks while syncing headers.
 	if srv.blockchain.chainState() == SyncStateSyncingHeaders {
 		srv._logAndDisconnectPeer(
-			pp, blk,
+			pp, blk, PeerMisbehaviorUnrequestedBlock,
 			"We should never get blocks when we're syncing headers")
 		return
 	}
@@ -1937,6 +2482,10 @@ func (srv *Server) _processTransactions(pp *Peer, msg *MsgDeSoTransactionBundle)
 	// will eventually add it as opposed to just forgetting about it.
 	glog.V(2).Infof("Server._handleTransactionBundle: Processing message %v from "+
 		"peer %v", msg, pp)
+	// Verify the signatures of the whole bundle in parallel before taking the chain lock. The
+	// mempool then picks up the results from the signature cache.
+	PreVerifyTxnSignatures(msg.Transactions, nil)
+
 	transactionsToRelay := []*MempoolTx{}
 	for _, txn := range msg.Transactions {
 		// Process the transaction with rate-limiting while allowing unconnectedTxns and
@@ -1951,6 +2500,7 @@ func (srv *Server) _processTransactions(pp *Peer, msg *MsgDeSoTransactionBundle)
 				glog.Errorf(fmt.Sprintf("Server._handleTransactionBundle: Disconnecting "+
 					"Peer %v for sending us a transaction %v with fee below the minimum fee %d",
 					pp, txn, srv.mempool.minFeeRateNanosPerKB))
+				pp.Misbehaving(PeerMisbehaviorLowFeeTransaction, fmt.Sprintf("Transaction %v", txn.Hash()))
 				pp.Disconnect()
 			}
 
@@ -1975,6 +2525,11 @@ func (srv *Server) _handleTransactionBundle(pp *Peer, msg *MsgDeSoTransactionBun
 	glog.V(1).Infof("Server._handleTransactionBundle: Received TransactionBundle "+
 		"message of size %v from Peer %v", len(msg.Transactions), pp)
 
+	// A header-only node has no state to validate transactions against.
+	if srv.blockchain.headerOnly {
+		return
+	}
+
 	pp.AddDeSoMessage(msg, true /*inbound*/)
 }
 
@@ -1986,6 +2541,8 @@ func (srv *Server) _handleMempool(pp *Peer, msg *MsgDeSoMempool) {
 
 func (srv *Server) StartStatsdReporter() {
 	go func() {
+		// The traffic counters only go up, so we report what changed since the last report.
+		lastTraffic := make(map[MsgType]MsgTypeTraffic)
 	out:
 		for {
 			select {
@@ -2003,6 +2560,42 @@ func (srv *Server) StartStatsdReporter() {
 				headersHeight := srv.blockchain.HeaderTip().Height
 				srv.statsdClient.Gauge("HEADERS.HEIGHT", float64(headersHeight), tags, 1)
 
+				// Report the messages we dropped from peers for going over the rate limits
+				// or the payload size limits.
+				numRateLimited, numOversized := srv.cmgr.MessageLimits.TakeStats()
+				for msgType, count := range numRateLimited {
+					srv.statsdClient.Count("PEER_MESSAGES.RATE_LIMITED", int64(count),
+						[]string{"msg_type:" + msgType.String()}, 1)
+				}
+				for msgType, count := range numOversized {
+					srv.statsdClient.Count("PEER_MESSAGES.OVERSIZED", int64(count),
+						[]string{"msg_type:" + msgType.String()}, 1)
+				}
+
+				// Report our traffic with peers by message type, and how much of the daily upload cap we used.
+				traffic := srv.cmgr.Bandwidth.Traffic.Get()
+				for msgType, msgTraffic := range traffic {
+					msgTypeTags := []string{"msg_type:" + msgType.String()}
+					lastMsgTraffic := lastTraffic[msgType]
+					srv.statsdClient.Count("PEER_TRAFFIC.MESSAGES_SENT",
+						int64(msgTraffic.MessagesSent-lastMsgTraffic.MessagesSent), msgTypeTags, 1)
+					srv.statsdClient.Count("PEER_TRAFFIC.BYTES_SENT",
+						int64(msgTraffic.BytesSent-lastMsgTraffic.BytesSent), msgTypeTags, 1)
+					srv.statsdClient.Count("PEER_TRAFFIC.MESSAGES_RECEIVED",
+						int64(msgTraffic.MessagesReceived-lastMsgTraffic.MessagesReceived), msgTypeTags, 1)
+					srv.statsdClient.Count("PEER_TRAFFIC.BYTES_RECEIVED",
+						int64(msgTraffic.BytesReceived-lastMsgTraffic.BytesReceived), msgTypeTags, 1)
+				}
+				lastTraffic = traffic
+				uploadStats := srv.cmgr.Bandwidth.GetUploadStats(time.Now())
+				srv.statsdClient.Gauge("UPLOAD.BYTES_SENT_IN_CYCLE", float64(uploadStats.BytesSentInCycle), tags, 1)
+
+				// Report the block producers a header-only node couldn't check against the forbidden keys.
+				if srv.blockchain.IsHeaderOnly() {
+					srv.statsdClient.Count("HEADER_ONLY.UNCHECKED_FORBIDDEN_PRODUCERS",
+						int64(srv.blockchain.TakeNumUncheckedForbiddenBlockProducers()), tags, 1)
+				}
+
 			case <-srv.mempool.quit:
 				break out
 			}
@@ -2023,13 +2616,24 @@ func (srv *Server) _handleAddrMessage(pp *Peer, msg *MsgDeSoAddr) {
 			"Peer %v for sending us an addr message with %d transactions, which exceeds "+
 			"the max allowed %d",
 			pp, len(msg.AddrList), MaxAddrsPerAddrMsg))
+		pp.Misbehaving(PeerMisbehaviorOversizedAddrMessage, fmt.Sprintf("%d addresses", len(msg.AddrList)))
 		pp.Disconnect()
 		return
 	}
 
-	// Add all the addresses we received to the addrmgr.
+	// Add all the addresses we received to the addrmgr. Onion addresses are only
+	// added if we can connect to them through a proxy, but they're relayed either way.
 	netAddrsReceived := []*wire.NetAddress{}
 	for _, addr := range msg.AddrList {
+		if addr.IsOnion() {
+			if !srv.cmgr.UsesProxy() {
+				continue
+			}
+			if na := srv.cmgr.AddOnionAddr(addr.OnionAddr, addr.Port, addr.Services); na != nil {
+				netAddrsReceived = append(netAddrsReceived, na)
+			}
+			continue
+		}
 		addrAsNetAddr := wire.NewNetAddressIPPort(addr.IP, addr.Port, (wire.ServiceFlag)(addr.Services))
 		if !addrmgr.IsRoutable(addrAsNetAddr) {
 			glog.V(1).Infof("Dropping address %v from peer %v because it is not routable", addr, pp)
@@ -2078,11 +2682,9 @@ func (srv *Server) _handleGetAddrMessage(pp *Peer, msg *MsgDeSoGetAddr) {
 	// Convert the list to a SingleAddr list.
 	res := &MsgDeSoAddr{}
 	for _, netAddr := range netAddrsFound {
-		singleAddr := &SingleAddr{
-			Timestamp: time.Now(),
-			IP:        netAddr.IP,
-			Port:      netAddr.Port,
-			Services:  (ServiceFlag)(netAddr.Services),
+		singleAddr := srv.cmgr.NetAddrToSingleAddr(netAddr)
+		if singleAddr == nil {
+			continue
 		}
 		res.AddrList = append(res.AddrList, singleAddr)
 	}
@@ -2096,6 +2698,8 @@ func (srv *Server) _handleControlMessages(serverMessage *ServerMessage) (_should
 		srv._handleNewPeer(serverMessage.Peer)
 	case *MsgDeSoDonePeer:
 		srv._handleDonePeer(serverMessage.Peer)
+	case *MsgDeSoBlockDownloadTick:
+		srv._handleBlockDownloadTick()
 	case *MsgDeSoQuit:
 		return true
 	}
@@ -2115,10 +2719,22 @@ func (srv *Server) _handlePeerMessages(serverMessage *ServerMessage) {
 		srv._handleGetBlocks(serverMessage.Peer, msg)
 	case *MsgDeSoBlock:
 		srv._handleBlock(serverMessage.Peer, msg)
+	case *MsgDeSoCompactBlock:
+		srv._handleCompactBlock(serverMessage.Peer, msg)
+	case *MsgDeSoGetBlockTxns:
+		srv._handleGetBlockTxns(serverMessage.Peer, msg)
+	case *MsgDeSoBlockTxns:
+		srv._handleBlockTxns(serverMessage.Peer, msg)
+	case *MsgDeSoGetTxnProof:
+		srv._handleGetTxnProof(serverMessage.Peer, msg)
+	case *MsgDeSoTxnProof:
+		srv._handleTxnProof(serverMessage.Peer, msg)
 	case *MsgDeSoGetSnapshot:
 		srv._handleGetSnapshot(serverMessage.Peer, msg)
 	case *MsgDeSoSnapshotData:
 		srv._handleSnapshot(serverMessage.Peer, msg)
+	case *MsgDeSoGetStateChecksum:
+		srv._handleGetStateChecksum(serverMessage.Peer, msg)
 	case *MsgDeSoGetTransactions:
 		srv._handleGetTransactions(serverMessage.Peer, msg)
 	case *MsgDeSoTransactionBundle:
@@ -2214,16 +2830,47 @@ func (srv *Server) _getAddrsToBroadcast() []*SingleAddr {
 
 // Must be run inside a goroutine. Relays addresses to peers at regular intervals
 // and relays our own address to peers once every 24 hours.
+// _isAnonymousConnection returns true if the peer can't see our IP on the connection, which is when
+// we dialed it through the proxy, or when it connected to us through our onion service. Tor delivers
+// the connections to our onion service from the loopback interface. We only tell these peers our onion
+// address, since anyone else could link it to our IP.
+func (srv *Server) _isAnonymousConnection(pp *Peer) bool {
+	if pp.isOutbound {
+		return srv.cmgr.UsesProxy()
+	}
+	return pp.netAddr != nil && pp.netAddr.IP.IsLoopback()
+}
+
 func (srv *Server) _startAddressRelayer() {
 	for numMinutesPassed := 0; ; numMinutesPassed++ {
 		if atomic.LoadInt32(&srv.shutdown) >= 1 {
 			break
 		}
 		// For the first ten minutes after the server starts, relay our address to all
-		// peers. After the first ten minutes, do it once every 24 hours.
+		// peers. After the first ten minutes, do it once every 24 hours. A header-only
+		// node has nothing to serve, so it doesn't relay its address.
 		glog.V(1).Infof("Server.Start._startAddressRelayer: Relaying our own addr to peers")
-		if numMinutesPassed < 10 || numMinutesPassed%(RebroadcastNodeAddrIntervalMinutes) == 0 {
+		if !srv.blockchain.headerOnly &&
+			(numMinutesPassed < 10 || numMinutesPassed%(RebroadcastNodeAddrIntervalMinutes) == 0) {
 			for _, pp := range srv.cmgr.GetAllPeers() {
+				// Relay our onion address if we have one. Peers that don't support onion
+				// addresses have it filtered out before it's sent.
+				if srv.onionAddr != nil && srv._isAnonymousConnection(pp) {
+					pp.AddDeSoMessage(&MsgDeSoAddr{
+						AddrList: []*SingleAddr{
+							{
+								Timestamp: time.Now(),
+								OnionAddr: srv.onionAddr,
+								Port:      srv.onionPort,
+								Services:  pp.localServiceFlags(),
+							},
+						},
+					}, false)
+				}
+				// Don't reveal our IP if we connect to peers through a proxy.
+				if srv.cmgr.UsesProxy() {
+					continue
+				}
 				bestAddress := srv.cmgr.AddrMgr.GetBestLocalAddress(pp.netAddr)
 				if bestAddress != nil {
 					glog.V(2).Infof("Server.Start._startAddressRelayer: Relaying address %v to "+
@@ -2276,6 +2923,22 @@ func (srv *Server) _startTransactionRelayer() {
 	}
 }
 
+// _startBlockDownloadTicker periodically asks the messageHandler to check for stalled block
+// downloads and compact blocks.
+func (srv *Server) _startBlockDownloadTicker() {
+	for {
+		time.Sleep(BlockDownloadTickInterval)
+		if atomic.LoadInt32(&srv.shutdown) >= 1 {
+			return
+		}
+		// Skip the tick if the Server is backed up. We'll check again on the next one.
+		select {
+		case srv.incomingMessages <- &ServerMessage{Msg: &MsgDeSoBlockDownloadTick{}}:
+		default:
+		}
+	}
+}
+
 func (srv *Server) Stop() {
 	glog.Info("Server.Stop: Gracefully shutting down Server")
 
@@ -2287,6 +2950,12 @@ func (srv *Server) Stop() {
 	srv.cmgr.Stop()
 	glog.Infof(CLog(Yellow, "Server.Stop: Closed the ConnectionManger"))
 
+	// Take down our onion service.
+	if srv.torController != nil {
+		srv.torController.Close()
+		glog.Infof(CLog(Yellow, "Server.Stop: Closed the Tor controller"))
+	}
+
 	// Stop the miner if we have one running.
 	if srv.miner != nil {
 		srv.miner.Stop()
@@ -2306,6 +2975,18 @@ func (srv *Server) Stop() {
 		glog.Infof(CLog(Yellow, "Server.Stop: Closed Mempool"))
 	}
 
+	// Stop the state scrubber
+	if srv.stateScrubber != nil {
+		srv.stateScrubber.Stop()
+		glog.Infof(CLog(Yellow, "Server.Stop: Closed StateScrubber"))
+	}
+
+	// Stop the db maintenance
+	if srv.DBMaintainer != nil {
+		srv.DBMaintainer.Stop()
+		glog.Infof(CLog(Yellow, "Server.Stop: Closed DBMaintainer"))
+	}
+
 	// Stop the block producer
 	if srv.blockProducer != nil {
 		if srv.blockchain.MaxSyncBlockHeight == 0 {
@@ -2349,6 +3030,8 @@ func (srv *Server) Start() {
 
 	go srv._startTransactionRelayer()
 
+	go srv._startBlockDownloadTicker()
+
 	// Once the ConnectionManager is started, peers will be found and connected to and
 	// messages will begin to flow in to be processed.
 	if !srv.DisableNetworking {
@@ -2358,6 +3041,14 @@ func (srv *Server) Start() {
 	if srv.miner != nil && len(srv.miner.PublicKeys) > 0 {
 		go srv.miner.Start()
 	}
+
+	if srv.stateScrubber != nil {
+		srv.stateScrubber.Start()
+	}
+
+	if srv.DBMaintainer != nil {
+		srv.DBMaintainer.Start()
+	}
 }
 
 // SyncPrefixProgress keeps track of sync progress on an individual prefix. It is used in
@@ -0,0 +1,146 @@
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/decred/dcrd/lru"
+	"github.com/golang/glog"
+)
+
+// SignatureCacheSize is the number of verified signatures we remember. It comfortably
+// covers a full mempool plus the blocks that will eventually mine its transactions.
+const SignatureCacheSize uint = 100000 // 100K
+
+// SignatureCache remembers transactions whose ECDSA signature has already been checked.
+// The cache is keyed by the full transaction hash, which commits to the signature, and
+// stores the public key the signature was verified against. A cache hit therefore only
+// saves the elliptic curve math. Whether that key is allowed to sign for the transaction,
+// e.g. whether a derived key is authorized and hasn't expired, depends on the state and is
+// always checked by _verifySignature.
+type SignatureCache struct {
+	cache lru.KVCache
+}
+
+func NewSignatureCache(limit uint) *SignatureCache {
+	return &SignatureCache{
+		cache: lru.NewKVCache(limit),
+	}
+}
+
+// Contains returns true if the signature on the txn with the given hash was already
+// verified against signerPkBytes.
+func (sc *SignatureCache) Contains(txHash *BlockHash, signerPkBytes []byte) bool {
+	if txHash == nil {
+		return false
+	}
+	signerPk, exists := sc.cache.Lookup(*txHash)
+	if !exists {
+		return false
+	}
+	return bytes.Equal(signerPk.([]byte), signerPkBytes)
+}
+
+func (sc *SignatureCache) Add(txHash *BlockHash, signerPkBytes []byte) {
+	if txHash == nil {
+		return
+	}
+	sc.cache.Add(*txHash, signerPkBytes)
+}
+
+// globalSignatureCache is shared by every UtxoView. The mempool and the block connection
+// path validate the same transactions, so sharing the cache means a transaction's
+// signature is usually verified once, when it first enters the mempool.
+var globalSignatureCache = NewSignatureCache(SignatureCacheSize)
+
+// _txnSignerPublicKey returns the public key that's expected to have signed the txn. This is
+// the derived key if one is present in ExtraData and the owner public key otherwise.
+func _txnSignerPublicKey(txn *MsgDeSoTxn) []byte {
+	if derivedPkBytes, isDerived := IsDerivedSignature(txn); isDerived {
+		return derivedPkBytes
+	}
+	return txn.PublicKey
+}
+
+// _verifyTxnSignatureWithCache checks that the txn is signed by signerPk, consulting the
+// signature cache first and adding the txn to it on success. The txHash is the hash of the
+// full txn and may be nil, in which case the cache is skipped.
+func _verifyTxnSignatureWithCache(
+	txn *MsgDeSoTxn, txHash *BlockHash, signerPkBytes []byte, signerPk *btcec.PublicKey) (bool, error) {
+
+	if globalSignatureCache.Contains(txHash, signerPkBytes) {
+		return true, nil
+	}
+	if txn.Signature == nil {
+		return false, nil
+	}
+
+	// Compute a hash of the transaction without the signature.
+	txBytes, err := txn.ToBytes(true /*preSignature*/)
+	if err != nil {
+		return false, err
+	}
+	preSignatureHash := Sha256DoubleHash(txBytes)
+	if !txn.Signature.Verify(preSignatureHash[:], signerPk) {
+		return false, nil
+	}
+
+	globalSignatureCache.Add(txHash, signerPkBytes)
+	return true, nil
+}
+
+// PreVerifyTxnSignatures verifies the signatures of the passed txns on a pool of workers and
+// adds the valid ones to the signature cache. Nothing is returned. Invalid signatures are
+// simply left out of the cache and get reported with the usual rule errors when the txn is
+// connected. The txHashes must line up with txns. If txHashes is nil, the hashes are computed.
+func PreVerifyTxnSignatures(txns []*MsgDeSoTxn, txHashes []*BlockHash) {
+	if len(txns) == 0 {
+		return
+	}
+
+	numWorkers := runtime.NumCPU()
+	if numWorkers > len(txns) {
+		numWorkers = len(txns)
+	}
+
+	txnIndexChan := make(chan int, len(txns))
+	for ii := range txns {
+		txnIndexChan <- ii
+	}
+	close(txnIndexChan)
+
+	var wg sync.WaitGroup
+	for ii := 0; ii < numWorkers; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for txnIndex := range txnIndexChan {
+				txn := txns[txnIndex]
+				// Block rewards aren't signed.
+				if txn.TxnMeta == nil || txn.TxnMeta.GetTxnType() == TxnTypeBlockReward {
+					continue
+				}
+				var txHash *BlockHash
+				if txHashes != nil {
+					txHash = txHashes[txnIndex]
+				} else {
+					txHash = txn.Hash()
+				}
+				if txHash == nil {
+					continue
+				}
+				signerPkBytes := _txnSignerPublicKey(txn)
+				signerPk, err := btcec.ParsePubKey(signerPkBytes, btcec.S256())
+				if err != nil {
+					continue
+				}
+				if _, err = _verifyTxnSignatureWithCache(txn, txHash, signerPkBytes, signerPk); err != nil {
+					glog.V(2).Infof("PreVerifyTxnSignatures: Problem verifying txn %v: %v", txHash, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,70 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreVerifyTxnSignatures(t *testing.T) {
+	require := require.New(t)
+
+	_, params, db := NewTestBlockchain()
+	utxoView, err := NewUtxoView(db, params, nil, nil)
+	require.NoError(err)
+
+	newSignedTxn := func(amountNanos uint64) *MsgDeSoTxn {
+		txn := &MsgDeSoTxn{
+			TxInputs: []*DeSoInput{},
+			TxOutputs: []*DeSoOutput{{
+				PublicKey:   m1PkBytes,
+				AmountNanos: amountNanos,
+			}},
+			PublicKey: m0PkBytes,
+			TxnMeta:   &BasicTransferMetadata{},
+		}
+		_signTxn(t, txn, m0Priv)
+		return txn
+	}
+
+	// A few properly signed txns, one signed by the wrong key, and one with a signature
+	// that doesn't match the contents.
+	var txns []*MsgDeSoTxn
+	for ii := uint64(1); ii <= 10; ii++ {
+		txns = append(txns, newSignedTxn(ii))
+	}
+	wrongKeyTxn := newSignedTxn(11)
+	_signTxn(t, wrongKeyTxn, m1Priv)
+	txns = append(txns, wrongKeyTxn)
+	tamperedTxn := newSignedTxn(12)
+	tamperedTxn.TxOutputs[0].AmountNanos = 13
+	txns = append(txns, tamperedTxn)
+
+	var txHashes []*BlockHash
+	for _, txn := range txns {
+		txHashes = append(txHashes, txn.Hash())
+	}
+	PreVerifyTxnSignatures(txns, txHashes)
+
+	for ii, txn := range txns {
+		isCached := globalSignatureCache.Contains(txHashes[ii], m0PkBytes)
+		_, err := utxoView._verifySignature(txn, txHashes[ii], 1)
+		if txn == wrongKeyTxn || txn == tamperedTxn {
+			require.False(isCached)
+			require.Error(err)
+			require.Contains(err.Error(), string(RuleErrorInvalidTransactionSignature))
+		} else {
+			require.True(isCached)
+			require.NoError(err)
+		}
+	}
+
+	// A cached signature is only good for the key it was verified against.
+	require.False(globalSignatureCache.Contains(txHashes[0], m1PkBytes))
+
+	// Verifying without the cache gives the same results.
+	for ii, txn := range txns {
+		_, err := utxoView._verifySignature(txn, nil, 1)
+		require.Equal(ii < 10, err == nil)
+	}
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/cloudflare/circl/group"
 	"github.com/decred/dcrd/lru"
 	"github.com/deso-protocol/go-deadlock"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/fatih/color"
 	"github.com/golang/glog"
 	"github.com/oleiade/lane"
@@ -50,6 +49,16 @@ var (
 	_prefixOperationChannelStatus = []byte{4}
 
 	_prefixMigrationStatus = []byte{5}
+
+	// This prefix saves the progress of encoder migrations, so that they can be resumed if the node was terminated
+	// midway through the migration.
+	// 	<prefix [1]byte> -> <EncoderMigrationProgress>
+	_prefixMigrationProgress = []byte{6}
+
+	// This prefix saves the per-prefix state checksums maintained for the StateScrubber, together with the block
+	// height at which their records were encoded. They're saved alongside the checksum after a flush.
+	// 	<prefix [1]byte> -> <blockHeight uvarint, numPrefixes uvarint, [<prefix byte, checksum bytes>]...>
+	_prefixSnapshotPrefixChecksums = []byte{7}
 )
 
 // -------------------------------------------------------------------------------------
@@ -90,7 +99,7 @@ var (
 // 	- serving snapshot chunks to syncing nodes.
 type Snapshot struct {
 	// SnapshotDb is used to store snapshot-related records.
-	SnapshotDb      *badger.DB
+	SnapshotDb      KVStore
 	SnapshotDbMutex *sync.Mutex
 	// AncestralMemory stores information about the ancestral records that should be flushed into the db.
 	// We use a concurrency-safe deque which allows us to push objects to the end of the AncestralMemory
@@ -133,7 +142,7 @@ type Snapshot struct {
 	// down incorrectly.
 	Status *SnapshotStatus
 
-	mainDb *badger.DB
+	mainDb KVStore
 	params *DeSoParams
 
 	isTxIndex       bool
@@ -149,14 +158,14 @@ type Snapshot struct {
 }
 
 // NewSnapshot creates a new snapshot instance.
-func NewSnapshot(mainDb *badger.DB, mainDbDirectory string, snapshotBlockHeightPeriod uint64, isTxIndex bool,
+func NewSnapshot(mainDb KVStore, mainDbDirectory string, snapshotBlockHeightPeriod uint64, isTxIndex bool,
 	disableChecksum bool, params *DeSoParams, disableMigrations bool) (_snap *Snapshot, _err error, _shouldRestart bool) {
 
 	// Initialize the ancestral records database
 	snapshotDirectory := filepath.Join(GetBadgerDbPath(mainDbDirectory), "snapshot")
 	snapshotOpts := PerformanceBadgerOptions(snapshotDirectory)
 	snapshotOpts.ValueDir = GetBadgerDbPath(snapshotDirectory)
-	snapshotDb, err := badger.Open(snapshotOpts)
+	snapshotDb, err := OpenBadgerKVStore(snapshotOpts)
 	if err != nil {
 		return nil, errors.Wrapf(err, "NewSnapshot: Problem creating SnapshotDb"), true
 	}
@@ -288,6 +297,13 @@ func (snap *Snapshot) Run() {
 			}
 			glog.V(2).Infof("Snapshot.Run: PrintText (%s) Current checksum (%v)", operation.printText, stateChecksum)
 
+		case SnapshotOperationChecksumCheckpoint:
+			checkpoint, err := snap.getChecksumCheckpoint(operation.checkpointScrubBoundary)
+			if err != nil {
+				glog.Errorf("Snapshot.Run: Problem getting checksum checkpoint (%v)", err)
+			}
+			operation.checkpointChannel <- checkpoint
+
 		case SnapshotOperationExit:
 			glog.V(2).Infof("Snapshot.Run: Exiting the operation loop")
 			if err := snap.Checksum.Wait(); err != nil {
@@ -465,7 +481,7 @@ func (snap *Snapshot) FinishProcessBlock(blockNode *BlockNode) {
 	})
 }
 
-func (snap *Snapshot) ProcessSnapshotChunk(mainDb *badger.DB, mainDbMutex *deadlock.RWMutex,
+func (snap *Snapshot) ProcessSnapshotChunk(mainDb KVStore, mainDbMutex *deadlock.RWMutex,
 	snapshotChunk []*DBEntry, blockHeight uint64) {
 	snap.OperationChannel.EnqueueOperation(&SnapshotOperation{
 		operationType: SnapshotOperationProcessChunk,
@@ -492,6 +508,43 @@ func (snap *Snapshot) RemoveChecksumBytes(key []byte, value []byte) {
 	})
 }
 
+// GetChecksumCheckpoint returns the state checksum as of the moment this function was called. The checkpoint is
+// computed by the snapshot Run loop, so it reflects all checksum operations enqueued prior to the call. The caller
+// should make sure no main db writes are happening concurrently, e.g. by holding the ChainLock while enqueueing.
+// Changes to keys below scrubBoundary that happen after this checkpoint are reported separately in the
+// ScrubbedPrefixDeltas of the next one. A nil scrubBoundary is unbounded and covers all keys, while an empty one
+// covers none, for callers that don't need them.
+// It returns a channel on which the checkpoint will be sent; the checkpoint will be nil if something went wrong.
+func (snap *Snapshot) GetChecksumCheckpoint(scrubBoundary []byte) chan *StateChecksumCheckpoint {
+	checkpointChannel := make(chan *StateChecksumCheckpoint, 1)
+	snap.OperationChannel.EnqueueOperation(&SnapshotOperation{
+		operationType:           SnapshotOperationChecksumCheckpoint,
+		checkpointChannel:       checkpointChannel,
+		checkpointScrubBoundary: scrubBoundary,
+	})
+	return checkpointChannel
+}
+
+// getChecksumCheckpoint should only be called from the snapshot Run loop.
+func (snap *Snapshot) getChecksumCheckpoint(scrubBoundary []byte) (*StateChecksumCheckpoint, error) {
+	// GetChecksum waits for all checksum workers to finish so that the checksum is up-to-date.
+	checksum, err := snap.Checksum.GetChecksum()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Snapshot.getChecksumCheckpoint: Problem getting checksum")
+	}
+	prefixDeltas, scrubbedPrefixDeltas, prefixDeltasValid := snap.Checksum.PopPrefixDeltas(scrubBoundary)
+	prefixChecksums, prefixChecksumsHeight := snap.Checksum.GetPrefixChecksums()
+	return &StateChecksumCheckpoint{
+		Checksum:              checksum,
+		BlockHeight:           snap.Status.CurrentBlockHeight,
+		PrefixDeltas:          prefixDeltas,
+		ScrubbedPrefixDeltas:  scrubbedPrefixDeltas,
+		PrefixDeltasValid:     prefixDeltasValid,
+		PrefixChecksums:       prefixChecksums,
+		PrefixChecksumsHeight: prefixChecksumsHeight,
+	}, nil
+}
+
 // WaitForAllOperationsToFinish will busy-wait for the snapshot channel to process all
 // current operations. Spinlocks are undesired but it's the easiest solution in this case,
 func (snap *Snapshot) WaitForAllOperationsToFinish() {
@@ -632,15 +685,11 @@ func (snap *Snapshot) FlushAncestralRecords() {
 
 	// We launch a new read-write transaction to set the records.
 	snap.SnapshotDbMutex.Lock()
-	err = snap.SnapshotDb.Update(func(txn *badger.Txn) error {
+	err = snap.SnapshotDb.Update(func(txn KVTxn) error {
 		// This update is called after a change to the main db records and so the current checksum reflects the state of
 		// the main db. In case we restart the node, we want to be able to retrieve the most recent checksum and resume
 		// from it when adding new records. Therefore, we save the current checksum bytes in the db.
-		currentChecksum, err := snap.Checksum.ToBytes()
-		if err != nil {
-			return errors.Wrapf(err, "Snapshot.StartAncestralRecordsFlush: Problem getting checksum bytes")
-		}
-		err = txn.Set(_prefixSnapshotChecksum, currentChecksum)
+		err := snap.Checksum.saveChecksumWithTxn(txn)
 		if err != nil {
 			return errors.Wrapf(err, "Snapshot.StartAncestralRecordsFlush: Problem flushing checksum bytes")
 		}
@@ -656,9 +705,9 @@ func (snap *Snapshot) FlushAncestralRecords() {
 			}
 
 			// We check whether this record is already present in ancestral records,
-			// if so then there's nothing to do. What we want is err == badger.ErrKeyNotFound
+			// if so then there's nothing to do. What we want is err == ErrKVKeyNotFound
 			_, err = snap.GetAncestralRecordsKeyWithTxn(txn, keyBytes, blockHeight)
-			if err != badger.ErrKeyNotFound {
+			if err != ErrKVKeyNotFound {
 				if err != nil {
 					// In this case, we hit a real error with Badger, so we should return.
 					return errors.Wrapf(err, "Snapshot.StartAncestralRecordsFlush: Problem "+
@@ -717,9 +766,8 @@ func (snap *Snapshot) DeleteAncestralRecords(height uint64) error {
 	defer snap.SnapshotDbMutex.Unlock()
 
 	var keys [][]byte
-	err := snap.SnapshotDb.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.AllVersions = false
+	err := snap.SnapshotDb.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
 		opts.PrefetchValues = false
 		// Iterate over the prefix as long as there are valid keys in the DB.
 		it := txn.NewIterator(opts)
@@ -734,7 +782,7 @@ func (snap *Snapshot) DeleteAncestralRecords(height uint64) error {
 	if err != nil {
 		return errors.Wrapf(err, "DeleteAncestralRecords: Problem iterating through the height")
 	}
-	err = snap.SnapshotDb.Update(func(txn *badger.Txn) error {
+	err = snap.SnapshotDb.Update(func(txn KVTxn) error {
 		for _, key := range keys {
 			err := txn.Delete(key)
 			if err != nil {
@@ -768,8 +816,8 @@ func (snap *Snapshot) GetAncestralRecordsKey(key []byte, blockHeight uint64) []b
 }
 
 // GetAncestralRecordsKey is warpper around an ancestral record.
-func (snap *Snapshot) GetAncestralRecordsKeyWithTxn(txn *badger.Txn, key []byte, blockHeight uint64) (
-	_record *badger.Item, _err error) {
+func (snap *Snapshot) GetAncestralRecordsKeyWithTxn(txn KVTxn, key []byte, blockHeight uint64) (
+	_record KVItem, _err error) {
 
 	recordsKey := snap.GetAncestralRecordsKey(key, blockHeight)
 	return txn.Get(recordsKey)
@@ -781,7 +829,7 @@ func (snap *Snapshot) GetAncestralRecordsKeyWithTxn(txn *badger.Txn, key []byte,
 // need to create this distinction to tell the difference between a record that was
 // updated to have an *empty* value vs a record that was deleted entirely.
 func (snap *Snapshot) DBSetAncestralRecordWithTxn(
-	txn *badger.Txn, blockHeight uint64, keyBytes []byte, value *AncestralRecordValue) error {
+	txn KVTxn, blockHeight uint64, keyBytes []byte, value *AncestralRecordValue) error {
 
 	if value.Existed {
 		return txn.Set(snap.GetAncestralRecordsKey(keyBytes, blockHeight), append(value.Value, byte(1)))
@@ -863,7 +911,7 @@ func (snap *Snapshot) SnapshotProcessBlock(blockNode *BlockNode) {
 				continue
 			}
 			snap.SnapshotDbMutex.Lock()
-			err = snap.SnapshotDb.Update(func(txn *badger.Txn) error {
+			err = snap.SnapshotDb.Update(func(txn KVTxn) error {
 				return txn.Set(_prefixLastEpochMetadata, snap.CurrentEpochSnapshotMetadata.ToBytes())
 			})
 			snap.SnapshotDbMutex.Unlock()
@@ -905,7 +953,7 @@ func (snap *Snapshot) String() string {
 // GetSnapshotChunk fetches a batch of records from the nodes DB that match the provided prefix and
 // have a key at least equal to the startKey lexicographically. The function will also fetch ancestral
 // records and combine them with the DB records so that the batch reflects an ancestral block.
-func (snap *Snapshot) GetSnapshotChunk(mainDb *badger.DB, prefix []byte, startKey []byte) (
+func (snap *Snapshot) GetSnapshotChunk(mainDb KVStore, prefix []byte, startKey []byte) (
 	_snapshotEntriesBatch []*DBEntry, _snapshotEntriesFilled bool, _concurrencyFault bool, _err error) {
 
 	// Check if we're flushing to the main db or to the ancestral records. If a flush is currently
@@ -1005,7 +1053,7 @@ func (snap *Snapshot) GetSnapshotChunk(mainDb *badger.DB, prefix []byte, startKe
 }
 
 // SetSnapshotChunk is called to put the snapshot chunk that we've got from a peer in the database.
-func (snap *Snapshot) SetSnapshotChunk(mainDb *badger.DB, mainDbMutex *deadlock.RWMutex,
+func (snap *Snapshot) SetSnapshotChunk(mainDb KVStore, mainDbMutex *deadlock.RWMutex,
 	chunk []*DBEntry, blockHeight uint64) error {
 
 	var err error
@@ -1165,12 +1213,31 @@ type StateChecksum struct {
 	// maxWorkers is the maximum number of workers we can have in the worker pool.
 	maxWorkers int64
 
-	snapshotDb      *badger.DB
+	// prefixDeltas keeps track of the points added to the checksum per state prefix, since the last call to
+	// PopPrefixDeltas. It is only maintained after EnablePrefixDeltas is called, and is used by the StateScrubber
+	// to attribute checksum mismatches to individual prefixes. prefixDeltasValid is set to false whenever the
+	// checksum is overwritten, e.g. in ResetChecksum or FromBytes, because then the deltas no longer add up.
+	prefixDeltas      map[byte]group.Element
+	prefixDeltasValid bool
+	// scrubbedPrefixDeltas are the part of the prefixDeltas made to keys below scrubBoundary. The StateScrubber
+	// hashes the state in batches, and sets the boundary to the end of its latest batch so that it can update the
+	// batches it has already hashed with the changes made since. A nil boundary covers all keys.
+	scrubbedPrefixDeltas map[byte]group.Element
+	scrubBoundary        []byte
+	// prefixChecksums are the per-prefix checksums of the state. They're seeded by the StateScrubber with the
+	// checksums it computed from the db, and are then kept up to date with every record added to the checksum.
+	// They're persisted together with the checksum, so that the scrubber can attribute a mismatch to its prefix
+	// even on the first scrub after a restart. prefixChecksumsHeight is the block height at which the seeded
+	// records were encoded. The prefixChecksums are nil until seeded, and are dropped when the checksum is overwritten.
+	prefixChecksums       map[byte]group.Element
+	prefixChecksumsHeight uint64
+
+	snapshotDb      KVStore
 	snapshotDbMutex *sync.Mutex
 }
 
 // Initialize starts the state checksum by initializing it to the identity element.
-func (sc *StateChecksum) Initialize(snapshotDb *badger.DB, snapshotDbMutex *sync.Mutex) error {
+func (sc *StateChecksum) Initialize(snapshotDb KVStore, snapshotDbMutex *sync.Mutex) error {
 	// Set the elliptic curve group to Ristretto255 and initialize checksum as identity.
 	sc.curve = group.Ristretto255
 	sc.checksum = sc.curve.Identity()
@@ -1198,7 +1265,7 @@ func (sc *StateChecksum) Initialize(snapshotDb *badger.DB, snapshotDbMutex *sync
 	defer sc.snapshotDbMutex.Unlock()
 
 	// Get snapshot checksum from the db.
-	err := sc.snapshotDb.View(func(txn *badger.Txn) error {
+	err := sc.snapshotDb.View(func(txn KVTxn) error {
 		item, err := txn.Get(_prefixSnapshotChecksum)
 		if err != nil {
 			return err
@@ -1208,9 +1275,25 @@ func (sc *StateChecksum) Initialize(snapshotDb *badger.DB, snapshotDbMutex *sync
 			return err
 		}
 		// If we get here, it means we've saved a checksum in the db, so we will set it to the checksum.
-		return sc.FromBytes(value)
+		if err = sc.FromBytes(value); err != nil {
+			return err
+		}
+
+		// The per-prefix checksums are saved in the same transaction as the checksum, so they match it.
+		item, err = txn.Get(_prefixSnapshotPrefixChecksums)
+		if err == ErrKVKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		return sc.prefixChecksumsFromBytes(value)
 	})
-	if err != nil && err != badger.ErrKeyNotFound {
+	if err != nil && err != ErrKVKeyNotFound {
 		return errors.Wrapf(err, "StateChecksum.Initialize: Problem reading checksum from the db")
 	}
 	return nil
@@ -1220,17 +1303,103 @@ func (sc *StateChecksum) SaveChecksum() error {
 	sc.snapshotDbMutex.Lock()
 	defer sc.snapshotDbMutex.Unlock()
 
-	return sc.snapshotDb.Update(func(txn *badger.Txn) error {
-		checksumBytes, err := sc.ToBytes()
+	return sc.snapshotDb.Update(func(txn KVTxn) error {
+		return sc.saveChecksumWithTxn(txn)
+	})
+}
+
+// saveChecksumWithTxn saves the checksum and the per-prefix checksums in the snapshot db.
+func (sc *StateChecksum) saveChecksumWithTxn(txn KVTxn) error {
+	// Wait for all the worker threads to finish, and hold the add mutex so that the checksum
+	// and the per-prefix checksums are saved at the same point.
+	if err := sc.semaphore.Acquire(sc.ctx, sc.maxWorkers); err != nil {
+		return errors.Wrapf(err, "StateChecksum.saveChecksumWithTxn: problem acquiring semaphore")
+	}
+	defer sc.semaphore.Release(sc.maxWorkers)
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+
+	checksumBytes, err := sc.checksum.MarshalBinary()
+	if err != nil {
+		return errors.Wrapf(err, "StateChecksum.saveChecksumWithTxn: Problem getting checksum bytes")
+	}
+	if err = txn.Set(_prefixSnapshotChecksum, checksumBytes); err != nil {
+		return errors.Wrapf(err, "StateChecksum.saveChecksumWithTxn: Problem setting checksum bytes")
+	}
+
+	if sc.prefixChecksums == nil {
+		return txn.Delete(_prefixSnapshotPrefixChecksums)
+	}
+	prefixChecksumsBytes, err := sc.prefixChecksumsToBytes()
+	if err != nil {
+		return errors.Wrapf(err, "StateChecksum.saveChecksumWithTxn: Problem getting per-prefix checksum bytes")
+	}
+	return txn.Set(_prefixSnapshotPrefixChecksums, prefixChecksumsBytes)
+}
+
+// prefixChecksumsToBytes encodes the per-prefix checksums. The caller should hold the add mutex.
+func (sc *StateChecksum) prefixChecksumsToBytes() ([]byte, error) {
+	prefixes := make([]byte, 0, len(sc.prefixChecksums))
+	for prefix := range sc.prefixChecksums {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(ii, jj int) bool {
+		return prefixes[ii] < prefixes[jj]
+	})
+
+	data := UintToBuf(sc.prefixChecksumsHeight)
+	data = append(data, UintToBuf(uint64(len(prefixes)))...)
+	for _, prefix := range prefixes {
+		checksumBytes, err := sc.prefixChecksums[prefix].MarshalBinary()
 		if err != nil {
-			return errors.Wrapf(err, "StateChecksum.SaveChecksum: Problem getting checksum bytes")
+			return nil, errors.Wrapf(err, "StateChecksum.prefixChecksumsToBytes: Problem encoding "+
+				"checksum of prefix (%v)", prefix)
 		}
-		return txn.Set(_prefixSnapshotChecksum, checksumBytes)
-	})
+		data = append(data, prefix)
+		data = append(data, EncodeByteArray(checksumBytes)...)
+	}
+	return data, nil
+}
+
+// prefixChecksumsFromBytes decodes the per-prefix checksums encoded with prefixChecksumsToBytes.
+func (sc *StateChecksum) prefixChecksumsFromBytes(data []byte) error {
+	rr := bytes.NewReader(data)
+	blockHeight, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "StateChecksum.prefixChecksumsFromBytes: Problem reading block height")
+	}
+	numPrefixes, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "StateChecksum.prefixChecksumsFromBytes: Problem reading number of prefixes")
+	}
+	prefixChecksums := make(map[byte]group.Element)
+	for ii := uint64(0); ii < numPrefixes; ii++ {
+		prefix, err := rr.ReadByte()
+		if err != nil {
+			return errors.Wrapf(err, "StateChecksum.prefixChecksumsFromBytes: Problem reading prefix")
+		}
+		checksumBytes, err := DecodeByteArray(rr)
+		if err != nil {
+			return errors.Wrapf(err, "StateChecksum.prefixChecksumsFromBytes: Problem reading checksum bytes")
+		}
+		prefixChecksum := group.Ristretto255.Identity()
+		if err = prefixChecksum.UnmarshalBinary(checksumBytes); err != nil {
+			return errors.Wrapf(err, "StateChecksum.prefixChecksumsFromBytes: Problem decoding "+
+				"checksum of prefix (%v)", prefix)
+		}
+		prefixChecksums[prefix] = prefixChecksum
+	}
+
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+	sc.prefixChecksums = prefixChecksums
+	sc.prefixChecksumsHeight = blockHeight
+	return nil
 }
 
 func (sc *StateChecksum) ResetChecksum() {
 	sc.checksum = sc.curve.Identity()
+	sc.invalidatePrefixDeltas()
 }
 
 func (sc *StateChecksum) AddToChecksum(elem group.Element) {
@@ -1240,6 +1409,122 @@ func (sc *StateChecksum) AddToChecksum(elem group.Element) {
 	sc.checksum.Add(sc.checksum, elem)
 }
 
+// addToChecksumWithKey adds the element of the record with the given key to the checksum, and to the prefix
+// deltas if they're enabled.
+func (sc *StateChecksum) addToChecksumWithKey(key []byte, elem group.Element) {
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+
+	sc.checksum.Add(sc.checksum, elem)
+	if sc.prefixChecksums != nil {
+		addToPrefixDelta(sc.prefixChecksums, key[0], elem)
+	}
+	if sc.prefixDeltas == nil {
+		return
+	}
+	addToPrefixDelta(sc.prefixDeltas, key[0], elem)
+	if sc.scrubBoundary == nil || bytes.Compare(key, sc.scrubBoundary) < 0 {
+		addToPrefixDelta(sc.scrubbedPrefixDeltas, key[0], elem)
+	}
+}
+
+// addToPrefixDelta adds the element to the delta of the prefix.
+func addToPrefixDelta(prefixDeltas map[byte]group.Element, prefix byte, elem group.Element) {
+	if _, exists := prefixDeltas[prefix]; !exists {
+		prefixDeltas[prefix] = group.Ristretto255.Identity()
+	}
+	prefixDeltas[prefix].Add(prefixDeltas[prefix], elem)
+}
+
+// EnablePrefixDeltas starts tracking the per-prefix changes to the checksum. The deltas will only be
+// considered valid after the first call to PopPrefixDeltas.
+func (sc *StateChecksum) EnablePrefixDeltas() {
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+
+	if sc.prefixDeltas != nil {
+		return
+	}
+	sc.prefixDeltas = make(map[byte]group.Element)
+	sc.scrubbedPrefixDeltas = make(map[byte]group.Element)
+	sc.scrubBoundary = []byte{}
+	sc.prefixDeltasValid = false
+}
+
+// PopPrefixDeltas returns the per-prefix changes to the checksum since the last call and resets them. It also
+// returns the part of the changes made to keys below the scrub boundary from the last call, and replaces the
+// boundary with the new scrubBoundary. The returned boolean is false if the deltas can't be trusted, e.g. because
+// the checksum was overwritten.
+func (sc *StateChecksum) PopPrefixDeltas(scrubBoundary []byte) (_prefixDeltas map[byte]group.Element,
+	_scrubbedPrefixDeltas map[byte]group.Element, _valid bool) {
+
+	if err := sc.Wait(); err != nil {
+		glog.Errorf("StateChecksum.PopPrefixDeltas: Problem waiting for the checksum, error (%v)", err)
+		return nil, nil, false
+	}
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+
+	if sc.prefixDeltas == nil {
+		return nil, nil, false
+	}
+	prefixDeltas, scrubbedPrefixDeltas, valid := sc.prefixDeltas, sc.scrubbedPrefixDeltas, sc.prefixDeltasValid
+	sc.prefixDeltas = make(map[byte]group.Element)
+	sc.scrubbedPrefixDeltas = make(map[byte]group.Element)
+	sc.scrubBoundary = scrubBoundary
+	sc.prefixDeltasValid = true
+	return prefixDeltas, scrubbedPrefixDeltas, valid
+}
+
+// SetPrefixChecksums seeds the per-prefix checksums with the checksums of the state as of the last call to
+// PopPrefixDeltas, encoded at blockHeight. The changes made since are added on top. The per-prefix checksums are
+// dropped instead if the checksum was overwritten since, as the changes then no longer add up.
+func (sc *StateChecksum) SetPrefixChecksums(prefixChecksums map[byte]group.Element, blockHeight uint64) {
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+
+	if sc.prefixDeltas == nil || !sc.prefixDeltasValid {
+		sc.prefixChecksums = nil
+		return
+	}
+	sc.prefixChecksums = make(map[byte]group.Element)
+	for prefix, prefixChecksum := range prefixChecksums {
+		addToPrefixDelta(sc.prefixChecksums, prefix, prefixChecksum)
+	}
+	for prefix, delta := range sc.prefixDeltas {
+		addToPrefixDelta(sc.prefixChecksums, prefix, delta)
+	}
+	sc.prefixChecksumsHeight = blockHeight
+}
+
+// GetPrefixChecksums returns a copy of the per-prefix checksums, and the block height at which they were seeded.
+// The returned map is nil if the per-prefix checksums aren't known.
+func (sc *StateChecksum) GetPrefixChecksums() (_prefixChecksums map[byte]group.Element, _blockHeight uint64) {
+	if err := sc.Wait(); err != nil {
+		glog.Errorf("StateChecksum.GetPrefixChecksums: Problem waiting for the checksum, error (%v)", err)
+		return nil, 0
+	}
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+
+	if sc.prefixChecksums == nil {
+		return nil, 0
+	}
+	prefixChecksums := make(map[byte]group.Element)
+	for prefix, prefixChecksum := range sc.prefixChecksums {
+		addToPrefixDelta(prefixChecksums, prefix, prefixChecksum)
+	}
+	return prefixChecksums, sc.prefixChecksumsHeight
+}
+
+func (sc *StateChecksum) invalidatePrefixDeltas() {
+	sc.addMutex.Lock()
+	defer sc.addMutex.Unlock()
+
+	sc.prefixDeltasValid = false
+	sc.prefixChecksums = nil
+}
+
 func (sc *StateChecksum) HashToCurve(bytes []byte) group.Element {
 	var hashElement group.Element
 
@@ -1365,7 +1650,7 @@ func (sc *StateChecksum) AddOrRemoveBytesWithMigrations(keyInput []byte, valueIn
 		}
 
 		// Now add everything.
-		sc.AddToChecksum(hashElements[0])
+		sc.addToChecksumWithKey(key, hashElements[0])
 		for ii, index := range encodingsMapping {
 			encoderMigrationChecksums[ii].Checksum.AddToChecksum(hashElements[index])
 		}
@@ -1432,6 +1717,7 @@ func (sc *StateChecksum) FromBytes(checksumBytes []byte) error {
 	if err != nil {
 		return errors.Wrapf(err, "StateChecksum.FromBytes: Problem setting checksum from bytes")
 	}
+	sc.invalidatePrefixDeltas()
 	return nil
 }
 
@@ -1524,11 +1810,11 @@ type SnapshotEpochMetadata struct {
 
 	updateMutex sync.Mutex
 
-	snapshotDb      *badger.DB
+	snapshotDb      KVStore
 	snapshotDbMutex *sync.Mutex
 }
 
-func (metadata *SnapshotEpochMetadata) Initialize(snapshotDb *badger.DB, snapshotDbMutex *sync.Mutex) error {
+func (metadata *SnapshotEpochMetadata) Initialize(snapshotDb KVStore, snapshotDbMutex *sync.Mutex) error {
 	metadata.SnapshotBlockHeight = uint64(0)
 	metadata.FirstSnapshotBlockHeight = uint64(0)
 	metadata.CurrentEpochChecksumBytes = []byte{}
@@ -1544,7 +1830,7 @@ func (metadata *SnapshotEpochMetadata) Initialize(snapshotDb *badger.DB, snapsho
 	metadata.snapshotDbMutex.Lock()
 	defer metadata.snapshotDbMutex.Unlock()
 
-	err := snapshotDb.View(func(txn *badger.Txn) error {
+	err := snapshotDb.View(func(txn KVTxn) error {
 		// Now get the last epoch metadata.
 		item, err := txn.Get(_prefixLastEpochMetadata)
 		if err != nil {
@@ -1558,8 +1844,8 @@ func (metadata *SnapshotEpochMetadata) Initialize(snapshotDb *badger.DB, snapsho
 		return metadata.FromBytes(rr)
 	})
 	// If we're starting the hyper sync node for the first time, then there will be no snapshot saved
-	// and we'll get ErrKeyNotFound error. That's why we don't error when it happens.
-	if err != nil && err != badger.ErrKeyNotFound {
+	// and we'll get ErrKVKeyNotFound error. That's why we don't error when it happens.
+	if err != nil && err != ErrKVKeyNotFound {
 		return errors.Wrapf(err, "Snapshot.NewSnapshot: Problem retrieving snapshot information from db")
 	}
 	return nil
@@ -1679,6 +1965,9 @@ const (
 	SnapshotOperationChecksumRemove
 	// SnapshotOperationChecksumPrint is called when we want to print the state checksum.
 	SnapshotOperationChecksumPrint
+	// SnapshotOperationChecksumCheckpoint is enqueued when we want to read the state checksum after all previously
+	// enqueued checksum operations have been applied, e.g. by the StateScrubber.
+	SnapshotOperationChecksumCheckpoint
 	// SnapshotOperationExit is used to quit the snapshot loop
 	SnapshotOperationExit
 )
@@ -1694,7 +1983,7 @@ type SnapshotOperation struct {
 
 	/* SnapshotOperationProcessChunk */
 	// mainDb is the main db instance.
-	mainDb      *badger.DB
+	mainDb      KVStore
 	mainDbMutex *deadlock.RWMutex
 	// snapshotChunk is the snapshot chunk received from the peer.
 	snapshotChunk []*DBEntry
@@ -1709,6 +1998,12 @@ type SnapshotOperation struct {
 	/* SnapshotOperationChecksumPrint */
 	// printText is the text we want to put in the print statement.
 	printText string
+
+	/* SnapshotOperationChecksumCheckpoint */
+	// checkpointChannel is used to return the state checksum once all prior operations have been processed.
+	checkpointChannel chan *StateChecksumCheckpoint
+	// checkpointScrubBoundary is the new scrub boundary, see StateChecksum.PopPrefixDeltas.
+	checkpointScrubBoundary []byte
 }
 
 type SnapshotOperationChannel struct {
@@ -1717,11 +2012,11 @@ type SnapshotOperationChannel struct {
 	StateSemaphore     int32
 	StateSemaphoreLock sync.Mutex
 
-	snapshotDb      *badger.DB
+	snapshotDb      KVStore
 	snapshotDbMutex *sync.Mutex
 }
 
-func (opChan *SnapshotOperationChannel) Initialize(snapshotDb *badger.DB, snapshotDbMutex *sync.Mutex) error {
+func (opChan *SnapshotOperationChannel) Initialize(snapshotDb KVStore, snapshotDbMutex *sync.Mutex) error {
 	opChan.OperationChannel = make(chan *SnapshotOperation, 100000)
 	opChan.StateSemaphore = 0
 
@@ -1734,7 +2029,7 @@ func (opChan *SnapshotOperationChannel) Initialize(snapshotDb *badger.DB, snapsh
 	}
 	opChan.snapshotDbMutex.Lock()
 	defer opChan.snapshotDbMutex.Unlock()
-	err := snapshotDb.View(func(txn *badger.Txn) error {
+	err := snapshotDb.View(func(txn KVTxn) error {
 		item, err := txn.Get(_prefixOperationChannelStatus)
 		if err != nil {
 			return err
@@ -1751,7 +2046,7 @@ func (opChan *SnapshotOperationChannel) Initialize(snapshotDb *badger.DB, snapsh
 		opChan.StateSemaphore = int32(stateSemaphore)
 		return nil
 	})
-	if err != nil && err != badger.ErrKeyNotFound {
+	if err != nil && err != ErrKVKeyNotFound {
 		return errors.Wrapf(err, "SnapshotOperationChannel.Initialize: Problem reading StateSemaphore from db")
 	}
 
@@ -1762,7 +2057,7 @@ func (opChan *SnapshotOperationChannel) SaveOperationChannel() error {
 	opChan.snapshotDbMutex.Lock()
 	defer opChan.snapshotDbMutex.Unlock()
 
-	return opChan.snapshotDb.Update(func(txn *badger.Txn) error {
+	return opChan.snapshotDb.Update(func(txn KVTxn) error {
 		return txn.Set(_prefixOperationChannelStatus, UintToBuf(uint64(opChan.StateSemaphore)))
 	})
 }
@@ -1822,13 +2117,13 @@ type SnapshotStatus struct {
 	// SnapshotStatus is called concurrently by the Server and Snapshot threads. And badger cannot handle
 	// concurrent writes to the database. To make sure this concurrency doesn't affect general performance,
 	// we use a custom badger.DB to save SnapshotStatus.
-	snapshotDb *badger.DB
+	snapshotDb KVStore
 
 	// snapshotDbMutex is held whenever we modify snapshotDb.
 	snapshotDbMutex *sync.Mutex
 }
 
-func (status *SnapshotStatus) Initialize(snapshotDb *badger.DB, snapshotDbMutex *sync.Mutex) error {
+func (status *SnapshotStatus) Initialize(snapshotDb KVStore, snapshotDbMutex *sync.Mutex) error {
 	status.MainDBSemaphore = uint64(0)
 	status.AncestralDBSemaphore = uint64(0)
 
@@ -1878,7 +2173,7 @@ func (status *SnapshotStatus) SaveStatus() {
 	status.snapshotDbMutex.Lock()
 	defer status.snapshotDbMutex.Unlock()
 
-	err := status.snapshotDb.Update(func(txn *badger.Txn) error {
+	err := status.snapshotDb.Update(func(txn KVTxn) error {
 		return txn.Set(_prefixSnapshotStatus, status.ToBytes())
 	})
 	if err != nil {
@@ -1890,7 +2185,7 @@ func (status *SnapshotStatus) ReadStatus() error {
 	status.snapshotDbMutex.Lock()
 	defer status.snapshotDbMutex.Unlock()
 
-	err := status.snapshotDb.View(func(txn *badger.Txn) error {
+	err := status.snapshotDb.View(func(txn KVTxn) error {
 		item, err := txn.Get(_prefixSnapshotStatus)
 		if err != nil {
 			return err
@@ -1902,7 +2197,7 @@ func (status *SnapshotStatus) ReadStatus() error {
 		rr := bytes.NewReader(statusBytes)
 		return status.FromBytes(rr)
 	})
-	if err != nil && err != badger.ErrKeyNotFound {
+	if err != nil && err != ErrKVKeyNotFound {
 		return errors.Wrapf(err, "SnapshotStatus.ReadStatus: Problem reading status from db")
 	}
 	return nil
@@ -1972,13 +2267,13 @@ type EncoderMigration struct {
 	completed          bool
 	currentBlockHeight uint64
 
-	mainDb          *badger.DB
-	snapshotDb      *badger.DB
+	mainDb          KVStore
+	snapshotDb      KVStore
 	snapshotDbMutex *sync.Mutex
 	params          *DeSoParams
 }
 
-func (migration *EncoderMigration) Initialize(mainDb *badger.DB, snapshotDb *badger.DB,
+func (migration *EncoderMigration) Initialize(mainDb KVStore, snapshotDb KVStore,
 	snapshotDbMutex *sync.Mutex, blockHeight uint64, params *DeSoParams, disabled bool) error {
 
 	migration.mainDb = mainDb
@@ -2001,7 +2296,7 @@ func (migration *EncoderMigration) Initialize(mainDb *badger.DB, snapshotDb *bad
 	}
 
 	// Retrieve all migrations from the snapshot Db.
-	err := migration.snapshotDb.View(func(txn *badger.Txn) error {
+	err := migration.snapshotDb.View(func(txn KVTxn) error {
 		item, err := txn.Get(_prefixMigrationStatus)
 		if err != nil {
 			return err
@@ -2061,7 +2356,7 @@ func (migration *EncoderMigration) Initialize(mainDb *badger.DB, snapshotDb *bad
 		migration.migrationChecksums = migrationChecksums
 		return nil
 	})
-	if err != nil && err != badger.ErrKeyNotFound {
+	if err != nil && err != ErrKVKeyNotFound {
 		return errors.Wrapf(err, "EncoderMigrationChecksum.Initialize: Problem reading migration from db")
 	}
 
@@ -2092,7 +2387,7 @@ func (migration *EncoderMigration) Initialize(mainDb *badger.DB, snapshotDb *bad
 	return nil
 }
 
-func (migration *EncoderMigration) InitializeSingleHeight(mainDb *badger.DB, snapshotDb *badger.DB,
+func (migration *EncoderMigration) InitializeSingleHeight(mainDb KVStore, snapshotDb KVStore,
 	snapshotDbMutex *sync.Mutex, blockHeight uint64, params *DeSoParams) {
 
 	migration.currentBlockHeight = blockHeight
@@ -2130,7 +2425,7 @@ func (migration *EncoderMigration) SaveMigrations() error {
 	}
 	data = append(data, BoolToByte(migration.completed))
 
-	return migration.snapshotDb.Update(func(txn *badger.Txn) error {
+	return migration.snapshotDb.Update(func(txn KVTxn) error {
 		return txn.Set(_prefixMigrationStatus, data)
 	})
 }
@@ -2175,62 +2470,103 @@ func (migration *EncoderMigration) StartMigrations() error {
 	carrierChecksum := &StateChecksum{}
 	carrierChecksum.Initialize(nil, nil)
 
-	// This whole thing is just a status printer
-	startedPrefix := prefixes[0]
-	finishChannel := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(60 * time.Second)
-		defer ticker.Stop()
-
-		for {
-			select {
-			case <-finishChannel:
-				return
-			case <-ticker.C:
-				var completedPrefixes [][]byte
-				var incompletePrefixes [][]byte
-				var currentPrefix []byte
-
-				for _, prefix := range StatePrefixes.StatePrefixesList {
-					if prefix[0] < startedPrefix[0] {
-						completedPrefixes = append(completedPrefixes, prefix)
-					} else if prefix[0] == startedPrefix[0] {
-						currentPrefix = startedPrefix[:]
-					} else {
-						incompletePrefixes = append(incompletePrefixes, prefix)
-					}
-				}
-				if len(completedPrefixes) > 0 {
-					glog.Infof(CLog(Green, fmt.Sprintf("EncoderMigration: finished updating prefixes (%v)", completedPrefixes)))
-				}
-				if len(currentPrefix) > 0 {
-					glog.Infof(CLog(Yellow, fmt.Sprintf("EncoderMigration: currently updating prefix: (%v)", currentPrefix)))
-				}
-				if len(incompletePrefixes) > 0 {
-					glog.Infof("Remaining prefixes (%v)", incompletePrefixes)
+	// If the node was terminated during a previous attempt at these migrations, we will resume from the last saved key.
+	progress, err := migration.loadProgress()
+	if err != nil {
+		glog.Errorf("EncoderMigration.StartMigrations: Problem loading migration progress, starting from "+
+			"scratch. Error (%v)", err)
+		progress = nil
+	}
+	// resumePrefixIndex is the index of the prefix that contains the resume key. The prefixes before it have
+	// already been finished.
+	var resumeKey []byte
+	resumePrefixIndex := -1
+	if progress != nil {
+		resumeKey, err = progress.Restore(migration.currentBlockHeight, outstandingChecksums)
+		if err == nil {
+			for ii, prefix := range prefixes {
+				if bytes.HasPrefix(resumeKey, prefix) {
+					resumePrefixIndex = ii
+					break
 				}
 			}
+			if resumePrefixIndex == -1 {
+				err = fmt.Errorf("resume key (%v) doesn't belong to any state prefix", resumeKey)
+			}
 		}
-	}()
+		if err != nil {
+			glog.Infof("EncoderMigration.StartMigrations: Saved migration progress can't be used, starting "+
+				"from scratch. Reason: (%v)", err)
+			for _, migrationChecksum := range outstandingChecksums {
+				migrationChecksum.Checksum.ResetChecksum()
+			}
+			resumeKey = nil
+			resumePrefixIndex = -1
+		} else {
+			glog.Infof(CLog(Yellow, fmt.Sprintf("EncoderMigration: Resuming migrations from key (%v)", resumeKey)))
+		}
+	}
+
+	// Print the progress per prefix periodically.
+	migrationProgress := NewEncoderMigrationPrefixProgress(prefixes)
+	finishChannel := make(chan struct{})
+	go migrationProgress.PrintPeriodically(finishChannel, 60*time.Second)
+
+	// saveProgress waits for the checksum workers and saves the checksums computed up to and including lastKey.
+	saveProgress := func(lastKey []byte) error {
+		if err := carrierChecksum.Wait(); err != nil {
+			return errors.Wrapf(err, "problem waiting for the checksum")
+		}
+		return migration.saveProgress(lastKey, outstandingChecksums)
+	}
 
 	// Compute the checksums for all migrations, as needed.
-	err := migration.mainDb.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		for _, prefix := range prefixes {
-			startedPrefix = prefix
+	err = migration.mainDb.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
+		for ii, prefix := range prefixes {
+			// Skip prefixes that we've already finished before the node was terminated.
+			if ii < resumePrefixIndex {
+				migrationProgress.FinishPrefix(ii)
+				continue
+			}
+			migrationProgress.StartPrefix(ii)
+			startKey := prefix
+			if ii == resumePrefixIndex {
+				startKey = resumeKey
+			}
+
 			it := txn.NewIterator(opts)
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			var lastKey []byte
+			for it.Seek(startKey); it.ValidForPrefix(prefix); it.Next() {
 				item := it.Item()
 				key := item.Key()
+				// The resume key has already been added to the checksums.
+				if len(resumeKey) > 0 && bytes.Equal(key, resumeKey) {
+					continue
+				}
 				err := item.Value(func(value []byte) error {
 					return carrierChecksum.AddOrRemoveBytesWithMigrations(key, value, migration.currentBlockHeight,
 						outstandingChecksums, true)
 				})
 				if err != nil {
+					it.Close()
 					return err
 				}
+				lastKey = item.KeyCopy(lastKey)
+				if migrationProgress.IncrementEntries()%EncoderMigrationProgressSaveInterval == 0 {
+					if err := saveProgress(lastKey); err != nil {
+						it.Close()
+						return errors.Wrapf(err, "problem saving migration progress")
+					}
+				}
 			}
 			it.Close()
+			if len(lastKey) > 0 {
+				if err := saveProgress(lastKey); err != nil {
+					return errors.Wrapf(err, "problem saving migration progress")
+				}
+			}
+			migrationProgress.FinishPrefix(ii)
 		}
 		return nil
 	})
@@ -2243,6 +2579,10 @@ func (migration *EncoderMigration) StartMigrations() error {
 		return errors.Wrapf(err, "EncoderMigration.StartMigrations: Problem waiting for the checksum. "+
 			"Node should be restarted.")
 	}
+	if err = migration.deleteProgress(); err != nil {
+		return errors.Wrapf(err, "EncoderMigration.StartMigrations: Problem deleting migration progress. "+
+			"Node should be restarted.")
+	}
 	glog.Infof(CLog(Yellow, "Finished computing the migration"))
 
 	for _, migrationChecksum := range migration.migrationChecksums {
@@ -2252,6 +2592,72 @@ func (migration *EncoderMigration) StartMigrations() error {
 	return nil
 }
 
+// saveProgress persists the checksums of outstanding migrations, computed up to and including lastKey, so that
+// migrations can be resumed if the node is terminated midway.
+func (migration *EncoderMigration) saveProgress(lastKey []byte, outstandingChecksums []*EncoderMigrationChecksum) error {
+	progress := &EncoderMigrationProgress{
+		BlockHeight: migration.currentBlockHeight,
+		LastKey:     lastKey,
+	}
+	for _, migrationChecksum := range outstandingChecksums {
+		checksumBytes, err := migrationChecksum.Checksum.ToBytes()
+		if err != nil {
+			return errors.Wrapf(err, "EncoderMigration.saveProgress: Problem getting checksum bytes")
+		}
+		progress.MigrationHeights = append(progress.MigrationHeights, migrationChecksum.BlockHeight)
+		progress.ChecksumBytes = append(progress.ChecksumBytes, checksumBytes)
+	}
+
+	if migration.snapshotDb == nil {
+		return nil
+	}
+	migration.snapshotDbMutex.Lock()
+	defer migration.snapshotDbMutex.Unlock()
+	return migration.snapshotDb.Update(func(txn KVTxn) error {
+		return txn.Set(_prefixMigrationProgress, progress.ToBytes())
+	})
+}
+
+func (migration *EncoderMigration) loadProgress() (*EncoderMigrationProgress, error) {
+	if migration.snapshotDb == nil {
+		return nil, nil
+	}
+	migration.snapshotDbMutex.Lock()
+	defer migration.snapshotDbMutex.Unlock()
+
+	var progress *EncoderMigrationProgress
+	err := migration.snapshotDb.View(func(txn KVTxn) error {
+		item, err := txn.Get(_prefixMigrationProgress)
+		if err != nil {
+			return err
+		}
+		progressBytes, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		progress = &EncoderMigrationProgress{}
+		return progress.FromBytes(bytes.NewReader(progressBytes))
+	})
+	if err == ErrKVKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "EncoderMigration.loadProgress: Problem reading migration progress")
+	}
+	return progress, nil
+}
+
+func (migration *EncoderMigration) deleteProgress() error {
+	if migration.snapshotDb == nil {
+		return nil
+	}
+	migration.snapshotDbMutex.Lock()
+	defer migration.snapshotDbMutex.Unlock()
+	return migration.snapshotDb.Update(func(txn KVTxn) error {
+		return txn.Delete(_prefixMigrationProgress)
+	})
+}
+
 func (migration *EncoderMigration) GetMigrationChecksumAtBlockheight(blockHeight uint64) *StateChecksum {
 	for _, migrationChecksum := range migration.migrationChecksums {
 		if migrationChecksum.BlockHeight == blockHeight {
@@ -2278,6 +2684,305 @@ func (migration *EncoderMigration) ResetChecksums() {
 	}
 }
 
+// -------------------------------------------------------------------------------------
+// EncoderMigrationProgress, EncoderMigrationPrefixProgress
+// -------------------------------------------------------------------------------------
+
+// EncoderMigrationProgressSaveInterval is the number of entries after which we save the migration progress.
+const EncoderMigrationProgressSaveInterval = 1000000
+
+// EncoderMigrationProgress is saved in the snapshot db during encoder migrations. It contains the checksums of
+// outstanding migrations computed up to and including LastKey, so that migrations can be resumed after a crash.
+type EncoderMigrationProgress struct {
+	// BlockHeight is the height of the state that was being migrated.
+	BlockHeight uint64
+	// MigrationHeights and ChecksumBytes are the partial checksums of the outstanding migrations.
+	MigrationHeights []uint64
+	ChecksumBytes    [][]byte
+	// LastKey is the last db key that was added to the checksums.
+	LastKey []byte
+}
+
+func (progress *EncoderMigrationProgress) ToBytes() []byte {
+	var data []byte
+	data = append(data, UintToBuf(progress.BlockHeight)...)
+	data = append(data, UintToBuf(uint64(len(progress.MigrationHeights)))...)
+	for ii := range progress.MigrationHeights {
+		data = append(data, UintToBuf(progress.MigrationHeights[ii])...)
+		data = append(data, EncodeByteArray(progress.ChecksumBytes[ii])...)
+	}
+	data = append(data, EncodeByteArray(progress.LastKey)...)
+	return data
+}
+
+func (progress *EncoderMigrationProgress) FromBytes(rr *bytes.Reader) error {
+	var err error
+	if progress.BlockHeight, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "EncoderMigrationProgress.FromBytes: Problem reading BlockHeight")
+	}
+	numMigrations, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "EncoderMigrationProgress.FromBytes: Problem reading number of migrations")
+	}
+	for ; numMigrations > 0; numMigrations-- {
+		migrationHeight, err := ReadUvarint(rr)
+		if err != nil {
+			return errors.Wrapf(err, "EncoderMigrationProgress.FromBytes: Problem reading migration height")
+		}
+		checksumBytes, err := DecodeByteArray(rr)
+		if err != nil {
+			return errors.Wrapf(err, "EncoderMigrationProgress.FromBytes: Problem reading checksum bytes")
+		}
+		progress.MigrationHeights = append(progress.MigrationHeights, migrationHeight)
+		progress.ChecksumBytes = append(progress.ChecksumBytes, checksumBytes)
+	}
+	if progress.LastKey, err = DecodeByteArray(rr); err != nil {
+		return errors.Wrapf(err, "EncoderMigrationProgress.FromBytes: Problem reading LastKey")
+	}
+	return nil
+}
+
+// Restore sets the outstanding migration checksums to the saved partial checksums and returns the key from which
+// the migration should be resumed. The progress can only be used if it was saved for the same state, and the same
+// set of outstanding migrations.
+func (progress *EncoderMigrationProgress) Restore(blockHeight uint64,
+	outstandingChecksums []*EncoderMigrationChecksum) (_resumeKey []byte, _err error) {
+
+	if progress.BlockHeight != blockHeight {
+		return nil, fmt.Errorf("progress was saved at block height (%v) but state is at block height (%v)",
+			progress.BlockHeight, blockHeight)
+	}
+	if len(progress.MigrationHeights) != len(outstandingChecksums) {
+		return nil, fmt.Errorf("progress was saved for (%v) migrations but there are (%v) outstanding migrations",
+			len(progress.MigrationHeights), len(outstandingChecksums))
+	}
+	for ii, migrationChecksum := range outstandingChecksums {
+		if progress.MigrationHeights[ii] != migrationChecksum.BlockHeight {
+			return nil, fmt.Errorf("progress was saved for migration at height (%v) but outstanding migration "+
+				"is at height (%v)", progress.MigrationHeights[ii], migrationChecksum.BlockHeight)
+		}
+	}
+	for ii, migrationChecksum := range outstandingChecksums {
+		if err := migrationChecksum.Checksum.FromBytes(progress.ChecksumBytes[ii]); err != nil {
+			return nil, errors.Wrapf(err, "EncoderMigrationProgress.Restore: Problem setting checksum")
+		}
+	}
+	return progress.LastKey, nil
+}
+
+// EncoderMigrationPrefixProgress keeps track of how far we've got when scanning the state prefixes during
+// encoder migrations. It is safe to call concurrently with PrintPeriodically.
+type EncoderMigrationPrefixProgress struct {
+	prefixes          [][]byte
+	completedPrefixes []bool
+	currentPrefix     int
+	// prefixEntries is the number of entries processed in the current prefix.
+	prefixEntries uint64
+	totalEntries  uint64
+	prefixStarted time.Time
+	started       time.Time
+
+	mtx sync.Mutex
+}
+
+func NewEncoderMigrationPrefixProgress(prefixes [][]byte) *EncoderMigrationPrefixProgress {
+	return &EncoderMigrationPrefixProgress{
+		prefixes:          prefixes,
+		completedPrefixes: make([]bool, len(prefixes)),
+		currentPrefix:     -1,
+		started:           time.Now(),
+	}
+}
+
+func (progress *EncoderMigrationPrefixProgress) StartPrefix(prefixIndex int) {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+
+	progress.currentPrefix = prefixIndex
+	progress.prefixEntries = 0
+	progress.prefixStarted = time.Now()
+}
+
+// IncrementEntries increments the number of processed entries and returns the total.
+func (progress *EncoderMigrationPrefixProgress) IncrementEntries() uint64 {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+
+	progress.prefixEntries++
+	progress.totalEntries++
+	return progress.totalEntries
+}
+
+func (progress *EncoderMigrationPrefixProgress) FinishPrefix(prefixIndex int) {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+
+	progress.completedPrefixes[prefixIndex] = true
+	if progress.currentPrefix != prefixIndex {
+		return
+	}
+	glog.V(1).Infof("EncoderMigration: finished prefix (%v) with (%v) entries in (%v)",
+		progress.prefixes[prefixIndex], progress.prefixEntries, time.Since(progress.prefixStarted))
+	progress.currentPrefix = -1
+}
+
+// PrintPeriodically prints the progress every interval until finishChannel is closed.
+func (progress *EncoderMigrationPrefixProgress) PrintPeriodically(finishChannel chan struct{}, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-finishChannel:
+			return
+		case <-ticker.C:
+			progress.Print()
+		}
+	}
+}
+
+func (progress *EncoderMigrationPrefixProgress) Print() {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+
+	var completedPrefixes [][]byte
+	var incompletePrefixes [][]byte
+	for ii, prefix := range progress.prefixes {
+		if progress.completedPrefixes[ii] {
+			completedPrefixes = append(completedPrefixes, prefix)
+		} else if ii != progress.currentPrefix {
+			incompletePrefixes = append(incompletePrefixes, prefix)
+		}
+	}
+	glog.Infof(CLog(Green, fmt.Sprintf("EncoderMigration: finished (%v/%v) prefixes, processed (%v) entries in (%v)",
+		len(completedPrefixes), len(progress.prefixes), progress.totalEntries, time.Since(progress.started))))
+	if len(completedPrefixes) > 0 {
+		glog.Infof(CLog(Green, fmt.Sprintf("EncoderMigration: finished updating prefixes (%v)", completedPrefixes)))
+	}
+	if progress.currentPrefix >= 0 {
+		glog.Infof(CLog(Yellow, fmt.Sprintf("EncoderMigration: currently updating prefix: (%v), processed (%v) "+
+			"entries in (%v)", progress.prefixes[progress.currentPrefix], progress.prefixEntries,
+			time.Since(progress.prefixStarted))))
+	}
+	if len(incompletePrefixes) > 0 {
+		glog.Infof("Remaining prefixes (%v)", incompletePrefixes)
+	}
+}
+
+// -------------------------------------------------------------------------------------
+// EncoderMigrationDryRun
+// -------------------------------------------------------------------------------------
+
+// EncoderMigrationDryRunResult summarizes what an outstanding encoder migration would change in the state.
+type EncoderMigrationDryRunResult struct {
+	Migration *MigrationHeight
+
+	// VersionChanges is the number of entries, per state prefix, whose GetVersionByte changes at the migration height.
+	VersionChanges      map[byte]uint64
+	TotalVersionChanges uint64
+	// ChecksumChanges is the number of entries whose checksum encoding changes at the migration height.
+	ChecksumChanges uint64
+	// ChecksumDelta is the difference between the state checksum at the migration height and the current state
+	// checksum, assuming no other changes to the state. It is the identity element if the checksum won't change.
+	ChecksumDelta group.Element
+}
+
+// EncoderMigrationDryRun walks all state prefixes and determines, for each encoder migration above blockHeight,
+// which entries would be affected by the migration. It doesn't modify the db. The GlobalDeSoParams should be set
+// to params prior to calling this function, as encoders rely on them to determine their version.
+func EncoderMigrationDryRun(mainDb KVStore, blockHeight uint64, params *DeSoParams) (
+	_results []*EncoderMigrationDryRunResult, _entriesScanned uint64, _err error) {
+
+	var results []*EncoderMigrationDryRunResult
+	var deltaChecksums []*StateChecksum
+	for _, migrationHeight := range params.EncoderMigrationHeightsList {
+		if migrationHeight.Height <= blockHeight {
+			continue
+		}
+		deltaChecksum := &StateChecksum{}
+		if err := deltaChecksum.Initialize(nil, nil); err != nil {
+			return nil, 0, errors.Wrapf(err, "EncoderMigrationDryRun: Problem initializing checksum")
+		}
+		deltaChecksums = append(deltaChecksums, deltaChecksum)
+		results = append(results, &EncoderMigrationDryRunResult{
+			Migration:      migrationHeight,
+			VersionChanges: make(map[byte]uint64),
+		})
+	}
+	if len(results) == 0 {
+		return nil, 0, nil
+	}
+
+	migrationProgress := NewEncoderMigrationPrefixProgress(StatePrefixes.StatePrefixesList)
+	finishChannel := make(chan struct{})
+	go migrationProgress.PrintPeriodically(finishChannel, 60*time.Second)
+	defer close(finishChannel)
+
+	entriesScanned := uint64(0)
+	err := mainDb.View(func(txn KVTxn) error {
+		opts := DefaultKVIteratorOptions
+		for ii, prefix := range StatePrefixes.StatePrefixesList {
+			migrationProgress.StartPrefix(ii)
+			it := txn.NewIterator(opts)
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				item := it.Item()
+				key := item.KeyCopy(nil)
+				value, err := item.ValueCopy(nil)
+				if err != nil {
+					it.Close()
+					return errors.Wrapf(err, "problem reading value for key (%v)", key)
+				}
+
+				// Determine the version of the entry, if it's a DeSoEncoder.
+				var encoder DeSoEncoder
+				if isEncoder, stateEncoder := StateKeyToDeSoEncoder(key); isEncoder && stateEncoder != nil {
+					if exists, err := DecodeFromBytes(stateEncoder, bytes.NewReader(value)); exists && err == nil {
+						encoder = stateEncoder
+					}
+				}
+				currentEncoding := EncodeKeyAndValueForChecksum(key, value, blockHeight)
+
+				for jj, result := range results {
+					if encoder != nil && encoder.GetVersionByte(blockHeight) !=
+						encoder.GetVersionByte(result.Migration.Height) {
+
+						result.VersionChanges[prefix[0]]++
+						result.TotalVersionChanges++
+					}
+					migrationEncoding := EncodeKeyAndValueForChecksum(key, value, result.Migration.Height)
+					if bytes.Equal(currentEncoding, migrationEncoding) {
+						continue
+					}
+					result.ChecksumChanges++
+					if err := deltaChecksums[jj].AddBytes(migrationEncoding); err != nil {
+						it.Close()
+						return err
+					}
+					if err := deltaChecksums[jj].RemoveBytes(currentEncoding); err != nil {
+						it.Close()
+						return err
+					}
+				}
+				entriesScanned++
+				migrationProgress.IncrementEntries()
+			}
+			it.Close()
+			migrationProgress.FinishPrefix(ii)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "EncoderMigrationDryRun: Problem scanning the state")
+	}
+
+	for ii, result := range results {
+		if result.ChecksumDelta, err = deltaChecksums[ii].GetChecksum(); err != nil {
+			return nil, 0, errors.Wrapf(err, "EncoderMigrationDryRun: Problem getting checksum delta")
+		}
+	}
+	return results, entriesScanned, nil
+}
+
 // -------------------------------------------------------------------------------------
 // Timer
 // -------------------------------------------------------------------------------------
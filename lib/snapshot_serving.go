@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// SnapshotServingLimiter limits the resources a node spends on serving snapshot chunks to hypersyncing peers.
+// Fetching a chunk is an expensive read from the main and ancestral records dbs, and sending it can take up to
+// SnapshotBatchSize of bandwidth, so a handful of peers requesting chunks as fast as they can could saturate the
+// disk and the network of a block producer.
+//
+// Requests first wait for one of maxConcurrentRequests slots. Waiting requests are kept in a FIFO queue, and since
+// every peer processes its messages sequentially, a peer can have at most one request in the queue. This means a
+// peer that was just served goes to the back of the queue, so the slots are shared round-robin between peers.
+// Once a chunk is fetched, the request waits until sending it fits both the per-peer and the global bandwidth.
+// A zero limit means unlimited.
+type SnapshotServingLimiter struct {
+	maxConcurrentRequests uint64
+	peerBytesPerSecond    uint64
+
+	mtx            sync.Mutex
+	activeRequests uint64
+	waitQueue      []*snapshotServingWaiter
+	globalThrottle *bandwidthThrottle
+	peerThrottles  map[uint64]*bandwidthThrottle
+}
+
+type snapshotServingWaiter struct {
+	peerID uint64
+	ready  chan struct{}
+}
+
+func NewSnapshotServingLimiter(maxConcurrentRequests uint64, peerBytesPerSecond uint64,
+	globalBytesPerSecond uint64) *SnapshotServingLimiter {
+
+	return &SnapshotServingLimiter{
+		maxConcurrentRequests: maxConcurrentRequests,
+		peerBytesPerSecond:    peerBytesPerSecond,
+		globalThrottle:        &bandwidthThrottle{bytesPerSecond: globalBytesPerSecond},
+		peerThrottles:         make(map[uint64]*bandwidthThrottle),
+	}
+}
+
+// AcquireSlot blocks until the request can be served. It returns false if the quit channel was closed
+// in the meantime, in which case the slot must not be released.
+func (limiter *SnapshotServingLimiter) AcquireSlot(peerID uint64, quit chan interface{}) bool {
+	limiter.mtx.Lock()
+	if limiter.maxConcurrentRequests == 0 ||
+		(limiter.activeRequests < limiter.maxConcurrentRequests && len(limiter.waitQueue) == 0) {
+
+		limiter.activeRequests++
+		limiter.mtx.Unlock()
+		return true
+	}
+	waiter := &snapshotServingWaiter{
+		peerID: peerID,
+		ready:  make(chan struct{}),
+	}
+	limiter.waitQueue = append(limiter.waitQueue, waiter)
+	limiter.mtx.Unlock()
+
+	select {
+	case <-waiter.ready:
+		return true
+	case <-quit:
+		limiter.mtx.Lock()
+		for ii, queuedWaiter := range limiter.waitQueue {
+			if queuedWaiter == waiter {
+				limiter.waitQueue = append(limiter.waitQueue[:ii], limiter.waitQueue[ii+1:]...)
+				limiter.mtx.Unlock()
+				return false
+			}
+		}
+		limiter.mtx.Unlock()
+		// If the waiter wasn't in the queue anymore, we were handed the slot just as we were quitting.
+		limiter.ReleaseSlot()
+		return false
+	}
+}
+
+// ReleaseSlot hands the slot over to the first request in the queue, or frees it if the queue is empty.
+func (limiter *SnapshotServingLimiter) ReleaseSlot() {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	if len(limiter.waitQueue) > 0 {
+		waiter := limiter.waitQueue[0]
+		limiter.waitQueue = limiter.waitQueue[1:]
+		close(waiter.ready)
+		return
+	}
+	if limiter.activeRequests > 0 {
+		limiter.activeRequests--
+	}
+}
+
+// WaitForBandwidth blocks until numBytes can be sent to the peer without exceeding the bandwidth limits.
+// It returns false if the quit channel was closed in the meantime.
+func (limiter *SnapshotServingLimiter) WaitForBandwidth(peerID uint64, numBytes uint64, quit chan interface{}) bool {
+	delay := limiter.reserveBandwidth(peerID, numBytes, time.Now())
+	if delay <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(delay):
+		return true
+	case <-quit:
+		return false
+	}
+}
+
+// reserveBandwidth records that numBytes will be sent to the peer, and returns how long we have to wait before
+// sending them.
+func (limiter *SnapshotServingLimiter) reserveBandwidth(peerID uint64, numBytes uint64, now time.Time) time.Duration {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	delay := limiter.globalThrottle.reserve(numBytes, now)
+	if limiter.peerBytesPerSecond > 0 {
+		peerThrottle, exists := limiter.peerThrottles[peerID]
+		if !exists {
+			peerThrottle = &bandwidthThrottle{bytesPerSecond: limiter.peerBytesPerSecond}
+			limiter.peerThrottles[peerID] = peerThrottle
+		}
+		if peerDelay := peerThrottle.reserve(numBytes, now); peerDelay > delay {
+			delay = peerDelay
+		}
+	}
+	return delay
+}
+
+// RemovePeer should be called when a peer disconnects to clean up its bandwidth throttle.
+func (limiter *SnapshotServingLimiter) RemovePeer(peerID uint64) {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	delete(limiter.peerThrottles, peerID)
+}
+
+// bandwidthThrottle is a token bucket that can go into debt. Every reservation is allowed to start once all
+// previously reserved bytes would have been sent at bytesPerSecond. This lets us throttle messages as big
+// as a snapshot chunk without requiring a bucket that large.
+type bandwidthThrottle struct {
+	bytesPerSecond uint64
+	// nextAvailable is the time at which all reserved bytes will have been sent.
+	nextAvailable time.Time
+}
+
+func (throttle *bandwidthThrottle) reserve(numBytes uint64, now time.Time) time.Duration {
+	if throttle.bytesPerSecond == 0 {
+		return 0
+	}
+	if throttle.nextAvailable.Before(now) {
+		throttle.nextAvailable = now
+	}
+	delay := throttle.nextAvailable.Sub(now)
+	throttle.nextAvailable = throttle.nextAvailable.Add(
+		time.Duration(float64(numBytes) / float64(throttle.bytesPerSecond) * float64(time.Second)))
+	return delay
+}
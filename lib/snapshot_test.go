@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"bytes"
 	"context"
 	"encoding/binary"
 	"encoding/hex"
@@ -9,7 +10,6 @@ import (
 	"github.com/btcsuite/btcd/btcec"
 	"github.com/cloudflare/circl/group"
 	merkletree "github.com/deso-protocol/go-merkle-tree"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/oleiade/lane"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/semaphore"
@@ -316,7 +316,7 @@ func TestBadgerConcurrentWrite(t *testing.T) {
 	wait := sync.WaitGroup{}
 	wait.Add(1)
 
-	err := db.Update(func(txn *badger.Txn) error {
+	err := db.Update(func(txn KVTxn) error {
 		for ii := 0; ii < sequentialWrites; ii++ {
 			err := txn.Set(keys[ii][:], vals[ii][:])
 			if err != nil {
@@ -325,15 +325,15 @@ func TestBadgerConcurrentWrite(t *testing.T) {
 		}
 
 		// This won't work because of concurrency
-		//go func(txn *badger.Txn, wait *sync.WaitGroup) {
+		//go func(txn KVTxn, wait *sync.WaitGroup) {
 		//	for jj := sequentialWrites; jj < sequentialWrites + concurrentWrites; jj++ {
 		//		_ = txn.Set(keys[jj][:], vals[jj][:])
 		//	}
 		//	wait.Done()
 		//}(txn, &wait)
 
-		go func(db *badger.DB, wait *sync.WaitGroup) {
-			err := db.Update(func(txn *badger.Txn) error {
+		go func(db KVStore, wait *sync.WaitGroup) {
+			err := db.Update(func(txn KVTxn) error {
 				for jj := sequentialWrites; jj < sequentialWrites+concurrentWrites; jj++ {
 					err := txn.Set(keys[jj][:], vals[jj][:])
 					if err != nil {
@@ -360,7 +360,7 @@ func TestBadgerConcurrentWrite(t *testing.T) {
 	wait.Wait()
 	fmt.Println("Finished everything")
 
-	err = db.View(func(txn *badger.Txn) error {
+	err = db.View(func(txn KVTxn) error {
 		for ii := 0; ii < sequentialWrites+concurrentWrites; ii++ {
 			item, err := txn.Get(keys[ii][:])
 			if err != nil {
@@ -388,13 +388,13 @@ func TestBadgerEmptyWrite(t *testing.T) {
 	key := []byte{1, 2, 3}
 	val := []byte{}
 
-	err := db.Update(func(txn *badger.Txn) error {
+	err := db.Update(func(txn KVTxn) error {
 		return txn.Set(key, val)
 	})
 	require.NoError(err)
 
 	var readVal []byte
-	err = db.View(func(txn *badger.Txn) error {
+	err = db.View(func(txn KVTxn) error {
 		item, err := txn.Get(key)
 		if err != nil {
 			return err
@@ -733,3 +733,429 @@ func TestStateChecksumBirthdayParadox(t *testing.T) {
 	}
 	fmt.Println(totalElappsed)
 }
+
+func TestStateScrubber(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	snap := chain.snapshot
+	eventManager := NewEventManager()
+	var events []*StateChecksumMismatchEvent
+	eventManager.OnStateChecksumMismatch(func(event *StateChecksumMismatchEvent) {
+		events = append(events, event)
+	})
+	scrubber := NewStateScrubber(db, snap, chain, eventManager, params, time.Hour, math.MaxUint32)
+
+	putBalance := func(publicKey []byte, balanceNanos uint64) {
+		snap.PrepareAncestralRecordsFlush()
+		require.NoError(DbPutDeSoBalanceForPublicKey(db, snap, publicKey, balanceNanos))
+		snap.StartAncestralRecordsFlush(true)
+	}
+
+	// The freshly initialized state should match the incremental checksum.
+	event, err := scrubber.Scrub()
+	require.NoError(err)
+	require.Nil(event)
+
+	// Writes that go through the snapshot keep the checksum in sync.
+	putBalance(m0PkBytes, 100)
+	putBalance(m1PkBytes, 200)
+	event, err = scrubber.Scrub()
+	require.NoError(err)
+	require.Nil(event)
+
+	// A write that bypasses the snapshot should be detected and attributed to its prefix.
+	require.NoError(db.Update(func(txn KVTxn) error {
+		return txn.Set(_dbKeyForPublicKeyToDeSoBalanceNanos(m2PkBytes), EncodeUint64(300))
+	}))
+	event, err = scrubber.Scrub()
+	require.NoError(err)
+	require.NotNil(event)
+	require.NotEqual(event.ComputedChecksum, event.IncrementalChecksum)
+	require.Equal([][]byte{Prefixes.PrefixPublicKeyToDeSoBalanceNanos}, event.MismatchedPrefixes)
+	require.Len(events, 1)
+
+	// The aggregate mismatch keeps getting reported, but the drift is no longer attributed to the prefix.
+	putBalance(m0PkBytes, 150)
+	event, err = scrubber.Scrub()
+	require.NoError(err)
+	require.NotNil(event)
+	require.Empty(event.MismatchedPrefixes)
+	require.Len(events, 2)
+
+	// The per-prefix checksums are saved with the checksum, so the first scrub after a restart can still
+	// attribute a mismatch to its prefix.
+	snap.WaitForAllOperationsToFinish()
+	restartedChecksum := &StateChecksum{}
+	require.NoError(restartedChecksum.Initialize(snap.SnapshotDb, snap.SnapshotDbMutex))
+	prefixChecksums, _ := restartedChecksum.GetPrefixChecksums()
+	require.Contains(prefixChecksums, Prefixes.PrefixPublicKeyToDeSoBalanceNanos[0])
+	snap.Checksum = restartedChecksum
+	scrubber = NewStateScrubber(db, snap, chain, eventManager, params, time.Hour, math.MaxUint32)
+	require.NoError(db.Update(func(txn KVTxn) error {
+		return txn.Set(_dbKeyForPublicKeyToDeSoBalanceNanos(m3PkBytes), EncodeUint64(400))
+	}))
+	event, err = scrubber.Scrub()
+	require.NoError(err)
+	require.NotNil(event)
+	require.Equal([][]byte{Prefixes.PrefixPublicKeyToDeSoBalanceNanos}, event.MismatchedPrefixes)
+	require.Len(events, 3)
+}
+
+func TestEncoderMigrationResume(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	snap := chain.snapshot
+	for ii, publicKey := range [][]byte{m0PkBytes, m1PkBytes, m2PkBytes, m3PkBytes} {
+		require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, publicKey, uint64(ii+1)))
+	}
+	blockHeight := uint64(chain.blockTip().Height)
+
+	// Compute the migration checksum in one go.
+	fullMigration := &EncoderMigration{}
+	fullMigration.InitializeSingleHeight(db, snap.SnapshotDb, snap.SnapshotDbMutex, blockHeight, params)
+	require.NoError(fullMigration.StartMigrations())
+	fullChecksum, err := fullMigration.migrationChecksums[0].Checksum.ToBytes()
+	require.NoError(err)
+	// We add a marker to both checksums to make sure the resumed migration builds on the saved progress.
+	require.NoError(fullMigration.migrationChecksums[0].Checksum.AddBytes([]byte("marker")))
+	expectedChecksum, err := fullMigration.migrationChecksums[0].Checksum.ToBytes()
+	require.NoError(err)
+
+	// Simulate a crash after the second balance entry was added to the migration checksum.
+	lastKey := _dbKeyForPublicKeyToDeSoBalanceNanos(m1PkBytes)
+	partialChecksum := &StateChecksum{}
+	partialChecksum.Initialize(nil, nil)
+	require.NoError(db.View(func(txn KVTxn) error {
+		it := txn.NewIterator(DefaultKVIteratorOptions)
+		defer it.Close()
+		for _, prefix := range StatePrefixes.StatePrefixesList {
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if bytes.Compare(key, lastKey) > 0 {
+					return nil
+				}
+				value, err := it.Item().ValueCopy(nil)
+				require.NoError(err)
+				require.NoError(partialChecksum.AddBytes(EncodeKeyAndValueForChecksum(key, value, blockHeight)))
+			}
+		}
+		return nil
+	}))
+	require.NoError(partialChecksum.AddBytes([]byte("marker")))
+	resumedMigration := &EncoderMigration{}
+	resumedMigration.InitializeSingleHeight(db, snap.SnapshotDb, snap.SnapshotDbMutex, blockHeight, params)
+	resumedMigration.migrationChecksums[0].Checksum = partialChecksum
+	require.NoError(resumedMigration.saveProgress(lastKey, resumedMigration.migrationChecksums))
+	resumedMigration.migrationChecksums[0].Checksum = &StateChecksum{}
+	resumedMigration.migrationChecksums[0].Checksum.Initialize(nil, nil)
+
+	// Resuming should yield the same checksum and clean up the progress.
+	progress, err := resumedMigration.loadProgress()
+	require.NoError(err)
+	require.NotNil(progress)
+	require.Equal(lastKey, progress.LastKey)
+	require.NoError(resumedMigration.StartMigrations())
+	resumedChecksum, err := resumedMigration.migrationChecksums[0].Checksum.ToBytes()
+	require.NoError(err)
+	require.Equal(expectedChecksum, resumedChecksum)
+	progress, err = resumedMigration.loadProgress()
+	require.NoError(err)
+	require.Nil(progress)
+
+	// Progress whose last key isn't in a state prefix can't be resumed from, so the migration starts over.
+	nonStateKey := append([]byte{}, Prefixes.PrefixBlockHashToBlock...)
+	require.False(StatePrefixes.StatePrefixesMap[nonStateKey[0]])
+	restartedMigration := &EncoderMigration{}
+	restartedMigration.InitializeSingleHeight(db, snap.SnapshotDb, snap.SnapshotDbMutex, blockHeight, params)
+	restartedMigration.migrationChecksums[0].Checksum = partialChecksum
+	require.NoError(restartedMigration.saveProgress(nonStateKey, restartedMigration.migrationChecksums))
+	restartedMigration.migrationChecksums[0].Checksum = &StateChecksum{}
+	restartedMigration.migrationChecksums[0].Checksum.Initialize(nil, nil)
+	require.NoError(restartedMigration.StartMigrations())
+	restartedChecksum, err := restartedMigration.migrationChecksums[0].Checksum.ToBytes()
+	require.NoError(err)
+	require.Equal(fullChecksum, restartedChecksum)
+
+	// Progress saved for a different state can't be restored.
+	_, err = (&EncoderMigrationProgress{BlockHeight: blockHeight + 1}).Restore(
+		blockHeight, resumedMigration.migrationChecksums)
+	require.Error(err)
+}
+
+func TestEncoderMigrationDryRun(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	require.NoError(DbPutDeSoBalanceForPublicKey(db, nil, m0PkBytes, 100))
+	blockHeight := uint64(chain.blockTip().Height)
+
+	// There are no migrations above the tip.
+	results, _, err := EncoderMigrationDryRun(db, blockHeight, params)
+	require.NoError(err)
+	require.Empty(results)
+
+	// Balances aren't DeSoEncoders, so a new migration shouldn't affect them.
+	paramsCopy := *params
+	paramsCopy.EncoderMigrationHeightsList = append(paramsCopy.EncoderMigrationHeightsList,
+		&MigrationHeight{Height: blockHeight + 100, Version: 1, Name: "TestMigration"})
+	results, entriesScanned, err := EncoderMigrationDryRun(db, blockHeight, &paramsCopy)
+	require.NoError(err)
+	require.Len(results, 1)
+	require.NotZero(entriesScanned)
+	require.Equal(uint64(blockHeight+100), results[0].Migration.Height)
+	require.Zero(results[0].TotalVersionChanges)
+	require.Zero(results[0].ChecksumChanges)
+	require.True(results[0].ChecksumDelta.IsIdentity())
+}
+
+type localStateChecksumFetcher struct {
+	snap *Snapshot
+	db   KVStore
+}
+
+func (fetcher *localStateChecksumFetcher) GetStateChecksum(request *MsgDeSoGetStateChecksum) (
+	*MsgDeSoStateChecksum, error) {
+
+	// Send the request through the wire format to make sure it survives encoding.
+	requestBytes, err := request.ToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	decodedRequest := &MsgDeSoGetStateChecksum{}
+	if err := decodedRequest.FromBytes(requestBytes); err != nil {
+		return nil, err
+	}
+	reply, concurrencyFault, err := fetcher.snap.GetStateChecksum(fetcher.db, decodedRequest)
+	if err != nil {
+		return nil, err
+	}
+	if concurrencyFault {
+		return nil, fmt.Errorf("unexpected concurrency fault")
+	}
+	replyBytes, err := reply.ToBytes(false)
+	if err != nil {
+		return nil, err
+	}
+	decodedReply := &MsgDeSoStateChecksum{}
+	if err := decodedReply.FromBytes(replyBytes); err != nil {
+		return nil, err
+	}
+	return decodedReply, nil
+}
+
+func TestStateDiffer(t *testing.T) {
+	require := require.New(t)
+
+	chainA, _, dbA := NewLowDifficultyBlockchain()
+	chainB, _, dbB := NewLowDifficultyBlockchain()
+
+	// Put enough balances in both dbs so that the differ has to split the prefix a few times.
+	var publicKeys [][]byte
+	for ii := 0; ii < 1000; ii++ {
+		privateKey, err := btcec.NewPrivateKey(btcec.S256())
+		require.NoError(err)
+		publicKey := privateKey.PubKey().SerializeCompressed()
+		publicKeys = append(publicKeys, publicKey)
+		require.NoError(DbPutDeSoBalanceForPublicKey(dbA, nil, publicKey, uint64(ii)))
+		require.NoError(DbPutDeSoBalanceForPublicKey(dbB, nil, publicKey, uint64(ii)))
+	}
+
+	differ := NewStateDiffer(&localStateChecksumFetcher{chainA.snapshot, dbA},
+		&localStateChecksumFetcher{chainB.snapshot, dbB})
+	prefixes := [][]byte{Prefixes.PrefixPublicKeyToDeSoBalanceNanos, Prefixes.PrefixPKIDToProfileEntry}
+	diffs, err := differ.DiffPrefixes(prefixes)
+	require.NoError(err)
+	require.Empty(diffs)
+
+	// Change a balance on node A and add an extra balance on node B.
+	require.NoError(DbPutDeSoBalanceForPublicKey(dbA, nil, publicKeys[123], 1))
+	require.NoError(DbPutDeSoBalanceForPublicKey(dbB, nil, m0PkBytes, 5))
+
+	differ = NewStateDiffer(&localStateChecksumFetcher{chainA.snapshot, dbA},
+		&localStateChecksumFetcher{chainB.snapshot, dbB})
+	diffs, err = differ.DiffPrefixes(prefixes)
+	require.NoError(err)
+	require.Len(diffs, 2)
+	sort.Slice(diffs, func(ii, jj int) bool {
+		return diffs[ii].FoundA && !diffs[jj].FoundA
+	})
+	require.Equal(_dbKeyForPublicKeyToDeSoBalanceNanos(publicKeys[123]), diffs[0].Key)
+	require.Equal(EncodeUint64(1), diffs[0].ValueA)
+	require.Equal(EncodeUint64(123), diffs[0].ValueB)
+	require.True(diffs[0].FoundA && diffs[0].FoundB)
+	require.Equal(_dbKeyForPublicKeyToDeSoBalanceNanos(m0PkBytes), diffs[1].Key)
+	require.False(diffs[1].FoundA)
+	require.True(diffs[1].FoundB)
+	require.Equal(EncodeUint64(5), diffs[1].ValueB)
+	// We should've needed a lot fewer requests than there are records.
+	require.Less(differ.NumRequests, uint64(100))
+}
+
+func TestStateChecksumPeerKeys(t *testing.T) {
+	require := require.New(t)
+
+	allowedKey, err := NewPeerTransportKey()
+	require.NoError(err)
+	otherKey, err := NewPeerTransportKey()
+	require.NoError(err)
+	srv := &Server{stateChecksumPeerKeys: [][]byte{allowedKey.PublicKey}}
+
+	// Only a peer that proved it owns an allowed key over an encrypted connection can request checksums.
+	require.True(srv.isStateChecksumPeer(&Peer{transportPublicKey: allowedKey.PublicKey}))
+	require.False(srv.isStateChecksumPeer(&Peer{transportPublicKey: otherKey.PublicKey}))
+	require.False(srv.isStateChecksumPeer(&Peer{}))
+}
+
+func TestSnapshotServingLimiterSlots(t *testing.T) {
+	require := require.New(t)
+
+	limiter := NewSnapshotServingLimiter(1, 0, 0)
+	quit := make(chan interface{})
+	require.True(limiter.AcquireSlot(1, quit))
+
+	// Both peers have to wait for the slot, and they should get it in the order they asked for it.
+	served := make(chan uint64, 2)
+	for _, peerID := range []uint64{2, 3} {
+		peerID := peerID
+		go func() {
+			require.True(limiter.AcquireSlot(peerID, quit))
+			served <- peerID
+			limiter.ReleaseSlot()
+		}()
+		// Give the goroutine time to enter the queue.
+		for {
+			limiter.mtx.Lock()
+			queued := len(limiter.waitQueue)
+			limiter.mtx.Unlock()
+			if queued == int(peerID)-1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	select {
+	case <-served:
+		t.Fatal("a peer was served while the slot was taken")
+	default:
+	}
+
+	limiter.ReleaseSlot()
+	require.Equal(uint64(2), <-served)
+	require.Equal(uint64(3), <-served)
+
+	// A waiting peer that disconnects leaves the queue without taking the slot.
+	require.True(limiter.AcquireSlot(1, quit))
+	peerQuit := make(chan interface{})
+	close(peerQuit)
+	require.False(limiter.AcquireSlot(4, peerQuit))
+	require.Empty(limiter.waitQueue)
+	limiter.ReleaseSlot()
+	require.Equal(uint64(0), limiter.activeRequests)
+}
+
+func TestSnapshotServingLimiterBandwidth(t *testing.T) {
+	require := require.New(t)
+
+	limiter := NewSnapshotServingLimiter(0, 1000, 2000)
+	now := time.Now()
+
+	// The first chunk is sent right away, but the next one has to wait until the first one was sent.
+	require.Equal(time.Duration(0), limiter.reserveBandwidth(1, 1000, now))
+	require.Equal(time.Second, limiter.reserveBandwidth(1, 1000, now))
+
+	// Another peer is only limited by the global bandwidth, which is shared with the first peer.
+	require.Equal(time.Second, limiter.reserveBandwidth(2, 500, now))
+
+	// Once the reserved bytes are sent, there's no delay.
+	require.Equal(time.Duration(0), limiter.reserveBandwidth(2, 500, now.Add(10*time.Second)))
+
+	limiter.RemovePeer(1)
+	require.Nil(limiter.peerThrottles[1])
+}
+
+func TestStateScrubberBatches(t *testing.T) {
+	require := require.New(t)
+
+	chain, params, db := NewLowDifficultyBlockchain()
+	snap := chain.snapshot
+	scrubber := NewStateScrubber(db, snap, chain, nil, params, time.Hour, math.MaxUint32)
+	prefix := Prefixes.PrefixPublicKeyToDeSoBalanceNanos
+
+	putBalance := func(publicKey []byte, balanceNanos uint64) {
+		snap.PrepareAncestralRecordsFlush()
+		require.NoError(DbPutDeSoBalanceForPublicKey(db, snap, publicKey, balanceNanos))
+		snap.StartAncestralRecordsFlush(true)
+	}
+	newPublicKey := func(lastByte byte) []byte {
+		publicKey := make([]byte, btcec.PubKeyBytesLenCompressed)
+		publicKey[0] = 0x02
+		publicKey[len(publicKey)-1] = lastByte
+		return publicKey
+	}
+	for ii := byte(0); ii < 5; ii++ {
+		putBalance(newPublicKey(ii), uint64(ii)+1)
+	}
+
+	// Once a checkpoint sets a scrub boundary, the next checkpoint reports the changes made to keys below it.
+	boundaryKey := _dbKeyForPublicKeyToDeSoBalanceNanos(newPublicKey(3))
+	checkpoint := <-snap.GetChecksumCheckpoint(boundaryKey)
+	require.NotNil(checkpoint)
+	putBalance(newPublicKey(10), 10)
+	checkpoint = <-snap.GetChecksumCheckpoint([]byte{})
+	require.NotNil(checkpoint)
+	require.Empty(checkpoint.ScrubbedPrefixDeltas)
+	require.Contains(checkpoint.PrefixDeltas, prefix[0])
+
+	putBalance(newPublicKey(1), 20)
+	checkpoint = <-snap.GetChecksumCheckpoint(boundaryKey)
+	require.NotNil(checkpoint)
+	require.Empty(checkpoint.ScrubbedPrefixDeltas)
+
+	putBalance(newPublicKey(2), 30)
+	putBalance(newPublicKey(4), 40)
+	checkpoint = <-snap.GetChecksumCheckpoint([]byte{})
+	require.NotNil(checkpoint)
+	hashRecord := func(publicKey []byte, balanceNanos uint64) group.Element {
+		encoding := EncodeKeyAndValueForChecksum(_dbKeyForPublicKeyToDeSoBalanceNanos(publicKey),
+			EncodeUint64(balanceNanos), checkpoint.BlockHeight)
+		return group.Ristretto255.HashToElement(encoding, []byte("DESO-ELLIPTIC-SUM:Ristretto255"))
+	}
+	expectedScrubbedDelta := group.Ristretto255.Identity()
+	expectedScrubbedDelta.Add(expectedScrubbedDelta, hashRecord(newPublicKey(2), 30))
+	removedElement := hashRecord(newPublicKey(2), 3)
+	expectedScrubbedDelta.Add(expectedScrubbedDelta, removedElement.Neg(removedElement))
+	require.Len(checkpoint.ScrubbedPrefixDeltas, 1)
+	require.True(expectedScrubbedDelta.IsEqual(checkpoint.ScrubbedPrefixDeltas[prefix[0]]))
+	require.False(checkpoint.ScrubbedPrefixDeltas[prefix[0]].IsEqual(checkpoint.PrefixDeltas[prefix[0]]))
+
+	// A nil boundary is unbounded, so the changes to all keys are reported.
+	checkpoint = <-snap.GetChecksumCheckpoint(nil)
+	require.NotNil(checkpoint)
+	putBalance(newPublicKey(0xff), 60)
+	checkpoint = <-snap.GetChecksumCheckpoint([]byte{})
+	require.NotNil(checkpoint)
+	require.Len(checkpoint.ScrubbedPrefixDeltas, 1)
+	require.True(checkpoint.ScrubbedPrefixDeltas[prefix[0]].IsEqual(checkpoint.PrefixDeltas[prefix[0]]))
+
+	// Scrubbing one record per transaction gives the same result as scrubbing everything at once, and the
+	// per-prefix checksums line up with the previous scrub.
+	scrubber.batchSize = 1
+	event, err := scrubber.Scrub()
+	require.NoError(err)
+	require.Nil(event)
+	putBalance(newPublicKey(0), 50)
+	event, err = scrubber.Scrub()
+	require.NoError(err)
+	require.Nil(event)
+
+	// A write that bypasses the snapshot is still detected when scrubbing in batches.
+	require.NoError(db.Update(func(txn KVTxn) error {
+		return txn.Set(_dbKeyForPublicKeyToDeSoBalanceNanos(newPublicKey(20)), EncodeUint64(300))
+	}))
+	event, err = scrubber.Scrub()
+	require.NoError(err)
+	require.NotNil(event)
+	require.Equal([][]byte{prefix}, event.MismatchedPrefixes)
+}
@@ -0,0 +1,487 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// The state diff compares the state of two nodes at the same snapshot epoch without downloading all of it.
+// We ask both nodes for the checksum of a range of keys, and if the checksums match we know the range is
+// identical. Otherwise, we split the range in half and recurse until the ranges are small enough to fetch
+// the records and compare them one by one. A handful of differing records can be found this way in a
+// logarithmic number of round trips.
+
+const (
+	// StateChecksumMaxRangeEntries is the maximum number of records a node will hash when responding to
+	// a GetStateChecksum message. Larger ranges are truncated and the rest has to be requested separately.
+	StateChecksumMaxRangeEntries = 100000
+	// StateChecksumMaxIncludedEntries is the maximum number of records a node will attach to a StateChecksum
+	// message. Ranges with more records have to be split before the records can be fetched.
+	StateChecksumMaxIncludedEntries = 100
+)
+
+// GetStateChecksum computes the reply to a GetStateChecksum message. The checksum covers the state at the current
+// snapshot epoch, so we merge the main db records with the ancestral records, same as when serving snapshot chunks.
+// Similarly to GetSnapshotChunk, we return a concurrencyFault if the records were modified while we were reading them.
+func (snap *Snapshot) GetStateChecksum(mainDb KVStore, msg *MsgDeSoGetStateChecksum) (
+	_reply *MsgDeSoStateChecksum, _concurrencyFault bool, _err error) {
+
+	reply := &MsgDeSoStateChecksum{
+		SnapshotMetadata: snap.CurrentEpochSnapshotMetadata,
+		StartKey:         msg.StartKey,
+		EndKey:           msg.EndKey,
+	}
+	snapshotHeight := snap.CurrentEpochSnapshotMetadata.SnapshotBlockHeight
+	// If the peer is expecting a different epoch, we just send it our snapshot metadata.
+	if msg.SnapshotHeight != snapshotHeight {
+		return reply, false, nil
+	}
+
+	mainDBSemaphoreBefore, ancestralDBSemaphoreBefore := snap.Status.GetSemaphores()
+	if snap.Status.IsFlushing() {
+		return nil, true, nil
+	}
+
+	checksum := &StateChecksum{}
+	if err := checksum.Initialize(nil, nil); err != nil {
+		return nil, false, errors.Wrapf(err, "Snapshot.GetStateChecksum: Problem initializing checksum")
+	}
+	rangeChecksum := checksum.curve.Identity()
+
+	var rangeKeys [][]byte
+	var rangeEntries []*DBEntry
+	prefix := msg.GetPrefix()
+	startKey := msg.StartKey
+	var lastKey []byte
+	for finished := false; !finished; {
+		chunk, chunkFull, concurrencyFault, err := snap.GetSnapshotChunk(mainDb, prefix, startKey)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "Snapshot.GetStateChecksum: Problem fetching snapshot chunk")
+		}
+		if concurrencyFault {
+			return nil, true, nil
+		}
+
+		for _, entry := range chunk {
+			// Skip the empty entry and the last entry of the previous chunk, which is the first entry of this one.
+			if entry.IsEmpty() || (lastKey != nil && bytes.Compare(entry.Key, lastKey) <= 0) {
+				continue
+			}
+			if len(msg.EndKey) > 0 && bytes.Compare(entry.Key, msg.EndKey) >= 0 {
+				finished = true
+				break
+			}
+			// If we've hashed enough records, truncate the range at the current key.
+			if len(rangeKeys) == StateChecksumMaxRangeEntries {
+				reply.EndKey = entry.Key
+				finished = true
+				break
+			}
+
+			encoding := EncodeKeyAndValueForChecksum(entry.Key, entry.Value, snapshotHeight)
+			rangeChecksum.Add(rangeChecksum, checksum.curve.HashToElement(encoding, checksum.dst))
+			rangeKeys = append(rangeKeys, entry.Key)
+			if msg.IncludeEntries && len(rangeEntries) <= StateChecksumMaxIncludedEntries {
+				rangeEntries = append(rangeEntries, entry)
+			}
+			lastKey = entry.Key
+		}
+		if !chunkFull || lastKey == nil {
+			break
+		}
+		startKey = lastKey
+	}
+
+	// The range could have been spread over several chunks, so we make sure there was no flush in-between them.
+	mainDBSemaphoreAfter, ancestralDBSemaphoreAfter := snap.Status.GetSemaphores()
+	if ancestralDBSemaphoreBefore != ancestralDBSemaphoreAfter ||
+		mainDBSemaphoreBefore != mainDBSemaphoreAfter {
+		return nil, true, nil
+	}
+
+	checksumBytes, err := rangeChecksum.MarshalBinary()
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "Snapshot.GetStateChecksum: Problem encoding checksum")
+	}
+	reply.Checksum = checksumBytes
+	reply.NumEntries = uint64(len(rangeKeys))
+	if len(rangeKeys) > 0 {
+		reply.SplitKey = rangeKeys[len(rangeKeys)/2]
+	}
+	if len(rangeEntries) <= StateChecksumMaxIncludedEntries {
+		reply.Entries = rangeEntries
+	}
+	return reply, false, nil
+}
+
+// StateChecksumFetcher is used by the StateDiffer to request range checksums from a node.
+type StateChecksumFetcher interface {
+	GetStateChecksum(request *MsgDeSoGetStateChecksum) (*MsgDeSoStateChecksum, error)
+}
+
+// StateDiffEntry is a record that differs between the two nodes. FoundA and FoundB tell if the
+// record exists on the respective node.
+type StateDiffEntry struct {
+	Key    []byte
+	ValueA []byte
+	FoundA bool
+	ValueB []byte
+	FoundB bool
+}
+
+// StateDiffer finds the records that differ between two nodes.
+type StateDiffer struct {
+	nodeA StateChecksumFetcher
+	nodeB StateChecksumFetcher
+
+	// SnapshotMetadata is the snapshot epoch at which both nodes are compared. It's set by DiffPrefixes.
+	SnapshotMetadata *SnapshotEpochMetadata
+	// NumRequests counts the GetStateChecksum requests sent to each node.
+	NumRequests uint64
+
+	diffs []*StateDiffEntry
+}
+
+func NewStateDiffer(nodeA StateChecksumFetcher, nodeB StateChecksumFetcher) *StateDiffer {
+	return &StateDiffer{
+		nodeA: nodeA,
+		nodeB: nodeB,
+	}
+}
+
+// DiffPrefixes compares the records under the provided prefixes and returns the ones that differ. Both nodes
+// have to be at the same snapshot epoch.
+func (differ *StateDiffer) DiffPrefixes(prefixes [][]byte) ([]*StateDiffEntry, error) {
+	if len(prefixes) == 0 {
+		return nil, nil
+	}
+
+	// Request a checksum at an epoch height no node can be at, which makes the nodes reply with their metadata.
+	probe := &MsgDeSoGetStateChecksum{
+		SnapshotHeight: math.MaxUint64,
+		StartKey:       prefixes[0],
+	}
+	replyA, replyB, err := differ.fetch(probe)
+	if err != nil {
+		return nil, errors.Wrapf(err, "StateDiffer.DiffPrefixes: Problem fetching snapshot metadata")
+	}
+	metadataA, metadataB := replyA.SnapshotMetadata, replyB.SnapshotMetadata
+	sameBlockHash := metadataA.CurrentEpochBlockHash == nil && metadataB.CurrentEpochBlockHash == nil
+	if metadataA.CurrentEpochBlockHash != nil && metadataB.CurrentEpochBlockHash != nil {
+		sameBlockHash = metadataA.CurrentEpochBlockHash.IsEqual(metadataB.CurrentEpochBlockHash)
+	}
+	if metadataA.SnapshotBlockHeight != metadataB.SnapshotBlockHeight || !sameBlockHash {
+		return nil, fmt.Errorf("StateDiffer.DiffPrefixes: Nodes are at different snapshot epochs, node A is at "+
+			"height (%v) with block hash (%v), node B is at height (%v) with block hash (%v)",
+			metadataA.SnapshotBlockHeight, metadataA.CurrentEpochBlockHash,
+			metadataB.SnapshotBlockHeight, metadataB.CurrentEpochBlockHash)
+	}
+	differ.SnapshotMetadata = metadataA
+
+	differ.diffs = nil
+	for _, prefix := range prefixes {
+		if len(prefix) == 0 {
+			return nil, fmt.Errorf("StateDiffer.DiffPrefixes: Received an empty prefix")
+		}
+		if err := differ.diffRange(prefix, nil); err != nil {
+			return nil, errors.Wrapf(err, "StateDiffer.DiffPrefixes: Problem comparing prefix (%v)", prefix)
+		}
+	}
+	return differ.diffs, nil
+}
+
+// diffRange compares the records in the range [startKey, endKey). An empty endKey means the end of the prefix.
+func (differ *StateDiffer) diffRange(startKey []byte, endKey []byte) error {
+	for {
+		replyA, replyB, err := differ.fetchRange(startKey, endKey, false)
+		if err != nil {
+			return err
+		}
+
+		// If any of the nodes truncated the range, we first compare the part covered by both of them.
+		if !bytes.Equal(replyA.EndKey, replyB.EndKey) {
+			commonEndKey := replyA.EndKey
+			if len(commonEndKey) == 0 || (len(replyB.EndKey) > 0 && bytes.Compare(replyB.EndKey, commonEndKey) < 0) {
+				commonEndKey = replyB.EndKey
+			}
+			if err := differ.diffRange(startKey, commonEndKey); err != nil {
+				return err
+			}
+			startKey = commonEndKey
+			continue
+		}
+
+		if !bytes.Equal(replyA.Checksum, replyB.Checksum) || replyA.NumEntries != replyB.NumEntries {
+			if replyA.NumEntries <= StateChecksumMaxIncludedEntries && replyB.NumEntries <= StateChecksumMaxIncludedEntries {
+				if err := differ.diffEntries(startKey, replyA.EndKey); err != nil {
+					return err
+				}
+			} else {
+				// Split the range at the middle key of the node that has more records.
+				splitKey := replyA.SplitKey
+				if replyB.NumEntries > replyA.NumEntries {
+					splitKey = replyB.SplitKey
+				}
+				if bytes.Compare(splitKey, startKey) <= 0 {
+					return fmt.Errorf("StateDiffer.diffRange: Received an invalid split key (%v) for range "+
+						"starting at (%v)", splitKey, startKey)
+				}
+				if err := differ.diffRange(startKey, splitKey); err != nil {
+					return err
+				}
+				if err := differ.diffRange(splitKey, replyA.EndKey); err != nil {
+					return err
+				}
+			}
+		}
+
+		// We're done if the checksum covered the whole range, otherwise we continue with the rest of it.
+		if bytes.Equal(replyA.EndKey, endKey) {
+			return nil
+		}
+		startKey = replyA.EndKey
+	}
+}
+
+// diffEntries fetches the records in the range [startKey, endKey) from both nodes and records the ones that differ.
+func (differ *StateDiffer) diffEntries(startKey []byte, endKey []byte) error {
+	replyA, replyB, err := differ.fetchRange(startKey, endKey, true)
+	if err != nil {
+		return err
+	}
+	if uint64(len(replyA.Entries)) != replyA.NumEntries || uint64(len(replyB.Entries)) != replyB.NumEntries {
+		return fmt.Errorf("StateDiffer.diffEntries: Nodes didn't include all records in range (%v, %v)",
+			startKey, endKey)
+	}
+
+	// Both lists of entries are sorted, so we merge them to find the differences.
+	entriesA, entriesB := replyA.Entries, replyB.Entries
+	for len(entriesA) > 0 || len(entriesB) > 0 {
+		var cmp int
+		if len(entriesA) == 0 {
+			cmp = 1
+		} else if len(entriesB) == 0 {
+			cmp = -1
+		} else {
+			cmp = bytes.Compare(entriesA[0].Key, entriesB[0].Key)
+		}
+
+		switch {
+		case cmp < 0:
+			differ.diffs = append(differ.diffs, &StateDiffEntry{
+				Key:    entriesA[0].Key,
+				ValueA: entriesA[0].Value,
+				FoundA: true,
+			})
+			entriesA = entriesA[1:]
+		case cmp > 0:
+			differ.diffs = append(differ.diffs, &StateDiffEntry{
+				Key:    entriesB[0].Key,
+				ValueB: entriesB[0].Value,
+				FoundB: true,
+			})
+			entriesB = entriesB[1:]
+		default:
+			if !bytes.Equal(entriesA[0].Value, entriesB[0].Value) {
+				differ.diffs = append(differ.diffs, &StateDiffEntry{
+					Key:    entriesA[0].Key,
+					ValueA: entriesA[0].Value,
+					FoundA: true,
+					ValueB: entriesB[0].Value,
+					FoundB: true,
+				})
+			}
+			entriesA = entriesA[1:]
+			entriesB = entriesB[1:]
+		}
+	}
+	return nil
+}
+
+func (differ *StateDiffer) fetchRange(startKey []byte, endKey []byte, includeEntries bool) (
+	_replyA *MsgDeSoStateChecksum, _replyB *MsgDeSoStateChecksum, _err error) {
+
+	replyA, replyB, err := differ.fetch(&MsgDeSoGetStateChecksum{
+		SnapshotHeight: differ.SnapshotMetadata.SnapshotBlockHeight,
+		StartKey:       startKey,
+		EndKey:         endKey,
+		IncludeEntries: includeEntries,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	// If any of the nodes moved to a new snapshot epoch in the meantime, we can't continue.
+	if len(replyA.Checksum) == 0 || len(replyB.Checksum) == 0 {
+		return nil, nil, fmt.Errorf("StateDiffer.fetchRange: Nodes moved to a new snapshot epoch, node A is at "+
+			"height (%v) and node B is at height (%v)", replyA.SnapshotMetadata.SnapshotBlockHeight,
+			replyB.SnapshotMetadata.SnapshotBlockHeight)
+	}
+	return replyA, replyB, nil
+}
+
+func (differ *StateDiffer) fetch(request *MsgDeSoGetStateChecksum) (
+	_replyA *MsgDeSoStateChecksum, _replyB *MsgDeSoStateChecksum, _err error) {
+
+	differ.NumRequests++
+	replyA, err := differ.nodeA.GetStateChecksum(request)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StateDiffer.fetch: Problem fetching checksum from node A")
+	}
+	replyB, err := differ.nodeB.GetStateChecksum(request)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StateDiffer.fetch: Problem fetching checksum from node B")
+	}
+	return replyA, replyB, nil
+}
+
+// StateDiffPeer is a minimal connection to a node, which only performs the version and transport handshakes
+// and sends GetStateChecksum requests. It's used by the state-diff command, which runs without a Server.
+// Nodes only answer GetStateChecksum requests from peers with a key in their --state-checksum-peer-keys, so
+// the connection is always encrypted with transportKey.
+type StateDiffPeer struct {
+	conn         net.Conn
+	params       *DeSoParams
+	transportKey *PeerTransportKey
+	timeout      time.Duration
+}
+
+// NewStateDiffPeer connects to the node at addr and performs the version and transport handshakes.
+func NewStateDiffPeer(addr string, params *DeSoParams, transportKey *PeerTransportKey, timeout time.Duration) (
+	*StateDiffPeer, error) {
+
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewStateDiffPeer: Problem connecting to (%v)", addr)
+	}
+	peer := &StateDiffPeer{
+		conn:         conn,
+		params:       params,
+		transportKey: transportKey,
+		timeout:      timeout,
+	}
+	if err := peer.negotiateVersion(); err != nil {
+		peer.conn.Close()
+		return nil, errors.Wrapf(err, "NewStateDiffPeer: Problem negotiating version with (%v)", addr)
+	}
+	return peer, nil
+}
+
+func (peer *StateDiffPeer) negotiateVersion() error {
+	verMsg := NewMessage(MsgTypeVersion).(*MsgDeSoVersion)
+	verMsg.Version = peer.params.ProtocolVersion
+	verMsg.Services = SFEncryptedTransport
+	verMsg.TstampSecs = time.Now().Unix()
+	verMsg.Nonce = uint64(RandInt64(math.MaxInt64))
+	verMsg.UserAgent = peer.params.UserAgent
+	verPayload, err := peer.writeMessageWithPayload(verMsg)
+	if err != nil {
+		return err
+	}
+
+	// The node replies with its version, after which we run the transport handshake as the initiator.
+	// Then we reply to the node's version with a verack and wait for the node's verack.
+	msg, theirVerPayload, err := peer.readMessageWithPayload()
+	if err != nil {
+		return err
+	}
+	theirVerMsg, ok := msg.(*MsgDeSoVersion)
+	if !ok {
+		return fmt.Errorf("StateDiffPeer.negotiateVersion: Expected VERSION but received %v", msg.GetMsgType())
+	}
+	if (theirVerMsg.Services & SFEncryptedTransport) == 0 {
+		return fmt.Errorf("StateDiffPeer.negotiateVersion: Node doesn't encrypt peer connections, so it " +
+			"can't be serving state checksums")
+	}
+	transport := &PeerTransport{StaticKey: peer.transportKey}
+	peer.conn.SetDeadline(time.Now().Add(peer.timeout))
+	encryptedConn, _, err := transport.Handshake(peer.conn, true, PeerTransportPrologue(verPayload, theirVerPayload))
+	peer.conn.SetDeadline(time.Time{})
+	if err != nil {
+		return errors.Wrapf(err, "StateDiffPeer.negotiateVersion: Problem running transport handshake")
+	}
+	peer.conn = encryptedConn
+
+	if err := peer.writeMessage(&MsgDeSoVerack{Nonce: theirVerMsg.Nonce}); err != nil {
+		return err
+	}
+	msg, err = peer.readMessage()
+	if err != nil {
+		return err
+	}
+	verackMsg, ok := msg.(*MsgDeSoVerack)
+	if !ok {
+		return fmt.Errorf("StateDiffPeer.negotiateVersion: Expected VERACK but received %v", msg.GetMsgType())
+	}
+	if verackMsg.Nonce != verMsg.Nonce {
+		return fmt.Errorf("StateDiffPeer.negotiateVersion: Received VERACK with nonce (%v) but expected (%v)",
+			verackMsg.Nonce, verMsg.Nonce)
+	}
+	return nil
+}
+
+// GetStateChecksum sends the request and waits for the reply. Other messages sent by the node are ignored,
+// except for pings, which we have to answer to stay connected.
+func (peer *StateDiffPeer) GetStateChecksum(request *MsgDeSoGetStateChecksum) (*MsgDeSoStateChecksum, error) {
+	if err := peer.writeMessage(request); err != nil {
+		return nil, err
+	}
+	for {
+		msg, err := peer.readMessage()
+		if err != nil {
+			return nil, err
+		}
+		switch msg := msg.(type) {
+		case *MsgDeSoStateChecksum:
+			if !bytes.Equal(msg.StartKey, request.StartKey) {
+				glog.V(1).Infof("StateDiffPeer.GetStateChecksum: Ignoring stale reply with start key (%v)", msg.StartKey)
+				continue
+			}
+			return msg, nil
+		case *MsgDeSoPing:
+			if err := peer.writeMessage(&MsgDeSoPong{Nonce: msg.Nonce}); err != nil {
+				return nil, err
+			}
+		default:
+			glog.V(2).Infof("StateDiffPeer.GetStateChecksum: Ignoring message of type %v", msg.GetMsgType())
+		}
+	}
+}
+
+func (peer *StateDiffPeer) Close() {
+	peer.conn.Close()
+}
+
+func (peer *StateDiffPeer) writeMessage(msg DeSoMessage) error {
+	_, err := peer.writeMessageWithPayload(msg)
+	return err
+}
+
+func (peer *StateDiffPeer) writeMessageWithPayload(msg DeSoMessage) ([]byte, error) {
+	peer.conn.SetWriteDeadline(time.Now().Add(peer.timeout))
+	payload, err := WriteMessage(peer.conn, msg, peer.params.NetworkType)
+	if err != nil {
+		return nil, errors.Wrapf(err, "StateDiffPeer.writeMessage: Problem writing message of type %v",
+			msg.GetMsgType())
+	}
+	return payload, nil
+}
+
+func (peer *StateDiffPeer) readMessage() (DeSoMessage, error) {
+	msg, _, err := peer.readMessageWithPayload()
+	return msg, err
+}
+
+func (peer *StateDiffPeer) readMessageWithPayload() (DeSoMessage, []byte, error) {
+	peer.conn.SetReadDeadline(time.Now().Add(peer.timeout))
+	msg, payload, err := ReadMessage(peer.conn, peer.params.NetworkType)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StateDiffPeer.readMessage: Problem reading message")
+	}
+	return msg, payload, nil
+}
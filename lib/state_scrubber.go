@@ -0,0 +1,383 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cloudflare/circl/group"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// -------------------------------------------------------------------------------------
+// StateChecksumCheckpoint
+// -------------------------------------------------------------------------------------
+
+// StateChecksumCheckpoint is the state checksum captured by the snapshot Run loop at a point
+// where all previously enqueued checksum operations have been applied.
+type StateChecksumCheckpoint struct {
+	Checksum group.Element
+	// BlockHeight is the height used to encode records when they were added to the checksum.
+	BlockHeight uint64
+
+	// PrefixDeltas are the per-prefix changes to the checksum since the previous checkpoint.
+	// ScrubbedPrefixDeltas are the part of these changes made to keys below the scrub boundary
+	// the previous checkpoint was requested with. PrefixDeltasValid is false if the deltas can't
+	// be trusted, e.g. on the first checkpoint.
+	PrefixDeltas         map[byte]group.Element
+	ScrubbedPrefixDeltas map[byte]group.Element
+	PrefixDeltasValid    bool
+
+	// PrefixChecksums are the per-prefix checksums seeded by the last scrub at PrefixChecksumsHeight and kept up
+	// to date since. They're nil if no scrub has completed since the checksum was last overwritten.
+	PrefixChecksums       map[byte]group.Element
+	PrefixChecksumsHeight uint64
+}
+
+// -------------------------------------------------------------------------------------
+// StateScrubber
+// -------------------------------------------------------------------------------------
+
+const (
+	// StateScrubberBatchSize is the number of records the scrubber hashes in a single db transaction.
+	// The scrubber throttles itself and opens a new transaction after each batch.
+	StateScrubberBatchSize = 1000
+	// DefaultStateScrubberMaxEntriesPerSecond is the default scrubbing rate.
+	DefaultStateScrubberMaxEntriesPerSecond = 5000
+)
+
+// StateScrubber is a low-priority background job that periodically re-computes the state checksum
+// from scratch by re-hashing every state prefix in the main db, and compares the result with the
+// incremental checksum maintained by the Snapshot. If a code path ever writes state without updating
+// the checksum, the two will diverge, and the scrubber will raise a StateChecksumMismatchEvent.
+//
+// To compare the two checksums, we need them both to reflect the same state. Keeping a single read transaction
+// open for the whole scrub would pin old versions of the db for hours, so the state is hashed in batches of
+// StateScrubberBatchSize records, each in its own transaction. For every batch, we acquire a read lock on the
+// ChainLock, so that no blocks are being connected, and then open a badger read transaction and enqueue a
+// checksum checkpoint operation to the snapshot. The read transaction gives us a consistent view of the db,
+// while the checkpoint gives us the incremental checksum after all prior writes were accounted for. Each
+// checkpoint also carries the changes made since the previous one to the keys we've already hashed, which
+// brings the earlier batches up to date, so that the sum of all batches can be compared with the last checkpoint.
+//
+// After each scrub, the per-prefix checksums it computed are handed over to the StateChecksum, which keeps them
+// up to date as records are written and persists them together with the checksum. Comparing them with the
+// per-prefix checksums of the next scrub, including the first one after a restart, tells us which prefixes
+// have drifted.
+type StateScrubber struct {
+	db           KVStore
+	snapshot     *Snapshot
+	blockchain   *Blockchain
+	eventManager *EventManager
+	params       *DeSoParams
+
+	// interval is the time between consecutive scrubs.
+	interval time.Duration
+	// maxEntriesPerSecond throttles the scrubber so it doesn't hurt block processing.
+	maxEntriesPerSecond uint64
+	// batchSize is the number of records hashed in a single db transaction, StateScrubberBatchSize by default.
+	batchSize int
+
+	exitChannel chan struct{}
+	waitGroup   sync.WaitGroup
+}
+
+func NewStateScrubber(db KVStore, snapshot *Snapshot, blockchain *Blockchain, eventManager *EventManager,
+	params *DeSoParams, interval time.Duration, maxEntriesPerSecond uint64) *StateScrubber {
+
+	if maxEntriesPerSecond == 0 {
+		maxEntriesPerSecond = DefaultStateScrubberMaxEntriesPerSecond
+	}
+	// We want the snapshot to track the per-prefix deltas so that we can attribute mismatches to prefixes.
+	snapshot.Checksum.EnablePrefixDeltas()
+
+	return &StateScrubber{
+		db:                  db,
+		snapshot:            snapshot,
+		blockchain:          blockchain,
+		eventManager:        eventManager,
+		params:              params,
+		interval:            interval,
+		maxEntriesPerSecond: maxEntriesPerSecond,
+		batchSize:           StateScrubberBatchSize,
+		exitChannel:         make(chan struct{}),
+	}
+}
+
+func (scrubber *StateScrubber) Start() {
+	glog.Infof("StateScrubber.Start: Starting the state scrubber with interval (%v)", scrubber.interval)
+
+	scrubber.waitGroup.Add(1)
+	go func() {
+		defer scrubber.waitGroup.Done()
+
+		ticker := time.NewTicker(scrubber.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-scrubber.exitChannel:
+				return
+			case <-ticker.C:
+			}
+
+			// We don't scrub while syncing, because the state is changing too rapidly.
+			if scrubber.blockchain.isSyncing() {
+				glog.V(1).Infof("StateScrubber: Skipping scrub because node is syncing")
+				continue
+			}
+			if _, err := scrubber.Scrub(); err != nil {
+				glog.Errorf("StateScrubber: Problem scrubbing state, error (%v)", err)
+			}
+		}
+	}()
+}
+
+func (scrubber *StateScrubber) Stop() {
+	close(scrubber.exitChannel)
+	scrubber.waitGroup.Wait()
+	glog.Infof("StateScrubber.Stop: Stopped the state scrubber")
+}
+
+// Scrub re-computes the state checksum from the main db and compares it with the incremental checksum.
+// If a mismatch is detected, the returned event is non-nil and is also raised through the EventManager.
+func (scrubber *StateScrubber) Scrub() (*StateChecksumMismatchEvent, error) {
+	startTime := time.Now()
+
+	// Re-hash all state records, prefix by prefix and batch by batch. The prefixes are hashed in ascending
+	// order, so that the keys we've already hashed are exactly the keys below the end of the latest batch.
+	prefixChecksums := make(map[byte]group.Element)
+	var firstCheckpoint, checkpoint *StateChecksumCheckpoint
+	for _, prefix := range scrubber.sortedStatePrefixes() {
+		prefixChecksums[prefix[0]] = group.Ristretto255.Identity()
+		for startKey := prefix; startKey != nil; {
+			batchStartTime := time.Now()
+			endKey, err := scrubber.findBatchEnd(prefix, startKey)
+			if err != nil {
+				return nil, errors.Wrapf(err, "StateScrubber.Scrub: Problem finding batch end for prefix (%v)", prefix)
+			}
+			// The changes to the keys of this batch are tracked from its checkpoint onwards. The last batch of a
+			// prefix ends at the next prefix, or is unbounded if there's no next prefix.
+			scrubBoundary := endKey
+			if scrubBoundary == nil && prefix[0] < math.MaxUint8 {
+				scrubBoundary = []byte{prefix[0] + 1}
+			}
+			txn, nextCheckpoint, err := scrubber.openConsistentView(scrubBoundary)
+			if err != nil {
+				return nil, errors.Wrapf(err, "StateScrubber.Scrub: Problem opening consistent view")
+			}
+
+			// Bring the batches we've hashed so far up to date with this checkpoint. The scrubbed deltas of the
+			// first checkpoint refer to the previous scrub, so they're skipped.
+			if firstCheckpoint == nil {
+				firstCheckpoint = nextCheckpoint
+				glog.V(1).Infof("StateScrubber.Scrub: Scrubbing state from height (%v)", firstCheckpoint.BlockHeight)
+			} else {
+				if !nextCheckpoint.PrefixDeltasValid {
+					txn.Discard()
+					return nil, fmt.Errorf("StateScrubber.Scrub: Checksum was overwritten during the scrub")
+				}
+				if scrubber.encoderMigrationBetween(firstCheckpoint.BlockHeight, nextCheckpoint.BlockHeight) {
+					txn.Discard()
+					return nil, fmt.Errorf("StateScrubber.Scrub: Records were re-encoded during the scrub")
+				}
+				for deltaPrefix, delta := range nextCheckpoint.ScrubbedPrefixDeltas {
+					addToPrefixDelta(prefixChecksums, deltaPrefix, delta)
+				}
+			}
+			checkpoint = nextCheckpoint
+
+			batchChecksum, numEntries, err := scrubber.hashBatch(txn, prefix, startKey, endKey, checkpoint.BlockHeight)
+			txn.Discard()
+			if err != nil {
+				return nil, errors.Wrapf(err, "StateScrubber.Scrub: Problem hashing prefix (%v)", prefix)
+			}
+			prefixChecksums[prefix[0]].Add(prefixChecksums[prefix[0]], batchChecksum)
+			if !scrubber.throttle(numEntries, batchStartTime) {
+				return nil, fmt.Errorf("StateScrubber.Scrub: Scrubber was stopped")
+			}
+			startKey = endKey
+		}
+	}
+	computedChecksum := group.Ristretto255.Identity()
+	for _, prefixChecksum := range prefixChecksums {
+		computedChecksum.Add(computedChecksum, prefixChecksum)
+	}
+
+	// Attribute the drift to individual prefixes. This is only possible if a previous scrub, possibly from before
+	// a restart, seeded the per-prefix checksums, and the records haven't been re-encoded in the meantime.
+	var mismatchedPrefixes [][]byte
+	if scrubber.canComparePrefixes(checkpoint) {
+		for _, prefix := range StatePrefixes.StatePrefixesList {
+			expected := group.Ristretto255.Identity()
+			if prefixChecksum, exists := checkpoint.PrefixChecksums[prefix[0]]; exists {
+				expected.Add(expected, prefixChecksum)
+			}
+			if !expected.IsEqual(prefixChecksums[prefix[0]]) {
+				mismatchedPrefixes = append(mismatchedPrefixes, prefix)
+			}
+		}
+	}
+	// The per-prefix checksums we've computed become the baseline for the next scrub.
+	scrubber.snapshot.Checksum.SetPrefixChecksums(prefixChecksums, checkpoint.BlockHeight)
+
+	glog.V(1).Infof("StateScrubber.Scrub: Finished scrubbing state at height (%v) in (%v)",
+		checkpoint.BlockHeight, time.Since(startTime))
+	if computedChecksum.IsEqual(checkpoint.Checksum) && len(mismatchedPrefixes) == 0 {
+		return nil, nil
+	}
+
+	computedBytes, err := computedChecksum.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrapf(err, "StateScrubber.Scrub: Problem encoding computed checksum")
+	}
+	incrementalBytes, err := checkpoint.Checksum.MarshalBinary()
+	if err != nil {
+		return nil, errors.Wrapf(err, "StateScrubber.Scrub: Problem encoding incremental checksum")
+	}
+	event := &StateChecksumMismatchEvent{
+		BlockHeight:         checkpoint.BlockHeight,
+		ComputedChecksum:    computedBytes,
+		IncrementalChecksum: incrementalBytes,
+		MismatchedPrefixes:  mismatchedPrefixes,
+	}
+	glog.Errorf(CLog(Red, fmt.Sprintf("StateScrubber.Scrub: State checksum mismatch at height (%v), computed "+
+		"checksum (%v), incremental checksum (%v), mismatched prefixes (%v)", event.BlockHeight,
+		hex.EncodeToString(computedBytes), hex.EncodeToString(incrementalBytes), mismatchedPrefixes)))
+	if scrubber.eventManager != nil {
+		scrubber.eventManager.stateChecksumMismatch(event)
+	}
+	return event, nil
+}
+
+// sortedStatePrefixes returns the state prefixes in ascending order.
+func (scrubber *StateScrubber) sortedStatePrefixes() [][]byte {
+	prefixes := append([][]byte{}, StatePrefixes.StatePrefixesList...)
+	sort.Slice(prefixes, func(ii, jj int) bool {
+		return bytes.Compare(prefixes[ii], prefixes[jj]) < 0
+	})
+	return prefixes
+}
+
+// openConsistentView opens a read transaction on the main db together with the checksum checkpoint
+// that reflects exactly the same state. The checkpoint starts tracking the changes to keys below
+// scrubBoundary, which will be reported in the next checkpoint.
+func (scrubber *StateScrubber) openConsistentView(scrubBoundary []byte) (KVTxn, *StateChecksumCheckpoint, error) {
+	// Holding the ChainLock guarantees that no block is being flushed to the db while we open the transaction
+	// and enqueue the checkpoint operation. All checksum operations for writes visible to the transaction will
+	// have been enqueued before the checkpoint.
+	scrubber.blockchain.ChainLock.RLock()
+	txn := scrubber.db.NewTransaction(false)
+	checkpointChannel := scrubber.snapshot.GetChecksumCheckpoint(scrubBoundary)
+	scrubber.blockchain.ChainLock.RUnlock()
+
+	select {
+	case checkpoint := <-checkpointChannel:
+		if checkpoint == nil {
+			txn.Discard()
+			return nil, nil, fmt.Errorf("StateScrubber.openConsistentView: Problem getting checksum checkpoint")
+		}
+		return txn, checkpoint, nil
+	case <-scrubber.exitChannel:
+		txn.Discard()
+		return nil, nil, fmt.Errorf("StateScrubber.openConsistentView: Scrubber was stopped")
+	}
+}
+
+// findBatchEnd returns the first key of the prefix after the batch of StateScrubberBatchSize records starting at
+// startKey, or nil if the batch reaches the end of the prefix. It only reads keys, in a short-lived transaction.
+func (scrubber *StateScrubber) findBatchEnd(prefix []byte, startKey []byte) ([]byte, error) {
+	txn := scrubber.db.NewTransaction(false)
+	defer txn.Discard()
+
+	opts := DefaultKVIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	numKeys := 0
+	for it.Seek(startKey); it.ValidForPrefix(prefix); it.Next() {
+		if numKeys == scrubber.batchSize {
+			return it.Item().KeyCopy(nil), nil
+		}
+		numKeys++
+	}
+	return nil, nil
+}
+
+// hashBatch computes the checksum of the records of the prefix in [startKey, endKey), or from startKey to the end
+// of the prefix if endKey is nil. We hash the records serially on purpose, using all threads like the
+// StateChecksum does would compete with block processing.
+func (scrubber *StateScrubber) hashBatch(txn KVTxn, prefix []byte, startKey []byte, endKey []byte,
+	blockHeight uint64) (_checksum group.Element, _numEntries uint64, _err error) {
+
+	checksum := &StateChecksum{}
+	if err := checksum.Initialize(nil, nil); err != nil {
+		return nil, 0, errors.Wrapf(err, "StateScrubber.hashBatch: Problem initializing checksum")
+	}
+	batchChecksum := group.Ristretto255.Identity()
+	numEntries := uint64(0)
+
+	opts := DefaultKVIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Seek(startKey); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		key := item.Key()
+		if endKey != nil && bytes.Compare(key, endKey) >= 0 {
+			break
+		}
+		err := item.Value(func(value []byte) error {
+			encoding := EncodeKeyAndValueForChecksum(key, value, blockHeight)
+			batchChecksum.Add(batchChecksum, checksum.curve.HashToElement(encoding, checksum.dst))
+			return nil
+		})
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "StateScrubber.hashBatch: Problem reading value for key (%v)", key)
+		}
+		numEntries++
+	}
+	return batchChecksum, numEntries, nil
+}
+
+// throttle sleeps for as long as needed to keep the scrubbing rate under maxEntriesPerSecond.
+// It returns false if the scrubber was stopped in the meantime.
+func (scrubber *StateScrubber) throttle(entries uint64, batchStartTime time.Time) bool {
+	minDuration := time.Duration(entries) * time.Second / time.Duration(scrubber.maxEntriesPerSecond)
+	sleepDuration := minDuration - time.Since(batchStartTime)
+	if sleepDuration <= 0 {
+		sleepDuration = 0
+	}
+	select {
+	case <-scrubber.exitChannel:
+		return false
+	case <-time.After(sleepDuration):
+		return true
+	}
+}
+
+// canComparePrefixes checks if the per-prefix checksums seeded by a previous scrub can be compared
+// with the current scrub, which ended at lastCheckpoint.
+func (scrubber *StateScrubber) canComparePrefixes(lastCheckpoint *StateChecksumCheckpoint) bool {
+	if lastCheckpoint.PrefixChecksums == nil {
+		return false
+	}
+	// If an encoder migration happened in-between the scrubs, records are now encoded differently.
+	return !scrubber.encoderMigrationBetween(lastCheckpoint.PrefixChecksumsHeight, lastCheckpoint.BlockHeight)
+}
+
+// encoderMigrationBetween checks if an encoder migration happened after fromHeight and up to toHeight,
+// in which case records are encoded differently at the two heights.
+func (scrubber *StateScrubber) encoderMigrationBetween(fromHeight uint64, toHeight uint64) bool {
+	for _, migrationHeight := range scrubber.params.EncoderMigrationHeightsList {
+		if migrationHeight.Height > fromHeight && migrationHeight.Height <= toHeight {
+			return true
+		}
+	}
+	return false
+}
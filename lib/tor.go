@@ -0,0 +1,398 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/sha3"
+	"golang.org/x/net/proxy"
+)
+
+// tor.go contains what we need to run a node without revealing its IP. Outbound connections
+// can go through a SOCKS5 proxy, which is usually Tor, and the node can publish a Tor onion
+// service that forwards inbound connections to our protocol port. Onion addresses are
+// gossiped in MsgDeSoAddr alongside IP addresses.
+//
+// The address manager only deals in IPs, so an onion address is stored there under an
+// OnionCat IPv6 address (fd87:d87e:eb43::/48) derived from its public key, and the
+// ConnectionManager keeps the full onion address needed to dial it.
+
+const (
+	// OnionAddrLen is the length of a Tor v3 onion address: a 32-byte ed25519 public key,
+	// a 2-byte checksum and a version byte.
+	OnionAddrLen = 35
+	// OnionAddrVersion is the version byte of a Tor v3 onion address.
+	OnionAddrVersion = 0x03
+
+	onionAddrSuffix = ".onion"
+
+	// TorOnionServiceKeyFilename is the file in the data directory holding the private key of
+	// our onion service, so that our onion address doesn't change when we restart.
+	TorOnionServiceKeyFilename = "tor_onion_service_key"
+)
+
+var (
+	onionAddrEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+	onionCatPrefix    = []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+)
+
+func _onionAddrChecksum(publicKey []byte) []byte {
+	checksumInput := append([]byte(".onion checksum"), publicKey...)
+	checksumInput = append(checksumInput, OnionAddrVersion)
+	checksum := sha3.Sum256(checksumInput)
+	return checksum[:2]
+}
+
+// NewOnionAddr returns the onion address of the onion service with the given ed25519 public key.
+func NewOnionAddr(publicKey []byte) ([]byte, error) {
+	if len(publicKey) != 32 {
+		return nil, fmt.Errorf("NewOnionAddr: Public key must be 32 bytes but was %d", len(publicKey))
+	}
+	onionAddr := append([]byte{}, publicKey...)
+	onionAddr = append(onionAddr, _onionAddrChecksum(publicKey)...)
+	return append(onionAddr, OnionAddrVersion), nil
+}
+
+// ValidateOnionAddr checks the length, version and checksum of a Tor v3 onion address.
+func ValidateOnionAddr(onionAddr []byte) error {
+	if len(onionAddr) != OnionAddrLen {
+		return fmt.Errorf("ValidateOnionAddr: Onion address must be %d bytes but was %d",
+			OnionAddrLen, len(onionAddr))
+	}
+	if onionAddr[OnionAddrLen-1] != OnionAddrVersion {
+		return fmt.Errorf("ValidateOnionAddr: Unsupported onion address version %d",
+			onionAddr[OnionAddrLen-1])
+	}
+	if !bytes.Equal(onionAddr[32:34], _onionAddrChecksum(onionAddr[:32])) {
+		return fmt.Errorf("ValidateOnionAddr: Invalid onion address checksum")
+	}
+	return nil
+}
+
+// OnionAddrToString returns the host name of an onion address, e.g. "<56 characters>.onion".
+func OnionAddrToString(onionAddr []byte) string {
+	return strings.ToLower(onionAddrEncoding.EncodeToString(onionAddr)) + onionAddrSuffix
+}
+
+// IsOnionHost returns true if the host name is an onion address.
+func IsOnionHost(host string) bool {
+	return strings.HasSuffix(strings.ToLower(host), onionAddrSuffix)
+}
+
+// ParseOnionAddr parses the host name of a Tor v3 onion service.
+func ParseOnionAddr(host string) ([]byte, error) {
+	if !IsOnionHost(host) {
+		return nil, fmt.Errorf("ParseOnionAddr: Host %v is not an onion address", host)
+	}
+	encodedAddr := strings.ToUpper(strings.TrimSuffix(strings.ToLower(host), onionAddrSuffix))
+	onionAddr, err := onionAddrEncoding.DecodeString(encodedAddr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ParseOnionAddr: Problem decoding onion address %v", host)
+	}
+	if err := ValidateOnionAddr(onionAddr); err != nil {
+		return nil, errors.Wrapf(err, "ParseOnionAddr: Invalid onion address %v", host)
+	}
+	return onionAddr, nil
+}
+
+// OnionAddrToNetAddr returns the OnionCat address we store an onion address under in the
+// address manager.
+func OnionAddrToNetAddr(onionAddr []byte, port uint16, services ServiceFlag) *wire.NetAddress {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, onionCatPrefix)
+	copy(ip[len(onionCatPrefix):], onionAddr[:net.IPv6len-len(onionCatPrefix)])
+	return wire.NewNetAddressIPPort(ip, port, wire.ServiceFlag(services))
+}
+
+// NewProxyDialer returns a dialer that connects through the SOCKS5 proxy at proxyAddr.
+func NewProxyDialer(proxyAddr string, dialTimeout time.Duration) (proxy.ContextDialer, error) {
+	if _, _, err := net.SplitHostPort(proxyAddr); err != nil {
+		return nil, errors.Wrapf(err, "NewProxyDialer: Proxy address %v should look like <host>:<port>", proxyAddr)
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, &net.Dialer{Timeout: dialTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewProxyDialer: Problem creating SOCKS5 dialer for %v", proxyAddr)
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("NewProxyDialer: SOCKS5 dialer doesn't support timeouts")
+	}
+	return contextDialer, nil
+}
+
+// DialWithProxy dials addr through the proxy, giving up after dialTimeout.
+func DialWithProxy(proxyDialer proxy.ContextDialer, addr string, dialTimeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	return proxyDialer.DialContext(ctx, "tcp", addr)
+}
+
+// The SOCKS5 values needed to resolve a host name through Tor. The RESOLVE command is a Tor
+// extension to SOCKS5, see https://spec.torproject.org/socks-extensions.
+const (
+	socks5Version         = 0x05
+	socks5AuthNone        = 0x00
+	socks5CmdTorResolve   = 0xF0
+	socks5AddrTypeIPv4    = 0x01
+	socks5AddrTypeDomain  = 0x03
+	socks5AddrTypeIPv6    = 0x04
+	socks5ReplySucceeded  = 0x00
+	socks5MaxDomainLength = 255
+)
+
+// NewProxyLookupIP returns a function that resolves host names through the SOCKS5 proxy at
+// proxyAddr using Tor's RESOLVE extension. It has the same signature as net.LookupIP so that
+// it can be used in its place wherever we'd otherwise leak DNS requests outside the proxy.
+func NewProxyLookupIP(proxyAddr string, dialTimeout time.Duration) func(host string) ([]net.IP, error) {
+	return func(host string) ([]net.IP, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IP{ip}, nil
+		}
+		ip, err := ProxyResolve(proxyAddr, host, dialTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return []net.IP{ip}, nil
+	}
+}
+
+// ProxyResolve resolves host through the SOCKS5 proxy at proxyAddr using Tor's RESOLVE extension.
+func ProxyResolve(proxyAddr string, host string, timeout time.Duration) (net.IP, error) {
+	if len(host) == 0 || len(host) > socks5MaxDomainLength {
+		return nil, fmt.Errorf("ProxyResolve: Invalid host name length %d", len(host))
+	}
+	conn, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ProxyResolve: Problem connecting to proxy %v", proxyAddr)
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, errors.Wrapf(err, "ProxyResolve: Problem setting deadline")
+	}
+
+	// Negotiate no authentication.
+	if _, err := conn.Write([]byte{socks5Version, 1, socks5AuthNone}); err != nil {
+		return nil, errors.Wrapf(err, "ProxyResolve: Problem sending greeting")
+	}
+	greetingReply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greetingReply); err != nil {
+		return nil, errors.Wrapf(err, "ProxyResolve: Problem reading greeting reply")
+	}
+	if greetingReply[0] != socks5Version || greetingReply[1] != socks5AuthNone {
+		return nil, fmt.Errorf("ProxyResolve: Proxy %v doesn't accept SOCKS5 without authentication", proxyAddr)
+	}
+
+	// Send the RESOLVE request. The port is ignored by Tor.
+	request := []byte{socks5Version, socks5CmdTorResolve, 0x00, socks5AddrTypeDomain, byte(len(host))}
+	request = append(request, host...)
+	request = append(request, 0x00, 0x00)
+	if _, err := conn.Write(request); err != nil {
+		return nil, errors.Wrapf(err, "ProxyResolve: Problem sending resolve request")
+	}
+
+	replyHeader := make([]byte, 4)
+	if _, err := io.ReadFull(conn, replyHeader); err != nil {
+		return nil, errors.Wrapf(err, "ProxyResolve: Problem reading resolve reply")
+	}
+	if replyHeader[0] != socks5Version {
+		return nil, fmt.Errorf("ProxyResolve: Unexpected SOCKS version %d in reply", replyHeader[0])
+	}
+	if replyHeader[1] != socks5ReplySucceeded {
+		return nil, fmt.Errorf("ProxyResolve: Proxy failed to resolve %v with reply code %d", host, replyHeader[1])
+	}
+	var ipLen int
+	switch replyHeader[3] {
+	case socks5AddrTypeIPv4:
+		ipLen = net.IPv4len
+	case socks5AddrTypeIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, fmt.Errorf("ProxyResolve: Unexpected address type %d in reply", replyHeader[3])
+	}
+	// The address is followed by a port, which we don't need.
+	replyAddr := make([]byte, ipLen+2)
+	if _, err := io.ReadFull(conn, replyAddr); err != nil {
+		return nil, errors.Wrapf(err, "ProxyResolve: Problem reading resolved address")
+	}
+	return net.IP(replyAddr[:ipLen]), nil
+}
+
+// TorController talks to Tor over its control port. The onion services it adds are removed by
+// Tor when the controller is closed.
+type TorController struct {
+	conn *textproto.Conn
+}
+
+// NewTorController connects to the Tor control port at controlAddr and authenticates, with the
+// password if one is given and with Tor's auth cookie otherwise.
+func NewTorController(controlAddr string, password string) (*TorController, error) {
+	netConn, err := net.DialTimeout("tcp", controlAddr, 10*time.Second)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewTorController: Problem connecting to Tor control port %v", controlAddr)
+	}
+	controller := &TorController{conn: textproto.NewConn(netConn)}
+	if err := controller._authenticate(password); err != nil {
+		controller.Close()
+		return nil, errors.Wrapf(err, "NewTorController: Problem authenticating with Tor control port %v", controlAddr)
+	}
+	return controller, nil
+}
+
+// _command sends a command and returns the lines of Tor's reply, without the final "OK".
+func (controller *TorController) _command(format string, args ...interface{}) ([]string, error) {
+	id, err := controller.conn.Cmd(format, args...)
+	if err != nil {
+		return nil, err
+	}
+	controller.conn.StartResponse(id)
+	defer controller.conn.EndResponse(id)
+
+	_, message, err := controller.conn.ReadResponse(250)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(message, "\n")
+	return lines[:len(lines)-1], nil
+}
+
+func (controller *TorController) _authenticate(password string) error {
+	lines, err := controller._command("PROTOCOLINFO 1")
+	if err != nil {
+		return errors.Wrapf(err, "TorController._authenticate: Problem getting protocol info")
+	}
+	var authMethods []string
+	cookieFile := ""
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		for _, field := range strings.Fields(strings.TrimPrefix(line, "AUTH ")) {
+			if strings.HasPrefix(field, "METHODS=") {
+				authMethods = strings.Split(strings.TrimPrefix(field, "METHODS="), ",")
+			}
+		}
+		if cookieFileIndex := strings.Index(line, "COOKIEFILE="); cookieFileIndex >= 0 {
+			cookieFile, err = strconv.Unquote(line[cookieFileIndex+len("COOKIEFILE="):])
+			if err != nil {
+				return errors.Wrapf(err, "TorController._authenticate: Problem parsing cookie file")
+			}
+		}
+	}
+	hasAuthMethod := func(method string) bool {
+		for _, authMethod := range authMethods {
+			if authMethod == method {
+				return true
+			}
+		}
+		return false
+	}
+
+	authArg := ""
+	switch {
+	case hasAuthMethod("NULL"):
+	case password != "" && hasAuthMethod("HASHEDPASSWORD"):
+		authArg = " " + strconv.Quote(password)
+	case cookieFile != "" && hasAuthMethod("COOKIE"):
+		cookie, err := ioutil.ReadFile(cookieFile)
+		if err != nil {
+			return errors.Wrapf(err, "TorController._authenticate: Problem reading cookie file")
+		}
+		authArg = " " + hex.EncodeToString(cookie)
+	default:
+		return fmt.Errorf("TorController._authenticate: No supported auth method in %v, "+
+			"a password may be required", authMethods)
+	}
+	if _, err := controller._command("AUTHENTICATE%s", authArg); err != nil {
+		return errors.Wrapf(err, "TorController._authenticate: Problem authenticating")
+	}
+	return nil
+}
+
+// AddOnion adds an onion service that forwards virtualPort to target. If privateKey is empty a new
+// key is generated. It returns the service's onion address and private key.
+func (controller *TorController) AddOnion(privateKey string, virtualPort uint16, target string) (
+	_onionAddr []byte, _privateKey string, _err error) {
+
+	keyArg := privateKey
+	if keyArg == "" {
+		keyArg = "NEW:ED25519-V3"
+	}
+	lines, err := controller._command("ADD_ONION %s Port=%d,%s", keyArg, virtualPort, target)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "TorController.AddOnion: Problem adding onion service")
+	}
+	serviceID := ""
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ServiceID=") {
+			serviceID = strings.TrimPrefix(line, "ServiceID=")
+		}
+		if strings.HasPrefix(line, "PrivateKey=") {
+			privateKey = strings.TrimPrefix(line, "PrivateKey=")
+		}
+	}
+	onionAddr, err := ParseOnionAddr(serviceID + onionAddrSuffix)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "TorController.AddOnion: Problem parsing service id")
+	}
+	return onionAddr, privateKey, nil
+}
+
+// Close closes the control connection, which removes the onion services we added.
+func (controller *TorController) Close() error {
+	return controller.conn.Close()
+}
+
+// GetTorOnionServiceKeyFilePath returns the path of our onion service key in the data directory.
+func GetTorOnionServiceKeyFilePath(dataDir string) string {
+	return filepath.Join(dataDir, TorOnionServiceKeyFilename)
+}
+
+// StartOnionService publishes an onion service that forwards port to the same port on localhost.
+// The service key is kept in keyFilePath so that our onion address survives restarts. The onion
+// service stays up until the returned controller is closed.
+func StartOnionService(controlAddr string, password string, keyFilePath string, port uint16) (
+	_controller *TorController, _onionAddr []byte, _err error) {
+
+	privateKey := ""
+	keyBytes, err := ioutil.ReadFile(keyFilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, errors.Wrapf(err, "StartOnionService: Problem reading key file %v", keyFilePath)
+	}
+	if err == nil {
+		privateKey = strings.TrimSpace(string(keyBytes))
+	}
+
+	controller, err := NewTorController(controlAddr, password)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "StartOnionService: ")
+	}
+	onionAddr, newPrivateKey, err := controller.AddOnion(privateKey, port,
+		net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	if err != nil {
+		controller.Close()
+		return nil, nil, errors.Wrapf(err, "StartOnionService: ")
+	}
+	if privateKey == "" {
+		if err := ioutil.WriteFile(keyFilePath, []byte(newPrivateKey), 0600); err != nil {
+			controller.Close()
+			return nil, nil, errors.Wrapf(err, "StartOnionService: Problem writing key file %v", keyFilePath)
+		}
+	}
+	glog.Infof("StartOnionService: Published onion service %v:%d", OnionAddrToString(onionAddr), port)
+	return controller, onionAddr, nil
+}
@@ -0,0 +1,301 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOnionAddr(t *testing.T) {
+	require := require.New(t)
+
+	// A real onion address parses and encodes back to itself.
+	host := "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion"
+	onionAddr, err := ParseOnionAddr(strings.ToUpper(host))
+	require.NoError(err)
+	require.Len(onionAddr, OnionAddrLen)
+	require.Equal(host, OnionAddrToString(onionAddr))
+	newOnionAddr, err := NewOnionAddr(onionAddr[:32])
+	require.NoError(err)
+	require.Equal(onionAddr, newOnionAddr)
+
+	// Addresses with a bad checksum or version, or that aren't onion addresses, are rejected.
+	_, err = ParseOnionAddr("euckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion")
+	require.Error(err)
+	badVersion := append([]byte{}, onionAddr...)
+	badVersion[OnionAddrLen-1] = 0x02
+	require.Error(ValidateOnionAddr(badVersion))
+	_, err = ParseOnionAddr("duckduckgo.com")
+	require.Error(err)
+
+	// Onion addresses are stored under an OnionCat address in the addrmgr, and connect-ips can
+	// be onion addresses.
+	na, err := IPToNetAddr(host+":17000", nil, &DeSoTestnetParams)
+	require.NoError(err)
+	require.True(addrmgr.IsOnionCatTor(na))
+	require.Equal(uint16(17000), na.Port)
+	require.Equal(OnionAddrToNetAddr(onionAddr, 17000, 0), na)
+}
+
+func TestMsgDeSoAddrOnionAddrs(t *testing.T) {
+	require := require.New(t)
+
+	onionAddr, err := ParseOnionAddr("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion")
+	require.NoError(err)
+	addrMsg := &MsgDeSoAddr{
+		AddrList: []*SingleAddr{
+			{
+				Timestamp: time.Unix(1000, 0),
+				Services:  SFFullNodeDeprecated,
+				IP:        net.ParseIP("8.8.8.8").To4(),
+				Port:      17000,
+			},
+			{
+				Timestamp: time.Unix(2000, 0),
+				Services:  SFFullNodeDeprecated,
+				OnionAddr: onionAddr,
+				Port:      17000,
+			},
+		},
+	}
+	addrBytes, err := addrMsg.ToBytes(false)
+	require.NoError(err)
+	decodedMsg := &MsgDeSoAddr{}
+	require.NoError(decodedMsg.FromBytes(addrBytes))
+	require.Equal(addrMsg, decodedMsg)
+	require.Equal("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion:17000",
+		decodedMsg.AddrList[1].String())
+
+	// An onion address with a bad checksum fails to decode.
+	badOnionAddr := append([]byte{}, onionAddr...)
+	badOnionAddr[0] ^= 1
+	addrMsg.AddrList[1].OnionAddr = badOnionAddr
+	addrBytes, err = addrMsg.ToBytes(false)
+	require.NoError(err)
+	require.Error(decodedMsg.FromBytes(addrBytes))
+
+	// Onion addresses are filtered out for peers that can't decode them.
+	addrMsg.AddrList[1].OnionAddr = onionAddr
+	pp := &Peer{knownAddressesMap: make(map[string]bool)}
+	require.Len(pp._filterAddrMsg(addrMsg).AddrList, 1)
+	pp = &Peer{knownAddressesMap: make(map[string]bool), serviceFlags: SFOnionAddrs}
+	require.Len(pp._filterAddrMsg(addrMsg).AddrList, 2)
+}
+
+func TestAddOnionAddr(t *testing.T) {
+	require := require.New(t)
+
+	cmgr := &ConnectionManager{onionAddrs: make(map[string]*onionAddrEntry)}
+	onionAddr, err := ParseOnionAddr("duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad.onion")
+	require.NoError(err)
+	na := cmgr.AddOnionAddr(onionAddr, 17000, 0)
+	require.NotNil(na)
+	require.Equal(onionAddr, cmgr.GetOnionAddr(na))
+
+	// A different onion address with the same prefix maps to the same OnionCat address, but it
+	// can't replace the one we already know.
+	publicKey := append([]byte{}, onionAddr[:32]...)
+	publicKey[31] ^= 1
+	spoofedOnionAddr, err := NewOnionAddr(publicKey)
+	require.NoError(err)
+	require.Equal(na, OnionAddrToNetAddr(spoofedOnionAddr, 17000, 0))
+	require.Nil(cmgr.AddOnionAddr(spoofedOnionAddr, 17000, 0))
+	require.Equal(onionAddr, cmgr.GetOnionAddr(na))
+
+	// Adding the same onion address again is fine.
+	require.Equal(na, cmgr.AddOnionAddr(onionAddr, 17000, 0))
+
+	// Once we know MaxOnionAddrs onion addresses, new ones are dropped until old ones expire.
+	for ii := 1; len(cmgr.onionAddrs) < MaxOnionAddrs; ii++ {
+		publicKey := make([]byte, 32)
+		publicKey[0], publicKey[1] = byte(ii), byte(ii>>8)
+		newOnionAddr, err := NewOnionAddr(publicKey)
+		require.NoError(err)
+		require.NotNil(cmgr.AddOnionAddr(newOnionAddr, 17000, 0))
+	}
+	publicKey = make([]byte, 32)
+	publicKey[2] = 1
+	extraOnionAddr, err := NewOnionAddr(publicKey)
+	require.NoError(err)
+	require.Nil(cmgr.AddOnionAddr(extraOnionAddr, 17000, 0))
+	cmgr.onionAddrs[addrmgr.NetAddressKey(na)].lastSeen = time.Now().Add(-OnionAddrExpiry - time.Hour)
+	require.NotNil(cmgr.AddOnionAddr(extraOnionAddr, 17000, 0))
+	require.Nil(cmgr.GetOnionAddr(na))
+	require.Len(cmgr.onionAddrs, MaxOnionAddrs)
+}
+
+// _runFakeTorControlPort accepts a single control connection, requires the password, and
+// answers ADD_ONION with serviceID and a new private key unless one is given.
+func _runFakeTorControlPort(listener net.Listener, password string, serviceID string, commands chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.TrimRight(line, "\r\n")
+		commands <- command
+		switch {
+		case command == "PROTOCOLINFO 1":
+			fmt.Fprintf(conn, "250-PROTOCOLINFO 1\r\n250-AUTH METHODS=HASHEDPASSWORD\r\n"+
+				"250-VERSION Tor=\"0.4.7.10\"\r\n250 OK\r\n")
+		case command == fmt.Sprintf("AUTHENTICATE %q", password):
+			fmt.Fprintf(conn, "250 OK\r\n")
+		case strings.HasPrefix(command, "ADD_ONION NEW:ED25519-V3 "):
+			fmt.Fprintf(conn, "250-ServiceID=%s\r\n250-PrivateKey=ED25519-V3:c2VjcmV0\r\n250 OK\r\n", serviceID)
+		case strings.HasPrefix(command, "ADD_ONION "):
+			fmt.Fprintf(conn, "250-ServiceID=%s\r\n250 OK\r\n", serviceID)
+		default:
+			fmt.Fprintf(conn, "515 Authentication failed\r\n")
+		}
+	}
+}
+
+func TestIsAnonymousConnection(t *testing.T) {
+	require := require.New(t)
+
+	srv := &Server{cmgr: &ConnectionManager{}}
+	clearnetAddr := wire.NewNetAddressIPPort(net.ParseIP("93.184.216.34"), 17000, 0)
+	loopbackAddr := wire.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), 51234, 0)
+
+	// Without a proxy, only the peers that connected through our onion service can't see our IP.
+	require.False(srv._isAnonymousConnection(&Peer{isOutbound: true, netAddr: clearnetAddr}))
+	require.False(srv._isAnonymousConnection(&Peer{isOutbound: false, netAddr: clearnetAddr}))
+	require.True(srv._isAnonymousConnection(&Peer{isOutbound: false, netAddr: loopbackAddr}))
+
+	// With a proxy, the peers we dial can't see our IP either, but clearnet inbound peers still can.
+	srv.cmgr.proxyDialer = &net.Dialer{}
+	require.True(srv._isAnonymousConnection(&Peer{isOutbound: true, netAddr: clearnetAddr}))
+	require.False(srv._isAnonymousConnection(&Peer{isOutbound: false, netAddr: clearnetAddr}))
+}
+
+func TestStartOnionService(t *testing.T) {
+	require := require.New(t)
+
+	serviceID := "duckduckgogg42xjoc72x3sjasowoarfbgcmvfimaftt6twagswzczad"
+	dir, err := ioutil.TempDir("", "tor")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	keyFilePath := filepath.Join(dir, TorOnionServiceKeyFilename)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer listener.Close()
+	commands := make(chan string, 100)
+
+	// The first time, Tor generates a key that we save.
+	go _runFakeTorControlPort(listener, "hunter2", serviceID, commands)
+	controller, onionAddr, err := StartOnionService(listener.Addr().String(), "hunter2", keyFilePath, 17000)
+	require.NoError(err)
+	require.Equal(serviceID+".onion", OnionAddrToString(onionAddr))
+	require.Equal("PROTOCOLINFO 1", <-commands)
+	require.Equal(`AUTHENTICATE "hunter2"`, <-commands)
+	require.Equal("ADD_ONION NEW:ED25519-V3 Port=17000,127.0.0.1:17000", <-commands)
+	keyBytes, err := ioutil.ReadFile(keyFilePath)
+	require.NoError(err)
+	require.Equal("ED25519-V3:c2VjcmV0", string(keyBytes))
+	require.NoError(controller.Close())
+
+	// After a restart the saved key is reused.
+	go _runFakeTorControlPort(listener, "hunter2", serviceID, commands)
+	controller, _, err = StartOnionService(listener.Addr().String(), "hunter2", keyFilePath, 17000)
+	require.NoError(err)
+	<-commands
+	<-commands
+	require.Equal("ADD_ONION ED25519-V3:c2VjcmV0 Port=17000,127.0.0.1:17000", <-commands)
+	require.NoError(controller.Close())
+
+	// A wrong password is an error.
+	go _runFakeTorControlPort(listener, "hunter2", serviceID, commands)
+	_, _, err = StartOnionService(listener.Addr().String(), "wrong", keyFilePath, 17000)
+	require.Error(err)
+}
+
+// _runFakeSocksResolver accepts a single connection on the listener and answers a Tor RESOLVE
+// request with the given IP, sending the host name it was asked to resolve on hosts.
+func _runFakeSocksResolver(listener net.Listener, ip net.IP, hosts chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return
+	}
+	conn.Write([]byte{socks5Version, socks5AuthNone})
+
+	requestHeader := make([]byte, 5)
+	if _, err := io.ReadFull(conn, requestHeader); err != nil || requestHeader[1] != socks5CmdTorResolve {
+		return
+	}
+	hostAndPort := make([]byte, int(requestHeader[4])+2)
+	if _, err := io.ReadFull(conn, hostAndPort); err != nil {
+		return
+	}
+	hosts <- string(hostAndPort[:len(hostAndPort)-2])
+
+	if ip == nil {
+		// General SOCKS server failure.
+		conn.Write([]byte{socks5Version, 0x01, 0x00, socks5AddrTypeIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	reply := []byte{socks5Version, socks5ReplySucceeded, 0x00, socks5AddrTypeIPv4}
+	reply = append(reply, ip.To4()...)
+	conn.Write(append(reply, 0x00, 0x00))
+}
+
+func TestNewProxyLookupIP(t *testing.T) {
+	require := require.New(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(err)
+	defer listener.Close()
+	hosts := make(chan string, 1)
+	lookupIP := NewProxyLookupIP(listener.Addr().String(), 5*time.Second)
+
+	// Host names are resolved by the proxy.
+	go _runFakeSocksResolver(listener, net.ParseIP("1.2.3.4"), hosts)
+	ips, err := lookupIP("seed.deso.org")
+	require.NoError(err)
+	require.Equal("seed.deso.org", <-hosts)
+	require.Len(ips, 1)
+	require.True(net.ParseIP("1.2.3.4").Equal(ips[0]))
+
+	// A failure to resolve is an error.
+	go _runFakeSocksResolver(listener, nil, hosts)
+	_, err = lookupIP("unknown.deso.org")
+	require.Error(err)
+	require.Equal("unknown.deso.org", <-hosts)
+
+	// IPs are returned without asking the proxy.
+	ips, err = lookupIP("5.6.7.8")
+	require.NoError(err)
+	require.True(net.ParseIP("5.6.7.8").Equal(ips[0]))
+	require.Len(hosts, 0)
+
+	// The address manager resolves host names with the lookup function it's given.
+	addrMgr := addrmgr.New("", lookupIP)
+	go _runFakeSocksResolver(listener, net.ParseIP("1.2.3.4"), hosts)
+	netAddr, err := IPToNetAddr("peer.deso.org:17000", addrMgr, &DeSoMainnetParams)
+	require.NoError(err)
+	require.Equal("peer.deso.org", <-hosts)
+	require.True(net.ParseIP("1.2.3.4").Equal(netAddr.IP))
+	require.Equal(uint16(17000), netAddr.Port)
+}
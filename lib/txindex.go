@@ -3,7 +3,6 @@ package lib
 import (
 	"encoding/hex"
 	"fmt"
-	"github.com/dgraph-io/badger/v3"
 	"path/filepath"
 	"reflect"
 	"sync"
@@ -44,7 +43,7 @@ func NewTXIndex(coreChain *Blockchain, params *DeSoParams, dataDirectory string)
 	txIndexOpts.ValueDir = GetBadgerDbPath(txIndexDir)
 	glog.Infof("TxIndex BadgerDB Dir: %v", txIndexOpts.Dir)
 	glog.Infof("TxIndex BadgerDB ValueDir: %v", txIndexOpts.ValueDir)
-	txIndexDb, err := badger.Open(txIndexOpts)
+	txIndexDb, err := OpenBadgerKVStore(txIndexOpts)
 	if err != nil {
 		glog.Fatal(err)
 	}
@@ -291,7 +290,7 @@ func (txi *TXIndex) Update() error {
 				"with hash %v: %v", blockToDetach.Hash, err)
 		}
 		blockHeight := uint64(txi.CoreChain.blockTip().Height)
-		err = txi.TXIndexChain.DB().Update(func(dbTxn *badger.Txn) error {
+		err = txi.TXIndexChain.DB().Update(func(dbTxn KVTxn) error {
 			for _, txn := range blockMsg.Txns {
 				if err := DbDeleteTxindexTransactionMappingsWithTxn(dbTxn, nil,
 					blockHeight, txn, txi.Params); err != nil {
@@ -339,7 +338,7 @@ func (txi *TXIndex) Update() error {
 			return fmt.Errorf("Update: Error putting best hash for block "+
 				"%v: %v", blockToDetach, err)
 		}
-		err = txi.TXIndexChain.DB().Update(func(txn *badger.Txn) error {
+		err = txi.TXIndexChain.DB().Update(func(txn KVTxn) error {
 			if err := DeleteUtxoOperationsForBlockWithTxn(txn, nil, blockToDetach.Hash); err != nil {
 				return fmt.Errorf("Update: Error deleting UtxoOperations 1 for block %v, %v", blockToDetach.Hash, err)
 			}
@@ -396,7 +395,7 @@ func (txi *TXIndex) Update() error {
 		// Do each block update in a single transaction so we're safe in case the node
 		// restarts.
 		blockHeight := uint64(txi.CoreChain.BlockTip().Height)
-		err = txi.TXIndexChain.DB().Update(func(dbTxn *badger.Txn) error {
+		err = txi.TXIndexChain.DB().Update(func(dbTxn KVTxn) error {
 
 			// Iterate through each transaction in the block and do the following:
 			// - Connect it to the view
@@ -0,0 +1,245 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	merkletree "github.com/deso-protocol/go-merkle-tree"
+	"github.com/pkg/errors"
+)
+
+// txn_proof.go builds and verifies proofs that a transaction is included in a block. A block commits to
+// its transactions through the TransactionMerkleRoot of its header, computed by ComputeMerkleRoot. A proof
+// is the branch of the Merkle tree from the transaction hash up to the root, so it can be checked against
+// the header alone, without the rest of the block. The exchange with peers is:
+// - A node asks a peer that sets SFTxnProofs for a proof with a MsgDeSoGetTxnProof.
+// - The peer replies with a MsgDeSoTxnProof, which carries the header and block producer info of the block
+//   along with the proof, or nothing if it doesn't have the block or the transaction isn't in it.
+//
+// A MsgDeSoTxnProof is a self-contained receipt: anyone who knows the block is in the best chain can check
+// it with Verify.
+
+// MaxTxnMerkleProofDepth is the longest Merkle branch we accept, which is enough for any block that fits
+// in a message.
+const MaxTxnMerkleProofDepth = 64
+
+// TxnMerkleProof is the Merkle branch of a transaction in a block.
+type TxnMerkleProof struct {
+	// TxnIndex is the position of the transaction in the block and NumTxns is the number of transactions
+	// in the block. Together, they give the shape of the tree and on which side of each hash of the
+	// branch our node is.
+	//
+	// Neither is committed to by the header: the Merkle root only commits to the order of the hashes, and
+	// ComputeRoot can only rule out the shapes that don't fit the branch. A verified proof shows that the
+	// transaction is in the block, but TxnIndex is only as trustworthy as the peer that sent it.
+	TxnIndex uint64
+	NumTxns  uint64
+	// Branch holds the sibling of our node at each level of the tree, from the transaction hash up to
+	// the children of the root. Where a row has an odd number of nodes, the last node is paired with
+	// itself.
+	Branch []*BlockHash
+}
+
+// _txnMerkleTreeDepth returns the number of levels above the transaction hashes in the tree that
+// ComputeMerkleRoot builds for numTxns transactions. A single transaction is paired with itself.
+func _txnMerkleTreeDepth(numTxns uint64) int {
+	depth := 1
+	for rowLen := numTxns; rowLen > 2; rowLen = (rowLen + 1) / 2 {
+		depth++
+	}
+	return depth
+}
+
+// BuildTxnMerkleProof returns the Merkle branch of a transaction in a block.
+func BuildTxnMerkleProof(blk *MsgDeSoBlock, txnHash *BlockHash) (*TxnMerkleProof, error) {
+	if len(blk.Txns) == 0 {
+		return nil, fmt.Errorf("BuildTxnMerkleProof: Block must contain at least one txn")
+	}
+
+	// Build the tree the same way ComputeMerkleRoot does.
+	hashes := [][]byte{}
+	txnIndex := -1
+	for ii, txn := range blk.Txns {
+		currentHash := txn.Hash()
+		if *currentHash == *txnHash && txnIndex == -1 {
+			txnIndex = ii
+		}
+		hashes = append(hashes, currentHash[:])
+	}
+	if txnIndex == -1 {
+		return nil, fmt.Errorf("BuildTxnMerkleProof: Txn %v is not in the block", txnHash)
+	}
+	merkleTree := merkletree.NewTreeFromHashes(merkletree.Sha256DoubleHash, hashes)
+
+	proof := &TxnMerkleProof{
+		TxnIndex: uint64(txnIndex),
+		NumTxns:  uint64(len(blk.Txns)),
+	}
+	index := txnIndex
+	for _, row := range merkleTree.Rows[:len(merkleTree.Rows)-1] {
+		siblingIndex := index ^ 1
+		if siblingIndex >= len(row) {
+			siblingIndex = index
+		}
+		proof.Branch = append(proof.Branch, NewBlockHash(row[siblingIndex].GetHash()))
+		index /= 2
+	}
+	return proof, nil
+}
+
+// ComputeRoot returns the Merkle root the branch leads to from the transaction hash. It fails if the
+// branch doesn't have the shape of the tree of a block with NumTxns transactions.
+func (proof *TxnMerkleProof) ComputeRoot(txnHash *BlockHash) (*BlockHash, error) {
+	if proof.NumTxns == 0 || proof.TxnIndex >= proof.NumTxns {
+		return nil, fmt.Errorf("TxnMerkleProof.ComputeRoot: Txn index %d is out of range for %d txns",
+			proof.TxnIndex, proof.NumTxns)
+	}
+	if len(proof.Branch) != _txnMerkleTreeDepth(proof.NumTxns) {
+		return nil, fmt.Errorf("TxnMerkleProof.ComputeRoot: Branch has %d hashes, but the tree of %d txns "+
+			"has %d levels", len(proof.Branch), proof.NumTxns, _txnMerkleTreeDepth(proof.NumTxns))
+	}
+
+	currentHash := *txnHash
+	index := proof.TxnIndex
+	rowLen := proof.NumTxns
+	for level, siblingHash := range proof.Branch {
+		if siblingHash == nil {
+			return nil, fmt.Errorf("TxnMerkleProof.ComputeRoot: Hash at level %d is nil", level)
+		}
+		var pairBytes []byte
+		if index%2 == 1 {
+			// A node on the right always has a distinct left sibling. Allowing them to be equal would let
+			// a prover claim a padded last node of a row, and so a larger NumTxns and TxnIndex, is real.
+			if *siblingHash == currentHash {
+				return nil, fmt.Errorf("TxnMerkleProof.ComputeRoot: Hash at level %d is paired with "+
+					"itself on the right", level)
+			}
+			pairBytes = append(append(pairBytes, siblingHash[:]...), currentHash[:]...)
+		} else {
+			// The last node of a row with an odd number of nodes is paired with itself.
+			if index == rowLen-1 && *siblingHash != currentHash {
+				return nil, fmt.Errorf("TxnMerkleProof.ComputeRoot: Hash at level %d should be paired "+
+					"with itself", level)
+			}
+			pairBytes = append(append(pairBytes, currentHash[:]...), siblingHash[:]...)
+		}
+		copy(currentHash[:], merkletree.Sha256DoubleHash(pairBytes))
+		index /= 2
+		rowLen = (rowLen + 1) / 2
+	}
+	return &currentHash, nil
+}
+
+// VerifyTxnMerkleProof checks that the proof shows that the transaction is included in the block with
+// the given header.
+func VerifyTxnMerkleProof(header *MsgDeSoHeader, txnHash *BlockHash, proof *TxnMerkleProof) error {
+	if header == nil || header.TransactionMerkleRoot == nil || txnHash == nil || proof == nil {
+		return fmt.Errorf("VerifyTxnMerkleProof: Header, txn hash, and proof should not be nil")
+	}
+	merkleRoot, err := proof.ComputeRoot(txnHash)
+	if err != nil {
+		return errors.Wrapf(err, "VerifyTxnMerkleProof: ")
+	}
+	if *merkleRoot != *header.TransactionMerkleRoot {
+		return errors.Wrapf(RuleErrorInvalidTxnMerkleRoot, "VerifyTxnMerkleProof: Proof of txn %v leads "+
+			"to Merkle root %v, but the header commits to %v", txnHash, merkleRoot, header.TransactionMerkleRoot)
+	}
+	return nil
+}
+
+func (proof *TxnMerkleProof) ToBytes() []byte {
+	data := []byte{}
+	data = append(data, UintToBuf(proof.TxnIndex)...)
+	data = append(data, UintToBuf(proof.NumTxns)...)
+	data = append(data, UintToBuf(uint64(len(proof.Branch)))...)
+	for _, siblingHash := range proof.Branch {
+		data = append(data, siblingHash[:]...)
+	}
+	return data
+}
+
+func (proof *TxnMerkleProof) FromBytes(data []byte) error {
+	ret := &TxnMerkleProof{}
+	rr := bytes.NewReader(data)
+
+	var err error
+	if ret.TxnIndex, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "TxnMerkleProof.FromBytes: Problem reading txn index")
+	}
+	if ret.NumTxns, err = ReadUvarint(rr); err != nil {
+		return errors.Wrapf(err, "TxnMerkleProof.FromBytes: Problem reading number of txns")
+	}
+	branchLen, err := ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "TxnMerkleProof.FromBytes: Problem reading branch length")
+	}
+	if branchLen > MaxTxnMerkleProofDepth {
+		return fmt.Errorf("TxnMerkleProof.FromBytes: Branch has %d hashes, which is more than %d",
+			branchLen, MaxTxnMerkleProofDepth)
+	}
+	for ii := uint64(0); ii < branchLen; ii++ {
+		siblingHashBytes := make([]byte, HashSizeBytes)
+		if _, err = io.ReadFull(rr, siblingHashBytes); err != nil {
+			return errors.Wrapf(err, "TxnMerkleProof.FromBytes: Problem reading hash")
+		}
+		ret.Branch = append(ret.Branch, NewBlockHash(siblingHashBytes))
+	}
+
+	*proof = *ret
+	return nil
+}
+
+// GetTxnMerkleProof returns a proof that a transaction is in a block we store, along with the block so
+// that its header and block producer info can be sent with the proof.
+func (bc *Blockchain) GetTxnMerkleProof(blockHash *BlockHash, txnHash *BlockHash) (
+	*MsgDeSoBlock, *TxnMerkleProof, error) {
+
+	blk := bc.GetBlock(blockHash)
+	if blk == nil {
+		return nil, nil, fmt.Errorf("GetTxnMerkleProof: Block %v not found", blockHash)
+	}
+	proof, err := BuildTxnMerkleProof(blk, txnHash)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "GetTxnMerkleProof: Problem building proof for block %v", blockHash)
+	}
+	return blk, proof, nil
+}
+
+// NewTxnProofMessage returns the reply to a MsgDeSoGetTxnProof. It carries no proof if we don't have the
+// block or the transaction isn't in it.
+func (bc *Blockchain) NewTxnProofMessage(blockHash *BlockHash, txnHash *BlockHash) *MsgDeSoTxnProof {
+	msg := &MsgDeSoTxnProof{
+		BlockHash: blockHash,
+		TxnHash:   txnHash,
+	}
+	blk, proof, err := bc.GetTxnMerkleProof(blockHash, txnHash)
+	if err != nil {
+		return msg
+	}
+	msg.Header = blk.Header
+	msg.BlockProducerInfo = blk.BlockProducerInfo
+	msg.Proof = proof
+	return msg
+}
+
+// Verify checks that the message carries the header of BlockHash and a valid proof that TxnHash is in
+// the block. It doesn't check that the block is in the best chain or who produced it.
+func (msg *MsgDeSoTxnProof) Verify() error {
+	if msg.Header == nil || msg.Proof == nil {
+		return fmt.Errorf("MsgDeSoTxnProof.Verify: Peer didn't prove txn %v is in block %v", msg.TxnHash,
+			msg.BlockHash)
+	}
+	headerHash, err := msg.Header.Hash()
+	if err != nil {
+		return errors.Wrapf(err, "MsgDeSoTxnProof.Verify: Problem computing header hash")
+	}
+	if *headerHash != *msg.BlockHash {
+		return fmt.Errorf("MsgDeSoTxnProof.Verify: Header has hash %v, but the proof is for block %v",
+			headerHash, msg.BlockHash)
+	}
+	if err = VerifyTxnMerkleProof(msg.Header, msg.TxnHash, msg.Proof); err != nil {
+		return errors.Wrapf(err, "MsgDeSoTxnProof.Verify: ")
+	}
+	return nil
+}
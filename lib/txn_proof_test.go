@@ -0,0 +1,126 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTxnMerkleProof(t *testing.T) {
+	require := require.New(t)
+
+	// Proofs are checked against the Merkle root ComputeMerkleRoot computes, for trees with and without
+	// rows of odd length.
+	for numTxns := 1; numTxns <= 17; numTxns++ {
+		blk := _newCompactBlockTestBlock(t, numTxns-1)
+		for txnIndex, txn := range blk.Txns {
+			proof, err := BuildTxnMerkleProof(blk, txn.Hash())
+			require.NoError(err)
+			require.Equal(uint64(txnIndex), proof.TxnIndex)
+			require.Equal(uint64(numTxns), proof.NumTxns)
+			require.NoError(VerifyTxnMerkleProof(blk.Header, txn.Hash(), proof))
+
+			// The proof survives the wire.
+			decodedProof := &TxnMerkleProof{}
+			require.NoError(decodedProof.FromBytes(proof.ToBytes()))
+			require.Equal(proof, decodedProof)
+		}
+	}
+
+	blk := _newCompactBlockTestBlock(t, 4)
+	txnHash := blk.Txns[2].Hash()
+	proof, err := BuildTxnMerkleProof(blk, txnHash)
+	require.NoError(err)
+
+	// A proof doesn't hold for another txn, another block, or another position.
+	require.Equal(RuleErrorInvalidTxnMerkleRoot,
+		errors.Cause(VerifyTxnMerkleProof(blk.Header, blk.Txns[1].Hash(), proof)))
+	otherBlock := _newCompactBlockTestBlock(t, 5)
+	require.Error(VerifyTxnMerkleProof(otherBlock.Header, txnHash, proof))
+	wrongIndexProof := *proof
+	wrongIndexProof.TxnIndex = 3
+	require.Error(VerifyTxnMerkleProof(blk.Header, txnHash, &wrongIndexProof))
+
+	// Nor does a branch that doesn't have the shape of the tree.
+	shortProof := *proof
+	shortProof.Branch = proof.Branch[1:]
+	require.Error(VerifyTxnMerkleProof(blk.Header, txnHash, &shortProof))
+	wrongCountProof := *proof
+	wrongCountProof.NumTxns = 9
+	require.Error(VerifyTxnMerkleProof(blk.Header, txnHash, &wrongCountProof))
+
+	// The last txn of a row of odd length is paired with itself, and only with itself.
+	lastTxnHash := blk.Txns[4].Hash()
+	lastProof, err := BuildTxnMerkleProof(blk, lastTxnHash)
+	require.NoError(err)
+	require.Equal(*lastTxnHash, *lastProof.Branch[0])
+	lastProof.Branch[0] = txnHash
+	require.Error(VerifyTxnMerkleProof(blk.Header, lastTxnHash, lastProof))
+
+	// A prover can't claim that the padding of a row of odd length is a real txn, which would move the
+	// txn to another position in a larger block. For a block [a, b, c], the branch of c also leads to the
+	// root from position 3 of 4 if c is allowed to be paired with itself on the right.
+	oddBlock := _newCompactBlockTestBlock(t, 2)
+	oddTxnHash := oddBlock.Txns[2].Hash()
+	oddProof, err := BuildTxnMerkleProof(oddBlock, oddTxnHash)
+	require.NoError(err)
+	require.NoError(VerifyTxnMerkleProof(oddBlock.Header, oddTxnHash, oddProof))
+	paddedProof := *oddProof
+	paddedProof.TxnIndex = 3
+	paddedProof.NumTxns = 4
+	require.Error(VerifyTxnMerkleProof(oddBlock.Header, oddTxnHash, &paddedProof))
+
+	_, err = BuildTxnMerkleProof(blk, otherBlock.Txns[5].Hash())
+	require.Error(err)
+}
+
+func TestTxnProofMessages(t *testing.T) {
+	require := require.New(t)
+
+	blk := _newCompactBlockTestBlock(t, 6)
+	blk.BlockProducerInfo = &BlockProducerInfo{PublicKey: m0PkBytes}
+	blockHash, err := blk.Hash()
+	require.NoError(err)
+	txnHash := blk.Txns[3].Hash()
+
+	getTxnProof := &MsgDeSoGetTxnProof{BlockHash: blockHash, TxnHash: txnHash}
+	getTxnProofBytes, err := getTxnProof.ToBytes(false)
+	require.NoError(err)
+	require.LessOrEqual(uint64(len(getTxnProofBytes)), MaxPayloadSizeForMsgType(MsgTypeGetTxnProof))
+	decodedGetTxnProof := &MsgDeSoGetTxnProof{}
+	require.NoError(decodedGetTxnProof.FromBytes(getTxnProofBytes))
+	require.Equal(getTxnProof, decodedGetTxnProof)
+
+	// A proof carries everything needed to check it, and survives the wire.
+	proof, err := BuildTxnMerkleProof(blk, txnHash)
+	require.NoError(err)
+	txnProof := &MsgDeSoTxnProof{
+		BlockHash:         blockHash,
+		TxnHash:           txnHash,
+		Header:            blk.Header,
+		BlockProducerInfo: blk.BlockProducerInfo,
+		Proof:             proof,
+	}
+	txnProofBytes, err := txnProof.ToBytes(false)
+	require.NoError(err)
+	decodedTxnProof := &MsgDeSoTxnProof{}
+	require.NoError(decodedTxnProof.FromBytes(txnProofBytes))
+	require.Equal(txnProof.Proof, decodedTxnProof.Proof)
+	require.Equal(txnProof.BlockProducerInfo.PublicKey, decodedTxnProof.BlockProducerInfo.PublicKey)
+	require.NoError(decodedTxnProof.Verify())
+
+	// The proof has to come with the header of its block.
+	decodedTxnProof.Header = _newCompactBlockTestBlock(t, 6).Header
+	decodedTxnProof.Header.Nonce++
+	require.Error(decodedTxnProof.Verify())
+
+	// A reply without a proof doesn't verify.
+	noProof := &MsgDeSoTxnProof{BlockHash: blockHash, TxnHash: txnHash}
+	noProofBytes, err := noProof.ToBytes(false)
+	require.NoError(err)
+	decodedNoProof := &MsgDeSoTxnProof{}
+	require.NoError(decodedNoProof.FromBytes(noProofBytes))
+	require.Equal(noProof, decodedNoProof)
+	require.Error(decodedNoProof.Verify())
+}
@@ -0,0 +1,59 @@
+package migrate
+
+import (
+	"github.com/go-pg/pg/v10/orm"
+	migrations "github.com/robinjoseph08/go-pg-migrations/v3"
+)
+
+func init() {
+	up := func(db orm.DB) error {
+		_, err := db.Exec(`
+			ALTER TABLE pg_messages
+				ADD COLUMN version                           SMALLINT,
+				ADD COLUMN sender_messaging_public_key       BYTEA,
+				ADD COLUMN sender_messaging_group_key_name   BYTEA,
+				ADD COLUMN recipient_messaging_public_key    BYTEA,
+				ADD COLUMN recipient_messaging_group_key_name BYTEA;
+		`)
+		if err != nil {
+			return err
+		}
+
+		// Messages written before this migration are left without messaging keys. V3 messages may have been
+		// sent between messaging keys, and their ExtraData is only stored after ExtraDataOnEntriesBlockHeight,
+		// so there is nothing reliable to backfill them from. Reads match these messages by their owner keys,
+		// which is what V1 and V2 messages use, so indexes on the owner keys are added for them too.
+		_, err = db.Exec(`
+			CREATE INDEX pg_messages_sender_messaging_public_key_timestamp_nanos_idx
+				ON pg_messages(sender_messaging_public_key, timestamp_nanos);
+			CREATE INDEX pg_messages_recipient_messaging_public_key_timestamp_nanos_idx
+				ON pg_messages(recipient_messaging_public_key, timestamp_nanos);
+			CREATE INDEX pg_messages_sender_public_key_timestamp_nanos_idx
+				ON pg_messages(sender_public_key, timestamp_nanos) WHERE sender_messaging_public_key IS NULL;
+			CREATE INDEX pg_messages_recipient_public_key_timestamp_nanos_idx
+				ON pg_messages(recipient_public_key, timestamp_nanos) WHERE recipient_messaging_public_key IS NULL;
+		`)
+		return err
+	}
+
+	down := func(db orm.DB) error {
+		_, err := db.Exec(`
+			DROP INDEX pg_messages_sender_messaging_public_key_timestamp_nanos_idx;
+			DROP INDEX pg_messages_recipient_messaging_public_key_timestamp_nanos_idx;
+			DROP INDEX pg_messages_sender_public_key_timestamp_nanos_idx;
+			DROP INDEX pg_messages_recipient_public_key_timestamp_nanos_idx;
+
+			ALTER TABLE pg_messages
+				DROP COLUMN version,
+				DROP COLUMN sender_messaging_public_key,
+				DROP COLUMN sender_messaging_group_key_name,
+				DROP COLUMN recipient_messaging_public_key,
+				DROP COLUMN recipient_messaging_group_key_name;
+		`)
+		return err
+	}
+
+	opts := migrations.MigrationOptions{}
+
+	migrations.Register("20220321120000_add_message_messaging_keys", up, down, opts)
+}